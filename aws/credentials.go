@@ -0,0 +1,280 @@
+package aws
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNoCredentials is returned when no source of credentials is configured.
+var ErrNoCredentials = errors.New("no AWS credentials found in the environment, a web identity token, the container credentials endpoint or the instance metadata")
+
+// CredentialsSource returns the credentials requests are signed with.
+type CredentialsSource interface {
+	Retrieve(ctx context.Context) (*Credentials, error)
+}
+
+// Retrieve returns the credentials themselves, so that fixed credentials
+// can be used as a CredentialsSource.
+func (c *Credentials) Retrieve(context.Context) (*Credentials, error) {
+	return c, nil
+}
+
+// defaultIMDSEndpoint is the instance metadata service of EC2.
+const defaultIMDSEndpoint = "http://169.254.169.254"
+
+// defaultContainerHost serves AWS_CONTAINER_CREDENTIALS_RELATIVE_URI on ECS.
+const defaultContainerHost = "http://169.254.170.2"
+
+// metadataTimeout bounds the requests to the instance metadata service, which
+// does not answer outside of EC2.
+const metadataTimeout = 2 * time.Second
+
+// refreshWindow is how long before they expire temporary credentials are
+// refreshed.
+const refreshWindow = 5 * time.Minute
+
+// CredentialChain resolves credentials in the order of the AWS SDKs: the
+// standard environment variables, a web identity token exchanged with STS
+// (IAM roles for Kubernetes service accounts), the container credentials
+// endpoint of ECS and EKS Pod Identity, then the instance metadata service of
+// EC2, unless AWS_EC2_METADATA_DISABLED is true. Temporary credentials are
+// cached until shortly before they expire.
+type CredentialChain struct {
+	Client *http.Client
+	Now    func() time.Time
+
+	mu     sync.Mutex
+	cached *Credentials
+}
+
+// NewCredentialChain returns a chain resolving the credentials of the
+// environment.
+func NewCredentialChain() *CredentialChain {
+	return &CredentialChain{Client: &http.Client{Timeout: 30 * time.Second}, Now: time.Now}
+}
+
+// Retrieve returns the credentials of the first source configured, an error
+// wrapping ErrNoCredentials if there is none.
+func (c *CredentialChain) Retrieve(ctx context.Context) (*Credentials, error) {
+	if credentials := CredentialsFromEnv(); credentials != nil {
+		return credentials, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cached != nil && c.Now().Add(refreshWindow).Before(c.cached.Expiration) {
+		return c.cached, nil
+	}
+	credentials, err := c.resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.cached = credentials
+	return credentials, nil
+}
+
+func (c *CredentialChain) resolve(ctx context.Context) (*Credentials, error) {
+	if tokenFile := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"); tokenFile != "" {
+		credentials, err := c.webIdentity(ctx, tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("assuming a role with the web identity token %s: %w", tokenFile, err)
+		}
+		return credentials, nil
+	}
+	if uri := containerCredentialsURI(); uri != "" {
+		credentials, err := c.container(ctx, uri)
+		if err != nil {
+			return nil, fmt.Errorf("reading the container credentials: %w", err)
+		}
+		return credentials, nil
+	}
+	if strings.EqualFold(os.Getenv("AWS_EC2_METADATA_DISABLED"), "true") {
+		return nil, ErrNoCredentials
+	}
+	credentials, err := c.instance(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%w: instance metadata: %v", ErrNoCredentials, err)
+	}
+	return credentials, nil
+}
+
+// webIdentity exchanges the token of tokenFile for the credentials of
+// AWS_ROLE_ARN with AssumeRoleWithWebIdentity, which is not signed.
+func (c *CredentialChain) webIdentity(ctx context.Context, tokenFile string) (*Credentials, error) {
+	roleArn := os.Getenv("AWS_ROLE_ARN")
+	if roleArn == "" {
+		return nil, fmt.Errorf("AWS_ROLE_ARN is not set")
+	}
+	token, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return nil, err
+	}
+	sessionName := os.Getenv("AWS_ROLE_SESSION_NAME")
+	if sessionName == "" {
+		sessionName = fmt.Sprintf("gnark-mbu-%d", c.Now().Unix())
+	}
+	form := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {"2011-06-15"},
+		"RoleArn":          {roleArn},
+		"RoleSessionName":  {sessionName},
+		"WebIdentityToken": {strings.TrimSpace(string(token))},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ServiceEndpoint("sts", RegionFromEnv())+"/", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	body, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	var response struct {
+		Credentials struct {
+			AccessKeyId     string
+			SecretAccessKey string
+			SessionToken    string
+			Expiration      time.Time
+		} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+	}
+	if err = xml.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("invalid STS response: %w", err)
+	}
+	credentials := response.Credentials
+	if credentials.AccessKeyId == "" {
+		return nil, fmt.Errorf("STS returned no credentials")
+	}
+	return &Credentials{
+		AccessKeyId:     credentials.AccessKeyId,
+		SecretAccessKey: credentials.SecretAccessKey,
+		SessionToken:    credentials.SessionToken,
+		Expiration:      credentials.Expiration,
+	}, nil
+}
+
+// containerCredentialsURI returns the container credentials endpoint set up
+// by ECS or EKS Pod Identity, empty if there is none.
+func containerCredentialsURI() string {
+	if relative := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); relative != "" {
+		return defaultContainerHost + relative
+	}
+	return os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+}
+
+func (c *CredentialChain) container(ctx context.Context, uri string) (*Credentials, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN")
+	if tokenFile := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE"); tokenFile != "" {
+		contents, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		token = strings.TrimSpace(string(contents))
+	}
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	body, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	return parseMetadataCredentials(body)
+}
+
+// instance reads the credentials of the role of the EC2 instance with
+// IMDSv2. The endpoint is AWS_EC2_METADATA_SERVICE_ENDPOINT if set.
+func (c *CredentialChain) instance(ctx context.Context) (*Credentials, error) {
+	ctx, cancel := context.WithTimeout(ctx, metadataTimeout)
+	defer cancel()
+	endpoint := os.Getenv("AWS_EC2_METADATA_SERVICE_ENDPOINT")
+	if endpoint == "" {
+		endpoint = defaultIMDSEndpoint
+	}
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint+"/latest/api/token", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "21600")
+	token, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	get := func(path string) ([]byte, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("X-aws-ec2-metadata-token", string(token))
+		return c.do(req)
+	}
+	roles, err := get("/latest/meta-data/iam/security-credentials/")
+	if err != nil {
+		return nil, err
+	}
+	role, _, _ := strings.Cut(strings.TrimSpace(string(roles)), "\n")
+	if role == "" {
+		return nil, fmt.Errorf("the instance has no role")
+	}
+	body, err := get("/latest/meta-data/iam/security-credentials/" + url.PathEscape(role))
+	if err != nil {
+		return nil, err
+	}
+	return parseMetadataCredentials(body)
+}
+
+// parseMetadataCredentials parses the credentials served by the container
+// credentials endpoint and the instance metadata service.
+func parseMetadataCredentials(body []byte) (*Credentials, error) {
+	var credentials struct {
+		Code            string
+		Message         string
+		AccessKeyId     string
+		SecretAccessKey string
+		Token           string
+		Expiration      time.Time
+	}
+	if err := json.Unmarshal(body, &credentials); err != nil {
+		return nil, fmt.Errorf("invalid credentials: %w", err)
+	}
+	if credentials.Code != "" && credentials.Code != "Success" {
+		return nil, fmt.Errorf("%s: %s", credentials.Code, credentials.Message)
+	}
+	if credentials.AccessKeyId == "" {
+		return nil, fmt.Errorf("no access key in the credentials")
+	}
+	return &Credentials{
+		AccessKeyId:     credentials.AccessKeyId,
+		SecretAccessKey: credentials.SecretAccessKey,
+		SessionToken:    credentials.Token,
+		Expiration:      credentials.Expiration,
+	}, nil
+}
+
+func (c *CredentialChain) do(req *http.Request) ([]byte, error) {
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s failed with status %d: %s", req.Method, req.URL.Path, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
@@ -0,0 +1,131 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// clearCredentialsEnv unsets every variable the chain reads.
+func clearCredentialsEnv(t *testing.T) {
+	for _, name := range []string{
+		"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN",
+		"AWS_WEB_IDENTITY_TOKEN_FILE", "AWS_ROLE_ARN", "AWS_ROLE_SESSION_NAME",
+		"AWS_CONTAINER_CREDENTIALS_RELATIVE_URI", "AWS_CONTAINER_CREDENTIALS_FULL_URI",
+		"AWS_CONTAINER_AUTHORIZATION_TOKEN", "AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE",
+		"AWS_EC2_METADATA_DISABLED", "AWS_EC2_METADATA_SERVICE_ENDPOINT",
+		"AWS_ENDPOINT_URL", "AWS_ENDPOINT_URL_STS",
+	} {
+		t.Setenv(name, "")
+	}
+}
+
+func TestCredentialChainWebIdentity(t *testing.T) {
+	clearCredentialsEnv(t)
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.FormValue("Action") != "AssumeRoleWithWebIdentity" || r.FormValue("RoleArn") != "arn:aws:iam::1:role/prover" || r.FormValue("WebIdentityToken") != "jwt" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`<AssumeRoleWithWebIdentityResponse><AssumeRoleWithWebIdentityResult><Credentials>
+			<AccessKeyId>ASIA</AccessKeyId><SecretAccessKey>secret</SecretAccessKey><SessionToken>session</SessionToken>
+			<Expiration>2030-01-01T01:00:00Z</Expiration>
+		</Credentials></AssumeRoleWithWebIdentityResult></AssumeRoleWithWebIdentityResponse>`))
+	}))
+	defer server.Close()
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("jwt\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AWS_WEB_IDENTITY_TOKEN_FILE", tokenFile)
+	t.Setenv("AWS_ROLE_ARN", "arn:aws:iam::1:role/prover")
+	t.Setenv("AWS_ENDPOINT_URL_STS", server.URL)
+
+	now := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	chain := NewCredentialChain()
+	chain.Now = func() time.Time { return now }
+	for i := 0; i < 2; i++ {
+		credentials, err := chain.Retrieve(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if credentials.AccessKeyId != "ASIA" || credentials.SessionToken != "session" {
+			t.Fatalf("unexpected credentials %+v", credentials)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("expected the credentials to be cached, got %d calls", calls)
+	}
+	now = now.Add(58 * time.Minute)
+	if _, err := chain.Retrieve(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Fatalf("expected the credentials to be refreshed before they expire, got %d calls", calls)
+	}
+}
+
+func TestCredentialChainContainer(t *testing.T) {
+	clearCredentialsEnv(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "pod-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"AccessKeyId": "ASIA", "SecretAccessKey": "secret", "Token": "session", "Expiration": "2030-01-01T01:00:00Z"}`))
+	}))
+	defer server.Close()
+	t.Setenv("AWS_CONTAINER_CREDENTIALS_FULL_URI", server.URL+"/credentials")
+	t.Setenv("AWS_CONTAINER_AUTHORIZATION_TOKEN", "pod-token")
+	credentials, err := NewCredentialChain().Retrieve(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if credentials.AccessKeyId != "ASIA" || credentials.SessionToken != "session" || credentials.Expiration.IsZero() {
+		t.Fatalf("unexpected credentials %+v", credentials)
+	}
+}
+
+func TestCredentialChainInstance(t *testing.T) {
+	clearCredentialsEnv(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPut && r.URL.Path == "/latest/api/token":
+			w.Write([]byte("imds-token"))
+		case r.Header.Get("X-aws-ec2-metadata-token") != "imds-token":
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.URL.Path == "/latest/meta-data/iam/security-credentials/":
+			w.Write([]byte("prover-role"))
+		case r.URL.Path == "/latest/meta-data/iam/security-credentials/prover-role":
+			w.Write([]byte(`{"Code": "Success", "AccessKeyId": "ASIA", "SecretAccessKey": "secret", "Token": "session", "Expiration": "2030-01-01T01:00:00Z"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	t.Setenv("AWS_EC2_METADATA_SERVICE_ENDPOINT", server.URL)
+	credentials, err := NewCredentialChain().Retrieve(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if credentials.AccessKeyId != "ASIA" || credentials.SessionToken != "session" {
+		t.Fatalf("unexpected credentials %+v", credentials)
+	}
+
+	// Outside of EC2 there are no credentials.
+	server.Close()
+	if _, err = NewCredentialChain().Retrieve(context.Background()); !errors.Is(err, ErrNoCredentials) {
+		t.Fatalf("expected no credentials, got %v", err)
+	}
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	if _, err = NewCredentialChain().Retrieve(context.Background()); !errors.Is(err, ErrNoCredentials) {
+		t.Fatalf("expected no credentials, got %v", err)
+	}
+}
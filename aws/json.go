@@ -0,0 +1,128 @@
+package aws
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// JSONClient calls an AWS service with a JSON API, such as KMS, Secrets
+// Manager or SQS.
+type JSONClient struct {
+	// Service is the signing name of the service, such as kms.
+	Service string
+	// TargetPrefix prefixes the actions in X-Amz-Target, such as
+	// TrentService for KMS.
+	TargetPrefix string
+	// JSONVersion is the version of the JSON protocol of the service, 1.1
+	// if empty, 1.0 for SQS.
+	JSONVersion string
+	Region      string
+	Endpoint    string
+	Credentials CredentialsSource
+	Client      *http.Client
+	Now         func() time.Time
+}
+
+// NewJSONClient returns a client of service in region, or in the region of
+// the environment if empty, with the credentials of a CredentialChain, which
+// must resolve. The endpoint is ServiceEndpoint.
+func NewJSONClient(service string, targetPrefix string, region string) (*JSONClient, error) {
+	credentials := NewCredentialChain()
+	if _, err := credentials.Retrieve(context.Background()); err != nil {
+		return nil, fmt.Errorf("%s needs AWS credentials: %w", service, err)
+	}
+	if region == "" {
+		region = RegionFromEnv()
+	}
+	return &JSONClient{
+		Service:      service,
+		TargetPrefix: targetPrefix,
+		Region:       region,
+		Endpoint:     ServiceEndpoint(service, region),
+		Credentials:  credentials,
+		Client:       &http.Client{Timeout: 30 * time.Second},
+		Now:          time.Now,
+	}, nil
+}
+
+// ServiceEndpoint returns the endpoint of service in region:
+// AWS_ENDPOINT_URL_<SERVICE> or AWS_ENDPOINT_URL if set, such as for
+// LocalStack, the regional endpoint of AWS otherwise.
+func ServiceEndpoint(service string, region string) string {
+	endpoint := os.Getenv("AWS_ENDPOINT_URL_" + strings.ToUpper(service))
+	if endpoint == "" {
+		endpoint = os.Getenv("AWS_ENDPOINT_URL")
+	}
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.%s.amazonaws.com", service, region)
+	}
+	return strings.TrimSuffix(endpoint, "/")
+}
+
+// Error is an error answered by an AWS service.
+type Error struct {
+	Action     string
+	StatusCode int
+	Type       string
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s failed with status %d: %s %s", e.Action, e.StatusCode, e.Type, e.Message)
+}
+
+// Call calls action with request, decoding the answer into response. Byte
+// slices are sent and received as base64, like the blobs of the API.
+func (c *JSONClient) Call(ctx context.Context, action string, request interface{}, response interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.Endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	version := c.JSONVersion
+	if version == "" {
+		version = "1.1"
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-"+version)
+	req.Header.Set("X-Amz-Target", c.TargetPrefix+"."+action)
+	credentials, err := c.Credentials.Retrieve(ctx)
+	if err != nil {
+		return err
+	}
+	payloadHash := sha256.Sum256(body)
+	SignV4(req, credentials, c.Region, c.Service, hex.EncodeToString(payloadHash[:]), c.Now().UTC())
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		failure := &Error{Action: c.Service + " " + action, StatusCode: resp.StatusCode}
+		var answer struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+		if json.Unmarshal(data, &answer) == nil {
+			failure.Type, failure.Message = answer.Type, answer.Message
+		}
+		return failure
+	}
+	// Some actions, such as DeleteMessage of SQS, answer with no body.
+	if err = json.NewDecoder(resp.Body).Decode(response); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
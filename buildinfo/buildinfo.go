@@ -0,0 +1,28 @@
+// Package buildinfo exposes the version of the running binary. Version is
+// set at link time with
+//
+//	-ldflags "-X worldcoin/gnark-mbu/buildinfo.Version=v1.2.3"
+//
+// and GitCommit falls back to the VCS revision recorded by the Go toolchain.
+package buildinfo
+
+import "runtime/debug"
+
+var Version = "dev"
+
+var GitCommit = ""
+
+func init() {
+	if GitCommit != "" {
+		return
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			GitCommit = setting.Value
+		}
+	}
+}
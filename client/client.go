@@ -0,0 +1,462 @@
+// Package client calls the prover server, so that Go services do not need to
+// encode its requests by hand.
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"worldcoin/gnark-mbu/prover"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// The headers of the server. They are not taken from the server package so
+// that clients do not link it.
+const (
+	clientIdHeader       = "X-Client-Id"
+	signatureHeader      = "X-Signature"
+	idempotencyKeyHeader = "Idempotency-Key"
+	deadlineHeader       = "X-Deadline"
+	priorityHeader       = "X-Priority"
+)
+
+// Options configure a ProverClient.
+type Options struct {
+	// HTTPClient sends the requests, http.DefaultClient if nil.
+	HTTPClient *http.Client
+	// Timeout bounds each attempt of a request, including the time the
+	// server takes to prove. Zero means no timeout.
+	Timeout time.Duration
+	// Retries is the number of times a request is retried after connection
+	// errors and 429, 502, 503 or 504 responses.
+	Retries int
+	// Backoff is the wait before the first retry, doubled for every next
+	// one. A Retry-After sent by the server takes precedence.
+	Backoff time.Duration
+	// ClientID and Key sign the parameters of proof requests with a key
+	// registered on the server. Key is an ed25519.PrivateKey or an
+	// *ecdsa.PrivateKey. Requests are unsigned if Key is nil.
+	ClientID string
+	Key      crypto.Signer
+	// RequestEncoding compresses request bodies with gzip or zstd if set.
+	// Responses are compressed with gzip and decoded by the transport of
+	// HTTPClient unless it disables compression.
+	RequestEncoding string
+	// Format sends proof requests and receives their proofs in CBOR or
+	// MessagePack if set, rather than JSON.
+	Format prover.BinaryFormat
+}
+
+// ProverClient calls the prover server at a base URL. It is safe for
+// concurrent use.
+type ProverClient struct {
+	base    *url.URL
+	options Options
+	client  *http.Client
+}
+
+// New returns a client of the server at baseURL, e.g. http://prover:3001.
+func New(baseURL string, options Options) (*ProverClient, error) {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return nil, fmt.Errorf("invalid prover URL %q, expected http or https", baseURL)
+	}
+	switch options.Key.(type) {
+	case nil, ed25519.PrivateKey, *ecdsa.PrivateKey:
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", options.Key)
+	}
+	switch options.RequestEncoding {
+	case "", "gzip", "zstd":
+	default:
+		return nil, fmt.Errorf("unsupported request encoding %q, expected gzip or zstd", options.RequestEncoding)
+	}
+	switch options.Format {
+	case "", prover.BinaryFormatCBOR, prover.BinaryFormatMsgpack:
+	default:
+		return nil, fmt.Errorf("unsupported format %q, expected cbor or msgpack", options.Format)
+	}
+	client := options.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &ProverClient{base: base, options: options, client: client}, nil
+}
+
+// Error is an error response of the server.
+type Error struct {
+	StatusCode int
+	// Code is the error code of the response, e.g. root_mismatch. The codes
+	// are listed in the README.
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// RetryAfter is the wait requested by the server, if any.
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("prover answered %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// retryable reports whether a request failing with the status code may
+// succeed if retried.
+func retryable(statusCode int) bool {
+	switch statusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// request is a request to send, possibly more than once.
+type request struct {
+	method string
+	path   string
+	query  url.Values
+	body   []byte
+	header http.Header
+	// format is the format of the body and response, JSON if empty. Error
+	// responses are always JSON.
+	format prover.BinaryFormat
+}
+
+// do sends req, retrying it as configured, and decodes the response into
+// response unless it is nil.
+func (c *ProverClient) do(ctx context.Context, req *request, expectedStatus int, response interface{}) error {
+	backoff := c.options.Backoff
+	for attempt := 0; ; attempt++ {
+		err := c.attempt(ctx, req, expectedStatus, response)
+		if err == nil || attempt >= c.options.Retries {
+			return err
+		}
+		var responseErr *Error
+		if errors.As(err, &responseErr) {
+			if !retryable(responseErr.StatusCode) {
+				return err
+			}
+		} else if ctx.Err() != nil {
+			return err
+		}
+		wait := backoff
+		if responseErr != nil && responseErr.RetryAfter > 0 {
+			wait = responseErr.RetryAfter
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+func (c *ProverClient) attempt(ctx context.Context, req *request, expectedStatus int, response interface{}) error {
+	if c.options.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.options.Timeout)
+		defer cancel()
+	}
+	u := c.base.JoinPath(req.path)
+	u.RawQuery = req.query.Encode()
+	var body io.Reader
+	if req.body != nil {
+		encoded, err := encodeBody(c.options.RequestEncoding, req.body)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(encoded)
+	}
+	httpRequest, err := http.NewRequestWithContext(ctx, req.method, u.String(), body)
+	if err != nil {
+		return err
+	}
+	for name, values := range req.header {
+		httpRequest.Header[name] = values
+	}
+	if req.body != nil {
+		contentType := "application/json"
+		if req.format != "" {
+			contentType = req.format.ContentType()
+		}
+		httpRequest.Header.Set("Content-Type", contentType)
+		if c.options.RequestEncoding != "" {
+			httpRequest.Header.Set("Content-Encoding", c.options.RequestEncoding)
+		}
+	}
+	if req.format != "" {
+		httpRequest.Header.Set("Accept", req.format.ContentType())
+	}
+	httpResponse, err := c.client.Do(httpRequest)
+	if err != nil {
+		return err
+	}
+	defer httpResponse.Body.Close()
+	responseBytes, err := io.ReadAll(httpResponse.Body)
+	if err != nil {
+		return err
+	}
+	if httpResponse.StatusCode != expectedStatus {
+		responseErr := &Error{StatusCode: httpResponse.StatusCode}
+		if json.Unmarshal(responseBytes, responseErr) != nil || responseErr.Code == "" {
+			responseErr.Code, responseErr.Message = "", strings.TrimSpace(string(responseBytes))
+		}
+		if seconds, err := strconv.Atoi(httpResponse.Header.Get("Retry-After")); err == nil {
+			responseErr.RetryAfter = time.Duration(seconds) * time.Second
+		}
+		return responseErr
+	}
+	if response == nil {
+		return nil
+	}
+	if req.format != "" {
+		return req.format.Unmarshal(responseBytes, response)
+	}
+	return json.Unmarshal(responseBytes, response)
+}
+
+// encodeBody compresses body in encoding, if any.
+func encodeBody(encoding string, body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var encoder io.WriteCloser
+	switch encoding {
+	case "":
+		return body, nil
+	case "gzip":
+		encoder = gzip.NewWriter(&buf)
+	default:
+		var err error
+		if encoder, err = zstd.NewWriter(&buf, zstd.WithEncoderConcurrency(1)); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := encoder.Write(body); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// proofRequest returns the signed request proving params, encoded in format
+// or JSON if it is empty.
+func (c *ProverClient) proofRequest(method string, path string, params *prover.Parameters, format prover.BinaryFormat) (*request, error) {
+	var body []byte
+	var err error
+	if format != "" {
+		body, err = format.Marshal(params)
+	} else {
+		body, err = json.Marshal(params)
+	}
+	if err != nil {
+		return nil, err
+	}
+	req := &request{method: method, path: path, query: url.Values{}, body: body, header: http.Header{}, format: format}
+	if c.options.Key != nil {
+		digest := params.Digest()
+		var signature []byte
+		if key, ok := c.options.Key.(ed25519.PrivateKey); ok {
+			signature = ed25519.Sign(key, digest[:])
+		} else if signature, err = ecdsa.SignASN1(rand.Reader, c.options.Key.(*ecdsa.PrivateKey), digest[:]); err != nil {
+			return nil, err
+		}
+		req.header.Set(clientIdHeader, c.options.ClientID)
+		req.header.Set(signatureHeader, base64.StdEncoding.EncodeToString(signature))
+	}
+	return req, nil
+}
+
+// ProveOptions are the options of a proof request.
+type ProveOptions struct {
+	// Deadline is the time by which the proof is needed, which the server
+	// scales on.
+	Deadline time.Time
+	// CallbackURL is where the server posts the result once the proof
+	// finishes.
+	CallbackURL string
+	// Priority is the priority class of the proof in the queue of the
+	// server: critical, normal or background. The server defaults to normal.
+	Priority string
+}
+
+// Prove requests the proof of params, whose input hash must be set, e.g.
+// with NewParameters. Retries of a signed request share an idempotency key,
+// so that the server proves them once; the server refuses idempotency keys on
+// unsigned requests, whose retries are matched by their input hash.
+func (c *ProverClient) Prove(ctx context.Context, params *prover.Parameters, options *ProveOptions) (*prover.Proof, error) {
+	req, err := c.proofRequest(http.MethodPost, "/prove", params, c.options.Format)
+	if err != nil {
+		return nil, err
+	}
+	if c.options.Key != nil {
+		var key [16]byte
+		if _, err = rand.Read(key[:]); err != nil {
+			return nil, err
+		}
+		req.header.Set(idempotencyKeyHeader, hex.EncodeToString(key[:]))
+	}
+	options.apply(req)
+	var proof prover.Proof
+	if err = c.do(ctx, req, http.StatusOK, &proof); err != nil {
+		return nil, err
+	}
+	return &proof, nil
+}
+
+func (options *ProveOptions) apply(req *request) {
+	if options == nil {
+		return
+	}
+	if !options.Deadline.IsZero() {
+		req.header.Set(deadlineHeader, options.Deadline.UTC().Format(time.RFC3339))
+	}
+	if options.CallbackURL != "" {
+		req.query.Set("callback_url", options.CallbackURL)
+	}
+	if options.Priority != "" {
+		req.header.Set(priorityHeader, options.Priority)
+	}
+}
+
+// VerifyResult is the result of Verify.
+type VerifyResult struct {
+	Valid bool `json:"valid"`
+	// Message tells why an invalid proof did not verify.
+	Message string `json:"message,omitempty"`
+}
+
+// Verify checks proof against its public inputs. postRoot is only used by
+// the keys proving it publicly, and may be nil otherwise.
+func (c *ProverClient) Verify(ctx context.Context, inputHash *big.Int, postRoot *big.Int, proof *prover.Proof) (*VerifyResult, error) {
+	verifyRequest := struct {
+		InputHash string        `json:"inputHash"`
+		PostRoot  string        `json:"postRoot,omitempty"`
+		Proof     *prover.Proof `json:"proof"`
+	}{InputHash: "0x" + inputHash.Text(16), Proof: proof}
+	if postRoot != nil {
+		verifyRequest.PostRoot = "0x" + postRoot.Text(16)
+	}
+	body, err := json.Marshal(&verifyRequest)
+	if err != nil {
+		return nil, err
+	}
+	var result VerifyResult
+	if err = c.do(ctx, &request{method: http.MethodPost, path: "/verify", body: body}, http.StatusOK, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ChainLink is the proof of a batch of a chain verified by VerifyChain.
+type ChainLink struct {
+	InputHash *big.Int
+	PreRoot   *big.Int
+	PostRoot  *big.Int
+	Proof     *prover.Proof
+}
+
+// ChainFailure is a link of a chain that failed VerifyChain, with the code
+// invalid_proof or root_discontinuity.
+type ChainFailure struct {
+	Index   int    `json:"index"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// VerifyChainResult is the result of VerifyChain.
+type VerifyChainResult struct {
+	Valid    bool           `json:"valid"`
+	Failures []ChainFailure `json:"failures,omitempty"`
+}
+
+// VerifyChain verifies the proofs of consecutive batches, and checks that
+// every pre root is the post root of the previous link.
+func (c *ProverClient) VerifyChain(ctx context.Context, links []ChainLink) (*VerifyChainResult, error) {
+	type linkJSON struct {
+		InputHash string        `json:"inputHash"`
+		PreRoot   string        `json:"preRoot"`
+		PostRoot  string        `json:"postRoot"`
+		Proof     *prover.Proof `json:"proof"`
+	}
+	chainRequest := struct {
+		Proofs []linkJSON `json:"proofs"`
+	}{Proofs: make([]linkJSON, len(links))}
+	for i, link := range links {
+		chainRequest.Proofs[i] = linkJSON{
+			InputHash: "0x" + link.InputHash.Text(16),
+			PreRoot:   "0x" + link.PreRoot.Text(16),
+			PostRoot:  "0x" + link.PostRoot.Text(16),
+			Proof:     link.Proof,
+		}
+	}
+	body, err := json.Marshal(&chainRequest)
+	if err != nil {
+		return nil, err
+	}
+	var result VerifyChainResult
+	if err = c.do(ctx, &request{method: http.MethodPost, path: "/verify_chain", body: body}, http.StatusOK, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Health is the health of the server.
+type Health struct {
+	// Status is ok, or degraded if the server fails to prove.
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Health returns the health of the server.
+func (c *ProverClient) Health(ctx context.Context) (*Health, error) {
+	var health Health
+	if err := c.do(ctx, &request{method: http.MethodGet, path: "/health"}, http.StatusOK, &health); err != nil {
+		return nil, err
+	}
+	return &health, nil
+}
+
+// Info describes the circuit of the server. The circuit is omitted if the
+// keys could not be loaded.
+type Info struct {
+	Status    string `json:"status"`
+	Curve     string `json:"curve,omitempty"`
+	TreeDepth uint32 `json:"treeDepth,omitempty"`
+	BatchSize uint32 `json:"batchSize,omitempty"`
+	// Commitment is the hash computing the input hash, to pass to
+	// NewParameters.
+	Commitment     prover.Commitment `json:"commitment,omitempty"`
+	Indexed        bool              `json:"indexed,omitempty"`
+	CircuitVersion string            `json:"circuitVersion"`
+	Fingerprint    string            `json:"fingerprint,omitempty"`
+}
+
+// Info returns the circuit of the server.
+func (c *ProverClient) Info(ctx context.Context) (*Info, error) {
+	var info Info
+	if err := c.do(ctx, &request{method: http.MethodGet, path: "/info"}, http.StatusOK, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
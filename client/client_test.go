@@ -0,0 +1,211 @@
+package client
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"worldcoin/gnark-mbu/prover"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/klauspost/compress/zstd"
+)
+
+func testParameters(t *testing.T) *prover.Parameters {
+	params, err := NewParameters(&Insertion{
+		PreRoot:      big.NewInt(1),
+		PostRoot:     big.NewInt(2),
+		IdComms:      []*big.Int{big.NewInt(3)},
+		MerkleProofs: [][]*big.Int{{big.NewInt(4), big.NewInt(5)}},
+	}, prover.CommitmentKeccak)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return params
+}
+
+func TestProveRetries(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := testParameters(t)
+	digest := params.Digest()
+	proof, err := json.Marshal(&prover.Proof{Proof: groth16.NewProof(ecc.BN254)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var received prover.Parameters
+		if err := json.Unmarshal(body, &received); err != nil {
+			t.Errorf("unexpected body %s: %v", body, err)
+		}
+		signature, _ := base64.StdEncoding.DecodeString(r.Header.Get(signatureHeader))
+		if r.Header.Get(clientIdHeader) != "sequencer" || !ed25519.Verify(publicKey, digest[:], signature) {
+			t.Error("expected the request to be signed")
+		}
+		if r.URL.Query().Get("callback_url") != "https://sequencer/proofs" {
+			t.Errorf("unexpected query %s", r.URL.RawQuery)
+		}
+		if r.Header.Get(priorityHeader) != "critical" {
+			t.Errorf("expected a critical proof, got %q", r.Header.Get(priorityHeader))
+		}
+		keys = append(keys, r.Header.Get(idempotencyKeyHeader))
+		if len(keys) == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"code":"shutting_down","message":"the server is shutting down"}`))
+			return
+		}
+		w.Write(proof)
+	}))
+	defer server.Close()
+
+	proverClient, err := New(server.URL, Options{Retries: 1, ClientID: "sequencer", Key: privateKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = proverClient.Prove(context.Background(), params, &ProveOptions{CallbackURL: "https://sequencer/proofs", Priority: "critical"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0] == "" || keys[0] != keys[1] {
+		t.Fatalf("expected a retry with the same idempotency key, got %v", keys)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if r.Header.Get(idempotencyKeyHeader) != "" {
+			t.Error("expected unsigned requests to carry no idempotency key")
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"code":"root_mismatch","message":"merkle proof 0 does not open the root"}`))
+	}))
+	defer server.Close()
+
+	proverClient, err := New(server.URL, Options{Retries: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = proverClient.Prove(context.Background(), testParameters(t), nil)
+	if clientErr, ok := err.(*Error); !ok || clientErr.StatusCode != http.StatusBadRequest || clientErr.Code != "root_mismatch" {
+		t.Fatalf("expected a root_mismatch error, got %v", err)
+	}
+	if attempts != 1 {
+		t.Fatalf("expected client errors not to be retried, got %d attempts", attempts)
+	}
+}
+
+func TestProveAsync(t *testing.T) {
+	polls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/jobs":
+			w.WriteHeader(http.StatusAccepted)
+			w.Write([]byte(`{"id":"7","status":"queued"}`))
+		case r.Method == http.MethodGet && r.URL.Path == "/jobs/7":
+			polls++
+			if polls < 3 {
+				w.Write([]byte(`{"id":"7","status":"running"}`))
+				return
+			}
+			w.Write([]byte(`{"id":"7","status":"failed","error":{"code":"witness_error","message":"unsatisfied"}}`))
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		}
+	}))
+	defer server.Close()
+
+	proverClient, err := New(server.URL, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = proverClient.ProveAsync(context.Background(), testParameters(t), time.Millisecond)
+	if clientErr, ok := err.(*Error); !ok || clientErr.Code != "witness_error" || polls != 3 {
+		t.Fatalf("expected the job to fail with witness_error after 3 polls, got %v after %d", err, polls)
+	}
+}
+
+func TestRequestEncoding(t *testing.T) {
+	params := testParameters(t)
+	proof, err := json.Marshal(&prover.Proof{Proof: groth16.NewProof(ecc.BN254)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		decoder, err := zstd.NewReader(r.Body)
+		if err != nil || r.Header.Get("Content-Encoding") != "zstd" {
+			t.Errorf("expected a zstd body, got %q: %v", r.Header.Get("Content-Encoding"), err)
+			return
+		}
+		defer decoder.Close()
+		var received prover.Parameters
+		if err = json.NewDecoder(decoder).Decode(&received); err != nil || received.InputHash.Cmp(&params.InputHash) != 0 {
+			t.Errorf("unexpected body: %v", err)
+		}
+		// The transport asks for gzip responses and decodes them.
+		if r.Header.Get("Accept-Encoding") != "gzip" {
+			t.Errorf("unexpected Accept-Encoding %q", r.Header.Get("Accept-Encoding"))
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		encoder := gzip.NewWriter(w)
+		encoder.Write(proof)
+		encoder.Close()
+	}))
+	defer server.Close()
+
+	if _, err = New(server.URL, Options{RequestEncoding: "br"}); err == nil {
+		t.Fatal("expected unsupported encodings to be rejected")
+	}
+	proverClient, err := New(server.URL, Options{RequestEncoding: "zstd"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = proverClient.Prove(context.Background(), params, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBinaryFormat(t *testing.T) {
+	params := testParameters(t)
+	proof, err := (&prover.Proof{Proof: groth16.NewProof(ecc.BN254)}).Binary(prover.ProofEncodingDefault)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/msgpack" || r.Header.Get("Accept") != "application/msgpack" {
+			t.Errorf("unexpected headers %v", r.Header)
+		}
+		body, _ := io.ReadAll(r.Body)
+		var received prover.Parameters
+		if err := prover.BinaryFormatMsgpack.Unmarshal(body, &received); err != nil || received.InputHash.Cmp(&params.InputHash) != 0 {
+			t.Errorf("unexpected body: %v", err)
+		}
+		response, _ := prover.BinaryFormatMsgpack.Marshal(proof)
+		w.Write(response)
+	}))
+	defer server.Close()
+
+	if _, err = New(server.URL, Options{Format: "protobuf"}); err == nil {
+		t.Fatal("expected unsupported formats to be rejected")
+	}
+	proverClient, err := New(server.URL, Options{Format: prover.BinaryFormatMsgpack})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = proverClient.Prove(context.Background(), params, nil); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,98 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+	"worldcoin/gnark-mbu/prover"
+)
+
+// JobStatus is the status of an async proof job.
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+)
+
+// Finished reports whether a job in this status is done.
+func (s JobStatus) Finished() bool {
+	return s == JobSucceeded || s == JobFailed
+}
+
+// Job is a proof requested asynchronously, on servers with a job store.
+type Job struct {
+	ID     string    `json:"id"`
+	Status JobStatus `json:"status"`
+	// Proof is the proof of a succeeded job.
+	Proof *prover.Proof `json:"proof,omitempty"`
+	// Error tells why a failed job failed. Its StatusCode is not set.
+	Error     *Error    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// SubmitJob requests the proof of params asynchronously and returns the
+// queued job.
+func (c *ProverClient) SubmitJob(ctx context.Context, params *prover.Parameters, options *ProveOptions) (*Job, error) {
+	req, err := c.proofRequest(http.MethodPost, "/jobs", params, "")
+	if err != nil {
+		return nil, err
+	}
+	options.apply(req)
+	var job Job
+	if err = c.do(ctx, req, http.StatusAccepted, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// Job returns the job with the id.
+func (c *ProverClient) Job(ctx context.Context, id string) (*Job, error) {
+	var job Job
+	if err := c.do(ctx, &request{method: http.MethodGet, path: "/jobs/" + url.PathEscape(id)}, http.StatusOK, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// WaitJob polls the job with the id every interval, which must be positive,
+// until it finishes or ctx is done.
+func (c *ProverClient) WaitJob(ctx context.Context, id string, interval time.Duration) (*Job, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		job, err := c.Job(ctx, id)
+		if err != nil || job.Status.Finished() {
+			return job, err
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// ProveAsync submits a job proving params and waits for its proof, polling
+// every interval. A failed job is returned as its *Error.
+func (c *ProverClient) ProveAsync(ctx context.Context, params *prover.Parameters, interval time.Duration) (*prover.Proof, error) {
+	job, err := c.SubmitJob(ctx, params, nil)
+	if err != nil {
+		return nil, err
+	}
+	if job, err = c.WaitJob(ctx, job.ID, interval); err != nil {
+		return nil, err
+	}
+	if job.Status == JobFailed {
+		if job.Error == nil {
+			return nil, fmt.Errorf("job %s failed", job.ID)
+		}
+		return nil, job.Error
+	}
+	return job.Proof, nil
+}
@@ -0,0 +1,66 @@
+package client
+
+import (
+	"fmt"
+	"math/big"
+	"worldcoin/gnark-mbu/prover"
+)
+
+// Insertion is a batch of identity commitments inserted into the tree, as
+// the sequencer tracks it.
+type Insertion struct {
+	StartIndex uint32
+	PreRoot    *big.Int
+	PostRoot   *big.Int
+	IdComms    []*big.Int
+	// MerkleProofs are the sibling paths of the slots IdComms are inserted
+	// at, from the leaves up, each taken from the tree with the previous
+	// commitments inserted.
+	MerkleProofs [][]*big.Int
+	// EmptyLeaf is the value of the slots not inserted into, zero if nil.
+	EmptyLeaf *big.Int
+	// Indices are the slots IdComms are inserted at, for indexed circuits.
+	Indices []uint32
+	// TreeDepth selects the circuit of the tree depth among those of the
+	// server, zero leaving it to the length of the Merkle proofs.
+	TreeDepth uint32
+}
+
+// NewParameters returns the parameters proving insertion, with their input
+// hash computed with the commitment of the server, see Info.
+func NewParameters(insertion *Insertion, commitment prover.Commitment) (*prover.Parameters, error) {
+	if insertion.PreRoot == nil || insertion.PostRoot == nil {
+		return nil, fmt.Errorf("the pre and post roots are required")
+	}
+	params := &prover.Parameters{StartIndex: insertion.StartIndex, Indices: insertion.Indices, TreeDepth: insertion.TreeDepth}
+	params.PreRoot.Set(insertion.PreRoot)
+	params.PostRoot.Set(insertion.PostRoot)
+	if insertion.EmptyLeaf != nil {
+		params.EmptyLeaf.Set(insertion.EmptyLeaf)
+	}
+	params.IdComms = make([]big.Int, len(insertion.IdComms))
+	for i, idComm := range insertion.IdComms {
+		params.IdComms[i].Set(idComm)
+	}
+	params.MerkleProofs = make([][]big.Int, len(insertion.MerkleProofs))
+	for i, proof := range insertion.MerkleProofs {
+		params.MerkleProofs[i] = make([]big.Int, len(proof))
+		for j, sibling := range proof {
+			params.MerkleProofs[i][j].Set(sibling)
+		}
+	}
+	if err := params.ComputeInputHashWith(commitment); err != nil {
+		return nil, err
+	}
+	return params, nil
+}
+
+// ParseNumber parses a 0x-prefixed hex or decimal number, as the server
+// encodes them.
+func ParseNumber(s string) (*big.Int, error) {
+	n, ok := new(big.Int).SetString(s, 0)
+	if !ok || n.Sign() < 0 {
+		return nil, fmt.Errorf("invalid number %q", s)
+	}
+	return n, nil
+}
@@ -0,0 +1,170 @@
+package coordinator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backend is a downstream prover instance.
+type backend struct {
+	url *url.URL
+	// name labels the metrics of the backend.
+	name string
+
+	mu       sync.Mutex
+	healthy  bool
+	reason   string
+	checked  time.Time
+	inFlight int
+	// reported is the number of proofs queued and running the backend
+	// reported at its last check, capacity its proving slots.
+	reported int
+	capacity int
+}
+
+func newBackend(location string) (*backend, error) {
+	parsed, err := url.Parse(strings.TrimSuffix(location, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid backend %q: %w", location, err)
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid backend %q: expected an http:// or https:// URL", location)
+	}
+	return &backend{url: parsed, name: parsed.String(), capacity: 1}, nil
+}
+
+// load is the share of the proving slots of the backend taken. Its reported
+// proofs lag behind, so the requests sent by the coordinator since count
+// if they are more.
+func (b *backend) load() float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	busy := b.reported
+	if b.inFlight > busy {
+		busy = b.inFlight
+	}
+	return float64(busy) / float64(b.capacity)
+}
+
+func (b *backend) isHealthy() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.healthy
+}
+
+// acquire counts a request sent to the backend until release is called.
+func (b *backend) acquire() (release func()) {
+	b.mu.Lock()
+	b.inFlight++
+	b.mu.Unlock()
+	backendInFlightGauge.WithLabelValues(b.name).Inc()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.mu.Lock()
+			b.inFlight--
+			b.mu.Unlock()
+			backendInFlightGauge.WithLabelValues(b.name).Dec()
+		})
+	}
+}
+
+// setHealth records the outcome of a health check or of a request.
+func (b *backend) setHealth(healthy bool, reason string) {
+	b.mu.Lock()
+	b.healthy, b.reason, b.checked = healthy, reason, time.Now()
+	b.mu.Unlock()
+	up := 0.0
+	if healthy {
+		up = 1
+	}
+	backendUpGauge.WithLabelValues(b.name).Set(up)
+}
+
+// endpoint returns the URL of path on the backend.
+func (b *backend) endpoint(path string) string {
+	return b.url.String() + path
+}
+
+// check asks the backend whether it is ready to prove, then for its load.
+// Backends without /autoscale, such as verifiers, count as one slot.
+func (b *backend) check(ctx context.Context, client *http.Client) {
+	get := func(path string, v interface{}) (int, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.endpoint(path), nil)
+		if err != nil {
+			return 0, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return 0, err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+		if err != nil {
+			return resp.StatusCode, err
+		}
+		json.Unmarshal(body, v)
+		return resp.StatusCode, nil
+	}
+	var ready struct {
+		Status string `json:"status"`
+		Reason string `json:"reason"`
+	}
+	status, err := get("/ready", &ready)
+	if err != nil {
+		b.setHealth(false, err.Error())
+		return
+	}
+	if status != http.StatusOK {
+		reason := ready.Reason
+		if reason == "" {
+			reason = ready.Status
+		}
+		b.setHealth(false, fmt.Sprintf("not ready (%d): %s", status, reason))
+		return
+	}
+	var signal struct {
+		QueueDepth    int `json:"queueDepth"`
+		RunningProofs int `json:"runningProofs"`
+		Capacity      int `json:"capacity"`
+	}
+	if status, err = get("/autoscale", &signal); err != nil || status != http.StatusOK || signal.Capacity < 1 {
+		signal.QueueDepth, signal.RunningProofs, signal.Capacity = 0, 0, 1
+	}
+	b.mu.Lock()
+	b.reported, b.capacity = signal.QueueDepth+signal.RunningProofs, signal.Capacity
+	b.mu.Unlock()
+	b.setHealth(true, "")
+}
+
+// backendStatus is a backend as listed by /backends.
+type backendStatus struct {
+	URL       string    `json:"url"`
+	Healthy   bool      `json:"healthy"`
+	Reason    string    `json:"reason,omitempty"`
+	CheckedAt time.Time `json:"checkedAt"`
+	InFlight  int       `json:"inFlight"`
+	Reported  int       `json:"reportedProofs"`
+	Capacity  int       `json:"capacity"`
+}
+
+func (b *backend) status() backendStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return backendStatus{
+		URL:       b.name,
+		Healthy:   b.healthy,
+		Reason:    b.reason,
+		CheckedAt: b.checked,
+		InFlight:  b.inFlight,
+		Reported:  b.reported,
+		Capacity:  b.capacity,
+	}
+}
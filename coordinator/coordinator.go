@@ -0,0 +1,328 @@
+// Package coordinator turns prover instances into a proving cluster: it
+// forwards the proof and verification requests it receives to a pool of
+// downstream instances, each holding the keys, routing every request to the
+// healthy instance with the least load and failing over to the others.
+package coordinator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"sync"
+	"time"
+	"worldcoin/gnark-mbu/logging"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Config configures a Coordinator.
+type Config struct {
+	// Backends are the base URLs of the prover instances, such as
+	// http://prover-0:3001.
+	Backends []string
+	// Address is the address the forwarded endpoints are served on.
+	Address string
+	// MetricsAddress, if set, is the address /metrics is served on while
+	// the coordinator runs.
+	MetricsAddress string
+	// HealthInterval is the time between health checks of every backend,
+	// 5 seconds if zero. A check taking longer fails.
+	HealthInterval time.Duration
+	// MaxAttempts bounds the backends a request is sent to, every healthy
+	// backend if zero.
+	MaxAttempts int
+}
+
+// routes are the paths forwarded. Jobs and aggregations live in the instance
+// that accepted them, so they are not: callers needing them talk to an
+// instance directly.
+var routes = []string{
+	"/prove",
+	"/prove/insertion",
+	"/prove/deletion",
+	"/prove/ws",
+	"/prove_batch",
+	"/prove_split",
+	"/witness",
+	"/check",
+	"/verify",
+	"/verify_chain",
+	"/info",
+	"/keys",
+}
+
+// errNoBackend is returned by the transport when every backend is down or
+// was tried.
+var errNoBackend = errors.New("no healthy backend is left to send the request to")
+
+// Coordinator forwards requests to its backends. It is safe for concurrent
+// use.
+type Coordinator struct {
+	config    Config
+	backends  []*backend
+	client    *http.Client
+	transport http.RoundTripper
+}
+
+// New returns a Coordinator of the backends of config. Backends are down
+// until their first health check.
+func New(config Config) (*Coordinator, error) {
+	if len(config.Backends) == 0 {
+		return nil, fmt.Errorf("the coordinator needs at least one backend")
+	}
+	if config.HealthInterval <= 0 {
+		config.HealthInterval = 5 * time.Second
+	}
+	c := &Coordinator{
+		config:    config,
+		client:    &http.Client{Timeout: config.HealthInterval},
+		transport: http.DefaultTransport,
+	}
+	for _, location := range config.Backends {
+		b, err := newBackend(location)
+		if err != nil {
+			return nil, err
+		}
+		backendUpGauge.WithLabelValues(b.name).Set(0)
+		c.backends = append(c.backends, b)
+	}
+	return c, nil
+}
+
+// checkAll checks the health of every backend at once.
+func (c *Coordinator) checkAll(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, b := range c.backends {
+		wg.Add(1)
+		go func(b *backend) {
+			defer wg.Done()
+			wasHealthy := b.isHealthy()
+			b.check(ctx, c.client)
+			if status := b.status(); status.Healthy != wasHealthy {
+				logging.Logger().Info().Str("backend", status.URL).Bool("healthy", status.Healthy).Str("reason", status.Reason).Msg("backend health changed")
+			}
+		}(b)
+	}
+	wg.Wait()
+}
+
+// pick returns the healthy backend not tried yet with the least load, the
+// first listed among equals, or nil if there is none.
+func (c *Coordinator) pick(tried map[*backend]bool) *backend {
+	var (
+		best     *backend
+		bestLoad float64
+	)
+	for _, b := range c.backends {
+		if tried[b] || !b.isHealthy() {
+			continue
+		}
+		if load := b.load(); best == nil || load < bestLoad {
+			best, bestLoad = b, load
+		}
+	}
+	return best
+}
+
+// RoundTrip sends req to the backends in turn, from the least loaded, until
+// one answers with another status than 503. Backends that cannot be reached
+// are down until their next successful health check. The body is buffered,
+// so that it can be sent again.
+func (c *Coordinator) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+	}
+	attempts := c.config.MaxAttempts
+	if attempts <= 0 {
+		attempts = len(c.backends)
+	}
+	tried := make(map[*backend]bool)
+	lastErr := errNoBackend
+	for attempt := 0; attempt < attempts; attempt++ {
+		b := c.pick(tried)
+		if b == nil {
+			break
+		}
+		tried[b] = true
+		out := req.Clone(req.Context())
+		out.URL.Scheme, out.URL.Host = b.url.Scheme, b.url.Host
+		out.URL.Path = b.url.Path + req.URL.Path
+		out.URL.RawPath = ""
+		out.Host = b.url.Host
+		out.Body, out.ContentLength = nil, int64(len(body))
+		if req.Body != nil {
+			out.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		release := b.acquire()
+		resp, err := c.transport.RoundTrip(out)
+		if err != nil {
+			release()
+			if req.Context().Err() != nil {
+				return nil, err
+			}
+			attemptsCounter.WithLabelValues(b.name, outcomeUnreachable).Inc()
+			b.setHealth(false, err.Error())
+			logging.Logger().Warn().Err(err).Str("backend", b.name).Msg("backend unreachable, failing over")
+			lastErr = err
+			continue
+		}
+		if resp.StatusCode == http.StatusServiceUnavailable && attempt+1 < attempts && c.pick(tried) != nil {
+			release()
+			resp.Body.Close()
+			attemptsCounter.WithLabelValues(b.name, outcomeUnavailable).Inc()
+			logging.Logger().Info().Str("backend", b.name).Msg("backend unavailable, failing over")
+			continue
+		}
+		attemptsCounter.WithLabelValues(b.name, outcomeForwarded).Inc()
+		if rwc, ok := resp.Body.(io.ReadWriteCloser); ok && resp.StatusCode == http.StatusSwitchingProtocols {
+			resp.Body = &releasingConn{ReadWriteCloser: rwc, release: release}
+		} else {
+			resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
+		}
+		return resp, nil
+	}
+	return nil, lastErr
+}
+
+// releasingBody releases its backend once the response is read.
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (body *releasingBody) Close() error {
+	defer body.release()
+	return body.ReadCloser.Close()
+}
+
+// releasingConn releases its backend once an upgraded connection, such as a
+// WebSocket, is closed.
+type releasingConn struct {
+	io.ReadWriteCloser
+	release func()
+}
+
+func (conn *releasingConn) Close() error {
+	defer conn.release()
+	return conn.ReadWriteCloser.Close()
+}
+
+// writeJSON writes v as the JSON response of a coordinator endpoint.
+func writeJSON(w http.ResponseWriter, statusCode int, v interface{}) {
+	responseBytes, err := json.Marshal(v)
+	if err != nil {
+		statusCode = http.StatusInternalServerError
+		responseBytes = []byte(`{"code": "unexpected_error", "message": "failed to marshal response"}`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(responseBytes)
+}
+
+// proxyError answers requests the transport could not forward, in the error
+// format of the prover.
+func proxyError(w http.ResponseWriter, r *http.Request, err error) {
+	if r.Context().Err() != nil {
+		return
+	}
+	if errors.Is(err, errNoBackend) {
+		unroutedCounter.Inc()
+		w.Header().Set("Retry-After", "1")
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"code": "no_backend", "message": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusBadGateway, map[string]string{"code": "backend_unreachable", "message": err.Error()})
+}
+
+// Handler returns the endpoints of the coordinator: the forwarded routes,
+// /health, /ready, ready while a backend is healthy, and /backends, the
+// status of every backend.
+func (c *Coordinator) Handler() http.Handler {
+	proxy := &httputil.ReverseProxy{
+		// The transport picks the backend.
+		Director:     func(*http.Request) {},
+		Transport:    c,
+		ErrorHandler: proxyError,
+	}
+	mux := http.NewServeMux()
+	for _, route := range routes {
+		mux.Handle(route, proxy)
+	}
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	mux.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
+		if c.pick(nil) == nil {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "degraded", "reason": "no backend is healthy"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+	})
+	mux.HandleFunc("/backends", func(w http.ResponseWriter, r *http.Request) {
+		statuses := make([]backendStatus, len(c.backends))
+		for i, b := range c.backends {
+			statuses[i] = b.status()
+		}
+		writeJSON(w, http.StatusOK, statuses)
+	})
+	return mux
+}
+
+// Run checks the backends, then serves the endpoints of the coordinator and
+// checks the backends every HealthInterval until ctx is done. Requests in
+// flight are completed before it returns.
+func (c *Coordinator) Run(ctx context.Context) error {
+	listener, err := net.Listen("tcp", c.config.Address)
+	if err != nil {
+		return err
+	}
+	if c.config.MetricsAddress != "" {
+		metricsListener, err := net.Listen("tcp", c.config.MetricsAddress)
+		if err != nil {
+			listener.Close()
+			return err
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.Handler())
+		metricsServer := &http.Server{Handler: mux}
+		go metricsServer.Serve(metricsListener)
+		defer metricsServer.Close()
+		logging.Logger().Info().Str("addr", c.config.MetricsAddress).Msg("metrics server started")
+	}
+	c.checkAll(ctx)
+	go func() {
+		ticker := time.NewTicker(c.config.HealthInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				c.checkAll(ctx)
+			}
+		}
+	}()
+
+	server := &http.Server{Handler: c.Handler()}
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(listener) }()
+	logging.Logger().Info().Str("addr", c.config.Address).Int("backends", len(c.backends)).Msg("coordinator started")
+	select {
+	case err = <-served:
+		return err
+	case <-ctx.Done():
+	}
+	logging.Logger().Info().Msg("shutting down coordinator")
+	return server.Shutdown(context.Background())
+}
@@ -0,0 +1,155 @@
+package coordinator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testBackend serves /ready, /autoscale with running proofs and /prove,
+// answering with prove.
+func testBackend(t *testing.T, running int, prove http.HandlerFunc) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status": "ready"}`))
+	})
+	mux.HandleFunc("/autoscale", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"queueDepth": 0, "runningProofs": %d, "capacity": 1}`, running)
+	})
+	mux.HandleFunc("/prove", prove)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// answer answers /prove with name and the body of the request.
+func answer(name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s:%s", name, body)
+	}
+}
+
+func newTestCoordinator(t *testing.T, backends ...string) (*Coordinator, *httptest.Server) {
+	c, err := New(Config{Backends: backends})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.checkAll(context.Background())
+	server := httptest.NewServer(c.Handler())
+	t.Cleanup(server.Close)
+	return c, server
+}
+
+func post(t *testing.T, url string) (int, string) {
+	resp, err := http.Post(url+"/prove", "application/json", strings.NewReader("params"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+func TestCoordinatorRoutesToLeastLoaded(t *testing.T) {
+	busy := testBackend(t, 2, answer("busy"))
+	idle := testBackend(t, 0, answer("idle"))
+	_, server := newTestCoordinator(t, busy.URL, idle.URL)
+	if status, body := post(t, server.URL); status != http.StatusOK || body != "idle:params" {
+		t.Fatalf("expected the idle backend, got %d %s", status, body)
+	}
+}
+
+func TestCoordinatorFailsOver(t *testing.T) {
+	draining := testBackend(t, 0, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"code": "shutting_down", "message": "draining"}`))
+	})
+	down := testBackend(t, 0, answer("down"))
+	up := testBackend(t, 1, answer("up"))
+	c, server := newTestCoordinator(t, draining.URL, down.URL, up.URL)
+	down.Close()
+	// The draining backend is tried first, then the unreachable one, as
+	// they are less loaded; the body is sent again to the last.
+	if status, body := post(t, server.URL); status != http.StatusOK || body != "up:params" {
+		t.Fatalf("expected the healthy backend, got %d %s", status, body)
+	}
+	if c.backends[1].isHealthy() {
+		t.Fatal("the unreachable backend is still healthy")
+	}
+	if !c.backends[0].isHealthy() {
+		t.Fatal("a 503 answer must not take the backend down")
+	}
+}
+
+func TestCoordinatorWithoutBackends(t *testing.T) {
+	draining := testBackend(t, 0, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"code": "shutting_down", "message": "draining"}`))
+	})
+	c, server := newTestCoordinator(t, draining.URL)
+	// The answer of the last backend is returned as is.
+	if status, body := post(t, server.URL); status != http.StatusServiceUnavailable || !strings.Contains(body, "shutting_down") {
+		t.Fatalf("expected the answer of the backend, got %d %s", status, body)
+	}
+
+	c.backends[0].setHealth(false, "down")
+	status, body := post(t, server.URL)
+	var response struct{ Code string }
+	json.Unmarshal([]byte(body), &response)
+	if status != http.StatusServiceUnavailable || response.Code != "no_backend" {
+		t.Fatalf("expected no_backend, got %d %s", status, body)
+	}
+	resp, err := http.Get(server.URL + "/ready")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected the coordinator not to be ready, got %d", resp.StatusCode)
+	}
+}
+
+func TestCoordinatorHealthCheck(t *testing.T) {
+	ready := true
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
+		if !ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"status": "degraded", "reason": "no proving keys are loaded"}`))
+		}
+	})
+	backend := httptest.NewServer(mux)
+	defer backend.Close()
+	c, err := New(Config{Backends: []string{backend.URL + "/"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.checkAll(context.Background())
+	status := c.backends[0].status()
+	// Backends without /autoscale count as one slot.
+	if !status.Healthy || status.Capacity != 1 {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+	ready = false
+	c.checkAll(context.Background())
+	if status = c.backends[0].status(); status.Healthy || !strings.Contains(status.Reason, "no proving keys") {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+}
+
+func TestNewRejectsInvalidBackends(t *testing.T) {
+	for _, backends := range [][]string{nil, {"prover:3001"}, {"ftp://prover"}} {
+		if _, err := New(Config{Backends: backends}); err == nil {
+			t.Fatalf("expected an error for %v", backends)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package coordinator
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Outcomes of the attempts of forwarded requests.
+const (
+	outcomeForwarded   = "forwarded"
+	outcomeUnavailable = "unavailable"
+	outcomeUnreachable = "unreachable"
+)
+
+var (
+	backendUpGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "prover_coordinator_backend_up",
+		Help: "Whether a backend of the coordinator passed its last health check.",
+	}, []string{"backend"})
+	backendInFlightGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "prover_coordinator_backend_in_flight",
+		Help: "Number of requests the coordinator is waiting on a backend for.",
+	}, []string{"backend"})
+	attemptsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "prover_coordinator_attempts_total",
+		Help: "Number of requests sent to a backend by the coordinator, by outcome: forwarded (answered), unavailable (answered 503, retried on another backend) or unreachable.",
+	}, []string{"backend", "outcome"})
+	unroutedCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "prover_coordinator_unrouted_requests_total",
+		Help: "Number of requests the coordinator answered itself, no healthy backend being left to send them to.",
+	})
+)
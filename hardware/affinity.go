@@ -0,0 +1,119 @@
+package hardware
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// NUMANode is a memory node of the host and the CPUs local to it.
+type NUMANode struct {
+	ID   int   `json:"id"`
+	CPUs []int `json:"cpus"`
+}
+
+// ParseCPUList parses a CPU list in the format of the kernel and taskset,
+// such as "0-3,8,10-11", into sorted, distinct CPU numbers.
+func ParseCPUList(list string) ([]int, error) {
+	seen := make(map[int]bool)
+	for _, part := range strings.Split(strings.TrimSpace(list), ",") {
+		if part == "" {
+			continue
+		}
+		first, last, isRange := strings.Cut(part, "-")
+		start, err := strconv.Atoi(first)
+		if err != nil || start < 0 {
+			return nil, fmt.Errorf("invalid CPU list %q: bad CPU %q", list, first)
+		}
+		end := start
+		if isRange {
+			if end, err = strconv.Atoi(last); err != nil || end < start {
+				return nil, fmt.Errorf("invalid CPU list %q: bad range %q", list, part)
+			}
+		}
+		for cpu := start; cpu <= end; cpu++ {
+			seen[cpu] = true
+		}
+	}
+	if len(seen) == 0 {
+		return nil, fmt.Errorf("invalid CPU list %q: no CPUs", list)
+	}
+	cpus := make([]int, 0, len(seen))
+	for cpu := range seen {
+		cpus = append(cpus, cpu)
+	}
+	sort.Ints(cpus)
+	return cpus, nil
+}
+
+// FormatCPUList formats sorted CPU numbers as a CPU list, merging runs into
+// ranges.
+func FormatCPUList(cpus []int) string {
+	var parts []string
+	for i := 0; i < len(cpus); {
+		j := i
+		for j+1 < len(cpus) && cpus[j+1] == cpus[j]+1 {
+			j++
+		}
+		if i == j {
+			parts = append(parts, strconv.Itoa(cpus[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", cpus[i], cpus[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
+
+// NodeCPUs returns the CPUs of the NUMA node id of the profile.
+func (p *Profile) NodeCPUs(id int) ([]int, error) {
+	for _, node := range p.NUMANodes {
+		if node.ID == id {
+			return node.CPUs, nil
+		}
+	}
+	if len(p.NUMANodes) == 0 {
+		return nil, fmt.Errorf("NUMA node %d not found, the host reports no NUMA nodes", id)
+	}
+	return nil, fmt.Errorf("NUMA node %d not found, the host has %d node(s)", id, len(p.NUMANodes))
+}
+
+// PartitionCPUs splits cpus between n concurrent proofs: a NUMA node each if
+// cpus span n nodes of the profile, so that the memory of every proof stays
+// local to its CPUs, or else runs of consecutive CPUs of equal sizes.
+func (p *Profile) PartitionCPUs(cpus []int, n int) ([][]int, error) {
+	if n <= 0 || n > len(cpus) {
+		return nil, fmt.Errorf("cannot split %d CPUs between %d partitions", len(cpus), n)
+	}
+	included := make(map[int]bool, len(cpus))
+	for _, cpu := range cpus {
+		included[cpu] = true
+	}
+	var nodes [][]int
+	for _, node := range p.NUMANodes {
+		var local []int
+		for _, cpu := range node.CPUs {
+			if included[cpu] {
+				local = append(local, cpu)
+			}
+		}
+		if len(local) > 0 {
+			nodes = append(nodes, local)
+		}
+	}
+	if len(nodes) == n {
+		return nodes, nil
+	}
+	partitions := make([][]int, n)
+	start := 0
+	for i := range partitions {
+		size := len(cpus) / n
+		if i < len(cpus)%n {
+			size++
+		}
+		partitions[i] = cpus[start : start+size]
+		start += size
+	}
+	return partitions, nil
+}
@@ -0,0 +1,107 @@
+package hardware
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// numaNodes lists the NUMA nodes of /sys/devices/system/node, leaving out the
+// nodes without CPUs, such as those of memory expanders.
+func numaNodes() []NUMANode {
+	matches, _ := filepath.Glob("/sys/devices/system/node/node[0-9]*")
+	var nodes []NUMANode
+	for _, dir := range matches {
+		id, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(dir), "node"))
+		if err != nil {
+			continue
+		}
+		list, err := os.ReadFile(filepath.Join(dir, "cpulist"))
+		if err != nil {
+			continue
+		}
+		cpus, err := ParseCPUList(string(list))
+		if err != nil {
+			continue
+		}
+		nodes = append(nodes, NUMANode{ID: id, CPUs: cpus})
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+	return nodes
+}
+
+// allowedCPUs lists the CPUs the process may run on.
+func allowedCPUs() []int {
+	var set unix.CPUSet
+	if err := unix.SchedGetaffinity(0, &set); err != nil {
+		return nil
+	}
+	var cpus []int
+	for cpu := 0; cpu < len(set)*64; cpu++ {
+		if set.IsSet(cpu) {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus
+}
+
+// PinCPUs restricts every thread of the process to cpus. Affinity is set per
+// thread on Linux and Go moves goroutines between its threads, so the whole
+// process is pinned: threads started afterwards inherit the affinity of the
+// thread starting them. Memory is allocated on the node of the CPU first
+// touching it, so pinning before loading the keys keeps them local to it.
+func PinCPUs(cpus []int) error {
+	var set unix.CPUSet
+	for _, cpu := range cpus {
+		set.Set(cpu)
+	}
+	pinned := make(map[int]bool)
+	// Threads may be started while pinning, by threads not pinned yet, so
+	// the threads are listed again until none is new.
+	for {
+		entries, err := os.ReadDir("/proc/self/task")
+		if err != nil {
+			return err
+		}
+		added := false
+		for _, e := range entries {
+			tid, err := strconv.Atoi(e.Name())
+			if err != nil || pinned[tid] {
+				continue
+			}
+			if err := unix.SchedSetaffinity(tid, &set); err != nil && err != unix.ESRCH {
+				return fmt.Errorf("pinning thread %d to CPUs %s: %w", tid, FormatCPUList(cpus), err)
+			}
+			pinned[tid] = true
+			added = true
+		}
+		if !added {
+			return nil
+		}
+	}
+}
+
+// PinThread restricts the calling thread to cpus and returns a function
+// restoring its previous affinity. The calling goroutine must be locked to
+// its thread with runtime.LockOSThread, as Go would otherwise move it to
+// another, and the threads Go starts meanwhile do not inherit the affinity.
+func PinThread(cpus []int) (func() error, error) {
+	var previous, set unix.CPUSet
+	if err := unix.SchedGetaffinity(0, &previous); err != nil {
+		return nil, err
+	}
+	for _, cpu := range cpus {
+		set.Set(cpu)
+	}
+	if err := unix.SchedSetaffinity(0, &set); err != nil {
+		return nil, fmt.Errorf("pinning thread to CPUs %s: %w", FormatCPUList(cpus), err)
+	}
+	return func() error {
+		return unix.SchedSetaffinity(0, &previous)
+	}, nil
+}
@@ -0,0 +1,65 @@
+package hardware
+
+import (
+	"runtime"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestPinCPUs(t *testing.T) {
+	var current unix.CPUSet
+	if err := unix.SchedGetaffinity(0, &current); err != nil {
+		t.Skip(err)
+	}
+	var cpus []int
+	for cpu := 0; cpu < len(current)*64; cpu++ {
+		if current.IsSet(cpu) {
+			cpus = append(cpus, cpu)
+		}
+	}
+	// Pinning to the current CPUs leaves the process as it is.
+	if err := PinCPUs(cpus); err != nil {
+		t.Fatal(err)
+	}
+	var pinned unix.CPUSet
+	if err := unix.SchedGetaffinity(0, &pinned); err != nil {
+		t.Fatal(err)
+	}
+	if pinned != current {
+		t.Fatalf("affinity changed: %v, expected %v", pinned, current)
+	}
+}
+
+func TestPinThread(t *testing.T) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	var process unix.CPUSet
+	if err := unix.SchedGetaffinity(0, &process); err != nil {
+		t.Skip(err)
+	}
+	var cpu int
+	for !process.IsSet(cpu) {
+		cpu++
+	}
+	restore, err := PinThread([]int{cpu})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pinned unix.CPUSet
+	if err = unix.SchedGetaffinity(0, &pinned); err != nil {
+		t.Fatal(err)
+	}
+	if pinned.Count() != 1 || !pinned.IsSet(cpu) {
+		t.Fatalf("expected the thread to be pinned to CPU %d, got %v", cpu, pinned)
+	}
+	if err = restore(); err != nil {
+		t.Fatal(err)
+	}
+	if err = unix.SchedGetaffinity(0, &pinned); err != nil {
+		t.Fatal(err)
+	}
+	if pinned != process {
+		t.Fatalf("expected the affinity of the thread to be restored, got %v", pinned)
+	}
+}
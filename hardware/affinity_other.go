@@ -0,0 +1,24 @@
+//go:build !linux
+
+package hardware
+
+import "fmt"
+
+func numaNodes() []NUMANode {
+	return nil
+}
+
+func allowedCPUs() []int {
+	return nil
+}
+
+// PinCPUs restricts the process to cpus, which is only supported on Linux.
+func PinCPUs(cpus []int) error {
+	return fmt.Errorf("CPU affinity is only supported on Linux")
+}
+
+// PinThread restricts the calling thread to cpus, which is only supported on
+// Linux.
+func PinThread(cpus []int) (func() error, error) {
+	return nil, fmt.Errorf("CPU affinity is only supported on Linux")
+}
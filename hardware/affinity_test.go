@@ -0,0 +1,85 @@
+package hardware
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestParseCPUList(t *testing.T) {
+	for _, test := range []struct {
+		list string
+		cpus []int
+	}{
+		{"0", []int{0}},
+		{"0-3", []int{0, 1, 2, 3}},
+		{"8-9,0-1,4\n", []int{0, 1, 4, 8, 9}},
+		{"0-2,1-3", []int{0, 1, 2, 3}},
+	} {
+		cpus, err := ParseCPUList(test.list)
+		if err != nil {
+			t.Fatalf("%q: %v", test.list, err)
+		}
+		if !reflect.DeepEqual(cpus, test.cpus) {
+			t.Fatalf("%q: expected %v, got %v", test.list, test.cpus, cpus)
+		}
+	}
+	for _, list := range []string{"", "a", "3-1", "-1", "0-"} {
+		if _, err := ParseCPUList(list); err == nil {
+			t.Fatalf("%q: expected an error", list)
+		}
+	}
+}
+
+func TestFormatCPUList(t *testing.T) {
+	if list := FormatCPUList([]int{0, 1, 2, 3, 8, 10, 11}); list != "0-3,8,10-11" {
+		t.Fatalf("unexpected list: %s", list)
+	}
+	cpus, _ := ParseCPUList("0-15,32-47")
+	if list := FormatCPUList(cpus); list != "0-15,32-47" {
+		t.Fatalf("list not round-tripped: %s", list)
+	}
+}
+
+func TestNodeCPUs(t *testing.T) {
+	profile := Profile{NUMANodes: []NUMANode{{ID: 0, CPUs: []int{0, 1}}, {ID: 1, CPUs: []int{2, 3}}}}
+	cpus, err := profile.NodeCPUs(1)
+	if err != nil || !reflect.DeepEqual(cpus, []int{2, 3}) {
+		t.Fatalf("unexpected CPUs of node 1: %v, %v", cpus, err)
+	}
+	if _, err = profile.NodeCPUs(2); err == nil {
+		t.Fatal("expected an error for a missing node")
+	}
+	if _, err = (&Profile{}).NodeCPUs(0); err == nil {
+		t.Fatal("expected an error without NUMA nodes")
+	}
+}
+
+func TestPartitionCPUs(t *testing.T) {
+	profile := &Profile{NUMANodes: []NUMANode{{ID: 0, CPUs: []int{0, 1, 2, 3}}, {ID: 1, CPUs: []int{4, 5, 6, 7}}}}
+	cpus := []int{0, 1, 2, 3, 4, 5, 6, 7}
+	for _, test := range []struct {
+		cpus       []int
+		n          int
+		partitions string
+	}{
+		// Two proofs get a node each.
+		{cpus, 2, "[[0 1 2 3] [4 5 6 7]]"},
+		{cpus, 3, "[[0 1 2] [3 4 5] [6 7]]"},
+		{cpus, 1, "[[0 1 2 3 4 5 6 7]]"},
+		// The CPUs of the nodes outside cpus are left out.
+		{[]int{2, 3, 4, 5}, 2, "[[2 3] [4 5]]"},
+		{[]int{0, 1, 2}, 2, "[[0 1] [2]]"},
+	} {
+		partitions, err := profile.PartitionCPUs(test.cpus, test.n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(partitions) != test.partitions {
+			t.Errorf("%v in %d: expected %s, got %v", test.cpus, test.n, test.partitions, partitions)
+		}
+	}
+	if _, err := profile.PartitionCPUs([]int{0}, 2); err == nil {
+		t.Fatal("expected a CPU not to be split")
+	}
+}
@@ -0,0 +1,88 @@
+// Package hardware detects the capabilities of the host and derives the
+// proving configuration best suited to it.
+package hardware
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"golang.org/x/sys/cpu"
+)
+
+// Profile describes the capabilities of the host relevant to proving.
+type Profile struct {
+	Arch   string `json:"arch"`
+	NumCPU int    `json:"numCpu"`
+	// CPUs are the CPUs the process may run on, on Linux.
+	CPUs            []int      `json:"cpus,omitempty"`
+	CPUFeatures     []string   `json:"cpuFeatures"`
+	TotalMemory     uint64     `json:"totalMemory"`
+	AvailableMemory uint64     `json:"availableMemory"`
+	GPUs            []string   `json:"gpus"`
+	NUMANodes       []NUMANode `json:"numaNodes,omitempty"`
+}
+
+// HasFeature reports whether the CPU supports the named feature.
+func (p *Profile) HasFeature(name string) bool {
+	for _, f := range p.CPUFeatures {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Detect inspects the host. Values that cannot be determined on the current
+// platform are left empty.
+func Detect() Profile {
+	total, available := memoryInfo()
+	return Profile{
+		Arch:            runtime.GOARCH,
+		NumCPU:          runtime.NumCPU(),
+		CPUs:            allowedCPUs(),
+		CPUFeatures:     cpuFeatures(),
+		TotalMemory:     total,
+		AvailableMemory: available,
+		GPUs:            gpus(),
+		NUMANodes:       numaNodes(),
+	}
+}
+
+func cpuFeatures() []string {
+	var features []string
+	add := func(name string, present bool) {
+		if present {
+			features = append(features, name)
+		}
+	}
+	switch runtime.GOARCH {
+	case "amd64":
+		add("adx", cpu.X86.HasADX)
+		add("bmi2", cpu.X86.HasBMI2)
+		add("avx2", cpu.X86.HasAVX2)
+		add("avx512f", cpu.X86.HasAVX512F)
+		add("avx512ifma", cpu.X86.HasAVX512IFMA)
+	case "arm64":
+		add("asimd", cpu.ARM64.HasASIMD)
+		add("sha3", cpu.ARM64.HasSHA3)
+	}
+	return features
+}
+
+// gpus lists the NVIDIA devices exposed by the driver.
+func gpus() []string {
+	var devices []string
+	entries, err := os.ReadDir("/proc/driver/nvidia/gpus")
+	if err == nil {
+		for _, e := range entries {
+			devices = append(devices, e.Name())
+		}
+		return devices
+	}
+	matches, _ := filepath.Glob("/dev/nvidia[0-9]*")
+	for _, m := range matches {
+		devices = append(devices, filepath.Base(m))
+	}
+	return devices
+}
@@ -0,0 +1,7 @@
+//go:build !linux
+
+package hardware
+
+func memoryInfo() (total uint64, available uint64) {
+	return 0, 0
+}
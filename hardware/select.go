@@ -0,0 +1,82 @@
+package hardware
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Overrides pins parts of the selection. Zero values leave the choice to
+// Select.
+type Overrides struct {
+	Threads int
+	// ReservedCPUs are kept from the process, and so from its proofs, when
+	// Threads is not pinned: gnark starts a goroutine per CPU whatever the
+	// threads, so proofs would otherwise take every CPU the process runs
+	// on. The first CPUs are reserved, as they take most interrupts.
+	ReservedCPUs int
+	// CPUs the process is pinned to, which replace the CPUs of the host when
+	// deriving the threads.
+	CPUs []int
+}
+
+// Selection is the proving configuration chosen for a host.
+type Selection struct {
+	Profile Profile `json:"profile"`
+	Threads int     `json:"threads"`
+	// ProvingCPUs are the CPUs the process is to be pinned to in order to
+	// keep it off the reserved CPUs, nil if none are reserved or the CPUs of
+	// the process are unknown.
+	ProvingCPUs []int    `json:"provingCpus,omitempty"`
+	GPU         bool     `json:"gpu"`
+	Reasons     []string `json:"reasons"`
+}
+
+// Select picks the proving configuration for the given host.
+func Select(profile Profile, overrides Overrides) Selection {
+	selection := Selection{Profile: profile}
+	reason := func(format string, args ...interface{}) {
+		selection.Reasons = append(selection.Reasons, fmt.Sprintf(format, args...))
+	}
+
+	numCPU, cpus := profile.NumCPU, profile.CPUs
+	if len(overrides.CPUs) > 0 {
+		cpus = overrides.CPUs
+		reason("pinned to CPUs %s", FormatCPUList(overrides.CPUs))
+	}
+	if len(cpus) > 0 {
+		numCPU = len(cpus)
+	}
+	if overrides.Threads > 0 {
+		selection.Threads = overrides.Threads
+		reason("using %d threads as configured", overrides.Threads)
+	} else if reserved := overrides.ReservedCPUs; reserved > 0 && len(cpus) > 1 {
+		if reserved >= numCPU {
+			reserved = numCPU - 1
+		}
+		selection.ProvingCPUs = cpus[reserved:]
+		selection.Threads = len(selection.ProvingCPUs)
+		reason("proving on CPUs %s, keeping the process off CPUs %s", FormatCPUList(selection.ProvingCPUs), FormatCPUList(cpus[:reserved]))
+	} else {
+		if overrides.ReservedCPUs > 0 && len(cpus) == 0 {
+			reason("the CPUs of the process are unknown, so none can be reserved")
+		}
+		selection.Threads = numCPU
+		reason("using all %d CPUs for proving", numCPU)
+	}
+
+	if profile.Arch == "amd64" {
+		if profile.HasFeature("adx") && profile.HasFeature("bmi2") {
+			reason("ADX and BMI2 available, field arithmetic uses the assembly implementation")
+		} else {
+			reason("ADX or BMI2 missing, field arithmetic falls back to the generic implementation")
+		}
+	}
+
+	// The groth16 backend only proves on the CPU.
+	selection.GPU = false
+	if len(profile.GPUs) > 0 {
+		reason("%d GPU(s) detected (%s) but the proving backend has no GPU support", len(profile.GPUs), strings.Join(profile.GPUs, ", "))
+	}
+
+	return selection
+}
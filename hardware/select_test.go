@@ -0,0 +1,66 @@
+package hardware
+
+import "testing"
+
+func TestSelectThreads(t *testing.T) {
+	profile := Profile{Arch: "amd64", NumCPU: 8, AvailableMemory: 16 << 30}
+	if selection := Select(profile, Overrides{}); selection.Threads != 8 {
+		t.Fatalf("expected 8 threads, got %d", selection.Threads)
+	}
+	if selection := Select(profile, Overrides{Threads: 2}); selection.Threads != 2 {
+		t.Fatalf("overrides not applied: %+v", selection)
+	}
+}
+
+func TestSelectReservedCPUs(t *testing.T) {
+	for _, test := range []struct {
+		cpus, reserved, threads int
+	}{
+		{8, 1, 7},
+		{8, 0, 8},
+		{8, 10, 1},
+		{1, 1, 1},
+	} {
+		cpus := make([]int, test.cpus)
+		for i := range cpus {
+			cpus[i] = i
+		}
+		selection := Select(Profile{NumCPU: test.cpus, CPUs: cpus}, Overrides{ReservedCPUs: test.reserved})
+		if selection.Threads != test.threads {
+			t.Fatalf("%d CPUs, %d reserved: expected %d threads, got %d", test.cpus, test.reserved, test.threads, selection.Threads)
+		}
+	}
+	// Known CPUs are reserved by pinning the process off the first ones.
+	selection := Select(Profile{NumCPU: 4, CPUs: []int{0, 1, 2, 3}}, Overrides{ReservedCPUs: 1})
+	if selection.Threads != 3 || FormatCPUList(selection.ProvingCPUs) != "1-3" {
+		t.Fatalf("expected to prove on CPUs 1-3, got %d threads on %v", selection.Threads, selection.ProvingCPUs)
+	}
+	if selection = Select(Profile{NumCPU: 4, CPUs: []int{0, 1, 2, 3}}, Overrides{}); selection.ProvingCPUs != nil {
+		t.Fatalf("expected no CPU to be reserved, got %v", selection.ProvingCPUs)
+	}
+	// Without affinity, the process cannot be kept off any CPU.
+	if selection = Select(Profile{NumCPU: 4}, Overrides{ReservedCPUs: 1}); selection.Threads != 4 || selection.ProvingCPUs != nil {
+		t.Fatalf("expected every CPU to prove, got %d threads on %v", selection.Threads, selection.ProvingCPUs)
+	}
+	// Pinned threads are used as they are.
+	if selection := Select(Profile{NumCPU: 8}, Overrides{Threads: 8, ReservedCPUs: 1}); selection.Threads != 8 {
+		t.Fatalf("expected the pinned threads, got %d", selection.Threads)
+	}
+}
+
+func TestSelectNeverEnablesGPU(t *testing.T) {
+	selection := Select(Profile{NumCPU: 1, GPUs: []string{"nvidia0"}}, Overrides{})
+	if selection.GPU {
+		t.Fatal("GPU proving is not supported by the backend")
+	}
+}
+
+func TestSelectPinnedCPUs(t *testing.T) {
+	selection := Select(Profile{NumCPU: 64}, Overrides{ReservedCPUs: 1, CPUs: []int{0, 1, 2, 3, 32, 33, 34, 35}})
+	if selection.Threads != 7 || FormatCPUList(selection.ProvingCPUs) != "1-3,32-35" {
+		t.Fatalf("expected the threads of the pinned CPUs, got %d on %v", selection.Threads, selection.ProvingCPUs)
+	}
+	if selection.Reasons[0] != "pinned to CPUs 0-3,32-35" {
+		t.Fatalf("unexpected reason: %s", selection.Reasons[0])
+	}
+}
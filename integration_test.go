@@ -1,10 +1,17 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"io"
+	"math/big"
+	"net"
 	"net/http"
 	"strings"
 	"testing"
+	"time"
+	"worldcoin/gnark-mbu/client"
+	"worldcoin/gnark-mbu/jobstore"
 	"worldcoin/gnark-mbu/logging"
 	"worldcoin/gnark-mbu/prover"
 	"worldcoin/gnark-mbu/server"
@@ -13,18 +20,30 @@ import (
 const ProverAddress = "localhost:8080"
 const MetricsAddress = "localhost:9999"
 
+// verifyingSystem is the verifying part of the proving system of the server.
+var verifyingSystem *prover.VerifyingSystem
+
 func TestMain(m *testing.M) {
 	logging.Logger().Info().Msg("Setting up the prover")
-	ps, err := prover.Setup(3, 2)
+	// The keys are set up from a fixed seed, so that they are the same on
+	// every run.
+	var ps *prover.ProvingSystem
+	err := withSeed("integration", func() (err error) {
+		ps, err = prover.Setup(context.Background(), 3, 2)
+		return err
+	})
 	if err != nil {
 		panic(err)
 	}
+	verifyingSystem = ps.VerifyingSystem()
 	cfg := server.Config{
 		ProverAddress:  ProverAddress,
 		MetricsAddress: MetricsAddress,
+		Jobs:           jobstore.NewMemory(),
 	}
 	logging.Logger().Info().Msg("Starting the server")
 	instance := server.Run(&cfg, ps)
+	waitForServer(ProverAddress)
 	logging.Logger().Info().Msg("Running the tests")
 	defer func() {
 		instance.RequestStop()
@@ -33,6 +52,20 @@ func TestMain(m *testing.M) {
 	m.Run()
 }
 
+// waitForServer blocks until the server listens on the given address, as
+// server.Run returns before the listeners are bound.
+func waitForServer(address string) {
+	for i := 0; i < 100; i++ {
+		conn, err := net.Dial("tcp", address)
+		if err == nil {
+			conn.Close()
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	panic("server did not start listening on " + address)
+}
+
 func TestWrongMethod(t *testing.T) {
 	response, err := http.Get("http://localhost:8080/prove")
 	if err != nil {
@@ -63,6 +96,33 @@ func TestHappyPath(t *testing.T) {
 	}
 }
 
+func TestHappyPathWithMetadata(t *testing.T) {
+	body := `{
+		"inputHash":"0x5057a31740d54d42ac70c05e0768fb770c682cb2c559bdd03fe4099f7e584e4f",
+		"startIndex":0,
+		"preRoot":"0x18f43331537ee2af2e3d758d50f72106467c6eea50371dd528d57eb2b856d238",
+		"postRoot":"0x2267bee7aae8ed55eb9aecff101145335ed1dd0a5a276a2b7eb3ae7d20e232d8",
+		"identityCommitments":["0x1","0x2"],
+		"merkleProofs": [
+			["0x0","0x2098f5fb9e239eab3ceac3f27b81e481dc3124d55ffed523a839ee8446b64864","0x1069673dcdb12263df301a6ff584a7ec261a44cb9dc68df067a4774460b1f1e1"],
+			["0x1","0x2098f5fb9e239eab3ceac3f27b81e481dc3124d55ffed523a839ee8446b64864","0x1069673dcdb12263df301a6ff584a7ec261a44cb9dc68df067a4774460b1f1e1"]
+		]}`
+	response, err := http.Post("http://localhost:8080/prove?include_metadata=true", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, response.StatusCode)
+	}
+	responseBody, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(responseBody), `"postRoot":"0x2267bee7aae8ed55eb9aecff101145335ed1dd0a5a276a2b7eb3ae7d20e232d8"`) {
+		t.Fatalf("Expected the metadata to echo the post root, got %s", string(responseBody))
+	}
+}
+
 func TestWrongInput(t *testing.T) {
 	body := `{
 		"inputHash":"0x5057a31740d54d42ac70c05e0768fb770c682cb2c559bdd03fe4099f7e584e4f",
@@ -85,7 +145,304 @@ func TestWrongInput(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if !strings.Contains(string(responseBody), "proving_error") {
-		t.Fatalf("Expected error message to be tagged with 'proving_error', got %s", string(responseBody))
+	if !strings.Contains(string(responseBody), "root_mismatch") {
+		t.Fatalf("Expected error message to be tagged with 'root_mismatch', got %s", string(responseBody))
+	}
+}
+
+func TestInputHashMismatch(t *testing.T) {
+	body := `{
+		"inputHash":"0x1",
+		"startIndex":0,
+		"preRoot":"0x18f43331537ee2af2e3d758d50f72106467c6eea50371dd528d57eb2b856d238",
+		"postRoot":"0x2267bee7aae8ed55eb9aecff101145335ed1dd0a5a276a2b7eb3ae7d20e232d8",
+		"identityCommitments":["0x1","0x2"],
+		"merkleProofs": [
+			["0x0","0x2098f5fb9e239eab3ceac3f27b81e481dc3124d55ffed523a839ee8446b64864","0x1069673dcdb12263df301a6ff584a7ec261a44cb9dc68df067a4774460b1f1e1"],
+			["0x1","0x2098f5fb9e239eab3ceac3f27b81e481dc3124d55ffed523a839ee8446b64864","0x1069673dcdb12263df301a6ff584a7ec261a44cb9dc68df067a4774460b1f1e1"]
+		]}`
+	response, err := http.Post("http://localhost:8080/prove", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected status code %d, got %d", http.StatusBadRequest, response.StatusCode)
+	}
+	responseBody, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(responseBody), "input_hash_mismatch") {
+		t.Fatalf("Expected error message to be tagged with 'input_hash_mismatch', got %s", string(responseBody))
+	}
+}
+
+func TestVerify(t *testing.T) {
+	body := `{
+		"inputHash":"0x5057a31740d54d42ac70c05e0768fb770c682cb2c559bdd03fe4099f7e584e4f",
+		"startIndex":0,
+		"preRoot":"0x18f43331537ee2af2e3d758d50f72106467c6eea50371dd528d57eb2b856d238",
+		"postRoot":"0x2267bee7aae8ed55eb9aecff101145335ed1dd0a5a276a2b7eb3ae7d20e232d8",
+		"identityCommitments":["0x1","0x2"],
+		"merkleProofs": [
+			["0x0","0x2098f5fb9e239eab3ceac3f27b81e481dc3124d55ffed523a839ee8446b64864","0x1069673dcdb12263df301a6ff584a7ec261a44cb9dc68df067a4774460b1f1e1"],
+			["0x1","0x2098f5fb9e239eab3ceac3f27b81e481dc3124d55ffed523a839ee8446b64864","0x1069673dcdb12263df301a6ff584a7ec261a44cb9dc68df067a4774460b1f1e1"]
+		]}`
+	response, err := http.Post("http://localhost:8080/prove", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for inputHash, valid := range map[string]bool{
+		"0x5057a31740d54d42ac70c05e0768fb770c682cb2c559bdd03fe4099f7e584e4f": true,
+		"0x1": false,
+	} {
+		request := `{"inputHash":"` + inputHash + `","proof":` + string(proof) + `}`
+		response, err = http.Post("http://localhost:8080/verify", "application/json", strings.NewReader(request))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d", http.StatusOK, response.StatusCode)
+		}
+		responseBody, err := io.ReadAll(response.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(responseBody), `"valid":true`) != valid {
+			t.Fatalf("Expected valid to be %t for input hash %s, got %s", valid, inputHash, string(responseBody))
+		}
+	}
+}
+
+func TestVerifierMode(t *testing.T) {
+	cfg := server.Config{
+		ProverAddress:  "localhost:8081",
+		MetricsAddress: "localhost:9997",
+		Mode:           server.ModeVerifier,
+	}
+	instance := server.Run(&cfg, verifyingSystem.ProvingSystem())
+	defer func() {
+		instance.RequestStop()
+		instance.AwaitStop()
+	}()
+	waitForServer(cfg.ProverAddress)
+
+	response, err := http.Post("http://localhost:8081/prove", "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expected status code %d, got %d", http.StatusNotFound, response.StatusCode)
+	}
+	body := `{
+		"inputHash":"0x5057a31740d54d42ac70c05e0768fb770c682cb2c559bdd03fe4099f7e584e4f",
+		"startIndex":0,
+		"preRoot":"0x18f43331537ee2af2e3d758d50f72106467c6eea50371dd528d57eb2b856d238",
+		"postRoot":"0x2267bee7aae8ed55eb9aecff101145335ed1dd0a5a276a2b7eb3ae7d20e232d8",
+		"identityCommitments":["0x1","0x2"],
+		"merkleProofs": [
+			["0x0","0x2098f5fb9e239eab3ceac3f27b81e481dc3124d55ffed523a839ee8446b64864","0x1069673dcdb12263df301a6ff584a7ec261a44cb9dc68df067a4774460b1f1e1"],
+			["0x1","0x2098f5fb9e239eab3ceac3f27b81e481dc3124d55ffed523a839ee8446b64864","0x1069673dcdb12263df301a6ff584a7ec261a44cb9dc68df067a4774460b1f1e1"]
+		]}`
+	response, err = http.Post("http://localhost:8080/prove", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := `{"inputHash":"0x5057a31740d54d42ac70c05e0768fb770c682cb2c559bdd03fe4099f7e584e4f","proof":` + string(proof) + `}`
+	response, err = http.Post("http://localhost:8081/verify", "application/json", strings.NewReader(request))
+	if err != nil {
+		t.Fatal(err)
+	}
+	responseBody, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusOK || !strings.Contains(string(responseBody), `"valid":true`) {
+		t.Fatalf("Expected the proof to verify, got %d %s", response.StatusCode, string(responseBody))
+	}
+	response, err = http.Get("http://localhost:8081/info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if responseBody, err = io.ReadAll(response.Body); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(responseBody), `"mode":"verifier"`) {
+		t.Fatalf("Expected the verifier mode to be reported, got %s", string(responseBody))
+	}
+}
+
+// TestDegradedVerify checks that a server whose proving keys failed to load
+// keeps verifying proofs, and serving the artifacts of the circuit, with the
+// verifying key loaded apart from them.
+func TestDegradedVerify(t *testing.T) {
+	cfg := server.Config{
+		ProverAddress:   "localhost:8082",
+		MetricsAddress:  "localhost:9996",
+		KeysError:       errors.New("corrupted proving key"),
+		VerifyingSystem: verifyingSystem,
+	}
+	instance := server.Run(&cfg, nil)
+	defer func() {
+		instance.RequestStop()
+		instance.AwaitStop()
+	}()
+	waitForServer(cfg.ProverAddress)
+
+	body := `{
+		"inputHash":"0x5057a31740d54d42ac70c05e0768fb770c682cb2c559bdd03fe4099f7e584e4f",
+		"startIndex":0,
+		"preRoot":"0x18f43331537ee2af2e3d758d50f72106467c6eea50371dd528d57eb2b856d238",
+		"postRoot":"0x2267bee7aae8ed55eb9aecff101145335ed1dd0a5a276a2b7eb3ae7d20e232d8",
+		"identityCommitments":["0x1","0x2"],
+		"merkleProofs": [
+			["0x0","0x2098f5fb9e239eab3ceac3f27b81e481dc3124d55ffed523a839ee8446b64864","0x1069673dcdb12263df301a6ff584a7ec261a44cb9dc68df067a4774460b1f1e1"],
+			["0x1","0x2098f5fb9e239eab3ceac3f27b81e481dc3124d55ffed523a839ee8446b64864","0x1069673dcdb12263df301a6ff584a7ec261a44cb9dc68df067a4774460b1f1e1"]
+		]}`
+	response, err := http.Post("http://localhost:8082/prove", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("Expected status code %d, got %d", http.StatusServiceUnavailable, response.StatusCode)
+	}
+	// The proof is generated by the healthy server.
+	response, err = http.Post("http://localhost:8080/prove", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := `{"inputHash":"0x5057a31740d54d42ac70c05e0768fb770c682cb2c559bdd03fe4099f7e584e4f","proof":` + string(proof) + `}`
+	response, err = http.Post("http://localhost:8082/verify", "application/json", strings.NewReader(request))
+	if err != nil {
+		t.Fatal(err)
+	}
+	responseBody, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusOK || !strings.Contains(string(responseBody), `"valid":true`) {
+		t.Fatalf("Expected the proof to verify, got %d %s", response.StatusCode, string(responseBody))
+	}
+
+	response, err = http.Get("http://localhost:8082/artifacts/verifier.sol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if responseBody, err = io.ReadAll(response.Body); err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusOK || !strings.Contains(string(responseBody), "contract BatchVerifier") {
+		t.Fatalf("Expected the verifier contract, got %d %s", response.StatusCode, string(responseBody))
+	}
+	response, err = http.Get("http://localhost:8082/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if responseBody, err = io.ReadAll(response.Body); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(responseBody), `"degraded"`) {
+		t.Fatalf("Expected the server to be degraded, got %s", string(responseBody))
+	}
+}
+
+func TestProveBatch(t *testing.T) {
+	params := `{
+		"startIndex":0,
+		"preRoot":"0x18f43331537ee2af2e3d758d50f72106467c6eea50371dd528d57eb2b856d238",
+		"postRoot":"0x2267bee7aae8ed55eb9aecff101145335ed1dd0a5a276a2b7eb3ae7d20e232d8",
+		"identityCommitments":["0x1","0x2"],
+		"merkleProofs": [
+			["0x0","0x2098f5fb9e239eab3ceac3f27b81e481dc3124d55ffed523a839ee8446b64864","0x1069673dcdb12263df301a6ff584a7ec261a44cb9dc68df067a4774460b1f1e1"],
+			["0x1","0x2098f5fb9e239eab3ceac3f27b81e481dc3124d55ffed523a839ee8446b64864","0x1069673dcdb12263df301a6ff584a7ec261a44cb9dc68df067a4774460b1f1e1"]
+		]}`
+	wrongBatchSize := `{"startIndex":0,"preRoot":"0x0","postRoot":"0x0","identityCommitments":["0x1"],"merkleProofs":[["0x0","0x0","0x0"]]}`
+	body := "[" + params + "," + wrongBatchSize + "]"
+	response, err := http.Post("http://localhost:8080/prove_batch", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, response.StatusCode)
+	}
+	responseBody, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(responseBody)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 results, got %s", string(responseBody))
+	}
+	for _, line := range lines {
+		switch {
+		case strings.Contains(line, `"index":0`) && strings.Contains(line, `"proof"`):
+		case strings.Contains(line, `"index":1`) && strings.Contains(line, "wrong_batch_size"):
+		default:
+			t.Fatalf("Unexpected result %s", line)
+		}
+	}
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	proverClient, err := client.New("http://"+ProverAddress, client.Options{Timeout: time.Minute, Retries: 2, Backoff: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := proverClient.Info(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	number := func(s string) *big.Int {
+		n, err := client.ParseNumber(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	siblings := []*big.Int{number("0x2098f5fb9e239eab3ceac3f27b81e481dc3124d55ffed523a839ee8446b64864"), number("0x1069673dcdb12263df301a6ff584a7ec261a44cb9dc68df067a4774460b1f1e1")}
+	params, err := client.NewParameters(&client.Insertion{
+		PreRoot:      number("0x18f43331537ee2af2e3d758d50f72106467c6eea50371dd528d57eb2b856d238"),
+		PostRoot:     number("0x2267bee7aae8ed55eb9aecff101145335ed1dd0a5a276a2b7eb3ae7d20e232d8"),
+		IdComms:      []*big.Int{big.NewInt(1), big.NewInt(2)},
+		MerkleProofs: [][]*big.Int{append([]*big.Int{big.NewInt(0)}, siblings...), append([]*big.Int{big.NewInt(1)}, siblings...)},
+	}, info.Commitment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.InputHash.Cmp(number("0x5057a31740d54d42ac70c05e0768fb770c682cb2c559bdd03fe4099f7e584e4f")) != 0 {
+		t.Fatalf("unexpected input hash %s", params.InputHash.Text(16))
+	}
+
+	proof, err := proverClient.Prove(ctx, params, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, err := proverClient.Verify(ctx, &params.InputHash, nil, proof); err != nil || !result.Valid {
+		t.Fatalf("expected the proof to verify, got %+v, %v", result, err)
+	}
+	if proof, err = proverClient.ProveAsync(ctx, params, 100*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if result, err := proverClient.Verify(ctx, &params.InputHash, nil, proof); err != nil || !result.Valid {
+		t.Fatalf("expected the async proof to verify, got %+v, %v", result, err)
+	}
+
+	params.PostRoot.SetInt64(1)
+	_, err = proverClient.Prove(ctx, params, nil)
+	if clientErr, ok := err.(*client.Error); !ok || clientErr.Code != "input_hash_mismatch" {
+		t.Fatalf("expected an input_hash_mismatch error, got %v", err)
 	}
 }
@@ -0,0 +1,83 @@
+// Package jobstore persists the proof jobs of the server's async mode, so
+// that they survive restarts of the prover.
+package jobstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type Status string
+
+const (
+	StatusQueued    Status = "queued"
+	StatusRunning   Status = "running"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+)
+
+// Finished reports whether a job in this status is done.
+func (s Status) Finished() bool {
+	return s == StatusSucceeded || s == StatusFailed
+}
+
+// Job is a proof requested asynchronously.
+type Job struct {
+	ID string
+	// ClientID is the authenticated client that submitted the job, empty for
+	// unsigned requests.
+	ClientID string
+	Status   Status
+	// Stage is the stage of the proof of a running job, see
+	// prover.ProofStage, or the stage a failed job failed in.
+	Stage string
+	// Parameters are the parameters of the proof, as JSON.
+	Parameters []byte
+	// Proof is the JSON proof of a succeeded job.
+	Proof []byte
+	// ErrorCode and ErrorMessage describe why a failed job failed, like the
+	// error responses of the server.
+	ErrorCode    string
+	ErrorMessage string
+	// CallbackURL is where the result is posted once the job finishes, if
+	// set.
+	CallbackURL string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// ErrNotFound is returned for jobs that do not exist or were deleted.
+var ErrNotFound = errors.New("job not found")
+
+// Store persists jobs. It is safe for concurrent use.
+type Store interface {
+	// Create adds a new job.
+	Create(ctx context.Context, job *Job) error
+	// Update replaces the status, stage, result and update time of a job.
+	Update(ctx context.Context, job *Job) error
+	Get(ctx context.Context, id string) (*Job, error)
+	// Unfinished returns the jobs that are queued or running, oldest first.
+	Unfinished(ctx context.Context) ([]*Job, error)
+	// DeleteFinished deletes the jobs finished before a time and returns how
+	// many were deleted.
+	DeleteFinished(ctx context.Context, before time.Time) (int64, error)
+	Close() error
+}
+
+// Open opens the store at location: "memory" for one that does not survive
+// restarts, sqlite://<path> for a SQLite database file, or a postgres:// URL.
+func Open(location string) (Store, error) {
+	switch {
+	case location == "memory":
+		return NewMemory(), nil
+	case strings.HasPrefix(location, "sqlite://"):
+		return openSQL(sqlite, strings.TrimPrefix(location, "sqlite://"))
+	case strings.HasPrefix(location, "postgres://"), strings.HasPrefix(location, "postgresql://"):
+		return openSQL(postgres, location)
+	default:
+		return nil, fmt.Errorf("unsupported job store %q, expected memory, sqlite://<path> or postgres://...", location)
+	}
+}
@@ -0,0 +1,160 @@
+package jobstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testStore(t *testing.T, store Store) {
+	ctx := context.Background()
+	created := time.Unix(1000, 0)
+	for i, id := range []string{"b", "a", "c"} {
+		job := &Job{ID: id, ClientID: "sequencer", Status: StatusQueued, Parameters: []byte(`{"startIndex":0}`), CallbackURL: "https://sequencer/" + id, CreatedAt: created.Add(time.Duration(i) * time.Second)}
+		job.UpdatedAt = job.CreatedAt
+		if err := store.Create(ctx, job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a, err := store.Get(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.ClientID != "sequencer" || a.Status != StatusQueued || string(a.Parameters) != `{"startIndex":0}` || a.Proof != nil || a.CallbackURL != "https://sequencer/a" || !a.CreatedAt.Equal(created.Add(time.Second)) {
+		t.Fatalf("unexpected job %+v", a)
+	}
+	if _, err = store.Get(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if err = store.Update(ctx, &Job{ID: "missing", Status: StatusFailed}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	a.Status, a.Stage, a.Proof, a.UpdatedAt = StatusSucceeded, "done", []byte(`{"ar":[]}`), created.Add(time.Hour)
+	if err = store.Update(ctx, a); err != nil {
+		t.Fatal(err)
+	}
+	c := &Job{ID: "c", Status: StatusFailed, ErrorCode: "root_mismatch", ErrorMessage: "bad proof", UpdatedAt: created.Add(2 * time.Hour)}
+	if err = store.Update(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+	if a, err = store.Get(ctx, "a"); err != nil || a.Status != StatusSucceeded || a.Stage != "done" || string(a.Proof) != `{"ar":[]}` {
+		t.Fatalf("expected the update to be stored, got %+v, %v", a, err)
+	}
+
+	unfinished, err := store.Unfinished(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unfinished) != 1 || unfinished[0].ID != "b" {
+		t.Fatalf("expected b to be unfinished, got %+v", unfinished)
+	}
+
+	deleted, err := store.DeleteFinished(ctx, created.Add(90*time.Minute))
+	if err != nil || deleted != 1 {
+		t.Fatalf("expected a to be deleted, got %d, %v", deleted, err)
+	}
+	if _, err = store.Get(ctx, "a"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected a to be deleted, got %v", err)
+	}
+	if c, err = store.Get(ctx, "c"); err != nil || c.ErrorCode != "root_mismatch" {
+		t.Fatalf("expected c to be kept, got %+v, %v", c, err)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemory())
+}
+
+func TestSQLiteStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.db")
+	store, err := Open("sqlite://" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, store)
+	if err = store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Jobs survive reopening the database.
+	if store, err = Open("sqlite://" + path); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if unfinished, err := store.Unfinished(context.Background()); err != nil || len(unfinished) != 1 {
+		t.Fatalf("expected the unfinished job to be kept, got %+v, %v", unfinished, err)
+	}
+}
+
+// TestPostgresStore runs against the database of JOBSTORE_POSTGRES_URL,
+// deleting the jobs it holds first.
+func TestPostgresStore(t *testing.T) {
+	location := os.Getenv("JOBSTORE_POSTGRES_URL")
+	if location == "" {
+		t.Skip("JOBSTORE_POSTGRES_URL is not set")
+	}
+	store, err := Open(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if _, err = store.(*sqlStore).db.Exec(`DELETE FROM prover_jobs`); err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, store)
+}
+
+func TestOpen(t *testing.T) {
+	if _, err := Open("mysql://localhost/jobs"); err == nil {
+		t.Fatal("expected unsupported stores to be rejected")
+	}
+	if store, err := Open("memory"); err != nil || store == nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNumberedPlaceholders(t *testing.T) {
+	store := &sqlStore{dialect: postgres}
+	if query := store.query("UPDATE t SET a = ? WHERE b = ?"); query != "UPDATE t SET a = $1 WHERE b = $2" {
+		t.Fatalf("unexpected query %s", query)
+	}
+}
+
+func TestMigrateSQLite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The table as created before jobs had callbacks.
+	_, err = db.Exec(`CREATE TABLE prover_jobs (id TEXT PRIMARY KEY, client_id TEXT NOT NULL, status TEXT NOT NULL, parameters TEXT NOT NULL,
+		proof TEXT NOT NULL, error_code TEXT NOT NULL, error_message TEXT NOT NULL, created_at BIGINT NOT NULL, updated_at BIGINT NOT NULL)`)
+	if err == nil {
+		_, err = db.Exec(`INSERT INTO prover_jobs VALUES ('old', '', 'failed', '{}', '', 'witness_error', '', 0, 0)`)
+	}
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := Open("sqlite://" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if job, err := store.Get(context.Background(), "old"); err != nil || job.CallbackURL != "" || job.Stage != "" {
+		t.Fatalf("expected the old job to be kept without callback or stage, got %+v, %v", job, err)
+	}
+	if err = store.Create(context.Background(), &Job{ID: "new", Status: StatusQueued, CallbackURL: "https://sequencer/new"}); err != nil {
+		t.Fatal(err)
+	}
+	if job, err := store.Get(context.Background(), "new"); err != nil || job.CallbackURL != "https://sequencer/new" {
+		t.Fatalf("expected the callback to be stored, got %+v, %v", job, err)
+	}
+}
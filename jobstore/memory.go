@@ -0,0 +1,81 @@
+package jobstore
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+type memoryStore struct {
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+// NewMemory returns a Store keeping jobs in memory.
+func NewMemory() Store {
+	return &memoryStore{jobs: make(map[string]*Job)}
+}
+
+func copyJob(job *Job) *Job {
+	copied := *job
+	return &copied
+}
+
+func (s *memoryStore) Create(_ context.Context, job *Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[job.ID] = copyJob(job)
+	return nil
+}
+
+func (s *memoryStore) Update(_ context.Context, job *Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.jobs[job.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	stored.Status, stored.Stage, stored.Proof, stored.ErrorCode, stored.ErrorMessage, stored.UpdatedAt = job.Status, job.Stage, job.Proof, job.ErrorCode, job.ErrorMessage, job.UpdatedAt
+	return nil
+}
+
+func (s *memoryStore) Get(_ context.Context, id string) (*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyJob(job), nil
+}
+
+func (s *memoryStore) Unfinished(_ context.Context) ([]*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var jobs []*Job
+	for _, job := range s.jobs {
+		if !job.Status.Finished() {
+			jobs = append(jobs, copyJob(job))
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].CreatedAt.Before(jobs[j].CreatedAt) })
+	return jobs, nil
+}
+
+func (s *memoryStore) DeleteFinished(_ context.Context, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var deleted int64
+	for id, job := range s.jobs {
+		if job.Status.Finished() && job.UpdatedAt.Before(before) {
+			delete(s.jobs, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
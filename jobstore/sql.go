@@ -0,0 +1,196 @@
+package jobstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+)
+
+// dialect is a database/sql driver and how its queries differ.
+type dialect struct {
+	driver string
+	// numbered placeholders are $1, $2, ... instead of ?.
+	numbered bool
+	// setup is run when the database is opened.
+	setup []string
+}
+
+var (
+	sqlite = dialect{
+		driver: "sqlite",
+		// Concurrent writers wait for each other instead of failing.
+		setup: []string{"PRAGMA busy_timeout = 5000", "PRAGMA journal_mode = WAL"},
+	}
+	postgres = dialect{driver: "postgres", numbered: true}
+)
+
+const schema = `CREATE TABLE IF NOT EXISTS prover_jobs (
+	id TEXT PRIMARY KEY,
+	client_id TEXT NOT NULL,
+	status TEXT NOT NULL,
+	parameters TEXT NOT NULL,
+	proof TEXT NOT NULL,
+	error_code TEXT NOT NULL,
+	error_message TEXT NOT NULL,
+	callback_url TEXT NOT NULL DEFAULT '',
+	stage TEXT NOT NULL DEFAULT '',
+	created_at BIGINT NOT NULL,
+	updated_at BIGINT NOT NULL
+)`
+
+const statusIndex = `CREATE INDEX IF NOT EXISTS prover_jobs_status ON prover_jobs (status, updated_at)`
+
+// migrations add the columns missing from tables created by earlier versions,
+// by the column they add.
+var migrations = []struct{ column, statement string }{
+	{"callback_url", `ALTER TABLE prover_jobs ADD COLUMN callback_url TEXT NOT NULL DEFAULT ''`},
+	{"stage", `ALTER TABLE prover_jobs ADD COLUMN stage TEXT NOT NULL DEFAULT ''`},
+}
+
+const jobColumns = `id, client_id, status, parameters, proof, error_code, error_message, callback_url, stage, created_at, updated_at`
+
+type sqlStore struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+func openSQL(dialect dialect, source string) (*sqlStore, error) {
+	db, err := sql.Open(dialect.driver, source)
+	if err != nil {
+		return nil, err
+	}
+	if dialect.driver == sqlite.driver {
+		// SQLite serializes writes anyway, and pragmas are per connection.
+		db.SetMaxOpenConns(1)
+	}
+	for _, statement := range append(dialect.setup, schema, statusIndex) {
+		if _, err = db.Exec(statement); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	if err = migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &sqlStore{db: db, dialect: dialect}, nil
+}
+
+// migrate runs the migrations whose column is missing. Selecting the column
+// tells, portably across dialects.
+func migrate(db *sql.DB) error {
+	for _, migration := range migrations {
+		rows, err := db.Query(`SELECT ` + migration.column + ` FROM prover_jobs WHERE 1 = 0`)
+		if err == nil {
+			rows.Close()
+			continue
+		}
+		if _, err = db.Exec(migration.statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// query rewrites the ? placeholders of query for the dialect.
+func (s *sqlStore) query(query string) string {
+	if !s.dialect.numbered {
+		return query
+	}
+	var rewritten strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			rewritten.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		rewritten.WriteRune(c)
+	}
+	return rewritten.String()
+}
+
+func (s *sqlStore) Create(ctx context.Context, job *Job) error {
+	_, err := s.db.ExecContext(ctx, s.query(`INSERT INTO prover_jobs (`+jobColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+		job.ID, job.ClientID, string(job.Status), string(job.Parameters), string(job.Proof), job.ErrorCode, job.ErrorMessage, job.CallbackURL, job.Stage,
+		job.CreatedAt.UnixNano(), job.UpdatedAt.UnixNano())
+	return err
+}
+
+func (s *sqlStore) Update(ctx context.Context, job *Job) error {
+	result, err := s.db.ExecContext(ctx, s.query(`UPDATE prover_jobs SET status = ?, stage = ?, proof = ?, error_code = ?, error_message = ?, updated_at = ? WHERE id = ?`),
+		string(job.Status), job.Stage, string(job.Proof), job.ErrorCode, job.ErrorMessage, job.UpdatedAt.UnixNano(), job.ID)
+	if err != nil {
+		return err
+	}
+	if updated, err := result.RowsAffected(); err == nil && updated == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// scanner is a *sql.Row or *sql.Rows.
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanJob(row scanner) (*Job, error) {
+	var job Job
+	var status, parameters, proof string
+	var createdAt, updatedAt int64
+	err := row.Scan(&job.ID, &job.ClientID, &status, &parameters, &proof, &job.ErrorCode, &job.ErrorMessage, &job.CallbackURL, &job.Stage, &createdAt, &updatedAt)
+	if err != nil {
+		return nil, err
+	}
+	job.Status, job.Parameters = Status(status), []byte(parameters)
+	if proof != "" {
+		job.Proof = []byte(proof)
+	}
+	job.CreatedAt, job.UpdatedAt = time.Unix(0, createdAt), time.Unix(0, updatedAt)
+	return &job, nil
+}
+
+func (s *sqlStore) Get(ctx context.Context, id string) (*Job, error) {
+	job, err := scanJob(s.db.QueryRowContext(ctx, s.query(`SELECT `+jobColumns+` FROM prover_jobs WHERE id = ?`), id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return job, err
+}
+
+func (s *sqlStore) Unfinished(ctx context.Context) ([]*Job, error) {
+	rows, err := s.db.QueryContext(ctx, s.query(`SELECT `+jobColumns+` FROM prover_jobs WHERE status IN (?, ?) ORDER BY created_at`),
+		string(StatusQueued), string(StatusRunning))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var jobs []*Job
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+func (s *sqlStore) DeleteFinished(ctx context.Context, before time.Time) (int64, error) {
+	result, err := s.db.ExecContext(ctx, s.query(`DELETE FROM prover_jobs WHERE status IN (?, ?) AND updated_at < ?`),
+		string(StatusSucceeded), string(StatusFailed), before.UnixNano())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
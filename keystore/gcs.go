@@ -0,0 +1,244 @@
+package keystore
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// gcsMetadataHost serves access tokens for the service account of the
+// instance when running on Google Cloud. GCE_METADATA_HOST overrides it, as
+// for the Google Cloud client libraries.
+const gcsMetadataHost = "metadata.google.internal"
+
+// gcsReadScope is the only scope requested for service account tokens, the
+// keys file being read only.
+const gcsReadScope = "https://www.googleapis.com/auth/devstorage.read_only"
+
+// errNoGCSCredentials is returned when no access token can be obtained.
+var errNoGCSCredentials = errors.New("no GCS credentials found")
+
+// gcsSource reads an object through the Cloud Storage JSON API. It is
+// authenticated with the token in GOOGLE_OAUTH_ACCESS_TOKEN, the service
+// account key file in GOOGLE_APPLICATION_CREDENTIALS or, failing those, a
+// token from the instance metadata server; requests are only anonymous when
+// asked for.
+type gcsSource struct {
+	bucket   string
+	object   string
+	endpoint string
+	tokenURL string
+	// serviceAccount is set when GOOGLE_APPLICATION_CREDENTIALS is.
+	serviceAccount *gcsServiceAccount
+	anonymous      bool
+	now            func() time.Time
+}
+
+// gcsServiceAccount is the part of a service account key file needed to
+// exchange a signed assertion for an access token.
+type gcsServiceAccount struct {
+	ClientEmail  string `json:"client_email"`
+	PrivateKeyID string `json:"private_key_id"`
+	PrivateKey   string `json:"private_key"`
+	TokenURI     string `json:"token_uri"`
+	key          *rsa.PrivateKey
+}
+
+// newGCSSource fails if there are no credentials to authenticate the
+// requests with, unless anonymous, so that a misconfigured deployment does
+// not fall back to anonymous requests.
+func newGCSSource(ctx context.Context, u *url.URL, anonymous bool) (*gcsSource, error) {
+	if u.Host == "" || strings.Trim(u.Path, "/") == "" {
+		return nil, fmt.Errorf("invalid GCS location, expected gs://bucket/object: %s", u)
+	}
+	src := &gcsSource{
+		bucket:    u.Host,
+		object:    strings.TrimPrefix(u.Path, "/"),
+		endpoint:  "https://storage.googleapis.com",
+		tokenURL:  "http://" + gcsMetadataHost + "/computeMetadata/v1/instance/service-accounts/default/token",
+		anonymous: anonymous,
+		now:       time.Now,
+	}
+	if host := os.Getenv("STORAGE_EMULATOR_HOST"); host != "" {
+		src.endpoint = strings.TrimSuffix(host, "/")
+		if !strings.Contains(src.endpoint, "://") {
+			src.endpoint = "http://" + src.endpoint
+		}
+	}
+	if host := os.Getenv("GCE_METADATA_HOST"); host != "" {
+		src.tokenURL = "http://" + host + "/computeMetadata/v1/instance/service-accounts/default/token"
+	}
+	if anonymous {
+		return src, nil
+	}
+	if path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); path != "" {
+		account, err := readGCSServiceAccount(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading GOOGLE_APPLICATION_CREDENTIALS: %w", err)
+		}
+		src.serviceAccount = account
+	}
+	if _, err := src.token(ctx); err != nil {
+		return nil, fmt.Errorf("reading %s needs GCS credentials, unless anonymous access is enabled for a public bucket: %w", u, err)
+	}
+	return src, nil
+}
+
+func (src *gcsSource) request(ctx context.Context) (*http.Request, error) {
+	objectURL := fmt.Sprintf("%s/storage/v1/b/%s/o/%s?alt=media", src.endpoint, url.PathEscape(src.bucket), url.PathEscape(src.object))
+	return http.NewRequestWithContext(ctx, http.MethodGet, objectURL, nil)
+}
+
+func (src *gcsSource) sign(ctx context.Context, req *http.Request) error {
+	if src.anonymous {
+		return nil
+	}
+	token, err := src.token(ctx)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// token returns an access token from the first available source. Tokens are
+// fetched for every request, as downloads can outlive them.
+func (src *gcsSource) token(ctx context.Context) (string, error) {
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		return token, nil
+	}
+	if src.serviceAccount != nil {
+		return src.serviceAccountToken(ctx)
+	}
+	return src.metadataToken(ctx)
+}
+
+// metadataToken returns an access token from the metadata server, which is
+// only reachable when running on Google Cloud.
+func (src *gcsSource) metadataToken(ctx context.Context) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src.tokenURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	token, err := requestGCSToken(req)
+	if err != nil {
+		return "", fmt.Errorf("%w: GOOGLE_OAUTH_ACCESS_TOKEN and GOOGLE_APPLICATION_CREDENTIALS are not set and the metadata server is unavailable: %v", errNoGCSCredentials, err)
+	}
+	return token, nil
+}
+
+func readGCSServiceAccount(path string) (*gcsServiceAccount, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var account gcsServiceAccount
+	if err = json.Unmarshal(data, &account); err != nil {
+		return nil, fmt.Errorf("invalid service account key file %s: %w", path, err)
+	}
+	if account.ClientEmail == "" || account.PrivateKey == "" {
+		return nil, fmt.Errorf("%s is not a service account key file", path)
+	}
+	if account.TokenURI == "" {
+		account.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+	block, _ := pem.Decode([]byte(account.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("invalid private key in %s", path)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key in %s: %w", path, err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("the private key in %s is not an RSA key", path)
+	}
+	account.key = key
+	return &account, nil
+}
+
+// serviceAccountToken exchanges an assertion signed with the service account
+// key for an access token, following the OAuth 2.0 JWT bearer flow.
+func (src *gcsSource) serviceAccountToken(ctx context.Context) (string, error) {
+	account := src.serviceAccount
+	now := src.now().Unix()
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": account.PrivateKeyID})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":   account.ClientEmail,
+		"scope": gcsReadScope,
+		"aud":   account.TokenURI,
+		"iat":   now,
+		"exp":   now + 3600,
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, account.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {unsigned + "." + base64.RawURLEncoding.EncodeToString(signature)},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, account.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	token, err := requestGCSToken(req)
+	if err != nil {
+		return "", fmt.Errorf("exchanging the key of %s for an access token: %w", account.ClientEmail, err)
+	}
+	return token, nil
+}
+
+// requestGCSToken performs a token request and decodes the access token of
+// its response.
+func requestGCSToken(req *http.Request) (string, error) {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("token request failed with %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	if token.AccessToken == "" {
+		return "", errors.New("token response has no access token")
+	}
+	return token.AccessToken, nil
+}
@@ -0,0 +1,259 @@
+// Package keystore makes keys files stored locally or in object storage
+// available as local files for the prover to load, and loads the operator
+// keys proofs are signed with.
+package keystore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"worldcoin/gnark-mbu/logging"
+)
+
+// Options configure how remote keys files are fetched.
+type Options struct {
+	// CacheDir is where remote keys files are downloaded to. Defaults to the
+	// system temporary directory.
+	CacheDir string
+	// SHA256 is the hex encoded SHA-256 checksum the keys file must match.
+	// Empty skips verification.
+	SHA256 string
+	// Retries is the number of times an interrupted download is resumed.
+	Retries int
+	// Client performs the requests. Defaults to http.DefaultClient.
+	Client *http.Client
+	// Anonymous reads S3 and GCS objects without authenticating the
+	// requests, for public buckets. Otherwise fetching fails when no
+	// credentials are found.
+	Anonymous bool
+}
+
+// ChecksumError is returned when a keys file does not match the expected
+// checksum.
+type ChecksumError struct {
+	Path     string
+	Expected string
+	Actual   string
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("checksum mismatch for %s: expected %s, got %s", e.Path, e.Expected, e.Actual)
+}
+
+// source is a remote object that can be read from an offset.
+type source interface {
+	// request returns a request for the object.
+	request(ctx context.Context) (*http.Request, error)
+	// sign authenticates a request, after all headers have been set.
+	sign(ctx context.Context, req *http.Request) error
+}
+
+// Fetch makes the keys file at location available locally and returns its
+// path. Locations are local paths, file:// URLs, s3://bucket/key or
+// gs://bucket/object. Remote files are downloaded to the cache directory,
+// resuming interrupted downloads, and reused if they match the checksum.
+func Fetch(ctx context.Context, location string, opts Options) (string, error) {
+	u, err := url.Parse(location)
+	if err != nil || u.Scheme == "" || u.Scheme == "file" {
+		localPath := location
+		if err == nil && u.Scheme == "file" {
+			localPath = u.Path
+		}
+		return localPath, verifyChecksum(localPath, opts.SHA256)
+	}
+
+	var src source
+	switch u.Scheme {
+	case "s3":
+		src, err = newS3Source(ctx, u, opts.Anonymous)
+	case "gs":
+		src, err = newGCSSource(ctx, u, opts.Anonymous)
+	default:
+		err = fmt.Errorf("unsupported keys location scheme: %s", u.Scheme)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	dir := opts.CacheDir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	locationHash := sha256.Sum256([]byte(location))
+	localPath := filepath.Join(dir, hex.EncodeToString(locationHash[:8])+"-"+path.Base(u.Path))
+
+	// Without a checksum a cached file may be stale, so it is only reused
+	// when it can be verified.
+	if opts.SHA256 != "" {
+		if err = verifyChecksum(localPath, opts.SHA256); err == nil {
+			logging.Logger().Info().Str("path", localPath).Msg("using cached keys file")
+			return localPath, nil
+		}
+	}
+
+	client := opts.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if err = download(ctx, client, src, localPath, opts.Retries); err != nil {
+		return "", err
+	}
+	if err = verifyChecksum(localPath, opts.SHA256); err != nil {
+		os.Remove(localPath)
+		return "", err
+	}
+	return localPath, nil
+}
+
+func verifyChecksum(path string, expected string) error {
+	if expected == "" {
+		return nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err = io.Copy(hash, file); err != nil {
+		return err
+	}
+	actual := hex.EncodeToString(hash.Sum(nil))
+	if !strings.EqualFold(actual, expected) {
+		return &ChecksumError{Path: path, Expected: expected, Actual: actual}
+	}
+	return nil
+}
+
+// download fetches src into localPath through a partial file, resuming from
+// where a previous attempt stopped. The ETag of the object is kept next to the
+// partial file so that a changed object is downloaded from scratch.
+func download(ctx context.Context, client *http.Client, src source, localPath string, retries int) error {
+	partialPath := localPath + ".partial"
+	etagPath := partialPath + ".etag"
+	var err error
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			logging.Logger().Warn().Err(err).Int("attempt", attempt).Msg("resuming keys file download")
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Duration(attempt) * time.Second):
+			}
+		}
+		var done bool
+		done, err = downloadAttempt(ctx, client, src, partialPath, etagPath)
+		if err == nil && done {
+			os.Remove(etagPath)
+			return os.Rename(partialPath, localPath)
+		}
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return err
+		}
+	}
+	return fmt.Errorf("downloading keys file: %w", err)
+}
+
+type statusError struct {
+	status string
+	body   string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("unexpected response %s: %s", e.status, e.body)
+}
+
+func downloadAttempt(ctx context.Context, client *http.Client, src source, partialPath string, etagPath string) (bool, error) {
+	file, err := os.OpenFile(partialPath, os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return false, err
+	}
+
+	req, err := src.request(ctx)
+	if err != nil {
+		return false, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		if etag, err := os.ReadFile(etagPath); err == nil {
+			req.Header.Set("If-Range", string(etag))
+		}
+	}
+	if err = src.sign(ctx, req); err != nil {
+		return false, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// The whole object, either requested or because it changed.
+		if offset > 0 {
+			logging.Logger().Info().Msg("keys file changed, restarting download")
+		}
+		if err = file.Truncate(0); err != nil {
+			return false, err
+		}
+		if offset, err = file.Seek(0, io.SeekStart); err != nil {
+			return false, err
+		}
+	case http.StatusPartialContent:
+		start, err := contentRangeStart(resp.Header.Get("Content-Range"))
+		if err != nil {
+			return false, err
+		}
+		if start != offset {
+			return false, fmt.Errorf("requested range from %d, got %d", offset, start)
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The partial file already holds the whole object.
+		return true, nil
+	default:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return false, &statusError{status: resp.Status, body: string(body)}
+	}
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		if err = os.WriteFile(etagPath, []byte(etag), 0o644); err != nil {
+			return false, err
+		}
+	}
+
+	logging.Logger().Info().Int64("offset", offset).Int64("remaining", resp.ContentLength).Msg("downloading keys file")
+	if _, err = io.Copy(file, resp.Body); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// contentRangeStart parses the first byte position of a Content-Range header
+// of the form "bytes start-end/size".
+func contentRangeStart(contentRange string) (int64, error) {
+	spec, ok := strings.CutPrefix(contentRange, "bytes ")
+	if !ok {
+		return 0, fmt.Errorf("invalid content range: %q", contentRange)
+	}
+	start, _, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, fmt.Errorf("invalid content range: %q", contentRange)
+	}
+	return strconv.ParseInt(start, 10, 64)
+}
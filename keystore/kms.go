@@ -0,0 +1,93 @@
+package keystore
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"strings"
+	"worldcoin/gnark-mbu/aws"
+)
+
+// kmsSigner signs digests with an asymmetric key of AWS KMS. Only
+// ECC_NIST_P256 keys are supported, signing SHA-256 digests with
+// ECDSA_SHA_256.
+type kmsSigner struct {
+	keyId  string
+	kms    *aws.JSONClient
+	public *ecdsa.PublicKey
+}
+
+func newKMSSigner(ctx context.Context, keyId string) (*kmsSigner, error) {
+	if keyId == "" {
+		return nil, fmt.Errorf("invalid KMS location, expected %s<key id, alias or ARN>", kmsScheme)
+	}
+	// The region of an ARN takes precedence, arn:aws:kms:<region>:...
+	region := ""
+	if fields := strings.Split(keyId, ":"); len(fields) > 3 && fields[0] == "arn" {
+		region = fields[3]
+	}
+	kms, err := aws.NewJSONClient("kms", "TrentService", region)
+	if err != nil {
+		return nil, err
+	}
+	s := &kmsSigner{keyId: keyId, kms: kms}
+	if err := s.loadPublicKey(ctx); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// loadPublicKey reads the public key of the key, checking that it signs
+// with ECDSA on P-256.
+func (s *kmsSigner) loadPublicKey(ctx context.Context) error {
+	var response struct {
+		PublicKey []byte
+		KeySpec   string
+		KeyUsage  string
+	}
+	if err := s.kms.Call(ctx, "GetPublicKey", map[string]string{"KeyId": s.keyId}, &response); err != nil {
+		return fmt.Errorf("KMS key %s: %w", s.keyId, err)
+	}
+	if response.KeySpec != "ECC_NIST_P256" || response.KeyUsage != "SIGN_VERIFY" {
+		return fmt.Errorf("KMS key %s is a %s key for %s, expected an ECC_NIST_P256 key for SIGN_VERIFY", s.keyId, response.KeySpec, response.KeyUsage)
+	}
+	key, err := x509.ParsePKIXPublicKey(response.PublicKey)
+	if err != nil {
+		return fmt.Errorf("KMS key %s: %w", s.keyId, err)
+	}
+	public, ok := key.(*ecdsa.PublicKey)
+	if !ok || public.Curve != elliptic.P256() {
+		return fmt.Errorf("KMS key %s is not a P-256 ECDSA key", s.keyId)
+	}
+	s.public = public
+	return nil
+}
+
+func (s *kmsSigner) Public() crypto.PublicKey {
+	return s.public
+}
+
+// Sign signs a SHA-256 digest, returning the ASN.1 encoded signature.
+func (s *kmsSigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if opts.HashFunc() != crypto.SHA256 || len(digest) != sha256.Size {
+		return nil, fmt.Errorf("KMS keys only sign SHA-256 digests")
+	}
+	var response struct {
+		Signature []byte
+	}
+	err := s.kms.Call(context.Background(), "Sign", map[string]interface{}{
+		"KeyId":            s.keyId,
+		"Message":          digest,
+		"MessageType":      "DIGEST",
+		"SigningAlgorithm": "ECDSA_SHA_256",
+	}, &response)
+	if err != nil {
+		return nil, fmt.Errorf("KMS key %s: %w", s.keyId, err)
+	}
+	return response.Signature, nil
+}
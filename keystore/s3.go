@@ -0,0 +1,88 @@
+package keystore
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+	"worldcoin/gnark-mbu/aws"
+)
+
+// s3Source reads an object from S3 or an S3 compatible store, authenticated
+// with the credentials of an aws.CredentialChain, or unsigned if anonymous.
+type s3Source struct {
+	bucket   string
+	key      string
+	region   string
+	endpoint string
+	// credentials is nil for anonymous requests.
+	credentials aws.CredentialsSource
+	now         func() time.Time
+}
+
+// newS3Source fails if there are no credentials to sign the requests with,
+// unless anonymous, so that a misconfigured deployment does not fall back to
+// unsigned requests.
+func newS3Source(ctx context.Context, u *url.URL, anonymous bool) (*s3Source, error) {
+	if u.Host == "" || strings.Trim(u.Path, "/") == "" {
+		return nil, fmt.Errorf("invalid S3 location, expected s3://bucket/key: %s", u)
+	}
+	src := &s3Source{
+		bucket:   u.Host,
+		key:      strings.TrimPrefix(u.Path, "/"),
+		region:   aws.RegionFromEnv(),
+		endpoint: os.Getenv("AWS_ENDPOINT_URL"),
+		now:      time.Now,
+	}
+	if !anonymous {
+		chain := aws.NewCredentialChain()
+		if _, err := chain.Retrieve(ctx); err != nil {
+			return nil, fmt.Errorf("reading %s needs AWS credentials, unless anonymous access is enabled for a public bucket: %w", u, err)
+		}
+		src.credentials = chain
+	}
+	return src, nil
+}
+
+func (src *s3Source) request(ctx context.Context) (*http.Request, error) {
+	// Custom endpoints are addressed path-style, as virtual hosts are
+	// rarely set up for S3 compatible stores.
+	objectURL := fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", src.bucket, src.region, escapePath(src.key))
+	if src.endpoint != "" {
+		objectURL = fmt.Sprintf("%s/%s/%s", strings.TrimSuffix(src.endpoint, "/"), src.bucket, escapePath(src.key))
+	}
+	return http.NewRequestWithContext(ctx, http.MethodGet, objectURL, nil)
+}
+
+func (src *s3Source) sign(ctx context.Context, req *http.Request) error {
+	if src.credentials == nil {
+		return nil
+	}
+	credentials, err := src.credentials.Retrieve(ctx)
+	if err != nil {
+		return err
+	}
+	aws.SignV4(req, credentials, src.region, "s3", aws.EmptyPayloadHash, src.now().UTC())
+	return nil
+}
+
+// escapePath URI-encodes each segment of an object key as required by
+// Signature Version 4, which is stricter than url.PathEscape.
+func escapePath(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		var escaped strings.Builder
+		for _, b := range []byte(segment) {
+			if ('A' <= b && b <= 'Z') || ('a' <= b && b <= 'z') || ('0' <= b && b <= '9') || b == '-' || b == '.' || b == '_' || b == '~' {
+				escaped.WriteByte(b)
+			} else {
+				fmt.Fprintf(&escaped, "%%%02X", b)
+			}
+		}
+		segments[i] = escaped.String()
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,51 @@
+package keystore
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"worldcoin/gnark-mbu/secrets"
+)
+
+// kmsScheme prefixes the locations of AWS KMS keys.
+const kmsScheme = "awskms://"
+
+// LoadSigner returns the signer of the private key at location: a PEM file,
+// or a secret reference read with secrets.Read, holding a PKCS #8 Ed25519 or
+// ECDSA key or a SEC 1 ECDSA key, or awskms://<key id, alias or ARN> for an
+// ECC_NIST_P256 key of AWS KMS, which never leaves it.
+func LoadSigner(ctx context.Context, location string) (crypto.Signer, error) {
+	if strings.HasPrefix(location, kmsScheme) {
+		return newKMSSigner(ctx, strings.TrimPrefix(location, kmsScheme))
+	}
+	data, err := secrets.Read(ctx, location)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM block found", location)
+	}
+	var key interface{}
+	if block.Type == "EC PRIVATE KEY" {
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	} else {
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", location, err)
+	}
+	switch key := key.(type) {
+	case ed25519.PrivateKey:
+		return key, nil
+	case *ecdsa.PrivateKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("%s: unsupported key type %T", location, key)
+	}
+}
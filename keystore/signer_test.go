@@ -0,0 +1,108 @@
+package keystore
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadSignerPEM(t *testing.T) {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	pkcs8, _ := x509.MarshalPKCS8PrivateKey(edKey)
+	sec1, _ := x509.MarshalECPrivateKey(ecKey)
+	dir := t.TempDir()
+	for name, block := range map[string]*pem.Block{
+		"ed25519.pem": {Type: "PRIVATE KEY", Bytes: pkcs8},
+		"ecdsa.pem":   {Type: "EC PRIVATE KEY", Bytes: sec1},
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		signer, err := LoadSigner(context.Background(), path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		switch public := signer.Public().(type) {
+		case ed25519.PublicKey:
+			if !public.Equal(edKey.Public()) {
+				t.Fatalf("%s: wrong public key", name)
+			}
+		case *ecdsa.PublicKey:
+			if !public.Equal(&ecKey.PublicKey) {
+				t.Fatalf("%s: wrong public key", name)
+			}
+		}
+	}
+	if _, err := LoadSigner(context.Background(), filepath.Join(dir, "missing.pem")); err == nil {
+		t.Fatal("expected an error for a missing file")
+	}
+}
+
+func TestLoadSignerKMS(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	public, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") || !strings.Contains(r.Header.Get("Authorization"), "/eu-west-1/kms/") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		var request struct {
+			KeyId            string
+			Message          []byte
+			MessageType      string
+			SigningAlgorithm string
+		}
+		json.NewDecoder(r.Body).Decode(&request)
+		if request.KeyId != "arn:aws:kms:eu-west-1:111122223333:key/test" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"__type": "NotFoundException", "message": "no such key"}`))
+			return
+		}
+		switch r.Header.Get("X-Amz-Target") {
+		case "TrentService.GetPublicKey":
+			json.NewEncoder(w).Encode(map[string]interface{}{"PublicKey": public, "KeySpec": "ECC_NIST_P256", "KeyUsage": "SIGN_VERIFY"})
+		case "TrentService.Sign":
+			if request.MessageType != "DIGEST" || request.SigningAlgorithm != "ECDSA_SHA_256" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			signature, _ := ecdsa.SignASN1(rand.Reader, key, request.Message)
+			json.NewEncoder(w).Encode(map[string]interface{}{"Signature": signature})
+		}
+	}))
+	defer server.Close()
+	t.Setenv("AWS_ENDPOINT_URL_KMS", server.URL)
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	signer, err := LoadSigner(context.Background(), "awskms://arn:aws:kms:eu-west-1:111122223333:key/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte("proof"))
+	signature, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ecdsa.VerifyASN1(signer.Public().(*ecdsa.PublicKey), digest[:], signature) {
+		t.Fatal("signature does not verify")
+	}
+	if _, err = LoadSigner(context.Background(), "awskms://arn:aws:kms:eu-west-1:111122223333:key/other"); err == nil || !strings.Contains(err.Error(), "NotFoundException") {
+		t.Fatalf("expected the KMS error, got %v", err)
+	}
+}
@@ -1,18 +1,88 @@
 package logging
 
 import (
+	"fmt"
+	"io"
+	"os"
+
 	gnarkLogger "github.com/consensys/gnark/logger"
 	"github.com/rs/zerolog"
-	"os"
 )
 
 var log = zerolog.New(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: "15:04:05"}).With().Timestamp().Logger()
 
+// output is the file logs are written to, if any, closed when the output is
+// replaced.
+var output io.Closer
+
 func Logger() *zerolog.Logger {
 	return &log
 }
 
-func SetJSONOutput() {
-	log = zerolog.New(os.Stdout).With().Timestamp().Logger()
+// Options configure where and how logs are written.
+type Options struct {
+	// Output is stderr, stdout or the path of a log file. Empty means
+	// stdout for JSON and stderr otherwise.
+	Output string
+	// JSON writes entries as JSON instead of for the console.
+	JSON bool
+	// MaxSize is the size in bytes a log file is rotated at. Zero never
+	// rotates it.
+	MaxSize int64
+	// MaxBackups is the number of rotated log files kept, as <path>.1 for
+	// the latest one up to <path>.<MaxBackups>.
+	MaxBackups int
+}
+
+// Configure replaces the logger with one writing as set by options, closing
+// the previous log file. It is meant to be called once at startup.
+func Configure(options Options) error {
+	var out io.Writer
+	var closer io.Closer
+	switch options.Output {
+	case "stderr":
+		out = os.Stderr
+	case "stdout":
+		out = os.Stdout
+	case "":
+		out = os.Stderr
+		if options.JSON {
+			out = os.Stdout
+		}
+	default:
+		file, err := openRotatingFile(options.Output, options.MaxSize, options.MaxBackups)
+		if err != nil {
+			return fmt.Errorf("failed to open log file: %w", err)
+		}
+		out, closer = file, file
+	}
+	if !options.JSON {
+		out = zerolog.ConsoleWriter{Out: out, TimeFormat: "15:04:05", NoColor: closer != nil}
+	}
+	log = zerolog.New(out).With().Timestamp().Logger()
 	gnarkLogger.Set(log)
+	if output != nil {
+		output.Close()
+	}
+	output = closer
+	return nil
+}
+
+// SetLevel sets the minimum level of the entries written by every logger,
+// including the ones taken before. It may be called while logging.
+func SetLevel(level zerolog.Level) {
+	zerolog.SetGlobalLevel(level)
+}
+
+// Level returns the level set with SetLevel.
+func Level() zerolog.Level {
+	return zerolog.GlobalLevel()
+}
+
+// Audit returns the logger for security relevant events, such as the
+// authenticated origin of proof requests. Its entries are tagged with
+// log=audit so that they can be routed separately.
+func Audit() *zerolog.Logger {
+	audit := log.With().Str("log", "audit").Logger()
+	return &audit
 }
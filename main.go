@@ -1,17 +1,40 @@
 package main
 
 import (
+	"bytes"
+	"crypto"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/constraint"
 	gnarkLogger "github.com/consensys/gnark/logger"
+	"github.com/rs/zerolog"
 	"github.com/urfave/cli/v2"
 	"io"
 	"math/big"
+	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"worldcoin/gnark-mbu/coordinator"
+	"worldcoin/gnark-mbu/hardware"
+	"worldcoin/gnark-mbu/jobstore"
+	"worldcoin/gnark-mbu/keystore"
 	"worldcoin/gnark-mbu/logging"
+	"worldcoin/gnark-mbu/metrics"
 	"worldcoin/gnark-mbu/prover"
+	"worldcoin/gnark-mbu/replay"
+	"worldcoin/gnark-mbu/secrets"
 	"worldcoin/gnark-mbu/server"
+	"worldcoin/gnark-mbu/watch"
+	"worldcoin/gnark-mbu/worker"
 )
 
 func main() {
@@ -25,144 +48,1241 @@ func main() {
 					&cli.StringFlag{Name: "output", Usage: "Output file", Required: true},
 					&cli.UintFlag{Name: "tree-depth", Usage: "Merkle tree depth", Required: true},
 					&cli.UintFlag{Name: "batch-size", Usage: "Batch size", Required: true},
+					&cli.BoolFlag{Name: "public-post-root", Usage: "expose the post root as a public input", Required: false},
+					&cli.StringFlag{Name: "empty-leaf", Usage: "value of empty tree slots", Value: "0", Required: false},
+					&cli.StringFlag{Name: "curve", Usage: "curve to set up the circuit on", Value: "bn254", Required: false},
+					&cli.StringFlag{Name: "commitment", Usage: "hash binding the inputs to the input hash: keccak, keccak-chained, poseidon or sha256", Value: "keccak", Required: false},
+					&cli.BoolFlag{Name: "indexed", Usage: "insert every identity commitment at its own index instead of consecutively from the start index", Required: false},
+					&cli.BoolFlag{Name: "non-zero-id-comms", Usage: "assert in the circuit that identity commitments are non-zero", Required: false},
+					&cli.StringFlag{Name: "tree-hash", Usage: "hash of the tree nodes: poseidon, poseidon-<full rounds>-<partial rounds> or mimc", Value: "poseidon", Required: false},
+					&cli.BoolFlag{Name: "raw-keys", Usage: "write uncompressed keys, larger but faster to load", Required: false},
 				},
 				Action: func(context *cli.Context) error {
 					path := context.String("output")
 					treeDepth := uint32(context.Uint("tree-depth"))
 					batchSize := uint32(context.Uint("batch-size"))
+					opts, err := circuitOptions(context)
+					if err != nil {
+						return err
+					}
 					logging.Logger().Info().Msg("Running setup")
-					system, err := prover.Setup(treeDepth, batchSize)
+					ctx, stop := signal.NotifyContext(context.Context, os.Interrupt, syscall.SIGTERM)
+					defer stop()
+					written, err := prover.SetupToFile(ctx, path, context.Bool("raw-keys"), treeDepth, batchSize, opts...)
+					if err != nil {
+						return err
+					}
+					logging.Logger().Info().Int64("bytesWritten", written).Msg("proving system written to file")
+					return nil
+				},
+			},
+			{
+				Name: "keygen",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "output", Usage: "Output file", Required: true},
+					&cli.UintFlag{Name: "tree-depth", Usage: "Merkle tree depth", Required: true},
+					&cli.UintFlag{Name: "batch-size", Usage: "Batch size", Required: true},
+					&cli.BoolFlag{Name: "public-post-root", Usage: "expose the post root as a public input", Required: false},
+					&cli.StringFlag{Name: "empty-leaf", Usage: "value of empty tree slots", Value: "0", Required: false},
+					&cli.StringFlag{Name: "curve", Usage: "curve to set up the circuit on", Value: "bn254", Required: false},
+					&cli.StringFlag{Name: "commitment", Usage: "hash binding the inputs to the input hash: keccak, keccak-chained, poseidon or sha256", Value: "keccak", Required: false},
+					&cli.BoolFlag{Name: "indexed", Usage: "insert every identity commitment at its own index instead of consecutively from the start index", Required: false},
+					&cli.BoolFlag{Name: "non-zero-id-comms", Usage: "assert in the circuit that identity commitments are non-zero", Required: false},
+					&cli.StringFlag{Name: "tree-hash", Usage: "hash of the tree nodes: poseidon, poseidon-<full rounds>-<partial rounds> or mimc", Value: "poseidon", Required: false},
+					&cli.BoolFlag{Name: "raw-keys", Usage: "write uncompressed keys, larger but faster to load", Required: false},
+					&cli.StringFlag{Name: "until", Usage: "last stage to run: compile, setup or serialize", Value: string(prover.KeygenSerialize), Required: false},
+				},
+				Action: func(context *cli.Context) error {
+					path := context.String("output")
+					treeDepth := uint32(context.Uint("tree-depth"))
+					batchSize := uint32(context.Uint("batch-size"))
+					opts, err := circuitOptions(context)
+					if err != nil {
+						return err
+					}
+					until, err := prover.ParseKeygenStage(context.String("until"))
+					if err != nil {
+						return err
+					}
+					logging.Logger().Info().Str("until", string(until)).Msg("Running keygen")
+					ctx, stop := signal.NotifyContext(context.Context, os.Interrupt, syscall.SIGTERM)
+					defer stop()
+					written, err := prover.KeygenToFile(ctx, path, context.Bool("raw-keys"), until, treeDepth, batchSize, opts...)
+					if err != nil {
+						return err
+					}
+					if until != prover.KeygenSerialize {
+						checkpoint, keysCheckpoint := prover.KeygenCheckpoints(path)
+						if until == prover.KeygenSetup {
+							checkpoint = keysCheckpoint
+						}
+						logging.Logger().Info().Str("stage", string(until)).Str("checkpoint", checkpoint).Msg("stopped after stage")
+						return nil
+					}
+					logging.Logger().Info().Int64("bytesWritten", written).Msg("proving system written to file")
+					return nil
+				},
+			},
+			{
+				Name: "setup-aggregation",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "output", Usage: "Output file", Required: true},
+					&cli.StringFlag{Name: "keys-file", Usage: "proving system file of the proofs to aggregate, set up on bls12_377", Required: true},
+					&cli.UintFlag{Name: "count", Usage: "number of proofs aggregated", Required: true},
+				},
+				Action: func(context *cli.Context) error {
+					path := context.String("output")
+					ps, err := prover.ReadSystemFromFile(context.String("keys-file"))
+					if err != nil {
+						return err
+					}
+					logging.Logger().Info().Msg("Running aggregation setup")
+					system, err := prover.SetupAggregation(ps, uint32(context.Uint("count")))
+					if err != nil {
+						return err
+					}
+					file, err := os.Create(path)
+					defer file.Close()
+					if err != nil {
+						return err
+					}
+					written, err := system.WriteTo(file)
+					if err != nil {
+						return err
+					}
+					logging.Logger().Info().Int64("bytesWritten", written).Msg("aggregation system written to file")
+					return nil
+				},
+			},
+			{
+				Name: "r1cs",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "output", Usage: "Output file", Required: true},
+					&cli.UintFlag{Name: "tree-depth", Usage: "Merkle tree depth", Required: true},
+					&cli.UintFlag{Name: "batch-size", Usage: "Batch size", Required: true},
+					&cli.BoolFlag{Name: "public-post-root", Usage: "expose the post root as a public input", Required: false},
+					&cli.StringFlag{Name: "empty-leaf", Usage: "value of empty tree slots", Value: "0", Required: false},
+					&cli.StringFlag{Name: "curve", Usage: "curve to set up the circuit on", Value: "bn254", Required: false},
+					&cli.StringFlag{Name: "commitment", Usage: "hash binding the inputs to the input hash: keccak, keccak-chained, poseidon or sha256", Value: "keccak", Required: false},
+					&cli.BoolFlag{Name: "indexed", Usage: "insert every identity commitment at its own index instead of consecutively from the start index", Required: false},
+					&cli.BoolFlag{Name: "non-zero-id-comms", Usage: "assert in the circuit that identity commitments are non-zero", Required: false},
+					&cli.StringFlag{Name: "tree-hash", Usage: "hash of the tree nodes: poseidon, poseidon-<full rounds>-<partial rounds> or mimc", Value: "poseidon", Required: false},
+				},
+				Action: func(context *cli.Context) error {
+					path := context.String("output")
+					treeDepth := uint32(context.Uint("tree-depth"))
+					batchSize := uint32(context.Uint("batch-size"))
+					opts, err := circuitOptions(context)
+					if err != nil {
+						return err
+					}
+					logging.Logger().Info().Msg("Building R1CS")
+					cs, err := prover.BuildR1CS(context.Context, treeDepth, batchSize, opts...)
+					if err != nil {
+						return err
+					}
+					file, err := os.Create(path)
+					defer file.Close()
+					if err != nil {
+						return err
+					}
+					written, err := cs.WriteTo(file)
+					if err != nil {
+						return err
+					}
+					logging.Logger().Info().Int64("bytesWritten", written).Msg("R1CS written to file")
+					return nil
+				},
+			},
+			{
+				Name: "circuit-fingerprint",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "keys-file", Usage: "proving system file whose constraint system is hashed instead of compiling the circuit", Required: false},
+					&cli.UintFlag{Name: "tree-depth", Usage: "Merkle tree depth", Required: false},
+					&cli.UintFlag{Name: "batch-size", Usage: "Batch size", Required: false},
+					&cli.BoolFlag{Name: "public-post-root", Usage: "expose the post root as a public input", Required: false},
+					&cli.StringFlag{Name: "empty-leaf", Usage: "value of empty tree slots", Value: "0", Required: false},
+					&cli.StringFlag{Name: "curve", Usage: "curve to set up the circuit on", Value: "bn254", Required: false},
+					&cli.StringFlag{Name: "commitment", Usage: "hash binding the inputs to the input hash: keccak, keccak-chained, poseidon or sha256", Value: "keccak", Required: false},
+					&cli.BoolFlag{Name: "indexed", Usage: "insert every identity commitment at its own index instead of consecutively from the start index", Required: false},
+					&cli.BoolFlag{Name: "non-zero-id-comms", Usage: "assert in the circuit that identity commitments are non-zero", Required: false},
+					&cli.StringFlag{Name: "tree-hash", Usage: "hash of the tree nodes: poseidon, poseidon-<full rounds>-<partial rounds> or mimc", Value: "poseidon", Required: false},
+					&cli.StringFlag{Name: "expect", Usage: "constraint system hash the circuit must have, failing otherwise", Required: false},
+				},
+				Action: func(context *cli.Context) error {
+					var fingerprint *prover.CircuitFingerprint
+					if path := context.String("keys-file"); path != "" {
+						ps, err := prover.ReadSystemFromFile(path)
+						if err != nil {
+							return err
+						}
+						if fingerprint, err = ps.CircuitFingerprint(); err != nil {
+							return err
+						}
+					} else {
+						if !context.IsSet("tree-depth") || !context.IsSet("batch-size") {
+							return fmt.Errorf("either keys-file or tree-depth and batch-size are required")
+						}
+						opts, err := circuitOptions(context)
+						if err != nil {
+							return err
+						}
+						logging.Logger().Info().Msg("Building R1CS")
+						fingerprint, err = prover.FingerprintCircuit(context.Context, uint32(context.Uint("tree-depth")), uint32(context.Uint("batch-size")), opts...)
+						if err != nil {
+							return err
+						}
+					}
+					r, err := json.MarshalIndent(fingerprint, "", "  ")
+					if err != nil {
+						return err
+					}
+					fmt.Println(string(r))
+					if expected := context.String("expect"); expected != "" && !strings.EqualFold(expected, fingerprint.ConstraintSystemHash) {
+						return fmt.Errorf("the constraint system hash %s is not the expected %s", fingerprint.ConstraintSystemHash, expected)
+					}
+					return nil
+				},
+			},
+			{
+				Name: "export-r1cs",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "output", Usage: "Output file", Required: true},
+					&cli.StringFlag{Name: "format", Usage: "format of the constraint system: gnark or circom", Value: "gnark", Required: false},
+					&cli.StringFlag{Name: "keys-file", Usage: "proving system file whose constraint system is exported instead of compiling the circuit", Required: false},
+					&cli.UintFlag{Name: "tree-depth", Usage: "Merkle tree depth", Required: false},
+					&cli.UintFlag{Name: "batch-size", Usage: "Batch size", Required: false},
+					&cli.BoolFlag{Name: "public-post-root", Usage: "expose the post root as a public input", Required: false},
+					&cli.StringFlag{Name: "empty-leaf", Usage: "value of empty tree slots", Value: "0", Required: false},
+					&cli.StringFlag{Name: "curve", Usage: "curve to set up the circuit on", Value: "bn254", Required: false},
+					&cli.StringFlag{Name: "commitment", Usage: "hash binding the inputs to the input hash: keccak, keccak-chained, poseidon or sha256", Value: "keccak", Required: false},
+					&cli.BoolFlag{Name: "indexed", Usage: "insert every identity commitment at its own index instead of consecutively from the start index", Required: false},
+					&cli.BoolFlag{Name: "non-zero-id-comms", Usage: "assert in the circuit that identity commitments are non-zero", Required: false},
+					&cli.StringFlag{Name: "tree-hash", Usage: "hash of the tree nodes: poseidon, poseidon-<full rounds>-<partial rounds> or mimc", Value: "poseidon", Required: false},
+				},
+				Action: func(context *cli.Context) error {
+					format, err := prover.ParseR1CSFormat(context.String("format"))
+					if err != nil {
+						return err
+					}
+					var cs constraint.ConstraintSystem
+					if path := context.String("keys-file"); path != "" {
+						ps, err := prover.ReadSystemFromFile(path)
+						if err != nil {
+							return err
+						}
+						cs = ps.ConstraintSystem
+					} else {
+						if !context.IsSet("tree-depth") || !context.IsSet("batch-size") {
+							return fmt.Errorf("either keys-file or tree-depth and batch-size are required")
+						}
+						opts, err := circuitOptions(context)
+						if err != nil {
+							return err
+						}
+						logging.Logger().Info().Msg("Building R1CS")
+						cs, err = prover.BuildR1CS(context.Context, uint32(context.Uint("tree-depth")), uint32(context.Uint("batch-size")), opts...)
+						if err != nil {
+							return err
+						}
+					}
+					file, err := os.Create(context.String("output"))
+					if err != nil {
+						return err
+					}
+					defer file.Close()
+					written, err := prover.ExportR1CS(file, cs, format)
+					if err != nil {
+						return err
+					}
+					logging.Logger().Info().Int64("bytesWritten", written).Str("format", string(format)).Msg("R1CS exported to file")
+					return file.Close()
+				},
+			},
+			{
+				Name: "reheader-keys",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "keys-file", Usage: "proving system file with a stale header, in the key encoding of this gnark version", Required: true},
+					&cli.StringFlag{Name: "output", Usage: "Output file", Required: true},
+					&cli.BoolFlag{Name: "raw-keys", Usage: "write uncompressed keys, larger but faster to load", Required: false},
+				},
+				Action: func(context *cli.Context) error {
+					ctx, stop := signal.NotifyContext(context.Context, os.Interrupt, syscall.SIGTERM)
+					defer stop()
+					ps, err := prover.ReheaderKeysFromFile(ctx, context.String("keys-file"))
+					if err != nil {
+						return err
+					}
+					// The keys are only written once a proof verifies, so
+					// that keys that do not match the circuit are caught here
+					// rather than by the servers loading them.
+					named, err := testVectorParams(nil, ps)
+					if err != nil {
+						return err
+					}
+					params := named[0].params
+					logging.Logger().Info().Msg("proving a test batch")
+					proof, err := ps.Prove(ctx, params)
+					if err != nil {
+						return fmt.Errorf("the re-headered keys cannot prove a test batch: %w", err)
+					}
+					if ps.PublicPostRoot {
+						err = ps.VerifyWithPostRoot(params.InputHash, params.PostRoot, proof)
+					} else {
+						err = ps.Verify(params.InputHash, proof)
+					}
+					if err != nil {
+						return fmt.Errorf("the test proof of the re-headered keys does not verify: %w", err)
+					}
+					logging.Logger().Info().Msg("test proof verified")
+					written, err := ps.WriteToFile(ctx, context.String("output"), context.Bool("raw-keys"))
+					if err != nil {
+						return err
+					}
+					logging.Logger().Info().Int64("bytesWritten", written).Str("fingerprint", ps.Fingerprint()).Msg("re-headered proving system written to file")
+					return nil
+				},
+			},
+			{
+				Name: "profile",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "output", Usage: "pprof output file", Value: "circuit.pprof", Required: false},
+					&cli.UintFlag{Name: "tree-depth", Usage: "Merkle tree depth", Required: true},
+					&cli.UintFlag{Name: "batch-size", Usage: "Batch size", Required: true},
+					&cli.BoolFlag{Name: "public-post-root", Usage: "expose the post root as a public input", Required: false},
+					&cli.StringFlag{Name: "empty-leaf", Usage: "value of empty tree slots", Value: "0", Required: false},
+					&cli.StringFlag{Name: "curve", Usage: "curve to set up the circuit on", Value: "bn254", Required: false},
+					&cli.StringFlag{Name: "commitment", Usage: "hash binding the inputs to the input hash: keccak, keccak-chained, poseidon or sha256", Value: "keccak", Required: false},
+					&cli.BoolFlag{Name: "indexed", Usage: "insert every identity commitment at its own index instead of consecutively from the start index", Required: false},
+					&cli.BoolFlag{Name: "non-zero-id-comms", Usage: "assert in the circuit that identity commitments are non-zero", Required: false},
+					&cli.StringFlag{Name: "tree-hash", Usage: "hash of the tree nodes: poseidon, poseidon-<full rounds>-<partial rounds> or mimc", Value: "poseidon", Required: false},
+					&cli.BoolFlag{Name: "lookup-keccak", Usage: "hash keccak and keccak-chained inputs with the lookup-based Keccak gadget", Required: false},
+				},
+				Action: func(context *cli.Context) error {
+					treeDepth := uint32(context.Uint("tree-depth"))
+					batchSize := uint32(context.Uint("batch-size"))
+					opts, err := circuitOptions(context)
+					if err != nil {
+						return err
+					}
+					logging.Logger().Info().Msg("Profiling R1CS")
+					profile, err := prover.ProfileR1CS(context.Context, context.String("output"), treeDepth, batchSize, opts...)
+					if err != nil {
+						return err
+					}
+					r, err := json.MarshalIndent(profile, "", "  ")
+					if err != nil {
+						return err
+					}
+					fmt.Println(string(r))
+					return nil
+				},
+			},
+			{
+				Name: "export-vk",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "keys-file", Usage: "proving system file", Required: true},
+					&cli.StringFlag{Name: "output", Usage: "Output file", Required: true},
+				},
+				Action: func(context *cli.Context) error {
+					ps, err := prover.ReadSystemFromFile(context.String("keys-file"))
+					if err != nil {
+						return err
+					}
+					written, err := ps.WriteVerifyingKeyToFile(context.String("output"))
+					if err != nil {
+						return err
+					}
+					logging.Logger().Info().Int64("bytesWritten", written).Msg("verifying key written to file")
+					return nil
+				},
+			},
+			{
+				Name: "export-vk-json",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "keys-file", Usage: "proving system file", Required: false},
+					&cli.StringFlag{Name: "vk-file", Usage: "verifying key file (generated from export-vk), instead of keys-file", Required: false},
+					&cli.StringFlag{Name: "output", Usage: "JSON output (will write to stdout if not provided)", Required: false},
+				},
+				Action: func(context *cli.Context) error {
+					vs, err := readVerifyingSystem(context)
+					if err != nil {
+						return err
+					}
+					var output io.Writer
+					if outPath := context.String("output"); outPath != "" {
+						file, err := os.Create(outPath)
+						if err != nil {
+							return err
+						}
+						defer file.Close()
+						output = file
+					} else {
+						output = os.Stdout
+					}
+					return vs.ExportVerifyingKeyJSON(output)
+				},
+			},
+			{
+				Name: "export-solidity",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "keys-file", Usage: "proving system file", Required: false},
+					&cli.StringFlag{Name: "vk-file", Usage: "verifying key file (generated from export-vk), instead of keys-file", Required: false},
+					&cli.StringFlag{Name: "output", Usage: "solidity output (will write to stdout if not provided)", Required: false},
+				},
+				Action: func(context *cli.Context) error {
+					ps, err := readVerifyingSystem(context)
+					if err != nil {
+						return err
+					}
+					var output io.Writer
+					if outPath := context.String("output"); outPath != "" {
+						file, err := os.Create(outPath)
+						defer file.Close()
+						if err != nil {
+							return err
+						}
+						output = file
+					} else {
+						output = os.Stdout
+					}
+					return ps.ExportSolidity(output)
+				},
+			},
+			{
+				Name: "gas-report",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "keys-file", Usage: "proving system file", Required: false},
+					&cli.StringFlag{Name: "vk-file", Usage: "verifying key file (generated from export-vk), instead of keys-file", Required: false},
+				},
+				Action: func(context *cli.Context) error {
+					vs, err := readVerifyingSystem(context)
+					if err != nil {
+						return err
+					}
+					report, err := json.MarshalIndent(vs.GasReport(), "", "  ")
+					if err != nil {
+						return err
+					}
+					fmt.Println(string(report))
+					return nil
+				},
+			},
+			{
+				Name: "bench",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "keys-file", Usage: "proving system file", Required: true},
+					&cli.IntFlag{Name: "count", Usage: "number of test batches to prove, none to only report the statistics of the proving system", Value: 1, Required: false},
+				},
+				Action: func(context *cli.Context) error {
+					count := context.Int("count")
+					if count < 0 {
+						return fmt.Errorf("count must not be negative")
+					}
+					ps, err := prover.ReadSystemFromFile(context.String("keys-file"))
+					if err != nil {
+						return err
+					}
+					report := benchReport{Stats: ps.Stats()}
+					logging.Logger().Info().Int("constraints", report.Stats.Constraints).Int64("provingKeySize", report.Stats.ProvingKeySize).Int64("witnessSize", report.Stats.WitnessSize).Msg("Read proving system")
+					if count > 0 {
+						if report.ProofSeconds, err = benchProofs(context, ps, count); err != nil {
+							return err
+						}
+					}
+					r, err := json.MarshalIndent(report, "", "  ")
+					if err != nil {
+						return err
+					}
+					fmt.Println(string(r))
+					return nil
+				},
+			},
+			{
+				Name: "gen-test-params",
+				Flags: []cli.Flag{
+					&cli.UintFlag{Name: "tree-depth", Usage: "depth of the mock tree", Required: true},
+					&cli.UintFlag{Name: "batch-size", Usage: "batch size", Required: true},
+					&cli.StringFlag{Name: "empty-leaf", Usage: "value of empty tree slots", Value: "0", Required: false},
+					&cli.StringFlag{Name: "commitment", Usage: "hash computing the input hash: keccak, keccak-chained, poseidon or sha256", Value: "keccak", Required: false},
+					&cli.BoolFlag{Name: "indexed", Usage: "insert at every other index, for keys set up with indexed", Required: false},
+					&cli.StringFlag{Name: "tree-hash", Usage: "hash of the tree nodes: poseidon, poseidon-<full rounds>-<partial rounds> or mimc", Value: "poseidon", Required: false},
+				},
+				Action: func(context *cli.Context) error {
+					treeDepth := context.Int("tree-depth")
+					batchSize := uint32(context.Uint("batch-size"))
+					var emptyLeaf big.Int
+					_, ok := emptyLeaf.SetString(context.String("empty-leaf"), 0)
+					if !ok {
+						return fmt.Errorf("invalid number: %s", context.String("empty-leaf"))
+					}
+					commitment, err := prover.ParseCommitment(context.String("commitment"))
+					if err != nil {
+						return err
+					}
+					treeHash, err := prover.NativeTreeHash(prover.TreeHash(context.String("tree-hash")), ecc.BN254)
+					if err != nil {
+						return err
+					}
+					logging.Logger().Info().Msg("Generating test params")
+					params, err := testParams(treeDepth, batchSize, emptyLeaf, treeHash, context.Bool("indexed"), 0)
+					if err != nil {
+						return err
+					}
+					if err = params.ComputeInputHashWith(commitment); err != nil {
+						return err
+					}
+					r, _ := json.Marshal(params)
+					fmt.Println(string(r))
+					return nil
+				},
+			},
+			{
+				Name: "start",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "keys-file", Usage: "proving system file, or an s3://bucket/key or gs://bucket/object URL, the largest file of keys-dir if not provided", Required: false},
+					&cli.StringFlag{Name: "keys-dir", Usage: "directory of proving system files, requests are proven with the file matching their tree depth, batch size and mode", Required: false},
+					&cli.BoolFlag{Name: "lazy-keys", Usage: "load the files of keys-dir on first use instead of at startup", Required: false},
+					&cli.BoolFlag{Name: "dev", Usage: "set up throwaway keys for a tiny circuit at startup instead of loading keys, for development", Required: false},
+					&cli.StringFlag{Name: "keys-sha256", Usage: "SHA-256 checksum the keys file must match", Required: false},
+					&cli.StringFlag{Name: "keys-cache-dir", Usage: "directory remote keys files are downloaded to", Value: os.TempDir(), Required: false},
+					&cli.BoolFlag{Name: "json-logging", Usage: "enable JSON logging", Required: false},
+					&cli.StringFlag{Name: "prover-address", Usage: "address for the prover server", Value: "localhost:3001", Required: false},
+					&cli.StringFlag{Name: "metrics-address", Usage: "address for the metrics server", Value: "localhost:9998", Required: false},
+					&cli.IntFlag{Name: "threads", Usage: "number of threads used for proving, detected if not provided", Required: false},
+					&cli.IntFlag{Name: "reserved-cpus", Usage: "CPUs the process is pinned off when threads is not provided, left to the kernel and the rest of the host, as gnark starts a goroutine per CPU for every proof (Linux only)", Value: 1, Required: false},
+					&cli.StringFlag{Name: "cpu-affinity", Usage: "CPU list the process is pinned to, such as 0-15,32-47 (Linux only)", Required: false},
+					&cli.IntFlag{Name: "numa-node", Usage: "NUMA node whose CPUs the process is pinned to, -1 not to pin it (Linux only)", Value: -1, Required: false},
+					&cli.BoolFlag{Name: "legacy-json", Usage: "accept the legacy sequencer JSON dialect unless requests are sent as application/json", Required: false},
+					&cli.IntFlag{Name: "max-concurrent-proofs", Usage: "maximum number of proofs generated at once, 0 for unbounded", Required: false},
+					&cli.Int64Flag{Name: "memory-budget", Usage: "megabytes of memory the proofs generated at once may take on top of the keys, 0 for unbounded", Required: false},
+					&cli.Int64Flag{Name: "proof-memory", Usage: "megabytes of memory a proof is assumed to take, estimated from the circuit if not provided", Required: false},
+					&cli.BoolFlag{Name: "memory-budget-queue", Usage: "queue proofs exceeding memory-budget until running ones finish instead of rejecting them", Required: false},
+					&cli.DurationFlag{Name: "autoscale-target-latency", Usage: "deadline assumed by the autoscaling signal for requests without one", Value: 5 * time.Minute, Required: false},
+					&cli.BoolFlag{Name: "self-verify", Usage: "verify every proof against the verifying key before returning it", Required: false},
+					&cli.StringFlag{Name: "result-signing-key", Usage: "PEM file or secret reference of the Ed25519 or ECDSA private key proofs are signed with, or awskms://<key id> for an AWS KMS key", Required: false},
+					&cli.DurationFlag{Name: "priority-aging", Usage: "time after which a queued proof is promoted one priority class", Value: 5 * time.Minute, Required: false},
+					&cli.StringFlag{Name: "client-keys-dir", Usage: "directory of <client id>.pem public keys signed requests are verified against", Required: false},
+					&cli.BoolFlag{Name: "require-signatures", Usage: "reject requests not signed by a registered client key", Required: false},
+					&cli.StringFlag{Name: "aggregation-keys-file", Usage: "aggregation system file, enables /aggregate, whose proofs are verified off-chain only", Required: false},
+					&cli.IntFlag{Name: "batch-workers", Usage: "number of parameter sets of a /prove_batch request proven at once", Value: 1, Required: false},
+					&cli.StringFlag{Name: "proof-encoding", Usage: "encoding of proofs in responses: default or compressed", Value: "default", Required: false},
+					&cli.BoolFlag{Name: "decimal-json", Usage: "write field elements in responses as decimal strings instead of 32-byte hex", Required: false},
+					&cli.DurationFlag{Name: "prove-timeout", Usage: "time a request waits for its proof, including queueing, before failing with a timeout (0 = no timeout)", Value: 0, Required: false},
+					&cli.DurationFlag{Name: "read-timeout", Usage: "time to read a request, body included (0 = no timeout)", Value: 0, Required: false},
+					&cli.DurationFlag{Name: "write-timeout", Usage: "time to serve a request once its headers are read, which should exceed prove-timeout (0 = no timeout)", Value: 0, Required: false},
+					&cli.DurationFlag{Name: "idle-timeout", Usage: "time a keep-alive connection waits for its next request (0 = read-timeout)", Value: 0, Required: false},
+					&cli.DurationFlag{Name: "slow-request-threshold", Usage: "serving time above which a proof request is logged as slow, with its batch size and phase timings (0 = never)", Value: 0, Required: false},
+					&cli.BoolFlag{Name: "keys-anonymous", Usage: "read an s3:// or gs:// keys file without credentials, for public buckets", Required: false},
+					&cli.StringFlag{Name: "tls-cert", Usage: "PEM file or secret reference (vault://, awssm://, awskms+file://) of the certificate chain the prover and admin APIs are served over HTTPS with, together with tls-key", Required: false},
+					&cli.StringFlag{Name: "tls-key", Usage: "PEM file or secret reference (vault://, awssm://, awskms+file://) of the private key of tls-cert", Required: false},
+					&cli.Float64Flag{Name: "rate-limit-ip", Usage: "proof requests per second allowed per IP address, 0 for unlimited", Required: false},
+					&cli.IntFlag{Name: "rate-limit-ip-burst", Usage: "proof requests per IP address allowed at once, defaults to the rate", Required: false},
+					&cli.Float64Flag{Name: "rate-limit-client", Usage: "proof requests per second allowed per signing client, 0 for unlimited", Required: false},
+					&cli.IntFlag{Name: "rate-limit-client-burst", Usage: "proof requests per signing client allowed at once, defaults to the rate", Required: false},
+					&cli.DurationFlag{Name: "drain-grace-period", Usage: "time in-flight proofs are given to complete on shutdown before they are cancelled", Value: 2 * time.Minute, Required: false},
+					&cli.StringFlag{Name: "tenants-file", Usage: "YAML file of the tenants whose API keys the proof endpoints require, reloaded when it changes", Required: false},
+					&cli.UintFlag{Name: "start-index-alignment", Usage: "reject batches whose start index is not a multiple of it, 0 to accept any", Required: false},
+					&cli.StringFlag{Name: "rate-limits-file", Usage: "JSON file mapping client ids to {\"rate\": ..., \"burst\": ...} overriding rate-limit-client", Required: false},
+					&cli.IntFlag{Name: "witness-workers", Usage: "number of goroutines building each witness, the proving threads if not provided", Required: false},
+					&cli.Int64Flag{Name: "max-body-bytes", Usage: "maximum size of request bodies, 0 for unlimited", Value: 64 << 20, Required: false},
+					&cli.IntFlag{Name: "max-batch-size", Usage: "maximum number of elements of any JSON, CBOR or MessagePack array in a request, 0 for unlimited", Required: false},
+					&cli.IntFlag{Name: "max-json-depth", Usage: "maximum nesting of objects, maps and arrays in a request, JSON or binary, 0 for unlimited", Value: 16, Required: false},
+					&cli.StringFlag{Name: "log-level", Usage: "minimum level of log entries: trace, debug, info, warn or error", Value: "info", Required: false},
+					&cli.StringFlag{Name: "log-output", Usage: "stderr, stdout or the path of a log file, stdout for JSON logging and stderr otherwise if not provided", Required: false},
+					&cli.Int64Flag{Name: "log-max-size", Usage: "size in megabytes a log file is rotated at, 0 to never rotate it", Value: 100, Required: false},
+					&cli.IntFlag{Name: "log-max-backups", Usage: "number of rotated log files kept", Value: 5, Required: false},
+					&cli.BoolFlag{Name: "pprof", Usage: "serve net/http/pprof profiles under /debug/pprof/ on the metrics address", Required: false},
+					&cli.StringFlag{Name: "metrics-exporter", Usage: "backend the metrics are also pushed to: statsd://host:port, datadog://host:port (DogStatsD tags), or otlp://host:port or otlps://host:port (OTLP over HTTP)", Required: false},
+					&cli.DurationFlag{Name: "metrics-push-interval", Usage: "interval the metrics are pushed to the metrics-exporter at", Value: 10 * time.Second, Required: false},
+					&cli.IntFlag{Name: "proof-cache-size", Usage: "number of proofs kept in memory to answer retried requests, 0 to disable the cache", Value: 1024, Required: false},
+					&cli.DurationFlag{Name: "proof-cache-ttl", Usage: "time proofs are kept in the proof cache, 0 to keep them until evicted", Value: time.Hour, Required: false},
+					&cli.StringFlag{Name: "proof-cache-redis", Usage: "redis://host/<key prefix> URL of a Redis server the proof cache is kept in instead of memory", Required: false},
+					&cli.StringFlag{Name: "job-store", Usage: "store of the async proof jobs served under /jobs: memory, sqlite://<path> or a postgres:// URL; the async mode is disabled if unset", Required: false},
+					&cli.DurationFlag{Name: "job-retention", Usage: "time finished async jobs are kept, 0 to keep them", Value: 24 * time.Hour, Required: false},
+					&cli.StringFlag{Name: "callback-secret-file", Usage: "file or secret reference (vault://, awssm://, awskms+file://) holding the secret callbacks are signed with; callback_url is rejected if unset", Required: false},
+					&cli.StringSliceFlag{Name: "callback-allowed-urls", Usage: "callback URLs requests may ask for, required with callback-secret-file: hosts, with an optional port, *.domain wildcards, or URL prefixes", Required: false},
+					&cli.IntFlag{Name: "callback-attempts", Usage: "number of times a callback is tried before giving up", Value: 5, Required: false},
+					&cli.DurationFlag{Name: "callback-backoff", Usage: "wait before retrying a failed callback, doubled for every next retry", Value: time.Second, Required: false},
+					&cli.DurationFlag{Name: "callback-max-backoff", Usage: "maximum wait between callback retries", Value: time.Minute, Required: false},
+					&cli.DurationFlag{Name: "callback-timeout", Usage: "timeout of each callback attempt", Value: 10 * time.Second, Required: false},
+					&cli.BoolFlag{Name: "witness-dump", Usage: "write the witness of every failed proof to a file and log its path, for debugging", Required: false},
+					&cli.StringFlag{Name: "witness-dump-dir", Usage: "directory of the witness dumps, the temporary directory if not provided", Required: false},
+					&cli.BoolFlag{Name: "witness-dump-redact", Usage: "leave the identity commitments and the private witness out of the witness dumps", Required: false},
+					&cli.StringSliceFlag{Name: "cors-allowed-origins", Usage: "origins browsers may call the API from, * for any; CORS is disabled if unset", Required: false},
+					&cli.StringSliceFlag{Name: "cors-allowed-methods", Usage: "methods browsers may call the API with from the allowed origins", Value: cli.NewStringSlice("GET", "POST"), Required: false},
+					&cli.DurationFlag{Name: "cors-max-age", Usage: "time browsers may cache preflight responses, 0 to leave it to them", Value: 10 * time.Minute, Required: false},
+					&cli.StringFlag{Name: "mode", Usage: "endpoints served: both, prover for the proof endpoints only or verifier for the verification endpoints only", Value: "both", Required: false},
+					&cli.StringFlag{Name: "vk-file", Usage: "verifying key file exported with export-vk, loaded instead of keys in verifier mode, and otherwise verifying proofs while the keys fail to load", Required: false},
+					&cli.StringFlag{Name: "admin-address", Usage: "address of the read-only admin API, which is disabled if unset", Required: false},
+					&cli.StringFlag{Name: "admin-token-file", Usage: "file or secret reference (vault://, awssm://, awskms+file://) holding the token requests to the admin API must carry, required with admin-address", Required: false},
+					&cli.StringFlag{Name: "ledger", Usage: "ledger rejecting duplicate and conflicting batches: memory or a redis://host/<key prefix> URL; any batch is proven if unset", Required: false},
+					&cli.IntFlag{Name: "proof-retries", Usage: "number of times a proof failing with a transient error is tried again, 0 to never retry", Value: 0, Required: false},
+					&cli.DurationFlag{Name: "proof-retry-backoff", Usage: "wait before retrying a proof, doubled for every next retry and jittered", Value: time.Second, Required: false},
+					&cli.DurationFlag{Name: "proof-retry-max-backoff", Usage: "maximum wait between proof retries", Value: 30 * time.Second, Required: false},
+					&cli.StringFlag{Name: "record-file", Usage: "NDJSON file the proof requests served are appended to, stripped of their credentials, for the replay command", Required: false},
+				},
+				Action: func(context *cli.Context) error {
+					if err := configureLogging(context); err != nil {
+						return err
+					}
+					keys := context.String("keys-file")
+					dev := context.Bool("dev")
+					if dev && (keys != "" || context.String("keys-dir") != "") {
+						return fmt.Errorf("dev sets up its own keys, keys-file and keys-dir cannot be given")
+					}
+					mode, err := server.ParseMode(context.String("mode"))
+					if err != nil {
+						return err
+					}
+					verifier := mode == server.ModeVerifier
+					vkFile := context.String("vk-file")
+					if verifier {
+						if vkFile == "" {
+							return fmt.Errorf("verifier mode requires vk-file")
+						}
+						if dev || keys != "" || context.String("keys-dir") != "" {
+							return fmt.Errorf("verifier mode loads only the verifying key of vk-file, keys-file, keys-dir and dev cannot be given")
+						}
+					}
+					var keyFiles []server.KeyFile
+					if dir := context.String("keys-dir"); dir != "" {
+						if keyFiles, err = server.ScanKeysDir(dir); err != nil {
+							return err
+						}
+						if len(keyFiles) == 0 {
+							return fmt.Errorf("no key files found in %s", dir)
+						}
+						if keys == "" {
+							keys = largestKeyFile(keyFiles).Path
+						}
+					} else if keys == "" && !dev && !verifier {
+						return fmt.Errorf("either keys-file or keys-dir is required")
+					}
+					proofEncoding, err := prover.ParseProofEncoding(context.String("proof-encoding"))
+					if err != nil {
+						return err
+					}
+					fetchKeys := func() (string, error) {
+						return keystore.Fetch(context.Context, keys, keystore.Options{
+							CacheDir:  context.String("keys-cache-dir"),
+							SHA256:    context.String("keys-sha256"),
+							Retries:   5,
+							Anonymous: context.Bool("keys-anonymous"),
+						})
+					}
+					// Failing to load the keys degrades the server instead of
+					// stopping it, so endpoints not needing them stay available.
+					var (
+						keysPath string
+						keysErr  error
+					)
+					if !dev && !verifier {
+						keysPath, keysErr = fetchKeys()
+					}
+					profile := hardware.Detect()
+					cpus, err := cpuAffinity(context, &profile)
+					if err != nil {
+						return err
+					}
+					selection := hardware.Select(profile, hardware.Overrides{
+						Threads:      context.Int("threads"),
+						ReservedCPUs: context.Int("reserved-cpus"),
+						CPUs:         cpus,
+					})
+					// The reserved CPUs are kept free by pinning the
+					// process off them, as Go runs proofs and requests
+					// on the same threads.
+					if selection.ProvingCPUs != nil {
+						if err = hardware.PinCPUs(selection.ProvingCPUs); err != nil {
+							return err
+						}
+					}
+					runtime.GOMAXPROCS(selection.Threads)
+					logging.Logger().Info().
+						Strs("cpuFeatures", selection.Profile.CPUFeatures).
+						Uint64("availableMemory", selection.Profile.AvailableMemory).
+						Strs("gpus", selection.Profile.GPUs).
+						Int("numaNodes", len(selection.Profile.NUMANodes)).
+						Int("threads", selection.Threads).
+						Bool("gpu", selection.GPU).
+						Strs("reasons", selection.Reasons).
+						Msg("Selected proving configuration")
+					readKeys := func(path string) (*prover.ProvingSystem, error) {
+						ps, err := prover.ReadSystemFromFile(path)
+						if err != nil {
+							return nil, err
+						}
+						ps.WitnessWorkers = context.Int("witness-workers")
+						ps.WitnessDump = witnessDump(context)
+						return ps, nil
+					}
+					readVerifyingKey := func() (*prover.ProvingSystem, error) {
+						vs, err := prover.ReadVerifyingSystemFromFile(vkFile)
+						if err != nil {
+							return nil, err
+						}
+						return vs.ProvingSystem(), nil
+					}
+					// Reloads fetch the keys again, picking up a replaced object.
+					loadKeys := func() (*prover.ProvingSystem, error) {
+						path, err := fetchKeys()
+						if err != nil {
+							return nil, err
+						}
+						return readKeys(path)
+					}
+					var ps *prover.ProvingSystem
+					if dev {
+						logging.Logger().Warn().Uint32("treeDepth", prover.DevTreeDepth).Uint32("batchSize", prover.DevBatchSize).Msg("Setting up throwaway development keys, proofs only verify against this process")
+						if ps, keysErr = prover.SetupDev(context.Context); keysErr == nil {
+							ps.WitnessWorkers = context.Int("witness-workers")
+							ps.WitnessDump = witnessDump(context)
+						}
+						// There is no key file to reload.
+						loadKeys = nil
+					} else if verifier {
+						logging.Logger().Info().Msg("Reading verifying key from file")
+						ps, keysErr = readVerifyingKey()
+						loadKeys = readVerifyingKey
+					} else if keysErr == nil {
+						logging.Logger().Info().Msg("Reading proving system from file")
+						ps, keysErr = readKeys(keysPath)
+					}
+					if keysErr != nil {
+						ps = nil
+						logging.Logger().Error().Err(keysErr).Msg("Failed to read proving system")
+					} else {
+						logging.Logger().Info().Stringer("curve", ps.Curve).Uint32("treeDepth", ps.TreeDepth).Uint32("batchSize", ps.BatchSize).Msg("Read proving system")
+					}
+					// Outside of verifier mode, the verifying key is loaded
+					// apart from the keys, to keep verifying proofs if they
+					// fail to load.
+					var vs *prover.VerifyingSystem
+					if vkFile != "" && !verifier {
+						if vs, err = prover.ReadVerifyingSystemFromFile(vkFile); err != nil {
+							return err
+						}
+						if ps != nil && vs.Fingerprint() != ps.Fingerprint() {
+							return fmt.Errorf("the verifying key of vk-file is not that of the keys")
+						}
+					}
+					var clientKeys server.ClientKeys
+					if dir := context.String("client-keys-dir"); dir != "" {
+						clientKeys, err = server.LoadClientKeys(dir)
+						if err != nil {
+							return err
+						}
+						logging.Logger().Info().Int("clients", len(clientKeys)).Msg("Loaded client keys")
+					}
+					if context.Bool("require-signatures") && len(clientKeys) == 0 {
+						return fmt.Errorf("signatures are required but no client keys are registered")
+					}
+					var aggregation *prover.AggregationSystem
+					if path := context.String("aggregation-keys-file"); path != "" {
+						aggregation, err = prover.ReadAggregationSystemFromFile(path)
+						if err != nil {
+							return err
+						}
+						logging.Logger().Info().Uint32("count", aggregation.Count).Msg("Read aggregation system")
+					}
+					rateLimits, err := rateLimits(context)
+					if err != nil {
+						return err
+					}
+					memoryBudget, err := memoryBudget(context, ps)
+					if err != nil {
+						return err
+					}
+					// Secret references are read with the providers of the
+					// environment, such as Vault or AWS Secrets Manager.
+					resolver := secrets.NewResolver()
+					var tenants []server.Tenant
+					if path := context.String("tenants-file"); path != "" {
+						if tenants, err = server.LoadTenants(context.Context, path, resolver); err != nil {
+							return err
+						}
+						logging.Logger().Info().Int("tenants", len(tenants)).Msg("Read tenants")
+					}
+					proofCache, err := proofCache(context)
+					if err != nil {
+						return err
+					}
+					callbacks, err := callbacks(context, resolver)
+					if err != nil {
+						return err
+					}
+					adminToken, err := adminToken(context, resolver)
+					if err != nil {
+						return err
+					}
+					tlsCertificate, err := tlsCertificate(context, resolver)
+					if err != nil {
+						return err
+					}
+					ledger, err := ledger(context)
+					if err != nil {
+						return err
+					}
+					proofRetries, err := proofRetries(context)
+					if err != nil {
+						return err
+					}
+					var resultSigner crypto.Signer
+					if location := context.String("result-signing-key"); location != "" {
+						if resultSigner, err = keystore.LoadSigner(context.Context, location); err != nil {
+							return err
+						}
+					}
+					var metricsExporter metrics.Exporter
+					if location := context.String("metrics-exporter"); location != "" {
+						if context.Duration("metrics-push-interval") <= 0 {
+							return fmt.Errorf("metrics-push-interval must be positive")
+						}
+						// The server closes the exporter once it pushed the
+						// last metrics.
+						if metricsExporter, err = metrics.Open(location); err != nil {
+							return err
+						}
+					}
+					var jobs jobstore.Store
+					if location := context.String("job-store"); location != "" {
+						if jobs, err = jobstore.Open(location); err != nil {
+							return err
+						}
+						defer jobs.Close()
+					}
+					var recorder *replay.Recorder
+					if path := context.String("record-file"); path != "" {
+						if recorder, err = replay.NewRecorder(path); err != nil {
+							return err
+						}
+						defer recorder.Close()
+					}
+					requestLimits := server.RequestLimits{
+						MaxBodyBytes: context.Int64("max-body-bytes"),
+						MaxBatchSize: context.Int("max-batch-size"),
+						MaxJSONDepth: context.Int("max-json-depth"),
+					}
+					config := server.Config{
+						ProverAddress:          context.String("prover-address"),
+						MetricsAddress:         context.String("metrics-address"),
+						Hardware:               &selection,
+						LegacyJSON:             context.Bool("legacy-json"),
+						MaxConcurrentProofs:    context.Int("max-concurrent-proofs"),
+						AutoscaleTargetLatency: context.Duration("autoscale-target-latency"),
+						PriorityAging:          context.Duration("priority-aging"),
+						SelfVerify:             context.Bool("self-verify"),
+						ResultSigner:           resultSigner,
+						ClientKeys:             clientKeys,
+						RequireSignatures:      context.Bool("require-signatures"),
+						ProveTimeout:           context.Duration("prove-timeout"),
+						ReadTimeout:            context.Duration("read-timeout"),
+						WriteTimeout:           context.Duration("write-timeout"),
+						IdleTimeout:            context.Duration("idle-timeout"),
+						SlowRequestThreshold:   context.Duration("slow-request-threshold"),
+						RateLimits:             rateLimits,
+						RequestLimits:          requestLimits,
+						DrainGracePeriod:       context.Duration("drain-grace-period"),
+						ProofEncoding:          proofEncoding,
+						NumberFormat:           numberFormat(context),
+						BatchWorkers:           context.Int("batch-workers"),
+						Aggregation:            aggregation,
+						KeysError:              keysErr,
+						VerifyingSystem:        vs,
+						LoadKeys:               loadKeys,
+						Pprof:                  context.Bool("pprof"),
+						MetricsExporter:        metricsExporter,
+						MetricsPushInterval:    context.Duration("metrics-push-interval"),
+						ProofCache:             proofCache,
+						Ledger:                 ledger,
+						ProofRetries:           proofRetries,
+						Jobs:                   jobs,
+						Callbacks:              callbacks,
+						JobRetention:           context.Duration("job-retention"),
+						KeyFiles:               keyFiles,
+						LoadKeyFile:            readKeys,
+						LazyKeys:               context.Bool("lazy-keys"),
+						MemoryBudget:           memoryBudget,
+						Tenants:                tenants,
+						TenantsFile:            context.String("tenants-file"),
+						Secrets:                resolver,
+						Recorder:               recorder,
+						StartIndexAlignment:    uint32(context.Uint("start-index-alignment")),
+						Dev:                    dev,
+						CORS:                   cors(context),
+						Mode:                   mode,
+						AdminAddress:           context.String("admin-address"),
+						AdminToken:             adminToken,
+						TLSCertificate:         tlsCertificate,
+					}
+					instance := server.Run(&config, ps)
+					stop := make(chan os.Signal, 1)
+					signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+					sig := <-stop
+					logging.Logger().Info().Stringer("signal", sig).Msg("Received signal, draining and shutting down")
+					instance.RequestStop()
+					logging.Logger().Info().Msg("Waiting for server to close")
+					instance.AwaitStop()
+					return nil
+				},
+			},
+			{
+				Name: "worker",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "keys-file", Usage: "proving system file, or an s3://bucket/key or gs://bucket/object URL", Required: true},
+					&cli.StringFlag{Name: "keys-sha256", Usage: "SHA-256 checksum the keys file must match", Required: false},
+					&cli.StringFlag{Name: "keys-cache-dir", Usage: "directory remote keys files are downloaded to", Value: os.TempDir(), Required: false},
+					&cli.BoolFlag{Name: "keys-anonymous", Usage: "read an s3:// or gs:// keys file without credentials, for public buckets", Required: false},
+					&cli.StringFlag{Name: "queue", Usage: "queue to take jobs from, redis://host/<jobs list>?results=<list>, nats://host/<subject>?group=<group>&results=<subject> or sqs://<host>/<account>/<queue>?results=<queue>", Required: true},
+					&cli.StringFlag{Name: "proof-encoding", Usage: "encoding of proofs in results: default or compressed", Value: "default", Required: false},
+					&cli.BoolFlag{Name: "decimal-json", Usage: "write proof coordinates as decimal strings instead of 32-byte hex", Required: false},
+					&cli.IntFlag{Name: "threads", Usage: "number of threads used for proving, all CPUs if not provided", Required: false},
+					&cli.IntFlag{Name: "witness-workers", Usage: "number of goroutines building each witness, the proving threads if not provided", Required: false},
+					&cli.StringFlag{Name: "cpu-affinity", Usage: "CPU list the process is pinned to, such as 0-15,32-47 (Linux only)", Required: false},
+					&cli.IntFlag{Name: "numa-node", Usage: "NUMA node whose CPUs the process is pinned to, -1 not to pin it (Linux only)", Value: -1, Required: false},
+					&cli.IntFlag{Name: "workers", Usage: "number of jobs proven concurrently, each by a worker with its own queue connection", Value: 1, Required: false},
+					&cli.BoolFlag{Name: "cpu-partitions", Usage: "pin the proofs of every worker to its own CPUs, a NUMA node each if there are as many workers as nodes (Linux only)", Required: false},
+					&cli.BoolFlag{Name: "json-logging", Usage: "enable JSON logging", Required: false},
+					&cli.StringFlag{Name: "log-level", Usage: "minimum level of log entries: trace, debug, info, warn or error", Value: "info", Required: false},
+					&cli.StringFlag{Name: "log-output", Usage: "stderr, stdout or the path of a log file, stdout for JSON logging and stderr otherwise if not provided", Required: false},
+					&cli.Int64Flag{Name: "log-max-size", Usage: "size in megabytes a log file is rotated at, 0 to never rotate it", Value: 100, Required: false},
+					&cli.IntFlag{Name: "log-max-backups", Usage: "number of rotated log files kept", Value: 5, Required: false},
+				},
+				Action: func(context *cli.Context) error {
+					if err := configureLogging(context); err != nil {
+						return err
+					}
+					profile := hardware.Detect()
+					cpus, err := cpuAffinity(context, &profile)
 					if err != nil {
 						return err
 					}
-					file, err := os.Create(path)
-					defer file.Close()
+					if threads := context.Int("threads"); threads > 0 {
+						runtime.GOMAXPROCS(threads)
+					} else if len(cpus) > 0 {
+						runtime.GOMAXPROCS(len(cpus))
+					}
+					encoding, err := prover.ParseProofEncoding(context.String("proof-encoding"))
 					if err != nil {
 						return err
 					}
-					written, err := system.WriteTo(file)
+					keysPath, err := keystore.Fetch(context.Context, context.String("keys-file"), keystore.Options{
+						CacheDir:  context.String("keys-cache-dir"),
+						SHA256:    context.String("keys-sha256"),
+						Retries:   5,
+						Anonymous: context.Bool("keys-anonymous"),
+					})
 					if err != nil {
 						return err
 					}
-					logging.Logger().Info().Int64("bytesWritten", written).Msg("proving system written to file")
-					return nil
+					ps, err := prover.ReadSystemFromFile(keysPath)
+					if err != nil {
+						return err
+					}
+					ps.WitnessWorkers = context.Int("witness-workers")
+					logging.Logger().Info().Stringer("curve", ps.Curve).Uint32("treeDepth", ps.TreeDepth).Uint32("batchSize", ps.BatchSize).Msg("Read proving system")
+					partitions, err := workerPartitions(context, &profile, cpus)
+					if err != nil {
+						return err
+					}
+					ctx, stop := signal.NotifyContext(context.Context, os.Interrupt, syscall.SIGTERM)
+					defer stop()
+					done := make(chan error, len(partitions))
+					for _, partition := range partitions {
+						queue, err := worker.Open(context.String("queue"))
+						if err != nil {
+							return err
+						}
+						defer queue.Close()
+						w := &worker.Worker{ProvingSystem: ps, Queue: queue, Encoding: encoding, Numbers: numberFormat(context), CPUs: partition}
+						go func() { done <- w.Run(ctx) }()
+					}
+					logging.Logger().Info().Int("workers", len(partitions)).Msg("Waiting for jobs")
+					// The first worker to fail stops the others, as stop cancels
+					// ctx.
+					var runErr error
+					for range partitions {
+						if err := <-done; err != nil && runErr == nil {
+							runErr = err
+							stop()
+						}
+					}
+					return runErr
 				},
 			},
 			{
-				Name: "r1cs",
+				Name: "watch",
 				Flags: []cli.Flag{
-					&cli.StringFlag{Name: "output", Usage: "Output file", Required: true},
-					&cli.UintFlag{Name: "tree-depth", Usage: "Merkle tree depth", Required: true},
-					&cli.UintFlag{Name: "batch-size", Usage: "Batch size", Required: true},
+					&cli.StringFlag{Name: "keys-file", Usage: "proving system file", Required: false},
+					&cli.StringFlag{Name: "vk-file", Usage: "verifying key file (generated from export-vk), instead of keys-file", Required: false},
+					&cli.StringFlag{Name: "rpc-url", Usage: "JSON-RPC endpoint of an Ethereum node", Required: true},
+					&cli.StringFlag{Name: "contract", Usage: "address of the identity manager whose registerIdentities transactions are verified", Required: true},
+					&cli.Uint64Flag{Name: "from-block", Usage: "first block verified, the last confirmed block if not provided", Required: false},
+					&cli.Uint64Flag{Name: "confirmations", Usage: "number of blocks behind the head of the chain blocks are verified at", Value: 12, Required: false},
+					&cli.DurationFlag{Name: "poll-interval", Usage: "time between polls of the head of the chain", Value: 12 * time.Second, Required: false},
+					&cli.StringFlag{Name: "webhook", Usage: "URL every proof that does not verify is posted to as JSON", Required: false},
+					&cli.StringFlag{Name: "metrics-address", Usage: "address for the metrics server", Value: "localhost:9998", Required: false},
+					&cli.BoolFlag{Name: "json-logging", Usage: "enable JSON logging", Required: false},
+					&cli.StringFlag{Name: "log-level", Usage: "minimum level of log entries: trace, debug, info, warn or error", Value: "info", Required: false},
+					&cli.StringFlag{Name: "log-output", Usage: "stderr, stdout or the path of a log file, stdout for JSON logging and stderr otherwise if not provided", Required: false},
+					&cli.Int64Flag{Name: "log-max-size", Usage: "size in megabytes a log file is rotated at, 0 to never rotate it", Value: 100, Required: false},
+					&cli.IntFlag{Name: "log-max-backups", Usage: "number of rotated log files kept", Value: 5, Required: false},
 				},
 				Action: func(context *cli.Context) error {
-					path := context.String("output")
-					treeDepth := uint32(context.Uint("tree-depth"))
-					batchSize := uint32(context.Uint("batch-size"))
-					logging.Logger().Info().Msg("Building R1CS")
-					cs, err := prover.BuildR1CS(treeDepth, batchSize)
+					if err := configureLogging(context); err != nil {
+						return err
+					}
+					vs, err := readVerifyingSystem(context)
 					if err != nil {
 						return err
 					}
-					file, err := os.Create(path)
-					defer file.Close()
+					logging.Logger().Info().Stringer("curve", vs.Curve).Uint32("treeDepth", vs.TreeDepth).Uint32("batchSize", vs.BatchSize).Msg("Read verifying system")
+					watcher, err := watch.New(watch.Config{
+						RPC:            context.String("rpc-url"),
+						Contract:       context.String("contract"),
+						FromBlock:      context.Uint64("from-block"),
+						Confirmations:  context.Uint64("confirmations"),
+						PollInterval:   context.Duration("poll-interval"),
+						Webhook:        context.String("webhook"),
+						MetricsAddress: context.String("metrics-address"),
+					}, vs)
 					if err != nil {
 						return err
 					}
-					written, err := cs.WriteTo(file)
+					ctx, stop := signal.NotifyContext(context.Context, os.Interrupt, syscall.SIGTERM)
+					defer stop()
+					return watcher.Run(ctx)
+				},
+			},
+			{
+				Name: "coordinator",
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{Name: "backends", Usage: "base URLs of the prover instances requests are forwarded to, such as http://prover-0:3001", Required: true},
+					&cli.StringFlag{Name: "prover-address", Usage: "address for the forwarded endpoints", Value: "localhost:3001", Required: false},
+					&cli.StringFlag{Name: "metrics-address", Usage: "address for the metrics server", Value: "localhost:9998", Required: false},
+					&cli.DurationFlag{Name: "health-check-interval", Usage: "time between health checks of every backend", Value: 5 * time.Second, Required: false},
+					&cli.IntFlag{Name: "max-attempts", Usage: "number of backends a request is sent to before failing, every healthy backend if not provided", Required: false},
+					&cli.BoolFlag{Name: "json-logging", Usage: "enable JSON logging", Required: false},
+					&cli.StringFlag{Name: "log-level", Usage: "minimum level of log entries: trace, debug, info, warn or error", Value: "info", Required: false},
+					&cli.StringFlag{Name: "log-output", Usage: "stderr, stdout or the path of a log file, stdout for JSON logging and stderr otherwise if not provided", Required: false},
+					&cli.Int64Flag{Name: "log-max-size", Usage: "size in megabytes a log file is rotated at, 0 to never rotate it", Value: 100, Required: false},
+					&cli.IntFlag{Name: "log-max-backups", Usage: "number of rotated log files kept", Value: 5, Required: false},
+				},
+				Action: func(context *cli.Context) error {
+					if err := configureLogging(context); err != nil {
+						return err
+					}
+					c, err := coordinator.New(coordinator.Config{
+						Backends:       context.StringSlice("backends"),
+						Address:        context.String("prover-address"),
+						MetricsAddress: context.String("metrics-address"),
+						HealthInterval: context.Duration("health-check-interval"),
+						MaxAttempts:    context.Int("max-attempts"),
+					})
 					if err != nil {
 						return err
 					}
-					logging.Logger().Info().Int64("bytesWritten", written).Msg("R1CS written to file")
-					return nil
+					ctx, stop := signal.NotifyContext(context.Context, os.Interrupt, syscall.SIGTERM)
+					defer stop()
+					return c.Run(ctx)
 				},
 			},
 			{
-				Name: "export-solidity",
+				Name: "replay",
 				Flags: []cli.Flag{
-					&cli.StringFlag{Name: "keys-file", Usage: "proving system file", Required: true},
-					&cli.StringFlag{Name: "output", Usage: "solidity output (will write to stdout if not provided)", Required: false},
+					&cli.StringFlag{Name: "input", Usage: "NDJSON file of requests recorded with record-file", Required: true},
+					&cli.StringFlag{Name: "target", Usage: "base URL of the server the requests are sent to, such as http://localhost:3001", Required: true},
+					&cli.IntFlag{Name: "concurrency", Usage: "number of requests in flight at once", Value: 1, Required: false},
+					&cli.Float64Flag{Name: "qps", Usage: "maximum number of requests sent per second, 0 for no limit", Value: 0, Required: false},
+					&cli.IntFlag{Name: "count", Usage: "number of requests sent, cycling through the recorded ones, each of them once if not provided", Required: false},
+					&cli.StringSliceFlag{Name: "header", Usage: "header added to every request, as Name: value, such as the X-API-Key of a tenant", Required: false},
+					&cli.DurationFlag{Name: "timeout", Usage: "time a request may take before it fails, 0 for no timeout", Value: 0, Required: false},
 				},
 				Action: func(context *cli.Context) error {
-					keys := context.String("keys-file")
-					ps, err := prover.ReadSystemFromFile(keys)
+					file, err := os.Open(context.String("input"))
 					if err != nil {
 						return err
 					}
-					var output io.Writer
-					if outPath := context.String("output"); outPath != "" {
-						file, err := os.Create(outPath)
-						defer file.Close()
-						if err != nil {
-							return err
+					defer file.Close()
+					requests, err := replay.ReadRequests(file)
+					if err != nil {
+						return err
+					}
+					header := make(http.Header)
+					for _, line := range context.StringSlice("header") {
+						name, value, ok := strings.Cut(line, ":")
+						if !ok {
+							return fmt.Errorf("invalid header %q, expected Name: value", line)
 						}
-						output = file
-					} else {
-						output = os.Stdout
+						header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
 					}
-					return ps.ExportSolidity(output)
+					ctx, stop := signal.NotifyContext(context.Context, os.Interrupt, syscall.SIGTERM)
+					defer stop()
+					logging.Logger().Info().Int("recorded", len(requests)).Str("target", context.String("target")).Msg("replaying requests")
+					report, err := replay.Run(ctx, requests, replay.Options{
+						Target:      context.String("target"),
+						Concurrency: context.Int("concurrency"),
+						QPS:         context.Float64("qps"),
+						Count:       context.Int("count"),
+						Header:      header,
+						Client:      &http.Client{Timeout: context.Duration("timeout")},
+					})
+					if report == nil {
+						return err
+					}
+					statuses := zerolog.Dict()
+					for status, count := range report.Statuses {
+						statuses.Int(strconv.Itoa(status), count)
+					}
+					logging.Logger().Info().
+						Int("requests", report.Requests).
+						Int("failures", report.Failures).
+						Dict("statuses", statuses).
+						Dur("elapsed", report.Elapsed).
+						Float64("throughput", report.Throughput).
+						Dur("mean", report.Latencies.Mean).
+						Dur("p50", report.Latencies.P50).
+						Dur("p90", report.Latencies.P90).
+						Dur("p95", report.Latencies.P95).
+						Dur("p99", report.Latencies.P99).
+						Dur("max", report.Latencies.Max).
+						Msg("replay finished")
+					return err
 				},
 			},
 			{
-				Name: "gen-test-params",
+				Name: "fuzz-input-hash",
 				Flags: []cli.Flag{
-					&cli.UintFlag{Name: "tree-depth", Usage: "depth of the mock tree", Required: true},
 					&cli.UintFlag{Name: "batch-size", Usage: "batch size", Required: true},
+					&cli.StringFlag{Name: "commitment", Usage: "hash computing the input hash: keccak, keccak-chained, poseidon or sha256", Value: "keccak", Required: false},
+					&cli.StringFlag{Name: "empty-leaf", Usage: "value of empty tree slots", Value: "0", Required: false},
+					&cli.BoolFlag{Name: "indexed", Usage: "hash the index of every identity commitment", Required: false},
+					&cli.IntFlag{Name: "iterations", Usage: "number of random parameter sets checked", Value: 1000, Required: false},
+					&cli.Int64Flag{Name: "seed", Usage: "seed of the random parameters, the current time if not provided", Required: false},
+					&cli.BoolFlag{Name: "lookup-keccak", Usage: "hash keccak and keccak-chained inputs with the lookup-based Keccak gadget", Required: false},
 				},
 				Action: func(context *cli.Context) error {
-					treeDepth := context.Int("tree-depth")
-					batchSize := uint32(context.Uint("batch-size"))
-					logging.Logger().Info().Msg("Generating test params")
-
-					params := prover.Parameters{}
-					tree := NewTree(treeDepth)
-
-					params.StartIndex = 0
-					params.PreRoot = tree.Root()
-					params.IdComms = make([]big.Int, batchSize)
-					params.MerkleProofs = make([][]big.Int, batchSize)
-					for i := 0; i < int(batchSize); i++ {
-						params.IdComms[i] = *new(big.Int).SetUint64(uint64(i + 1))
-						params.MerkleProofs[i] = tree.Update(i, params.IdComms[i])
+					var emptyLeaf big.Int
+					if _, ok := emptyLeaf.SetString(context.String("empty-leaf"), 0); !ok {
+						return fmt.Errorf("invalid number: %s", context.String("empty-leaf"))
 					}
-					params.PostRoot = tree.Root()
-					params.ComputeInputHash()
-					r, _ := json.Marshal(&params)
-					fmt.Println(string(r))
+					commitment, err := prover.ParseCommitment(context.String("commitment"))
+					if err != nil {
+						return err
+					}
+					opts := []prover.CircuitOption{prover.WithCommitment(commitment), prover.WithEmptyLeaf(emptyLeaf)}
+					if context.Bool("indexed") {
+						opts = append(opts, prover.WithIndices())
+					}
+					if context.Bool("lookup-keccak") {
+						opts = append(opts, prover.WithLookupKeccak())
+					}
+					seed := context.Int64("seed")
+					if !context.IsSet("seed") {
+						seed = time.Now().UnixNano()
+					}
+					logging.Logger().Info().Msg("compiling the input hash circuit")
+					fuzzer, err := prover.NewInputHashFuzzer(int(context.Uint("batch-size")), opts...)
+					if err != nil {
+						return err
+					}
+					iterations := context.Int("iterations")
+					logging.Logger().Info().Int64("seed", seed).Int("iterations", iterations).Msg("fuzzing the input hash")
+					if err = fuzzer.Run(seed, iterations); err != nil {
+						var divergence *prover.InputHashDivergenceError
+						if errors.As(err, &divergence) {
+							r, _ := json.Marshal(divergence.Params)
+							fmt.Println(string(r))
+						}
+						return err
+					}
+					logging.Logger().Info().Msg("the native and circuit input hashes agree")
 					return nil
 				},
 			},
 			{
-				Name: "start",
+				Name: "export-test-vectors",
 				Flags: []cli.Flag{
-					&cli.StringFlag{Name: "keys-file", Usage: "proving system file", Required: true},
-					&cli.BoolFlag{Name: "json-logging", Usage: "enable JSON logging", Required: false},
-					&cli.StringFlag{Name: "prover-address", Usage: "address for the prover server", Value: "localhost:3001", Required: false},
-					&cli.StringFlag{Name: "metrics-address", Usage: "address for the metrics server", Value: "localhost:9998", Required: false},
+					&cli.StringFlag{Name: "keys-file", Usage: "proving system file of the circuit the vectors are for", Required: true},
+					&cli.StringSliceFlag{Name: "params", Usage: "files of parameters to freeze, each a vector named after its file, instead of generated ones", Required: false},
+					&cli.StringFlag{Name: "output", Usage: "JSON output (will write to stdout if not provided)", Required: false},
 				},
 				Action: func(context *cli.Context) error {
-					if context.Bool("json-logging") {
-						logging.SetJSONOutput()
+					ps, err := prover.ReadSystemFromFile(context.String("keys-file"))
+					if err != nil {
+						return err
 					}
-					keys := context.String("keys-file")
-					logging.Logger().Info().Msg("Reading proving system from file")
-					ps, err := prover.ReadSystemFromFile(keys)
+					named, err := testVectorParams(context.StringSlice("params"), ps)
 					if err != nil {
 						return err
 					}
-					logging.Logger().Info().Uint32("treeDepth", ps.TreeDepth).Uint32("batchSize", ps.BatchSize).Msg("Read proving system")
-					config := server.Config{
-						ProverAddress:  context.String("prover-address"),
-						MetricsAddress: context.String("metrics-address"),
+					vectors := prover.TestVectors{Version: prover.TestVectorsVersion, CircuitVersion: prover.CircuitSemver}
+					for _, params := range named {
+						vector, err := ps.NewTestVector(params.name, params.params)
+						if err != nil {
+							return err
+						}
+						vectors.Vectors = append(vectors.Vectors, *vector)
 					}
-					instance := server.Run(&config, ps)
-					sigint := make(chan os.Signal, 1)
-					signal.Notify(sigint, os.Interrupt)
-					<-sigint
-					logging.Logger().Info().Msg("Received sigint, shutting down")
-					instance.RequestStop()
-					logging.Logger().Info().Msg("Waiting for server to close")
-					instance.AwaitStop()
+					output := os.Stdout
+					if path := context.String("output"); path != "" {
+						if output, err = os.Create(path); err != nil {
+							return err
+						}
+						defer output.Close()
+					}
+					if _, err = vectors.WriteTo(output); err != nil {
+						return err
+					}
+					logging.Logger().Info().Int("vectors", len(vectors.Vectors)).Msg("test vectors exported")
+					return nil
+				},
+			},
+			{
+				Name: "replay-test-vectors",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "keys-file", Usage: "proving system file to replay the vectors of its circuit with", Required: true},
+					&cli.StringFlag{Name: "input", Usage: "test vectors file", Required: true},
+					&cli.BoolFlag{Name: "prove", Usage: "also prove every vector and verify the proof against its public inputs", Required: false},
+				},
+				Action: func(context *cli.Context) error {
+					ps, err := prover.ReadSystemFromFile(context.String("keys-file"))
+					if err != nil {
+						return err
+					}
+					file, err := os.Open(context.String("input"))
+					if err != nil {
+						return err
+					}
+					defer file.Close()
+					vectors, err := prover.ReadTestVectors(file)
+					if err != nil {
+						return err
+					}
+					circuit := ps.TestVectorCircuit()
+					replayed, failed := 0, 0
+					for i := range vectors.Vectors {
+						vector := &vectors.Vectors[i]
+						if vector.Circuit != circuit {
+							logging.Logger().Info().Str("vector", vector.Name).Msg("skipping test vector of another circuit")
+							continue
+						}
+						replayed++
+						if err = ps.ReplayTestVector(context.Context, vector, context.Bool("prove")); err != nil {
+							failed++
+							logging.Logger().Error().Err(err).Str("vector", vector.Name).Msg("test vector failed")
+							continue
+						}
+						logging.Logger().Info().Str("vector", vector.Name).Msg("test vector passed")
+					}
+					if replayed == 0 {
+						return fmt.Errorf("no test vector is for the circuit of %s", context.String("keys-file"))
+					}
+					if failed > 0 {
+						return fmt.Errorf("%d of %d test vectors failed", failed, replayed)
+					}
+					logging.Logger().Info().Int("vectors", replayed).Msg("test vectors replayed")
 					return nil
 				},
 			},
@@ -170,14 +1290,24 @@ func main() {
 				Name: "prove",
 				Flags: []cli.Flag{
 					&cli.StringFlag{Name: "keys-file", Usage: "proving system file", Required: true},
+					&cli.StringFlag{Name: "encoding", Usage: "encoding of the proof: default or compressed", Value: "default", Required: false},
+					&cli.BoolFlag{Name: "decimal-json", Usage: "write proof coordinates as decimal strings instead of 32-byte hex", Required: false},
+					&cli.BoolFlag{Name: "witness-dump", Usage: "write the witness of every failed proof to a file and log its path, for debugging", Required: false},
+					&cli.StringFlag{Name: "witness-dump-dir", Usage: "directory of the witness dumps, the temporary directory if not provided", Required: false},
+					&cli.BoolFlag{Name: "witness-dump-redact", Usage: "leave the identity commitments and the private witness out of the witness dumps", Required: false},
 				},
 				Action: func(context *cli.Context) error {
+					encoding, err := prover.ParseProofEncoding(context.String("encoding"))
+					if err != nil {
+						return err
+					}
 					keys := context.String("keys-file")
 					ps, err := prover.ReadSystemFromFile(keys)
 					if err != nil {
 						return err
 					}
-					logging.Logger().Info().Uint32("treeDepth", ps.TreeDepth).Uint32("batchSize", ps.BatchSize).Msg("Read proving system")
+					ps.WitnessDump = witnessDump(context)
+					logging.Logger().Info().Stringer("curve", ps.Curve).Uint32("treeDepth", ps.TreeDepth).Uint32("batchSize", ps.BatchSize).Msg("Read proving system")
 					logging.Logger().Info().Msg("reading params from stdin")
 					bytes, err := io.ReadAll(os.Stdin)
 					if err != nil {
@@ -189,11 +1319,13 @@ func main() {
 						return err
 					}
 					logging.Logger().Info().Msg("params read successfully")
-					proof, err := ps.Prove(&params)
+					ctx, stop := signal.NotifyContext(context.Context, os.Interrupt, syscall.SIGTERM)
+					defer stop()
+					proof, err := ps.Prove(ctx, &params)
 					if err != nil {
 						return err
 					}
-					r, _ := json.Marshal(&proof)
+					r, _ := json.Marshal(proof.Encoded(encoding, numberFormat(context)))
 					fmt.Println(string(r))
 					return nil
 				},
@@ -201,21 +1333,22 @@ func main() {
 			{
 				Name: "verify",
 				Flags: []cli.Flag{
-					&cli.StringFlag{Name: "keys-file", Usage: "proving system file", Required: true},
+					&cli.StringFlag{Name: "keys-file", Usage: "proving system file", Required: false},
+					&cli.StringFlag{Name: "vk-file", Usage: "verifying key file (generated from export-vk), instead of keys-file", Required: false},
 					&cli.StringFlag{Name: "input-hash", Usage: "the hash of all public inputs", Required: true},
+					&cli.StringFlag{Name: "post-root", Usage: "the post root, for circuits exposing it as a public input", Required: false},
 				},
 				Action: func(context *cli.Context) error {
-					keys := context.String("keys-file")
 					var inputHash big.Int
 					_, ok := inputHash.SetString(context.String("input-hash"), 0)
 					if !ok {
 						return fmt.Errorf("invalid number: %s", context.String("input-hash"))
 					}
-					ps, err := prover.ReadSystemFromFile(keys)
+					ps, err := readVerifyingSystem(context)
 					if err != nil {
 						return err
 					}
-					logging.Logger().Info().Uint32("treeDepth", ps.TreeDepth).Uint32("batchSize", ps.BatchSize).Msg("Read proving system")
+					logging.Logger().Info().Stringer("curve", ps.Curve).Uint32("treeDepth", ps.TreeDepth).Uint32("batchSize", ps.BatchSize).Msg("Read verifying system")
 					logging.Logger().Info().Msg("reading proof from stdin")
 					bytes, err := io.ReadAll(os.Stdin)
 					if err != nil {
@@ -227,7 +1360,16 @@ func main() {
 						return err
 					}
 					logging.Logger().Info().Msg("proof read successfully")
-					err = ps.Verify(inputHash, &proof)
+					if ps.PublicPostRoot {
+						var postRoot big.Int
+						_, ok = postRoot.SetString(context.String("post-root"), 0)
+						if !ok {
+							return fmt.Errorf("invalid number: %s", context.String("post-root"))
+						}
+						err = ps.VerifyWithPostRoot(inputHash, postRoot, &proof)
+					} else {
+						err = ps.Verify(inputHash, &proof)
+					}
 					if err != nil {
 						return err
 					}
@@ -242,3 +1384,455 @@ func main() {
 		logging.Logger().Fatal().Err(err).Msg("App failed.")
 	}
 }
+
+// testParams returns the insertion of a batch of identity commitments 1 to
+// batchSize into a mock tree whose first prefilled leaves are set, from
+// index prefilled on, or at every other index from there if indexed, as
+// failed insertions leave gaps. The input hash is not computed.
+func testParams(treeDepth int, batchSize uint32, emptyLeaf big.Int, treeHash nodeHash, indexed bool, prefilled int) (*prover.Parameters, error) {
+	last := prefilled + int(batchSize) - 1
+	if indexed {
+		last = prefilled + 2*(int(batchSize)-1)
+	}
+	if last >= 1<<treeDepth {
+		return nil, fmt.Errorf("a batch of %d from index %d does not fit in a tree of depth %d", batchSize, prefilled, treeDepth)
+	}
+	tree := NewTreeWithHash(treeDepth, emptyLeaf, treeHash)
+	for i := 0; i < prefilled; i++ {
+		tree.Update(i, *new(big.Int).SetUint64(uint64(1000 + i)))
+	}
+
+	params := prover.Parameters{}
+	params.EmptyLeaf = emptyLeaf
+	params.StartIndex = uint32(prefilled)
+	params.PreRoot = tree.Root()
+	params.IdComms = make([]big.Int, batchSize)
+	params.MerkleProofs = make([][]big.Int, batchSize)
+	if indexed {
+		params.Indices = make([]uint32, batchSize)
+	}
+	for i := 0; i < int(batchSize); i++ {
+		index := prefilled + i
+		if indexed {
+			index = prefilled + 2*i
+			params.Indices[i] = uint32(index)
+		}
+		params.IdComms[i] = *new(big.Int).SetUint64(uint64(i + 1))
+		params.MerkleProofs[i] = tree.Update(index, params.IdComms[i])
+	}
+	params.PostRoot = tree.Root()
+	return &params, nil
+}
+
+// benchReport is the output of the bench command.
+type benchReport struct {
+	Stats *prover.Stats `json:"stats"`
+	// ProofSeconds are the durations of the proofs of the test batches.
+	ProofSeconds []float64 `json:"proofSeconds,omitempty"`
+}
+
+// benchProofs proves count insertions into an empty tree with ps, returning
+// the duration of every proof in seconds.
+func benchProofs(context *cli.Context, ps *prover.ProvingSystem, count int) ([]float64, error) {
+	treeHash, err := prover.NativeTreeHash(ps.TreeHash, ps.Curve)
+	if err != nil {
+		return nil, err
+	}
+	params, err := testParams(int(ps.TreeDepth), ps.BatchSize, ps.EmptyLeaf, treeHash, ps.Indexed, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err = params.ComputeInputHashWith(ps.Commitment); err != nil {
+		return nil, err
+	}
+	ctx, stop := signal.NotifyContext(context.Context, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	seconds := make([]float64, count)
+	for i := range seconds {
+		start := time.Now()
+		if _, err = ps.Prove(ctx, params); err != nil {
+			return nil, err
+		}
+		seconds[i] = time.Since(start).Seconds()
+		logging.Logger().Info().Int("proof", i+1).Float64("seconds", seconds[i]).Msg("proof generated")
+	}
+	return seconds, nil
+}
+
+type namedParams struct {
+	name   string
+	params *prover.Parameters
+}
+
+// testVectorParams reads the parameters of the given files, or generates
+// insertions into an empty and a partly filled tree for the circuit of ps
+// if there are none.
+func testVectorParams(paths []string, ps *prover.ProvingSystem) ([]namedParams, error) {
+	var named []namedParams
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var params prover.Parameters
+		if err = json.Unmarshal(data, &params); err != nil {
+			return nil, fmt.Errorf("invalid parameters %s: %w", path, err)
+		}
+		named = append(named, namedParams{strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)), &params})
+	}
+	if len(paths) > 0 {
+		return named, nil
+	}
+	treeHash, err := prover.NativeTreeHash(ps.TreeHash, ps.Curve)
+	if err != nil {
+		return nil, err
+	}
+	for _, prefilled := range []int{0, int(ps.BatchSize)} {
+		params, err := testParams(int(ps.TreeDepth), ps.BatchSize, ps.EmptyLeaf, treeHash, ps.Indexed, prefilled)
+		if err != nil {
+			// The tree is too small for a partly filled one.
+			if prefilled > 0 {
+				continue
+			}
+			return nil, err
+		}
+		named = append(named, namedParams{fmt.Sprintf("insertion-%d-%d-from-%d", ps.TreeDepth, ps.BatchSize, prefilled), params})
+	}
+	return named, nil
+}
+
+// largestKeyFile returns the key file of the largest batch size, then tree
+// depth, which serves the requests matching no file.
+func largestKeyFile(files []server.KeyFile) server.KeyFile {
+	largest := files[0]
+	for _, file := range files[1:] {
+		circuit, current := file.Circuit, largest.Circuit
+		if circuit.BatchSize > current.BatchSize || circuit.BatchSize == current.BatchSize && circuit.TreeDepth > current.TreeDepth {
+			largest = file
+		}
+	}
+	return largest
+}
+
+// readVerifyingSystem reads the verifying key file given with vk-file, or
+// the verifying part of the proving system given with keys-file.
+func readVerifyingSystem(context *cli.Context) (*prover.VerifyingSystem, error) {
+	if path := context.String("vk-file"); path != "" {
+		return prover.ReadVerifyingSystemFromFile(path)
+	}
+	path := context.String("keys-file")
+	if path == "" {
+		return nil, fmt.Errorf("either keys-file or vk-file is required")
+	}
+	ps, err := prover.ReadSystemFromFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ps.VerifyingSystem(), nil
+}
+
+// configureLogging sets up logging as selected with the json-logging and log
+// flags.
+func configureLogging(context *cli.Context) error {
+	level, err := zerolog.ParseLevel(context.String("log-level"))
+	if err != nil {
+		return err
+	}
+	err = logging.Configure(logging.Options{
+		Output:     context.String("log-output"),
+		JSON:       context.Bool("json-logging"),
+		MaxSize:    context.Int64("log-max-size") << 20,
+		MaxBackups: context.Int("log-max-backups"),
+	})
+	if err != nil {
+		return err
+	}
+	logging.SetLevel(level)
+	return nil
+}
+
+// witnessDump returns the witness dumps configured with the witness-dump
+// flags, nil if disabled.
+func witnessDump(context *cli.Context) *prover.WitnessDump {
+	if !context.Bool("witness-dump") {
+		return nil
+	}
+	return &prover.WitnessDump{Dir: context.String("witness-dump-dir"), Redact: context.Bool("witness-dump-redact")}
+}
+
+// cpuAffinity pins the process to the CPUs of the cpu-affinity or numa-node
+// flags, of the NUMA nodes of profile, and returns them, or nil if neither is
+// given.
+func cpuAffinity(context *cli.Context, profile *hardware.Profile) ([]int, error) {
+	list, node := context.String("cpu-affinity"), context.Int("numa-node")
+	var (
+		cpus []int
+		err  error
+	)
+	switch {
+	case list != "" && node >= 0:
+		return nil, fmt.Errorf("cpu-affinity and numa-node cannot both be given")
+	case list != "":
+		cpus, err = hardware.ParseCPUList(list)
+	case node >= 0:
+		cpus, err = profile.NodeCPUs(node)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = hardware.PinCPUs(cpus); err != nil {
+		return nil, err
+	}
+	logging.Logger().Info().Str("cpus", hardware.FormatCPUList(cpus)).Int("numaNode", node).Msg("Pinned process to CPUs")
+	return cpus, nil
+}
+
+// workerPartitions returns the CPUs of every worker selected with the
+// workers and cpu-partitions flags, split from cpus if the process is pinned
+// to them and from its allowed CPUs otherwise, nil for workers proving on
+// any CPU.
+func workerPartitions(context *cli.Context, profile *hardware.Profile, cpus []int) ([][]int, error) {
+	workers := context.Int("workers")
+	if workers <= 0 {
+		return nil, fmt.Errorf("workers must be positive")
+	}
+	if !context.Bool("cpu-partitions") {
+		return make([][]int, workers), nil
+	}
+	if cpus == nil {
+		cpus = profile.CPUs
+	}
+	if cpus == nil {
+		return nil, fmt.Errorf("the CPUs of the process are unknown, so they cannot be partitioned")
+	}
+	partitions, err := profile.PartitionCPUs(cpus, workers)
+	if err != nil {
+		return nil, err
+	}
+	for i, partition := range partitions {
+		logging.Logger().Info().Int("worker", i).Str("cpus", hardware.FormatCPUList(partition)).Msg("Partitioned CPUs")
+	}
+	return partitions, nil
+}
+
+// numberFormat returns the format of field elements selected with the
+// decimal-json flag.
+func numberFormat(context *cli.Context) prover.NumberFormat {
+	if context.Bool("decimal-json") {
+		return prover.NumberFormatDecimal
+	}
+	return prover.NumberFormatHex
+}
+
+// proofCache returns the proof cache configured with the proof-cache flags,
+// or nil if it is disabled.
+func proofCache(context *cli.Context) (server.ProofCache, error) {
+	ttl := context.Duration("proof-cache-ttl")
+	if location := context.String("proof-cache-redis"); location != "" {
+		return server.OpenRedisProofCache(location, ttl)
+	}
+	if size := context.Int("proof-cache-size"); size > 0 {
+		return server.NewMemoryProofCache(size, ttl), nil
+	}
+	return nil, nil
+}
+
+// ledger returns the Ledger of the ledger flag, nil if it is unset.
+func ledger(context *cli.Context) (server.Ledger, error) {
+	switch location := context.String("ledger"); {
+	case location == "":
+		return nil, nil
+	case location == "memory":
+		return server.NewMemoryLedger(), nil
+	default:
+		return server.OpenRedisLedger(location)
+	}
+}
+
+// proofRetries returns the retries configured with the proof-retry flags, or
+// nil if proofs are tried once.
+func proofRetries(context *cli.Context) (*server.ProofRetries, error) {
+	retries := context.Int("proof-retries")
+	if retries < 0 {
+		return nil, fmt.Errorf("proof-retries must not be negative")
+	}
+	if retries == 0 {
+		return nil, nil
+	}
+	return &server.ProofRetries{
+		Retries:    retries,
+		Backoff:    context.Duration("proof-retry-backoff"),
+		MaxBackoff: context.Duration("proof-retry-max-backoff"),
+	}, nil
+}
+
+// callbacks returns the callback delivery configured with the callback flags,
+// or nil if callbacks are disabled.
+func callbacks(context *cli.Context, resolver *secrets.Resolver) (*server.Callbacks, error) {
+	path := context.String("callback-secret-file")
+	if path == "" {
+		return nil, nil
+	}
+	secret, err := resolver.Secret(context.Context, path)
+	if err != nil {
+		return nil, err
+	}
+	secret = bytes.TrimSpace(secret)
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("callback secret file %s is empty", path)
+	}
+	allowed := context.StringSlice("callback-allowed-urls")
+	if len(allowed) == 0 {
+		return nil, fmt.Errorf("callback-allowed-urls is required with callback-secret-file")
+	}
+	if context.Int("callback-attempts") < 1 {
+		return nil, fmt.Errorf("callback-attempts must be at least 1")
+	}
+	return &server.Callbacks{
+		Secret:      secret,
+		AllowedURLs: allowed,
+		Attempts:    context.Int("callback-attempts"),
+		Backoff:     context.Duration("callback-backoff"),
+		MaxBackoff:  context.Duration("callback-max-backoff"),
+		Timeout:     context.Duration("callback-timeout"),
+	}, nil
+}
+
+// adminToken returns the token of the admin API read from admin-token-file,
+// which is required with admin-address.
+func adminToken(context *cli.Context, resolver *secrets.Resolver) (string, error) {
+	path := context.String("admin-token-file")
+	if context.String("admin-address") == "" {
+		if path != "" {
+			return "", fmt.Errorf("admin-token-file is only used with admin-address")
+		}
+		return "", nil
+	}
+	if path == "" {
+		return "", fmt.Errorf("admin-address requires admin-token-file")
+	}
+	token, err := resolver.Secret(context.Context, path)
+	if err != nil {
+		return "", err
+	}
+	token = bytes.TrimSpace(token)
+	if len(token) == 0 {
+		return "", fmt.Errorf("admin token file %s is empty", path)
+	}
+	return string(token), nil
+}
+
+// tlsCertificate returns the certificate of tls-cert and tls-key, or nil if
+// the APIs are served over plain HTTP.
+func tlsCertificate(context *cli.Context, resolver *secrets.Resolver) (*tls.Certificate, error) {
+	certLocation, keyLocation := context.String("tls-cert"), context.String("tls-key")
+	if certLocation == "" && keyLocation == "" {
+		return nil, nil
+	}
+	if certLocation == "" || keyLocation == "" {
+		return nil, fmt.Errorf("tls-cert and tls-key must be given together")
+	}
+	return server.LoadTLSCertificate(context.Context, resolver, certLocation, keyLocation)
+}
+
+// cors returns the CORS configuration of the flags, nil if no origin is
+// allowed.
+func cors(context *cli.Context) *server.CORS {
+	origins := context.StringSlice("cors-allowed-origins")
+	if len(origins) == 0 {
+		return nil
+	}
+	return &server.CORS{
+		AllowedOrigins: origins,
+		AllowedMethods: context.StringSlice("cors-allowed-methods"),
+		MaxAge:         context.Duration("cors-max-age"),
+	}
+}
+
+// rateLimits returns the limits configured with the rate-limit flags, or nil
+// if requests are not limited.
+func rateLimits(context *cli.Context) (*server.RateLimits, error) {
+	limits := server.RateLimits{
+		IP:     server.RateLimit{Rate: context.Float64("rate-limit-ip"), Burst: context.Int("rate-limit-ip-burst")},
+		Client: server.RateLimit{Rate: context.Float64("rate-limit-client"), Burst: context.Int("rate-limit-client-burst")},
+	}
+	if path := context.String("rate-limits-file"); path != "" {
+		bytes, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(bytes, &limits.Clients); err != nil {
+			return nil, fmt.Errorf("invalid rate limits file %s: %w", path, err)
+		}
+	}
+	if limits.IP.Rate < 0 || limits.Client.Rate < 0 {
+		return nil, fmt.Errorf("rate limits must not be negative")
+	}
+	if limits.IP.Rate == 0 && limits.Client.Rate == 0 && len(limits.Clients) == 0 {
+		return nil, nil
+	}
+	return &limits, nil
+}
+
+// memoryBudget returns the memory budget of the flags, nil if unbounded,
+// logging the memory a proof of ps is assumed to take.
+func memoryBudget(context *cli.Context, ps *prover.ProvingSystem) (*server.MemoryBudget, error) {
+	megabytes, proofMegabytes := context.Int64("memory-budget"), context.Int64("proof-memory")
+	if megabytes < 0 || proofMegabytes < 0 {
+		return nil, fmt.Errorf("memory-budget and proof-memory must not be negative")
+	}
+	if megabytes == 0 {
+		return nil, nil
+	}
+	budget := server.MemoryBudget{Bytes: uint64(megabytes) << 20, ProofBytes: uint64(proofMegabytes) << 20, Queue: context.Bool("memory-budget-queue")}
+	proofBytes := budget.ProofBytes
+	if proofBytes == 0 && ps != nil {
+		proofBytes = ps.ProofMemory()
+	}
+	logging.Logger().Info().Uint64("budget", budget.Bytes).Uint64("proofMemory", proofBytes).Bool("queue", budget.Queue).Msg("bounding the memory of proofs")
+	if proofBytes > budget.Bytes {
+		logging.Logger().Warn().Msg("a proof takes more memory than the whole budget, every proof will be rejected")
+	}
+	return &budget, nil
+}
+
+func circuitOptions(context *cli.Context) ([]prover.CircuitOption, error) {
+	var opts []prover.CircuitOption
+	if context.Bool("public-post-root") {
+		opts = append(opts, prover.WithPublicPostRoot())
+	}
+	if context.Bool("indexed") {
+		opts = append(opts, prover.WithIndices())
+	}
+	if context.Bool("non-zero-id-comms") {
+		opts = append(opts, prover.WithNonZeroIdComms())
+	}
+	if context.Bool("lookup-keccak") {
+		opts = append(opts, prover.WithLookupKeccak())
+	}
+	var emptyLeaf big.Int
+	if _, ok := emptyLeaf.SetString(context.String("empty-leaf"), 0); !ok {
+		return nil, fmt.Errorf("invalid number: %s", context.String("empty-leaf"))
+	}
+	if emptyLeaf.Sign() != 0 {
+		opts = append(opts, prover.WithEmptyLeaf(emptyLeaf))
+	}
+	curve, err := prover.ParseCurve(context.String("curve"))
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, prover.WithCurve(curve))
+	commitment, err := prover.ParseCommitment(context.String("commitment"))
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, prover.WithCommitment(commitment))
+	treeHash, err := prover.ParseTreeHash(context.String("tree-hash"))
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, prover.WithTreeHash(treeHash))
+	return opts, nil
+}
@@ -0,0 +1,116 @@
+// Package merkletree is a sparse Merkle tree of fixed depth, the tree the
+// circuit inserts into, for clients building the parameters of batches.
+package merkletree
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// Hash hashes two nodes of a tree into their parent.
+type Hash func(left, right *big.Int) (*big.Int, error)
+
+// Tree is a Merkle tree of fixed depth whose leaves that were not set hold an
+// empty leaf. Only the nodes above set leaves are stored. It is not safe for
+// concurrent use.
+type Tree struct {
+	depth int
+	hash  Hash
+	// empty holds the roots of the empty subtrees of every height, from the
+	// empty leaf up to the root of the empty tree.
+	empty []big.Int
+	// nodes holds the nodes above set leaves by level, from the leaves up.
+	nodes []map[uint64]*big.Int
+}
+
+// New returns an empty tree of depth whose nodes are hashed with hash.
+func New(depth int, emptyLeaf *big.Int, hash Hash) (*Tree, error) {
+	if depth < 0 || depth > 64 {
+		return nil, fmt.Errorf("invalid tree depth: %d", depth)
+	}
+	t := &Tree{depth: depth, hash: hash, empty: make([]big.Int, depth+1), nodes: make([]map[uint64]*big.Int, depth+1)}
+	t.empty[0].Set(emptyLeaf)
+	for level := 1; level <= depth; level++ {
+		node, err := hash(&t.empty[level-1], &t.empty[level-1])
+		if err != nil {
+			return nil, err
+		}
+		t.empty[level].Set(node)
+	}
+	for level := range t.nodes {
+		t.nodes[level] = make(map[uint64]*big.Int)
+	}
+	return t, nil
+}
+
+// Depth returns the depth of the tree.
+func (t *Tree) Depth() int {
+	return t.depth
+}
+
+// EmptyLeaf returns the value of the leaves that were not set.
+func (t *Tree) EmptyLeaf() *big.Int {
+	return new(big.Int).Set(&t.empty[0])
+}
+
+// Root returns the root of the tree.
+func (t *Tree) Root() *big.Int {
+	return new(big.Int).Set(t.node(t.depth, 0))
+}
+
+// node returns the node at index of level, counted from the leaves.
+func (t *Tree) node(level int, index uint64) *big.Int {
+	if node, ok := t.nodes[level][index]; ok {
+		return node
+	}
+	return &t.empty[level]
+}
+
+// checkIndex reports indices past the last leaf.
+func (t *Tree) checkIndex(index uint64) error {
+	if t.depth < 64 && index >= uint64(1)<<t.depth {
+		return fmt.Errorf("index %d does not fit in a tree of depth %d", index, t.depth)
+	}
+	return nil
+}
+
+// Leaf returns the leaf at index.
+func (t *Tree) Leaf(index uint64) (*big.Int, error) {
+	if err := t.checkIndex(index); err != nil {
+		return nil, err
+	}
+	return new(big.Int).Set(t.node(0, index)), nil
+}
+
+// Proof returns the Merkle proof of the leaf at index: its sibling nodes from
+// the leaves up, as the circuit takes them.
+func (t *Tree) Proof(index uint64) ([]big.Int, error) {
+	if err := t.checkIndex(index); err != nil {
+		return nil, err
+	}
+	proof := make([]big.Int, t.depth)
+	for level := range proof {
+		proof[level].Set(t.node(level, index^1))
+		index >>= 1
+	}
+	return proof, nil
+}
+
+// Set sets the leaf at index and updates the nodes above it. If hashing
+// fails, the tree is left partially updated.
+func (t *Tree) Set(index uint64, leaf *big.Int) error {
+	if err := t.checkIndex(index); err != nil {
+		return err
+	}
+	t.nodes[0][index] = new(big.Int).Set(leaf)
+	for level := 0; level < t.depth; level++ {
+		left, right := t.node(level, index&^1), t.node(level, index|1)
+		parent, err := t.hash(left, right)
+		if err != nil {
+			return err
+		}
+		index >>= 1
+		t.nodes[level+1][index] = parent
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package merkletree
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/iden3/go-iden3-crypto/poseidon"
+)
+
+func poseidonHash(left, right *big.Int) (*big.Int, error) {
+	return poseidon.Hash([]*big.Int{left, right})
+}
+
+// denseRoot computes the root of leaves level by level.
+func denseRoot(t *testing.T, leaves []big.Int) *big.Int {
+	level := leaves
+	for len(level) > 1 {
+		next := make([]big.Int, len(level)/2)
+		for i := range next {
+			node, err := poseidonHash(&level[2*i], &level[2*i+1])
+			if err != nil {
+				t.Fatal(err)
+			}
+			next[i].Set(node)
+		}
+		level = next
+	}
+	return &level[0]
+}
+
+func TestTree(t *testing.T) {
+	const depth = 4
+	emptyLeaf := big.NewInt(7)
+	tree, err := New(depth, emptyLeaf, poseidonHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaves := make([]big.Int, 1<<depth)
+	for i := range leaves {
+		leaves[i].Set(emptyLeaf)
+	}
+	if tree.Root().Cmp(denseRoot(t, leaves)) != 0 {
+		t.Fatal("expected the root of the empty tree")
+	}
+	for _, index := range []uint64{3, 0, 15, 8, 3} {
+		leaf := big.NewInt(int64(100 + index))
+		if err = tree.Set(index, leaf); err != nil {
+			t.Fatal(err)
+		}
+		leaves[index].Set(leaf)
+		root := denseRoot(t, leaves)
+		if tree.Root().Cmp(root) != 0 {
+			t.Fatalf("root differs after setting leaf %d", index)
+		}
+		// The proof of every leaf opens the root.
+		for i := range leaves {
+			proof, err := tree.Proof(uint64(i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			node := new(big.Int).Set(&leaves[i])
+			for level := range proof {
+				left, right := node, &proof[level]
+				if (i>>level)&1 == 1 {
+					left, right = right, left
+				}
+				if node, err = poseidonHash(left, right); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if node.Cmp(root) != 0 {
+				t.Fatalf("proof of leaf %d does not open the root", i)
+			}
+		}
+	}
+	if _, err = tree.Proof(1 << depth); err == nil {
+		t.Fatal("expected an index past the last leaf to be rejected")
+	}
+}
@@ -0,0 +1,105 @@
+// Package metrics pushes the Prometheus metrics of the prover to backends
+// that cannot scrape them, such as StatsD agents and OpenTelemetry
+// collectors, for deployments whose monitoring cannot reach the network
+// segment of the provers. The metrics are still collected with the
+// Prometheus client; exporters translate what it gathers.
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+	"worldcoin/gnark-mbu/logging"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Exporter sends gathered metrics to a backend. Export is called with every
+// metric family at each push, with the cumulative values of counters and
+// histograms; exporters keep what they need to send deltas. Exporters are
+// not safe for concurrent use.
+type Exporter interface {
+	Export(ctx context.Context, families []*dto.MetricFamily) error
+	Close() error
+}
+
+// Open returns the exporter of location: statsd://host:port for a StatsD
+// agent, labels being appended to the metric names, datadog://host:port for
+// a DogStatsD agent, labels being sent as tags, or otlp://host:port and
+// otlps://host:port for an OpenTelemetry collector receiving OTLP over HTTP,
+// at /v1/metrics unless location has another path.
+func Open(location string) (Exporter, error) {
+	parsed, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metrics exporter %q: %w", location, err)
+	}
+	if parsed.Host == "" {
+		return nil, fmt.Errorf("invalid metrics exporter %q: missing host", location)
+	}
+	switch strings.ToLower(parsed.Scheme) {
+	case "statsd":
+		return NewStatsD(parsed.Host, false)
+	case "datadog", "dogstatsd":
+		return NewStatsD(parsed.Host, true)
+	case "otlp", "otlps":
+		scheme := "http"
+		if strings.ToLower(parsed.Scheme) == "otlps" {
+			scheme = "https"
+		}
+		path := parsed.Path
+		if path == "" || path == "/" {
+			path = "/v1/metrics"
+		}
+		return NewOTLP((&url.URL{Scheme: scheme, Host: parsed.Host, Path: path}).String()), nil
+	default:
+		return nil, fmt.Errorf("unsupported metrics exporter %q, expected statsd://, datadog://, otlp:// or otlps://", location)
+	}
+}
+
+// Push exports the metrics of gatherer every interval until ctx is done,
+// then once more so that the last values are not lost. Failed exports are
+// logged and retried at the next push.
+func Push(ctx context.Context, gatherer prometheus.Gatherer, exporter Exporter, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			push(ctx, gatherer, exporter, interval)
+		case <-ctx.Done():
+			final, cancel := context.WithTimeout(context.Background(), interval)
+			push(final, gatherer, exporter, interval)
+			cancel()
+			return
+		}
+	}
+}
+
+func push(ctx context.Context, gatherer prometheus.Gatherer, exporter Exporter, timeout time.Duration) {
+	families, err := gatherer.Gather()
+	if err != nil {
+		// Gather returns what it could gather along with the error.
+		logging.Logger().Warn().Err(err).Msg("error gathering metrics")
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if err = exporter.Export(ctx, families); err != nil {
+		logging.Logger().Error().Err(err).Msg("error exporting metrics")
+	}
+}
+
+// seriesKey identifies a series of a family by its name and labels.
+func seriesKey(name string, metric *dto.Metric) string {
+	var key strings.Builder
+	key.WriteString(name)
+	for _, label := range metric.GetLabel() {
+		key.WriteString("\xff")
+		key.WriteString(label.GetName())
+		key.WriteString("=")
+		key.WriteString(label.GetValue())
+	}
+	return key.String()
+}
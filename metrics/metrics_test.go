@@ -0,0 +1,151 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// testRegistry returns a registry with a metric of every type.
+func testRegistry() (*prometheus.Registry, *prometheus.CounterVec, prometheus.Gauge, prometheus.Histogram) {
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "proofs_total", Help: "Proofs."}, []string{"outcome"})
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "queue_depth", Help: "Queue."})
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "duration_seconds", Help: "Duration.", Buckets: []float64{1, 10}})
+	registry.MustRegister(counter, gauge, histogram)
+	return registry, counter, gauge, histogram
+}
+
+// receive returns the lines of the next StatsD packet on conn.
+func receive(t *testing.T, conn net.PacketConn) []string {
+	buf := make([]byte, maxPacketSize)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(string(buf[:n]), "\n")
+}
+
+func TestStatsD(t *testing.T) {
+	for _, test := range []struct {
+		tags          bool
+		first, second []string
+	}{
+		{false,
+			[]string{"duration_seconds_sum:7.5|c", "duration_seconds_count:2|c", "proofs_total.outcome.ok:3|c", "queue_depth:0|g", "queue_depth:-2|g"},
+			[]string{"duration_seconds_sum:20|c", "duration_seconds_count:1|c", "proofs_total.outcome.ok:1|c", "queue_depth:4|g"}},
+		{true,
+			[]string{"duration_seconds_sum:7.5|c", "duration_seconds_count:2|c", "proofs_total:3|c|#outcome:ok", "queue_depth:-2|g"},
+			[]string{"duration_seconds_sum:20|c", "duration_seconds_count:1|c", "proofs_total:1|c|#outcome:ok", "queue_depth:4|g"}},
+	} {
+		listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		exporter, err := NewStatsD(listener.LocalAddr().String(), test.tags)
+		if err != nil {
+			t.Fatal(err)
+		}
+		registry, counter, gauge, histogram := testRegistry()
+		counter.WithLabelValues("ok").Add(3)
+		gauge.Set(-2)
+		histogram.Observe(0.5)
+		histogram.Observe(7)
+		families, _ := registry.Gather()
+		if err = exporter.Export(context.Background(), families); err != nil {
+			t.Fatal(err)
+		}
+		if lines := receive(t, listener); strings.Join(lines, " ") != strings.Join(test.first, " ") {
+			t.Fatalf("tags %t: unexpected first export %q", test.tags, lines)
+		}
+		// Counters are sent as increments.
+		counter.WithLabelValues("ok").Inc()
+		gauge.Set(4)
+		histogram.Observe(20)
+		families, _ = registry.Gather()
+		if err = exporter.Export(context.Background(), families); err != nil {
+			t.Fatal(err)
+		}
+		if lines := receive(t, listener); strings.Join(lines, " ") != strings.Join(test.second, " ") {
+			t.Fatalf("tags %t: unexpected second export %q", test.tags, lines)
+		}
+		exporter.Close()
+		listener.Close()
+	}
+}
+
+func TestOTLP(t *testing.T) {
+	requests := make(chan otlpRequest, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request otlpRequest
+		if r.URL.Path != "/v1/metrics" || r.Header.Get("Content-Type") != "application/json" || json.NewDecoder(r.Body).Decode(&request) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		requests <- request
+	}))
+	defer collector.Close()
+	exporter, err := Open("otlp://" + strings.TrimPrefix(collector.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry, counter, gauge, histogram := testRegistry()
+	counter.WithLabelValues("ok").Add(3)
+	gauge.Set(5)
+	for _, value := range []float64{0.5, 7, 8, 20} {
+		histogram.Observe(value)
+	}
+	families, _ := registry.Gather()
+	if err = exporter.Export(context.Background(), families); err != nil {
+		t.Fatal(err)
+	}
+	request := <-requests
+	metrics := make(map[string]otlpMetric)
+	for _, metric := range request.ResourceMetrics[0].ScopeMetrics[0].Metrics {
+		metrics[metric.Name] = metric
+	}
+	if sum := metrics["proofs_total"].Sum; sum == nil || !sum.IsMonotonic || sum.DataPoints[0].AsDouble != 3 || sum.DataPoints[0].Attributes[0].Value.StringValue != "ok" {
+		t.Fatalf("unexpected counter %+v", metrics["proofs_total"])
+	}
+	if gauge := metrics["queue_depth"].Gauge; gauge == nil || gauge.DataPoints[0].AsDouble != 5 {
+		t.Fatalf("unexpected gauge %+v", metrics["queue_depth"])
+	}
+	// OTLP buckets count the samples between bounds.
+	histogramMetric := metrics["duration_seconds"].Histogram
+	if histogramMetric == nil || histogramMetric.DataPoints[0].Count != "4" || strings.Join(histogramMetric.DataPoints[0].BucketCounts, ",") != "1,2,1" {
+		t.Fatalf("unexpected histogram %+v", metrics["duration_seconds"])
+	}
+
+	collector.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	if err = exporter.Export(context.Background(), families); err == nil {
+		t.Fatal("expected the rejected export to fail")
+	}
+}
+
+func TestOpen(t *testing.T) {
+	for location, valid := range map[string]bool{
+		"statsd://127.0.0.1:8125":  true,
+		"datadog://127.0.0.1:8125": true,
+		"otlps://collector:4318":   true,
+		"prometheus://collector":   false,
+		"statsd://":                false,
+	} {
+		exporter, err := Open(location)
+		if (err == nil) != valid {
+			t.Fatalf("%s: expected valid to be %t, got %v", location, valid, err)
+		}
+		if exporter != nil {
+			exporter.Close()
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// serviceName is the service.name resource attribute of OTLP exports.
+const serviceName = "semaphore-mtb"
+
+// aggregationTemporalityCumulative is the OTLP aggregation temporality of
+// Prometheus counters and histograms, which are never reset.
+const aggregationTemporalityCumulative = 2
+
+// OTLP exports metrics to an OpenTelemetry collector in the JSON encoding of
+// OTLP over HTTP. Counters are exported as cumulative monotonic sums, gauges
+// and untyped metrics as gauges, histograms as cumulative histograms and
+// summaries as summaries.
+type OTLP struct {
+	endpoint string
+	client   *http.Client
+	// start is the start time of cumulative series, when the exporter was
+	// created.
+	start time.Time
+}
+
+// NewOTLP returns an exporter posting to the OTLP/HTTP metrics endpoint, such
+// as http://collector:4318/v1/metrics.
+func NewOTLP(endpoint string) *OTLP {
+	return &OTLP{endpoint: endpoint, client: &http.Client{}, start: time.Now()}
+}
+
+// The types below are those of the OTLP protobuf messages, in their JSON
+// encoding: 64-bit integers are strings and enums are numbers.
+
+type otlpRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope    `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpAttribute struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpMetric struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Sum         *otlpSum       `json:"sum,omitempty"`
+	Gauge       *otlpGauge     `json:"gauge,omitempty"`
+	Histogram   *otlpHistogram `json:"histogram,omitempty"`
+	Summary     *otlpSummary   `json:"summary,omitempty"`
+}
+
+type otlpNumberDataPoint struct {
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	AsDouble          float64         `json:"asDouble"`
+}
+
+type otlpSum struct {
+	DataPoints             []otlpNumberDataPoint `json:"dataPoints"`
+	AggregationTemporality int                   `json:"aggregationTemporality"`
+	IsMonotonic            bool                  `json:"isMonotonic"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpNumberDataPoint `json:"dataPoints"`
+}
+
+type otlpHistogramDataPoint struct {
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	Count             string          `json:"count"`
+	Sum               float64         `json:"sum"`
+	BucketCounts      []string        `json:"bucketCounts"`
+	ExplicitBounds    []float64       `json:"explicitBounds"`
+}
+
+type otlpHistogram struct {
+	DataPoints             []otlpHistogramDataPoint `json:"dataPoints"`
+	AggregationTemporality int                      `json:"aggregationTemporality"`
+}
+
+type otlpQuantileValue struct {
+	Quantile float64 `json:"quantile"`
+	Value    float64 `json:"value"`
+}
+
+type otlpSummaryDataPoint struct {
+	Attributes        []otlpAttribute     `json:"attributes,omitempty"`
+	StartTimeUnixNano string              `json:"startTimeUnixNano"`
+	TimeUnixNano      string              `json:"timeUnixNano"`
+	Count             string              `json:"count"`
+	Sum               float64             `json:"sum"`
+	QuantileValues    []otlpQuantileValue `json:"quantileValues"`
+}
+
+type otlpSummary struct {
+	DataPoints []otlpSummaryDataPoint `json:"dataPoints"`
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+func otlpAttributes(metric *dto.Metric) []otlpAttribute {
+	var attributes []otlpAttribute
+	for _, label := range metric.GetLabel() {
+		attributes = append(attributes, otlpAttribute{Key: label.GetName(), Value: otlpAnyValue{StringValue: label.GetValue()}})
+	}
+	return attributes
+}
+
+// histogramDataPoint converts a Prometheus histogram, whose buckets count
+// the samples up to their bound, into OTLP buckets counting the samples
+// between consecutive bounds, the last one above the highest bound.
+func histogramDataPoint(metric *dto.Metric, start, now string) otlpHistogramDataPoint {
+	histogram := metric.GetHistogram()
+	point := otlpHistogramDataPoint{
+		Attributes:        otlpAttributes(metric),
+		StartTimeUnixNano: start,
+		TimeUnixNano:      now,
+		Count:             strconv.FormatUint(histogram.GetSampleCount(), 10),
+		Sum:               histogram.GetSampleSum(),
+		BucketCounts:      []string{},
+		ExplicitBounds:    []float64{},
+	}
+	below := uint64(0)
+	for _, bucket := range histogram.GetBucket() {
+		point.ExplicitBounds = append(point.ExplicitBounds, bucket.GetUpperBound())
+		point.BucketCounts = append(point.BucketCounts, strconv.FormatUint(bucket.GetCumulativeCount()-below, 10))
+		below = bucket.GetCumulativeCount()
+	}
+	point.BucketCounts = append(point.BucketCounts, strconv.FormatUint(histogram.GetSampleCount()-below, 10))
+	return point
+}
+
+// request converts families into an OTLP export request at now.
+func (o *OTLP) request(families []*dto.MetricFamily, now time.Time) *otlpRequest {
+	start, at := unixNano(o.start), unixNano(now)
+	metrics := make([]otlpMetric, 0, len(families))
+	for _, family := range families {
+		metric := otlpMetric{Name: family.GetName(), Description: family.GetHelp()}
+		for _, m := range family.GetMetric() {
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				if metric.Sum == nil {
+					metric.Sum = &otlpSum{AggregationTemporality: aggregationTemporalityCumulative, IsMonotonic: true}
+				}
+				metric.Sum.DataPoints = append(metric.Sum.DataPoints, otlpNumberDataPoint{Attributes: otlpAttributes(m), StartTimeUnixNano: start, TimeUnixNano: at, AsDouble: m.GetCounter().GetValue()})
+			case dto.MetricType_HISTOGRAM:
+				if metric.Histogram == nil {
+					metric.Histogram = &otlpHistogram{AggregationTemporality: aggregationTemporalityCumulative}
+				}
+				metric.Histogram.DataPoints = append(metric.Histogram.DataPoints, histogramDataPoint(m, start, at))
+			case dto.MetricType_SUMMARY:
+				if metric.Summary == nil {
+					metric.Summary = &otlpSummary{}
+				}
+				point := otlpSummaryDataPoint{
+					Attributes:        otlpAttributes(m),
+					StartTimeUnixNano: start,
+					TimeUnixNano:      at,
+					Count:             strconv.FormatUint(m.GetSummary().GetSampleCount(), 10),
+					Sum:               m.GetSummary().GetSampleSum(),
+					QuantileValues:    []otlpQuantileValue{},
+				}
+				for _, quantile := range m.GetSummary().GetQuantile() {
+					point.QuantileValues = append(point.QuantileValues, otlpQuantileValue{Quantile: quantile.GetQuantile(), Value: quantile.GetValue()})
+				}
+				metric.Summary.DataPoints = append(metric.Summary.DataPoints, point)
+			default:
+				if metric.Gauge == nil {
+					metric.Gauge = &otlpGauge{}
+				}
+				value := m.GetGauge().GetValue()
+				if family.GetType() == dto.MetricType_UNTYPED {
+					value = m.GetUntyped().GetValue()
+				}
+				metric.Gauge.DataPoints = append(metric.Gauge.DataPoints, otlpNumberDataPoint{Attributes: otlpAttributes(m), TimeUnixNano: at, AsDouble: value})
+			}
+		}
+		metrics = append(metrics, metric)
+	}
+	return &otlpRequest{ResourceMetrics: []otlpResourceMetrics{{
+		Resource:     otlpResource{Attributes: []otlpAttribute{{Key: "service.name", Value: otlpAnyValue{StringValue: serviceName}}}},
+		ScopeMetrics: []otlpScopeMetrics{{Scope: otlpScope{Name: "worldcoin/gnark-mbu"}, Metrics: metrics}},
+	}}}
+}
+
+// Export posts families to the collector.
+func (o *OTLP) Export(ctx context.Context, families []*dto.MetricFamily) error {
+	body, err := json.Marshal(o.request(families, time.Now()))
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, o.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := o.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, response.Body)
+	if response.StatusCode/100 != 2 {
+		return fmt.Errorf("the collector answered %s", response.Status)
+	}
+	return nil
+}
+
+func (o *OTLP) Close() error {
+	o.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,152 @@
+package metrics
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// maxPacketSize keeps StatsD packets within the MTU of common networks, as
+// agents drop truncated datagrams.
+const maxPacketSize = 1432
+
+// StatsD exports metrics to a StatsD agent over UDP. Gauges are sent as
+// gauges, counters as the increments since the previous export, and
+// histograms and summaries as the increments of their sum and count. With
+// tags, labels are sent as DogStatsD tags; otherwise their names and values
+// are appended to the metric name, as plain StatsD has no labels.
+type StatsD struct {
+	conn net.Conn
+	tags bool
+	// last holds the cumulative value of every counter at the previous
+	// export.
+	last map[string]float64
+}
+
+// NewStatsD returns an exporter to the StatsD agent at address, sending
+// DogStatsD tags if tags is set.
+func NewStatsD(address string, tags bool) (*StatsD, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	return &StatsD{conn: conn, tags: tags, last: make(map[string]float64)}, nil
+}
+
+// statsdName replaces the characters StatsD separates fields with.
+func statsdName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '|', '@', '#', ',', '\n', ' ':
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+// line formats a sample of name, whose labels are those of metric.
+func (s *StatsD) line(name string, metric *dto.Metric, value float64, kind string) string {
+	var line strings.Builder
+	line.WriteString(statsdName(name))
+	if !s.tags {
+		for _, label := range metric.GetLabel() {
+			line.WriteString("." + statsdName(label.GetName()) + "." + strings.ReplaceAll(statsdName(label.GetValue()), ".", "_"))
+		}
+	}
+	line.WriteString(":" + strconv.FormatFloat(value, 'f', -1, 64) + "|" + kind)
+	if s.tags && len(metric.GetLabel()) != 0 {
+		tags := make([]string, len(metric.GetLabel()))
+		for i, label := range metric.GetLabel() {
+			tags[i] = statsdName(label.GetName()) + ":" + statsdName(label.GetValue())
+		}
+		line.WriteString("|#" + strings.Join(tags, ","))
+	}
+	return line.String()
+}
+
+// counter returns the line of the increment of a counter since the last
+// export, empty if it did not change. A counter below its last value was
+// reset, and counts from zero.
+func (s *StatsD) counter(name string, metric *dto.Metric, value float64) string {
+	key := seriesKey(name, metric)
+	delta := value - s.last[key]
+	if delta < 0 {
+		delta = value
+	}
+	s.last[key] = value
+	if delta == 0 {
+		return ""
+	}
+	return s.line(name, metric, delta, "c")
+}
+
+// gauge returns the lines setting a gauge. Plain StatsD reads signed values
+// as changes of the gauge, so negative ones are set from zero.
+func (s *StatsD) gauge(name string, metric *dto.Metric, value float64) []string {
+	if value < 0 && !s.tags {
+		return []string{s.line(name, metric, 0, "g"), s.line(name, metric, value, "g")}
+	}
+	return []string{s.line(name, metric, value, "g")}
+}
+
+// lines returns the lines of the samples of families.
+func (s *StatsD) lines(families []*dto.MetricFamily) []string {
+	var lines []string
+	add := func(line string) {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	for _, family := range families {
+		name := family.GetName()
+		for _, metric := range family.GetMetric() {
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				add(s.counter(name, metric, metric.GetCounter().GetValue()))
+			case dto.MetricType_GAUGE:
+				lines = append(lines, s.gauge(name, metric, metric.GetGauge().GetValue())...)
+			case dto.MetricType_HISTOGRAM:
+				add(s.counter(name+"_sum", metric, metric.GetHistogram().GetSampleSum()))
+				add(s.counter(name+"_count", metric, float64(metric.GetHistogram().GetSampleCount())))
+			case dto.MetricType_SUMMARY:
+				add(s.counter(name+"_sum", metric, metric.GetSummary().GetSampleSum()))
+				add(s.counter(name+"_count", metric, float64(metric.GetSummary().GetSampleCount())))
+			default:
+				lines = append(lines, s.gauge(name, metric, metric.GetUntyped().GetValue())...)
+			}
+		}
+	}
+	return lines
+}
+
+// Export sends the samples of families in packets of lines.
+func (s *StatsD) Export(ctx context.Context, families []*dto.MetricFamily) error {
+	var packet []byte
+	flush := func() error {
+		if len(packet) == 0 {
+			return nil
+		}
+		_, err := s.conn.Write(packet)
+		packet = packet[:0]
+		return err
+	}
+	for _, line := range s.lines(families) {
+		if len(packet) != 0 && len(packet)+1+len(line) > maxPacketSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		if len(packet) != 0 {
+			packet = append(packet, '\n')
+		}
+		packet = append(packet, line...)
+	}
+	return flush()
+}
+
+func (s *StatsD) Close() error {
+	return s.conn.Close()
+}
@@ -0,0 +1,281 @@
+// Package mtb embeds the prover in Go programs, so that they can prove and
+// verify batches in-process instead of calling the server over HTTP.
+//
+// Its API follows semantic versioning independently of the circuit and the
+// other packages of the module, whose APIs may change between releases:
+// within a major Version, exported identifiers are only added, never removed
+// or changed. Parameters and Proof are those of the prover, with the same
+// JSON and binary encodings as the server.
+package mtb
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"worldcoin/gnark-mbu/merkletree"
+	"worldcoin/gnark-mbu/prover"
+
+	"github.com/consensys/gnark/backend"
+)
+
+// Version is the semantic version of the API of this package.
+const Version = "1.2.0"
+
+type (
+	// Parameters are the inputs of a batch.
+	Parameters = prover.Parameters
+	// Proof is a Groth16 proof of a batch.
+	Proof = prover.Proof
+)
+
+// Error is returned by the methods of Prover. Code is the code the server
+// reports the error with, listed in the README, or cancelled when the
+// context of a proof is done. The typed errors of the prover are found with
+// errors.As.
+type Error struct {
+	Code string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+const (
+	// CodeCancelled is the code of the errors returned when the context of
+	// a proof is done before the proof completes.
+	CodeCancelled = "cancelled"
+	// CodeInvalidProof is the code of the errors returned for proofs that do
+	// not verify.
+	CodeInvalidProof = "invalid_proof"
+)
+
+func wrapError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Code: prover.ErrorCode(err), Err: err}
+}
+
+// Circuit describes the circuit a Prover proves batches for.
+type Circuit struct {
+	Curve          string
+	TreeDepth      uint32
+	BatchSize      uint32
+	Commitment     prover.Commitment
+	TreeHash       prover.TreeHash
+	Indexed        bool
+	PublicPostRoot bool
+	EmptyLeaf      big.Int
+	// Fingerprint identifies the circuit and the gnark version it was set
+	// up with, see prover.ProvingSystem.Fingerprint.
+	Fingerprint string
+	// Version is prover.CircuitSemver.
+	Version string
+}
+
+// Option configures a Prover.
+type Option func(*options)
+
+type options struct {
+	maxConcurrent  int
+	witnessWorkers int
+	proverOptions  []backend.ProverOption
+}
+
+// WithMaxConcurrentProofs bounds the number of proofs generated at once,
+// the further calls to Prove waiting for a slot. Zero means unbounded.
+func WithMaxConcurrentProofs(n int) Option {
+	return func(o *options) {
+		o.maxConcurrent = n
+	}
+}
+
+// WithWitnessWorkers sets the number of goroutines witnesses are built with,
+// GOMAXPROCS by default.
+func WithWitnessWorkers(n int) Option {
+	return func(o *options) {
+		o.witnessWorkers = n
+	}
+}
+
+// WithProverOptions passes options to the gnark prover and solver, such as
+// backend.WithHints for the hints of custom gadgets, see
+// prover.ProvingSystem.ProverOptions.
+func WithProverOptions(opts ...backend.ProverOption) Option {
+	return func(o *options) {
+		o.proverOptions = append(o.proverOptions, opts...)
+	}
+}
+
+// Prover proves and verifies batches for the circuit of a key file. It is
+// safe for concurrent use.
+type Prover struct {
+	system *prover.ProvingSystem
+	// slots holds a token for every proof in progress, nil if unbounded.
+	slots chan struct{}
+}
+
+// Load reads the key file at path, generated by the setup or keygen
+// commands.
+func Load(path string, opts ...Option) (*Prover, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	system, err := prover.ReadSystemFromFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return newProver(system, o), nil
+}
+
+// New returns a Prover for a proving system already in memory, such as one
+// returned by prover.Setup.
+func New(system *prover.ProvingSystem, opts ...Option) *Prover {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return newProver(system, o)
+}
+
+func newProver(system *prover.ProvingSystem, o options) *Prover {
+	p := &Prover{system: system}
+	if o.witnessWorkers > 0 {
+		system.WitnessWorkers = o.witnessWorkers
+	}
+	if len(o.proverOptions) != 0 {
+		system.ProverOptions = append(system.ProverOptions, o.proverOptions...)
+	}
+	if o.maxConcurrent > 0 {
+		p.slots = make(chan struct{}, o.maxConcurrent)
+	}
+	return p
+}
+
+// Circuit returns the circuit of the prover.
+func (p *Prover) Circuit() Circuit {
+	ps := p.system
+	circuit := Circuit{
+		Curve:          ps.Curve.String(),
+		TreeDepth:      ps.TreeDepth,
+		BatchSize:      ps.BatchSize,
+		Commitment:     ps.Commitment,
+		TreeHash:       ps.TreeHash,
+		Indexed:        ps.Indexed,
+		PublicPostRoot: ps.PublicPostRoot,
+		Fingerprint:    ps.Fingerprint(),
+		Version:        prover.CircuitSemver,
+	}
+	if circuit.Commitment == "" {
+		circuit.Commitment = prover.CommitmentKeccak
+	}
+	if circuit.TreeHash == "" {
+		circuit.TreeHash = prover.TreeHashPoseidon
+	}
+	circuit.EmptyLeaf.Set(&ps.EmptyLeaf)
+	return circuit
+}
+
+// NewTree returns an empty tree of the depth, empty leaf and tree hash of the
+// circuit of the prover.
+func (p *Prover) NewTree() (*merkletree.Tree, error) {
+	return p.system.NewTree()
+}
+
+// ParametersFromTree inserts idComms into tree at consecutive indices from
+// startIndex and returns the parameters proving the insertion, see
+// prover.ParametersFromTree.
+func ParametersFromTree(tree *merkletree.Tree, startIndex uint32, idComms []big.Int) (*Parameters, error) {
+	return prover.ParametersFromTree(tree, startIndex, idComms)
+}
+
+// Prove proves a batch. The input hash of params is computed if it is zero,
+// and checked otherwise. If ctx is done before the proof completes, Prove
+// returns an Error with CodeCancelled wrapping the error of the context; the
+// prover stops at its next cancellation check, but gnark cannot interrupt a
+// proof once it is generating it, which then completes in the background and
+// keeps its slot until it does. The slices of params must not be modified
+// until then.
+func (p *Prover) Prove(ctx context.Context, params *Parameters) (*Proof, error) {
+	if p.slots != nil {
+		select {
+		case p.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, &Error{Code: CodeCancelled, Err: ctx.Err()}
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		p.release()
+		return nil, &Error{Code: CodeCancelled, Err: err}
+	}
+	type result struct {
+		proof *Proof
+		err   error
+	}
+	// The input hash is computed into a copy, which abandoned proofs keep
+	// writing to.
+	batch := *params
+	batch.InputHash = *new(big.Int).Set(&params.InputHash)
+	done := make(chan result, 1)
+	go func() {
+		defer p.release()
+		proof, err := p.system.Prove(ctx, &batch)
+		done <- result{proof, err}
+	}()
+	select {
+	case res := <-done:
+		if errors.Is(res.err, context.Canceled) || errors.Is(res.err, context.DeadlineExceeded) {
+			return nil, &Error{Code: CodeCancelled, Err: res.err}
+		}
+		if res.err != nil {
+			return nil, wrapError(res.err)
+		}
+		params.InputHash.Set(&batch.InputHash)
+		return res.proof, nil
+	case <-ctx.Done():
+		return nil, &Error{Code: CodeCancelled, Err: ctx.Err()}
+	}
+}
+
+func (p *Prover) release() {
+	if p.slots != nil {
+		<-p.slots
+	}
+}
+
+// Check checks that params solve the circuit without proving them, which is
+// much faster. The input hash is handled as by Prove.
+func (p *Prover) Check(params *Parameters) error {
+	return wrapError(p.system.Check(params))
+}
+
+// Verify verifies a proof of a batch, computing its input hash from params
+// with the commitment of the circuit. The Merkle proofs of params are not
+// used. Proofs that do not verify are reported with CodeInvalidProof.
+func (p *Prover) Verify(params *Parameters, proof *Proof) error {
+	batch := *params
+	batch.InputHash = big.Int{}
+	commitment := p.system.Commitment
+	if commitment == "" {
+		commitment = prover.CommitmentKeccak
+	}
+	if err := batch.ComputeInputHashWith(commitment); err != nil {
+		return wrapError(err)
+	}
+	var err error
+	if p.system.PublicPostRoot {
+		err = p.system.VerifyWithPostRoot(batch.InputHash, batch.PostRoot, proof)
+	} else {
+		err = p.system.Verify(batch.InputHash, proof)
+	}
+	if err != nil {
+		return &Error{Code: CodeInvalidProof, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package mtb
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"worldcoin/gnark-mbu/prover"
+)
+
+func hex(s string) big.Int {
+	var i big.Int
+	i.SetString(s, 0)
+	return i
+}
+
+// testParameters inserts identity commitments 1 and 2 at the start of an
+// empty tree of depth 3.
+func testParameters() *Parameters {
+	emptyLevel1 := hex("0x2098f5fb9e239eab3ceac3f27b81e481dc3124d55ffed523a839ee8446b64864")
+	emptyLevel2 := hex("0x1069673dcdb12263df301a6ff584a7ec261a44cb9dc68df067a4774460b1f1e1")
+	return &Parameters{
+		PreRoot:  hex("0x18f43331537ee2af2e3d758d50f72106467c6eea50371dd528d57eb2b856d238"),
+		PostRoot: hex("0x2267bee7aae8ed55eb9aecff101145335ed1dd0a5a276a2b7eb3ae7d20e232d8"),
+		IdComms:  []big.Int{*big.NewInt(1), *big.NewInt(2)},
+		MerkleProofs: [][]big.Int{
+			{*big.NewInt(0), emptyLevel1, emptyLevel2},
+			{*big.NewInt(1), emptyLevel1, emptyLevel2},
+		},
+	}
+}
+
+func TestProver(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	if _, err := prover.SetupToFile(context.Background(), path, false, 3, 2, prover.WithCommitment(prover.CommitmentPoseidon)); err != nil {
+		t.Fatal(err)
+	}
+	p, err := Load(path, WithMaxConcurrentProofs(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if circuit := p.Circuit(); circuit.TreeDepth != 3 || circuit.BatchSize != 2 || circuit.Commitment != prover.CommitmentPoseidon || circuit.Fingerprint == "" {
+		t.Fatalf("unexpected circuit %+v", circuit)
+	}
+
+	params := testParameters()
+	proof, err := p.Prove(context.Background(), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.InputHash.Sign() == 0 {
+		t.Fatal("expected the input hash to be computed")
+	}
+	if err = p.Verify(testParameters(), proof); err != nil {
+		t.Fatal(err)
+	}
+
+	var mtbErr *Error
+	other := testParameters()
+	other.PostRoot.SetUint64(1)
+	if err = p.Verify(other, proof); !errors.As(err, &mtbErr) || mtbErr.Code != CodeInvalidProof {
+		t.Fatalf("expected the proof of another batch to be rejected, got %v", err)
+	}
+	var batchSize *prover.BatchSizeError
+	other = testParameters()
+	other.IdComms = other.IdComms[:1]
+	if err = p.Check(other); !errors.As(err, &mtbErr) || mtbErr.Code != "wrong_batch_size" || !errors.As(err, &batchSize) {
+		t.Fatalf("expected a wrong batch size error, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = p.Prove(ctx, testParameters()); !errors.As(err, &mtbErr) || mtbErr.Code != CodeCancelled || !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the proof to be cancelled, got %v", err)
+	}
+}
@@ -0,0 +1,379 @@
+package prover
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+
+	"github.com/consensys/gnark"
+	"github.com/consensys/gnark-crypto/ecc"
+	bls12377 "github.com/consensys/gnark-crypto/ecc/bls12-377"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/constraint"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+	"github.com/consensys/gnark/std/groth16_bls12377"
+)
+
+// AggregationCurve is the curve aggregated proofs are generated on. The
+// in-circuit verifier of gnark verifies BLS12-377 proofs in BW6-761 circuits,
+// so only proving systems set up on BLS12-377 can be aggregated. Ethereum has
+// precompiles for neither curve, so aggregated proofs can only be verified
+// off-chain, with Verify: aggregation does not reduce the gas of verifying
+// batches on the EVM, which still verifies every BN254 batch proof.
+const AggregationCurve = ecc.BW6_761
+
+// AggregationCircuit verifies a fixed number of proofs of the insertion
+// circuit. Its public inputs are the input hashes of the proofs.
+type AggregationCircuit struct {
+	InputHashes []frontend.Variable `gnark:",public"`
+
+	Proofs []groth16_bls12377.Proof `gnark:"input"`
+
+	// InnerVerifyingKey is the key of the aggregated proving system. It is
+	// compiled into the circuit as a constant, so that only proofs of that
+	// system are accepted.
+	InnerVerifyingKey groth16_bls12377.VerifyingKey `gnark:"-"`
+}
+
+// AggregationCircuitWithPublicPostRoots is AggregationCircuit for proving
+// systems exposing the post root, whose post roots follow the input hashes
+// in the public witness.
+type AggregationCircuitWithPublicPostRoots struct {
+	AggregationCircuit
+	PostRoots []frontend.Variable `gnark:",public"`
+}
+
+func (circuit *AggregationCircuit) verify(api frontend.API, postRoots []frontend.Variable) {
+	for i := range circuit.Proofs {
+		publicInputs := []frontend.Variable{circuit.InputHashes[i]}
+		if postRoots != nil {
+			publicInputs = append(publicInputs, postRoots[i])
+		}
+		groth16_bls12377.Verify(api, circuit.InnerVerifyingKey, circuit.Proofs[i], publicInputs)
+	}
+}
+
+func (circuit *AggregationCircuit) Define(api frontend.API) error {
+	circuit.verify(api, nil)
+	return nil
+}
+
+func (circuit *AggregationCircuitWithPublicPostRoots) Define(api frontend.API) error {
+	circuit.verify(api, circuit.PostRoots)
+	return nil
+}
+
+// newAggregationCircuit returns the shape of the circuit aggregating count
+// proofs verified by innerVerifyingKey.
+func newAggregationCircuit(innerVerifyingKey groth16.VerifyingKey, count int, publicPostRoot bool) frontend.Circuit {
+	circuit := AggregationCircuit{
+		InputHashes: make([]frontend.Variable, count),
+		Proofs:      make([]groth16_bls12377.Proof, count),
+	}
+	circuit.InnerVerifyingKey.Assign(innerVerifyingKey)
+	if publicPostRoot {
+		return &AggregationCircuitWithPublicPostRoots{AggregationCircuit: circuit, PostRoots: make([]frontend.Variable, count)}
+	}
+	return &circuit
+}
+
+// assignProof converts a BLS12-377 proof to its in-circuit representation.
+func assignProof(proof *Proof) (groth16_bls12377.Proof, error) {
+	var assigned groth16_bls12377.Proof
+	if proof.Proof.CurveID() != ecc.BLS12_377 {
+		return assigned, fmt.Errorf("only proofs on %s can be aggregated, got %s", ecc.BLS12_377, proof.Proof.CurveID())
+	}
+	// The proof types of gnark are internal, so the points are recovered
+	// from the serialized proof: Ar, Bs, Krs.
+	var buf bytes.Buffer
+	if _, err := proof.Proof.WriteRawTo(&buf); err != nil {
+		return assigned, err
+	}
+	var ar, krs bls12377.G1Affine
+	var bs bls12377.G2Affine
+	decoder := bls12377.NewDecoder(&buf)
+	for _, point := range []interface{}{&ar, &bs, &krs} {
+		if err := decoder.Decode(point); err != nil {
+			return assigned, err
+		}
+	}
+	assigned.Ar.Assign(&ar)
+	assigned.Bs.Assign(&bs)
+	assigned.Krs.Assign(&krs)
+	return assigned, nil
+}
+
+// AggregationInput is a proof to aggregate along with its public inputs.
+type AggregationInput struct {
+	Proof     *Proof
+	InputHash big.Int
+	// PostRoot is only used for proving systems with a public post root.
+	PostRoot big.Int
+}
+
+// AggregationSystem aggregates a fixed number of proofs of a proving system
+// set up on BLS12-377 into a single BW6-761 proof. TreeDepth, BatchSize and
+// PublicPostRoot describe the aggregated proving system.
+type AggregationSystem struct {
+	Count             uint32
+	TreeDepth         uint32
+	BatchSize         uint32
+	PublicPostRoot    bool
+	InnerVerifyingKey groth16.VerifyingKey
+	ProvingKey        groth16.ProvingKey
+	VerifyingKey      groth16.VerifyingKey
+	ConstraintSystem  constraint.ConstraintSystem
+}
+
+// SetupAggregation sets up the circuit aggregating count proofs of inner.
+func SetupAggregation(inner *ProvingSystem, count uint32) (*AggregationSystem, error) {
+	if inner.Curve != ecc.BLS12_377 {
+		return nil, fmt.Errorf("only proving systems on %s can be aggregated, got %s", ecc.BLS12_377, inner.Curve)
+	}
+	if count == 0 {
+		return nil, fmt.Errorf("at least one proof must be aggregated")
+	}
+	circuit := newAggregationCircuit(inner.VerifyingKey, int(count), inner.PublicPostRoot)
+	ccs, err := frontend.Compile(AggregationCurve.ScalarField(), r1cs.NewBuilder, circuit)
+	if err != nil {
+		return nil, err
+	}
+	pk, vk, err := groth16.Setup(ccs)
+	if err != nil {
+		return nil, err
+	}
+	return &AggregationSystem{
+		Count:             count,
+		TreeDepth:         inner.TreeDepth,
+		BatchSize:         inner.BatchSize,
+		PublicPostRoot:    inner.PublicPostRoot,
+		InnerVerifyingKey: inner.VerifyingKey,
+		ProvingKey:        pk,
+		VerifyingKey:      vk,
+		ConstraintSystem:  ccs,
+	}, nil
+}
+
+// assignment assigns the aggregation circuit, leaving the proofs unassigned
+// unless withProofs is set.
+func (as *AggregationSystem) assignment(inputs []AggregationInput, withProofs bool) (frontend.Circuit, error) {
+	if len(inputs) != int(as.Count) {
+		return nil, &BatchSizeError{Field: "proofs to aggregate", Expected: int(as.Count), Actual: len(inputs)}
+	}
+	field := ecc.BLS12_377.ScalarField()
+	circuit := AggregationCircuit{
+		InputHashes: make([]frontend.Variable, len(inputs)),
+		Proofs:      make([]groth16_bls12377.Proof, len(inputs)),
+	}
+	postRoots := make([]frontend.Variable, len(inputs))
+	for i, input := range inputs {
+		// The inner circuit reduces the input hash modulo its field.
+		circuit.InputHashes[i] = new(big.Int).Mod(&input.InputHash, field)
+		postRoots[i] = input.PostRoot
+		if !withProofs {
+			continue
+		}
+		if input.Proof == nil {
+			return nil, fmt.Errorf("proof %d is missing", i)
+		}
+		var err error
+		if circuit.Proofs[i], err = assignProof(input.Proof); err != nil {
+			return nil, fmt.Errorf("proof %d: %w", i, err)
+		}
+	}
+	if as.PublicPostRoot {
+		return &AggregationCircuitWithPublicPostRoots{AggregationCircuit: circuit, PostRoots: postRoots}, nil
+	}
+	return &circuit, nil
+}
+
+// Aggregate proves that all inputs are valid proofs of the inner proving
+// system. Each proof is verified natively first so that an invalid one is
+// reported rather than failing the aggregation.
+func (as *AggregationSystem) Aggregate(inputs []AggregationInput) (*Proof, error) {
+	assignment, err := as.assignment(inputs, true)
+	if err != nil {
+		return nil, err
+	}
+	inner := &VerifyingSystem{
+		Curve:          ecc.BLS12_377,
+		TreeDepth:      as.TreeDepth,
+		BatchSize:      as.BatchSize,
+		PublicPostRoot: as.PublicPostRoot,
+		VerifyingKey:   as.InnerVerifyingKey,
+	}
+	for i, input := range inputs {
+		var postRoot frontend.Variable
+		if as.PublicPostRoot {
+			postRoot = input.PostRoot
+		}
+		if err = inner.verify(input.InputHash, postRoot, input.Proof); err != nil {
+			return nil, &AggregationError{Proof: i, Err: err}
+		}
+	}
+	witness, err := frontend.NewWitness(assignment, AggregationCurve.ScalarField())
+	if err != nil {
+		return nil, &WitnessError{Err: err}
+	}
+	proof, err := groth16.Prove(as.ConstraintSystem, as.ProvingKey, witness)
+	if err != nil {
+		return nil, &WitnessError{Err: err}
+	}
+	return &Proof{proof}, nil
+}
+
+// Verify verifies an aggregated proof against the input hashes and, for
+// proving systems with a public post root, the post roots of its proofs.
+func (as *AggregationSystem) Verify(inputHashes []big.Int, postRoots []big.Int, proof *Proof) error {
+	if proof.Proof.CurveID() != AggregationCurve {
+		return fmt.Errorf("proof is on curve %s, aggregated proofs use %s", proof.Proof.CurveID(), AggregationCurve)
+	}
+	if as.PublicPostRoot && len(postRoots) != len(inputHashes) {
+		return fmt.Errorf("expected %d post roots, got %d", len(inputHashes), len(postRoots))
+	}
+	inputs := make([]AggregationInput, len(inputHashes))
+	for i := range inputHashes {
+		inputs[i].InputHash = inputHashes[i]
+		if as.PublicPostRoot {
+			inputs[i].PostRoot = postRoots[i]
+		}
+	}
+	publicAssignment, err := as.assignment(inputs, false)
+	if err != nil {
+		return err
+	}
+	witness, err := frontend.NewWitness(publicAssignment, AggregationCurve.ScalarField(), frontend.PublicOnly())
+	if err != nil {
+		return err
+	}
+	return groth16.Verify(proof.Proof, as.VerifyingKey, witness)
+}
+
+// aggregationFileMagic prefixes aggregation key files, which follow the
+// layout of proving system key files: a JSON header, the keys, the
+// length-prefixed constraint system and a SHA-256 checksum.
+var aggregationFileMagic = [4]byte{'M', 'B', 'U', 'A'}
+
+type aggregationFileHeader struct {
+	Count          uint32 `json:"count"`
+	TreeDepth      uint32 `json:"treeDepth"`
+	BatchSize      uint32 `json:"batchSize"`
+	PublicPostRoot bool   `json:"publicPostRoot,omitempty"`
+	GnarkVersion   string `json:"gnarkVersion"`
+}
+
+func (as *AggregationSystem) WriteTo(w io.Writer) (int64, error) {
+	header, err := json.Marshal(&aggregationFileHeader{
+		Count:          as.Count,
+		TreeDepth:      as.TreeDepth,
+		BatchSize:      as.BatchSize,
+		PublicPostRoot: as.PublicPostRoot,
+		GnarkVersion:   gnark.Version.String(),
+	})
+	if err != nil {
+		return 0, err
+	}
+	digest := sha256.New()
+	hashed := io.MultiWriter(w, digest)
+	var prefix [8]byte
+	copy(prefix[:], aggregationFileMagic[:])
+	binary.BigEndian.PutUint32(prefix[4:], uint32(len(header)))
+	written, err := hashed.Write(append(prefix[:], header...))
+	totalWritten := int64(written)
+	if err != nil {
+		return totalWritten, err
+	}
+	for _, object := range []io.WriterTo{as.InnerVerifyingKey, as.ProvingKey, as.VerifyingKey} {
+		keyWritten, err := object.WriteTo(hashed)
+		totalWritten += keyWritten
+		if err != nil {
+			return totalWritten, err
+		}
+	}
+	keyWritten, err := writeFramed(hashed, as.ConstraintSystem)
+	totalWritten += keyWritten
+	if err != nil {
+		return totalWritten, err
+	}
+	keyWritten, err = writeChecksum(w, digest)
+	totalWritten += keyWritten
+	return totalWritten, err
+}
+
+func (as *AggregationSystem) UnsafeReadFrom(r io.Reader) (int64, error) {
+	digest := sha256.New()
+	hashed := io.TeeReader(r, digest)
+	var prefix [8]byte
+	read, err := io.ReadFull(hashed, prefix[:])
+	totalRead := int64(read)
+	if err != nil {
+		return totalRead, err
+	}
+	if !bytes.Equal(prefix[:4], aggregationFileMagic[:]) {
+		return totalRead, fmt.Errorf("not an aggregation keys file")
+	}
+	headerBytes := make([]byte, binary.BigEndian.Uint32(prefix[4:]))
+	read, err = io.ReadFull(hashed, headerBytes)
+	totalRead += int64(read)
+	if err != nil {
+		return totalRead, err
+	}
+	var header aggregationFileHeader
+	if err = json.Unmarshal(headerBytes, &header); err != nil {
+		return totalRead, fmt.Errorf("invalid aggregation keys file header: %w", err)
+	}
+	if header.GnarkVersion != gnark.Version.String() {
+		return totalRead, &StaleKeysError{Field: "gnark version", Expected: gnark.Version.String(), Actual: header.GnarkVersion}
+	}
+	as.Count = header.Count
+	as.TreeDepth = header.TreeDepth
+	as.BatchSize = header.BatchSize
+	as.PublicPostRoot = header.PublicPostRoot
+
+	as.InnerVerifyingKey = groth16.NewVerifyingKey(ecc.BLS12_377)
+	as.ProvingKey = groth16.NewProvingKey(AggregationCurve)
+	as.VerifyingKey = groth16.NewVerifyingKey(AggregationCurve)
+	keys := []interface {
+		UnsafeReadFrom(io.Reader) (int64, error)
+	}{as.InnerVerifyingKey, as.ProvingKey, as.VerifyingKey}
+	for _, key := range keys {
+		keyRead, err := key.UnsafeReadFrom(hashed)
+		totalRead += keyRead
+		if err != nil {
+			return totalRead, err
+		}
+	}
+	as.ConstraintSystem = groth16.NewCS(AggregationCurve)
+	keyRead, err := readFramed(hashed, as.ConstraintSystem)
+	totalRead += keyRead
+	if err != nil {
+		return totalRead, err
+	}
+	keyRead, err = readChecksum(r, digest)
+	totalRead += keyRead
+	return totalRead, err
+}
+
+func ReadAggregationSystemFromFile(path string) (as *AggregationSystem, err error) {
+	as = new(AggregationSystem)
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		closeErr := file.Close()
+		if closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}()
+
+	_, err = as.UnsafeReadFrom(file)
+	return
+}
@@ -0,0 +1,112 @@
+package prover
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+	"github.com/consensys/gnark/test"
+)
+
+// innerProofs sets up squareCircuit on BLS12-377, which has a single public
+// input like the insertion circuit, and proves it for each square.
+func innerProofs(t *testing.T, squares ...int64) (groth16.VerifyingKey, []AggregationInput) {
+	cs, err := frontend.Compile(ecc.BLS12_377.ScalarField(), r1cs.NewBuilder, &squareCircuit{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, vk, err := groth16.Setup(cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputs := make([]AggregationInput, len(squares))
+	for i, square := range squares {
+		root := new(big.Int).Sqrt(big.NewInt(square))
+		witness, err := frontend.NewWitness(&squareCircuit{X: root, Y: square}, ecc.BLS12_377.ScalarField())
+		if err != nil {
+			t.Fatal(err)
+		}
+		proof, err := groth16.Prove(cs, pk, witness)
+		if err != nil {
+			t.Fatal(err)
+		}
+		inputs[i] = AggregationInput{Proof: &Proof{proof}, InputHash: *big.NewInt(square)}
+	}
+	return vk, inputs
+}
+
+func TestAggregationCircuit(t *testing.T) {
+	vk, inputs := innerProofs(t, 4, 9)
+	as := &AggregationSystem{Count: 2, InnerVerifyingKey: vk}
+	circuit := newAggregationCircuit(vk, 2, false)
+
+	assignment, err := as.assignment(inputs, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = test.IsSolved(circuit, assignment, AggregationCurve.ScalarField()); err != nil {
+		t.Fatal(err)
+	}
+
+	inputs[0], inputs[1] = inputs[1], inputs[0]
+	inputs[0].InputHash, inputs[1].InputHash = inputs[1].InputHash, inputs[0].InputHash
+	assignment, err = as.assignment(inputs, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = test.IsSolved(circuit, assignment, AggregationCurve.ScalarField()); err == nil {
+		t.Fatal("expected proofs paired with the wrong input hashes to be rejected")
+	}
+}
+
+func TestAggregateRejectsInvalidProof(t *testing.T) {
+	vk, inputs := innerProofs(t, 4, 9)
+	as := &AggregationSystem{Count: 2, InnerVerifyingKey: vk}
+	inputs[1].InputHash.SetInt64(16)
+	var aggregationErr *AggregationError
+	if _, err := as.Aggregate(inputs); !errors.As(err, &aggregationErr) || aggregationErr.Proof != 1 {
+		t.Fatalf("expected proof 1 to be reported, got %v", err)
+	}
+	var batchSize *BatchSizeError
+	if _, err := as.Aggregate(inputs[:1]); !errors.As(err, &batchSize) {
+		t.Fatalf("expected a wrong number of proofs to be reported, got %v", err)
+	}
+}
+
+func TestAggregationSystemRoundTrip(t *testing.T) {
+	innerVk, _ := innerProofs(t)
+	// The key file layout does not depend on the circuit, so keys of a small
+	// BW6-761 circuit stand in for those of the aggregation circuit.
+	cs, err := frontend.Compile(AggregationCurve.ScalarField(), r1cs.NewBuilder, &squareCircuit{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, vk, err := groth16.Setup(cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	as := &AggregationSystem{Count: 4, TreeDepth: 20, BatchSize: 100, InnerVerifyingKey: innerVk, ProvingKey: pk, VerifyingKey: vk, ConstraintSystem: cs}
+	var buf bytes.Buffer
+	if _, err = as.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	keysFile := buf.Bytes()
+
+	var read AggregationSystem
+	if _, err = read.UnsafeReadFrom(bytes.NewReader(keysFile)); err != nil {
+		t.Fatal(err)
+	}
+	if read.Count != 4 || read.TreeDepth != 20 || read.BatchSize != 100 {
+		t.Fatalf("unexpected aggregation system %+v", read)
+	}
+
+	var corrupt *CorruptKeysError
+	if _, err = new(AggregationSystem).UnsafeReadFrom(bytes.NewReader(keysFile[:len(keysFile)-1])); !errors.As(err, &corrupt) {
+		t.Fatalf("expected a truncated file to be rejected, got %v", err)
+	}
+}
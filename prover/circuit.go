@@ -1,14 +1,32 @@
 package prover
 
 import (
+	"fmt"
+	"math/big"
 	"strconv"
 	"worldcoin/gnark-mbu/prover/keccak"
 	"worldcoin/gnark-mbu/prover/poseidon"
+	"worldcoin/gnark-mbu/prover/sha2"
 
 	"github.com/consensys/gnark/frontend"
 )
 
-const emptyLeaf = 0
+// CircuitVersion identifies the revision of the circuit's constraints. Bump it
+// whenever they change so that keys set up for an earlier revision are
+// rejected when loaded. It is the major version of CircuitSemver.
+const CircuitVersion = 2
+
+// CircuitMinorVersion and CircuitPatchVersion count changes that keep the
+// constraints, and hence the keys, compatible: new options and fixes to how
+// witnesses are assigned. Reset them when CircuitVersion is bumped.
+const (
+	CircuitMinorVersion = 0
+	CircuitPatchVersion = 0
+)
+
+// CircuitSemver is the semantic version of the circuit, reported with every
+// proof so that callers can tell which revision produced it.
+var CircuitSemver = fmt.Sprintf("%d.%d.%d", CircuitVersion, CircuitMinorVersion, CircuitPatchVersion)
 
 type MbuCircuit struct {
 	// single public input
@@ -19,14 +37,38 @@ type MbuCircuit struct {
 	PreRoot    frontend.Variable   `gnark:"input"`
 	PostRoot   frontend.Variable   `gnark:"input"`
 	IdComms    []frontend.Variable `gnark:"input"`
+	// Indices are the tree indices of IdComms for circuits set up
+	// WithIndices, and nil otherwise.
+	Indices []frontend.Variable `gnark:"input"`
 
 	// private inputs
 	MerkleProofs [][]frontend.Variable `gnark:"input"`
 
+	// EmptyLeaf is the value of tree slots that have not been inserted into.
+	// It is compiled into the circuit as a constant, nil meaning zero.
+	EmptyLeaf *big.Int `gnark:"-"`
+	// Commitment is the hash computing InputHash, the empty commitment
+	// meaning Keccak.
+	Commitment Commitment `gnark:"-"`
+	// TreeHash is the hash of the nodes of the tree, the empty tree hash
+	// meaning Poseidon.
+	TreeHash TreeHash `gnark:"-"`
+	// NonZeroIdComms asserts that every identity commitment is non-zero.
+	NonZeroIdComms bool `gnark:"-"`
+	// LookupKeccak hashes Keccak commitments with keccak.LookupKeccak.
+	LookupKeccak bool `gnark:"-"`
+
 	BatchSize int
 	Depth     int
 }
 
+// MbuCircuitWithPublicPostRoot is MbuCircuit with PostRoot additionally
+// exposed as a public input, following InputHash in the public witness.
+type MbuCircuitWithPublicPostRoot struct {
+	MbuCircuit
+	PublicPostRoot frontend.Variable `gnark:",public"`
+}
+
 type bitPatternLengthError struct {
 	actualLength int
 }
@@ -35,13 +77,13 @@ func (e *bitPatternLengthError) Error() string {
 	return "Bit pattern length was " + strconv.Itoa(e.actualLength) + " not a total number of bytes"
 }
 
-func VerifyProof(api frontend.API, h poseidon.Poseidon, proofSet, helper []frontend.Variable) frontend.Variable {
+func VerifyProof(api frontend.API, h TreeHasher, proofSet, helper []frontend.Variable) frontend.Variable {
 	sum := proofSet[0]
 	for i := 1; i < len(proofSet); i++ {
 		api.AssertIsBoolean(helper[i-1])
 		d1 := api.Select(helper[i-1], proofSet[i], sum)
 		d2 := api.Select(helper[i-1], sum, proofSet[i])
-		sum = nodeSum(h, d1, d2)
+		sum = h.Hash(d1, d2)
 	}
 	return sum
 }
@@ -106,73 +148,251 @@ func FromBinaryBigEndian(bitsBigEndian []frontend.Variable, api frontend.API) (v
 	return api.FromBinary(bitsLittleEndian...), nil
 }
 
-func (circuit *MbuCircuit) Define(api frontend.API) error {
+// index returns the tree index of the i-th insertion.
+func (circuit *MbuCircuit) index(api frontend.API, i int) frontend.Variable {
+	if circuit.Indices != nil {
+		return circuit.Indices[i]
+	}
+	return api.Add(circuit.StartIndex, i)
+}
+
+// emptyLeaf returns the empty leaf value and whether it takes part in the
+// input hash, which is the case for all values other than zero.
+func (circuit *MbuCircuit) emptyLeaf() (frontend.Variable, bool) {
+	if circuit.EmptyLeaf == nil || circuit.EmptyLeaf.Sign() == 0 {
+		return 0, false
+	}
+	return circuit.EmptyLeaf, true
+}
+
+// hasher is a hash gadget fed bits, as Keccak and SHA-256 are.
+type hasher interface {
+	Write(data ...frontend.Variable)
+	Sum() []frontend.Variable
+}
+
+// inputHash hashes the inputs with the commitment of the circuit, matching
+// Parameters.ComputeInputHashWith.
+func (circuit *MbuCircuit) inputHash(api frontend.API) (frontend.Variable, error) {
+	if !circuit.LookupKeccak {
+		return circuit.hashInputs(api, func(inputSize int) hasher {
+			kh := keccak.NewKeccak256(api, inputSize)
+			return &kh
+		})
+	}
+	lookups := keccak.NewLookups(api)
+	sum, err := circuit.hashInputs(api, func(inputSize int) hasher {
+		kh := keccak.NewLookupKeccak256(lookups, inputSize)
+		return &kh
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sum, lookups.Commit()
+}
+
+// hashInputs hashes the inputs, with newKeccak for the Keccak commitments.
+func (circuit *MbuCircuit) hashInputs(api frontend.API, newKeccak func(inputSize int) hasher) (frontend.Variable, error) {
+	emptyLeaf, emptyLeafHashed := circuit.emptyLeaf()
+
+	if circuit.Commitment == CommitmentPoseidon {
+		h := poseidon.NewPoseidon2(api)
+		sum := circuit.StartIndex
+		inputs := append([]frontend.Variable{circuit.PreRoot, circuit.PostRoot}, circuit.IdComms[:circuit.BatchSize]...)
+		if emptyLeafHashed {
+			inputs = append(inputs, emptyLeaf)
+		}
+		inputs = append(inputs, circuit.Indices...)
+		for _, input := range inputs {
+			sum = nodeSum(h, sum, input)
+		}
+		return sum, nil
+	}
+	if circuit.Commitment == CommitmentKeccakChained {
+		return circuit.chainedKeccak(api, newKeccak, emptyLeaf, emptyLeafHashed)
+	}
+
 	// Hash private inputs.
 	// We keccak hash all input to save verification gas. Inputs are arranged as follows:
 	// StartIndex || PreRoot || PostRoot || IdComms[0] || IdComms[1] || ... || IdComms[batchSize-1]
 	//     32	  ||   256   ||   256    ||    256     ||    256     || ... ||     256 bits
+	// A non-zero empty leaf is appended as a further 256 bits, and the
+	// indices of circuits set up WithIndices as 32 bits each. SHA-256 hashes
+	// the same bits.
 
-	kh := keccak.NewKeccak256(api, (circuit.BatchSize+2)*256+32)
+	hashedWords := circuit.BatchSize + 2
+	if emptyLeafHashed {
+		hashedWords += 1
+	}
+	hashedBits := hashedWords*256 + 32*(len(circuit.Indices)+1)
+	var hasher hasher
+	if circuit.Commitment == CommitmentSHA256 {
+		sh := sha2.NewSha256(api)
+		hasher = &sh
+	} else {
+		hasher = newKeccak(hashedBits)
+	}
 
 	var bits []frontend.Variable
 	var err error
 
-	// We convert all the inputs to the keccak hash to use big-endian (network) byte
+	// We convert all the inputs to the hash to use big-endian (network) byte
 	// ordering so that it agrees with Solidity. This ensures that we don't have to
 	// perform the conversion inside the contract and hence save on gas.
 	bits, err = ToBinaryBigEndian(circuit.StartIndex, 32, api)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	kh.Write(bits...)
+	hasher.Write(bits...)
 
 	bits, err = ToBinaryBigEndian(circuit.PreRoot, 256, api)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	kh.Write(bits...)
+	hasher.Write(bits...)
 
 	bits, err = ToBinaryBigEndian(circuit.PostRoot, 256, api)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	kh.Write(bits...)
+	hasher.Write(bits...)
 
 	for i := 0; i < circuit.BatchSize; i++ {
 		bits, err = ToBinaryBigEndian(circuit.IdComms[i], 256, api)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		kh.Write(bits...)
+		hasher.Write(bits...)
 	}
 
-	var sum frontend.Variable
-	sum, err = FromBinaryBigEndian(kh.Sum(), api)
-	if err != nil {
-		return err
+	if emptyLeafHashed {
+		bits, err = ToBinaryBigEndian(emptyLeaf, 256, api)
+		if err != nil {
+			return nil, err
+		}
+		hasher.Write(bits...)
+	}
+
+	for _, index := range circuit.Indices {
+		bits, err = ToBinaryBigEndian(index, 32, api)
+		if err != nil {
+			return nil, err
+		}
+		hasher.Write(bits...)
 	}
 
 	// The same endianness conversion has been performed in the hash generation
-	// externally, so we can safely assert their equality here.
+	// externally, so we can safely assert the equality of the result with the
+	// input hash.
+	return FromBinaryBigEndian(hasher.Sum(), api)
+}
+
+// chainedKeccak hashes the inputs with CommitmentKeccakChained, matching
+// Parameters.chainedKeccak. The digest of every hash is fed to the next one
+// as it is output, in the bit order of the inputs.
+func (circuit *MbuCircuit) chainedKeccak(api frontend.API, newKeccak func(inputSize int) hasher, emptyLeaf frontend.Variable, emptyLeafHashed bool) (frontend.Variable, error) {
+	// hash hashes values of the given sizes in bits, each a variable or,
+	// for digests, its bits.
+	hash := func(values []frontend.Variable, sizes []int, digest []frontend.Variable) ([]frontend.Variable, error) {
+		inputBits := len(digest)
+		for _, size := range sizes {
+			inputBits += size
+		}
+		// The domain byte is counted so that inputs filling whole blocks
+		// are padded with a further block.
+		kh := newKeccak(inputBits + 8)
+		kh.Write(digest...)
+		for i, value := range values {
+			bits, err := ToBinaryBigEndian(value, sizes[i], api)
+			if err != nil {
+				return nil, err
+			}
+			kh.Write(bits...)
+		}
+		return kh.Sum(), nil
+	}
+
+	digest, err := hash([]frontend.Variable{circuit.StartIndex, circuit.PreRoot, circuit.PostRoot}, []int{32, 256, 256}, nil)
+	if err != nil {
+		return nil, err
+	}
+	for start := 0; start < circuit.BatchSize; start += KeccakChainChunk {
+		end := start + KeccakChainChunk
+		if end > circuit.BatchSize {
+			end = circuit.BatchSize
+		}
+		sizes := make([]int, end-start)
+		for i := range sizes {
+			sizes[i] = 256
+		}
+		if digest, err = hash(circuit.IdComms[start:end], sizes, digest); err != nil {
+			return nil, err
+		}
+	}
+	if emptyLeafHashed || len(circuit.Indices) != 0 {
+		var values []frontend.Variable
+		var sizes []int
+		if emptyLeafHashed {
+			values, sizes = append(values, emptyLeaf), append(sizes, 256)
+		}
+		for _, index := range circuit.Indices {
+			values, sizes = append(values, index), append(sizes, 32)
+		}
+		if digest, err = hash(values, sizes, digest); err != nil {
+			return nil, err
+		}
+	}
+	return FromBinaryBigEndian(digest, api)
+}
+
+// assertCapacity asserts that StartIndex + BatchSize does not exceed
+// 2^Depth, matching Parameters.ValidateCapacity. Decomposing every index into
+// Depth bits implies it, but fails on whichever insertion overflows; this
+// assertion fails first, with the mustBeLessOrEq debug information of the
+// sum and the capacity. Insertions at indices do not use StartIndex, and
+// every index is bounded by its decomposition.
+func (circuit *MbuCircuit) assertCapacity(api frontend.API) {
+	if circuit.Indices != nil {
+		return
+	}
+	capacity := new(big.Int).Lsh(big.NewInt(1), uint(circuit.Depth))
+	api.AssertIsLessOrEqual(api.Add(circuit.StartIndex, circuit.BatchSize), capacity)
+}
+
+func (circuit *MbuCircuit) Define(api frontend.API) error {
+	emptyLeaf, _ := circuit.emptyLeaf()
+	sum, err := circuit.inputHash(api)
+	if err != nil {
+		return err
+	}
 	api.AssertIsEqual(circuit.InputHash, sum)
 
+	circuit.assertCapacity(api)
+
 	// Actual batch merkle proof verification.
 	var root frontend.Variable
-	ph := poseidon.NewPoseidon2(api)
+	th, err := circuit.TreeHash.treeHasher(api)
+	if err != nil {
+		return err
+	}
 
 	prevRoot := circuit.PreRoot
 
 	// Individual insertions.
 	for i := 0; i < circuit.BatchSize; i += 1 {
-		currentIndex := api.Add(circuit.StartIndex, i)
+		if circuit.NonZeroIdComms {
+			api.AssertIsDifferent(circuit.IdComms[i], 0)
+		}
+
+		currentIndex := circuit.index(api, i)
 		currentPath := api.ToBinary(currentIndex, circuit.Depth)
 
 		// Verify proof for empty leaf.
-		root = VerifyProof(api, ph, append([]frontend.Variable{emptyLeaf}, circuit.MerkleProofs[i][:]...), currentPath)
+		root = VerifyProof(api, th, append([]frontend.Variable{emptyLeaf}, circuit.MerkleProofs[i][:]...), currentPath)
 		api.AssertIsEqual(root, prevRoot)
 
 		// Verify proof for idComm.
-		root = VerifyProof(api, ph, append([]frontend.Variable{circuit.IdComms[i]}, circuit.MerkleProofs[i][:]...), currentPath)
+		root = VerifyProof(api, th, append([]frontend.Variable{circuit.IdComms[i]}, circuit.MerkleProofs[i][:]...), currentPath)
 
 		// Set root for next iteration.
 		prevRoot = root
@@ -183,3 +403,11 @@ func (circuit *MbuCircuit) Define(api frontend.API) error {
 
 	return nil
 }
+
+func (circuit *MbuCircuitWithPublicPostRoot) Define(api frontend.API) error {
+	if err := circuit.MbuCircuit.Define(api); err != nil {
+		return err
+	}
+	api.AssertIsEqual(circuit.PublicPostRoot, circuit.PostRoot)
+	return nil
+}
@@ -0,0 +1,103 @@
+package prover
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/consensys/gnark/constraint"
+	"github.com/fxamacker/cbor/v2"
+)
+
+// constraintSystemDebugFields are the serialized fields of constraint systems
+// that do not constrain the circuit: the gnark version and the debug
+// information, which holds the source files and lines of the constraints and
+// so depends on where the binary was built.
+var constraintSystemDebugFields = []string{"GnarkVersion", "Logs", "DebugInfo", "SymbolTable", "MDebug"}
+
+// ConstraintSystemHash returns the hex SHA-256 hash of the deterministic CBOR
+// encoding of cs without its debug information. It is the same for every
+// build compiling the same circuit, and for the constraint system of the key
+// files set up for it.
+func ConstraintSystemHash(cs constraint.ConstraintSystem) (string, error) {
+	var buf bytes.Buffer
+	if _, err := cs.WriteTo(&buf); err != nil {
+		return "", err
+	}
+	var fields map[string]cbor.RawMessage
+	if err := cbor.Unmarshal(buf.Bytes(), &fields); err != nil {
+		return "", fmt.Errorf("decoding the constraint system: %w", err)
+	}
+	for _, field := range constraintSystemDebugFields {
+		delete(fields, field)
+	}
+	encoded, err := cborEncMode.Marshal(fields)
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.Sum256(encoded)
+	return fmt.Sprintf("%x", digest), nil
+}
+
+// CircuitFingerprint identifies the constraint system of a circuit, so that
+// CI can check that a prover proves the circuit a verifier was exported for.
+type CircuitFingerprint struct {
+	// ConstraintSystemHash is the ConstraintSystemHash of the circuit.
+	ConstraintSystemHash string `json:"constraintSystemHash"`
+	// Fingerprint is the fingerprint of the circuit recorded in key files.
+	Fingerprint    string `json:"fingerprint"`
+	CircuitVersion string `json:"circuitVersion"`
+	Constraints    int    `json:"constraints"`
+	PublicInputs   int    `json:"publicInputs"`
+	SecretInputs   int    `json:"secretInputs"`
+	Internal       int    `json:"internalVariables"`
+}
+
+// CircuitFingerprint returns the CircuitFingerprint of the constraint
+// system of the proving system.
+func (ps *ProvingSystem) CircuitFingerprint() (*CircuitFingerprint, error) {
+	if ps.ConstraintSystem == nil {
+		return nil, fmt.Errorf("the proving system holds no constraint system")
+	}
+	hash, err := ConstraintSystemHash(ps.ConstraintSystem)
+	if err != nil {
+		return nil, err
+	}
+	internal, secret, public := ps.ConstraintSystem.GetNbVariables()
+	return &CircuitFingerprint{
+		ConstraintSystemHash: hash,
+		Fingerprint:          ps.Fingerprint(),
+		CircuitVersion:       CircuitSemver,
+		Constraints:          ps.ConstraintSystem.GetNbConstraints(),
+		// The constant wire is counted as a public variable, but is no
+		// input.
+		PublicInputs: public - 1,
+		SecretInputs: secret,
+		Internal:     internal,
+	}, nil
+}
+
+// FingerprintCircuit compiles the circuit and returns its
+// CircuitFingerprint.
+func FingerprintCircuit(ctx context.Context, treeDepth uint32, batchSize uint32, opts ...CircuitOption) (*CircuitFingerprint, error) {
+	ccs, err := BuildR1CS(ctx, treeDepth, batchSize, opts...)
+	if err != nil {
+		return nil, err
+	}
+	options := newCircuitOptions(opts)
+	ps := &ProvingSystem{
+		Curve:            options.curve,
+		TreeDepth:        treeDepth,
+		BatchSize:        batchSize,
+		PublicPostRoot:   options.publicPostRoot,
+		EmptyLeaf:        options.emptyLeaf,
+		Commitment:       options.commitment,
+		Indexed:          options.indexed,
+		NonZeroIdComms:   options.nonZeroIdComms,
+		TreeHash:         options.treeHash,
+		LookupKeccak:     options.lookupKeccak,
+		ConstraintSystem: ccs,
+	}
+	return ps.CircuitFingerprint()
+}
@@ -0,0 +1,50 @@
+package prover
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend/groth16"
+)
+
+func TestCircuitFingerprint(t *testing.T) {
+	ctx := context.Background()
+	fingerprint, err := FingerprintCircuit(ctx, 2, 2, WithCommitment(CommitmentPoseidon))
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := FingerprintCircuit(ctx, 2, 2, WithCommitment(CommitmentPoseidon))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *again != *fingerprint || len(fingerprint.ConstraintSystemHash) != 64 || fingerprint.PublicInputs != 1 {
+		t.Fatalf("expected compiling the circuit again to yield the same fingerprint, got %+v and %+v", fingerprint, again)
+	}
+
+	// The constraint system of key files hashes like the compiled one.
+	cs, err := BuildR1CS(ctx, 2, 2, WithCommitment(CommitmentPoseidon))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err = cs.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	read := groth16.NewCS(ecc.BN254)
+	if _, err = read.ReadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if hash, err := ConstraintSystemHash(read); err != nil || hash != fingerprint.ConstraintSystemHash {
+		t.Fatalf("expected the read constraint system to hash to %s, got %s (%v)", fingerprint.ConstraintSystemHash, hash, err)
+	}
+
+	other, err := FingerprintCircuit(ctx, 2, 2, WithCommitment(CommitmentPoseidon), WithPublicPostRoot())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.ConstraintSystemHash == fingerprint.ConstraintSystemHash || other.PublicInputs != 2 {
+		t.Fatalf("expected another circuit to hash differently, got %+v", other)
+	}
+}
@@ -0,0 +1,351 @@
+package prover
+
+import (
+	"context"
+	"math/big"
+	"strings"
+	"testing"
+	"worldcoin/gnark-mbu/merkletree"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/test"
+	"github.com/iden3/go-iden3-crypto/poseidon"
+)
+
+const testTreeDepth = 3
+const testBatchSize = 2
+
+func hex(s string) big.Int {
+	var bi big.Int
+	bi.SetString(s, 0)
+	return bi
+}
+
+// testParameters returns a valid insertion of identity commitments 1 and 2 at
+// the start of an empty tree of depth testTreeDepth.
+func testParameters() *Parameters {
+	emptyLevel1 := hex("0x2098f5fb9e239eab3ceac3f27b81e481dc3124d55ffed523a839ee8446b64864")
+	emptyLevel2 := hex("0x1069673dcdb12263df301a6ff584a7ec261a44cb9dc68df067a4774460b1f1e1")
+	params := Parameters{
+		StartIndex: 0,
+		PreRoot:    hex("0x18f43331537ee2af2e3d758d50f72106467c6eea50371dd528d57eb2b856d238"),
+		PostRoot:   hex("0x2267bee7aae8ed55eb9aecff101145335ed1dd0a5a276a2b7eb3ae7d20e232d8"),
+		IdComms:    []big.Int{*big.NewInt(1), *big.NewInt(2)},
+		MerkleProofs: [][]big.Int{
+			{*big.NewInt(0), emptyLevel1, emptyLevel2},
+			{*big.NewInt(1), emptyLevel1, emptyLevel2},
+		},
+	}
+	params.ComputeInputHash()
+	return &params
+}
+
+func poseidonHash(a, b big.Int) big.Int {
+	h, err := poseidon.Hash([]*big.Int{&a, &b})
+	if err != nil {
+		panic(err)
+	}
+	return *h
+}
+
+// insertionParameters returns an insertion of identity commitments 1 and 2 at
+// the start of an empty tree of depth testTreeDepth filled with emptyLeaf.
+func insertionParameters(emptyLeaf big.Int) *Parameters {
+	empty := make([]big.Int, testTreeDepth+1)
+	empty[0] = emptyLeaf
+	for i := 1; i <= testTreeDepth; i++ {
+		empty[i] = poseidonHash(empty[i-1], empty[i-1])
+	}
+	first, second := *big.NewInt(1), *big.NewInt(2)
+	params := Parameters{
+		StartIndex: 0,
+		PreRoot:    empty[testTreeDepth],
+		PostRoot:   poseidonHash(poseidonHash(poseidonHash(first, second), empty[1]), empty[2]),
+		IdComms:    []big.Int{first, second},
+		MerkleProofs: [][]big.Int{
+			{empty[0], empty[1], empty[2]},
+			{first, empty[1], empty[2]},
+		},
+		EmptyLeaf: emptyLeaf,
+	}
+	params.ComputeInputHash()
+	return &params
+}
+
+// indexedParameters returns an insertion of identity commitments 1 and 2 at
+// indices 1 and 4 of an empty tree of depth testTreeDepth, leaving gaps.
+func indexedParameters() *Parameters {
+	leaves := make([]big.Int, 1<<testTreeDepth)
+	levels := func() [][]big.Int {
+		level := append([]big.Int(nil), leaves...)
+		all := [][]big.Int{level}
+		for len(level) > 1 {
+			next := make([]big.Int, len(level)/2)
+			for i := range next {
+				next[i] = poseidonHash(level[2*i], level[2*i+1])
+			}
+			all = append(all, next)
+			level = next
+		}
+		return all
+	}
+	params := Parameters{
+		StartIndex: 1,
+		PreRoot:    levels()[testTreeDepth][0],
+		IdComms:    []big.Int{*big.NewInt(1), *big.NewInt(2)},
+		Indices:    []uint32{1, 4},
+	}
+	for i, index := range params.Indices {
+		tree := levels()
+		proof := make([]big.Int, testTreeDepth)
+		for level := range proof {
+			proof[level] = tree[level][(index>>level)^1]
+		}
+		params.MerkleProofs = append(params.MerkleProofs, proof)
+		leaves[index] = params.IdComms[i]
+	}
+	params.PostRoot = levels()[testTreeDepth][0]
+	params.ComputeInputHash()
+	return &params
+}
+
+func testAssignment(params *Parameters) MbuCircuit {
+	idComms := make([]frontend.Variable, len(params.IdComms))
+	for i := range params.IdComms {
+		idComms[i] = params.IdComms[i]
+	}
+	proofs := make([][]frontend.Variable, len(params.MerkleProofs))
+	for i := range params.MerkleProofs {
+		proofs[i] = make([]frontend.Variable, len(params.MerkleProofs[i]))
+		for j := range params.MerkleProofs[i] {
+			proofs[i][j] = params.MerkleProofs[i][j]
+		}
+	}
+	// The test engine does not reduce assignments modulo the field, unlike
+	// witness construction, so the keccak output needs reducing here.
+	inputHash := new(big.Int).Mod(&params.InputHash, ecc.BN254.ScalarField())
+	var indices []frontend.Variable
+	for _, index := range params.Indices {
+		indices = append(indices, index)
+	}
+	return MbuCircuit{
+		InputHash:    inputHash,
+		StartIndex:   params.StartIndex,
+		PreRoot:      params.PreRoot,
+		PostRoot:     params.PostRoot,
+		IdComms:      idComms,
+		Indices:      indices,
+		MerkleProofs: proofs,
+		BatchSize:    testBatchSize,
+		Depth:        testTreeDepth,
+	}
+}
+
+func TestCircuitWithPublicPostRoot(t *testing.T) {
+	params := testParameters()
+	options := newCircuitOptions([]CircuitOption{WithPublicPostRoot()})
+
+	assignment := testAssignment(params)
+	circuit := testAssignment(params)
+	err := test.IsSolved(options.wrap(circuit, nil), options.wrap(assignment, params.PostRoot), ecc.BN254.ScalarField())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wrongRoot := new(big.Int).Add(&params.PostRoot, big.NewInt(1))
+	err = test.IsSolved(options.wrap(circuit, nil), options.wrap(assignment, wrongRoot), ecc.BN254.ScalarField())
+	if err == nil {
+		t.Fatal("expected the circuit to reject a public post root that differs from the computed one")
+	}
+}
+
+func TestInsertionParametersMatchKnownVector(t *testing.T) {
+	expected := testParameters()
+	params := insertionParameters(*big.NewInt(0))
+	if params.PreRoot.Cmp(&expected.PreRoot) != 0 || params.PostRoot.Cmp(&expected.PostRoot) != 0 {
+		t.Fatal("native roots do not match the known test vector")
+	}
+}
+
+func TestCircuitWithEmptyLeaf(t *testing.T) {
+	emptyLeaf := hex("0x0c7a1c1d5d5f8d3a7d6fd0ac0a4d8f8a7bd1b4c4b5a9c3e7e93fcd1e2f4a5b6c")
+	params := insertionParameters(emptyLeaf)
+	options := newCircuitOptions([]CircuitOption{WithEmptyLeaf(emptyLeaf)})
+
+	assignment := testAssignment(params)
+	circuit := testAssignment(params)
+	err := test.IsSolved(options.wrap(circuit, nil), options.wrap(assignment, nil), ecc.BN254.ScalarField())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The same insertion against a zero-filled tree must be rejected.
+	zeroOptions := newCircuitOptions(nil)
+	err = test.IsSolved(zeroOptions.wrap(circuit, nil), zeroOptions.wrap(assignment, nil), ecc.BN254.ScalarField())
+	if err == nil {
+		t.Fatal("expected the circuit to reject proofs against a different empty leaf")
+	}
+}
+
+func TestCircuitWithCommitment(t *testing.T) {
+	emptyLeaf := hex("0x0c7a1c1d5d5f8d3a7d6fd0ac0a4d8f8a7bd1b4c4b5a9c3e7e93fcd1e2f4a5b6c")
+	for _, commitment := range []Commitment{CommitmentKeccak, CommitmentPoseidon, CommitmentSHA256, CommitmentKeccakChained} {
+		params := insertionParameters(emptyLeaf)
+		if err := params.ComputeInputHashWith(commitment); err != nil {
+			t.Fatal(err)
+		}
+		options := newCircuitOptions([]CircuitOption{WithEmptyLeaf(emptyLeaf), WithCommitment(commitment)})
+
+		assignment := testAssignment(params)
+		circuit := testAssignment(params)
+		err := test.IsSolved(options.wrap(circuit, nil), options.wrap(assignment, nil), ecc.BN254.ScalarField())
+		if err != nil {
+			t.Fatalf("%s: %v", commitment, err)
+		}
+
+		// The input hash of another commitment must be rejected.
+		other := CommitmentKeccak
+		if commitment == CommitmentKeccak {
+			other = CommitmentSHA256
+		}
+		if err = params.ComputeInputHashWith(other); err != nil {
+			t.Fatal(err)
+		}
+		assignment = testAssignment(params)
+		err = test.IsSolved(options.wrap(circuit, nil), options.wrap(assignment, nil), ecc.BN254.ScalarField())
+		if err == nil {
+			t.Fatalf("%s: expected the %s input hash to be rejected", commitment, other)
+		}
+	}
+}
+
+func TestCircuitWithIndices(t *testing.T) {
+	for _, commitment := range []Commitment{CommitmentKeccak, CommitmentPoseidon, CommitmentSHA256, CommitmentKeccakChained} {
+		params := indexedParameters()
+		if err := params.ComputeInputHashWith(commitment); err != nil {
+			t.Fatal(err)
+		}
+		if err := params.Verify(testTreeDepth, testBatchSize); err != nil {
+			t.Fatalf("%s: %v", commitment, err)
+		}
+		options := newCircuitOptions([]CircuitOption{WithIndices(), WithCommitment(commitment)})
+
+		assignment := testAssignment(params)
+		circuit := testAssignment(params)
+		err := test.IsSolved(options.wrap(circuit, nil), options.wrap(assignment, nil), ecc.BN254.ScalarField())
+		if err != nil {
+			t.Fatalf("%s: %v", commitment, err)
+		}
+
+		// The indices are bound by the input hash.
+		params.Indices = []uint32{1, 5}
+		if err = params.ComputeInputHashWith(commitment); err != nil {
+			t.Fatal(err)
+		}
+		assignment.InputHash = testAssignment(params).InputHash
+		err = test.IsSolved(options.wrap(circuit, nil), options.wrap(assignment, nil), ecc.BN254.ScalarField())
+		if err == nil {
+			t.Fatalf("%s: expected the input hash of other indices to be rejected", commitment)
+		}
+	}
+}
+
+// circuitShapes records the size of the circuit with depth 2 and batch size 2
+// at CircuitVersion. A change in the constraints changes these counts; bump
+// CircuitVersion and record the new counts under it, so that keys set up for
+// the previous constraints are rejected instead of producing invalid proofs.
+var circuitShapes = map[int]map[Commitment][2]int{
+	1: {
+		CommitmentKeccak:        {193693, 155508},
+		CommitmentPoseidon:      {2906, 2900},
+		CommitmentSHA256:        {111315, 98205},
+		CommitmentKeccakChained: {381979, 306420},
+	},
+	2: {
+		CommitmentKeccak:        {193948, 155762},
+		CommitmentPoseidon:      {3161, 3154},
+		CommitmentSHA256:        {111570, 98459},
+		CommitmentKeccakChained: {382234, 306674},
+	},
+}
+
+func TestCircuitVersionMatchesConstraints(t *testing.T) {
+	shapes, ok := circuitShapes[CircuitVersion]
+	if !ok {
+		t.Fatalf("no constraint counts recorded for circuit version %d", CircuitVersion)
+	}
+	for commitment, expected := range shapes {
+		cs, err := BuildR1CS(context.Background(), 2, 2, WithCommitment(commitment))
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual := [2]int{cs.GetNbConstraints(), cs.GetNbInternalVariables()}
+		if actual != expected {
+			t.Fatalf("%s: the circuit has %v constraints and internal variables, circuit version %d has %v; bump CircuitVersion", commitment, actual, CircuitVersion, expected)
+		}
+	}
+}
+
+func TestCircuitAssertsCapacity(t *testing.T) {
+	hash, err := NativeTreeHash(TreeHashPoseidon, ecc.BN254)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := merkletree.New(testTreeDepth, new(big.Int), hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The batch fills the last slots of the tree.
+	params, err := ParametersFromTree(tree, 6, []big.Int{*big.NewInt(1), *big.NewInt(2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	params.ComputeInputHash()
+	circuit, assignment := testAssignment(params), testAssignment(params)
+	if err = test.IsSolved(&circuit, &assignment, ecc.BN254.ScalarField()); err != nil {
+		t.Fatal(err)
+	}
+
+	params.StartIndex = 7
+	params.ComputeInputHash()
+	assignment = testAssignment(params)
+	err = test.IsSolved(&circuit, &assignment, ecc.BN254.ScalarField())
+	// The failure names the assertion rather than an insertion.
+	if err == nil || !strings.Contains(err.Error(), "assertCapacity") {
+		t.Fatalf("expected the capacity assertion to fail, got %v", err)
+	}
+}
+
+func TestCircuitWithNonZeroIdComms(t *testing.T) {
+	params := testParameters()
+	options := newCircuitOptions([]CircuitOption{WithNonZeroIdComms()})
+	assignment := testAssignment(params)
+	circuit := testAssignment(params)
+	err := test.IsSolved(options.wrap(circuit, nil), options.wrap(assignment, nil), ecc.BN254.ScalarField())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Inserting a zero commitment leaves the tree unchanged, which the
+	// default circuit accepts.
+	hash, err := NativeTreeHash(TreeHashPoseidon, ecc.BN254)
+	if err != nil {
+		t.Fatal(err)
+	}
+	params.IdComms[1].SetUint64(0)
+	postRoot, err := nativeRoot(hash, &params.IdComms[1], 1, params.MerkleProofs[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	params.PostRoot.Set(postRoot)
+	params.ComputeInputHash()
+	assignment = testAssignment(params)
+	if err = test.IsSolved(&circuit, &assignment, ecc.BN254.ScalarField()); err != nil {
+		t.Fatal(err)
+	}
+	err = test.IsSolved(options.wrap(circuit, nil), options.wrap(assignment, nil), ecc.BN254.ScalarField())
+	if err == nil {
+		t.Fatal("expected the circuit to reject a zero identity commitment")
+	}
+}
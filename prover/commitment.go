@@ -0,0 +1,199 @@
+package prover
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/iden3/go-iden3-crypto/keccak256"
+	"github.com/iden3/go-iden3-crypto/poseidon"
+)
+
+// Commitment is the hash binding the inputs of a batch to the input hash, the
+// single public input of the circuit. It is chosen at setup and recorded in
+// the key file.
+//
+// There is no commitment mode committing to the identity commitments with
+// gnark's BSB22 commitments (api.Commit) instead of hashing them in the
+// circuit. Gnark v0.8.0 only solves the commitment inside groth16.Prove, so
+// Check and the test engine cannot solve such circuits, drops the commitment
+// keys when serializing keys, so that they cannot be written to key files,
+// drops the commitment and its proof of knowledge when serializing proofs,
+// and its Solidity verifier does not check them. A verifier recomputing the
+// commitment from the batch would need the basis of the commitment key,
+// which gnark-crypto keeps unexported. That leaves no way to deploy such a
+// circuit, or to verify its proofs outside of this process, until gnark is
+// upgraded to a version serializing commitments.
+type Commitment string
+
+const (
+	// CommitmentKeccak hashes the inputs with Keccak-256, which the EVM
+	// computes cheaply. It is the default and by far the most expensive
+	// in constraints.
+	CommitmentKeccak Commitment = "keccak"
+	// CommitmentPoseidon chains the inputs through Poseidon, as
+	// H(...H(H(StartIndex, PreRoot), PostRoot)..., IdComms[n-1]) followed by
+	// the empty leaf and indices when they are hashed, for
+	// verifiers that do not need to recompute the hash on the EVM. It is
+	// only available on BN254, the field the Poseidon constants are for.
+	CommitmentPoseidon Commitment = "poseidon"
+	// CommitmentSHA256 hashes the inputs with SHA-256, for chains with a
+	// SHA-256 precompile but no Keccak.
+	CommitmentSHA256 Commitment = "sha256"
+	// CommitmentKeccakChained chains Keccak-256 over chunks of
+	// KeccakChainChunk identity commitments, as
+	//
+	//	h = keccak256(StartIndex || PreRoot || PostRoot)
+	//	h = keccak256(h || IdComms[i*KeccakChainChunk:(i+1)*KeccakChainChunk]), for every chunk
+	//
+	// followed, if there is a non-zero empty leaf or indices, by
+	// h = keccak256(h || EmptyLeaf || Indices), with the encoding of
+	// CommitmentKeccak. Verifiers hash one chunk at a time instead of
+	// copying the whole batch, which keeps the memory of the on-chain
+	// hash and of compiling the circuit bounded for batches of thousands.
+	CommitmentKeccakChained Commitment = "keccak-chained"
+)
+
+// KeccakChainChunk is the number of identity commitments hashed at a time by
+// CommitmentKeccakChained.
+const KeccakChainChunk = 64
+
+// ParseCommitment parses the name of a commitment, the empty name selecting
+// Keccak.
+func ParseCommitment(name string) (Commitment, error) {
+	switch commitment := Commitment(name); commitment {
+	case CommitmentKeccak, CommitmentPoseidon, CommitmentSHA256, CommitmentKeccakChained:
+		return commitment, nil
+	case "":
+		return CommitmentKeccak, nil
+	default:
+		return "", fmt.Errorf("unknown commitment: %s", name)
+	}
+}
+
+// WithCommitment selects the hash binding the inputs to the input hash. It
+// defaults to CommitmentKeccak.
+func WithCommitment(commitment Commitment) CircuitOption {
+	return func(o *circuitOptions) {
+		o.commitment = commitment
+	}
+}
+
+func validateCommitment(commitment Commitment, curve ecc.ID) error {
+	if _, err := ParseCommitment(string(commitment)); err != nil {
+		return err
+	}
+	if commitment == CommitmentPoseidon && curve != ecc.BN254 {
+		return fmt.Errorf("the poseidon commitment is only available on %s", ecc.BN254)
+	}
+	return nil
+}
+
+// ComputeInputHashWith computes the input hash with the given commitment.
+// Keccak and SHA-256 hash the same big-endian encoding of the inputs, see
+// ComputeInputHash, which chained Keccak splits into chunks; Poseidon hashes
+// the field elements themselves.
+func (p *Parameters) ComputeInputHashWith(commitment Commitment) error {
+	switch commitment {
+	case CommitmentKeccak, "":
+		data, err := p.hashedInputs()
+		if err != nil {
+			return err
+		}
+		p.InputHash.SetBytes(keccak256.Hash(data))
+	case CommitmentSHA256:
+		data, err := p.hashedInputs()
+		if err != nil {
+			return err
+		}
+		digest := sha256.Sum256(data)
+		p.InputHash.SetBytes(digest[:])
+	case CommitmentKeccakChained:
+		p.InputHash.SetBytes(p.chainedKeccak())
+	case CommitmentPoseidon:
+		inputs := []*big.Int{&p.PreRoot, &p.PostRoot}
+		for i := range p.IdComms {
+			inputs = append(inputs, &p.IdComms[i])
+		}
+		if p.EmptyLeaf.Sign() != 0 {
+			inputs = append(inputs, &p.EmptyLeaf)
+		}
+		for _, index := range p.Indices {
+			inputs = append(inputs, new(big.Int).SetUint64(uint64(index)))
+		}
+		hash := new(big.Int).SetUint64(uint64(p.StartIndex))
+		for _, input := range inputs {
+			var err error
+			if hash, err = poseidon.Hash([]*big.Int{hash, input}); err != nil {
+				return err
+			}
+		}
+		p.InputHash.Set(hash)
+	default:
+		return fmt.Errorf("unknown commitment: %s", commitment)
+	}
+	return nil
+}
+
+// chainedKeccak hashes the inputs with CommitmentKeccakChained.
+func (p *Parameters) chainedKeccak() []byte {
+	data := binary.BigEndian.AppendUint32(nil, p.StartIndex)
+	data = append(data, p.PreRoot.FillBytes(make([]byte, 32))...)
+	data = append(data, p.PostRoot.FillBytes(make([]byte, 32))...)
+	hash := keccak256.Hash(data)
+	for start := 0; start < len(p.IdComms); start += KeccakChainChunk {
+		end := start + KeccakChainChunk
+		if end > len(p.IdComms) {
+			end = len(p.IdComms)
+		}
+		data = append([]byte(nil), hash...)
+		for i := start; i < end; i++ {
+			data = append(data, p.IdComms[i].FillBytes(make([]byte, 32))...)
+		}
+		hash = keccak256.Hash(data)
+	}
+	if p.EmptyLeaf.Sign() == 0 && len(p.Indices) == 0 {
+		return hash
+	}
+	data = append([]byte(nil), hash...)
+	if p.EmptyLeaf.Sign() != 0 {
+		data = append(data, p.EmptyLeaf.FillBytes(make([]byte, 32))...)
+	}
+	for _, index := range p.Indices {
+		data = binary.BigEndian.AppendUint32(data, index)
+	}
+	return keccak256.Hash(data)
+}
+
+// hashedInputs encodes the inputs for the Keccak and SHA-256 commitments.
+func (p *Parameters) hashedInputs() ([]byte, error) {
+	var data []byte
+	buf := new(bytes.Buffer)
+	err := binary.Write(buf, binary.BigEndian, p.StartIndex)
+	if err != nil {
+		return nil, err
+	}
+	data = append(data, buf.Bytes()...)
+	data = append(data, p.PreRoot.FillBytes(make([]byte, 32))...)
+	data = append(data, p.PostRoot.FillBytes(make([]byte, 32))...)
+	for _, v := range p.IdComms {
+		idBytes := v.Bytes()
+		// extend to 32 bytes if necessary, maintaining big-endian ordering
+		if len(idBytes) < 32 {
+			idBytes = append(make([]byte, 32-len(idBytes)), idBytes...)
+		}
+		data = append(data, idBytes...)
+	}
+	// A non-zero empty leaf is bound by the hash as well, zero is implied.
+	if p.EmptyLeaf.Sign() != 0 {
+		data = append(data, p.EmptyLeaf.FillBytes(make([]byte, 32))...)
+	}
+	// So are the indices of circuits set up WithIndices.
+	for _, index := range p.Indices {
+		data = binary.BigEndian.AppendUint32(data, index)
+	}
+	return data, nil
+}
@@ -0,0 +1,47 @@
+package prover
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/iden3/go-iden3-crypto/keccak256"
+)
+
+func TestChainedKeccak(t *testing.T) {
+	word := func(v int64) []byte {
+		return big.NewInt(v).FillBytes(make([]byte, 32))
+	}
+	params := &Parameters{StartIndex: 3, IdComms: make([]big.Int, 2*KeccakChainChunk+2)}
+	params.PreRoot.SetInt64(1)
+	params.PostRoot.SetInt64(2)
+	for i := range params.IdComms {
+		params.IdComms[i].SetInt64(int64(i + 10))
+	}
+	if err := params.ComputeInputHashWith(CommitmentKeccakChained); err != nil {
+		t.Fatal(err)
+	}
+
+	// Three chunks, the last of two commitments.
+	hash := keccak256.Hash([]byte{0, 0, 0, 3}, word(1), word(2))
+	for start := 0; start < len(params.IdComms); start += KeccakChainChunk {
+		data := append([]byte(nil), hash...)
+		for i := start; i < len(params.IdComms) && i < start+KeccakChainChunk; i++ {
+			data = append(data, word(int64(i+10))...)
+		}
+		hash = keccak256.Hash(data)
+	}
+	if params.InputHash.Cmp(new(big.Int).SetBytes(hash)) != 0 {
+		t.Fatalf("expected the input hash to chain the chunks, got %s", params.InputHash.Text(16))
+	}
+
+	// The empty leaf and indices are hashed after the last chunk.
+	params.EmptyLeaf.SetInt64(5)
+	params.Indices = []uint32{7}
+	if err := params.ComputeInputHashWith(CommitmentKeccakChained); err != nil {
+		t.Fatal(err)
+	}
+	tail := keccak256.Hash(hash, word(5), []byte{0, 0, 0, 7})
+	if params.InputHash.Cmp(new(big.Int).SetBytes(tail)) != 0 {
+		t.Fatalf("expected the input hash to bind the empty leaf and indices, got %s", params.InputHash.Text(16))
+	}
+}
@@ -0,0 +1,91 @@
+package prover
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/consensys/gnark-crypto/ecc"
+)
+
+// supportedCurves lists the curves the groth16 backend can prove on.
+var supportedCurves = []ecc.ID{
+	ecc.BN254,
+	ecc.BLS12_377,
+	ecc.BLS12_381,
+	ecc.BLS24_315,
+	ecc.BLS24_317,
+	ecc.BW6_761,
+	ecc.BW6_633,
+}
+
+// ParseCurve parses a curve name as printed by ecc.ID.String, e.g. "bn254"
+// or "bls12_381".
+func ParseCurve(name string) (ecc.ID, error) {
+	for _, curve := range supportedCurves {
+		if curve.String() == name {
+			return curve, nil
+		}
+	}
+	return ecc.UNKNOWN, fmt.Errorf("unsupported curve: %s", name)
+}
+
+func validateCurve(curve ecc.ID) error {
+	for _, supported := range supportedCurves {
+		if curve == supported {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported curve: %d", curve)
+}
+
+// ValidateFieldElements checks that the roots, identity commitments and
+// Merkle proof nodes are canonical elements of the given scalar field. The
+// input hash is exempt, as the circuit reduces it modulo the field.
+func (p *Parameters) ValidateFieldElements(field *big.Int) error {
+	return p.validateFieldElements(field, 1)
+}
+
+// validateFieldElements checks the Merkle proofs with up to workers
+// goroutines, reporting the first invalid node in order.
+func (p *Parameters) validateFieldElements(field *big.Int, workers int) error {
+	check := func(name string, v *big.Int) error {
+		if v.Sign() < 0 || v.Cmp(field) >= 0 {
+			return &FieldElementError{Name: name, Value: *v}
+		}
+		return nil
+	}
+	if err := check("pre root", &p.PreRoot); err != nil {
+		return err
+	}
+	if err := check("post root", &p.PostRoot); err != nil {
+		return err
+	}
+	if err := check("empty leaf", &p.EmptyLeaf); err != nil {
+		return err
+	}
+	for i := range p.IdComms {
+		if err := check(fmt.Sprintf("identity commitment %d", i), &p.IdComms[i]); err != nil {
+			return err
+		}
+	}
+	errs := make([]error, len(p.MerkleProofs))
+	depth := 1
+	if len(p.MerkleProofs) > 0 && len(p.MerkleProofs[0]) > 0 {
+		depth = len(p.MerkleProofs[0])
+	}
+	parallelize(len(p.MerkleProofs), workers, minParallelWork/depth, func(start, end int) {
+		for i := start; i < end; i++ {
+			for j := range p.MerkleProofs[i] {
+				if errs[i] = check(fmt.Sprintf("merkle proof %d node %d", i, j), &p.MerkleProofs[i][j]); errs[i] != nil {
+					break
+				}
+			}
+		}
+	})
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
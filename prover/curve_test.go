@@ -0,0 +1,78 @@
+package prover
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+)
+
+func TestParseCurve(t *testing.T) {
+	for _, curve := range supportedCurves {
+		parsed, err := ParseCurve(curve.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parsed != curve {
+			t.Fatalf("expected %s, got %s", curve, parsed)
+		}
+	}
+	if _, err := ParseCurve("secp256k1"); err == nil {
+		t.Fatal("expected curves without groth16 support to be rejected")
+	}
+}
+
+func TestValidateFieldElements(t *testing.T) {
+	params := testParameters()
+	if err := params.ValidateFieldElements(ecc.BN254.ScalarField()); err != nil {
+		t.Fatal(err)
+	}
+
+	// BLS24-315 has a smaller scalar field than BN254, so BN254 roots may not fit.
+	params.PostRoot.Set(ecc.BN254.ScalarField())
+	params.PostRoot.Sub(&params.PostRoot, big.NewInt(1))
+	if err := params.ValidateFieldElements(ecc.BLS24_315.ScalarField()); err == nil {
+		t.Fatal("expected a value beyond the BLS24-315 scalar field to be rejected")
+	}
+
+	params = testParameters()
+	params.MerkleProofs[1][2].Set(ecc.BN254.ScalarField())
+	if err := params.ValidateFieldElements(ecc.BN254.ScalarField()); err == nil {
+		t.Fatal("expected the field modulus to be rejected")
+	}
+}
+
+func TestProveOnBLS12_381(t *testing.T) {
+	ps, err := Setup(context.Background(), testTreeDepth, 1, WithCurve(ecc.BLS12_381), WithCommitment(CommitmentSHA256))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := ps.NewTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	params, err := ParametersFromTree(tree, 2, []big.Int{*big.NewInt(7)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The roots are computed with the tree hash of the BLS12-381 field.
+	if err = ps.checkRoots(params); err != nil {
+		t.Fatal(err)
+	}
+	proof, err := ps.Prove(context.Background(), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ps.Verify(params.InputHash, proof); err != nil {
+		t.Fatal(err)
+	}
+	if proof.Proof.CurveID() != ecc.BLS12_381 {
+		t.Fatalf("expected a BLS12-381 proof, got %s", proof.Proof.CurveID())
+	}
+
+	params.PostRoot.Add(&params.PostRoot, big.NewInt(1))
+	if _, err = ps.Prove(context.Background(), params); err == nil {
+		t.Fatal("expected a wrong post root to be rejected")
+	}
+}
@@ -0,0 +1,297 @@
+package prover
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// InputHashMismatchError is returned when the input hash supplied with the
+// parameters differs from the one computed from them. Proving with it would
+// produce a proof that does not verify against the supplied hash.
+type InputHashMismatchError struct {
+	Supplied big.Int
+	Computed big.Int
+}
+
+func (e *InputHashMismatchError) Error() string {
+	return fmt.Sprintf("input hash mismatch: supplied %s, computed %s", toHex32(&e.Supplied), toHex32(&e.Computed))
+}
+
+// ParameterError is returned when decoding parameters fails, naming the
+// offending field and, for arrays, its index.
+type ParameterError struct {
+	Field  string
+	Value  string
+	Reason string
+}
+
+func (e *ParameterError) Error() string {
+	if e.Value != "" {
+		return fmt.Sprintf("invalid %s %q: %s", e.Field, e.Value, e.Reason)
+	}
+	return fmt.Sprintf("invalid %s: %s", e.Field, e.Reason)
+}
+
+// BatchSizeError is returned when the number of identity commitments or
+// Merkle proofs does not match the batch size of the circuit.
+type BatchSizeError struct {
+	Field    string
+	Expected int
+	Actual   int
+}
+
+func (e *BatchSizeError) Error() string {
+	return fmt.Sprintf("wrong number of %s: %d, expected %d", e.Field, e.Actual, e.Expected)
+}
+
+// TreeDepthError is returned when all Merkle proofs have the same length but
+// it does not match the tree depth of the circuit.
+type TreeDepthError struct {
+	Expected int
+	Actual   int
+}
+
+func (e *TreeDepthError) Error() string {
+	return fmt.Sprintf("wrong tree depth: merkle proofs have %d nodes, expected %d", e.Actual, e.Expected)
+}
+
+// CircuitNotLoadedError is returned when parameters select with TreeDepth a
+// circuit other than the one proving them, no circuit of their tree depth
+// and batch size being loaded.
+type CircuitNotLoadedError struct {
+	TreeDepth       uint32
+	BatchSize       uint32
+	LoadedTreeDepth uint32
+	LoadedBatchSize uint32
+}
+
+func (e *CircuitNotLoadedError) Error() string {
+	return fmt.Sprintf("no circuit is loaded for tree depth %d and batch size %d, the keys proving the request are for tree depth %d and batch size %d",
+		e.TreeDepth, e.BatchSize, e.LoadedTreeDepth, e.LoadedBatchSize)
+}
+
+// ProofLengthError is returned when a Merkle proof differs in length from the
+// tree depth while other proofs in the batch have the right length.
+type ProofLengthError struct {
+	Proof    int
+	Expected int
+	Actual   int
+}
+
+func (e *ProofLengthError) Error() string {
+	return fmt.Sprintf("wrong size of merkle proof for proof %d: %d, expected %d", e.Proof, e.Actual, e.Expected)
+}
+
+// StartIndexError is returned when the insertions of a batch do not fit in
+// the tree.
+type StartIndexError struct {
+	StartIndex uint32
+	BatchSize  int
+	TreeDepth  uint32
+}
+
+func (e *StartIndexError) Error() string {
+	return fmt.Sprintf("start index %d out of range: %d insertions do not fit in a tree of depth %d", e.StartIndex, e.BatchSize, e.TreeDepth)
+}
+
+// StartIndexAlignmentError is returned when StartIndex is not a multiple of
+// the alignment required by the sequencer.
+type StartIndexAlignmentError struct {
+	StartIndex uint32
+	Alignment  uint32
+}
+
+func (e *StartIndexAlignmentError) Error() string {
+	return fmt.Sprintf("start index %d is not a multiple of %d", e.StartIndex, e.Alignment)
+}
+
+// IndexError is returned when the index of an insertion does not fit in the
+// tree.
+type IndexError struct {
+	Proof     int
+	Index     uint32
+	TreeDepth uint32
+}
+
+func (e *IndexError) Error() string {
+	return fmt.Sprintf("index %d of insertion %d out of range: it does not fit in a tree of depth %d", e.Index, e.Proof, e.TreeDepth)
+}
+
+// IndicesError is returned when parameters give indices to a circuit
+// inserting at consecutive indices, or none to one set up WithIndices.
+type IndicesError struct {
+	Indexed bool
+}
+
+func (e *IndicesError) Error() string {
+	if e.Indexed {
+		return "missing indices: the circuit inserts each identity commitment at its own index"
+	}
+	return "unexpected indices: the circuit inserts at consecutive indices from startIndex"
+}
+
+// RootMismatchError is returned when the roots recomputed from the Merkle
+// proofs do not chain from PreRoot to PostRoot. Proof is the index of the
+// proof that does not open the expected root, or -1 if the final root
+// differs from PostRoot. OpensPreRoot is set when a later proof opens
+// PreRoot instead, i.e. it ignores the earlier insertions of the batch.
+type RootMismatchError struct {
+	Proof        int
+	Index        uint64
+	Expected     big.Int
+	Computed     big.Int
+	OpensPreRoot bool
+}
+
+func (e *RootMismatchError) Error() string {
+	switch {
+	case e.Proof < 0:
+		return fmt.Sprintf("post root mismatch: expected %s, computed %s", toHex32(&e.Expected), toHex32(&e.Computed))
+	case e.Proof == 0:
+		return fmt.Sprintf("merkle proof 0 does not open the pre root %s with an empty leaf at index %d, computed %s", toHex32(&e.Expected), e.Index, toHex32(&e.Computed))
+	case e.OpensPreRoot:
+		return fmt.Sprintf("merkle proof %d opens the pre root at index %d instead of the root %s left by proof %d, it must include the earlier insertions of the batch", e.Proof, e.Index, toHex32(&e.Expected), e.Proof-1)
+	}
+	return fmt.Sprintf("merkle proof %d does not open the root %s left by proof %d with an empty leaf at index %d, computed %s", e.Proof, toHex32(&e.Expected), e.Proof-1, e.Index, toHex32(&e.Computed))
+}
+
+// ZeroIdCommError is returned when an identity commitment is zero, which
+// would leave its slot indistinguishable from an empty one.
+type ZeroIdCommError struct {
+	Index int
+}
+
+func (e *ZeroIdCommError) Error() string {
+	return fmt.Sprintf("identity commitment %d is zero", e.Index)
+}
+
+// FieldElementError is returned when a value is not an element of the
+// scalar field of the circuit.
+type FieldElementError struct {
+	Name  string
+	Value big.Int
+}
+
+func (e *FieldElementError) Error() string {
+	return fmt.Sprintf("%s is not an element of the scalar field: %s", e.Name, toHex32(&e.Value))
+}
+
+// EmptyLeafError is returned when the parameters assume a different empty
+// leaf than the one the circuit was compiled with.
+type EmptyLeafError struct {
+	Expected big.Int
+	Actual   big.Int
+}
+
+func (e *EmptyLeafError) Error() string {
+	return fmt.Sprintf("wrong empty leaf: %s, the circuit uses %s", toHex32(&e.Actual), toHex32(&e.Expected))
+}
+
+// WitnessError is returned when the witness cannot be built or does not
+// satisfy the circuit.
+type WitnessError struct {
+	Err error
+}
+
+func (e *WitnessError) Error() string {
+	return fmt.Sprintf("witness error: %s", e.Err)
+}
+
+func (e *WitnessError) Unwrap() error {
+	return e.Err
+}
+
+// CorruptKeysError is returned when a key file is truncated or does not match
+// its checksum.
+type CorruptKeysError struct {
+	Reason string
+}
+
+func (e *CorruptKeysError) Error() string {
+	return fmt.Sprintf("corrupt keys file: %s", e.Reason)
+}
+
+// StaleKeysError is returned when a key file was set up for a different
+// revision of the circuit or gnark version than the prover uses.
+type StaleKeysError struct {
+	Field    string
+	Expected string
+	Actual   string
+}
+
+func (e *StaleKeysError) Error() string {
+	return fmt.Sprintf("stale keys file: set up for %s %s, the prover uses %s; set up new keys", e.Field, e.Actual, e.Expected)
+}
+
+// AggregationError is returned when one of the proofs to aggregate does not
+// verify against its public inputs.
+type AggregationError struct {
+	Proof int
+	Err   error
+}
+
+func (e *AggregationError) Error() string {
+	return fmt.Sprintf("proof %d does not verify: %s", e.Proof, e.Err)
+}
+
+func (e *AggregationError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorCode returns the code the server reports err with, falling back to
+// proving_error for errors that are not typed errors of the prover. The codes
+// are listed in the README.
+func ErrorCode(err error) string {
+	var (
+		inputHash   *InputHashMismatchError
+		aggregation *AggregationError
+		batchSize   *BatchSizeError
+		treeDepth   *TreeDepthError
+		notLoaded   *CircuitNotLoadedError
+		length      *ProofLengthError
+		root        *RootMismatchError
+		startIndex  *StartIndexError
+		alignment   *StartIndexAlignmentError
+		index       *IndexError
+		indices     *IndicesError
+		field       *FieldElementError
+		zeroIdComm  *ZeroIdCommError
+		emptyLeaf   *EmptyLeafError
+		witness     *WitnessError
+	)
+	code := "proving_error"
+	switch {
+	case errors.As(err, &inputHash):
+		code = "input_hash_mismatch"
+	case errors.As(err, &aggregation):
+		code = "invalid_proof"
+	case errors.As(err, &batchSize):
+		code = "wrong_batch_size"
+	case errors.As(err, &treeDepth):
+		code = "wrong_tree_depth"
+	case errors.As(err, &notLoaded):
+		code = "circuit_not_loaded"
+	case errors.As(err, &length):
+		code = "proof_length_mismatch"
+	case errors.As(err, &root):
+		code = "root_mismatch"
+	case errors.As(err, &startIndex):
+		code = "start_index_out_of_range"
+	case errors.As(err, &alignment):
+		code = "start_index_misaligned"
+	case errors.As(err, &index):
+		code = "index_out_of_range"
+	case errors.As(err, &indices):
+		code = "wrong_indices"
+	case errors.As(err, &field):
+		code = "invalid_field_element"
+	case errors.As(err, &zeroIdComm):
+		code = "zero_identity_commitment"
+	case errors.As(err, &emptyLeaf):
+		code = "wrong_empty_leaf"
+	case errors.As(err, &witness):
+		code = "witness_error"
+	}
+	return code
+}
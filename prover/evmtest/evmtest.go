@@ -0,0 +1,160 @@
+// Package evmtest deploys Solidity contracts into a simulated go-ethereum
+// chain, so that tests can check the exported verifiers accept the proofs of
+// the prover on-chain. Contracts are compiled with the solc on the PATH;
+// tests are skipped without it.
+package evmtest
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// blockGasLimit is the gas limit of the simulated blocks, well above what a
+// pairing check costs.
+const blockGasLimit = 30_000_000
+
+// Contract is a compiled contract.
+type Contract struct {
+	ABI abi.ABI
+	Bin []byte
+}
+
+// SkipWithoutSolc skips the test if solc is not installed, before it spends
+// time setting up contracts it cannot compile.
+func SkipWithoutSolc(t testing.TB) string {
+	solc, err := exec.LookPath("solc")
+	if err != nil {
+		t.Skip("solc is not installed")
+	}
+	return solc
+}
+
+// Compile compiles source with solc and returns its contracts by name. It
+// skips the test if solc is not installed. The bytecode targets London, the
+// last fork before PUSH0 that the simulated chain runs.
+func Compile(t testing.TB, source string) map[string]*Contract {
+	solc := SkipWithoutSolc(t)
+	path := filepath.Join(t.TempDir(), "Contracts.sol")
+	if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command(solc, "--evm-version", "london", "--optimize", "--combined-json", "abi,bin", path).Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			t.Fatalf("solc failed: %s", exitErr.Stderr)
+		}
+		t.Fatal(err)
+	}
+	var compiled struct {
+		Contracts map[string]struct {
+			// ABI is a JSON string for solc before 0.8.10.
+			ABI json.RawMessage `json:"abi"`
+			Bin string          `json:"bin"`
+		} `json:"contracts"`
+	}
+	if err = json.Unmarshal(out, &compiled); err != nil {
+		t.Fatal(err)
+	}
+	contracts := make(map[string]*Contract)
+	for name, contract := range compiled.Contracts {
+		abiJSON := string(contract.ABI)
+		if unquoted, err := strconv.Unquote(abiJSON); err == nil {
+			abiJSON = unquoted
+		}
+		parsed, err := abi.JSON(strings.NewReader(abiJSON))
+		if err != nil {
+			t.Fatal(err)
+		}
+		contracts[name[strings.LastIndex(name, ":")+1:]] = &Contract{ABI: parsed, Bin: common.FromHex(contract.Bin)}
+	}
+	return contracts
+}
+
+// Chain is a simulated chain with a funded account sending the transactions.
+type Chain struct {
+	Backend *backends.SimulatedBackend
+	Auth    *bind.TransactOpts
+}
+
+// NewChain starts a simulated chain, closed when the test ends.
+func NewChain(t testing.TB) *Chain {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := crypto.PubkeyToAddress(key.PublicKey)
+	balance := new(big.Int).Lsh(big.NewInt(1), 100)
+	backend := backends.NewSimulatedBackend(core.GenesisAlloc{address: {Balance: balance}}, blockGasLimit)
+	t.Cleanup(func() { backend.Close() })
+	auth, err := bind.NewKeyedTransactorWithChainID(key, backend.Blockchain().Config().ChainID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Chain{Backend: backend, Auth: auth}
+}
+
+// Deployed is a contract deployed on a Chain.
+type Deployed struct {
+	*bind.BoundContract
+	Address common.Address
+	chain   *Chain
+}
+
+// Deploy deploys contract with the constructor arguments and mines it.
+func (c *Chain) Deploy(t testing.TB, contract *Contract, args ...interface{}) *Deployed {
+	address, tx, bound, err := bind.DeployContract(c.Auth, contract.ABI, contract.Bin, c.Backend, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Backend.Commit()
+	if receipt := c.receipt(t, tx); receipt.Status != types.ReceiptStatusSuccessful {
+		t.Fatal("deployment reverted")
+	}
+	return &Deployed{BoundContract: bound, Address: address, chain: c}
+}
+
+func (c *Chain) receipt(t testing.TB, tx *types.Transaction) *types.Receipt {
+	receipt, err := c.Backend.TransactionReceipt(context.Background(), tx.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return receipt
+}
+
+// Call calls a view method and returns its outputs.
+func (d *Deployed) Call(t testing.TB, method string, args ...interface{}) []interface{} {
+	var out []interface{}
+	if err := d.BoundContract.Call(&bind.CallOpts{}, &out, method, args...); err != nil {
+		t.Fatalf("%s failed: %v", method, err)
+	}
+	return out
+}
+
+// Transact sends a transaction calling method, mines it and returns its
+// receipt, whose status tells whether it was accepted. The gas is not
+// estimated, so that reverting transactions are mined too.
+func (d *Deployed) Transact(t testing.TB, method string, args ...interface{}) *types.Receipt {
+	opts := *d.chain.Auth
+	opts.GasLimit = blockGasLimit
+	tx, err := d.BoundContract.Transact(&opts, method, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.chain.Backend.Commit()
+	return d.chain.receipt(t, tx)
+}
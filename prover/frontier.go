@@ -0,0 +1,116 @@
+package prover
+
+import "math/big"
+
+// checkFrontier reports a frontier given with Merkle proofs or indices, which
+// it would contradict: a frontier stands for the proofs of consecutive
+// insertions.
+func checkFrontier(merkleProofs int, indices int) error {
+	if merkleProofs != 0 {
+		return &ParameterError{Field: "frontier", Reason: "cannot be given with merkleProofs"}
+	}
+	if indices != 0 {
+		return &ParameterError{Field: "frontier", Reason: "cannot be given with indices, it only describes consecutive insertions"}
+	}
+	return nil
+}
+
+// emptyNodes returns the roots of the empty subtrees of every height below
+// depth, from the empty leaf up.
+func emptyNodes(hash func(left, right *big.Int) (*big.Int, error), emptyLeaf *big.Int, depth int) ([]big.Int, error) {
+	empty := make([]big.Int, depth)
+	if depth == 0 {
+		return empty, nil
+	}
+	empty[0].Set(emptyLeaf)
+	for level := 1; level < depth; level++ {
+		node, err := hash(&empty[level-1], &empty[level-1])
+		if err != nil {
+			return nil, err
+		}
+		empty[level].Set(node)
+	}
+	return empty, nil
+}
+
+// nextProof derives the Merkle proof of the slot at index + 1 once leaf is
+// inserted at index, whose proof is siblings, assuming that the slots after
+// it are empty. The sibling of a node to its right is an empty subtree; the
+// sibling to its left is either the node of the insertion at that level or
+// one of its siblings.
+func nextProof(hash func(left, right *big.Int) (*big.Int, error), empty []big.Int, leaf *big.Int, index uint64, siblings []big.Int) ([]big.Int, error) {
+	depth := len(siblings)
+	// path holds the nodes of the insertion from its leaf up.
+	path := make([]big.Int, depth)
+	if depth != 0 {
+		path[0].Set(leaf)
+	}
+	for level := 1; level < depth; level++ {
+		left, right := &path[level-1], &siblings[level-1]
+		if (index>>(level-1))&1 == 1 {
+			left, right = right, left
+		}
+		node, err := hash(left, right)
+		if err != nil {
+			return nil, err
+		}
+		path[level].Set(node)
+	}
+	next := index + 1
+	proof := make([]big.Int, depth)
+	for level := range proof {
+		switch {
+		case (next>>level)&1 == 0:
+			proof[level].Set(&empty[level])
+		case (next>>level)-1 == index>>level:
+			proof[level].Set(&path[level])
+		default:
+			proof[level].Set(&siblings[level])
+		}
+	}
+	return proof, nil
+}
+
+// ExpandFrontier derives the Merkle proofs of params given in the compact
+// form, as the Frontier of the tree before the batch, and clears the
+// frontier. The proof of each insertion after the first is derived from the
+// previous one, as the tree is filled in order from StartIndex and is empty
+// after it. Parameters with Merkle proofs are left as they are. The derived
+// proofs are checked against the roots like any others.
+func (ps *ProvingSystem) ExpandFrontier(params *Parameters) error {
+	if params.Frontier == nil {
+		return nil
+	}
+	if err := checkFrontier(len(params.MerkleProofs), len(params.Indices)); err != nil {
+		return err
+	}
+	if ps.Indexed {
+		return &IndicesError{Indexed: true}
+	}
+	if len(params.Frontier) != int(ps.TreeDepth) {
+		return &TreeDepthError{Expected: int(ps.TreeDepth), Actual: len(params.Frontier)}
+	}
+	if err := params.ValidateCapacity(ps.TreeDepth); err != nil {
+		return err
+	}
+	hash, err := NativeTreeHash(ps.TreeHash, ps.Curve)
+	if err != nil {
+		return err
+	}
+	empty, err := emptyNodes(hash, &params.EmptyLeaf, len(params.Frontier))
+	if err != nil {
+		return err
+	}
+	proofs := make([][]big.Int, len(params.IdComms))
+	if len(proofs) != 0 {
+		proofs[0] = params.Frontier
+	}
+	for i := 1; i < len(proofs); i++ {
+		if proofs[i], err = nextProof(hash, empty, &params.IdComms[i-1], params.index(i-1), proofs[i-1]); err != nil {
+			return err
+		}
+	}
+	params.MerkleProofs = proofs
+	params.Frontier = nil
+	return nil
+}
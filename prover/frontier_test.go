@@ -0,0 +1,105 @@
+package prover
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+)
+
+// offsetParameters returns the insertion of n identity commitments at start
+// into a tree of depth testTreeDepth whose slots before start are filled.
+func offsetParameters(start, n int) *Parameters {
+	params := sequentialParameters(start + n)
+	params.StartIndex = uint32(start)
+	if start != 0 {
+		preRoot, err := nativeRoot(func(left, right *big.Int) (*big.Int, error) {
+			node := poseidonHash(*left, *right)
+			return &node, nil
+		}, &params.IdComms[start-1], uint64(start-1), params.MerkleProofs[start-1])
+		if err != nil {
+			panic(err)
+		}
+		params.PreRoot.Set(preRoot)
+	}
+	params.IdComms = params.IdComms[start:]
+	params.MerkleProofs = params.MerkleProofs[start:]
+	return params
+}
+
+func TestExpandFrontier(t *testing.T) {
+	ps := &ProvingSystem{Curve: ecc.BN254, TreeDepth: testTreeDepth}
+	for start := 0; start < 1<<testTreeDepth; start++ {
+		for n := 1; start+n <= 1<<testTreeDepth; n++ {
+			full := offsetParameters(start, n)
+			compact := offsetParameters(start, n)
+			compact.Frontier, compact.MerkleProofs = compact.MerkleProofs[0], nil
+			if err := ps.ExpandFrontier(compact); err != nil {
+				t.Fatalf("%d insertions at %d: %v", n, start, err)
+			}
+			if compact.Frontier != nil {
+				t.Fatal("expected the frontier to be cleared")
+			}
+			for i := range full.MerkleProofs {
+				for level := range full.MerkleProofs[i] {
+					if compact.MerkleProofs[i][level].Cmp(&full.MerkleProofs[i][level]) != 0 {
+						t.Fatalf("%d insertions at %d: proof %d differs at level %d", n, start, i, level)
+					}
+				}
+			}
+			if err := compact.Verify(testTreeDepth, uint32(n)); err != nil {
+				t.Fatalf("%d insertions at %d: %v", n, start, err)
+			}
+		}
+	}
+
+	// A frontier that is not the proof of the start index fails like a
+	// wrong Merkle proof.
+	params := offsetParameters(2, 3)
+	params.Frontier, params.MerkleProofs = params.MerkleProofs[1], nil
+	var rootErr *RootMismatchError
+	if err := params.Verify(testTreeDepth, 3); !errors.As(err, &rootErr) {
+		t.Fatalf("expected a root mismatch, got %v", err)
+	}
+	params = offsetParameters(0, 1)
+	params.Frontier, params.MerkleProofs = params.MerkleProofs[0][1:], nil
+	var depthErr *TreeDepthError
+	if err := ps.ExpandFrontier(params); !errors.As(err, &depthErr) {
+		t.Fatalf("expected a tree depth error, got %v", err)
+	}
+}
+
+func TestFrontierJSON(t *testing.T) {
+	params := offsetParameters(3, 2)
+	params.Frontier, params.MerkleProofs = params.MerkleProofs[0], nil
+	data, err := json.Marshal(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Parameters
+	if err = json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Digest() != params.Digest() {
+		t.Fatalf("expected the frontier to round trip, got %s", data)
+	}
+	encoded, err := params.MarshalCBOR()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = decoded.UnmarshalCBOR(encoded); err != nil || decoded.Digest() != params.Digest() {
+		t.Fatalf("expected the frontier to round trip in CBOR, got %v", err)
+	}
+
+	for _, body := range []string{
+		`{"preRoot":"0x0","postRoot":"0x0","identityCommitments":["0x1"],"merkleProofs":[["0x0"]],"frontier":["0x0"]}`,
+		`{"preRoot":"0x0","postRoot":"0x0","identityCommitments":["0x1"],"indices":[3],"frontier":["0x0"]}`,
+	} {
+		var paramErr *ParameterError
+		if err = json.Unmarshal([]byte(body), &decoded); !errors.As(err, &paramErr) || paramErr.Field != "frontier" {
+			t.Fatalf("expected the frontier to be rejected, got %v", err)
+		}
+	}
+}
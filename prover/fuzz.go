@@ -0,0 +1,191 @@
+package prover
+
+import (
+	"fmt"
+	"math/big"
+	"math/rand"
+
+	"github.com/consensys/gnark/constraint"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+)
+
+// inputHashCircuit is the input hash gadget of MbuCircuit alone, so that it
+// can be solved for parameters whose Merkle proofs are not valid.
+type inputHashCircuit struct {
+	MbuCircuit
+}
+
+func (circuit *inputHashCircuit) Define(api frontend.API) error {
+	sum, err := circuit.inputHash(api)
+	if err != nil {
+		return err
+	}
+	api.AssertIsEqual(circuit.InputHash, sum)
+	return nil
+}
+
+// InputHashDivergenceError is returned when the circuit rejects the input
+// hash ComputeInputHashWith computed for parameters, i.e. when the native
+// and in-circuit hashes disagree.
+type InputHashDivergenceError struct {
+	Commitment Commitment
+	Params     *Parameters
+	Err        error
+}
+
+func (e *InputHashDivergenceError) Error() string {
+	return fmt.Sprintf("the %s input hash %s computed natively does not solve the circuit: %v", e.Commitment, toHex32(&e.Params.InputHash), e.Err)
+}
+
+func (e *InputHashDivergenceError) Unwrap() error {
+	return e.Err
+}
+
+// InputHashFuzzer checks that the input hash computed natively by
+// ComputeInputHashWith is the one the circuit computes, for random
+// parameters, so that byte-ordering and padding divergences between the two
+// are caught before a sequencer runs into them. The circuit is compiled
+// once, with the input hash gadget only.
+type InputHashFuzzer struct {
+	batchSize int
+	options   circuitOptions
+	cs        constraint.ConstraintSystem
+}
+
+// NewInputHashFuzzer compiles the input hash of batches of batchSize with
+// the commitment, empty leaf, indices, Keccak gadget and curve of opts.
+func NewInputHashFuzzer(batchSize int, opts ...CircuitOption) (*InputHashFuzzer, error) {
+	options := newCircuitOptions(opts)
+	if err := validateCurve(options.curve); err != nil {
+		return nil, err
+	}
+	if err := validateCommitment(options.commitment, options.curve); err != nil {
+		return nil, err
+	}
+	if err := validateLookupKeccak(options); err != nil {
+		return nil, err
+	}
+	circuit := &inputHashCircuit{MbuCircuit{
+		BatchSize:    batchSize,
+		IdComms:      make([]frontend.Variable, batchSize),
+		EmptyLeaf:    &options.emptyLeaf,
+		Commitment:   options.commitment,
+		LookupKeccak: options.lookupKeccak,
+	}}
+	if options.indexed {
+		circuit.Indices = make([]frontend.Variable, batchSize)
+	}
+	cs, err := frontend.Compile(options.curve.ScalarField(), r1cs.NewBuilder, circuit)
+	if err != nil {
+		return nil, err
+	}
+	return &InputHashFuzzer{batchSize: batchSize, options: options, cs: cs}, nil
+}
+
+// fuzzEdgeValues are the field elements most likely to expose padding and
+// byte-ordering mistakes: zero, one, values whose leading or trailing bytes
+// are zero, and the largest field element.
+func fuzzEdgeValues(field *big.Int) []*big.Int {
+	one := big.NewInt(1)
+	return []*big.Int{
+		big.NewInt(0),
+		one,
+		big.NewInt(0xff),
+		new(big.Int).Lsh(one, 8),
+		new(big.Int).Lsh(one, 248),
+		new(big.Int).Sub(new(big.Int).Lsh(one, 248), one),
+		new(big.Int).Lsh(big.NewInt(0xff), 240),
+		new(big.Int).Sub(field, one),
+	}
+}
+
+// fuzzUint32Values are the edge values of start indices and indices.
+var fuzzUint32Values = []uint32{0, 1, 0xff, 0x100, 0xffff, 1 << 31, 1<<32 - 1}
+
+// RandomParameters returns parameters for the batch size of the fuzzer
+// drawn from rng, whose field elements are uniform or, one time in four,
+// edge values. The Merkle proofs are left empty and the input hash is not
+// computed.
+func (f *InputHashFuzzer) RandomParameters(rng *rand.Rand) *Parameters {
+	field := f.options.curve.ScalarField()
+	edges := fuzzEdgeValues(field)
+	element := func(i *big.Int) {
+		if rng.Intn(4) == 0 {
+			i.Set(edges[rng.Intn(len(edges))])
+		} else {
+			i.Rand(rng, field)
+		}
+	}
+	index := func() uint32 {
+		if rng.Intn(4) == 0 {
+			return fuzzUint32Values[rng.Intn(len(fuzzUint32Values))]
+		}
+		return rng.Uint32()
+	}
+	params := &Parameters{StartIndex: index(), IdComms: make([]big.Int, f.batchSize)}
+	element(&params.PreRoot)
+	element(&params.PostRoot)
+	for i := range params.IdComms {
+		element(&params.IdComms[i])
+	}
+	params.EmptyLeaf.Set(&f.options.emptyLeaf)
+	if f.options.indexed {
+		params.Indices = make([]uint32, f.batchSize)
+		for i := range params.Indices {
+			params.Indices[i] = index()
+		}
+	}
+	return params
+}
+
+// Check computes the input hash of params natively, replacing the supplied
+// one, and checks that it solves the input hash gadget of the circuit,
+// returning an InputHashDivergenceError if it does not.
+func (f *InputHashFuzzer) Check(params *Parameters) error {
+	if len(params.IdComms) != f.batchSize || (len(params.Indices) != 0) != f.options.indexed {
+		return fmt.Errorf("the parameters do not fit a batch of %d", f.batchSize)
+	}
+	if params.EmptyLeaf.Cmp(&f.options.emptyLeaf) != 0 {
+		return fmt.Errorf("the empty leaf %s is not the one the fuzzer is compiled with", toHex32(&params.EmptyLeaf))
+	}
+	if err := params.ComputeInputHashWith(f.options.commitment); err != nil {
+		return err
+	}
+	assignment := &inputHashCircuit{MbuCircuit{
+		InputHash:  params.InputHash,
+		StartIndex: params.StartIndex,
+		PreRoot:    params.PreRoot,
+		PostRoot:   params.PostRoot,
+		IdComms:    make([]frontend.Variable, f.batchSize),
+	}}
+	for i := range params.IdComms {
+		assignment.IdComms[i] = params.IdComms[i]
+	}
+	if f.options.indexed {
+		assignment.Indices = make([]frontend.Variable, f.batchSize)
+		for i, index := range params.Indices {
+			assignment.Indices[i] = index
+		}
+	}
+	witness, err := frontend.NewWitness(assignment, f.options.curve.ScalarField())
+	if err != nil {
+		return err
+	}
+	if err = f.cs.IsSolved(witness, solveCommitment(f.cs)...); err != nil {
+		return &InputHashDivergenceError{Commitment: f.options.commitment, Params: params, Err: err}
+	}
+	return nil
+}
+
+// Run checks the input hash of iterations random parameters drawn from a
+// generator seeded with seed, stopping at the first divergence.
+func (f *InputHashFuzzer) Run(seed int64, iterations int) error {
+	rng := rand.New(rand.NewSource(seed))
+	for i := 0; i < iterations; i++ {
+		if err := f.Check(f.RandomParameters(rng)); err != nil {
+			return fmt.Errorf("iteration %d of seed %d: %w", i, seed, err)
+		}
+	}
+	return nil
+}
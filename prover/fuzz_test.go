@@ -0,0 +1,68 @@
+package prover
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+)
+
+func TestInputHashFuzzer(t *testing.T) {
+	for _, test := range []struct {
+		commitment Commitment
+		opts       []CircuitOption
+	}{
+		{CommitmentKeccak, nil},
+		{CommitmentKeccak, []CircuitOption{WithIndices(), WithEmptyLeaf(*big.NewInt(7))}},
+		{CommitmentSHA256, []CircuitOption{WithIndices()}},
+		{CommitmentPoseidon, []CircuitOption{WithIndices(), WithEmptyLeaf(*big.NewInt(7))}},
+		{CommitmentKeccak, []CircuitOption{WithLookupKeccak()}},
+		{CommitmentKeccakChained, []CircuitOption{WithLookupKeccak(), WithIndices()}},
+	} {
+		fuzzer, err := NewInputHashFuzzer(2, append(test.opts, WithCommitment(test.commitment))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = fuzzer.Run(1, 20); err != nil {
+			t.Fatalf("%s: %v", test.commitment, err)
+		}
+	}
+}
+
+func TestInputHashFuzzerDetectsDivergence(t *testing.T) {
+	fuzzer, err := NewInputHashFuzzer(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Hash natively with SHA-256 what the circuit hashes with Keccak.
+	fuzzer.options.commitment = CommitmentSHA256
+	var divergence *InputHashDivergenceError
+	if err = fuzzer.Run(1, 1); !errors.As(err, &divergence) {
+		t.Fatalf("expected a divergence, got %v", err)
+	}
+}
+
+// FuzzInputHash checks the Keccak input hash of batches of one identity
+// commitment, reducing the fuzzed bytes modulo the scalar field. Run it with
+// go test ./prover -run '^$' -fuzz FuzzInputHash.
+func FuzzInputHash(f *testing.F) {
+	field := ecc.BN254.ScalarField()
+	for _, value := range fuzzEdgeValues(field) {
+		f.Add(uint32(0), value.Bytes(), value.Bytes(), value.Bytes())
+	}
+	f.Add(uint32(1<<32-1), []byte{0x01}, []byte{0x01, 0x00}, []byte{0xff})
+	fuzzer, err := NewInputHashFuzzer(1)
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Fuzz(func(t *testing.T, startIndex uint32, preRoot, postRoot, idComm []byte) {
+		params := &Parameters{StartIndex: startIndex, IdComms: make([]big.Int, 1)}
+		params.PreRoot.Mod(new(big.Int).SetBytes(preRoot), field)
+		params.PostRoot.Mod(new(big.Int).SetBytes(postRoot), field)
+		params.IdComms[0].Mod(new(big.Int).SetBytes(idComm), field)
+		if err := fuzzer.Check(params); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
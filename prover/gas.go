@@ -0,0 +1,195 @@
+package prover
+
+import (
+	"bytes"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend/groth16"
+)
+
+// Gas costs of the EVM since Istanbul, see EIP-1108 for the BN254
+// precompiles and EIP-2028 for calldata.
+const (
+	txGas               = 21000
+	calldataZeroGas     = 4
+	calldataNonZeroGas  = 16
+	pairingBaseGas      = 45000
+	pairingPerPairGas   = 34000
+	ecMulGas            = 6000
+	ecAddGas            = 150
+	keccakGas           = 30
+	keccakWordGas       = 6
+	sha256Gas           = 60
+	sha256WordGas       = 12
+	memoryWordGas       = 3
+	memoryQuadraticCoef = 512
+	// groth16Pairings is the number of pairings checked by a Groth16
+	// verifier.
+	groth16Pairings = 4
+)
+
+// GasReport estimates what verifying a batch costs on the EVM, to compare
+// batch sizes and commitments. Gas is only estimated for BN254, the curve
+// with EVM precompiles; it counts the transaction, its calldata, the
+// precompiles and the input hash, including the memory it is computed in,
+// but not the rest of the execution of the contracts.
+type GasReport struct {
+	// ProofSize is the size of an uncompressed proof in bytes.
+	ProofSize int `json:"proofSize"`
+	// CompressedProofSize is the size of a compressed proof in bytes.
+	CompressedProofSize int `json:"compressedProofSize"`
+	PublicInputs        int `json:"publicInputs"`
+	Pairings            int `json:"pairings"`
+	// CalldataSize is the size of the call verifying a batch in bytes:
+	// BatchVerifier.verifyBatch, or Verifier.verifyProof for the Poseidon
+	// commitment, whose input hash is not computed on-chain.
+	CalldataSize int `json:"calldataSize"`
+	// CalldataGas assumes the field elements have no zero bytes.
+	CalldataGas    uint64 `json:"calldataGas,omitempty"`
+	PairingGas     uint64 `json:"pairingGas,omitempty"`
+	PublicInputGas uint64 `json:"publicInputGas,omitempty"`
+	InputHashGas   uint64 `json:"inputHashGas,omitempty"`
+	TotalGas       uint64 `json:"totalGas,omitempty"`
+	GasPerIdentity uint64 `json:"gasPerIdentity,omitempty"`
+}
+
+// calldata counts the bytes of ABI-encoded calldata and their gas.
+type calldata struct {
+	size int
+	gas  uint64
+}
+
+// word adds a 32-byte word with the given number of non-zero bytes.
+func (c *calldata) word(nonZero int) {
+	c.size += 32
+	c.gas += uint64(nonZero)*calldataNonZeroGas + uint64(32-nonZero)*calldataZeroGas
+}
+
+// words adds n words with the given number of non-zero bytes.
+func (c *calldata) words(n int, nonZero int) {
+	for i := 0; i < n; i++ {
+		c.word(nonZero)
+	}
+}
+
+// memoryGas is the cost of expanding the memory to the given number of
+// words.
+func memoryGas(words uint64) uint64 {
+	return words*memoryWordGas + words*words/memoryQuadraticCoef
+}
+
+func wordsOf(size int) uint64 {
+	return uint64(size+31) / 32
+}
+
+// GasReport estimates the cost of verifying a batch of the circuit on the
+// EVM.
+func (vs *VerifyingSystem) GasReport() *GasReport {
+	report := &GasReport{PublicInputs: 1, Pairings: groth16Pairings}
+	if vs.PublicPostRoot {
+		report.PublicInputs = 2
+	}
+	var raw, compressed bytes.Buffer
+	proof := groth16.NewProof(vs.Curve)
+	proof.WriteRawTo(&raw)
+	proof.WriteTo(&compressed)
+	report.ProofSize, report.CompressedProofSize = raw.Len(), compressed.Len()
+
+	batchSize := int(vs.BatchSize)
+	// The selector and the 8 numbers of the proof.
+	cd := calldata{size: 4, gas: 4 * calldataNonZeroGas}
+	cd.words(8, 32)
+	// The packed input hash data: the start index, the roots, the identity
+	// commitments, the empty leaf and the indices.
+	hashed := 4 + 3*32 + 32*batchSize
+	if vs.EmptyLeaf.Sign() != 0 {
+		hashed += 32
+	}
+	// Each index is concatenated to a copy of the data, so the memory
+	// grows with the square of the batch size.
+	memory := wordsOf(hashed)
+	if vs.Indexed {
+		for i := 1; i <= batchSize; i++ {
+			memory += wordsOf(hashed + 4*i)
+		}
+		hashed += 4 * batchSize
+	}
+	switch vs.Commitment {
+	case CommitmentKeccak, CommitmentSHA256, CommitmentKeccakChained, "":
+		// startIndex, preRoot, postRoot and the identity commitments with
+		// their offset and length.
+		cd.word(4)
+		cd.words(2, 32)
+		cd.words(2, 2)
+		cd.words(batchSize, 32)
+		if vs.Indexed {
+			cd.words(2, 2)
+			cd.words(batchSize, 4)
+		}
+		switch vs.Commitment {
+		case CommitmentSHA256:
+			report.InputHashGas = sha256Gas + sha256WordGas*wordsOf(hashed) + memoryGas(memory)
+		case CommitmentKeccakChained:
+			report.InputHashGas = vs.chainedKeccakGas()
+		default:
+			report.InputHashGas = keccakGas + keccakWordGas*wordsOf(hashed) + memoryGas(memory)
+		}
+	default:
+		// Verifier.verifyProof takes the public inputs themselves.
+		cd.words(report.PublicInputs, 32)
+	}
+	report.CalldataSize = cd.size
+	if vs.Curve != ecc.BN254 {
+		report.InputHashGas = 0
+		return report
+	}
+
+	report.CalldataGas = cd.gas
+	report.PairingGas = pairingBaseGas + pairingPerPairGas*groth16Pairings
+	report.PublicInputGas = uint64(report.PublicInputs) * (ecMulGas + ecAddGas)
+	report.TotalGas = txGas + report.CalldataGas + report.PairingGas + report.PublicInputGas + report.InputHashGas
+	if vs.BatchSize != 0 {
+		report.GasPerIdentity = report.TotalGas / uint64(vs.BatchSize)
+	}
+	return report
+}
+
+// chainedKeccakGas estimates the input hash of CommitmentKeccakChained: the
+// hash of the start index and roots, then of every chunk after the previous
+// hash, in a buffer of one chunk, then of the empty leaf and indices after
+// the last hash, if any.
+func (vs *VerifyingSystem) chainedKeccakGas() uint64 {
+	batchSize := int(vs.BatchSize)
+	head := 4 + 2*32
+	gas := keccakGas + keccakWordGas*wordsOf(head)
+	// The packed head and the buffer with its length.
+	memory := wordsOf(head) + KeccakChainChunk + 2
+	for start := 0; start < batchSize; start += KeccakChainChunk {
+		chunk := batchSize - start
+		if chunk > KeccakChainChunk {
+			chunk = KeccakChainChunk
+		}
+		gas += keccakGas + keccakWordGas*uint64(chunk+1)
+	}
+	if vs.EmptyLeaf.Sign() == 0 && !vs.Indexed {
+		return gas + memoryGas(memory)
+	}
+	tail := 32
+	if vs.EmptyLeaf.Sign() != 0 {
+		tail += 32
+	}
+	memory += wordsOf(tail)
+	if vs.Indexed {
+		for i := 1; i <= batchSize; i++ {
+			memory += wordsOf(tail + 4*i)
+		}
+		tail += 4 * batchSize
+	}
+	return gas + keccakGas + keccakWordGas*wordsOf(tail) + memoryGas(memory)
+}
+
+// GasReport estimates the cost of verifying a batch of the circuit on the
+// EVM.
+func (ps *ProvingSystem) GasReport() *GasReport {
+	return ps.VerifyingSystem().GasReport()
+}
@@ -0,0 +1,48 @@
+package prover
+
+import (
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+)
+
+func TestGasReport(t *testing.T) {
+	keccak := (&VerifyingSystem{Curve: ecc.BN254, TreeDepth: 20, BatchSize: 100, Commitment: CommitmentKeccak}).GasReport()
+	if keccak.ProofSize != 256 || keccak.CompressedProofSize != 128 || keccak.PublicInputs != 1 || keccak.Pairings != 4 {
+		t.Fatalf("unexpected report %+v", keccak)
+	}
+	// The selector, the proof, the 3 other arguments, the offset and length
+	// of the identity commitments and the commitments themselves.
+	if keccak.CalldataSize != 4+32*(8+3+2+100) {
+		t.Fatalf("unexpected calldata size %d", keccak.CalldataSize)
+	}
+	if keccak.PairingGas != 181000 || keccak.TotalGas != 21000+keccak.CalldataGas+181000+6150+keccak.InputHashGas {
+		t.Fatalf("unexpected gas %+v", keccak)
+	}
+
+	larger := (&VerifyingSystem{Curve: ecc.BN254, BatchSize: 1000, Commitment: CommitmentKeccak}).GasReport()
+	if larger.TotalGas <= keccak.TotalGas || larger.GasPerIdentity >= keccak.GasPerIdentity {
+		t.Fatal("expected larger batches to cost more in total and less per identity")
+	}
+
+	sha256 := (&VerifyingSystem{Curve: ecc.BN254, BatchSize: 100, Commitment: CommitmentSHA256}).GasReport()
+	if sha256.InputHashGas <= keccak.InputHashGas || sha256.CalldataSize != keccak.CalldataSize {
+		t.Fatal("expected SHA-256 to hash the same calldata at a higher cost")
+	}
+
+	large := (&VerifyingSystem{Curve: ecc.BN254, BatchSize: 4096, Commitment: CommitmentKeccak}).GasReport()
+	chained := (&VerifyingSystem{Curve: ecc.BN254, BatchSize: 4096, Commitment: CommitmentKeccakChained}).GasReport()
+	if chained.InputHashGas >= large.InputHashGas || chained.CalldataSize != large.CalldataSize {
+		t.Fatalf("expected chained Keccak to hash the same calldata without the memory of the whole batch, got %d against %d", chained.InputHashGas, large.InputHashGas)
+	}
+
+	poseidon := (&VerifyingSystem{Curve: ecc.BN254, BatchSize: 100, Commitment: CommitmentPoseidon, PublicPostRoot: true}).GasReport()
+	if poseidon.InputHashGas != 0 || poseidon.CalldataSize != 4+32*(8+2) || poseidon.PublicInputGas != 2*6150 {
+		t.Fatalf("expected the poseidon verifier to take the public inputs, got %+v", poseidon)
+	}
+
+	bls := (&VerifyingSystem{Curve: ecc.BLS12_381, BatchSize: 100, Commitment: CommitmentKeccak}).GasReport()
+	if bls.ProofSize != 384 || bls.TotalGas != 0 {
+		t.Fatalf("expected no gas estimate off BN254, got %+v", bls)
+	}
+}
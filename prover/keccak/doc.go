@@ -0,0 +1,13 @@
+// Package keccak implements Keccak-256 and SHA3-256 in gnark.
+//
+// Keccak works over bits, each XOR and AND of the permutation costing a
+// constraint, which makes the input hash the largest gadget of the circuit at
+// large batch sizes. LookupKeccak computes the same hashes on 4-bit limbs,
+// checking the XOR and chi steps against the tables of a Lookups with
+// log-derivative arguments, and takes well under half the constraints once a
+// few permutations share the tables. gnark v0.8.0 has no std/lookup package,
+// so the arguments are implemented here, their challenge drawn from the
+// groth16 commitment of api.Commit. That commitment is neither serialized
+// with keys and proofs nor supported by the test engine in this gnark
+// version, which limits where circuits using LookupKeccak can be proven.
+package keccak
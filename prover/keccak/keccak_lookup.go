@@ -0,0 +1,190 @@
+package keccak
+
+import (
+	"math"
+
+	"github.com/consensys/gnark/frontend"
+)
+
+// nibbles is the number of 4-bit limbs of a lane.
+const nibbles = laneSize / 4
+
+// lane is a lane of the state as nibbles, least significant first.
+type lane [nibbles]frontend.Variable
+
+// LookupKeccak computes the same hashes as Keccak, checking the XOR and chi
+// steps of the permutation on nibbles against the lookup tables of a
+// Lookups instead of bit by bit. Rotations by other than multiples of four
+// split the nibbles, which is checked against range tables. It takes about
+// half the constraints of Keccak per permutation, on top of the tables,
+// which every LookupKeccak of a circuit shares.
+//
+// The bits written must be constrained to be boolean, as those of
+// api.ToBinary are: they are checked four at a time against the range of
+// nibbles. Lookups.Commit must be called once the circuit is done hashing.
+type LookupKeccak struct {
+	Keccak
+	lookups *Lookups
+}
+
+// NewLookupKeccak256 returns a Keccak-256 gadget checked with lookups.
+func NewLookupKeccak256(lookups *Lookups, inputSize int) LookupKeccak {
+	return LookupKeccak{Keccak: NewKeccak256(lookups.api, inputSize), lookups: lookups}
+}
+
+// padded returns the input followed by its padding, as Keccak.Sum pads it.
+func (h *LookupKeccak) padded() []frontend.Variable {
+	paddingSize := int(math.Ceil(float64(h.inputSize)/float64(h.blockSize))) * h.blockSize
+	if len(h.inputData) == 0 {
+		paddingSize = h.blockSize
+	}
+	P := make([]frontend.Variable, paddingSize)
+	copy(P, h.inputData)
+	for i := 0; i < 8; i += 1 {
+		P[i+len(h.inputData)] = (h.domain >> i) & 1
+	}
+	for i := len(h.inputData) + 8; i < len(P); i += 1 {
+		P[i] = 0
+	}
+	P[len(P)-1] = h.api.Sub(1, P[len(P)-1])
+	return P
+}
+
+func (h *LookupKeccak) Sum() []frontend.Variable {
+	P := h.padded()
+
+	var S [stateSize][stateSize]lane
+	for x := 0; x < stateSize; x += 1 {
+		for y := 0; y < stateSize; y += 1 {
+			for k := 0; k < nibbles; k += 1 {
+				S[x][y][k] = 0
+			}
+		}
+	}
+
+	// Absorbing phase
+	for i := 0; i < len(P); i += h.blockSize {
+		for x := 0; x < stateSize; x += 1 {
+			for y := 0; y < stateSize; y += 1 {
+				if x+5*y < h.blockSize/laneSize {
+					var Pi lane
+					for k := 0; k < nibbles; k += 1 {
+						start := i + (x+5*y)*laneSize + 4*k
+						Pi[k] = h.lookups.nibble(P[start : start+4])
+					}
+					S[x][y] = h.xor(S[x][y], Pi)
+				}
+			}
+		}
+		S = h.keccakf(S)
+	}
+
+	// Squeezing phase
+	var Z []frontend.Variable
+	i := 0
+	for i < h.outputSize {
+		for x := 0; x < stateSize; x += 1 {
+			for y := 0; y < stateSize; y += 1 {
+				if i < h.outputSize && x+5*y < h.blockSize/laneSize {
+					for _, n := range S[y][x] {
+						Z = append(Z, h.api.ToBinary(n, 4)...)
+					}
+					i += laneSize
+				}
+			}
+		}
+		if i < h.outputSize-laneSize {
+			S = h.keccakf(S)
+		}
+	}
+
+	return Z
+}
+
+func (h *LookupKeccak) keccakf(A [stateSize][stateSize]lane) [stateSize][stateSize]lane {
+	for i := 0; i < h.nRounds; i += 1 {
+		A = h.round(A, h.roundConstants[i])
+	}
+	return A
+}
+
+func (h *LookupKeccak) round(A [stateSize][stateSize]lane, RC [laneSize]frontend.Variable) [stateSize][stateSize]lane {
+	// C[x] = A[x,0] xor A[x,1] xor A[x,2] xor A[x,3] xor A[x,4], for x in 0…4
+	var C [stateSize]lane
+	for x := 0; x < stateSize; x += 1 {
+		C[x] = h.xor(A[x][0], A[x][1])
+		C[x] = h.xor(C[x], A[x][2])
+		C[x] = h.xor(C[x], A[x][3])
+		C[x] = h.xor(C[x], A[x][4])
+	}
+
+	// D[x] = C[x-1] xor rot(C[x+1],1), for x in 0…4
+	var D [stateSize]lane
+	for x := 0; x < stateSize; x += 1 {
+		tmp := h.rot(C[(x+1)%stateSize], 1)
+		D[x] = h.xor(C[(x+4)%stateSize], tmp)
+	}
+
+	// A[x,y] = A[x,y] xor D[x], for x in 0…4 and y in 0…4
+	for x := 0; x < stateSize; x += 1 {
+		for y := 0; y < stateSize; y += 1 {
+			A[x][y] = h.xor(A[x][y], D[x])
+		}
+	}
+
+	// B[y,2*x+3*y] = rot(A[x,y], r[x,y]), for (x,y) in (0…4,0…4)
+	var B [stateSize][stateSize]lane
+	for x := 0; x < stateSize; x += 1 {
+		for y := 0; y < stateSize; y += 1 {
+			B[y][(2*x+3*y)%stateSize] = h.rot(A[x][y], h.rotationOffsets[x][y])
+		}
+	}
+
+	// A[x,y] = B[x,y] xor ((not B[x+1,y]) and B[x+2,y]), for x in 0…4 and y in 0…4
+	for x := 0; x < stateSize; x += 1 {
+		for y := 0; y < stateSize; y += 1 {
+			for k := 0; k < nibbles; k += 1 {
+				A[x][y][k] = h.lookups.chiNibbles(B[x][y][k], B[(x+1)%stateSize][y][k], B[(x+2)%stateSize][y][k])
+			}
+		}
+	}
+
+	// A[0,0] = A[0,0] xor RC
+	var rc lane
+	for k := 0; k < nibbles; k += 1 {
+		rc[k] = h.lookups.packedBits(RC[4*k : 4*k+4])
+	}
+	A[0][0] = h.xor(A[0][0], rc)
+
+	return A
+}
+
+func (h *LookupKeccak) xor(a, b lane) lane {
+	var c lane
+	for k := 0; k < nibbles; k += 1 {
+		c[k] = h.lookups.xorNibbles(a[k], b[k])
+	}
+	return c
+}
+
+// rot rotates a left by r bits, moving bits to higher positions as rot of
+// Keccak does. Unless r is a multiple of four, the nibbles are split at
+// 4 - r%4 bits and the parts reassembled into the nibbles they move into.
+func (h *LookupKeccak) rot(a lane, r int) lane {
+	shift, offset := r%4, r/4
+	var c lane
+	if shift == 0 {
+		for k := 0; k < nibbles; k += 1 {
+			c[k] = a[(k+nibbles-offset)%nibbles]
+		}
+		return c
+	}
+	var lo, hi lane
+	for k := 0; k < nibbles; k += 1 {
+		lo[k], hi[k] = h.lookups.split(a[k], shift)
+	}
+	for k := 0; k < nibbles; k += 1 {
+		c[k] = h.api.Add(hi[(k+2*nibbles-offset-1)%nibbles], h.api.Mul(lo[(k+nibbles-offset)%nibbles], 1<<shift))
+	}
+	return c
+}
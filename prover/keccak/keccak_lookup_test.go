@@ -0,0 +1,243 @@
+package keccak
+
+import (
+	"math/big"
+	"math/rand"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/backend/hint"
+	"github.com/consensys/gnark/constraint"
+	cs_bn254 "github.com/consensys/gnark/constraint/bn254"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+	"github.com/iden3/go-iden3-crypto/keccak256"
+)
+
+// lookupEquivalenceCircuit hashes Input, given as bits, with Keccak and
+// LookupKeccak, asserting that both digests are equal and that of Hash.
+type lookupEquivalenceCircuit struct {
+	Input []frontend.Variable
+	Hash  frontend.Variable `gnark:",public"`
+}
+
+func (circuit *lookupEquivalenceCircuit) Define(api frontend.API) error {
+	for _, bit := range circuit.Input {
+		api.AssertIsBoolean(bit)
+	}
+	h := NewKeccak256(api, len(circuit.Input))
+	h.Write(circuit.Input...)
+	expected := h.Sum()
+
+	lookups := NewLookups(api)
+	lh := NewLookupKeccak256(lookups, len(circuit.Input))
+	lh.Write(circuit.Input...)
+	sum := lh.Sum()
+	if len(sum) != len(expected) {
+		panic("the digests differ in length")
+	}
+	for i := range sum {
+		api.AssertIsEqual(sum[i], expected[i])
+	}
+	api.AssertIsEqual(circuit.Hash, api.FromBinary(sum...))
+	return lookups.Commit()
+}
+
+// equivalenceAssignment returns the assignment of data and its digest.
+func equivalenceAssignment(data []byte) *lookupEquivalenceCircuit {
+	assignment := &lookupEquivalenceCircuit{Input: make([]frontend.Variable, 8*len(data))}
+	for i := range assignment.Input {
+		assignment.Input[i] = (data[i/8] >> (i % 8)) & 1
+	}
+	digest := keccak256.Hash(data)
+	// The bits of the digest are read little-endian.
+	for i := 0; i < len(digest)/2; i++ {
+		digest[i], digest[len(digest)-1-i] = digest[len(digest)-1-i], digest[i]
+	}
+	assignment.Hash = new(big.Int).SetBytes(digest)
+	return assignment
+}
+
+func compileEquivalence(t *testing.T, size int) constraint.ConstraintSystem {
+	cs, err := frontend.Compile(ecc.BN254.ScalarField(), r1cs.NewBuilder, &lookupEquivalenceCircuit{Input: make([]frontend.Variable, 8*size)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cs
+}
+
+func randomBytes(size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(int64(size))).Read(data)
+	return data
+}
+
+func TestLookupKeccakProves(t *testing.T) {
+	data := randomBytes(32)
+	cs, err := frontend.Compile(ecc.BN254.ScalarField(), r1cs.NewBuilder, &lookupCircuit{Input: make([]frontend.Variable, 8*len(data))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, vk, err := groth16.Setup(cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assignment := equivalenceAssignment(data)
+	witness, err := frontend.NewWitness(&lookupCircuit{Input: assignment.Input, Hash: assignment.Hash}, ecc.BN254.ScalarField())
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof, err := groth16.Prove(cs, pk, witness)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := witness.Public()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = groth16.Verify(proof, vk, public); err != nil {
+		t.Fatalf("expected the proof to verify, got %v", err)
+	}
+}
+
+// solveCommitment lets the solver run a circuit with a commitment outside
+// groth16.Prove, which the lookups are satisfied by whatever its value.
+func solveCommitment(cs constraint.ConstraintSystem) backend.ProverOption {
+	id := cs.(*cs_bn254.R1CS).CommitmentInfo.HintID
+	return func(config *backend.ProverConfig) error {
+		config.HintFunctions[id] = func(_ *big.Int, inputs []*big.Int, outputs []*big.Int) error {
+			outputs[0].SetUint64(uint64(len(inputs)) << 32)
+			return nil
+		}
+		return nil
+	}
+}
+
+func TestLookupKeccakMatchesKeccak(t *testing.T) {
+	// Inputs of one block, the longest filling it with the padding, and of
+	// two blocks, the second one padded with one byte or many.
+	for _, size := range []int{0, 1, 32, 135, 136 + 1, 2*136 - 1} {
+		data := randomBytes(size)
+		cs := compileEquivalence(t, size)
+		witness, err := frontend.NewWitness(equivalenceAssignment(data), ecc.BN254.ScalarField())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = cs.IsSolved(witness, solveCommitment(cs)); err != nil {
+			t.Fatalf("%d bytes: %v", size, err)
+		}
+
+		wrong := equivalenceAssignment(data)
+		wrong.Hash = new(big.Int).Add(wrong.Hash.(*big.Int), big.NewInt(1))
+		if witness, err = frontend.NewWitness(wrong, ecc.BN254.ScalarField()); err != nil {
+			t.Fatal(err)
+		}
+		if err = cs.IsSolved(witness, solveCommitment(cs)); err == nil {
+			t.Fatalf("%d bytes: expected a wrong digest not to solve", size)
+		}
+	}
+}
+
+func TestLookupKeccakConstraints(t *testing.T) {
+	count := func(circuit frontend.Circuit) int {
+		cs, err := frontend.Compile(ecc.BN254.ScalarField(), r1cs.NewBuilder, circuit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cs.GetNbConstraints()
+	}
+	// Four permutations, as hashing a few hundred bytes takes.
+	const size = 8 * 4 * 136
+	bitwise := count(&bitwiseCircuit{Input: make([]frontend.Variable, size-8)})
+	lookup := count(&lookupCircuit{Input: make([]frontend.Variable, size-8)})
+	t.Logf("bitwise: %d constraints, lookups: %d constraints", bitwise, lookup)
+	if 3*lookup > 2*bitwise {
+		t.Fatalf("expected the lookups to take at most two thirds of the constraints, got %d against %d", lookup, bitwise)
+	}
+}
+
+type bitwiseCircuit struct {
+	Input []frontend.Variable
+	Hash  frontend.Variable `gnark:",public"`
+}
+
+func (circuit *bitwiseCircuit) Define(api frontend.API) error {
+	h := NewKeccak256(api, len(circuit.Input))
+	h.Write(circuit.Input...)
+	api.AssertIsEqual(circuit.Hash, api.FromBinary(h.Sum()...))
+	return nil
+}
+
+// lookupCircuit asserts that Hash is the digest of Input with LookupKeccak.
+type lookupCircuit struct {
+	Input []frontend.Variable
+	Hash  frontend.Variable `gnark:",public"`
+}
+
+func (circuit *lookupCircuit) Define(api frontend.API) error {
+	for _, bit := range circuit.Input {
+		api.AssertIsBoolean(bit)
+	}
+	lookups := NewLookups(api)
+	h := NewLookupKeccak256(lookups, len(circuit.Input))
+	h.Write(circuit.Input...)
+	api.AssertIsEqual(circuit.Hash, api.FromBinary(h.Sum()...))
+	return lookups.Commit()
+}
+
+// xorCircuit asserts that Out is A ^ B.
+type xorCircuit struct {
+	A, B, Out frontend.Variable
+}
+
+func (circuit *xorCircuit) Define(api frontend.API) error {
+	lookups := NewLookups(api)
+	a, b := lookups.nibble(api.ToBinary(circuit.A, 4)), lookups.nibble(api.ToBinary(circuit.B, 4))
+	api.AssertIsEqual(lookups.xorNibbles(a, b), circuit.Out)
+	return lookups.Commit()
+}
+
+func TestLookupsRejectWrongResults(t *testing.T) {
+	cs, err := frontend.Compile(ecc.BN254.ScalarField(), r1cs.NewBuilder, &xorCircuit{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	witness, err := frontend.NewWitness(&xorCircuit{A: 3, B: 5, Out: 3 ^ 5 ^ 1}, ecc.BN254.ScalarField())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A prover answering the XOR wrongly, and counting only the queries
+	// found in the tables.
+	cheat := func(config *backend.ProverConfig) error {
+		config.HintFunctions[hint.UUID(nibbleXorHint)] = func(_ *big.Int, inputs []*big.Int, outputs []*big.Int) error {
+			outputs[0].SetUint64(inputs[0].Uint64() ^ inputs[1].Uint64() ^ 1)
+			return nil
+		}
+		config.HintFunctions[hint.UUID(multiplicitiesHint)] = func(_ *big.Int, inputs []*big.Int, outputs []*big.Int) error {
+			nbRows := int(inputs[0].Int64())
+			for i := range outputs {
+				outputs[i].SetUint64(0)
+			}
+			for _, q := range inputs[1+nbRows:] {
+				for i, row := range inputs[1 : 1+nbRows] {
+					if row.Cmp(q) == 0 {
+						outputs[i].Add(outputs[i], big.NewInt(1))
+					}
+				}
+			}
+			return nil
+		}
+		return nil
+	}
+	if err = cs.IsSolved(witness, solveCommitment(cs), cheat); err == nil {
+		t.Fatal("expected a result missing from the XOR table to be rejected")
+	}
+	if witness, err = frontend.NewWitness(&xorCircuit{A: 3, B: 5, Out: 3 ^ 5}, ecc.BN254.ScalarField()); err != nil {
+		t.Fatal(err)
+	}
+	if err = cs.IsSolved(witness, solveCommitment(cs)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,264 @@
+package keccak
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/consensys/gnark/backend/hint"
+	"github.com/consensys/gnark/frontend"
+)
+
+func init() {
+	hint.Register(nibbleXorHint, nibbleChiHint, nibbleSplitHint, multiplicitiesHint)
+}
+
+// Lookups checks values against fixed tables with log-derivative arguments:
+// the queries q of a table holding the rows t hold if
+//
+//	Σ_q 1/(α-q) = Σ_t m_t/(α-t)
+//
+// where m_t is the number of queries of row t, which the prover supplies,
+// and α is a commitment to the queries and multiplicities, drawn with
+// api.Commit once they are all known. Every query and row then costs a
+// constraint.
+//
+// Gnark v0.8.0 only supports a single api.Commit per circuit, and only in
+// R1CS, so the gadgets of a circuit share one Lookups, which Commit closes
+// at the end of the circuit. Its circuits cannot be solved by the test
+// engine, which does not implement api.Commit.
+type Lookups struct {
+	api frontend.API
+	// ranges holds the values of 1 to 4 bits, indexed by their size.
+	ranges [5]*lookupTable
+	xor    *lookupTable
+	chi    *lookupTable
+}
+
+// lookupTable is a table of keys and the keys queried from it.
+type lookupTable struct {
+	rows    []uint64
+	queries []frontend.Variable
+}
+
+// NewLookups returns the lookup tables of the nibble operations of
+// LookupKeccak.
+func NewLookups(api frontend.API) *Lookups {
+	l := &Lookups{api: api, xor: &lookupTable{}, chi: &lookupTable{}}
+	for bits := 1; bits < len(l.ranges); bits++ {
+		l.ranges[bits] = &lookupTable{}
+		for v := uint64(0); v < 1<<bits; v++ {
+			l.ranges[bits].rows = append(l.ranges[bits].rows, v)
+		}
+	}
+	for a := uint64(0); a < 16; a++ {
+		for b := uint64(0); b < 16; b++ {
+			l.xor.rows = append(l.xor.rows, xorKey(a, b, a^b))
+			for c := uint64(0); c < 16; c++ {
+				l.chi.rows = append(l.chi.rows, chiKey(a, b, c, chi(a, b, c)))
+			}
+		}
+	}
+	return l
+}
+
+// xorKey packs a XOR of nibbles, a ^ b = out, into a row of the XOR table.
+func xorKey(a, b, out uint64) uint64 {
+	return a | b<<4 | out<<8
+}
+
+// chiKey packs a chi step on nibbles, a ^ (^b & c) = out, into a row of the
+// chi table.
+func chiKey(a, b, c, out uint64) uint64 {
+	return a | b<<4 | c<<8 | out<<12
+}
+
+func chi(a, b, c uint64) uint64 {
+	return (a ^ (^b & c)) & 0xf
+}
+
+// packed returns the linear combination of values keyed like xorKey and
+// chiKey. Its values must be nibbles for the key to identify them.
+func (l *Lookups) packed(values ...frontend.Variable) frontend.Variable {
+	key := values[0]
+	for i, v := range values[1:] {
+		key = l.api.Add(key, l.api.Mul(v, 1<<(4*(i+1))))
+	}
+	return key
+}
+
+// constants returns the values of vs if they are all constants.
+func (l *Lookups) constants(vs ...frontend.Variable) ([]uint64, bool) {
+	values := make([]uint64, len(vs))
+	for i, v := range vs {
+		c, ok := l.api.ConstantValue(v)
+		if !ok {
+			return nil, false
+		}
+		values[i] = c.Uint64()
+	}
+	return values, true
+}
+
+// checkRange asserts that v is less than 2^bits, for bits up to 4.
+func (l *Lookups) checkRange(v frontend.Variable, bits int) {
+	if _, ok := l.api.ConstantValue(v); ok {
+		return
+	}
+	l.ranges[bits].queries = append(l.ranges[bits].queries, v)
+}
+
+// xorNibbles returns a ^ b for nibbles a and b.
+func (l *Lookups) xorNibbles(a, b frontend.Variable) frontend.Variable {
+	if c, ok := l.constants(a, b); ok {
+		return c[0] ^ c[1]
+	}
+	for _, pair := range [2][2]frontend.Variable{{a, b}, {b, a}} {
+		if c, ok := l.constants(pair[1]); ok && c[0] == 0 {
+			return pair[0]
+		}
+	}
+	out := l.hint(nibbleXorHint, a, b)
+	l.checkRange(out, 4)
+	l.xor.queries = append(l.xor.queries, l.packed(a, b, out))
+	return out
+}
+
+// chiNibbles returns a ^ (^b & c) for nibbles a, b and c.
+func (l *Lookups) chiNibbles(a, b, c frontend.Variable) frontend.Variable {
+	if values, ok := l.constants(a, b, c); ok {
+		return chi(values[0], values[1], values[2])
+	}
+	out := l.hint(nibbleChiHint, a, b, c)
+	l.checkRange(out, 4)
+	l.chi.queries = append(l.chi.queries, l.packed(a, b, c, out))
+	return out
+}
+
+// split splits nibble n into its low 4-high bits and its high bits.
+func (l *Lookups) split(n frontend.Variable, high int) (lo, hi frontend.Variable) {
+	low := 4 - high
+	if c, ok := l.constants(n); ok {
+		return c[0] & (1<<low - 1), c[0] >> low
+	}
+	lo = l.hint(nibbleSplitHint, n, low)
+	// hi is only an integer less than 2^high if lo is the low bits of n.
+	hi = l.api.Mul(l.api.Sub(n, lo), new(big.Int).ModInverse(big.NewInt(1<<low), l.api.Compiler().Field()))
+	l.checkRange(lo, low)
+	l.checkRange(hi, high)
+	return lo, hi
+}
+
+// hint returns the single output of f. Like the gadgets of gnark, it panics
+// if the hint cannot be added, which frontend.Compile reports as an error.
+func (l *Lookups) hint(f hint.Function, inputs ...frontend.Variable) frontend.Variable {
+	out, err := l.api.Compiler().NewHint(f, 1, inputs...)
+	if err != nil {
+		panic(err)
+	}
+	return out[0]
+}
+
+// nibble returns the nibble of four bits, least significant first, checking
+// that it is one so that bits need not be constrained to be boolean.
+func (l *Lookups) nibble(bits []frontend.Variable) frontend.Variable {
+	n := l.packedBits(bits)
+	l.checkRange(n, 4)
+	return n
+}
+
+func (l *Lookups) packedBits(bits []frontend.Variable) frontend.Variable {
+	n := bits[0]
+	for i, bit := range bits[1:] {
+		n = l.api.Add(n, l.api.Mul(bit, 1<<(i+1)))
+	}
+	return n
+}
+
+// Commit draws the challenge of the arguments from a commitment to every
+// query and multiplicity, and checks the queries of every table. It must be
+// called once all the lookups of the circuit are done.
+func (l *Lookups) Commit() error {
+	tables := append([]*lookupTable{l.xor, l.chi}, l.ranges[1:]...)
+	var committed []frontend.Variable
+	multiplicities := make([][]frontend.Variable, len(tables))
+	for i, table := range tables {
+		if len(table.queries) == 0 {
+			continue
+		}
+		inputs := []frontend.Variable{len(table.rows)}
+		for _, row := range table.rows {
+			inputs = append(inputs, row)
+		}
+		inputs = append(inputs, table.queries...)
+		m, err := l.api.Compiler().NewHint(multiplicitiesHint, len(table.rows), inputs...)
+		if err != nil {
+			return err
+		}
+		multiplicities[i] = m
+		committed = append(append(committed, table.queries...), m...)
+	}
+	if len(committed) == 0 {
+		return nil
+	}
+	alpha, err := l.api.Compiler().Commit(committed...)
+	if err != nil {
+		return err
+	}
+	for i, table := range tables {
+		if len(table.queries) == 0 {
+			continue
+		}
+		queried := make([]frontend.Variable, len(table.queries))
+		for j, q := range table.queries {
+			queried[j] = l.api.Inverse(l.api.Sub(alpha, q))
+		}
+		counted := make([]frontend.Variable, len(table.rows))
+		for j, row := range table.rows {
+			counted[j] = l.api.DivUnchecked(multiplicities[i][j], l.api.Sub(alpha, row))
+		}
+		l.api.AssertIsEqual(sum(l.api, queried), sum(l.api, counted))
+	}
+	return nil
+}
+
+func sum(api frontend.API, vs []frontend.Variable) frontend.Variable {
+	if len(vs) == 1 {
+		return vs[0]
+	}
+	return api.Add(vs[0], vs[1], vs[2:]...)
+}
+
+func nibbleXorHint(_ *big.Int, inputs []*big.Int, outputs []*big.Int) error {
+	outputs[0].SetUint64(inputs[0].Uint64() ^ inputs[1].Uint64())
+	return nil
+}
+
+func nibbleChiHint(_ *big.Int, inputs []*big.Int, outputs []*big.Int) error {
+	outputs[0].SetUint64(chi(inputs[0].Uint64(), inputs[1].Uint64(), inputs[2].Uint64()))
+	return nil
+}
+
+// nibbleSplitHint returns the low inputs[1] bits of inputs[0].
+func nibbleSplitHint(_ *big.Int, inputs []*big.Int, outputs []*big.Int) error {
+	outputs[0].SetUint64(inputs[0].Uint64() & (1<<inputs[1].Uint64() - 1))
+	return nil
+}
+
+// multiplicitiesHint counts the queries of each row of a table, given as the
+// number of rows followed by the rows and the queries.
+func multiplicitiesHint(_ *big.Int, inputs []*big.Int, outputs []*big.Int) error {
+	nbRows := int(inputs[0].Int64())
+	rows := make(map[string]int, nbRows)
+	for i, row := range inputs[1 : 1+nbRows] {
+		rows[row.String()] = i
+		outputs[i].SetUint64(0)
+	}
+	for _, q := range inputs[1+nbRows:] {
+		i, ok := rows[q.String()]
+		if !ok {
+			return fmt.Errorf("%s is not in the lookup table", q)
+		}
+		outputs[i].Add(outputs[i], big.NewInt(1))
+	}
+	return nil
+}
@@ -0,0 +1,312 @@
+package prover
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime/debug"
+	"time"
+	"worldcoin/gnark-mbu/logging"
+
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/constraint"
+)
+
+// KeygenStage is a stage of KeygenToFile, each of which ends with a
+// checkpoint that later runs resume from.
+type KeygenStage string
+
+const (
+	// KeygenCompile compiles the circuit and checkpoints the constraint
+	// system.
+	KeygenCompile KeygenStage = "compile"
+	// KeygenSetup generates the keys and checkpoints them.
+	KeygenSetup KeygenStage = "setup"
+	// KeygenSerialize writes the key file from the checkpoints, then
+	// removes them.
+	KeygenSerialize KeygenStage = "serialize"
+)
+
+var keygenStages = []KeygenStage{KeygenCompile, KeygenSetup, KeygenSerialize}
+
+// ParseKeygenStage parses the name of a stage, the empty name meaning
+// KeygenSerialize, i.e. all of them.
+func ParseKeygenStage(name string) (KeygenStage, error) {
+	if name == "" {
+		return KeygenSerialize, nil
+	}
+	for _, stage := range keygenStages {
+		if string(stage) == name {
+			return stage, nil
+		}
+	}
+	return "", fmt.Errorf("unknown keygen stage: %s", name)
+}
+
+var keygenCheckpointMagic = [4]byte{'M', 'B', 'U', 'C'}
+
+// KeygenCheckpoints returns the paths of the checkpoints KeygenToFile keeps
+// next to the key file at path: the constraint system and the keys.
+func KeygenCheckpoints(path string) (cs string, keys string) {
+	return path + ".cs.checkpoint", path + ".keys.checkpoint"
+}
+
+// KeygenToFile sets up the circuit and writes the proving system to path like
+// SetupToFile, in stages that each leave a checkpoint next to path, so that a
+// run interrupted during a long setup resumes after its last completed stage
+// instead of starting over. Checkpoints are fingerprinted like key files:
+// those of another circuit, circuit version or gnark version, and those that
+// are incomplete or do not match their checksum, are discarded and their
+// stage run again. It stops after the stage until, leaving its checkpoint,
+// and returns the size of the key file once it is written. A done ctx stops it
+// between stages, keeping the checkpoints of the completed ones.
+func KeygenToFile(ctx context.Context, path string, raw bool, until KeygenStage, treeDepth uint32, batchSize uint32, opts ...CircuitOption) (written int64, err error) {
+	log := logging.Logger().With().Uint32("treeDepth", treeDepth).Uint32("batchSize", batchSize).Logger()
+	if _, err = ParseKeygenStage(string(until)); err != nil {
+		return 0, err
+	}
+	options := newCircuitOptions(opts)
+	header := setupHeader(treeDepth, batchSize, options)
+	csPath, keysPath := KeygenCheckpoints(path)
+
+	// compile
+	csFile, err := openCheckpoint(csPath, &header)
+	if err != nil {
+		return 0, err
+	}
+	var ccs constraint.ConstraintSystem
+	if csFile != nil {
+		log.Info().Str("checkpoint", csPath).Msg("resuming from the compiled circuit")
+	} else {
+		start := time.Now()
+		log.Info().Msg("compiling the circuit")
+		if ccs, err = BuildR1CS(ctx, treeDepth, batchSize, opts...); err != nil {
+			return 0, err
+		}
+		log.Info().Int("constraints", ccs.GetNbConstraints()).Dur("took", time.Since(start)).Msg("circuit compiled")
+		err = writeCheckpoint(csPath, &header, func(kw *keysFileWriter) error {
+			return kw.write(func(w io.Writer) (int64, error) { return writeFramed(w, ccs) })
+		})
+		if err != nil {
+			return 0, err
+		}
+		if csFile, err = openCheckpoint(csPath, &header); err != nil {
+			return 0, err
+		}
+		if csFile == nil {
+			return 0, fmt.Errorf("the checkpoint %s was not written", csPath)
+		}
+	}
+	defer csFile.Close()
+	if until == KeygenCompile {
+		return 0, nil
+	}
+
+	if err = ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	// setup
+	keysFile, err := openCheckpoint(keysPath, &header)
+	if err != nil {
+		return 0, err
+	}
+	var (
+		pk groth16.ProvingKey
+		vk groth16.VerifyingKey
+	)
+	if keysFile != nil {
+		log.Info().Str("checkpoint", keysPath).Msg("resuming from the generated keys")
+		keys := bufio.NewReaderSize(keysFile, 1<<20)
+		pk, vk = groth16.NewProvingKey(options.curve), groth16.NewVerifyingKey(options.curve)
+		_, err = pk.UnsafeReadFrom(keys)
+		if err == nil {
+			_, err = vk.UnsafeReadFrom(keys)
+		}
+		keysFile.Close()
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		if ccs == nil {
+			ccs = groth16.NewCS(options.curve)
+			if _, err = readFramed(bufio.NewReaderSize(csFile, 1<<20), ccs); err != nil {
+				return 0, err
+			}
+		}
+		if err = ctx.Err(); err != nil {
+			return 0, err
+		}
+		start := time.Now()
+		log.Info().Msg("generating the keys")
+		if pk, vk, err = groth16.Setup(ccs); err != nil {
+			return 0, err
+		}
+		log.Info().Dur("took", time.Since(start)).Msg("keys generated")
+		err = writeCheckpoint(keysPath, &header, func(kw *keysFileWriter) error {
+			if err := kw.key(pk); err != nil {
+				return err
+			}
+			return kw.key(vk)
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	// The constraint system is copied from its checkpoint, return its
+	// memory before the keys are serialized.
+	ccs = nil
+	debug.FreeOSMemory()
+	if until == KeygenSetup {
+		return 0, nil
+	}
+
+	if err = ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	// serialize
+	if _, err = csFile.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	cs := bufio.NewReaderSize(csFile, 1<<20)
+	if _, err = readCheckpointHeader(cs); err != nil {
+		return 0, err
+	}
+	var lengthBuf [8]byte
+	if _, err = io.ReadFull(cs, lengthBuf[:]); err != nil {
+		return 0, err
+	}
+	if written, err = writeKeysFile(ctx, path, raw, &header, pk, vk, cs, int64(binary.BigEndian.Uint64(lengthBuf[:]))); err != nil {
+		return written, err
+	}
+	csFile.Close()
+	if err = errors.Join(os.Remove(csPath), os.Remove(keysPath)); err != nil {
+		log.Warn().Err(err).Msg("failed to remove the keygen checkpoints")
+	}
+	return written, nil
+}
+
+// writeCheckpoint writes the header and the sections written by write to a
+// checkpoint, followed by their checksum, through path.partial so that it
+// only appears at path once it is complete. Keys are written raw, which
+// loads faster.
+func writeCheckpoint(path string, header *keysFileHeader, write func(kw *keysFileWriter) error) (err error) {
+	partial := path + ".partial"
+	file, err := os.Create(partial)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := file.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(partial)
+		}
+	}()
+	buffered := bufio.NewWriterSize(file, 1<<20)
+	kw := newKeysFileWriter(buffered, true)
+	if err = kw.write(func(w io.Writer) (int64, error) { return writeFileHeader(w, keygenCheckpointMagic, header) }); err != nil {
+		return err
+	}
+	if err = write(kw); err != nil {
+		return err
+	}
+	if err = kw.finish(); err != nil {
+		return err
+	}
+	if err = buffered.Flush(); err != nil {
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		return err
+	}
+	return os.Rename(partial, path)
+}
+
+// readCheckpointHeader reads the magic and header of a checkpoint.
+func readCheckpointHeader(r io.Reader) (*keysFileHeader, error) {
+	var magic [4]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		return nil, err
+	}
+	if magic != keygenCheckpointMagic {
+		return nil, errors.New("not a keygen checkpoint")
+	}
+	header, _, err := readFileHeaderBody(r)
+	return header, err
+}
+
+// openCheckpoint opens the checkpoint at path positioned after its header if
+// it was written for the circuit of header and matches its checksum, and
+// returns nil otherwise, removing checkpoints that cannot be resumed from.
+func openCheckpoint(path string, header *keysFileHeader) (*os.File, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	offset, err := verifyCheckpoint(file, header)
+	if err == nil {
+		_, err = file.Seek(offset, io.SeekStart)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		return file, nil
+	}
+	file.Close()
+	logging.Logger().Warn().Err(err).Str("checkpoint", path).Msg("discarding keygen checkpoint")
+	if err = os.Remove(path); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// verifyCheckpoint checks the header and checksum of a checkpoint, returning
+// the offset of its first section.
+func verifyCheckpoint(file *os.File, header *keysFileHeader) (int64, error) {
+	stat, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	trailer := int64(len(keysFileChecksumMagic) + sha256.Size)
+	digest := sha256.New()
+	contents := io.TeeReader(io.LimitReader(file, stat.Size()-trailer), digest)
+	counted := &countingReader{r: bufio.NewReader(contents)}
+	checkpoint, err := readCheckpointHeader(counted)
+	if err != nil {
+		return 0, &CorruptKeysError{Reason: err.Error()}
+	}
+	if checkpoint.Fingerprint != header.Fingerprint {
+		return 0, errors.New("the checkpoint is for another circuit")
+	}
+	offset := counted.n
+	if _, err = io.Copy(io.Discard, counted); err != nil {
+		return 0, err
+	}
+	if _, err = readChecksum(file, digest); err != nil {
+		return 0, err
+	}
+	return offset, nil
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n += int64(n)
+	return n, err
+}
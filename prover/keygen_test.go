@@ -0,0 +1,90 @@
+package prover
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend/groth16"
+)
+
+func TestKeygenToFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	csPath, keysPath := KeygenCheckpoints(path)
+	opts := []CircuitOption{WithCommitment(CommitmentPoseidon)}
+
+	if _, err := KeygenToFile(context.Background(), path, false, KeygenCompile, 2, 1, opts...); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(csPath); err != nil {
+		t.Fatalf("expected the constraint system to be checkpointed: %v", err)
+	}
+	if _, err := os.Stat(keysPath); !os.IsNotExist(err) {
+		t.Fatal("expected keygen to stop after compiling")
+	}
+
+	// A corrupt checkpoint is discarded and its stage run again.
+	data, err := os.ReadFile(csPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)/2] ^= 1
+	if err = os.WriteFile(csPath, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = KeygenToFile(context.Background(), path, false, KeygenSetup, 2, 1, opts...); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := os.ReadFile(keysPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := bytes.NewReader(keys)
+	if _, err = readCheckpointHeader(r); err != nil {
+		t.Fatal(err)
+	}
+	pk, vk := groth16.NewProvingKey(ecc.BN254), groth16.NewVerifyingKey(ecc.BN254)
+	if _, err = pk.UnsafeReadFrom(r); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = vk.UnsafeReadFrom(r); err != nil {
+		t.Fatal(err)
+	}
+
+	// The keys are resumed from their checkpoint rather than generated
+	// again, which would give other keys.
+	written, err := KeygenToFile(context.Background(), path, false, KeygenSerialize, 2, 1, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected the checkpoints to be removed, got %d files", len(entries))
+	}
+	stat, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Size() != written {
+		t.Fatalf("expected %d bytes, the file has %d", written, stat.Size())
+	}
+	ps, err := ReadSystemFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ps.Commitment != CommitmentPoseidon || ps.ConstraintSystem.GetNbConstraints() == 0 {
+		t.Fatal("unexpected proving system")
+	}
+	var expected, actual bytes.Buffer
+	vk.WriteRawTo(&expected)
+	ps.VerifyingKey.WriteRawTo(&actual)
+	if !bytes.Equal(expected.Bytes(), actual.Bytes()) {
+		t.Fatal("expected the checkpointed keys to be written")
+	}
+}
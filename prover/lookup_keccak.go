@@ -0,0 +1,71 @@
+package prover
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend"
+	"github.com/consensys/gnark/constraint"
+	cs_bn254 "github.com/consensys/gnark/constraint/bn254"
+)
+
+// errLookupKeccakKeys is returned when writing the key file of a circuit set
+// up WithLookupKeccak.
+var errLookupKeccakKeys = errors.New("gnark v0.8.0 does not serialize the commitment keys of circuits set up with lookup Keccak, so they cannot be written to key files")
+
+// WithLookupKeccak hashes the inputs of the keccak and keccak-chained
+// commitments with keccak.LookupKeccak, which checks the permutation on
+// nibbles against lookup tables and takes well under half the constraints of
+// the bitwise gadget once the batch spans a few permutations. The input
+// hash is the same.
+//
+// The lookups draw their challenge from a BSB22 commitment (api.Commit),
+// which gnark v0.8.0 serializes neither with keys nor with proofs. Proving
+// systems set up with it can only be used by the process that set them up:
+// their key files cannot be written, and their proofs only verify in that
+// process. It is only available on BN254, and the command line only offers
+// it to the tooling counting constraints, profile and fuzz-input-hash.
+func WithLookupKeccak() CircuitOption {
+	return func(o *circuitOptions) {
+		o.lookupKeccak = true
+	}
+}
+
+func validateLookupKeccak(options circuitOptions) error {
+	if !options.lookupKeccak {
+		return nil
+	}
+	if options.commitment != CommitmentKeccak && options.commitment != CommitmentKeccakChained {
+		return fmt.Errorf("lookup Keccak only applies to the %s and %s commitments", CommitmentKeccak, CommitmentKeccakChained)
+	}
+	if options.curve != ecc.BN254 {
+		return fmt.Errorf("lookup Keccak is only available on %s", ecc.BN254)
+	}
+	return nil
+}
+
+// solveCommitment lets the constraint solver run a circuit with lookups
+// outside of groth16.Prove, which computes the commitment itself. The
+// lookups hold whatever the challenge, so the committed values are hashed
+// instead. It returns no option for circuits without a commitment.
+func solveCommitment(cs constraint.ConstraintSystem) []backend.ProverOption {
+	r1cs, ok := cs.(*cs_bn254.R1CS)
+	if !ok || !r1cs.CommitmentInfo.Is() {
+		return nil
+	}
+	return []backend.ProverOption{func(config *backend.ProverConfig) error {
+		config.HintFunctions[r1cs.CommitmentInfo.HintID] = func(field *big.Int, inputs []*big.Int, outputs []*big.Int) error {
+			h := sha256.New()
+			for _, input := range inputs {
+				h.Write(input.FillBytes(make([]byte, 32)))
+			}
+			outputs[0].SetBytes(h.Sum(nil))
+			outputs[0].Mod(outputs[0], field)
+			return nil
+		}
+		return nil
+	}}
+}
@@ -0,0 +1,58 @@
+package prover
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+)
+
+func TestLookupKeccakCheck(t *testing.T) {
+	cs, err := BuildR1CS(context.Background(), testTreeDepth, testBatchSize, WithLookupKeccak())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps := &ProvingSystem{Curve: ecc.BN254, TreeDepth: testTreeDepth, BatchSize: testBatchSize, Commitment: CommitmentKeccak, LookupKeccak: true, ConstraintSystem: cs}
+	if err = ps.Check(testParameters()); err != nil {
+		t.Fatal(err)
+	}
+	params := testParameters()
+	params.InputHash.SetUint64(42)
+	if err = ps.Check(params); err == nil {
+		t.Fatal("expected a wrong input hash to be rejected")
+	}
+
+	bitwise, err := BuildR1CS(context.Background(), testTreeDepth, testBatchSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cs.GetNbConstraints() >= bitwise.GetNbConstraints() {
+		t.Fatalf("expected fewer constraints with lookups, got %d against %d", cs.GetNbConstraints(), bitwise.GetNbConstraints())
+	}
+}
+
+func TestLookupKeccakOptions(t *testing.T) {
+	for _, opts := range [][]CircuitOption{
+		{WithCommitment(CommitmentPoseidon)},
+		{WithCommitment(CommitmentSHA256)},
+		{WithCurve(ecc.BLS12_377)},
+	} {
+		if _, err := BuildR1CS(context.Background(), testTreeDepth, testBatchSize, append(opts, WithLookupKeccak())...); err == nil {
+			t.Fatalf("expected %+v to be rejected", newCircuitOptions(opts))
+		}
+	}
+	if _, err := BuildR1CS(context.Background(), testTreeDepth, testBatchSize, WithCommitment(CommitmentKeccakChained), WithLookupKeccak()); err != nil {
+		t.Fatal(err)
+	}
+
+	header := setupHeader(testTreeDepth, testBatchSize, newCircuitOptions([]CircuitOption{WithLookupKeccak()}))
+	bitwise := setupHeader(testTreeDepth, testBatchSize, newCircuitOptions(nil))
+	if header.fingerprint() == bitwise.fingerprint() {
+		t.Fatal("expected the lookup gadget to change the fingerprint")
+	}
+	if _, err := writeKeysFileHeader(&bytes.Buffer{}, &header); !errors.Is(err, errLookupKeccakKeys) {
+		t.Fatalf("expected the key file not to be written, got %v", err)
+	}
+}
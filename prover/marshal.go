@@ -2,14 +2,21 @@ package prover
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/consensys/gnark"
 	"github.com/consensys/gnark-crypto/ecc"
 	"github.com/consensys/gnark/backend/groth16"
+	"hash"
 	"io"
 	"math/big"
 	"os"
+	"reflect"
+	"strconv"
+	"strings"
 )
 
 func fromHex(i *big.Int, s string) error {
@@ -24,65 +31,151 @@ func toHex(i *big.Int) string {
 	return fmt.Sprintf("0x%s", i.Text(16))
 }
 
+// toHex32 formats field elements as 0x-prefixed hex padded to 32 bytes, the
+// canonical encoding clients expect.
+func toHex32(i *big.Int) string {
+	return fmt.Sprintf("0x%064x", i)
+}
+
+// NumberFormat selects how field elements are written in JSON. Both formats
+// are accepted when decoding.
+type NumberFormat string
+
+const (
+	// NumberFormatHex writes 0x-prefixed hex padded to 32 bytes.
+	NumberFormatHex NumberFormat = "hex"
+	// NumberFormatDecimal writes decimal strings, for clients that do not
+	// parse hex.
+	NumberFormatDecimal NumberFormat = "decimal"
+)
+
+// Format formats a field element, as hex unless the format is decimal.
+func (f NumberFormat) Format(i *big.Int) string {
+	if f == NumberFormatDecimal {
+		return i.String()
+	}
+	return toHex32(i)
+}
+
 type ParametersJSON struct {
-	InputHash    string     `json:"inputHash"`
+	InputHash    string     `json:"inputHash,omitempty"`
 	StartIndex   uint32     `json:"startIndex"`
 	PreRoot      string     `json:"preRoot"`
 	PostRoot     string     `json:"postRoot"`
 	IdComms      []string   `json:"identityCommitments"`
 	MerkleProofs [][]string `json:"merkleProofs"`
+	EmptyLeaf    string     `json:"emptyLeaf,omitempty"`
+	Indices      []uint32   `json:"indices,omitempty"`
+	// Frontier replaces MerkleProofs in compact requests, see
+	// Parameters.Frontier.
+	Frontier  []string `json:"frontier,omitempty"`
+	TreeDepth uint32   `json:"treeDepth,omitempty"`
 }
 
 func (p *Parameters) MarshalJSON() ([]byte, error) {
 	paramsJson := ParametersJSON{}
-	paramsJson.InputHash = toHex(&p.InputHash)
+	paramsJson.InputHash = toHex32(&p.InputHash)
 	paramsJson.StartIndex = p.StartIndex
-	paramsJson.PreRoot = toHex(&p.PreRoot)
-	paramsJson.PostRoot = toHex(&p.PostRoot)
+	paramsJson.PreRoot = toHex32(&p.PreRoot)
+	paramsJson.PostRoot = toHex32(&p.PostRoot)
 	paramsJson.IdComms = make([]string, len(p.IdComms))
 	for i := 0; i < len(p.IdComms); i++ {
-		paramsJson.IdComms[i] = toHex(&p.IdComms[i])
+		paramsJson.IdComms[i] = toHex32(&p.IdComms[i])
 	}
 	paramsJson.MerkleProofs = make([][]string, len(p.MerkleProofs))
 	for i := 0; i < len(p.MerkleProofs); i++ {
 		paramsJson.MerkleProofs[i] = make([]string, len(p.MerkleProofs[i]))
 		for j := 0; j < len(p.MerkleProofs[i]); j++ {
-			paramsJson.MerkleProofs[i][j] = toHex(&p.MerkleProofs[i][j])
+			paramsJson.MerkleProofs[i][j] = toHex32(&p.MerkleProofs[i][j])
 		}
 	}
+	if p.EmptyLeaf.Sign() != 0 {
+		paramsJson.EmptyLeaf = toHex32(&p.EmptyLeaf)
+	}
+	paramsJson.Indices = p.Indices
+	for i := range p.Frontier {
+		paramsJson.Frontier = append(paramsJson.Frontier, toHex32(&p.Frontier[i]))
+	}
+	paramsJson.TreeDepth = p.TreeDepth
 	return json.Marshal(paramsJson)
 }
 
+// maxInputHash bounds input hashes, which are 256-bit digests reduced modulo
+// the scalar field by the circuit.
+var maxInputHash = new(big.Int).Lsh(big.NewInt(1), 256)
+
+// parseParameter parses the value of a parameter field as a 0x-prefixed hex or
+// a decimal number below bound.
+func parseParameter(i *big.Int, field string, s string, bound *big.Int) error {
+	digits, base := s, 10
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		digits, base = s[2:], 16
+	}
+	if digits == "" {
+		return &ParameterError{Field: field, Value: s, Reason: "expected a 0x-prefixed hex or decimal number"}
+	}
+	for _, c := range digits {
+		if !('0' <= c && c <= '9') && !(base == 16 && ('a' <= c && c <= 'f' || 'A' <= c && c <= 'F')) {
+			return &ParameterError{Field: field, Value: s, Reason: "expected a 0x-prefixed hex or decimal number"}
+		}
+	}
+	i.SetString(digits, base)
+	if i.Cmp(bound) >= 0 {
+		return &ParameterError{Field: field, Value: s, Reason: fmt.Sprintf("must be less than %s", toHex(bound))}
+	}
+	return nil
+}
+
+// UnmarshalJSON strictly decodes parameters: unknown fields are rejected, and
+// values must be 0x-prefixed hex or decimal strings below the BN254 scalar
+// field modulus, or 2^256 for the input hash. Errors are reported as a
+// ParameterError naming the offending field, e.g. merkleProofs[3][7].
 func (p *Parameters) UnmarshalJSON(data []byte) error {
 
-	var params ParametersJSON
+	var params struct {
+		ParametersJSON
+		// Pointers tell missing fields from empty ones.
+		PreRoot  *string `json:"preRoot"`
+		PostRoot *string `json:"postRoot"`
+	}
 
-	err := json.Unmarshal(data, &params)
-	if err != nil {
-		return err
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&params); err != nil {
+		return parametersJSONError(err)
 	}
 
-	err = fromHex(&p.InputHash, params.InputHash)
-	if err != nil {
-		return err
+	field := ecc.BN254.ScalarField()
+
+	// The input hash is optional, it is computed by the prover.
+	p.InputHash.SetUint64(0)
+	if params.InputHash != "" {
+		if err := parseParameter(&p.InputHash, "inputHash", params.InputHash, maxInputHash); err != nil {
+			return err
+		}
 	}
 
 	p.StartIndex = params.StartIndex
+	p.TreeDepth = params.TreeDepth
 
-	err = fromHex(&p.PreRoot, params.PreRoot)
-	if err != nil {
+	if params.PreRoot == nil {
+		return &ParameterError{Field: "preRoot", Reason: "missing"}
+	}
+	if err := parseParameter(&p.PreRoot, "preRoot", *params.PreRoot, field); err != nil {
 		return err
 	}
 
-	err = fromHex(&p.PostRoot, params.PostRoot)
-	if err != nil {
+	if params.PostRoot == nil {
+		return &ParameterError{Field: "postRoot", Reason: "missing"}
+	}
+	if err := parseParameter(&p.PostRoot, "postRoot", *params.PostRoot, field); err != nil {
 		return err
 	}
 
 	p.IdComms = make([]big.Int, len(params.IdComms))
 	for i := 0; i < len(params.IdComms); i++ {
-		err = fromHex(&p.IdComms[i], params.IdComms[i])
-		if err != nil {
+		name := fmt.Sprintf("identityCommitments[%d]", i)
+		if err := parseParameter(&p.IdComms[i], name, params.IdComms[i], field); err != nil {
 			return err
 		}
 	}
@@ -91,8 +184,34 @@ func (p *Parameters) UnmarshalJSON(data []byte) error {
 	for i := 0; i < len(params.MerkleProofs); i++ {
 		p.MerkleProofs[i] = make([]big.Int, len(params.MerkleProofs[i]))
 		for j := 0; j < len(params.MerkleProofs[i]); j++ {
-			err = fromHex(&p.MerkleProofs[i][j], params.MerkleProofs[i][j])
-			if err != nil {
+			name := fmt.Sprintf("merkleProofs[%d][%d]", i, j)
+			if err := parseParameter(&p.MerkleProofs[i][j], name, params.MerkleProofs[i][j], field); err != nil {
+				return err
+			}
+		}
+	}
+
+	p.EmptyLeaf.SetUint64(0)
+	if params.EmptyLeaf != "" {
+		if err := parseParameter(&p.EmptyLeaf, "emptyLeaf", params.EmptyLeaf, field); err != nil {
+			return err
+		}
+	}
+
+	p.Indices = nil
+	if len(params.Indices) != 0 {
+		p.Indices = params.Indices
+	}
+
+	p.Frontier = nil
+	if len(params.Frontier) != 0 {
+		if err := checkFrontier(len(params.MerkleProofs), len(params.Indices)); err != nil {
+			return err
+		}
+		p.Frontier = make([]big.Int, len(params.Frontier))
+		for i := range params.Frontier {
+			name := fmt.Sprintf("frontier[%d]", i)
+			if err := parseParameter(&p.Frontier[i], name, params.Frontier[i], field); err != nil {
 				return err
 			}
 		}
@@ -101,32 +220,136 @@ func (p *Parameters) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// parametersJSONError turns the errors of encoding/json about a field into a
+// ParameterError.
+func parametersJSONError(err error) error {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return &ParameterError{Field: typeErr.Field, Reason: fmt.Sprintf("expected %s, got %s", jsonTypeName(typeErr.Type.Kind()), typeErr.Value)}
+	}
+	// encoding/json reports unknown fields with an unexported error type.
+	const unknownFieldPrefix = "json: unknown field "
+	if message := err.Error(); strings.HasPrefix(message, unknownFieldPrefix) {
+		name, unquoteErr := strconv.Unquote(strings.TrimPrefix(message, unknownFieldPrefix))
+		if unquoteErr == nil {
+			return &ParameterError{Field: name, Reason: "unknown field"}
+		}
+	}
+	return err
+}
+
+func jsonTypeName(kind reflect.Kind) string {
+	switch kind {
+	case reflect.String:
+		return "a string"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Uint32:
+		return "a 32-bit unsigned integer"
+	default:
+		return kind.String()
+	}
+}
+
 type ProofJSON struct {
-	Ar  [2]string    `json:"ar"`
-	Bs  [2][2]string `json:"bs"`
-	Krs [2]string    `json:"krs"`
+	Ar  [2]string    `json:"ar,omitempty"`
+	Bs  [2][2]string `json:"bs,omitempty"`
+	Krs [2]string    `json:"krs,omitempty"`
+	// Curve and Raw are used for proofs on curves other than BN254, which
+	// have no EVM verifier and are encoded as raw gnark bytes instead.
+	Curve string `json:"curve,omitempty"`
+	Raw   []byte `json:"raw,omitempty"`
+	// Compressed holds the proof with compressed points, on Curve or BN254
+	// if it is not set.
+	Compressed []byte `json:"compressed,omitempty"`
+}
+
+// ProofEncoding selects how proofs are encoded in JSON.
+type ProofEncoding string
+
+const (
+	// ProofEncodingDefault encodes BN254 proofs as hex coordinates, as the
+	// EVM verifier expects them, and proofs on other curves as raw bytes.
+	ProofEncodingDefault ProofEncoding = "default"
+	// ProofEncodingCompressed encodes proofs as their compressed points,
+	// about half the size of the default encoding.
+	ProofEncodingCompressed ProofEncoding = "compressed"
+)
+
+// ParseProofEncoding parses the name of a proof encoding, the empty name
+// selecting the default one.
+func ParseProofEncoding(name string) (ProofEncoding, error) {
+	switch encoding := ProofEncoding(name); encoding {
+	case ProofEncodingDefault, ProofEncodingCompressed:
+		return encoding, nil
+	case "":
+		return ProofEncodingDefault, nil
+	default:
+		return "", fmt.Errorf("unknown proof encoding: %s", name)
+	}
+}
+
+// Encoded returns the proof as a json.Marshaler using the given encoding and,
+// for the coordinates of the default encoding, number format.
+// Proof.UnmarshalJSON accepts all encodings.
+func (p *Proof) Encoded(encoding ProofEncoding, format NumberFormat) json.Marshaler {
+	if encoding == ProofEncodingCompressed {
+		return compressedProof{p}
+	}
+	if format == NumberFormatDecimal {
+		return decimalProof{p}
+	}
+	return p
+}
+
+type decimalProof struct {
+	*Proof
+}
+
+func (p decimalProof) MarshalJSON() ([]byte, error) {
+	return p.Proof.marshalJSON(NumberFormatDecimal)
+}
+
+type compressedProof struct {
+	*Proof
+}
+
+func (p compressedProof) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := p.Proof.Proof.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return json.Marshal(ProofJSON{Curve: p.Proof.Proof.CurveID().String(), Compressed: buf.Bytes()})
 }
 
 func (p *Proof) MarshalJSON() ([]byte, error) {
-	const fpSize = 32
+	return p.marshalJSON(NumberFormatHex)
+}
+
+func (p *Proof) marshalJSON(format NumberFormat) ([]byte, error) {
 	var buf bytes.Buffer
 	_, err := p.Proof.WriteRawTo(&buf)
 	if err != nil {
 		return nil, err
 	}
 	proofBytes := buf.Bytes()
+	if curve := p.Proof.CurveID(); curve != ecc.BN254 {
+		return json.Marshal(ProofJSON{Curve: curve.String(), Raw: proofBytes})
+	}
+
+	const fpSize = 32
 	proofJson := ProofJSON{}
-	proofHexNumbers := [8]string{}
+	proofNumbers := [8]string{}
 	for i := 0; i < 8; i++ {
-		proofHexNumbers[i] = toHex(new(big.Int).SetBytes(proofBytes[i*fpSize : (i+1)*fpSize]))
+		proofNumbers[i] = format.Format(new(big.Int).SetBytes(proofBytes[i*fpSize : (i+1)*fpSize]))
 	}
 
-	proofJson.Ar = [2]string{proofHexNumbers[0], proofHexNumbers[1]}
+	proofJson.Ar = [2]string{proofNumbers[0], proofNumbers[1]}
 	proofJson.Bs = [2][2]string{
-		{proofHexNumbers[2], proofHexNumbers[3]},
-		{proofHexNumbers[4], proofHexNumbers[5]},
+		{proofNumbers[2], proofNumbers[3]},
+		{proofNumbers[4], proofNumbers[5]},
 	}
-	proofJson.Krs = [2]string{proofHexNumbers[6], proofHexNumbers[7]}
+	proofJson.Krs = [2]string{proofNumbers[6], proofNumbers[7]}
 
 	return json.Marshal(proofJson)
 }
@@ -137,6 +360,26 @@ func (p *Proof) UnmarshalJSON(data []byte) error {
 	if err != nil {
 		return err
 	}
+	if len(proofJson.Compressed) > 0 {
+		curve := ecc.BN254
+		if proofJson.Curve != "" {
+			if curve, err = ParseCurve(proofJson.Curve); err != nil {
+				return err
+			}
+		}
+		p.Proof = groth16.NewProof(curve)
+		_, err = p.Proof.ReadFrom(bytes.NewReader(proofJson.Compressed))
+		return err
+	}
+	if proofJson.Curve != "" {
+		curve, err := ParseCurve(proofJson.Curve)
+		if err != nil {
+			return err
+		}
+		p.Proof = groth16.NewProof(curve)
+		_, err = p.Proof.ReadFrom(bytes.NewReader(proofJson.Raw))
+		return err
+	}
 	proofHexNumbers := [8]string{
 		proofJson.Ar[0],
 		proofJson.Ar[1],
@@ -157,7 +400,10 @@ func (p *Proof) UnmarshalJSON(data []byte) error {
 	const fpSize = 32
 	proofBytes := make([]byte, 8*fpSize)
 	for i := 0; i < 8; i++ {
-		copy(proofBytes[i*fpSize:(i+1)*fpSize], proofInts[i].Bytes())
+		if proofInts[i].Sign() < 0 || proofInts[i].BitLen() > 8*fpSize {
+			return fmt.Errorf("proof coordinate %d does not fit in %d bytes", i, fpSize)
+		}
+		proofInts[i].FillBytes(proofBytes[i*fpSize : (i+1)*fpSize])
 	}
 
 	p.Proof = groth16.NewProof(ecc.BN254)
@@ -169,87 +415,412 @@ func (p *Proof) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
-func (ps *ProvingSystem) WriteTo(w io.Writer) (int64, error) {
-	var totalWritten int64 = 0
-	var intBuf [4]byte
+// keysFileMagic prefixes key files that start with a JSON header. Files
+// written before the header was introduced start directly with the tree depth.
+var keysFileMagic = [4]byte{'M', 'B', 'U', 'K'}
+
+// keysFileChecksumMagic prefixes the SHA-256 digest that ends key files whose
+// header sets Checksum. The digest covers everything before the magic. In
+// these files the constraint system is prefixed with its length.
+var keysFileChecksumMagic = [4]byte{'M', 'B', 'U', 'S'}
+
+// keysFileHeader describes the circuit a key file was set up for. Files
+// written before the fingerprint and checksum were introduced omit them and
+// are loaded without verification.
+type keysFileHeader struct {
+	Curve          string `json:"curve,omitempty"`
+	TreeDepth      uint32 `json:"treeDepth"`
+	BatchSize      uint32 `json:"batchSize"`
+	PublicPostRoot bool   `json:"publicPostRoot,omitempty"`
+	EmptyLeaf      string `json:"emptyLeaf,omitempty"`
+	// Commitment is omitted for Keccak, which keeps the fingerprints of
+	// keys set up before other commitments were introduced.
+	Commitment string `json:"commitment,omitempty"`
+	// Indexed is set for circuits set up WithIndices, and likewise only
+	// fingerprinted when set.
+	Indexed bool `json:"indexed,omitempty"`
+	// NonZeroIdComms is set for circuits set up WithNonZeroIdComms, and
+	// likewise only fingerprinted when set.
+	NonZeroIdComms bool `json:"nonZeroIdComms,omitempty"`
+	// TreeHash is omitted for Poseidon, likewise.
+	TreeHash string `json:"treeHash,omitempty"`
+	// LookupKeccak is set for circuits set up WithLookupKeccak, which are
+	// fingerprinted but cannot be written to key files.
+	LookupKeccak   bool   `json:"lookupKeccak,omitempty"`
+	CircuitVersion uint32 `json:"circuitVersion,omitempty"`
+	GnarkVersion   string `json:"gnarkVersion,omitempty"`
+	Fingerprint    string `json:"fingerprint,omitempty"`
+	Checksum       string `json:"checksum,omitempty"`
+}
 
-	binary.BigEndian.PutUint32(intBuf[:], ps.TreeDepth)
-	written, err := w.Write(intBuf[:])
-	totalWritten += int64(written)
-	if err != nil {
-		return totalWritten, err
+// fingerprint hashes the fields of the header that identify the circuit.
+func (h *keysFileHeader) fingerprint() string {
+	fields := fmt.Sprintf(
+		"curve=%s;treeDepth=%d;batchSize=%d;publicPostRoot=%t;emptyLeaf=%s;circuitVersion=%d;gnarkVersion=%s",
+		h.Curve, h.TreeDepth, h.BatchSize, h.PublicPostRoot, h.EmptyLeaf, h.CircuitVersion, h.GnarkVersion,
+	)
+	if h.Commitment != "" {
+		fields += ";commitment=" + h.Commitment
+	}
+	if h.Indexed {
+		fields += ";indexed=true"
+	}
+	if h.NonZeroIdComms {
+		fields += ";nonZeroIdComms=true"
+	}
+	if h.TreeHash != "" {
+		fields += ";treeHash=" + h.TreeHash
 	}
+	if h.LookupKeccak {
+		fields += ";lookupKeccak=true"
+	}
+	digest := sha256.Sum256([]byte(fields))
+	return fmt.Sprintf("%x", digest)
+}
 
-	binary.BigEndian.PutUint32(intBuf[:], ps.BatchSize)
-	written, err = w.Write(intBuf[:])
-	totalWritten += int64(written)
-	if err != nil {
-		return totalWritten, err
+// verify checks that a fingerprinted header is intact and was written for
+// the circuit and gnark version of this prover.
+func (h *keysFileHeader) verify() error {
+	if h.Fingerprint == "" {
+		return nil
+	}
+	if h.Fingerprint != h.fingerprint() {
+		return &CorruptKeysError{Reason: "the header does not match its fingerprint"}
 	}
+	if h.CircuitVersion != CircuitVersion {
+		return &StaleKeysError{Field: "circuit version", Expected: fmt.Sprint(CircuitVersion), Actual: fmt.Sprint(h.CircuitVersion)}
+	}
+	if h.GnarkVersion != gnark.Version.String() {
+		return &StaleKeysError{Field: "gnark version", Expected: gnark.Version.String(), Actual: h.GnarkVersion}
+	}
+	return nil
+}
 
-	keyWritten, err := ps.ProvingKey.WriteTo(w)
-	totalWritten += keyWritten
-	if err != nil {
-		return totalWritten, err
+func (ps *ProvingSystem) keysFileHeader() keysFileHeader {
+	header := keysFileHeader{
+		Curve:          ps.Curve.String(),
+		TreeDepth:      ps.TreeDepth,
+		BatchSize:      ps.BatchSize,
+		PublicPostRoot: ps.PublicPostRoot,
+		Indexed:        ps.Indexed,
+		NonZeroIdComms: ps.NonZeroIdComms,
+		LookupKeccak:   ps.LookupKeccak,
+		CircuitVersion: CircuitVersion,
+		GnarkVersion:   gnark.Version.String(),
+		Checksum:       "sha256",
+	}
+	if ps.EmptyLeaf.Sign() != 0 {
+		header.EmptyLeaf = toHex(&ps.EmptyLeaf)
+	}
+	if ps.Commitment != "" && ps.Commitment != CommitmentKeccak {
+		header.Commitment = string(ps.Commitment)
 	}
+	if ps.TreeHash != "" && ps.TreeHash != TreeHashPoseidon {
+		header.TreeHash = string(ps.TreeHash)
+	}
+	header.Fingerprint = header.fingerprint()
+	return header
+}
 
-	keyWritten, err = ps.VerifyingKey.WriteTo(w)
-	totalWritten += keyWritten
+// Fingerprint identifies the circuit the proving system was set up for: its
+// shape and options, the circuit version and the gnark version. It is stored
+// in key files and checked when they are loaded.
+func (ps *ProvingSystem) Fingerprint() string {
+	header := ps.keysFileHeader()
+	return header.Fingerprint
+}
+
+func writeKeysFileHeader(w io.Writer, header *keysFileHeader) (int64, error) {
+	return writeFileHeader(w, keysFileMagic, header)
+}
+
+// writeFileHeader writes magic followed by the length-prefixed JSON header.
+func writeFileHeader(w io.Writer, magic [4]byte, header *keysFileHeader) (int64, error) {
+	if header.LookupKeccak {
+		return 0, errLookupKeccakKeys
+	}
+	headerBytes, err := json.Marshal(header)
 	if err != nil {
-		return totalWritten, err
+		return 0, err
 	}
+	var buf bytes.Buffer
+	buf.Write(magic[:])
+	var intBuf [4]byte
+	binary.BigEndian.PutUint32(intBuf[:], uint32(len(headerBytes)))
+	buf.Write(intBuf[:])
+	buf.Write(headerBytes)
+	return buf.WriteTo(w)
+}
 
-	keyWritten, err = ps.ConstraintSystem.WriteTo(w)
-	totalWritten += keyWritten
+func readKeysFileHeader(r io.Reader) (*keysFileHeader, int64, error) {
+	var totalRead int64 = 0
+	var intBuf [4]byte
+
+	read, err := io.ReadFull(r, intBuf[:])
+	totalRead += int64(read)
 	if err != nil {
-		return totalWritten, err
+		return nil, totalRead, err
+	}
+
+	if intBuf != keysFileMagic {
+		// Legacy key file: tree depth followed by batch size.
+		header := keysFileHeader{TreeDepth: binary.BigEndian.Uint32(intBuf[:])}
+		read, err = io.ReadFull(r, intBuf[:])
+		totalRead += int64(read)
+		if err != nil {
+			return nil, totalRead, err
+		}
+		header.BatchSize = binary.BigEndian.Uint32(intBuf[:])
+		return &header, totalRead, nil
 	}
 
-	return totalWritten, nil
+	header, bodyRead, err := readFileHeaderBody(r)
+	return header, totalRead + bodyRead, err
 }
 
-func (ps *ProvingSystem) UnsafeReadFrom(r io.Reader) (int64, error) {
+// readFileHeaderBody reads the length-prefixed JSON header following the
+// magic of a file.
+func readFileHeaderBody(r io.Reader) (*keysFileHeader, int64, error) {
 	var totalRead int64 = 0
 	var intBuf [4]byte
-
 	read, err := io.ReadFull(r, intBuf[:])
 	totalRead += int64(read)
 	if err != nil {
-		return totalRead, err
+		return nil, totalRead, err
 	}
-	ps.TreeDepth = binary.BigEndian.Uint32(intBuf[:])
-
-	read, err = io.ReadFull(r, intBuf[:])
+	headerBytes := make([]byte, binary.BigEndian.Uint32(intBuf[:]))
+	read, err = io.ReadFull(r, headerBytes)
 	totalRead += int64(read)
 	if err != nil {
+		return nil, totalRead, err
+	}
+	var header keysFileHeader
+	if err = json.Unmarshal(headerBytes, &header); err != nil {
+		return nil, totalRead, fmt.Errorf("invalid key file header: %w", err)
+	}
+	return &header, totalRead, nil
+}
+
+// WriteTo writes the proving system with compressed points, halving the size
+// of the file at the cost of decompressing them when it is loaded.
+func (ps *ProvingSystem) WriteTo(w io.Writer) (int64, error) {
+	return ps.writeTo(w, false)
+}
+
+// WriteRawTo writes the proving system with uncompressed points, which load
+// faster. Readers accept both encodings.
+func (ps *ProvingSystem) WriteRawTo(w io.Writer) (int64, error) {
+	return ps.writeTo(w, true)
+}
+
+func (ps *ProvingSystem) writeTo(w io.Writer, raw bool) (int64, error) {
+	kw := newKeysFileWriter(w, raw)
+	header := ps.keysFileHeader()
+	if err := kw.header(&header); err != nil {
+		return kw.written, err
+	}
+	if err := kw.key(ps.ProvingKey); err != nil {
+		return kw.written, err
+	}
+	if err := kw.key(ps.VerifyingKey); err != nil {
+		return kw.written, err
+	}
+	if err := kw.write(func(w io.Writer) (int64, error) { return writeFramed(w, ps.ConstraintSystem) }); err != nil {
+		return kw.written, err
+	}
+	err := kw.finish()
+	return kw.written, err
+}
+
+// keysFileWriter writes the sections of a key file in order, hashing them
+// for the trailing checksum.
+type keysFileWriter struct {
+	w       io.Writer
+	digest  hash.Hash
+	hashed  io.Writer
+	raw     bool
+	written int64
+}
+
+func newKeysFileWriter(w io.Writer, raw bool) *keysFileWriter {
+	digest := sha256.New()
+	return &keysFileWriter{w: w, digest: digest, hashed: io.MultiWriter(w, digest), raw: raw}
+}
+
+func (kw *keysFileWriter) write(section func(io.Writer) (int64, error)) error {
+	written, err := section(kw.hashed)
+	kw.written += written
+	return err
+}
+
+func (kw *keysFileWriter) header(header *keysFileHeader) error {
+	return kw.write(func(w io.Writer) (int64, error) { return writeKeysFileHeader(w, header) })
+}
+
+func (kw *keysFileWriter) key(key interface {
+	io.WriterTo
+	WriteRawTo(io.Writer) (int64, error)
+}) error {
+	if kw.raw {
+		return kw.write(key.WriteRawTo)
+	}
+	return kw.write(key.WriteTo)
+}
+
+func (kw *keysFileWriter) finish() error {
+	written, err := writeChecksum(kw.w, kw.digest)
+	kw.written += written
+	return err
+}
+
+// setCircuit sets the description of the circuit recorded in a header.
+func (ps *ProvingSystem) setCircuit(header *keysFileHeader) (err error) {
+	ps.Curve = ecc.BN254
+	if header.Curve != "" {
+		ps.Curve, err = ParseCurve(header.Curve)
+		if err != nil {
+			return err
+		}
+	}
+	ps.TreeDepth = header.TreeDepth
+	ps.BatchSize = header.BatchSize
+	ps.PublicPostRoot = header.PublicPostRoot
+	ps.Indexed = header.Indexed
+	ps.NonZeroIdComms = header.NonZeroIdComms
+	ps.LookupKeccak = header.LookupKeccak
+	ps.EmptyLeaf.SetUint64(0)
+	if header.EmptyLeaf != "" {
+		if err = fromHex(&ps.EmptyLeaf, header.EmptyLeaf); err != nil {
+			return err
+		}
+	}
+	if ps.Commitment, err = ParseCommitment(header.Commitment); err != nil {
+		return err
+	}
+	ps.TreeHash, err = ParseTreeHash(header.TreeHash)
+	return err
+}
+
+func (ps *ProvingSystem) UnsafeReadFrom(r io.Reader) (int64, error) {
+	digest := sha256.New()
+	hashed := io.TeeReader(r, digest)
+	header, totalRead, err := readKeysFileHeader(hashed)
+	if err != nil {
+		return totalRead, err
+	}
+	if err = header.verify(); err != nil {
+		return totalRead, err
+	}
+	if err = ps.setCircuit(header); err != nil {
 		return totalRead, err
 	}
-	ps.BatchSize = binary.BigEndian.Uint32(intBuf[:])
 
-	ps.ProvingKey = groth16.NewProvingKey(ecc.BN254)
-	keyRead, err := ps.ProvingKey.UnsafeReadFrom(r)
+	ps.ProvingKey = groth16.NewProvingKey(ps.Curve)
+	keyRead, err := ps.ProvingKey.UnsafeReadFrom(hashed)
 	totalRead += keyRead
 	if err != nil {
-		return totalRead, err
+		return totalRead, keysReadError(header, err)
 	}
 
-	ps.VerifyingKey = groth16.NewVerifyingKey(ecc.BN254)
-	keyRead, err = ps.VerifyingKey.UnsafeReadFrom(r)
+	ps.VerifyingKey = groth16.NewVerifyingKey(ps.Curve)
+	keyRead, err = ps.VerifyingKey.UnsafeReadFrom(hashed)
 	totalRead += keyRead
 	if err != nil {
-		return totalRead, err
+		return totalRead, keysReadError(header, err)
 	}
 
-	ps.ConstraintSystem = groth16.NewCS(ecc.BN254)
-	keyRead, err = ps.ConstraintSystem.ReadFrom(r)
+	ps.ConstraintSystem = groth16.NewCS(ps.Curve)
+	if header.Checksum != "" {
+		keyRead, err = readFramed(hashed, ps.ConstraintSystem)
+	} else {
+		keyRead, err = ps.ConstraintSystem.ReadFrom(hashed)
+	}
 	totalRead += keyRead
 	if err != nil {
-		return totalRead, err
+		return totalRead, keysReadError(header, err)
+	}
+
+	if header.Checksum != "" {
+		read, err := readKeysFileChecksum(r, header, digest)
+		totalRead += read
+		if err != nil {
+			return totalRead, err
+		}
 	}
 
 	return totalRead, nil
 }
 
+// writeFramed writes an object prefixed with its length, for objects whose
+// decoder reads ahead, such as constraint systems, to keep it from consuming
+// what follows.
+func writeFramed(w io.Writer, object io.WriterTo) (int64, error) {
+	var buf bytes.Buffer
+	if _, err := object.WriteTo(&buf); err != nil {
+		return 0, err
+	}
+	var lengthBuf [8]byte
+	binary.BigEndian.PutUint64(lengthBuf[:], uint64(buf.Len()))
+	written, err := w.Write(lengthBuf[:])
+	if err != nil {
+		return int64(written), err
+	}
+	framed, err := buf.WriteTo(w)
+	return int64(written) + framed, err
+}
+
+// readFramed reads an object written by writeFramed.
+func readFramed(r io.Reader, object io.ReaderFrom) (int64, error) {
+	var lengthBuf [8]byte
+	read, err := io.ReadFull(r, lengthBuf[:])
+	if err != nil {
+		return int64(read), err
+	}
+	framed, err := object.ReadFrom(io.LimitReader(r, int64(binary.BigEndian.Uint64(lengthBuf[:]))))
+	return int64(read) + framed, err
+}
+
+// keysReadError reports a key file that ends early as truncated.
+func keysReadError(header *keysFileHeader, err error) error {
+	if header.Checksum != "" && (errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)) {
+		return &CorruptKeysError{Reason: "the file is truncated"}
+	}
+	return err
+}
+
+// readKeysFileChecksum reads the trailing digest of a key file and compares
+// it with the digest of everything read before it.
+func readKeysFileChecksum(r io.Reader, header *keysFileHeader, digest hash.Hash) (int64, error) {
+	if header.Checksum != "sha256" {
+		return 0, fmt.Errorf("unsupported key file checksum: %s", header.Checksum)
+	}
+	return readChecksum(r, digest)
+}
+
+// writeChecksum ends a key file with the digest of its contents.
+func writeChecksum(w io.Writer, digest hash.Hash) (int64, error) {
+	written, err := w.Write(append(keysFileChecksumMagic[:], digest.Sum(nil)...))
+	return int64(written), err
+}
+
+// readChecksum reads the digest ending a key file and compares it with the
+// digest of everything read before it.
+func readChecksum(r io.Reader, digest hash.Hash) (int64, error) {
+	computed := digest.Sum(nil)
+	trailer := make([]byte, len(keysFileChecksumMagic)+sha256.Size)
+	read, err := io.ReadFull(r, trailer)
+	if err != nil {
+		return int64(read), &CorruptKeysError{Reason: "the file is truncated"}
+	}
+	if !bytes.Equal(trailer[:len(keysFileChecksumMagic)], keysFileChecksumMagic[:]) {
+		return int64(read), &CorruptKeysError{Reason: "the checksum is missing"}
+	}
+	if !bytes.Equal(trailer[len(keysFileChecksumMagic):], computed) {
+		return int64(read), &CorruptKeysError{Reason: "the checksum does not match its contents"}
+	}
+	return int64(read), nil
+}
+
 func ReadSystemFromFile(path string) (ps *ProvingSystem, err error) {
 	ps = new(ProvingSystem)
 	file, err := os.Open(path)
@@ -270,3 +841,32 @@ func ReadSystemFromFile(path string) (ps *ProvingSystem, err error) {
 	}
 	return
 }
+
+// ReadSystemHeaderFromFile reads the circuit a key file was set up for from
+// its header, without loading its keys, so that directories of key files can
+// be scanned quickly. The header is verified like ReadSystemFromFile does;
+// the checksum of the keys is not. Files without a fingerprinted header,
+// written before it was introduced, are rejected, as they cannot be told
+// apart from other files.
+func ReadSystemHeaderFromFile(path string) (*ProvingSystem, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	header, _, err := readKeysFileHeader(file)
+	if err != nil {
+		return nil, err
+	}
+	if header.Fingerprint == "" {
+		return nil, fmt.Errorf("%s is not a key file with a fingerprinted header", path)
+	}
+	if err = header.verify(); err != nil {
+		return nil, err
+	}
+	ps := new(ProvingSystem)
+	if err = ps.setCircuit(header); err != nil {
+		return nil, err
+	}
+	return ps, nil
+}
@@ -0,0 +1,348 @@
+package prover
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/fxamacker/cbor/v2"
+)
+
+// BinaryFormat is a binary alternative to JSON for parameters and proofs, in
+// which field elements are canonical big-endian 32-byte strings rather than
+// hex. Large batches encode in about a third of the bytes of JSON and decode
+// without parsing numbers.
+type BinaryFormat string
+
+const (
+	// BinaryFormatCBOR is CBOR (RFC 8949), encoded deterministically.
+	BinaryFormatCBOR BinaryFormat = "cbor"
+	// BinaryFormatMsgpack is MessagePack, with the same maps as CBOR.
+	BinaryFormatMsgpack BinaryFormat = "msgpack"
+)
+
+// ContentType returns the media type of the format.
+func (f BinaryFormat) ContentType() string {
+	return "application/" + string(f)
+}
+
+// ParseBinaryContentType returns the binary format of a media type, or false
+// if it is not one. application/x-msgpack and application/vnd.msgpack are
+// accepted for MessagePack.
+func ParseBinaryContentType(contentType string) (BinaryFormat, bool) {
+	switch strings.ToLower(contentType) {
+	case "application/cbor":
+		return BinaryFormatCBOR, true
+	case "application/msgpack", "application/x-msgpack", "application/vnd.msgpack":
+		return BinaryFormatMsgpack, true
+	default:
+		return "", false
+	}
+}
+
+var (
+	cborEncMode, _ = cbor.CoreDetEncOptions().EncMode()
+	// cborDecMode rejects the unknown fields and duplicate keys of structs,
+	// and decodes maps of unknown types with string keys as MessagePack
+	// does.
+	cborDecMode, _ = cbor.DecOptions{
+		DupMapKey:         cbor.DupMapKeyEnforcedAPF,
+		IndefLength:       cbor.IndefLengthForbidden,
+		TagsMd:            cbor.TagsForbidden,
+		DefaultMapType:    reflect.TypeOf(map[string]interface{}(nil)),
+		ExtraReturnErrors: cbor.ExtraDecErrorUnknownField,
+	}.DecMode()
+)
+
+// Marshal encodes v in the format. Values implementing cbor.Marshaler, such
+// as Parameters and Proof, are encoded as in CBOR.
+func (f BinaryFormat) Marshal(v interface{}) ([]byte, error) {
+	data, err := cborEncMode.Marshal(v)
+	if err != nil || f != BinaryFormatMsgpack {
+		return data, err
+	}
+	var value interface{}
+	if err = cborDecMode.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err = encodeMsgpack(&buf, value); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode decodes data encoded in the format without a type, into maps with
+// string keys, slices, byte strings and scalars, so that the shape of a value
+// can be checked before it is decoded.
+func (f BinaryFormat) Decode(data []byte) (interface{}, error) {
+	if f == BinaryFormatMsgpack {
+		return decodeMsgpack(data)
+	}
+	var value interface{}
+	if err := cborDecMode.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// Unmarshal decodes data encoded in the format into v.
+func (f BinaryFormat) Unmarshal(data []byte, v interface{}) error {
+	if f == BinaryFormatMsgpack {
+		value, err := decodeMsgpack(data)
+		if err != nil {
+			return err
+		}
+		if data, err = cborEncMode.Marshal(value); err != nil {
+			return err
+		}
+	}
+	return cborDecMode.Unmarshal(data, v)
+}
+
+// ParametersBinary is the binary encoding of Parameters, with the fields of
+// ParametersJSON.
+type ParametersBinary struct {
+	InputHash    []byte     `cbor:"inputHash,omitempty"`
+	StartIndex   uint32     `cbor:"startIndex"`
+	PreRoot      []byte     `cbor:"preRoot"`
+	PostRoot     []byte     `cbor:"postRoot"`
+	IdComms      [][]byte   `cbor:"identityCommitments"`
+	MerkleProofs [][][]byte `cbor:"merkleProofs"`
+	EmptyLeaf    []byte     `cbor:"emptyLeaf,omitempty"`
+	Indices      []uint32   `cbor:"indices,omitempty"`
+	Frontier     [][]byte   `cbor:"frontier,omitempty"`
+	TreeDepth    uint32     `cbor:"treeDepth,omitempty"`
+}
+
+// fieldSize is the size of the canonical encoding of BN254 field elements.
+const fieldSize = 32
+
+func toBytes32(i *big.Int) []byte {
+	return i.FillBytes(make([]byte, fieldSize))
+}
+
+// parseBinaryParameter parses the canonical encoding of a field element below
+// bound.
+func parseBinaryParameter(i *big.Int, field string, b []byte, bound *big.Int) error {
+	if len(b) != fieldSize {
+		return &ParameterError{Field: field, Reason: fmt.Sprintf("expected %d big-endian bytes, got %d", fieldSize, len(b))}
+	}
+	i.SetBytes(b)
+	if i.Cmp(bound) >= 0 {
+		return &ParameterError{Field: field, Value: toHex32(i), Reason: fmt.Sprintf("must be less than %s", toHex(bound))}
+	}
+	return nil
+}
+
+func (p *Parameters) MarshalCBOR() ([]byte, error) {
+	params := ParametersBinary{
+		InputHash:  toBytes32(&p.InputHash),
+		StartIndex: p.StartIndex,
+		PreRoot:    toBytes32(&p.PreRoot),
+		PostRoot:   toBytes32(&p.PostRoot),
+		IdComms:    make([][]byte, len(p.IdComms)),
+		Indices:    p.Indices,
+		TreeDepth:  p.TreeDepth,
+	}
+	for i := range p.IdComms {
+		params.IdComms[i] = toBytes32(&p.IdComms[i])
+	}
+	params.MerkleProofs = make([][][]byte, len(p.MerkleProofs))
+	for i := range p.MerkleProofs {
+		params.MerkleProofs[i] = make([][]byte, len(p.MerkleProofs[i]))
+		for j := range p.MerkleProofs[i] {
+			params.MerkleProofs[i][j] = toBytes32(&p.MerkleProofs[i][j])
+		}
+	}
+	if p.EmptyLeaf.Sign() != 0 {
+		params.EmptyLeaf = toBytes32(&p.EmptyLeaf)
+	}
+	for i := range p.Frontier {
+		params.Frontier = append(params.Frontier, toBytes32(&p.Frontier[i]))
+	}
+	return cborEncMode.Marshal(params)
+}
+
+// UnmarshalCBOR strictly decodes parameters as UnmarshalJSON does, except
+// that field elements must be 32 big-endian bytes.
+func (p *Parameters) UnmarshalCBOR(data []byte) error {
+	var params ParametersBinary
+	if err := cborDecMode.Unmarshal(data, &params); err != nil {
+		return parametersCBORError(err)
+	}
+
+	field := ecc.BN254.ScalarField()
+
+	p.InputHash.SetUint64(0)
+	if params.InputHash != nil {
+		if err := parseBinaryParameter(&p.InputHash, "inputHash", params.InputHash, maxInputHash); err != nil {
+			return err
+		}
+	}
+
+	p.StartIndex = params.StartIndex
+	p.TreeDepth = params.TreeDepth
+
+	if params.PreRoot == nil {
+		return &ParameterError{Field: "preRoot", Reason: "missing"}
+	}
+	if err := parseBinaryParameter(&p.PreRoot, "preRoot", params.PreRoot, field); err != nil {
+		return err
+	}
+
+	if params.PostRoot == nil {
+		return &ParameterError{Field: "postRoot", Reason: "missing"}
+	}
+	if err := parseBinaryParameter(&p.PostRoot, "postRoot", params.PostRoot, field); err != nil {
+		return err
+	}
+
+	p.IdComms = make([]big.Int, len(params.IdComms))
+	for i := range params.IdComms {
+		name := fmt.Sprintf("identityCommitments[%d]", i)
+		if err := parseBinaryParameter(&p.IdComms[i], name, params.IdComms[i], field); err != nil {
+			return err
+		}
+	}
+
+	p.MerkleProofs = make([][]big.Int, len(params.MerkleProofs))
+	for i := range params.MerkleProofs {
+		p.MerkleProofs[i] = make([]big.Int, len(params.MerkleProofs[i]))
+		for j := range params.MerkleProofs[i] {
+			name := fmt.Sprintf("merkleProofs[%d][%d]", i, j)
+			if err := parseBinaryParameter(&p.MerkleProofs[i][j], name, params.MerkleProofs[i][j], field); err != nil {
+				return err
+			}
+		}
+	}
+
+	p.EmptyLeaf.SetUint64(0)
+	if params.EmptyLeaf != nil {
+		if err := parseBinaryParameter(&p.EmptyLeaf, "emptyLeaf", params.EmptyLeaf, field); err != nil {
+			return err
+		}
+	}
+
+	p.Indices = nil
+	if len(params.Indices) != 0 {
+		p.Indices = params.Indices
+	}
+
+	p.Frontier = nil
+	if len(params.Frontier) != 0 {
+		if err := checkFrontier(len(params.MerkleProofs), len(params.Indices)); err != nil {
+			return err
+		}
+		p.Frontier = make([]big.Int, len(params.Frontier))
+		for i := range params.Frontier {
+			name := fmt.Sprintf("frontier[%d]", i)
+			if err := parseBinaryParameter(&p.Frontier[i], name, params.Frontier[i], field); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// parametersCBORError turns the type errors of the CBOR decoder, which name
+// the struct and its field, into a ParameterError.
+func parametersCBORError(err error) error {
+	var typeErr *cbor.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.StructFieldName != "" {
+		name := typeErr.StructFieldName[strings.LastIndex(typeErr.StructFieldName, ".")+1:]
+		return &ParameterError{Field: name, Reason: fmt.Sprintf("expected %s, got %s", typeErr.GoType, typeErr.CBORType)}
+	}
+	return err
+}
+
+// ProofBinary is the binary encoding of proofs, with the fields of ProofJSON.
+// Coordinates are 32 big-endian bytes.
+type ProofBinary struct {
+	Ar         [][]byte   `cbor:"ar,omitempty"`
+	Bs         [][][]byte `cbor:"bs,omitempty"`
+	Krs        [][]byte   `cbor:"krs,omitempty"`
+	Curve      string     `cbor:"curve,omitempty"`
+	Raw        []byte     `cbor:"raw,omitempty"`
+	Compressed []byte     `cbor:"compressed,omitempty"`
+}
+
+// Binary returns the binary encoding of the proof in the given proof
+// encoding.
+func (p *Proof) Binary(encoding ProofEncoding) (*ProofBinary, error) {
+	var buf bytes.Buffer
+	curve := p.Proof.CurveID()
+	if encoding == ProofEncodingCompressed {
+		if _, err := p.Proof.WriteTo(&buf); err != nil {
+			return nil, err
+		}
+		return &ProofBinary{Curve: curve.String(), Compressed: buf.Bytes()}, nil
+	}
+	if _, err := p.Proof.WriteRawTo(&buf); err != nil {
+		return nil, err
+	}
+	proofBytes := buf.Bytes()
+	if curve != ecc.BN254 {
+		return &ProofBinary{Curve: curve.String(), Raw: proofBytes}, nil
+	}
+	coordinate := func(i int) []byte {
+		return proofBytes[i*fieldSize : (i+1)*fieldSize]
+	}
+	return &ProofBinary{
+		Ar:  [][]byte{coordinate(0), coordinate(1)},
+		Bs:  [][][]byte{{coordinate(2), coordinate(3)}, {coordinate(4), coordinate(5)}},
+		Krs: [][]byte{coordinate(6), coordinate(7)},
+	}, nil
+}
+
+func (p *Proof) MarshalCBOR() ([]byte, error) {
+	proof, err := p.Binary(ProofEncodingDefault)
+	if err != nil {
+		return nil, err
+	}
+	return cborEncMode.Marshal(proof)
+}
+
+// UnmarshalCBOR decodes proofs in all encodings.
+func (p *Proof) UnmarshalCBOR(data []byte) error {
+	var proof ProofBinary
+	if err := cborDecMode.Unmarshal(data, &proof); err != nil {
+		return err
+	}
+	if len(proof.Compressed) > 0 || proof.Curve != "" {
+		curve := ecc.BN254
+		if proof.Curve != "" {
+			var err error
+			if curve, err = ParseCurve(proof.Curve); err != nil {
+				return err
+			}
+		}
+		encoded := proof.Raw
+		if len(proof.Compressed) > 0 {
+			encoded = proof.Compressed
+		}
+		p.Proof = groth16.NewProof(curve)
+		_, err := p.Proof.ReadFrom(bytes.NewReader(encoded))
+		return err
+	}
+	if len(proof.Ar) != 2 || len(proof.Bs) != 2 || len(proof.Bs[0]) != 2 || len(proof.Bs[1]) != 2 || len(proof.Krs) != 2 {
+		return errors.New("invalid proof: expected the coordinates of ar, bs and krs")
+	}
+	coordinates := [8][]byte{proof.Ar[0], proof.Ar[1], proof.Bs[0][0], proof.Bs[0][1], proof.Bs[1][0], proof.Bs[1][1], proof.Krs[0], proof.Krs[1]}
+	proofBytes := make([]byte, 0, 8*fieldSize)
+	for _, coordinate := range coordinates {
+		if len(coordinate) != fieldSize {
+			return fmt.Errorf("invalid proof: expected coordinates of %d bytes, got %d", fieldSize, len(coordinate))
+		}
+		proofBytes = append(proofBytes, coordinate...)
+	}
+	p.Proof = groth16.NewProof(ecc.BN254)
+	_, err := p.Proof.ReadFrom(bytes.NewReader(proofBytes))
+	return err
+}
@@ -0,0 +1,146 @@
+package prover
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/frontend"
+)
+
+var binaryFormats = []BinaryFormat{BinaryFormatCBOR, BinaryFormatMsgpack}
+
+func TestParametersBinaryRoundTrip(t *testing.T) {
+	params := testParameters()
+	params.Indices = []uint32{3, 9}
+	params.TreeDepth = testTreeDepth
+	jsonBytes, err := json.Marshal(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, format := range binaryFormats {
+		encoded, err := format.Marshal(params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(encoded) >= len(jsonBytes) {
+			t.Errorf("expected %s to be smaller than JSON, got %d bytes against %d", format, len(encoded), len(jsonBytes))
+		}
+		var decoded Parameters
+		if err = format.Unmarshal(encoded, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		decodedJSON, err := json.Marshal(&decoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decodedJSON, jsonBytes) {
+			t.Fatalf("expected the %s parameters to round trip, got %s", format, decodedJSON)
+		}
+	}
+}
+
+func TestUnmarshalBinaryStrict(t *testing.T) {
+	element := func(b byte) []byte {
+		e := make([]byte, 32)
+		e[31] = b
+		return e
+	}
+	modulus := ecc.BN254.ScalarField().FillBytes(make([]byte, 32))
+	for _, format := range binaryFormats {
+		for field, body := range map[string]map[string]interface{}{
+			"extra":                  {"preRoot": element(1), "postRoot": element(2), "extra": 1},
+			"preRoot":                {"postRoot": element(2)},
+			"postRoot":               {"preRoot": element(1), "postRoot": modulus},
+			"identityCommitments[1]": {"preRoot": element(1), "postRoot": element(2), "identityCommitments": [][]byte{element(1), {1}}},
+			"merkleProofs[1][0]":     {"preRoot": element(1), "postRoot": element(2), "merkleProofs": [][][]byte{{element(1)}, {append(element(1), 0)}}},
+			"startIndex":             {"preRoot": element(1), "postRoot": element(2), "startIndex": "3"},
+		} {
+			encoded, err := format.Marshal(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var params Parameters
+			err = format.Unmarshal(encoded, &params)
+			var paramErr *ParameterError
+			if field == "extra" {
+				if err == nil {
+					t.Errorf("%s: expected the unknown field to be rejected", format)
+				}
+			} else if !errors.As(err, &paramErr) || paramErr.Field != field {
+				t.Errorf("%s: expected an error for %s, got %v", format, field, err)
+			}
+		}
+	}
+}
+
+func TestMsgpackCodec(t *testing.T) {
+	// {"a": [1, -1, -200, "x", nil, true], "b": bin 0x01ff}
+	encoded := []byte{0x82, 0xa1, 'a', 0x96, 0x01, 0xff, 0xd1, 0xff, 0x38, 0xa1, 'x', 0xc0, 0xc3, 0xa1, 'b', 0xc4, 0x02, 0x01, 0xff}
+	value, err := decodeMsgpack(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"a": []interface{}{uint64(1), int64(-1), int64(-200), "x", nil, true},
+		"b": []byte{0x01, 0xff},
+	}
+	if !reflect.DeepEqual(value, expected) {
+		t.Fatalf("unexpected value %#v", value)
+	}
+	var buf bytes.Buffer
+	if err = encodeMsgpack(&buf, value); err != nil || !bytes.Equal(buf.Bytes(), encoded) {
+		t.Fatalf("expected the value to encode back, got %x, %v", buf.Bytes(), err)
+	}
+
+	for _, invalid := range [][]byte{
+		{0x92, 0x01},                   // truncated array
+		{0xdd, 0xff, 0xff, 0xff, 0xff}, // length beyond the data
+		{0x81, 0x01, 0x01},             // integer key
+		{0xc7, 0x01, 0x01, 0x01},       // extension
+		{0x01, 0x02},                   // trailing data
+	} {
+		if _, err = decodeMsgpack(invalid); err == nil {
+			t.Errorf("expected %x to be rejected", invalid)
+		}
+	}
+}
+
+func TestProofBinaryEncodings(t *testing.T) {
+	ps := smallProvingSystem(t)
+	witness, err := frontend.NewWitness(&squareCircuit{X: 3, Y: 9}, ecc.BN254.ScalarField())
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof, err := groth16.Prove(ps.ConstraintSystem, ps.ProvingKey, witness)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicWitness, err := witness.Public()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, format := range binaryFormats {
+		for _, encoding := range []ProofEncoding{ProofEncodingDefault, ProofEncodingCompressed} {
+			binaryProof, err := (&Proof{proof}).Binary(encoding)
+			if err != nil {
+				t.Fatal(err)
+			}
+			encoded, err := format.Marshal(binaryProof)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var decoded Proof
+			if err = format.Unmarshal(encoded, &decoded); err != nil {
+				t.Fatal(err)
+			}
+			if err = groth16.Verify(decoded.Proof, ps.VerifyingKey, publicWitness); err != nil {
+				t.Fatalf("expected the decoded %s %s proof to verify, got %v", format, encoding, err)
+			}
+		}
+	}
+}
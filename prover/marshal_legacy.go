@@ -0,0 +1,79 @@
+package prover
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+)
+
+// LegacyParametersJSON is the dialect emitted by older signup-sequencer
+// releases: snake_case field names, field elements as decimal strings and
+// each identity commitment nested together with its Merkle proof.
+type LegacyParametersJSON struct {
+	InputHash  string               `json:"input_hash,omitempty"`
+	StartIndex uint32               `json:"start_index"`
+	PreRoot    string               `json:"pre_root"`
+	PostRoot   string               `json:"post_root"`
+	Identities []LegacyIdentityJSON `json:"identities"`
+}
+
+type LegacyIdentityJSON struct {
+	Commitment  string   `json:"commitment"`
+	MerkleProof []string `json:"merkle_proof"`
+}
+
+func fromDecimal(i *big.Int, s string) error {
+	_, ok := i.SetString(s, 10)
+	if !ok {
+		return fmt.Errorf("invalid decimal number: %s", s)
+	}
+	return nil
+}
+
+// UnmarshalLegacyJSON decodes parameters in the legacy sequencer dialect.
+func (p *Parameters) UnmarshalLegacyJSON(data []byte) error {
+	var params LegacyParametersJSON
+	err := json.Unmarshal(data, &params)
+	if err != nil {
+		return err
+	}
+
+	p.InputHash.SetUint64(0)
+	if params.InputHash != "" {
+		err = fromDecimal(&p.InputHash, params.InputHash)
+		if err != nil {
+			return err
+		}
+	}
+
+	p.StartIndex = params.StartIndex
+
+	err = fromDecimal(&p.PreRoot, params.PreRoot)
+	if err != nil {
+		return err
+	}
+
+	err = fromDecimal(&p.PostRoot, params.PostRoot)
+	if err != nil {
+		return err
+	}
+
+	p.IdComms = make([]big.Int, len(params.Identities))
+	p.MerkleProofs = make([][]big.Int, len(params.Identities))
+	for i, identity := range params.Identities {
+		err = fromDecimal(&p.IdComms[i], identity.Commitment)
+		if err != nil {
+			return err
+		}
+		p.MerkleProofs[i] = make([]big.Int, len(identity.MerkleProof))
+		for j, node := range identity.MerkleProof {
+			err = fromDecimal(&p.MerkleProofs[i][j], node)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	p.EmptyLeaf.SetUint64(0)
+	return nil
+}
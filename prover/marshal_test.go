@@ -0,0 +1,351 @@
+package prover
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+)
+
+func TestKeysFileHeaderRoundTrip(t *testing.T) {
+	header := keysFileHeader{Curve: "bls12_381", TreeDepth: 20, BatchSize: 100, PublicPostRoot: true}
+	var buf bytes.Buffer
+	written, err := writeKeysFileHeader(&buf, &header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, readBytes, err := readKeysFileHeader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if readBytes != written {
+		t.Fatalf("read %d bytes, written %d", readBytes, written)
+	}
+	if *read != header {
+		t.Fatalf("expected %+v, got %+v", header, *read)
+	}
+}
+
+func TestLegacyKeysFileHeader(t *testing.T) {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint32(20))
+	binary.Write(&buf, binary.BigEndian, uint32(100))
+	header, _, err := readKeysFileHeader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.TreeDepth != 20 || header.BatchSize != 100 || header.PublicPostRoot || header.Curve != "" {
+		t.Fatalf("unexpected legacy header %+v", *header)
+	}
+}
+
+func TestUnmarshalLegacyJSON(t *testing.T) {
+	expected := testParameters()
+	legacy := LegacyParametersJSON{
+		InputHash:  expected.InputHash.String(),
+		StartIndex: expected.StartIndex,
+		PreRoot:    expected.PreRoot.String(),
+		PostRoot:   expected.PostRoot.String(),
+	}
+	for i := range expected.IdComms {
+		identity := LegacyIdentityJSON{Commitment: expected.IdComms[i].String()}
+		for _, node := range expected.MerkleProofs[i] {
+			identity.MerkleProof = append(identity.MerkleProof, node.String())
+		}
+		legacy.Identities = append(legacy.Identities, identity)
+	}
+	data, err := json.Marshal(&legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var params Parameters
+	if err = params.UnmarshalLegacyJSON(data); err != nil {
+		t.Fatal(err)
+	}
+	canonical, _ := json.Marshal(&params)
+	expectedCanonical, _ := json.Marshal(expected)
+	if !bytes.Equal(canonical, expectedCanonical) {
+		t.Fatalf("expected %s, got %s", expectedCanonical, canonical)
+	}
+
+	if err = params.UnmarshalLegacyJSON([]byte(`{"pre_root":"0x1","post_root":"1"}`)); err == nil {
+		t.Fatal("expected hex strings to be rejected in the legacy dialect")
+	}
+}
+
+type squareCircuit struct {
+	X frontend.Variable
+	Y frontend.Variable `gnark:",public"`
+}
+
+func (circuit *squareCircuit) Define(api frontend.API) error {
+	api.AssertIsEqual(api.Mul(circuit.X, circuit.X), circuit.Y)
+	return nil
+}
+
+// smallProvingSystem sets up keys for a tiny circuit, which suffices to
+// exercise the key file format without the cost of setting up MbuCircuit.
+func smallProvingSystem(t *testing.T) *ProvingSystem {
+	cs, err := frontend.Compile(ecc.BN254.ScalarField(), r1cs.NewBuilder, &squareCircuit{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, vk, err := groth16.Setup(cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &ProvingSystem{Curve: ecc.BN254, TreeDepth: 20, BatchSize: 100, ProvingKey: pk, VerifyingKey: vk, ConstraintSystem: cs}
+}
+
+func TestKeysFileChecksum(t *testing.T) {
+	ps := smallProvingSystem(t)
+	var buf bytes.Buffer
+	written, err := ps.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keysFile := buf.Bytes()
+
+	var read ProvingSystem
+	readBytes, err := read.UnsafeReadFrom(bytes.NewReader(keysFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if readBytes != written {
+		t.Fatalf("read %d bytes, written %d", readBytes, written)
+	}
+	if read.Fingerprint() != ps.Fingerprint() {
+		t.Fatal("expected the fingerprint to survive a round trip")
+	}
+
+	var corrupt *CorruptKeysError
+	_, err = new(ProvingSystem).UnsafeReadFrom(bytes.NewReader(keysFile[:len(keysFile)-10]))
+	if !errors.As(err, &corrupt) {
+		t.Fatalf("expected a truncated file to be rejected, got %v", err)
+	}
+
+	flipped := bytes.Clone(keysFile)
+	flipped[len(flipped)-1] ^= 1
+	_, err = new(ProvingSystem).UnsafeReadFrom(bytes.NewReader(flipped))
+	if !errors.As(err, &corrupt) {
+		t.Fatalf("expected a checksum mismatch to be rejected, got %v", err)
+	}
+}
+
+func TestKeysFileHeaderVerify(t *testing.T) {
+	ps := &ProvingSystem{Curve: ecc.BN254, TreeDepth: 20, BatchSize: 100}
+	header := ps.keysFileHeader()
+	if err := header.verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := header
+	tampered.BatchSize = 10
+	var corrupt *CorruptKeysError
+	if err := tampered.verify(); !errors.As(err, &corrupt) {
+		t.Fatalf("expected a tampered header to be rejected, got %v", err)
+	}
+
+	stale := header
+	stale.CircuitVersion = CircuitVersion - 1
+	stale.Fingerprint = stale.fingerprint()
+	var staleErr *StaleKeysError
+	if err := stale.verify(); !errors.As(err, &staleErr) {
+		t.Fatalf("expected keys for an earlier circuit version to be rejected, got %v", err)
+	}
+
+	stale = header
+	stale.GnarkVersion = "0.7.0"
+	stale.Fingerprint = stale.fingerprint()
+	if err := stale.verify(); !errors.As(err, &staleErr) {
+		t.Fatalf("expected keys for another gnark version to be rejected, got %v", err)
+	}
+}
+
+func TestRawKeysFile(t *testing.T) {
+	ps := smallProvingSystem(t)
+	var compressed, raw bytes.Buffer
+	if _, err := ps.WriteTo(&compressed); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ps.WriteRawTo(&raw); err != nil {
+		t.Fatal(err)
+	}
+	if raw.Len() <= compressed.Len() {
+		t.Fatalf("expected raw keys to be larger, got %d raw and %d compressed bytes", raw.Len(), compressed.Len())
+	}
+
+	var read ProvingSystem
+	if _, err := read.UnsafeReadFrom(&raw); err != nil {
+		t.Fatal(err)
+	}
+	if read.Fingerprint() != ps.Fingerprint() {
+		t.Fatal("expected the fingerprint to survive a round trip")
+	}
+}
+
+func TestProofEncodings(t *testing.T) {
+	ps := smallProvingSystem(t)
+	assignment := &squareCircuit{X: 3, Y: 9}
+	witness, err := frontend.NewWitness(assignment, ecc.BN254.ScalarField())
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof, err := groth16.Prove(ps.ConstraintSystem, ps.ProvingKey, witness)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicWitness, err := witness.Public()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []NumberFormat{NumberFormatHex, NumberFormatDecimal} {
+		for _, encoding := range []ProofEncoding{ProofEncodingDefault, ProofEncodingCompressed} {
+			encoded, err := json.Marshal((&Proof{proof}).Encoded(encoding, format))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var decoded Proof
+			if err = json.Unmarshal(encoded, &decoded); err != nil {
+				t.Fatal(err)
+			}
+			if err = groth16.Verify(decoded.Proof, ps.VerifyingKey, publicWitness); err != nil {
+				t.Fatalf("expected the decoded %s %s proof to verify, got %v", encoding, format, err)
+			}
+		}
+	}
+
+	if _, err = ParseProofEncoding("base58"); err == nil {
+		t.Fatal("expected an unknown encoding to be rejected")
+	}
+}
+
+func TestProofUnmarshalJSONRange(t *testing.T) {
+	coordinates := func(first string) string {
+		return fmt.Sprintf(`{"ar":["%s","0x1"],"bs":[["0x1","0x1"],["0x1","0x1"]],"krs":["0x1","0x1"]}`, first)
+	}
+	for _, first := range []string{"0x1" + strings.Repeat("0", 64), "-0x1"} {
+		var proof Proof
+		err := json.Unmarshal([]byte(coordinates(first)), &proof)
+		if err == nil || !strings.Contains(err.Error(), "does not fit") {
+			t.Fatalf("expected the coordinate %s to be rejected, got %v", first, err)
+		}
+	}
+}
+
+func TestKeysFileCommitment(t *testing.T) {
+	ps := smallProvingSystem(t)
+	keccakFingerprint := ps.Fingerprint()
+	ps.Commitment = CommitmentPoseidon
+	if ps.Fingerprint() == keccakFingerprint {
+		t.Fatal("expected the commitment to change the fingerprint")
+	}
+	var buf bytes.Buffer
+	if _, err := ps.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var read ProvingSystem
+	if _, err := read.UnsafeReadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if read.Commitment != CommitmentPoseidon {
+		t.Fatalf("expected the poseidon commitment, got %s", read.Commitment)
+	}
+}
+
+func TestKeysFileIndexed(t *testing.T) {
+	ps := smallProvingSystem(t)
+	fingerprint := ps.Fingerprint()
+	ps.Indexed = true
+	if ps.Fingerprint() == fingerprint {
+		t.Fatal("expected indices to change the fingerprint")
+	}
+	var buf bytes.Buffer
+	if _, err := ps.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var read ProvingSystem
+	if _, err := read.UnsafeReadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !read.Indexed {
+		t.Fatal("expected the circuit to be indexed")
+	}
+
+	params := indexedParameters()
+	encoded, err := json.Marshal(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Parameters
+	if err = json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded.Indices, params.Indices) {
+		t.Fatalf("expected indices %v, got %v", params.Indices, decoded.Indices)
+	}
+}
+
+func TestUnmarshalJSONStrict(t *testing.T) {
+	valid, err := json.Marshal(testParameters())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var params Parameters
+	if err = json.Unmarshal(valid, &params); err != nil {
+		t.Fatal(err)
+	}
+	if err = json.Unmarshal([]byte(`{"preRoot":"12","postRoot":"0x0C"}`), &params); err != nil {
+		t.Fatalf("expected decimal and hex values to be accepted, got %v", err)
+	}
+
+	modulus := ecc.BN254.ScalarField().String()
+	for body, field := range map[string]string{
+		`{"preRoot":"0x1","postRoot":"0x2","extra":1}`:                                        "extra",
+		`{"postRoot":"0x2"}`:                                                                  "preRoot",
+		`{"preRoot":"0o7","postRoot":"0x2"}`:                                                  "preRoot",
+		`{"preRoot":"1_000","postRoot":"0x2"}`:                                                "preRoot",
+		`{"preRoot":"-1","postRoot":"0x2"}`:                                                   "preRoot",
+		`{"preRoot":"0x1","postRoot":"` + modulus + `"}`:                                      "postRoot",
+		`{"preRoot":"0x1","postRoot":"0x2","startIndex":"3"}`:                                 "startIndex",
+		`{"preRoot":"0x1","postRoot":"0x2","identityCommitments":["0x1","0xg"]}`:              "identityCommitments[1]",
+		`{"preRoot":"0x1","postRoot":"0x2","merkleProofs":[["0x1"],["0x2","0x"]]}`:            "merkleProofs[1][1]",
+		`{"preRoot":"0x1","postRoot":"0x2","inputHash":"0x1` + strings.Repeat("0", 64) + `"}`: "inputHash",
+	} {
+		var paramErr *ParameterError
+		if err = json.Unmarshal([]byte(body), &params); !errors.As(err, &paramErr) || paramErr.Field != field {
+			t.Errorf("%s: expected an error for %s, got %v", body, field, err)
+		}
+	}
+}
+
+func TestParametersFixedWidthHex(t *testing.T) {
+	encoded, err := json.Marshal(testParameters())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var params ParametersJSON
+	if err = json.Unmarshal(encoded, &params); err != nil {
+		t.Fatal(err)
+	}
+	values := append([]string{params.InputHash, params.PreRoot, params.PostRoot}, params.IdComms...)
+	for _, proof := range params.MerkleProofs {
+		values = append(values, proof...)
+	}
+	for _, value := range values {
+		if len(value) != 66 || !strings.HasPrefix(value, "0x") {
+			t.Fatalf("expected 32-byte 0x-padded hex, got %s", value)
+		}
+	}
+}
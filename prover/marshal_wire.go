@@ -0,0 +1,163 @@
+package prover
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend/groth16"
+)
+
+// ProofFormat is a wire format of proofs for verifiers not written in Go.
+// Unlike the JSON and binary encodings, wire formats hold the proof alone.
+type ProofFormat string
+
+const (
+	// ProofFormatRaw is the raw gnark encoding of the proof, with
+	// uncompressed points.
+	ProofFormatRaw ProofFormat = "raw"
+	// ProofFormatEVM is the JSON array of the 8 uint256 the Solidity
+	// verifier takes, for BN254 proofs.
+	ProofFormatEVM ProofFormat = "evm"
+	// ProofFormatSnarkJS is the proof JSON of snarkjs, for BN254 proofs.
+	ProofFormatSnarkJS ProofFormat = "snarkjs"
+	// ProofFormatBase64 is the base64 of the compressed gnark encoding of
+	// the proof.
+	ProofFormatBase64 ProofFormat = "base64"
+)
+
+var proofFormatContentTypes = map[ProofFormat]string{
+	ProofFormatRaw:     "application/octet-stream",
+	ProofFormatEVM:     "application/vnd.gnark-mbu.evm+json",
+	ProofFormatSnarkJS: "application/vnd.snarkjs+json",
+	ProofFormatBase64:  "text/plain",
+}
+
+// ParseProofFormat parses the name of a proof wire format.
+func ParseProofFormat(name string) (ProofFormat, error) {
+	format := ProofFormat(name)
+	if _, ok := proofFormatContentTypes[format]; !ok {
+		return "", fmt.Errorf("unknown proof format: %s", name)
+	}
+	return format, nil
+}
+
+// ParseProofContentType returns the proof wire format of a media type.
+func ParseProofContentType(mediaType string) (ProofFormat, bool) {
+	for format, contentType := range proofFormatContentTypes {
+		if contentType == mediaType {
+			return format, true
+		}
+	}
+	return "", false
+}
+
+// ContentType returns the media type of the format.
+func (f ProofFormat) ContentType() string {
+	return proofFormatContentTypes[f]
+}
+
+// CheckCurve returns an error if proofs on curve cannot be encoded in the
+// format: the EVM and snarkjs formats only encode BN254 proofs.
+func (f ProofFormat) CheckCurve(curve ecc.ID) error {
+	if (f == ProofFormatEVM || f == ProofFormatSnarkJS) && curve != ecc.BN254 {
+		return fmt.Errorf("%s proofs cannot be encoded in the %s format", curve, f)
+	}
+	return nil
+}
+
+// SnarkJSProof is a BN254 proof as snarkjs writes it: decimal projective
+// coordinates, with the coefficients of G2 coordinates in ascending order.
+type SnarkJSProof struct {
+	A        [3]string    `json:"pi_a"`
+	B        [3][2]string `json:"pi_b"`
+	C        [3]string    `json:"pi_c"`
+	Protocol string       `json:"protocol"`
+	Curve    string       `json:"curve"`
+}
+
+// evmWords returns the 8 coordinates of a BN254 proof in the order of the
+// Solidity verifier, which is the order of its raw encoding.
+func (p *Proof) evmWords() ([8]*big.Int, error) {
+	var words [8]*big.Int
+	if err := ProofFormatEVM.CheckCurve(p.Proof.CurveID()); err != nil {
+		return words, err
+	}
+	var buf bytes.Buffer
+	if _, err := p.Proof.WriteRawTo(&buf); err != nil {
+		return words, err
+	}
+	proofBytes := buf.Bytes()
+	for i := range words {
+		words[i] = new(big.Int).SetBytes(proofBytes[i*fieldSize : (i+1)*fieldSize])
+	}
+	return words, nil
+}
+
+// ProofFromEVM decodes a BN254 proof from the 8 uint256 the Solidity
+// verifier takes, as found in the calldata of transactions.
+func ProofFromEVM(words [8]*big.Int) (*Proof, error) {
+	raw := make([]byte, 0, len(words)*fieldSize)
+	for i, word := range words {
+		if word.Sign() < 0 || word.BitLen() > 8*fieldSize {
+			return nil, fmt.Errorf("proof word %d does not fit in %d bytes", i, fieldSize)
+		}
+		raw = append(raw, word.FillBytes(make([]byte, fieldSize))...)
+	}
+	proof := groth16.NewProof(ecc.BN254)
+	if _, err := proof.ReadFrom(bytes.NewReader(raw)); err != nil {
+		return nil, err
+	}
+	return &Proof{proof}, nil
+}
+
+// MarshalFormat encodes the proof in a wire format, writing the numbers of
+// the EVM format in numbers. snarkjs numbers are always decimal.
+func (p *Proof) MarshalFormat(format ProofFormat, numbers NumberFormat) ([]byte, error) {
+	var buf bytes.Buffer
+	switch format {
+	case ProofFormatRaw:
+		if _, err := p.Proof.WriteRawTo(&buf); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case ProofFormatBase64:
+		if _, err := p.Proof.WriteTo(&buf); err != nil {
+			return nil, err
+		}
+		return []byte(base64.StdEncoding.EncodeToString(buf.Bytes())), nil
+	case ProofFormatEVM:
+		words, err := p.evmWords()
+		if err != nil {
+			return nil, err
+		}
+		var proof [8]string
+		for i, word := range words {
+			proof[i] = numbers.Format(word)
+		}
+		return json.Marshal(proof)
+	case ProofFormatSnarkJS:
+		words, err := p.evmWords()
+		if err != nil {
+			return nil, err
+		}
+		// The raw encoding writes the coefficients of G2 coordinates in
+		// descending order.
+		return json.Marshal(SnarkJSProof{
+			A: [3]string{words[0].String(), words[1].String(), "1"},
+			B: [3][2]string{
+				{words[3].String(), words[2].String()},
+				{words[5].String(), words[4].String()},
+				{"1", "0"},
+			},
+			C:        [3]string{words[6].String(), words[7].String(), "1"},
+			Protocol: "groth16",
+			Curve:    "bn128",
+		})
+	default:
+		return nil, fmt.Errorf("unknown proof format: %s", format)
+	}
+}
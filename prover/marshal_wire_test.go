@@ -0,0 +1,95 @@
+package prover
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/frontend"
+)
+
+// wordsProof decodes a BN254 proof from its coordinates in EVM order.
+func wordsProof(t *testing.T, words [8]string) groth16.Proof {
+	var numbers [8]*big.Int
+	for i, word := range words {
+		numbers[i] = new(big.Int)
+		if err := fromHex(numbers[i], word); err != nil {
+			t.Fatal(err)
+		}
+	}
+	proof, err := ProofFromEVM(numbers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return proof.Proof
+}
+
+func TestProofFormats(t *testing.T) {
+	ps := smallProvingSystem(t)
+	witness, err := frontend.NewWitness(&squareCircuit{X: 3, Y: 9}, ecc.BN254.ScalarField())
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof, err := groth16.Prove(ps.ConstraintSystem, ps.ProvingKey, witness)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicWitness, err := witness.Public()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []ProofFormat{ProofFormatRaw, ProofFormatBase64, ProofFormatEVM, ProofFormatSnarkJS} {
+		encoded, err := (&Proof{proof}).MarshalFormat(format, NumberFormatDecimal)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded := groth16.NewProof(ecc.BN254)
+		switch format {
+		case ProofFormatRaw:
+			_, err = decoded.ReadFrom(bytes.NewReader(encoded))
+		case ProofFormatBase64:
+			var compressed []byte
+			if compressed, err = base64.StdEncoding.DecodeString(string(encoded)); err == nil {
+				_, err = decoded.ReadFrom(bytes.NewReader(compressed))
+			}
+		case ProofFormatEVM:
+			var words [8]string
+			if err = json.Unmarshal(encoded, &words); err == nil {
+				decoded = wordsProof(t, words)
+			}
+		case ProofFormatSnarkJS:
+			var snarkjs SnarkJSProof
+			if err = json.Unmarshal(encoded, &snarkjs); err == nil {
+				if snarkjs.Protocol != "groth16" || snarkjs.Curve != "bn128" || snarkjs.A[2] != "1" || snarkjs.B[2] != [2]string{"1", "0"} {
+					t.Fatalf("expected a snarkjs proof, got %s", encoded)
+				}
+				decoded = wordsProof(t, [8]string{
+					snarkjs.A[0], snarkjs.A[1],
+					snarkjs.B[0][1], snarkjs.B[0][0], snarkjs.B[1][1], snarkjs.B[1][0],
+					snarkjs.C[0], snarkjs.C[1],
+				})
+			}
+		}
+		if err != nil {
+			t.Fatalf("%s: %s", format, err)
+		}
+		if err = groth16.Verify(decoded, ps.VerifyingKey, publicWitness); err != nil {
+			t.Fatalf("expected the decoded %s proof to verify, got %v", format, err)
+		}
+		if parsed, ok := ParseProofContentType(format.ContentType()); !ok || parsed != format {
+			t.Fatalf("expected the content type of %s to be parsed, got %q", format, parsed)
+		}
+	}
+
+	if err = ProofFormatSnarkJS.CheckCurve(ecc.BLS12_381); err == nil {
+		t.Fatal("expected BLS12-381 proofs to have no snarkjs format")
+	}
+	if _, err = ParseProofFormat("pem"); err == nil {
+		t.Fatal("expected an unknown format to be rejected")
+	}
+}
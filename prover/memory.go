@@ -0,0 +1,22 @@
+package prover
+
+import "github.com/consensys/gnark-crypto/ecc"
+
+// msmScratch is the memory taken by the buckets of the five
+// multi-exponentiations groth16.Prove runs concurrently, which depends little
+// on the size of the circuit.
+const msmScratch = 384 << 20
+
+// ProofMemory estimates the memory, in bytes, groth16.Prove allocates for a
+// proof on top of the loaded keys. It grows with the number of constraints
+// and wires of the circuit, and so with its batch size and tree depth: the
+// solver and the FFTs of the quotient hold a handful of field elements per
+// constraint, padded to the FFT domain, and the multi-exponentiations copy
+// and decompose the wire values.
+func (ps *ProvingSystem) ProofMemory() uint64 {
+	cs := ps.ConstraintSystem
+	domain := ecc.NextPowerOfTwo(uint64(cs.GetNbConstraints()))
+	wires := uint64(cs.GetNbInternalVariables() + cs.GetNbSecretVariables() + cs.GetNbPublicVariables())
+	element := uint64(ps.Curve.ScalarField().BitLen()+7) / 8
+	return element*(5*domain+8*wires) + msmScratch
+}
@@ -0,0 +1,151 @@
+package prover
+
+import (
+	"math"
+	"math/big"
+
+	"github.com/consensys/gnark-crypto/ecc"
+)
+
+// nativeRoot computes the root of a tree from a leaf at index and its
+// sibling nodes, mirroring VerifyProof in the circuit.
+func nativeRoot(hash func(left, right *big.Int) (*big.Int, error), leaf *big.Int, index uint64, siblings []big.Int) (*big.Int, error) {
+	node := new(big.Int).Set(leaf)
+	for level := range siblings {
+		left, right := node, &siblings[level]
+		if (index>>level)&1 == 1 {
+			left, right = right, node
+		}
+		var err error
+		node, err = hash(left, right)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return node, nil
+}
+
+// Verify checks natively that the parameters form a valid batch of
+// batchSize insertions into a tree of treeDepth: that their shape matches,
+// that the insertions, at Indices if given, fit in the tree, and that the
+// Merkle proofs chain from PreRoot to PostRoot under the Poseidon hash of the
+// circuit on BN254, expanding their frontier first. It takes milliseconds,
+// so that bad batches can be rejected before proving.
+func (p *Parameters) Verify(treeDepth uint32, batchSize uint32) error {
+	if err := (&ProvingSystem{Curve: ecc.BN254, TreeDepth: treeDepth}).ExpandFrontier(p); err != nil {
+		return err
+	}
+	if err := p.ValidateShape(treeDepth, batchSize); err != nil {
+		return err
+	}
+	if err := p.ValidateFieldElements(ecc.BN254.ScalarField()); err != nil {
+		return err
+	}
+	hash, _ := NativeTreeHash(TreeHashPoseidon, ecc.BN254)
+	return p.verifyRoots(hash, treeDepth)
+}
+
+// VerifyParameters checks params like Parameters.Verify, on the curve and
+// with the empty leaf and tree hash of the proving system. It also checks their input
+// hash, computing it if it is not supplied.
+func (ps *ProvingSystem) VerifyParameters(params *Parameters) error {
+	if err := ps.validateParameters(params, ps.witnessWorkers()); err != nil {
+		return err
+	}
+	if err := ps.checkInputHash(params); err != nil {
+		return err
+	}
+	return ps.checkRoots(params)
+}
+
+// checkRoots recomputes the chain of roots of the batch natively, so that
+// unsatisfied constraints can be traced back to the offending proof.
+func (ps *ProvingSystem) checkRoots(params *Parameters) error {
+	hash, err := NativeTreeHash(ps.TreeHash, ps.Curve)
+	if err != nil {
+		return err
+	}
+	return params.verifyRoots(hash, ps.TreeDepth)
+}
+
+// treeCapacity returns the number of leaves of a tree of treeDepth, capped
+// at the largest uint64 for trees no index can overflow.
+func treeCapacity(treeDepth uint32) uint64 {
+	if treeDepth >= 64 {
+		return math.MaxUint64
+	}
+	return uint64(1) << treeDepth
+}
+
+// ValidateCapacity checks that the insertions fit in a tree of treeDepth:
+// that StartIndex + len(IdComms) does not exceed 2^treeDepth or, with
+// indices, that every index is below it. It reports a StartIndexError or an
+// IndexError. The circuit enforces the same with assertCapacity and by
+// decomposing every index into treeDepth bits, but fails with unsatisfied
+// constraints, which are only reported once the witness is solved.
+func (p *Parameters) ValidateCapacity(treeDepth uint32) error {
+	capacity := treeCapacity(treeDepth)
+	for i, index := range p.Indices {
+		if uint64(index) >= capacity {
+			return &IndexError{Proof: i, Index: index, TreeDepth: treeDepth}
+		}
+	}
+	if len(p.Indices) == 0 && uint64(p.StartIndex)+uint64(len(p.IdComms)) > capacity {
+		return &StartIndexError{StartIndex: p.StartIndex, BatchSize: len(p.IdComms), TreeDepth: treeDepth}
+	}
+	return nil
+}
+
+// ValidateAlignment checks that StartIndex is a multiple of alignment, for
+// sequencers whose trees are filled a whole batch at a time, reporting a
+// StartIndexAlignmentError. Zero or one does not constrain it, and
+// insertions at indices, which do not use StartIndex, are not checked. The
+// circuit does not constrain the alignment.
+func (p *Parameters) ValidateAlignment(alignment uint32) error {
+	if alignment > 1 && len(p.Indices) == 0 && p.StartIndex%alignment != 0 {
+		return &StartIndexAlignmentError{StartIndex: p.StartIndex, Alignment: alignment}
+	}
+	return nil
+}
+
+// verifyRoots reports insertions past the end of the tree as a
+// StartIndexError, or an IndexError with indices, and the first root that
+// does not match as a RootMismatchError.
+func (p *Parameters) verifyRoots(hash func(left, right *big.Int) (*big.Int, error), treeDepth uint32) error {
+	if err := p.ValidateCapacity(treeDepth); err != nil {
+		return err
+	}
+	prevRoot := &p.PreRoot
+	for i := range p.IdComms {
+		index := p.index(i)
+		root, err := nativeRoot(hash, &p.EmptyLeaf, index, p.MerkleProofs[i])
+		if err != nil {
+			return err
+		}
+		if root.Cmp(prevRoot) != 0 {
+			return &RootMismatchError{
+				Proof:        i,
+				Index:        index,
+				Expected:     *prevRoot,
+				Computed:     *root,
+				OpensPreRoot: i > 0 && root.Cmp(&p.PreRoot) == 0,
+			}
+		}
+		prevRoot, err = nativeRoot(hash, &p.IdComms[i], index, p.MerkleProofs[i])
+		if err != nil {
+			return err
+		}
+	}
+	if prevRoot.Cmp(&p.PostRoot) != 0 {
+		return &RootMismatchError{Proof: -1, Expected: p.PostRoot, Computed: *prevRoot}
+	}
+	return nil
+}
+
+// index returns the tree index of the i-th insertion.
+func (p *Parameters) index(i int) uint64 {
+	if len(p.Indices) != 0 {
+		return uint64(p.Indices[i])
+	}
+	return uint64(p.StartIndex) + uint64(i)
+}
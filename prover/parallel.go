@@ -0,0 +1,48 @@
+package prover
+
+import (
+	"runtime"
+	"sync"
+)
+
+// witnessWorkers returns the number of goroutines witnesses are built with.
+func (ps *ProvingSystem) witnessWorkers() int {
+	if ps.WitnessWorkers > 0 {
+		return ps.WitnessWorkers
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// minParallelWork is the number of field elements below which splitting
+// work across goroutines costs more than it saves.
+const minParallelWork = 1024
+
+// parallelize calls work on consecutive chunks of [0, n) using up to workers
+// goroutines, each handling at least minChunk items, and returns once all
+// are done.
+func parallelize(n int, workers int, minChunk int, work func(start, end int)) {
+	if minChunk < 1 {
+		minChunk = 1
+	}
+	if workers > n/minChunk {
+		workers = n / minChunk
+	}
+	if workers <= 1 {
+		work(0, n)
+		return
+	}
+	chunk := (n + workers - 1) / workers
+	var wg sync.WaitGroup
+	for start := 0; start < n; start += chunk {
+		end := start + chunk
+		if end > n {
+			end = n
+		}
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			work(start, end)
+		}(start, end)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,133 @@
+package poseidon
+
+import (
+	"math/big"
+
+	"github.com/consensys/gnark/frontend"
+)
+
+// grain is the Grain LFSR the reference implementation of Poseidon derives
+// its round constants and MDS matrix from, see generate_parameters_grain.sage
+// in https://extgit.iaik.tugraz.at/krypto/hadeshash.
+type grain struct {
+	state [80]bool
+}
+
+// newGrain initializes the LFSR for a prime field of n bits, the x^5 S-box,
+// a state of t elements and the given numbers of full and partial rounds,
+// and discards its first 160 bits.
+func newGrain(n, t, fullRounds, partialRounds int) *grain {
+	g := &grain{}
+	i := 0
+	put := func(value, bits int) {
+		for b := bits - 1; b >= 0; b-- {
+			g.state[i] = (value>>b)&1 == 1
+			i++
+		}
+	}
+	put(1, 2) // prime field
+	put(0, 4) // x^alpha S-box
+	put(n, 12)
+	put(t, 12)
+	put(fullRounds, 10)
+	put(partialRounds, 10)
+	put(1<<30-1, 30)
+	for i := 0; i < 160; i++ {
+		g.step()
+	}
+	return g
+}
+
+func (g *grain) step() bool {
+	s := &g.state
+	bit := s[62] != s[51] != s[38] != s[23] != s[13] != s[0]
+	copy(s[:], s[1:])
+	s[79] = bit
+	return bit
+}
+
+// bit returns the next output bit: of every pair of bits, the second is
+// output if the first is set and discarded otherwise.
+func (g *grain) bit() bool {
+	for !g.step() {
+		g.step()
+	}
+	return g.step()
+}
+
+// bits returns the next n bits as an integer, most significant first.
+func (g *grain) bits(n int) *big.Int {
+	value := new(big.Int)
+	for i := 0; i < n; i++ {
+		value.Lsh(value, 1)
+		if g.bit() {
+			value.SetBit(value, 0, 1)
+		}
+	}
+	return value
+}
+
+// GenerateParameters derives the round constants and MDS matrix of Poseidon
+// over field with a state of width elements, like the reference
+// implementation. The constants are drawn uniformly below the field modulus,
+// followed by the points of a Cauchy matrix. Unlike the reference, the matrix
+// is not checked against subspace trail attacks; the matrices of circomlib
+// pass these checks at the first draw and are reproduced.
+func GenerateParameters(field *big.Int, width, fullRounds, partialRounds int) *Parameters {
+	n := field.BitLen()
+	g := newGrain(n, width, fullRounds, partialRounds)
+	params := &Parameters{
+		FullRounds:    fullRounds,
+		PartialRounds: partialRounds,
+		Constants:     make([][]frontend.Variable, fullRounds+partialRounds),
+	}
+	for round := range params.Constants {
+		params.Constants[round] = make([]frontend.Variable, width)
+		for i := range params.Constants[round] {
+			c := g.bits(n)
+			for c.Cmp(field) >= 0 {
+				c = g.bits(n)
+			}
+			params.Constants[round][i] = *c
+		}
+	}
+	for params.MDS == nil {
+		points := cauchyPoints(g, field, n, width)
+		params.MDS = cauchyMatrix(field, points[:width], points[width:])
+	}
+	return params
+}
+
+// cauchyPoints draws 2*width distinct field elements.
+func cauchyPoints(g *grain, field *big.Int, n, width int) []*big.Int {
+	for {
+		points := make([]*big.Int, 2*width)
+		seen := make(map[string]bool)
+		for i := range points {
+			points[i] = g.bits(n)
+			points[i].Mod(points[i], field)
+			seen[points[i].String()] = true
+		}
+		if len(seen) == len(points) {
+			return points
+		}
+	}
+}
+
+// cauchyMatrix returns the matrix of 1/(xs[i]+ys[j]), or nil if a sum is
+// zero.
+func cauchyMatrix(field *big.Int, xs, ys []*big.Int) [][]frontend.Variable {
+	mds := make([][]frontend.Variable, len(xs))
+	for i := range xs {
+		mds[i] = make([]frontend.Variable, len(ys))
+		for j := range ys {
+			sum := new(big.Int).Add(xs[i], ys[j])
+			sum.Mod(sum, field)
+			if sum.Sign() == 0 {
+				return nil
+			}
+			mds[i][j] = *sum.ModInverse(sum, field)
+		}
+	}
+	return mds
+}
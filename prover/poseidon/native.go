@@ -0,0 +1,56 @@
+package poseidon
+
+import (
+	"math/big"
+
+	"github.com/consensys/gnark/frontend"
+)
+
+// Hash2 computes natively what Sum of NewPoseidon2 computes in a circuit over
+// field after writing left and right. The round constants are reduced modulo
+// field like the circuit does, so it matches on every curve.
+func Hash2(field *big.Int, left, right *big.Int) *big.Int {
+	return Parameters2.Hash(field, left, right)
+}
+
+// Hash computes natively what Sum of NewPoseidonWithParameters computes in a
+// circuit over field after writing inputs.
+func (p *Parameters) Hash(field *big.Int, inputs ...*big.Int) *big.Int {
+	data := append([]*big.Int{new(big.Int)}, inputs...)
+	return nativeSum(field, p.FullRounds+p.PartialRounds-1, p.FullRounds/2, p.Constants, p.MDS, data)
+}
+
+func nativeSum(field *big.Int, nTotalRounds int, nFullRounds int, constants, mds [][]frontend.Variable, data []*big.Int) *big.Int {
+	state := make([]*big.Int, len(data))
+	for i := range data {
+		state[i] = new(big.Int).Mod(data[i], field)
+	}
+	tmp := new(big.Int)
+	for round := 0; round < nTotalRounds+1; round += 1 {
+		for i := range state {
+			c := constants[round][i].(big.Int)
+			state[i].Add(state[i], &c).Mod(state[i], field)
+		}
+		// Partial rounds only apply the S-box to the first element.
+		sboxes := 1
+		if round < nFullRounds || round > (nTotalRounds-nFullRounds) {
+			sboxes = len(state)
+		}
+		for i := 0; i < sboxes; i += 1 {
+			tmp.Mul(state[i], state[i]).Mod(tmp, field)
+			tmp.Mul(tmp, tmp).Mod(tmp, field)
+			state[i].Mul(state[i], tmp).Mod(state[i], field)
+		}
+		next := make([]*big.Int, len(state))
+		for i := range mds {
+			next[i] = new(big.Int)
+			for j := range mds[i] {
+				m := mds[i][j].(big.Int)
+				next[i].Add(next[i], tmp.Mul(state[j], &m))
+			}
+			next[i].Mod(next[i], field)
+		}
+		state = next
+	}
+	return state[0]
+}
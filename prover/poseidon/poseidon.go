@@ -87,6 +87,33 @@ func NewPoseidon2(api frontend.API) Poseidon {
 	}
 }
 
+// Parameters are the numbers of rounds, the round constants and the MDS
+// matrix of Poseidon, the constants of a round and the MDS matrix having the
+// width of the state.
+type Parameters struct {
+	FullRounds    int
+	PartialRounds int
+	Constants     [][]frontend.Variable
+	MDS           [][]frontend.Variable
+}
+
+// Parameters2 are the parameters of NewPoseidon2, those of circomlib for two
+// inputs.
+var Parameters2 = &Parameters{FullRounds: 8, PartialRounds: 57, Constants: CONSTANTS, MDS: MDS}
+
+// NewPoseidonWithParameters hashes width-1 inputs with the given parameters,
+// where width is that of their MDS matrix.
+func NewPoseidonWithParameters(api frontend.API, params *Parameters) Poseidon {
+	return Poseidon{
+		nFullRounds:  params.FullRounds / 2,
+		nTotalRounds: params.FullRounds + params.PartialRounds - 1,
+		data:         []frontend.Variable{0},
+		api:          api,
+		constants:    params.Constants,
+		mds:          params.MDS,
+	}
+}
+
 func (h *Poseidon) Write(data ...frontend.Variable) {
 	h.data = append(h.data, data...)
 }
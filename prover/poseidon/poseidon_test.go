@@ -1,6 +1,8 @@
 package poseidon
 
 import (
+	"math/big"
+	"reflect"
 	"testing"
 
 	"github.com/consensys/gnark-crypto/ecc"
@@ -57,3 +59,75 @@ func TestPoseidon(t *testing.T) {
 		Hash:  hex("0x303f59cd0831b5633bcda50514521b33776b5d4280eb5868ba1dbbe2e4d76ab5"),
 	}, test.WithBackends(backend.GROTH16), test.WithCurves(ecc.BN254))
 }
+
+func TestHash2(t *testing.T) {
+	for _, tc := range []struct {
+		left, right int64
+		hash        string
+	}{
+		{0, 0, "0x2098f5fb9e239eab3ceac3f27b81e481dc3124d55ffed523a839ee8446b64864"},
+		{31213, 132, "0x303f59cd0831b5633bcda50514521b33776b5d4280eb5868ba1dbbe2e4d76ab5"},
+	} {
+		expected := hex(tc.hash)
+		if actual := Hash2(ecc.BN254.ScalarField(), big.NewInt(tc.left), big.NewInt(tc.right)); actual.Cmp(&expected) != 0 {
+			t.Fatalf("hash of %d and %d: expected %s, got %#x", tc.left, tc.right, tc.hash, actual)
+		}
+	}
+
+	// The circuit reduces the constants modulo the field of other curves.
+	field := ecc.BLS12_381.ScalarField()
+	left, right := big.NewInt(31213), big.NewInt(132)
+	err := test.IsSolved(&TestPoseidonCircuit2{}, &TestPoseidonCircuit2{
+		Left:  left,
+		Right: right,
+		Hash:  Hash2(field, left, right),
+	}, field)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGenerateParameters(t *testing.T) {
+	field := ecc.BN254.ScalarField()
+	for _, tc := range []struct {
+		width, partialRounds int
+		constants, mds       [][]frontend.Variable
+	}{
+		{2, 56, CONSTANTS1, MDS1},
+		{3, 57, CONSTANTS, MDS},
+	} {
+		params := GenerateParameters(field, tc.width, 8, tc.partialRounds)
+		if !reflect.DeepEqual(params.Constants, tc.constants) || !reflect.DeepEqual(params.MDS, tc.mds) {
+			t.Fatalf("expected the parameters of circomlib for width %d", tc.width)
+		}
+	}
+}
+
+type TestPoseidonParametersCircuit struct {
+	TestPoseidonCircuit2
+	Params *Parameters `gnark:"-"`
+}
+
+func (circuit *TestPoseidonParametersCircuit) Define(api frontend.API) error {
+	poseidon := NewPoseidonWithParameters(api, circuit.Params)
+	poseidon.Write(circuit.Left, circuit.Right)
+	api.AssertIsEqual(circuit.Hash, poseidon.Sum())
+	return nil
+}
+
+func TestPoseidonWithParameters(t *testing.T) {
+	field := ecc.BLS12_381.ScalarField()
+	params := GenerateParameters(field, 3, 8, 60)
+	left, right := big.NewInt(31213), big.NewInt(132)
+	hash := params.Hash(field, left, right)
+	if hash.Cmp(Hash2(field, left, right)) == 0 {
+		t.Fatal("expected other parameters to hash differently")
+	}
+	err := test.IsSolved(&TestPoseidonParametersCircuit{Params: params}, &TestPoseidonParametersCircuit{
+		TestPoseidonCircuit2: TestPoseidonCircuit2{Left: left, Right: right, Hash: hash},
+		Params:               params,
+	}, field)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,129 @@
+package prover
+
+import (
+	"context"
+	"os"
+	"sort"
+	"strings"
+
+	gnarkProfile "github.com/consensys/gnark/profile"
+	"github.com/google/pprof/profile"
+)
+
+// Gadgets the constraints of the circuit are broken down into by
+// ProfileR1CS.
+const (
+	GadgetKeccak       = "input hash: keccak"
+	GadgetSHA256       = "input hash: sha256"
+	GadgetPoseidonHash = "input hash: poseidon"
+	GadgetInputBits    = "input hash: bit decomposition"
+	GadgetMerkleProofs = "merkle proofs"
+	GadgetIndexBits    = "tree indices: bit decomposition"
+	GadgetRootChecks   = "root checks"
+	GadgetOther        = "other"
+)
+
+// Functions of the circuit by which constraints are attributed to gadgets.
+const (
+	circuitPackage      = "worldcoin/gnark-mbu/prover"
+	inputHashFunction   = circuitPackage + ".(*MbuCircuit).inputHash"
+	verifyProofFunction = circuitPackage + ".VerifyProof"
+	toBinaryBigEndian   = circuitPackage + ".ToBinaryBigEndian"
+	fromBinaryBigEndian = circuitPackage + ".FromBinaryBigEndian"
+	keccakPackage       = circuitPackage + "/keccak."
+	sha2Package         = circuitPackage + "/sha2."
+)
+
+// GadgetConstraints is the number of constraints added by a gadget.
+type GadgetConstraints struct {
+	Gadget      string `json:"gadget"`
+	Constraints int    `json:"constraints"`
+}
+
+// ConstraintProfile breaks the constraints of a circuit down by gadget.
+type ConstraintProfile struct {
+	Constraints int `json:"constraints"`
+	// Gadgets is sorted by decreasing number of constraints.
+	Gadgets []GadgetConstraints `json:"gadgets"`
+}
+
+// ProfileR1CS builds the R1CS of the circuit with gnark's profiler, writes
+// the pprof profile of its constraints to path and breaks them down by
+// gadget. The profiler is global, so no other circuit may be compiled at
+// the same time.
+func ProfileR1CS(ctx context.Context, path string, treeDepth uint32, batchSize uint32, opts ...CircuitOption) (*ConstraintProfile, error) {
+	// The profiler exits if it cannot create its file.
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	file.Close()
+
+	session := gnarkProfile.Start(gnarkProfile.WithPath(path))
+	_, err = BuildR1CS(ctx, treeDepth, batchSize, opts...)
+	session.Stop()
+	if err != nil {
+		return nil, err
+	}
+	if file, err = os.Open(path); err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	pprof, err := profile.Parse(file)
+	if err != nil {
+		return nil, err
+	}
+	return newConstraintProfile(pprof), nil
+}
+
+func newConstraintProfile(pprof *profile.Profile) *ConstraintProfile {
+	counts := make(map[string]int)
+	result := &ConstraintProfile{}
+	for _, sample := range pprof.Sample {
+		counts[sampleGadget(sample)] += int(sample.Value[0])
+		result.Constraints += int(sample.Value[0])
+	}
+	for gadget, count := range counts {
+		result.Gadgets = append(result.Gadgets, GadgetConstraints{Gadget: gadget, Constraints: count})
+	}
+	sort.Slice(result.Gadgets, func(i, j int) bool {
+		if result.Gadgets[i].Constraints != result.Gadgets[j].Constraints {
+			return result.Gadgets[i].Constraints > result.Gadgets[j].Constraints
+		}
+		return result.Gadgets[i].Gadget < result.Gadgets[j].Gadget
+	})
+	return result
+}
+
+// sampleGadget attributes a constraint to the gadget of the innermost
+// function of the circuit on its stack, which is sampled from the leaf.
+// Constraints added by Define itself are bit decompositions of the tree
+// indices if they come from gnark's ToBinary, else root checks.
+func sampleGadget(sample *profile.Sample) string {
+	toBinary := false
+	for _, location := range sample.Location {
+		for _, line := range location.Line {
+			function := line.Function.SystemName
+			switch {
+			case strings.HasPrefix(function, keccakPackage):
+				return GadgetKeccak
+			case strings.HasPrefix(function, sha2Package):
+				return GadgetSHA256
+			case function == toBinaryBigEndian || function == fromBinaryBigEndian:
+				return GadgetInputBits
+			case function == verifyProofFunction:
+				return GadgetMerkleProofs
+			case function == inputHashFunction:
+				return GadgetPoseidonHash
+			case strings.HasPrefix(function, circuitPackage+".") && strings.HasSuffix(function, ".Define"):
+				if toBinary {
+					return GadgetIndexBits
+				}
+				return GadgetRootChecks
+			case strings.Contains(function, "ToBinary"):
+				toBinary = true
+			}
+		}
+	}
+	return GadgetOther
+}
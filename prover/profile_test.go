@@ -0,0 +1,36 @@
+package prover
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProfileR1CS(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "circuit.pprof")
+	profile, err := ProfileR1CS(context.Background(), path, 4, 2, WithCommitment(CommitmentPoseidon), WithIndices())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs, err := BuildR1CS(context.Background(), 4, 2, WithCommitment(CommitmentPoseidon), WithIndices())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.Constraints != cs.GetNbConstraints() {
+		t.Fatalf("profiled %d constraints, the circuit has %d", profile.Constraints, cs.GetNbConstraints())
+	}
+	gadgets := make(map[string]int)
+	for _, gadget := range profile.Gadgets {
+		gadgets[gadget.Gadget] = gadget.Constraints
+	}
+	if gadgets[GadgetMerkleProofs] == 0 || gadgets[GadgetPoseidonHash] == 0 || gadgets[GadgetIndexBits] == 0 || gadgets[GadgetOther] != 0 {
+		t.Fatalf("unexpected breakdown %+v", profile.Gadgets)
+	}
+	if profile.Gadgets[0].Gadget != GadgetMerkleProofs {
+		t.Fatalf("expected the merkle proofs to dominate, got %+v", profile.Gadgets)
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() == 0 {
+		t.Fatalf("expected a pprof profile at %s", path)
+	}
+}
@@ -0,0 +1,72 @@
+package prover
+
+import (
+	"sync"
+	"time"
+)
+
+// ProofStage is a coarse stage of generating a proof.
+type ProofStage string
+
+const (
+	StageWitness ProofStage = "witness"
+	StageSolving ProofStage = "solving"
+	StageFFT     ProofStage = "fft"
+	StageMSMG1   ProofStage = "msm_g1"
+	StageMSMG2   ProofStage = "msm_g2"
+	StageDone    ProofStage = "done"
+)
+
+// ProofStages are the stages of a proof in order.
+var ProofStages = []ProofStage{StageWitness, StageSolving, StageFFT, StageMSMG1, StageMSMG2, StageDone}
+
+// Progress is called with the stage of a proof as it enters it.
+type Progress func(stage ProofStage)
+
+// provingStages are the stages of groth16.Prove with their share of its
+// time, measured on BN254 for circuits of a few hundred thousand
+// constraints. The multi-exponentiations in G1 and G2 overlap; the G2 one
+// finishes last.
+var provingStages = []struct {
+	stage ProofStage
+	share float64
+}{
+	{StageSolving, 0.05},
+	{StageFFT, 0.10},
+	{StageMSMG1, 0.50},
+	{StageMSMG2, 0.35},
+}
+
+// estimateStages reports the stages of groth16.Prove, which gnark does not
+// expose, until stop is called. The solving stage is entered right away and
+// the following ones when their share of the previous proving time has
+// elapsed; before the first proof, the proof stays in the solving stage.
+// No stage is reported once stop returns.
+func (ps *ProvingSystem) estimateStages(progress Progress) (stop func()) {
+	progress(StageSolving)
+	expected := time.Duration(ps.provingTime.Load())
+	stopped := make(chan struct{})
+	var wg sync.WaitGroup
+	if expected > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start, elapsed := time.Now(), 0.0
+			for i := 1; i < len(provingStages); i++ {
+				elapsed += provingStages[i-1].share
+				timer := time.NewTimer(time.Until(start.Add(time.Duration(elapsed * float64(expected)))))
+				select {
+				case <-timer.C:
+					progress(provingStages[i].stage)
+				case <-stopped:
+					timer.Stop()
+					return
+				}
+			}
+		}()
+	}
+	return func() {
+		close(stopped)
+		wg.Wait()
+	}
+}
@@ -0,0 +1,42 @@
+package prover
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEstimateStages(t *testing.T) {
+	var mu sync.Mutex
+	var stages []ProofStage
+	progress := func(stage ProofStage) {
+		mu.Lock()
+		defer mu.Unlock()
+		stages = append(stages, stage)
+	}
+
+	// Before the first proof, there is nothing to estimate from.
+	var ps ProvingSystem
+	ps.estimateStages(progress)()
+	if !reflect.DeepEqual(stages, []ProofStage{StageSolving}) {
+		t.Fatalf("expected the proof to stay in the solving stage, got %v", stages)
+	}
+
+	stages = nil
+	ps.provingTime.Store(int64(20 * time.Millisecond))
+	stop := ps.estimateStages(progress)
+	time.Sleep(100 * time.Millisecond)
+	stop()
+	if !reflect.DeepEqual(stages, []ProofStage{StageSolving, StageFFT, StageMSMG1, StageMSMG2}) {
+		t.Fatalf("expected every stage to be estimated, got %v", stages)
+	}
+
+	stages = nil
+	ps.provingTime.Store(int64(time.Hour))
+	ps.estimateStages(progress)()
+	time.Sleep(10 * time.Millisecond)
+	if !reflect.DeepEqual(stages, []ProofStage{StageSolving}) {
+		t.Fatalf("expected no stage after stopping, got %v", stages)
+	}
+}
@@ -0,0 +1,173 @@
+// Package proverfuzz provides generators of malformed and adversarial prover
+// inputs together with invariant checks that any prover implementation must
+// uphold when fed with them. It is meant to be used from test suites, both in
+// this repository and in downstream integrations.
+package proverfuzz
+
+import (
+	"fmt"
+	"math/big"
+	"math/rand"
+	"worldcoin/gnark-mbu/prover"
+
+	"github.com/consensys/gnark-crypto/ecc"
+)
+
+// Case is a single adversarial input, labelled for test reporting.
+type Case struct {
+	Name   string
+	Params *prover.Parameters
+}
+
+// BoundaryValues returns field elements sitting at or beyond the edges of the
+// BN254 scalar field, including values that do not fit in 256 bits.
+func BoundaryValues() []*big.Int {
+	modulus := ecc.BN254.ScalarField()
+	one := big.NewInt(1)
+	maxUint256 := new(big.Int).Sub(new(big.Int).Lsh(one, 256), one)
+	return []*big.Int{
+		big.NewInt(0),
+		big.NewInt(1),
+		new(big.Int).Sub(modulus, one),
+		new(big.Int).Set(modulus),
+		new(big.Int).Add(modulus, one),
+		maxUint256,
+		new(big.Int).Add(maxUint256, one),
+		big.NewInt(-1),
+	}
+}
+
+// ValidParameters returns well-shaped parameters for the given tree depth and
+// batch size. The values are random and do not form a valid batch update, but
+// they pass all shape checks, which makes them a good base for mutations.
+func ValidParameters(rng *rand.Rand, treeDepth uint32, batchSize uint32) *prover.Parameters {
+	modulus := ecc.BN254.ScalarField()
+	randomElement := func() big.Int {
+		return *new(big.Int).Rand(rng, modulus)
+	}
+	params := prover.Parameters{
+		StartIndex:   rng.Uint32(),
+		PreRoot:      randomElement(),
+		PostRoot:     randomElement(),
+		IdComms:      make([]big.Int, batchSize),
+		MerkleProofs: make([][]big.Int, batchSize),
+	}
+	for i := 0; i < int(batchSize); i++ {
+		params.IdComms[i] = randomElement()
+		params.MerkleProofs[i] = make([]big.Int, treeDepth)
+		for j := 0; j < int(treeDepth); j++ {
+			params.MerkleProofs[i][j] = randomElement()
+		}
+	}
+	params.ComputeInputHash()
+	return &params
+}
+
+func cloneParameters(p *prover.Parameters) *prover.Parameters {
+	clone := prover.Parameters{StartIndex: p.StartIndex}
+	clone.InputHash.Set(&p.InputHash)
+	clone.PreRoot.Set(&p.PreRoot)
+	clone.PostRoot.Set(&p.PostRoot)
+	clone.IdComms = make([]big.Int, len(p.IdComms))
+	for i := range p.IdComms {
+		clone.IdComms[i].Set(&p.IdComms[i])
+	}
+	clone.MerkleProofs = make([][]big.Int, len(p.MerkleProofs))
+	for i := range p.MerkleProofs {
+		clone.MerkleProofs[i] = make([]big.Int, len(p.MerkleProofs[i]))
+		for j := range p.MerkleProofs[i] {
+			clone.MerkleProofs[i][j].Set(&p.MerkleProofs[i][j])
+		}
+	}
+	return &clone
+}
+
+// MalformedShapes returns parameters whose shape does not match the given tree
+// depth and batch size: missing, truncated, extended and giant inputs.
+func MalformedShapes(rng *rand.Rand, treeDepth uint32, batchSize uint32) []Case {
+	base := ValidParameters(rng, treeDepth, batchSize)
+	var cases []Case
+	add := func(name string, mutate func(p *prover.Parameters)) {
+		p := cloneParameters(base)
+		mutate(p)
+		cases = append(cases, Case{Name: name, Params: p})
+	}
+
+	add("empty", func(p *prover.Parameters) { *p = prover.Parameters{} })
+	add("nil id comms", func(p *prover.Parameters) { p.IdComms = nil })
+	add("nil merkle proofs", func(p *prover.Parameters) { p.MerkleProofs = nil })
+	add("missing id comm", func(p *prover.Parameters) { p.IdComms = p.IdComms[:len(p.IdComms)-1] })
+	add("extra id comm", func(p *prover.Parameters) { p.IdComms = append(p.IdComms, *big.NewInt(1)) })
+	add("missing merkle proof", func(p *prover.Parameters) { p.MerkleProofs = p.MerkleProofs[:len(p.MerkleProofs)-1] })
+	add("extra merkle proof", func(p *prover.Parameters) {
+		p.MerkleProofs = append(p.MerkleProofs, make([]big.Int, treeDepth))
+	})
+	for i := 0; i < int(batchSize); i++ {
+		i := i
+		add(fmt.Sprintf("truncated merkle proof %d", i), func(p *prover.Parameters) {
+			p.MerkleProofs[i] = p.MerkleProofs[i][:len(p.MerkleProofs[i])-1]
+		})
+		add(fmt.Sprintf("empty merkle proof %d", i), func(p *prover.Parameters) { p.MerkleProofs[i] = nil })
+		add(fmt.Sprintf("extended merkle proof %d", i), func(p *prover.Parameters) {
+			p.MerkleProofs[i] = append(p.MerkleProofs[i], *big.NewInt(0))
+		})
+	}
+	add("giant batch", func(p *prover.Parameters) {
+		giant := ValidParameters(rng, treeDepth, 16*batchSize+1)
+		p.IdComms = giant.IdComms
+		p.MerkleProofs = giant.MerkleProofs
+	})
+	return cases
+}
+
+// AdversarialValues returns well-shaped parameters where individual values
+// are replaced by boundary field elements and inconsistent hashes.
+func AdversarialValues(rng *rand.Rand, treeDepth uint32, batchSize uint32) []Case {
+	base := ValidParameters(rng, treeDepth, batchSize)
+	var cases []Case
+	add := func(name string, mutate func(p *prover.Parameters)) {
+		p := cloneParameters(base)
+		mutate(p)
+		cases = append(cases, Case{Name: name, Params: p})
+	}
+
+	for _, v := range BoundaryValues() {
+		v := v
+		add(fmt.Sprintf("input hash %s", v), func(p *prover.Parameters) { p.InputHash.Set(v) })
+		add(fmt.Sprintf("pre root %s", v), func(p *prover.Parameters) { p.PreRoot.Set(v) })
+		add(fmt.Sprintf("post root %s", v), func(p *prover.Parameters) { p.PostRoot.Set(v) })
+		add(fmt.Sprintf("id comm %s", v), func(p *prover.Parameters) { p.IdComms[0].Set(v) })
+		if treeDepth > 0 {
+			add(fmt.Sprintf("merkle proof node %s", v), func(p *prover.Parameters) { p.MerkleProofs[0][0].Set(v) })
+		}
+	}
+	add("max start index", func(p *prover.Parameters) { p.StartIndex = ^uint32(0) })
+	add("stale input hash", func(p *prover.Parameters) { p.InputHash.Add(&p.InputHash, big.NewInt(1)) })
+	return cases
+}
+
+// All returns every generated case for the given tree depth and batch size.
+func All(rng *rand.Rand, treeDepth uint32, batchSize uint32) []Case {
+	return append(MalformedShapes(rng, treeDepth, batchSize), AdversarialValues(rng, treeDepth, batchSize)...)
+}
+
+// MalformedJSON returns request bodies that a JSON decoder for prover inputs
+// must reject (or accept) without panicking.
+func MalformedJSON() [][]byte {
+	return [][]byte{
+		[]byte(``),
+		[]byte(`null`),
+		[]byte(`[]`),
+		[]byte(`{}`),
+		[]byte(`{"inputHash":"0x"}`),
+		[]byte(`{"inputHash":"-0x1","preRoot":"0x0","postRoot":"0x0"}`),
+		[]byte(`{"inputHash":"0x0","startIndex":-1,"preRoot":"0x0","postRoot":"0x0"}`),
+		[]byte(`{"inputHash":"0x0","startIndex":4294967296,"preRoot":"0x0","postRoot":"0x0"}`),
+		[]byte(`{"inputHash":"0x0","preRoot":"0x0","postRoot":"0x0","identityCommitments":[null]}`),
+		[]byte(`{"inputHash":"0x0","preRoot":"0x0","postRoot":"0x0","identityCommitments":["0x1"],"merkleProofs":[null,[]]}`),
+		[]byte(`{"inputHash":"0x0","preRoot":"0x0","postRoot":"0x0","merkleProofs":[["zz"]]}`),
+		[]byte(`{"inputHash":"0xffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"}`),
+		[]byte(`{"inputHash":1,"preRoot":2}`),
+		[]byte(`{"inputHash":"0x0"`),
+	}
+}
@@ -0,0 +1,96 @@
+package proverfuzz
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"testing"
+	"worldcoin/gnark-mbu/prover"
+)
+
+// Prover is the subset of the prover.ProvingSystem API exercised by the
+// invariant checks. It allows wrapping or stubbing the proving system.
+type Prover interface {
+	Prove(ctx context.Context, params *prover.Parameters) (*prover.Proof, error)
+	Verify(inputHash big.Int, proof *prover.Proof) error
+}
+
+// PanicError is reported when a prover panics on a given input.
+type PanicError struct {
+	Value interface{}
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("prover panicked: %v", e.Value)
+}
+
+// UnverifiableProofError is reported when a prover returns a proof that does
+// not pass verification against the input hash of the parameters.
+type UnverifiableProofError struct {
+	Err error
+}
+
+func (e *UnverifiableProofError) Error() string {
+	return fmt.Sprintf("prover returned an unverifiable proof: %s", e.Err)
+}
+
+func (e *UnverifiableProofError) Unwrap() error {
+	return e.Err
+}
+
+func guard(f func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Value: r}
+		}
+	}()
+	f()
+	return nil
+}
+
+// CheckInvariants runs the prover on the given parameters and checks that it
+// neither panics nor returns a proof that fails verification. Proving errors
+// are expected for adversarial inputs and are not reported.
+func CheckInvariants(ctx context.Context, p Prover, params *prover.Parameters) error {
+	var proof *prover.Proof
+	var proveErr error
+	if err := guard(func() { proof, proveErr = p.Prove(ctx, params) }); err != nil {
+		return err
+	}
+	if proveErr != nil {
+		return nil
+	}
+	if proof == nil || proof.Proof == nil {
+		return &UnverifiableProofError{Err: fmt.Errorf("nil proof returned without error")}
+	}
+	var verifyErr error
+	if err := guard(func() { verifyErr = p.Verify(params.InputHash, proof) }); err != nil {
+		return err
+	}
+	if verifyErr != nil {
+		return &UnverifiableProofError{Err: verifyErr}
+	}
+	return nil
+}
+
+// CheckJSON decodes the given bytes into prover.Parameters and reports a
+// PanicError if decoding panics. Decoding errors are expected and ignored.
+func CheckJSON(data []byte) error {
+	return guard(func() {
+		var params prover.Parameters
+		_ = params.UnmarshalJSON(data)
+	})
+}
+
+// Run checks the invariants for every case as a separate subtest.
+func Run(t *testing.T, p Prover, cases []Case) {
+	t.Helper()
+	for _, c := range cases {
+		c := c
+		t.Run(c.Name, func(t *testing.T) {
+			if err := CheckInvariants(context.Background(), p, c.Params); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package proverfuzz
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"testing"
+	"worldcoin/gnark-mbu/prover"
+)
+
+const treeDepth = 3
+const batchSize = 2
+
+// shapeProver only runs the shape validation of the real proving system and
+// never produces proofs, so that the generators can be tested cheaply.
+type shapeProver struct{}
+
+func (shapeProver) Prove(_ context.Context, params *prover.Parameters) (*prover.Proof, error) {
+	if err := params.ValidateShape(treeDepth, batchSize); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("not proving")
+}
+
+func (shapeProver) Verify(big.Int, *prover.Proof) error {
+	return fmt.Errorf("not verifying")
+}
+
+type panickingProver struct{ shapeProver }
+
+func (panickingProver) Prove(context.Context, *prover.Parameters) (*prover.Proof, error) {
+	panic("boom")
+}
+
+func TestMalformedShapesAreRejected(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, c := range MalformedShapes(rng, treeDepth, batchSize) {
+		if err := c.Params.ValidateShape(treeDepth, batchSize); err == nil {
+			t.Errorf("%s: expected shape validation to fail", c.Name)
+		}
+	}
+	Run(t, shapeProver{}, All(rng, treeDepth, batchSize))
+}
+
+func TestValidParametersPassShapeValidation(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	params := ValidParameters(rng, treeDepth, batchSize)
+	if err := params.ValidateShape(treeDepth, batchSize); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPanicsAreReported(t *testing.T) {
+	params := ValidParameters(rand.New(rand.NewSource(1)), treeDepth, batchSize)
+	err := CheckInvariants(context.Background(), panickingProver{}, params)
+	if _, ok := err.(*PanicError); !ok {
+		t.Fatalf("expected a panic error, got %v", err)
+	}
+}
+
+func FuzzParametersJSON(f *testing.F) {
+	for _, seed := range MalformedJSON() {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if err := CheckJSON(data); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
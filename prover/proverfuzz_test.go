@@ -0,0 +1,41 @@
+package prover_test
+
+import (
+	"context"
+	"math/big"
+	"math/rand"
+	"testing"
+
+	"worldcoin/gnark-mbu/prover"
+	"worldcoin/gnark-mbu/prover/proverfuzz"
+)
+
+// TestProvingSystemInvariants runs the adversarial inputs of proverfuzz
+// against a real proving system, together with a valid batch whose proof
+// must verify. It lives in an external test package, proverfuzz importing
+// prover.
+func TestProvingSystemInvariants(t *testing.T) {
+	const treeDepth, batchSize = 3, 2
+	ps, err := prover.Setup(context.Background(), treeDepth, batchSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := ps.NewTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid, err := prover.ParametersFromTree(tree, 0, []big.Int{*big.NewInt(1), *big.NewInt(2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid.ComputeInputHash()
+	proof, err := ps.Prove(context.Background(), valid)
+	if err != nil {
+		t.Fatalf("expected the valid batch to be proven, got %v", err)
+	}
+	if err = ps.Verify(valid.InputHash, proof); err != nil {
+		t.Fatal(err)
+	}
+
+	proverfuzz.Run(t, ps, proverfuzz.All(rand.New(rand.NewSource(1)), treeDepth, batchSize))
+}
@@ -1,17 +1,21 @@
 package prover
 
 import (
-	"bytes"
+	"context"
+	"crypto/sha256"
 	"encoding/binary"
 	"fmt"
 	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend"
 	"github.com/consensys/gnark/backend/groth16"
 	"github.com/consensys/gnark/constraint"
 	"github.com/consensys/gnark/frontend"
 	"github.com/consensys/gnark/frontend/cs/r1cs"
-	"github.com/iden3/go-iden3-crypto/keccak256"
 	"io"
 	"math/big"
+	"sync"
+	"sync/atomic"
+	"time"
 	"worldcoin/gnark-mbu/logging"
 )
 
@@ -22,6 +26,25 @@ type Parameters struct {
 	PostRoot     big.Int
 	IdComms      []big.Int
 	MerkleProofs [][]big.Int
+	// EmptyLeaf is the value of tree slots that have not been inserted into.
+	EmptyLeaf big.Int
+	// Indices are the tree indices IdComms are inserted at, for circuits set
+	// up WithIndices. Other circuits insert at consecutive indices from
+	// StartIndex and take no indices.
+	Indices []uint32
+	// Frontier is the Merkle proof of StartIndex in the tree before the
+	// batch, which compact requests give instead of MerkleProofs: the proofs
+	// of insertions at consecutive indices share their left siblings, and
+	// their right siblings are empty. ExpandFrontier derives MerkleProofs
+	// from it.
+	Frontier []big.Int
+	// TreeDepth is the depth of the tree the batch is inserted into, which
+	// selects the circuit proving it when circuits of several depths are
+	// loaded. Zero leaves it to the length of the Merkle proofs.
+	TreeDepth uint32
+	// padding is the number of trailing IdComms SplitBatch filled with empty
+	// leaves, which ValidateShape lets be zero.
+	padding int
 }
 
 type Proof struct {
@@ -29,28 +52,100 @@ type Proof struct {
 }
 
 type ProvingSystem struct {
-	TreeDepth        uint32
-	BatchSize        uint32
+	Curve          ecc.ID
+	TreeDepth      uint32
+	BatchSize      uint32
+	PublicPostRoot bool
+	EmptyLeaf      big.Int
+	// Commitment is the hash binding the inputs to the input hash, the
+	// empty commitment meaning Keccak.
+	Commitment Commitment
+	// Indexed is set for circuits set up WithIndices.
+	Indexed bool
+	// NonZeroIdComms is set for circuits set up WithNonZeroIdComms.
+	NonZeroIdComms bool
+	// TreeHash is the hash of the nodes of the tree, the empty tree hash
+	// meaning Poseidon.
+	TreeHash TreeHash
+	// LookupKeccak is set for circuits set up WithLookupKeccak.
+	LookupKeccak     bool
 	ProvingKey       groth16.ProvingKey
 	VerifyingKey     groth16.VerifyingKey
 	ConstraintSystem constraint.ConstraintSystem
+	// WitnessWorkers is the number of goroutines witnesses are built with,
+	// GOMAXPROCS if zero. The constraint solver of gnark always uses every
+	// CPU.
+	WitnessWorkers int
+	// ProverOptions are passed to the gnark prover and solver by Prove,
+	// ProveWitness and Check, such as backend.WithHints for the hints of
+	// custom gadgets that are not registered globally with hint.Register.
+	// Gnark draws the randomness of proofs from crypto/rand, which no option
+	// overrides.
+	ProverOptions []backend.ProverOption
+	// WitnessDump, if not nil, writes the witnesses of the proofs that fail
+	// to files.
+	WitnessDump *WitnessDump
+	// buffers pools the *witnessBuffers of Prove.
+	buffers sync.Pool
+	// provingTime is the duration of the last groth16.Prove, in
+	// nanoseconds, which the stages reported to a Progress are estimated
+	// from.
+	provingTime atomic.Int64
+	// keySizes caches the sizes of the keys reported by Stats.
+	keySizes keySizes
 }
 
 func (p *Parameters) ValidateShape(treeDepth uint32, batchSize uint32) error {
+	if p.TreeDepth != 0 && p.TreeDepth != treeDepth {
+		return &CircuitNotLoadedError{TreeDepth: p.TreeDepth, BatchSize: uint32(len(p.IdComms)), LoadedTreeDepth: treeDepth, LoadedBatchSize: batchSize}
+	}
 	if len(p.IdComms) != int(batchSize) {
-		return fmt.Errorf("wrong number of identity commitments: %d", len(p.IdComms))
+		return &BatchSizeError{Field: "identity commitments", Expected: int(batchSize), Actual: len(p.IdComms)}
 	}
 	if len(p.MerkleProofs) != int(batchSize) {
-		return fmt.Errorf("wrong number of merkle proofs: %d", len(p.MerkleProofs))
+		return &BatchSizeError{Field: "merkle proofs", Expected: int(batchSize), Actual: len(p.MerkleProofs)}
+	}
+	if len(p.Indices) != 0 && len(p.Indices) != int(batchSize) {
+		return &BatchSizeError{Field: "indices", Expected: int(batchSize), Actual: len(p.Indices)}
 	}
 	for i, proof := range p.MerkleProofs {
 		if len(proof) != int(treeDepth) {
-			return fmt.Errorf("wrong size of merkle proof for proof %d: %d", i, len(proof))
+			if p.uniformProofLength() {
+				return &TreeDepthError{Expected: int(treeDepth), Actual: len(proof)}
+			}
+			return &ProofLengthError{Proof: i, Expected: int(treeDepth), Actual: len(proof)}
+		}
+	}
+	return p.validateIdComms()
+}
+
+// validateIdComms checks that identity commitments are non-zero elements of
+// the BN254 scalar field, which Semaphore derives them in, whatever the curve
+// of the proof. A zero commitment would leave its slot looking empty, so a
+// later batch could insert at the same index again.
+func (p *Parameters) validateIdComms() error {
+	field := ecc.BN254.ScalarField()
+	for i := range p.IdComms[:len(p.IdComms)-p.padding] {
+		idComm := &p.IdComms[i]
+		if idComm.Sign() == 0 {
+			return &ZeroIdCommError{Index: i}
+		}
+		if idComm.Sign() < 0 || idComm.Cmp(field) >= 0 {
+			return &FieldElementError{Name: fmt.Sprintf("identity commitment %d", i), Value: *idComm}
 		}
 	}
 	return nil
 }
 
+func (p *Parameters) uniformProofLength() bool {
+	for _, proof := range p.MerkleProofs {
+		if len(proof) != len(p.MerkleProofs[0]) {
+			return false
+		}
+	}
+	return true
+}
+
 func toBytesLE(b []byte) []byte {
 	for i := 0; i < len(b)/2; i++ {
 		b[i], b[len(b)-i-1] = b[len(b)-i-1], b[i]
@@ -58,35 +153,172 @@ func toBytesLE(b []byte) []byte {
 	return b
 }
 
-// ComputeInputHash computes the input hash to the prover and verifier.
+// ComputeInputHash computes the input hash to the prover and verifier with the
+// default Keccak commitment.
 //
 // It uses big-endian byte ordering (network ordering) in order to agree with
 // Solidity and avoid the need to perform the byte swapping operations on-chain
 // where they would increase our gas cost.
 func (p *Parameters) ComputeInputHash() error {
-	var data []byte
-	buf := new(bytes.Buffer)
-	err := binary.Write(buf, binary.BigEndian, p.StartIndex)
-	if err != nil {
-		return err
+	return p.ComputeInputHashWith(CommitmentKeccak)
+}
+
+// CircuitOption configures optional features of the circuit at compile time.
+// The options used to set up a proving system are recorded in its key file.
+type CircuitOption func(*circuitOptions)
+
+type circuitOptions struct {
+	curve          ecc.ID
+	publicPostRoot bool
+	emptyLeaf      big.Int
+	commitment     Commitment
+	indexed        bool
+	nonZeroIdComms bool
+	treeHash       TreeHash
+	lookupKeccak   bool
+}
+
+// WithPublicPostRoot exposes PostRoot as a second public input next to
+// InputHash, so that verifier contracts can read the new root directly from
+// the public signals.
+func WithPublicPostRoot() CircuitOption {
+	return func(o *circuitOptions) {
+		o.publicPostRoot = true
 	}
-	data = append(data, buf.Bytes()...)
-	data = append(data, p.PreRoot.Bytes()...)
-	data = append(data, p.PostRoot.Bytes()...)
-	for _, v := range p.IdComms {
-		idBytes := v.Bytes()
-		// extend to 32 bytes if necessary, maintaining big-endian ordering
-		if len(idBytes) < 32 {
-			idBytes = append(make([]byte, 32-len(idBytes)), idBytes...)
+}
+
+// WithIndices lets every identity commitment be inserted at its own index,
+// given in Parameters.Indices and bound by the input hash, instead of at
+// consecutive indices from StartIndex. Sequencers use it to fill the gaps
+// left by failed insertions. StartIndex is still hashed but not used.
+func WithIndices() CircuitOption {
+	return func(o *circuitOptions) {
+		o.indexed = true
+	}
+}
+
+// WithNonZeroIdComms asserts in the circuit that every identity commitment is
+// non-zero, on top of the native check of Parameters.ValidateShape, so that
+// the proofs themselves attest it to verifiers. Batches proven with it cannot
+// be padded with zero empty leaves.
+func WithNonZeroIdComms() CircuitOption {
+	return func(o *circuitOptions) {
+		o.nonZeroIdComms = true
+	}
+}
+
+// WithEmptyLeaf sets the value of tree slots that have not been inserted into,
+// for trees using a non-zero sentinel. It defaults to zero.
+func WithEmptyLeaf(emptyLeaf big.Int) CircuitOption {
+	return func(o *circuitOptions) {
+		o.emptyLeaf.Set(&emptyLeaf)
+	}
+}
+
+// WithCurve selects the curve the circuit is compiled and proved on. It
+// defaults to BN254, the only curve with an EVM verifier.
+func WithCurve(curve ecc.ID) CircuitOption {
+	return func(o *circuitOptions) {
+		o.curve = curve
+	}
+}
+
+func newCircuitOptions(opts []CircuitOption) circuitOptions {
+	o := circuitOptions{curve: ecc.BN254, commitment: CommitmentKeccak, treeHash: TreeHashPoseidon}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+func (ps *ProvingSystem) circuitOptions() circuitOptions {
+	return circuitOptions{curve: ps.Curve, publicPostRoot: ps.PublicPostRoot, emptyLeaf: ps.EmptyLeaf, commitment: ps.Commitment, indexed: ps.Indexed, nonZeroIdComms: ps.NonZeroIdComms, treeHash: ps.TreeHash, lookupKeccak: ps.LookupKeccak}
+}
+
+// wrap returns the circuit to compile or assign for the given options.
+func (o circuitOptions) wrap(circuit MbuCircuit, postRoot frontend.Variable) frontend.Circuit {
+	circuit.EmptyLeaf = &o.emptyLeaf
+	circuit.Commitment = o.commitment
+	circuit.TreeHash = o.treeHash
+	circuit.NonZeroIdComms = o.nonZeroIdComms
+	circuit.LookupKeccak = o.lookupKeccak
+	if o.indexed && circuit.Indices == nil {
+		circuit.Indices = make([]frontend.Variable, len(circuit.IdComms))
+	}
+	if o.publicPostRoot {
+		return &MbuCircuitWithPublicPostRoot{MbuCircuit: circuit, PublicPostRoot: postRoot}
+	}
+	return &circuit
+}
+
+// Digest returns a SHA-256 digest of a canonical encoding of the parameters,
+// which clients sign to authenticate requests. The input hash is derived from
+// the other fields and therefore not included. The encoding is
+//
+//	StartIndex || PreRoot || PostRoot || EmptyLeaf || len(IdComms) || IdComms ||
+//	len(MerkleProofs[0]) || MerkleProofs[0] || ... || len(MerkleProofs[n-1]) || MerkleProofs[n-1]
+//
+// followed, if there is a frontier instead of Merkle proofs, by
+// len(Frontier) || Frontier and, if there are indices, by
+// len(Indices) || Indices and, if it is given, by TreeDepth, with lengths,
+// StartIndex, indices and the tree depth as big-endian 32-bit integers and
+// all field elements as big-endian 32-byte integers.
+func (p *Parameters) Digest() [32]byte {
+	h := sha256.New()
+	var intBuf [4]byte
+	writeUint32 := func(v uint32) {
+		binary.BigEndian.PutUint32(intBuf[:], v)
+		h.Write(intBuf[:])
+	}
+	writeElement := func(v *big.Int) {
+		// Values beyond 256 bits are written in full, they are rejected
+		// before proving anyway.
+		b := v.Bytes()
+		if len(b) < 32 {
+			h.Write(make([]byte, 32-len(b)))
 		}
-		data = append(data, idBytes...)
+		h.Write(b)
 	}
-	hashBytes := keccak256.Hash(data)
-	p.InputHash.SetBytes(hashBytes)
-	return nil
+	writeUint32(p.StartIndex)
+	writeElement(&p.PreRoot)
+	writeElement(&p.PostRoot)
+	writeElement(&p.EmptyLeaf)
+	writeUint32(uint32(len(p.IdComms)))
+	for i := range p.IdComms {
+		writeElement(&p.IdComms[i])
+	}
+	for i := range p.MerkleProofs {
+		writeUint32(uint32(len(p.MerkleProofs[i])))
+		for j := range p.MerkleProofs[i] {
+			writeElement(&p.MerkleProofs[i][j])
+		}
+	}
+	if p.Frontier != nil {
+		writeUint32(uint32(len(p.Frontier)))
+		for i := range p.Frontier {
+			writeElement(&p.Frontier[i])
+		}
+	}
+	if len(p.Indices) != 0 {
+		writeUint32(uint32(len(p.Indices)))
+		for _, index := range p.Indices {
+			writeUint32(index)
+		}
+	}
+	if p.TreeDepth != 0 {
+		writeUint32(p.TreeDepth)
+	}
+	var digest [32]byte
+	h.Sum(digest[:0])
+	return digest
 }
 
-func BuildR1CS(treeDepth uint32, batchSize uint32) (constraint.ConstraintSystem, error) {
+// BuildR1CS compiles the circuit. Gnark cannot interrupt the compilation,
+// so ctx is only checked before it starts.
+func BuildR1CS(ctx context.Context, treeDepth uint32, batchSize uint32, opts ...CircuitOption) (constraint.ConstraintSystem, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	proofs := make([][]frontend.Variable, batchSize)
 	for i := 0; i < int(batchSize); i++ {
 		proofs[i] = make([]frontend.Variable, treeDepth)
@@ -97,69 +329,160 @@ func BuildR1CS(treeDepth uint32, batchSize uint32) (constraint.ConstraintSystem,
 		IdComms:      make([]frontend.Variable, batchSize),
 		MerkleProofs: proofs,
 	}
-	return frontend.Compile(ecc.BN254.ScalarField(), r1cs.NewBuilder, &circuit)
+	options := newCircuitOptions(opts)
+	if err := validateCurve(options.curve); err != nil {
+		return nil, err
+	}
+	if err := validateCommitment(options.commitment, options.curve); err != nil {
+		return nil, err
+	}
+	if _, err := ParseTreeHash(string(options.treeHash)); err != nil {
+		return nil, err
+	}
+	if err := validateLookupKeccak(options); err != nil {
+		return nil, err
+	}
+	return frontend.Compile(options.curve.ScalarField(), r1cs.NewBuilder, options.wrap(circuit, nil))
 }
 
-func Setup(treeDepth uint32, batchSize uint32) (*ProvingSystem, error) {
-	ccs, err := BuildR1CS(treeDepth, batchSize)
+// Setup compiles the circuit and generates its keys, checking ctx between
+// the two.
+func Setup(ctx context.Context, treeDepth uint32, batchSize uint32, opts ...CircuitOption) (*ProvingSystem, error) {
+	ccs, err := BuildR1CS(ctx, treeDepth, batchSize, opts...)
 	if err != nil {
 		return nil, err
 	}
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
 	pk, vk, err := groth16.Setup(ccs)
 	if err != nil {
 		return nil, err
 	}
-	return &ProvingSystem{treeDepth, batchSize, pk, vk, ccs}, nil
+	options := newCircuitOptions(opts)
+	return &ProvingSystem{
+		Curve:            options.curve,
+		TreeDepth:        treeDepth,
+		BatchSize:        batchSize,
+		PublicPostRoot:   options.publicPostRoot,
+		EmptyLeaf:        options.emptyLeaf,
+		Commitment:       options.commitment,
+		Indexed:          options.indexed,
+		NonZeroIdComms:   options.nonZeroIdComms,
+		TreeHash:         options.treeHash,
+		LookupKeccak:     options.lookupKeccak,
+		ProvingKey:       pk,
+		VerifyingKey:     vk,
+		ConstraintSystem: ccs,
+	}, nil
 }
 
+// ExportSolidity writes the verifier contracts, see
+// VerifyingSystem.ExportSolidity.
 func (ps *ProvingSystem) ExportSolidity(writer io.Writer) error {
-	return ps.VerifyingKey.ExportSolidity(writer)
+	return ps.VerifyingSystem().ExportSolidity(writer)
 }
 
-func (ps *ProvingSystem) Prove(params *Parameters) (*Proof, error) {
-	if err := params.ValidateShape(ps.TreeDepth, ps.BatchSize); err != nil {
-		return nil, err
+// checkInputHash recomputes the input hash of the parameters, replacing the
+// supplied one. A supplied hash that differs from the computed one, modulo the
+// scalar field, is reported as an InputHashMismatchError; a zero hash counts
+// as not supplied.
+func (ps *ProvingSystem) checkInputHash(params *Parameters) error {
+	var supplied big.Int
+	supplied.Set(&params.InputHash)
+	if err := params.ComputeInputHashWith(ps.Commitment); err != nil {
+		return err
 	}
-	idComms := make([]frontend.Variable, ps.BatchSize)
-	for i := 0; i < int(ps.BatchSize); i++ {
-		idComms[i] = params.IdComms[i]
+	if supplied.Sign() == 0 {
+		return nil
 	}
-	proofs := make([][]frontend.Variable, ps.BatchSize)
-	for i := 0; i < int(ps.BatchSize); i++ {
-		proofs[i] = make([]frontend.Variable, ps.TreeDepth)
-		for j := 0; j < int(ps.TreeDepth); j++ {
-			proofs[i][j] = params.MerkleProofs[i][j]
-		}
+	field := ps.Curve.ScalarField()
+	if new(big.Int).Mod(&supplied, field).Cmp(new(big.Int).Mod(&params.InputHash, field)) != 0 {
+		mismatch := &InputHashMismatchError{Supplied: supplied}
+		mismatch.Computed.Set(&params.InputHash)
+		return mismatch
 	}
-	assignment := MbuCircuit{
-		InputHash:    params.InputHash,
-		StartIndex:   params.StartIndex,
-		PreRoot:      params.PreRoot,
-		PostRoot:     params.PostRoot,
-		IdComms:      idComms,
-		MerkleProofs: proofs,
+	return nil
+}
+
+// Prove proves a batch. ctx is checked before the witness is built and
+// before the proof is generated, which gnark cannot interrupt; a done ctx
+// fails the proof with its error.
+func (ps *ProvingSystem) Prove(ctx context.Context, params *Parameters) (*Proof, error) {
+	return ps.ProveWithProgress(ctx, params, nil)
+}
+
+// ProveWithProgress is Prove, reporting the stages of the proof to progress
+// if it is not nil. Gnark does not report the stages of groth16.Prove, so
+// the FFT and multi-exponentiation stages are estimated from the duration
+// of the previous proof. StageDone is only reported for proofs that succeed.
+func (ps *ProvingSystem) ProveWithProgress(ctx context.Context, params *Parameters, progress Progress) (*Proof, error) {
+	if progress == nil {
+		progress = func(ProofStage) {}
 	}
-	witness, err := frontend.NewWitness(&assignment, ecc.BN254.ScalarField())
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	progress(StageWitness)
+	buffers := ps.witnessBuffers()
+	defer ps.releaseWitnessBuffers(buffers)
+	witness, err := ps.buildWitnessInto(params, buffers)
 	if err != nil {
 		return nil, err
 	}
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
 	logging.Logger().Info().Msg("generating proof")
-	proof, err := groth16.Prove(ps.ConstraintSystem, ps.ProvingKey, witness)
+	start := time.Now()
+	proof, err := func() (groth16.Proof, error) {
+		defer ps.estimateStages(progress)()
+		return groth16.Prove(ps.ConstraintSystem, ps.ProvingKey, witness, ps.ProverOptions...)
+	}()
 	if err != nil {
+		err = ps.unsatisfiedError(params, err)
+		ps.dumpWitness(params, witness, err)
 		return nil, err
 	}
+	ps.provingTime.Store(int64(time.Since(start)))
 	logging.Logger().Info().Msg("proof generated successfully")
+	progress(StageDone)
 	return &Proof{proof}, nil
 }
 
-func (ps *ProvingSystem) Verify(inputHash big.Int, proof *Proof) error {
-	publicAssignment := MbuCircuit{
-		InputHash: inputHash,
-		IdComms:   make([]frontend.Variable, ps.BatchSize),
-	}
-	witness, err := frontend.NewWitness(&publicAssignment, ecc.BN254.ScalarField(), frontend.PublicOnly())
+// Check solves the circuit for params without proving, which takes a
+// fraction of the time of Prove, and fails like Prove would.
+func (ps *ProvingSystem) Check(params *Parameters) error {
+	buffers := ps.witnessBuffers()
+	defer ps.releaseWitnessBuffers(buffers)
+	witness, err := ps.buildWitnessInto(params, buffers)
 	if err != nil {
 		return err
 	}
-	return groth16.Verify(proof.Proof, ps.VerifyingKey, witness)
+	opts := append(solveCommitment(ps.ConstraintSystem), ps.ProverOptions...)
+	if err = ps.ConstraintSystem.IsSolved(witness, opts...); err != nil {
+		return ps.unsatisfiedError(params, err)
+	}
+	return nil
+}
+
+// unsatisfiedError maps the error of params whose witness failed to solve
+// back to its cause, found by recomputing the roots natively.
+func (ps *ProvingSystem) unsatisfiedError(params *Parameters, err error) error {
+	if rootErr := ps.checkRoots(params); rootErr != nil {
+		return rootErr
+	}
+	// The input hash was recomputed before solving, so the circuit and
+	// the native computation disagree.
+	return &WitnessError{Err: fmt.Errorf("%w, although the input hash and roots check out natively", err)}
+}
+
+func (ps *ProvingSystem) Verify(inputHash big.Int, proof *Proof) error {
+	return ps.VerifyingSystem().Verify(inputHash, proof)
+}
+
+// VerifyWithPostRoot verifies a proof generated by a proving system set up
+// with WithPublicPostRoot.
+func (ps *ProvingSystem) VerifyWithPostRoot(inputHash big.Int, postRoot big.Int, proof *Proof) error {
+	return ps.VerifyingSystem().VerifyWithPostRoot(inputHash, postRoot, proof)
 }
@@ -0,0 +1,289 @@
+package prover
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend"
+	"github.com/iden3/go-iden3-crypto/keccak256"
+)
+
+func TestCheckInputHash(t *testing.T) {
+	ps := &ProvingSystem{Curve: ecc.BN254, TreeDepth: testTreeDepth, BatchSize: testBatchSize}
+	expected := testParameters()
+
+	params := testParameters()
+	params.InputHash.SetUint64(0)
+	if err := ps.checkInputHash(params); err != nil {
+		t.Fatal(err)
+	}
+	if params.InputHash.Cmp(&expected.InputHash) != 0 {
+		t.Fatalf("expected the input hash to be computed, got %s", toHex(&params.InputHash))
+	}
+
+	params = testParameters()
+	params.InputHash.Mod(&params.InputHash, ecc.BN254.ScalarField())
+	if err := ps.checkInputHash(params); err != nil {
+		t.Fatalf("expected a reduced input hash to be accepted: %s", err)
+	}
+
+	params = testParameters()
+	params.InputHash.Add(&params.InputHash, big.NewInt(1))
+	err := ps.checkInputHash(params)
+	var mismatch *InputHashMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("expected an input hash mismatch, got %v", err)
+	}
+	if mismatch.Computed.Cmp(&expected.InputHash) != 0 {
+		t.Fatalf("expected the computed hash %s, got %s", toHex(&expected.InputHash), toHex(&mismatch.Computed))
+	}
+}
+
+func TestComputeInputHashPadsRoots(t *testing.T) {
+	params := testParameters()
+	params.PreRoot.SetUint64(1)
+	params.ComputeInputHash()
+
+	var data []byte
+	data = append(data, 0, 0, 0, 0)
+	data = append(data, params.PreRoot.FillBytes(make([]byte, 32))...)
+	data = append(data, params.PostRoot.FillBytes(make([]byte, 32))...)
+	for i := range params.IdComms {
+		data = append(data, params.IdComms[i].FillBytes(make([]byte, 32))...)
+	}
+	expected := new(big.Int).SetBytes(keccak256.Hash(data))
+	if expected.Cmp(&params.InputHash) != 0 {
+		t.Fatalf("expected %s, got %s", toHex(expected), toHex(&params.InputHash))
+	}
+}
+
+func TestValidateShapeErrors(t *testing.T) {
+	params := testParameters()
+	params.IdComms = params.IdComms[:1]
+	var batchSize *BatchSizeError
+	if err := params.ValidateShape(testTreeDepth, testBatchSize); !errors.As(err, &batchSize) {
+		t.Fatalf("expected a batch size error, got %v", err)
+	}
+
+	params = testParameters()
+	for i := range params.MerkleProofs {
+		params.MerkleProofs[i] = params.MerkleProofs[i][:testTreeDepth-1]
+	}
+	var treeDepth *TreeDepthError
+	if err := params.ValidateShape(testTreeDepth, testBatchSize); !errors.As(err, &treeDepth) {
+		t.Fatalf("expected a tree depth error, got %v", err)
+	}
+
+	params = testParameters()
+	params.MerkleProofs[1] = params.MerkleProofs[1][:testTreeDepth-1]
+	var length *ProofLengthError
+	if err := params.ValidateShape(testTreeDepth, testBatchSize); !errors.As(err, &length) {
+		t.Fatalf("expected a proof length error, got %v", err)
+	}
+	if length.Proof != 1 {
+		t.Fatalf("expected proof 1 to be reported, got %d", length.Proof)
+	}
+
+	params = testParameters()
+	params.TreeDepth = testTreeDepth + 10
+	var notLoaded *CircuitNotLoadedError
+	if err := params.ValidateShape(testTreeDepth, testBatchSize); !errors.As(err, &notLoaded) || ErrorCode(err) != "circuit_not_loaded" {
+		t.Fatalf("expected a circuit not loaded error, got %v", err)
+	}
+	if notLoaded.TreeDepth != testTreeDepth+10 || notLoaded.LoadedTreeDepth != testTreeDepth {
+		t.Fatalf("unexpected error %+v", notLoaded)
+	}
+	params.TreeDepth = testTreeDepth
+	if err := params.ValidateShape(testTreeDepth, testBatchSize); err != nil {
+		t.Fatalf("expected the tree depth of the circuit to be accepted, got %v", err)
+	}
+}
+
+func TestCheckRoots(t *testing.T) {
+	ps := &ProvingSystem{Curve: ecc.BN254, TreeDepth: testTreeDepth, BatchSize: testBatchSize}
+	if err := ps.checkRoots(testParameters()); err != nil {
+		t.Fatal(err)
+	}
+
+	params := testParameters()
+	params.MerkleProofs[1][0].SetUint64(42)
+	var mismatch *RootMismatchError
+	if err := ps.checkRoots(params); !errors.As(err, &mismatch) {
+		t.Fatalf("expected a root mismatch, got %v", err)
+	}
+	if mismatch.Proof != 1 || mismatch.Index != 1 {
+		t.Fatalf("expected proof 1 at index 1 to be reported, got proof %d at index %d", mismatch.Proof, mismatch.Index)
+	}
+
+	params = testParameters()
+	params.PostRoot.SetUint64(1)
+	if err := ps.checkRoots(params); !errors.As(err, &mismatch) || mismatch.Proof != -1 {
+		t.Fatalf("expected a post root mismatch, got %v", err)
+	}
+
+	// A proof computed against the pre root, ignoring the first insertion.
+	params = testParameters()
+	params.MerkleProofs[1][0] = params.EmptyLeaf
+	if err := ps.checkRoots(params); !errors.As(err, &mismatch) || mismatch.Proof != 1 || !mismatch.OpensPreRoot {
+		t.Fatalf("expected proof 1 to be reported as opening the pre root, got %v", err)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	cs, err := BuildR1CS(context.Background(), testTreeDepth, testBatchSize, WithCommitment(CommitmentPoseidon))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps := &ProvingSystem{Curve: ecc.BN254, TreeDepth: testTreeDepth, BatchSize: testBatchSize, Commitment: CommitmentPoseidon, ConstraintSystem: cs}
+	// The input hash of testParameters is the keccak one, let Check compute
+	// the poseidon one.
+	poseidonParameters := func() *Parameters {
+		params := testParameters()
+		params.InputHash.SetUint64(0)
+		return params
+	}
+	if err = ps.Check(poseidonParameters()); err != nil {
+		t.Fatal(err)
+	}
+
+	params := poseidonParameters()
+	params.MerkleProofs[1][0].SetUint64(42)
+	var mismatch *RootMismatchError
+	if err = ps.Check(params); !errors.As(err, &mismatch) || mismatch.Proof != 1 {
+		t.Fatalf("expected proof 1 to be reported, got %v", err)
+	}
+
+	params = poseidonParameters()
+	params.IdComms = params.IdComms[:1]
+	var batchSize *BatchSizeError
+	if err = ps.Check(params); !errors.As(err, &batchSize) {
+		t.Fatalf("expected a batch size error, got %v", err)
+	}
+
+	// The prover options reach the solver.
+	errOption := errors.New("option applied")
+	ps.ProverOptions = []backend.ProverOption{func(*backend.ProverConfig) error { return errOption }}
+	if err = ps.Check(poseidonParameters()); !errors.Is(err, errOption) {
+		t.Fatalf("expected the prover options to be applied, got %v", err)
+	}
+}
+
+func TestCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := BuildR1CS(ctx, testTreeDepth, testBatchSize); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the compilation to be cancelled, got %v", err)
+	}
+	if _, err := Setup(ctx, testTreeDepth, testBatchSize); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the setup to be cancelled, got %v", err)
+	}
+	// The keys are not needed, as the proof is cancelled before it starts.
+	ps := &ProvingSystem{Curve: ecc.BN254, TreeDepth: testTreeDepth, BatchSize: testBatchSize}
+	if _, err := ps.Prove(ctx, testParameters()); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the proof to be cancelled, got %v", err)
+	}
+}
+
+func TestParametersVerify(t *testing.T) {
+	if err := testParameters().Verify(testTreeDepth, testBatchSize); err != nil {
+		t.Fatal(err)
+	}
+
+	params := testParameters()
+	params.StartIndex = 7
+	var startIndex *StartIndexError
+	if err := params.Verify(testTreeDepth, testBatchSize); !errors.As(err, &startIndex) {
+		t.Fatalf("expected a start index error, got %v", err)
+	}
+
+	var batchSize *BatchSizeError
+	if err := testParameters().Verify(testTreeDepth, testBatchSize+1); !errors.As(err, &batchSize) {
+		t.Fatalf("expected a batch size error, got %v", err)
+	}
+
+	params = testParameters()
+	params.IdComms[0].SetUint64(5)
+	var mismatch *RootMismatchError
+	if err := params.Verify(testTreeDepth, testBatchSize); !errors.As(err, &mismatch) || mismatch.Proof != 1 {
+		t.Fatalf("expected proof 1 to be reported, got %v", err)
+	}
+}
+
+func TestValidateCapacity(t *testing.T) {
+	params := testParameters()
+	params.StartIndex = 1<<testTreeDepth - testBatchSize
+	if err := params.ValidateCapacity(testTreeDepth); err != nil {
+		t.Fatalf("expected the last slots of the tree to fit, got %v", err)
+	}
+	params.StartIndex++
+	var startIndex *StartIndexError
+	if err := params.ValidateCapacity(testTreeDepth); !errors.As(err, &startIndex) {
+		t.Fatalf("expected a start index error, got %v", err)
+	}
+	params.StartIndex = 1<<32 - 1
+	if err := params.ValidateCapacity(64); err != nil {
+		t.Fatalf("expected any start index to fit a tree of depth 64, got %v", err)
+	}
+
+	params = indexedParameters()
+	params.Indices[1] = 1 << testTreeDepth
+	var index *IndexError
+	if err := params.ValidateCapacity(testTreeDepth); !errors.As(err, &index) || index.Proof != 1 {
+		t.Fatalf("expected index 1 to be reported, got %v", err)
+	}
+
+	// The capacity is checked before the witness is built.
+	cs, err := BuildR1CS(context.Background(), testTreeDepth, testBatchSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps := &ProvingSystem{Curve: ecc.BN254, TreeDepth: testTreeDepth, BatchSize: testBatchSize, ConstraintSystem: cs}
+	params = testParameters()
+	params.StartIndex = 1 << testTreeDepth
+	if err = ps.Check(params); !errors.As(err, &startIndex) {
+		t.Fatalf("expected a start index error, got %v", err)
+	}
+}
+
+func TestValidateAlignment(t *testing.T) {
+	params := testParameters()
+	params.StartIndex = 4
+	if err := params.ValidateAlignment(0); err != nil {
+		t.Fatal(err)
+	}
+	if err := params.ValidateAlignment(2); err != nil {
+		t.Fatal(err)
+	}
+	var alignment *StartIndexAlignmentError
+	if err := params.ValidateAlignment(8); !errors.As(err, &alignment) || alignment.Alignment != 8 {
+		t.Fatalf("expected an alignment error, got %v", err)
+	}
+	if err := indexedParameters().ValidateAlignment(8); err != nil {
+		t.Fatalf("expected insertions at indices not to be checked, got %v", err)
+	}
+}
+
+func TestValidateIdComms(t *testing.T) {
+	params := testParameters()
+	params.IdComms[1].SetUint64(0)
+	var zero *ZeroIdCommError
+	if err := params.ValidateShape(testTreeDepth, testBatchSize); !errors.As(err, &zero) || zero.Index != 1 {
+		t.Fatalf("expected identity commitment 1 to be reported as zero, got %v", err)
+	}
+	params.IdComms[1].Set(ecc.BN254.ScalarField())
+	var field *FieldElementError
+	if err := params.ValidateShape(testTreeDepth, testBatchSize); !errors.As(err, &field) {
+		t.Fatalf("expected a field element error, got %v", err)
+	}
+
+	// The empty leaves padding split batches are not identity commitments.
+	params = testParameters()
+	params.IdComms[1].SetUint64(0)
+	params.padding = 1
+	if err := params.ValidateShape(testTreeDepth, testBatchSize); err != nil {
+		t.Fatalf("expected padding to be accepted, got %v", err)
+	}
+}
@@ -0,0 +1,218 @@
+package prover
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+
+	"github.com/consensys/gnark/constraint"
+)
+
+// R1CSFormat is a format constraint systems are exported in.
+type R1CSFormat string
+
+const (
+	// R1CSFormatGnark is the gnark binary encoding, as written by r1cs.
+	R1CSFormatGnark R1CSFormat = "gnark"
+	// R1CSFormatCircom is the .r1cs binary format of circom, read by snarkjs
+	// and other tools of the iden3 ecosystem.
+	R1CSFormatCircom R1CSFormat = "circom"
+)
+
+// ParseR1CSFormat parses the name of a constraint system export format.
+func ParseR1CSFormat(name string) (R1CSFormat, error) {
+	switch format := R1CSFormat(name); format {
+	case R1CSFormatGnark, R1CSFormatCircom:
+		return format, nil
+	default:
+		return "", fmt.Errorf("unknown constraint system format: %s", name)
+	}
+}
+
+// ExportR1CS writes cs to w in format and returns the number of bytes
+// written.
+func ExportR1CS(w io.Writer, cs constraint.ConstraintSystem, format R1CSFormat) (int64, error) {
+	switch format {
+	case R1CSFormatGnark:
+		return cs.WriteTo(w)
+	case R1CSFormatCircom:
+		return WriteCircomR1CS(w, cs)
+	default:
+		return 0, fmt.Errorf("unknown constraint system format: %s", format)
+	}
+}
+
+// circomTerm is a term of a linear combination of a circom constraint.
+type circomTerm struct {
+	wire  uint32
+	coeff *big.Int
+}
+
+// circomR1CS holds the sections of a constraint system in the circom format.
+type circomR1CS struct {
+	field       *big.Int
+	fieldSize   int
+	constraints [][3][]circomTerm
+}
+
+// newCircomR1CS converts the constraints of cs. gnark numbers the wires like
+// circom: the constant one, then the public inputs, the secret inputs and the
+// internal wires. Terms of the same wire are merged, zero terms dropped and
+// the others sorted by wire.
+func newCircomR1CS(cs constraint.R1CS) (*circomR1CS, error) {
+	field := cs.Field()
+	constraints, resolver := cs.GetConstraints()
+	// Coefficients are resolved once; their string form may be negative.
+	coeffs := make(map[int]*big.Int)
+	coeff := func(id int) (*big.Int, error) {
+		if c, ok := coeffs[id]; ok {
+			return c, nil
+		}
+		c, ok := new(big.Int).SetString(resolver.CoeffToString(id), 10)
+		if !ok {
+			return nil, fmt.Errorf("invalid coefficient %d: %s", id, resolver.CoeffToString(id))
+		}
+		c.Mod(c, field)
+		coeffs[id] = c
+		return c, nil
+	}
+	combination := func(expression constraint.LinearExpression) ([]circomTerm, error) {
+		sums := make(map[uint32]*big.Int)
+		for _, term := range expression {
+			wire := uint32(term.WireID())
+			if term.IsConstant() {
+				wire = 0
+			}
+			c, err := coeff(term.CoeffID())
+			if err != nil {
+				return nil, err
+			}
+			if sum, ok := sums[wire]; ok {
+				sum.Add(sum, c).Mod(sum, field)
+			} else {
+				sums[wire] = new(big.Int).Set(c)
+			}
+		}
+		terms := make([]circomTerm, 0, len(sums))
+		for wire, sum := range sums {
+			if sum.Sign() != 0 {
+				terms = append(terms, circomTerm{wire, sum})
+			}
+		}
+		sort.Slice(terms, func(i, j int) bool { return terms[i].wire < terms[j].wire })
+		return terms, nil
+	}
+	exported := &circomR1CS{
+		field:       field,
+		fieldSize:   (field.BitLen() + 63) / 64 * 8,
+		constraints: make([][3][]circomTerm, len(constraints)),
+	}
+	for i, r1c := range constraints {
+		for j, expression := range []constraint.LinearExpression{r1c.L, r1c.R, r1c.O} {
+			terms, err := combination(expression)
+			if err != nil {
+				return nil, err
+			}
+			exported.constraints[i][j] = terms
+		}
+	}
+	return exported, nil
+}
+
+// countingWriter counts the bytes written to a bufio.Writer.
+type countingWriter struct {
+	*bufio.Writer
+	n   int64
+	err error
+}
+
+func (w *countingWriter) write(data []byte) {
+	if w.err != nil {
+		return
+	}
+	var n int
+	n, w.err = w.Write(data)
+	w.n += int64(n)
+}
+
+func (w *countingWriter) uint32(v uint32) {
+	w.write(binary.LittleEndian.AppendUint32(nil, v))
+}
+
+func (w *countingWriter) uint64(v uint64) {
+	w.write(binary.LittleEndian.AppendUint64(nil, v))
+}
+
+// element writes a field element in size little-endian bytes.
+func (w *countingWriter) element(v *big.Int, size int) {
+	bytes := v.FillBytes(make([]byte, size))
+	for i, j := 0, len(bytes)-1; i < j; i, j = i+1, j-1 {
+		bytes[i], bytes[j] = bytes[j], bytes[i]
+	}
+	w.write(bytes)
+}
+
+// WriteCircomR1CS writes the R1CS cs in version 1 of the .r1cs format of
+// circom: a header section, a constraints section and a section mapping
+// every wire to a label of the same number, with the coefficients little
+// endian in canonical form. The circuit has no public outputs, its public
+// inputs are circom public inputs.
+func WriteCircomR1CS(w io.Writer, cs constraint.ConstraintSystem) (int64, error) {
+	r1cs, ok := cs.(constraint.R1CS)
+	if !ok {
+		return 0, fmt.Errorf("only R1CS constraint systems can be written in the circom format")
+	}
+	exported, err := newCircomR1CS(r1cs)
+	if err != nil {
+		return 0, err
+	}
+	internal, secret, public := cs.GetNbVariables()
+	nbWires := internal + secret + public
+	constraintsSize := uint64(0)
+	for _, c := range exported.constraints {
+		for _, terms := range c {
+			constraintsSize += 4 + uint64(len(terms))*uint64(4+exported.fieldSize)
+		}
+	}
+
+	out := &countingWriter{Writer: bufio.NewWriter(w)}
+	out.write([]byte("r1cs"))
+	out.uint32(1)
+	out.uint32(3)
+
+	out.uint32(1)
+	out.uint64(uint64(4 + exported.fieldSize + 4*4 + 8 + 4))
+	out.uint32(uint32(exported.fieldSize))
+	out.element(exported.field, exported.fieldSize)
+	out.uint32(uint32(nbWires))
+	out.uint32(0)
+	out.uint32(uint32(public - 1))
+	out.uint32(uint32(secret))
+	out.uint64(uint64(nbWires))
+	out.uint32(uint32(len(exported.constraints)))
+
+	out.uint32(2)
+	out.uint64(constraintsSize)
+	for _, c := range exported.constraints {
+		for _, terms := range c {
+			out.uint32(uint32(len(terms)))
+			for _, term := range terms {
+				out.uint32(term.wire)
+				out.element(term.coeff, exported.fieldSize)
+			}
+		}
+	}
+
+	out.uint32(3)
+	out.uint64(uint64(8 * nbWires))
+	for i := 0; i < nbWires; i++ {
+		out.uint64(uint64(i))
+	}
+	if out.err != nil {
+		return out.n, out.err
+	}
+	return out.n, out.Flush()
+}
@@ -0,0 +1,100 @@
+package prover
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/big"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+)
+
+// circomReader reads the little-endian values of a circom .r1cs file.
+type circomReader struct {
+	data []byte
+}
+
+func (r *circomReader) next(n int) []byte {
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *circomReader) uint32() uint32 { return binary.LittleEndian.Uint32(r.next(4)) }
+func (r *circomReader) uint64() uint64 { return binary.LittleEndian.Uint64(r.next(8)) }
+
+func (r *circomReader) element(size int) *big.Int {
+	le := r.next(size)
+	be := make([]byte, size)
+	for i := range le {
+		be[size-1-i] = le[i]
+	}
+	return new(big.Int).SetBytes(be)
+}
+
+func TestWriteCircomR1CS(t *testing.T) {
+	cs, err := frontend.Compile(ecc.BN254.ScalarField(), r1cs.NewBuilder, &squareCircuit{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	written, err := ExportR1CS(&buf, cs, R1CSFormatCircom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written != int64(buf.Len()) {
+		t.Fatalf("expected %d bytes to be reported written, got %d", buf.Len(), written)
+	}
+
+	r := &circomReader{buf.Bytes()}
+	if magic := string(r.next(4)); magic != "r1cs" || r.uint32() != 1 || r.uint32() != 3 {
+		t.Fatalf("expected an r1cs v1 file with 3 sections, got magic %q", magic)
+	}
+	if r.uint32() != 1 || r.uint64() != 64 {
+		t.Fatal("expected the header section")
+	}
+	size := int(r.uint32())
+	field := r.element(size)
+	if field.Cmp(ecc.BN254.ScalarField()) != 0 {
+		t.Fatalf("expected the BN254 scalar field, got %s", field)
+	}
+	nbWires, outputs, public, secret, labels, nbConstraints := r.uint32(), r.uint32(), r.uint32(), r.uint32(), r.uint64(), r.uint32()
+	if outputs != 0 || public != 1 || secret != 1 || labels != uint64(nbWires) || int(nbConstraints) != cs.GetNbConstraints() {
+		t.Fatalf("unexpected header: %d wires, %d outputs, %d public, %d secret, %d labels, %d constraints", nbWires, outputs, public, secret, labels, nbConstraints)
+	}
+
+	// The constant one, Y = 9, X = 3 and the product X*X satisfy every
+	// constraint.
+	if nbWires != 4 {
+		t.Fatalf("expected a single internal wire, got %d wires", nbWires)
+	}
+	witness := []*big.Int{big.NewInt(1), big.NewInt(9), big.NewInt(3), big.NewInt(9)}
+	if r.uint32() != 2 {
+		t.Fatal("expected the constraints section")
+	}
+	r.uint64()
+	for i := uint32(0); i < nbConstraints; i++ {
+		var values [3]big.Int
+		for j := range values {
+			n := r.uint32()
+			for k := uint32(0); k < n; k++ {
+				wire, coeff := r.uint32(), r.element(size)
+				values[j].Add(&values[j], coeff.Mul(coeff, witness[wire]))
+			}
+			values[j].Mod(&values[j], field)
+		}
+		product := new(big.Int).Mul(&values[0], &values[1])
+		if product.Mod(product, field).Cmp(&values[2]) != 0 {
+			t.Fatalf("expected constraint %d to be satisfied", i)
+		}
+	}
+	if r.uint32() != 3 || r.uint64() != uint64(8*nbWires) || len(r.data) != int(8*nbWires) {
+		t.Fatal("expected the wire to label section to end the file")
+	}
+
+	if _, err = ParseR1CSFormat("bellman"); err == nil {
+		t.Fatal("expected an unknown format to be rejected")
+	}
+}
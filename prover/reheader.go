@@ -0,0 +1,122 @@
+package prover
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"time"
+	"worldcoin/gnark-mbu/logging"
+
+	"github.com/consensys/gnark"
+	"github.com/consensys/gnark/backend/groth16"
+)
+
+// ReheaderKeysFromFile reads a key file whose header is stale, written by a
+// prover built with another gnark version of the same key encoding, or before
+// key files had a header, keeping its keys so that upgrading gnark does not
+// take a new trusted setup. It does not convert keys between encodings: they
+// are decoded, with the point checks of gnark, in the encoding of the gnark
+// version the prover is built with, and files in another encoding fail with
+// an error naming both versions. The constraint system of the file is skipped
+// and compiled again, so the circuit version of the file must be the
+// prover's. Proofs of the re-headered system should be checked against its
+// verifying key before it is written.
+func ReheaderKeysFromFile(ctx context.Context, path string) (ps *ProvingSystem, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := file.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}()
+	digest := sha256.New()
+	hashed := io.TeeReader(file, digest)
+	header, _, err := readKeysFileHeader(hashed)
+	if err != nil {
+		return nil, err
+	}
+	if header.Fingerprint != "" && header.Fingerprint != header.fingerprint() {
+		return nil, &CorruptKeysError{Reason: "the header does not match its fingerprint"}
+	}
+	// Files written before the circuit version was recorded are checked by
+	// the test proof only.
+	if header.CircuitVersion != 0 && header.CircuitVersion != CircuitVersion {
+		return nil, &StaleKeysError{Field: "circuit version", Expected: fmt.Sprint(CircuitVersion), Actual: fmt.Sprint(header.CircuitVersion)}
+	}
+	ps = new(ProvingSystem)
+	if err = ps.setCircuit(header); err != nil {
+		return nil, err
+	}
+	log := logging.Logger().With().Uint32("treeDepth", ps.TreeDepth).Uint32("batchSize", ps.BatchSize).Str("fromGnark", header.GnarkVersion).Str("toGnark", gnark.Version.String()).Logger()
+
+	start := time.Now()
+	log.Info().Msg("decoding the keys")
+	ps.ProvingKey = groth16.NewProvingKey(ps.Curve)
+	if _, err = ps.ProvingKey.ReadFrom(hashed); err != nil {
+		return nil, keysDecodeError(header, "proving key", keysReadError(header, err))
+	}
+	ps.VerifyingKey = groth16.NewVerifyingKey(ps.Curve)
+	if _, err = ps.VerifyingKey.ReadFrom(hashed); err != nil {
+		return nil, keysDecodeError(header, "verifying key", keysReadError(header, err))
+	}
+	if header.Checksum != "" {
+		if err = skipFramed(hashed); err != nil {
+			return nil, keysReadError(header, err)
+		}
+		if _, err = readKeysFileChecksum(file, header, digest); err != nil {
+			return nil, err
+		}
+	}
+	log.Info().Dur("took", time.Since(start)).Msg("keys decoded")
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	start = time.Now()
+	log.Info().Msg("compiling the circuit")
+	options := ps.circuitOptions()
+	ps.ConstraintSystem, err = BuildR1CS(ctx, ps.TreeDepth, ps.BatchSize, func(o *circuitOptions) { *o = options })
+	if err != nil {
+		return nil, err
+	}
+	log.Info().Int("constraints", ps.ConstraintSystem.GetNbConstraints()).Dur("took", time.Since(start)).Msg("circuit compiled")
+	return ps, nil
+}
+
+// keysDecodeError names the gnark versions involved in a key that cannot be
+// decoded.
+func keysDecodeError(header *keysFileHeader, key string, err error) error {
+	from := header.GnarkVersion
+	if from == "" {
+		from = "an unknown gnark version"
+	}
+	return fmt.Errorf("decoding the %s written by %s with gnark %s: %w", key, from, gnark.Version, err)
+}
+
+// skipFramed skips an object written by writeFramed.
+func skipFramed(r io.Reader) error {
+	var lengthBuf [8]byte
+	if _, err := io.ReadFull(r, lengthBuf[:]); err != nil {
+		return err
+	}
+	_, err := io.CopyN(io.Discard, r, int64(binary.BigEndian.Uint64(lengthBuf[:])))
+	return err
+}
+
+// WriteToFile writes the proving system to path like WriteTo, or WriteRawTo
+// if raw is set, with a current header. The file only appears at path once it
+// is complete.
+func (ps *ProvingSystem) WriteToFile(ctx context.Context, path string, raw bool) (int64, error) {
+	var cs bytes.Buffer
+	if _, err := ps.ConstraintSystem.WriteTo(&cs); err != nil {
+		return 0, err
+	}
+	header := ps.keysFileHeader()
+	return writeKeysFile(ctx, path, raw, &header, ps.ProvingKey, ps.VerifyingKey, &cs, int64(cs.Len()))
+}
@@ -0,0 +1,82 @@
+package prover
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeStaleKeys writes the keys of ps to a file whose header is edited by
+// stale, as another prover version would have written it.
+func writeStaleKeys(t *testing.T, ps *ProvingSystem, stale func(*keysFileHeader)) string {
+	header := ps.keysFileHeader()
+	stale(&header)
+	header.Fingerprint = header.fingerprint()
+	var buf bytes.Buffer
+	kw := newKeysFileWriter(&buf, false)
+	if err := kw.header(&header); err != nil {
+		t.Fatal(err)
+	}
+	if err := kw.key(ps.ProvingKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := kw.key(ps.VerifyingKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := kw.write(func(w io.Writer) (int64, error) { return writeFramed(w, ps.ConstraintSystem) }); err != nil {
+		t.Fatal(err)
+	}
+	if err := kw.finish(); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "stale.ps")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReheaderKeys(t *testing.T) {
+	ctx := context.Background()
+	ps, err := Setup(ctx, testTreeDepth, 1, WithCommitment(CommitmentPoseidon))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale := writeStaleKeys(t, ps, func(header *keysFileHeader) { header.GnarkVersion = "v0.7.1" })
+	var staleErr *StaleKeysError
+	if _, err = ReadSystemFromFile(stale); !errors.As(err, &staleErr) {
+		t.Fatalf("expected the keys of another gnark version to be rejected, got %v", err)
+	}
+
+	reheadered, err := ReheaderKeysFromFile(ctx, stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(t.TempDir(), "reheadered.ps")
+	if _, err = reheadered.WriteToFile(ctx, output, false); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadSystemFromFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.Fingerprint() != ps.Fingerprint() {
+		t.Fatal("expected the re-headered keys to have the fingerprint of the prover")
+	}
+	var original, rewritten bytes.Buffer
+	ps.VerifyingKey.WriteTo(&original)
+	read.VerifyingKey.WriteTo(&rewritten)
+	if !bytes.Equal(original.Bytes(), rewritten.Bytes()) {
+		t.Fatal("expected re-headering to keep the keys")
+	}
+
+	// Keys of other constraints cannot be re-headered.
+	stale = writeStaleKeys(t, ps, func(header *keysFileHeader) { header.CircuitVersion = CircuitVersion + 1 })
+	if _, err = ReheaderKeysFromFile(ctx, stale); !errors.As(err, &staleErr) {
+		t.Fatalf("expected the keys of another circuit version to be rejected, got %v", err)
+	}
+}
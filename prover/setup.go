@@ -0,0 +1,206 @@
+package prover
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"time"
+	"worldcoin/gnark-mbu/logging"
+
+	"github.com/consensys/gnark/backend/groth16"
+)
+
+// DevTreeDepth and DevBatchSize are the shape of the circuit of SetupDev.
+const (
+	DevTreeDepth = 4
+	DevBatchSize = 2
+)
+
+// SetupDev compiles and sets up a tiny circuit in-process, so that the server
+// and integration tests can run without downloading production keys. The keys come from a setup no one else can reproduce, so
+// its proofs only verify against the returned proving system.
+func SetupDev(ctx context.Context, opts ...CircuitOption) (*ProvingSystem, error) {
+	return Setup(ctx, DevTreeDepth, DevBatchSize, opts...)
+}
+
+// setupProgressInterval is the number of bytes written between progress
+// messages of SetupToFile.
+const setupProgressInterval = 1 << 30
+
+// SetupToFile sets up the circuit and writes the proving system to path,
+// holding at most the constraint system and the keys in memory at once
+// instead of also buffering their encodings. The constraint system is spilled
+// to a scratch file next to path before the keys are generated and released
+// before they are written. The file is the same as WriteTo, or WriteRawTo
+// if raw is set, writes, and only appears at path once it is complete. ctx
+// is checked between the stages and while the file is written.
+func SetupToFile(ctx context.Context, path string, raw bool, treeDepth uint32, batchSize uint32, opts ...CircuitOption) (written int64, err error) {
+	log := logging.Logger().With().Uint32("treeDepth", treeDepth).Uint32("batchSize", batchSize).Logger()
+	header := setupHeader(treeDepth, batchSize, newCircuitOptions(opts))
+	if header.LookupKeccak {
+		return 0, errLookupKeccakKeys
+	}
+
+	start := time.Now()
+	log.Info().Msg("compiling the circuit")
+	ccs, err := BuildR1CS(ctx, treeDepth, batchSize, opts...)
+	if err != nil {
+		return 0, err
+	}
+	log.Info().Int("constraints", ccs.GetNbConstraints()).Dur("took", time.Since(start)).Msg("circuit compiled")
+
+	scratch, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".cs-*")
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		scratch.Close()
+		os.Remove(scratch.Name())
+	}()
+	start = time.Now()
+	csSize, err := writeBuffered(scratch, ccs.WriteTo)
+	if err != nil {
+		return 0, err
+	}
+	log.Info().Int64("bytes", csSize).Dur("took", time.Since(start)).Msg("constraint system spilled to disk")
+	if err = ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	start = time.Now()
+	log.Info().Msg("generating the keys")
+	pk, vk, err := groth16.Setup(ccs)
+	if err != nil {
+		return 0, err
+	}
+	log.Info().Dur("took", time.Since(start)).Msg("keys generated")
+	// The constraint system is not used anymore, return its memory before
+	// the keys are serialized.
+	debug.FreeOSMemory()
+	if err = ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	if _, err = scratch.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return writeKeysFile(ctx, path, raw, &header, pk, vk, scratch, csSize)
+}
+
+// setupHeader returns the header of the key file of a circuit.
+func setupHeader(treeDepth uint32, batchSize uint32, options circuitOptions) keysFileHeader {
+	return (&ProvingSystem{
+		Curve:          options.curve,
+		TreeDepth:      treeDepth,
+		BatchSize:      batchSize,
+		PublicPostRoot: options.publicPostRoot,
+		EmptyLeaf:      options.emptyLeaf,
+		Commitment:     options.commitment,
+		Indexed:        options.indexed,
+		NonZeroIdComms: options.nonZeroIdComms,
+		TreeHash:       options.treeHash,
+		LookupKeccak:   options.lookupKeccak,
+	}).keysFileHeader()
+}
+
+// writeKeysFile writes a key file with the keys and the csSize bytes of the
+// encoded constraint system read from cs, through path.partial so that it
+// only appears at path once it is complete. Writing stops once ctx is done.
+func writeKeysFile(ctx context.Context, path string, raw bool, header *keysFileHeader, pk groth16.ProvingKey, vk groth16.VerifyingKey, cs io.Reader, csSize int64) (written int64, err error) {
+	log := logging.Logger().With().Uint32("treeDepth", header.TreeDepth).Uint32("batchSize", header.BatchSize).Logger()
+	partial := path + ".partial"
+	file, err := os.Create(partial)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if closeErr := file.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(partial)
+		}
+	}()
+	buffered := bufio.NewWriterSize(file, 1<<20)
+	progress := &progressWriter{ctx: ctx, w: buffered, next: setupProgressInterval, log: func(written int64) {
+		log.Info().Int64("bytesWritten", written).Msg("writing keys")
+	}}
+	kw := newKeysFileWriter(progress, raw)
+	start := time.Now()
+	if err = kw.header(header); err != nil {
+		return kw.written, err
+	}
+	if err = kw.key(pk); err != nil {
+		return kw.written, err
+	}
+	if err = kw.key(vk); err != nil {
+		return kw.written, err
+	}
+	if err = kw.write(func(w io.Writer) (int64, error) { return copyFramed(w, cs, csSize) }); err != nil {
+		return kw.written, err
+	}
+	if err = kw.finish(); err != nil {
+		return kw.written, err
+	}
+	if err = buffered.Flush(); err != nil {
+		return kw.written, err
+	}
+	if err = file.Sync(); err != nil {
+		return kw.written, err
+	}
+	if err = os.Rename(partial, path); err != nil {
+		return kw.written, err
+	}
+	log.Info().Int64("bytesWritten", kw.written).Dur("took", time.Since(start)).Msg("keys written")
+	return kw.written, nil
+}
+
+// writeBuffered writes an object to file through a buffer.
+func writeBuffered(file *os.File, writeTo func(io.Writer) (int64, error)) (int64, error) {
+	buffered := bufio.NewWriterSize(file, 1<<20)
+	written, err := writeTo(buffered)
+	if err != nil {
+		return written, err
+	}
+	return written, buffered.Flush()
+}
+
+// copyFramed writes size bytes of r prefixed with their length, as
+// writeFramed does for objects held in memory.
+func copyFramed(w io.Writer, r io.Reader, size int64) (int64, error) {
+	var lengthBuf [8]byte
+	binary.BigEndian.PutUint64(lengthBuf[:], uint64(size))
+	written, err := w.Write(lengthBuf[:])
+	if err != nil {
+		return int64(written), err
+	}
+	copied, err := io.CopyN(w, r, size)
+	return int64(written) + copied, err
+}
+
+// progressWriter calls log every time another interval of bytes, starting
+// with next, has been written, and fails writes once ctx is done.
+type progressWriter struct {
+	ctx     context.Context
+	w       io.Writer
+	written int64
+	next    int64
+	log     func(written int64)
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	if err := p.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := p.w.Write(b)
+	p.written += int64(n)
+	for p.written >= p.next {
+		p.log(p.written)
+		p.next += setupProgressInterval
+	}
+	return n, err
+}
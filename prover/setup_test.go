@@ -0,0 +1,68 @@
+package prover
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSetupToFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	written, err := SetupToFile(context.Background(), path, true, 2, 1, WithCommitment(CommitmentPoseidon))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected only the keys file to be left, got %d files", len(entries))
+	}
+	stat, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Size() != written {
+		t.Fatalf("expected %d bytes, the file has %d", written, stat.Size())
+	}
+	ps, err := ReadSystemFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ps.TreeDepth != 2 || ps.BatchSize != 1 || ps.Commitment != CommitmentPoseidon {
+		t.Fatalf("unexpected proving system %d/%d/%s", ps.TreeDepth, ps.BatchSize, ps.Commitment)
+	}
+	if ps.ConstraintSystem.GetNbConstraints() == 0 {
+		t.Fatal("expected the constraint system to be read")
+	}
+	if ps.ProofMemory() <= msmScratch {
+		t.Fatal("expected the memory of a proof to grow with the circuit")
+	}
+
+	circuit, err := ReadSystemHeaderFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if circuit.Fingerprint() != ps.Fingerprint() || circuit.ProvingKey != nil {
+		t.Fatal("expected the header alone to be read")
+	}
+	other := filepath.Join(t.TempDir(), "other")
+	if err = os.WriteFile(other, []byte("not a key file"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ReadSystemHeaderFromFile(other); err == nil {
+		t.Fatal("expected a file without a header to be rejected")
+	}
+}
+
+func TestSetupDev(t *testing.T) {
+	ps, err := SetupDev(context.Background(), WithCommitment(CommitmentPoseidon))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ps.TreeDepth != DevTreeDepth || ps.BatchSize != DevBatchSize || ps.ProvingKey == nil {
+		t.Fatalf("unexpected proving system %d/%d", ps.TreeDepth, ps.BatchSize)
+	}
+}
@@ -0,0 +1,204 @@
+package sha2
+
+import (
+	"github.com/consensys/gnark/frontend"
+)
+
+// Implementation of SHA-256 in gnark following FIPS 180-4
+// https://nvlpubs.nist.gov/nistpubs/FIPS/NIST.FIPS.180-4.pdf
+//
+// Data is written and the digest returned as a sequence of bytes, each byte
+// given as 8 bits from the least significant one, like the Keccak gadget.
+
+const wordSize = 32
+
+type word [wordSize]frontend.Variable
+
+var initialHash = [8]uint32{
+	0x6a09e667, 0xbb67ae85, 0x3c6ef372, 0xa54ff53a, 0x510e527f, 0x9b05688c, 0x1f83d9ab, 0x5be0cd19,
+}
+
+var roundConstants = [64]uint32{
+	0x428a2f98, 0x71374491, 0xb5c0fbcf, 0xe9b5dba5, 0x3956c25b, 0x59f111f1, 0x923f82a4, 0xab1c5ed5,
+	0xd807aa98, 0x12835b01, 0x243185be, 0x550c7dc3, 0x72be5d74, 0x80deb1fe, 0x9bdc06a7, 0xc19bf174,
+	0xe49b69c1, 0xefbe4786, 0x0fc19dc6, 0x240ca1cc, 0x2de92c6f, 0x4a7484aa, 0x5cb0a9dc, 0x76f988da,
+	0x983e5152, 0xa831c66d, 0xb00327c8, 0xbf597fc7, 0xc6e00bf3, 0xd5a79147, 0x06ca6351, 0x14292967,
+	0x27b70a85, 0x2e1b2138, 0x4d2c6dfc, 0x53380d13, 0x650a7354, 0x766a0abb, 0x81c2c92e, 0x92722c85,
+	0xa2bfe8a1, 0xa81a664b, 0xc24b8b70, 0xc76c51a3, 0xd192e819, 0xd6990624, 0xf40e3585, 0x106aa070,
+	0x19a4c116, 0x1e376c08, 0x2748774c, 0x34b0bcb5, 0x391c0cb3, 0x4ed8aa4a, 0x5b9cca4f, 0x682e6ff3,
+	0x748f82ee, 0x78a5636f, 0x84c87814, 0x8cc70208, 0x90befffa, 0xa4506ceb, 0xbef9a3f7, 0xc67178f2,
+}
+
+type Sha256 struct {
+	inputData []frontend.Variable
+	api       frontend.API
+}
+
+func NewSha256(api frontend.API) Sha256 {
+	return Sha256{
+		inputData: []frontend.Variable{},
+		api:       api,
+	}
+}
+
+func (h *Sha256) Write(data ...frontend.Variable) {
+	h.inputData = append(h.inputData, data...)
+}
+
+func (h *Sha256) Reset() {
+	h.inputData = []frontend.Variable{}
+}
+
+func (h *Sha256) Sum() []frontend.Variable {
+	// Padding: a one bit, zeros up to 448 bits modulo 512 and the message
+	// length in bits as a big-endian 64-bit integer.
+	length := len(h.inputData)
+	P := append([]frontend.Variable{}, h.inputData...)
+	P = append(P, byteBits(0x80)...)
+	for len(P)%512 != 448 {
+		P = append(P, byteBits(0)...)
+	}
+	for i := 7; i >= 0; i -= 1 {
+		P = append(P, byteBits(byte(uint64(length)>>(8*i)))...)
+	}
+
+	var H [8]word
+	for i := range H {
+		H[i] = constant(initialHash[i])
+	}
+
+	for block := 0; block < len(P); block += 512 {
+		// Message schedule
+		var W [64]word
+		for t := 0; t < 16; t += 1 {
+			W[t] = fromBytes(P[block+t*wordSize : block+(t+1)*wordSize])
+		}
+		for t := 16; t < 64; t += 1 {
+			s0 := xor3(h.api, rotr(W[t-15], 7), rotr(W[t-15], 18), shr(W[t-15], 3))
+			s1 := xor3(h.api, rotr(W[t-2], 17), rotr(W[t-2], 19), shr(W[t-2], 10))
+			W[t] = add(h.api, W[t-16], s0, W[t-7], s1)
+		}
+
+		// Compression
+		a, b, c, d, e, f, g, hh := H[0], H[1], H[2], H[3], H[4], H[5], H[6], H[7]
+		for t := 0; t < 64; t += 1 {
+			S1 := xor3(h.api, rotr(e, 6), rotr(e, 11), rotr(e, 25))
+			T1 := add(h.api, hh, S1, ch(h.api, e, f, g), constant(roundConstants[t]), W[t])
+			S0 := xor3(h.api, rotr(a, 2), rotr(a, 13), rotr(a, 22))
+			T2 := add(h.api, S0, maj(h.api, a, b, c))
+			hh, g, f = g, f, e
+			e = add(h.api, d, T1)
+			d, c, b = c, b, a
+			a = add(h.api, T1, T2)
+		}
+		for i, v := range [8]word{a, b, c, d, e, f, g, hh} {
+			H[i] = add(h.api, H[i], v)
+		}
+	}
+
+	var digest []frontend.Variable
+	for _, w := range H {
+		digest = append(digest, toBytes(w)...)
+	}
+	return digest
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////
+/// Helpers for various binary operations
+///////////////////////////////////////////////////////////////////////////////////////////
+
+func byteBits(b byte) []frontend.Variable {
+	bits := make([]frontend.Variable, 8)
+	for i := range bits {
+		bits[i] = int(b>>i) & 1
+	}
+	return bits
+}
+
+func constant(v uint32) word {
+	var w word
+	for i := range w {
+		w[i] = int(v>>i) & 1
+	}
+	return w
+}
+
+// fromBytes converts 4 bytes to a big-endian word, with bits indexed from
+// the least significant one.
+func fromBytes(bytes []frontend.Variable) word {
+	var w word
+	for i := 0; i < 4; i += 1 {
+		copy(w[(3-i)*8:(4-i)*8], bytes[i*8:(i+1)*8])
+	}
+	return w
+}
+
+func toBytes(w word) []frontend.Variable {
+	bytes := make([]frontend.Variable, 0, wordSize)
+	for i := 3; i >= 0; i -= 1 {
+		bytes = append(bytes, w[i*8:(i+1)*8]...)
+	}
+	return bytes
+}
+
+func rotr(a word, r int) word {
+	var c word
+	for i := 0; i < wordSize; i += 1 {
+		c[i] = a[(i+r)%wordSize]
+	}
+	return c
+}
+
+func shr(a word, r int) word {
+	var c word
+	for i := 0; i < wordSize; i += 1 {
+		if i+r < wordSize {
+			c[i] = a[i+r]
+		} else {
+			c[i] = 0
+		}
+	}
+	return c
+}
+
+func xor3(api frontend.API, a, b, c word) word {
+	var d word
+	for i := 0; i < wordSize; i += 1 {
+		d[i] = api.Xor(api.Xor(a[i], b[i]), c[i])
+	}
+	return d
+}
+
+// ch selects f where e is set and g elsewhere, as g + e*(f-g).
+func ch(api frontend.API, e, f, g word) word {
+	var d word
+	for i := 0; i < wordSize; i += 1 {
+		d[i] = api.Add(g[i], api.Mul(e[i], api.Sub(f[i], g[i])))
+	}
+	return d
+}
+
+// maj is the majority of a, b and c, as a*b + c*(a xor b).
+func maj(api frontend.API, a, b, c word) word {
+	var d word
+	for i := 0; i < wordSize; i += 1 {
+		d[i] = api.Add(api.Mul(a[i], b[i]), api.Mul(c[i], api.Xor(a[i], b[i])))
+	}
+	return d
+}
+
+// add sums words modulo 2^32 by decomposing their sum, carries included.
+func add(api frontend.API, words ...word) word {
+	var sum frontend.Variable = 0
+	for _, w := range words {
+		sum = api.Add(sum, api.FromBinary(w[:]...))
+	}
+	carryBits := 0
+	for 1<<carryBits < len(words) {
+		carryBits += 1
+	}
+	bits := api.ToBinary(sum, wordSize+carryBits)
+	var c word
+	copy(c[:], bits[:wordSize])
+	return c
+}
@@ -0,0 +1,64 @@
+package sha2
+
+import (
+	"crypto/sha256"
+	"math/big"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/test"
+)
+
+type TestSha256Circuit struct {
+	Input []frontend.Variable `gnark:"input"`
+	Hash  frontend.Variable   `gnark:",public"`
+}
+
+func (circuit *TestSha256Circuit) Define(api frontend.API) error {
+	h := NewSha256(api)
+	h.Write(circuit.Input...)
+	// The top bits of the digest are dropped to fit the field.
+	sum := api.FromBinary(h.Sum()[:248]...)
+	api.AssertIsEqual(circuit.Hash, sum)
+	return nil
+}
+
+func testSha256(t *testing.T, message []byte) {
+	digest := sha256.Sum256(message)
+	// FromBinary reads the bits of the first 31 bytes as a little-endian
+	// integer.
+	var reversed [31]byte
+	for i := range reversed {
+		reversed[i] = digest[30-i]
+	}
+	var hash big.Int
+	hash.SetBytes(reversed[:])
+
+	input := make([]frontend.Variable, len(message)*8)
+	for i, b := range message {
+		for j := 0; j < 8; j++ {
+			input[i*8+j] = int(b>>j) & 1
+		}
+	}
+	circuit := &TestSha256Circuit{Input: make([]frontend.Variable, len(input))}
+	if err := test.IsSolved(circuit, &TestSha256Circuit{Input: input, Hash: hash}, ecc.BN254.ScalarField()); err != nil {
+		t.Fatal(err)
+	}
+	hash.Add(&hash, big.NewInt(1))
+	if err := test.IsSolved(circuit, &TestSha256Circuit{Input: input, Hash: hash}, ecc.BN254.ScalarField()); err == nil {
+		t.Fatal("expected a wrong digest to be rejected")
+	}
+}
+
+func TestSha256Empty(t *testing.T) {
+	testSha256(t, nil)
+}
+
+func TestSha256TwoBlocks(t *testing.T) {
+	message := make([]byte, 100)
+	for i := range message {
+		message[i] = byte(i * 7)
+	}
+	testSha256(t, message)
+}
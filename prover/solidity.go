@@ -0,0 +1,135 @@
+package prover
+
+import (
+	"fmt"
+	"io"
+	"text/template"
+)
+
+// batchVerifierTemplate is a contract verifying batches by their inputs
+// rather than their input hash. It extends the Verifier contract of gnark,
+// which must precede it in the same file.
+var batchVerifierTemplate = template.Must(template.New("BatchVerifier").Parse(`
+/// @title Verifies batch insertions into a tree of depth {{.TreeDepth}}, {{.BatchSize}} identity commitments at a time
+/// @notice Circuit {{.Fingerprint}}. The input hash is recomputed from the batch exactly like
+///         Parameters.ComputeInputHashWith({{.Commitment}}) in the prover, so that callers only pass the batch.
+contract BatchVerifier is Verifier {
+    uint256 public constant TREE_DEPTH = {{.TreeDepth}};
+    uint256 public constant BATCH_SIZE = {{.BatchSize}};
+{{- if .EmptyLeaf}}
+    uint256 public constant EMPTY_LEAF = {{.EmptyLeaf}};
+{{- end}}
+{{- if .Chunk}}
+    uint256 public constant CHUNK_SIZE = {{.Chunk}};
+{{- end}}
+
+    /// @notice Computes the input hash of a batch, reduced modulo the scalar field.
+    function inputHash(
+        uint32 startIndex,
+        uint256 preRoot,
+        uint256 postRoot,
+        uint256[] calldata identityCommitments{{if .Indexed}},
+        uint32[] calldata indices{{end}}
+    ) public pure returns (uint256) {
+        require(identityCommitments.length == BATCH_SIZE, "batch-verifier-wrong-batch-size");
+{{- if .Chunk}}
+        bytes32 hash = keccak256(abi.encodePacked(startIndex, preRoot, postRoot));
+        // The chunks are hashed in the same buffer, after the hash of the
+        // previous ones, so that memory does not grow with the batch.
+        uint256[] memory buffer = new uint256[](CHUNK_SIZE + 1);
+        for (uint256 start = 0; start < BATCH_SIZE; start += CHUNK_SIZE) {
+            uint256 end = start + CHUNK_SIZE < BATCH_SIZE ? start + CHUNK_SIZE : BATCH_SIZE;
+            buffer[0] = uint256(hash);
+            for (uint256 i = start; i < end; i++) {
+                buffer[i - start + 1] = identityCommitments[i];
+            }
+            uint256 length = (end - start + 1) * 32;
+            assembly {
+                hash := keccak256(add(buffer, 32), length)
+            }
+        }
+{{- if or .EmptyLeaf .Indexed}}
+        bytes memory data = abi.encodePacked(hash{{if .EmptyLeaf}}, EMPTY_LEAF{{end}});
+{{- if .Indexed}}
+        require(indices.length == BATCH_SIZE, "batch-verifier-wrong-indices");
+        for (uint256 i = 0; i < indices.length; i++) {
+            data = bytes.concat(data, bytes4(indices[i]));
+        }
+{{- end}}
+        hash = keccak256(data);
+{{- end}}
+        return uint256(hash) % SNARK_SCALAR_FIELD;
+{{- else}}
+        bytes memory data = abi.encodePacked(startIndex, preRoot, postRoot, identityCommitments{{if .EmptyLeaf}}, EMPTY_LEAF{{end}});
+{{- if .Indexed}}
+        require(indices.length == BATCH_SIZE, "batch-verifier-wrong-indices");
+        for (uint256 i = 0; i < indices.length; i++) {
+            data = bytes.concat(data, bytes4(indices[i]));
+        }
+{{- end}}
+        return uint256({{.Hash}}(data)) % SNARK_SCALAR_FIELD;
+{{- end}}
+    }
+
+    /// @notice Verifies the proof of a batch, given as the numbers of its ar, bs and krs in order.
+    function verifyBatch(
+        uint256[8] calldata proof,
+        uint32 startIndex,
+        uint256 preRoot,
+        uint256 postRoot,
+        uint256[] calldata identityCommitments{{if .Indexed}},
+        uint32[] calldata indices{{end}}
+    ) external view returns (bool) {
+        uint256[{{.PublicInputs}}] memory input;
+        input[0] = inputHash(startIndex, preRoot, postRoot, identityCommitments{{if .Indexed}}, indices{{end}});
+{{- if .PublicPostRoot}}
+        input[1] = postRoot;
+{{- end}}
+        return this.verifyProof([proof[0], proof[1]], [[proof[2], proof[3]], [proof[4], proof[5]]], [proof[6], proof[7]], input);
+    }
+}
+`))
+
+// ExportSolidity writes the Verifier contract of gnark for the verifying
+// key, followed by a BatchVerifier contract taking the inputs of a batch
+// instead of its input hash. The BatchVerifier is left out for the Poseidon
+// commitment, which is not computed on-chain.
+func (vs *VerifyingSystem) ExportSolidity(writer io.Writer) error {
+	if err := vs.VerifyingKey.ExportSolidity(writer); err != nil {
+		return err
+	}
+	var hash string
+	var chunk int
+	switch vs.Commitment {
+	case CommitmentKeccak, "":
+		hash = "keccak256"
+	case CommitmentSHA256:
+		hash = "sha256"
+	case CommitmentKeccakChained:
+		hash, chunk = "keccak256", KeccakChainChunk
+	default:
+		_, err := fmt.Fprintf(writer, "\n// No BatchVerifier: the %s commitment is not computed on-chain.\n", vs.Commitment)
+		return err
+	}
+	commitment, _ := ParseCommitment(string(vs.Commitment))
+	publicInputs := 1
+	if vs.PublicPostRoot {
+		publicInputs = 2
+	}
+	var emptyLeaf string
+	if vs.EmptyLeaf.Sign() != 0 {
+		emptyLeaf = vs.EmptyLeaf.String()
+	}
+	return batchVerifierTemplate.Execute(writer, map[string]interface{}{
+		"TreeDepth":      vs.TreeDepth,
+		"BatchSize":      vs.BatchSize,
+		"Fingerprint":    vs.Fingerprint(),
+		"Commitment":     commitment,
+		"EmptyLeaf":      emptyLeaf,
+		"Indexed":        vs.Indexed,
+		"PublicPostRoot": vs.PublicPostRoot,
+		"PublicInputs":   publicInputs,
+		"Hash":           hash,
+		"Chunk":          chunk,
+	})
+}
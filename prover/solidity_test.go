@@ -0,0 +1,177 @@
+package prover
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+	"worldcoin/gnark-mbu/prover/evmtest"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestExportSolidity(t *testing.T) {
+	ps := smallProvingSystem(t)
+	var source bytes.Buffer
+	if err := ps.ExportSolidity(&source); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"contract Verifier {", "contract BatchVerifier is Verifier {", "uint256 public constant BATCH_SIZE = 100;", "keccak256(data)"} {
+		if !strings.Contains(source.String(), expected) {
+			t.Errorf("expected the contracts to contain %q", expected)
+		}
+	}
+
+	ps.Commitment, ps.Indexed = CommitmentSHA256, true
+	source.Reset()
+	if err := ps.ExportSolidity(&source); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(source.String(), "sha256(data)") || !strings.Contains(source.String(), "uint32[] calldata indices") {
+		t.Fatal("expected the batch verifier to hash with SHA-256 and take indices")
+	}
+
+	ps.Commitment, ps.Indexed = CommitmentKeccakChained, false
+	source.Reset()
+	if err := ps.ExportSolidity(&source); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(source.String(), "uint256 public constant CHUNK_SIZE = 64;") || strings.Contains(source.String(), "keccak256(data)") {
+		t.Fatal("expected the batch verifier to hash the identity commitments a chunk at a time")
+	}
+
+	ps.Commitment = CommitmentPoseidon
+	source.Reset()
+	if err := ps.ExportSolidity(&source); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(source.String(), "BatchVerifier is") {
+		t.Fatal("expected no batch verifier for the poseidon commitment")
+	}
+}
+
+// batchRegistry is a test contract keeping the root of a tree, which it
+// only updates with batches proven to the BatchVerifier.
+const batchRegistry = `
+contract BatchRegistry {
+    BatchVerifier public immutable verifier;
+    uint256 public root;
+
+    constructor(BatchVerifier _verifier, uint256 _root) {
+        verifier = _verifier;
+        root = _root;
+    }
+
+    function insert(
+        uint256[8] calldata proof,
+        uint32 startIndex,
+        uint256 postRoot,
+        uint256[] calldata identityCommitments%s
+    ) external {
+        require(verifier.verifyBatch(proof, startIndex, root, postRoot, identityCommitments%s), "invalid-proof");
+        root = postRoot;
+    }
+}
+`
+
+// deployBatchRegistry compiles the contracts of ps followed by a
+// BatchRegistry, and deploys both on a simulated chain with the registry
+// at preRoot.
+func deployBatchRegistry(t *testing.T, ps *ProvingSystem, preRoot *big.Int) (verifier, registry *evmtest.Deployed) {
+	var source bytes.Buffer
+	if err := ps.ExportSolidity(&source); err != nil {
+		t.Fatal(err)
+	}
+	if ps.Indexed {
+		fmt.Fprintf(&source, batchRegistry, ",\n        uint32[] calldata indices", ", indices")
+	} else {
+		fmt.Fprintf(&source, batchRegistry, "", "")
+	}
+	contracts := evmtest.Compile(t, source.String())
+	chain := evmtest.NewChain(t)
+	verifier = chain.Deploy(t, contracts["BatchVerifier"])
+	registry = chain.Deploy(t, contracts["BatchRegistry"], verifier.Address, preRoot)
+	return verifier, registry
+}
+
+func batchArgs(params *Parameters) []interface{} {
+	idComms := make([]*big.Int, len(params.IdComms))
+	for i := range params.IdComms {
+		idComms[i] = &params.IdComms[i]
+	}
+	args := []interface{}{params.StartIndex, &params.PreRoot, &params.PostRoot, idComms}
+	if params.Indices != nil {
+		args = append(args, params.Indices)
+	}
+	return args
+}
+
+// insertArgs are the arguments of BatchRegistry.insert, which takes the pre
+// root from its state.
+func insertArgs(proof [8]*big.Int, params *Parameters) []interface{} {
+	args := batchArgs(params)
+	return append([]interface{}{proof, args[0]}, args[2:]...)
+}
+
+// TestBatchVerifierEVM cross-checks the BatchVerifier against the prover on
+// a simulated go-ethereum chain, catching encoding differences between
+// ComputeInputHash and the contract. It needs solc 0.8.
+func TestBatchVerifierEVM(t *testing.T) {
+	evmtest.SkipWithoutSolc(t)
+	field := ecc.BN254.ScalarField()
+	for name, opts := range map[string][]CircuitOption{
+		"keccak":           nil,
+		"sha256":           {WithCommitment(CommitmentSHA256)},
+		"keccak-chained":   {WithCommitment(CommitmentKeccakChained), WithEmptyLeaf(*big.NewInt(7))},
+		"public-post-root": {WithPublicPostRoot()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ps, err := Setup(context.Background(), testTreeDepth, testBatchSize, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			params := testParameters()
+			if err = params.ComputeInputHashWith(ps.Commitment); err != nil {
+				t.Fatal(err)
+			}
+			verifier, registry := deployBatchRegistry(t, ps, &params.PreRoot)
+
+			inputHash := verifier.Call(t, "inputHash", batchArgs(params)...)[0].(*big.Int)
+			if inputHash.Cmp(new(big.Int).Mod(&params.InputHash, field)) != 0 {
+				t.Fatalf("the contract computed input hash %s, the prover %s", inputHash.Text(16), params.InputHash.Text(16))
+			}
+
+			proof, err := ps.Prove(context.Background(), params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var raw bytes.Buffer
+			if _, err = proof.Proof.WriteRawTo(&raw); err != nil {
+				t.Fatal(err)
+			}
+			var numbers [8]*big.Int
+			for i := range numbers {
+				numbers[i] = new(big.Int).SetBytes(raw.Bytes()[i*32 : (i+1)*32])
+			}
+			if valid := verifier.Call(t, "verifyBatch", append([]interface{}{numbers}, batchArgs(params)...)...)[0].(bool); !valid {
+				t.Fatal("expected the proof to verify on-chain")
+			}
+
+			tampered := *params
+			tampered.IdComms = append([]big.Int(nil), params.IdComms...)
+			tampered.IdComms[0].SetInt64(7)
+			if receipt := registry.Transact(t, "insert", insertArgs(numbers, &tampered)...); receipt.Status != types.ReceiptStatusFailed {
+				t.Fatal("expected the insertion of another batch to revert")
+			}
+			if receipt := registry.Transact(t, "insert", insertArgs(numbers, params)...); receipt.Status != types.ReceiptStatusSuccessful {
+				t.Fatal("expected the insertion to be accepted")
+			}
+			if root := registry.Call(t, "root")[0].(*big.Int); root.Cmp(&params.PostRoot) != 0 {
+				t.Fatalf("expected the registry root to be %s, got %s", params.PostRoot.String(), root.String())
+			}
+		})
+	}
+}
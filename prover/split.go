@@ -0,0 +1,150 @@
+package prover
+
+import (
+	"context"
+	"math/big"
+)
+
+// SubBatch is one of the consecutive batches a batch is split into by
+// SplitBatch, with its proof once proven.
+type SubBatch struct {
+	Parameters *Parameters
+	Proof      *Proof
+	// Padding is the number of empty leaves appended to fill the last
+	// sub-batch, which leave its post root unchanged.
+	Padding int
+}
+
+// SplitBatch splits params, a batch of any size, into consecutive batches of
+// the batch size of the circuit, whose roots chain from the pre root of
+// params to its post root. The intermediate roots and input hashes are
+// computed natively, after checking that the whole batch chains. Without
+// indices, a last sub-batch that is not full is padded with empty leaves at
+// the following indices, whose Merkle proofs are derived from the last
+// insertion assuming that the rest of the tree is empty, as it is for trees
+// filled in order. Batches with indices must be a multiple of the batch size.
+func (ps *ProvingSystem) SplitBatch(params *Parameters) ([]*SubBatch, error) {
+	if err := ps.ExpandFrontier(params); err != nil {
+		return nil, err
+	}
+	size := len(params.IdComms)
+	if size == 0 {
+		return nil, &BatchSizeError{Field: "identity commitments", Expected: int(ps.BatchSize), Actual: 0}
+	}
+	if err := params.ValidateShape(ps.TreeDepth, uint32(size)); err != nil {
+		return nil, err
+	}
+	if (len(params.Indices) != 0) != ps.Indexed {
+		return nil, &IndicesError{Indexed: ps.Indexed}
+	}
+	workers := ps.witnessWorkers()
+	if err := params.validateFieldElements(ps.Curve.ScalarField(), workers); err != nil {
+		return nil, err
+	}
+	if params.EmptyLeaf.Cmp(&ps.EmptyLeaf) != 0 {
+		return nil, &EmptyLeafError{Expected: ps.EmptyLeaf, Actual: params.EmptyLeaf}
+	}
+	hash, err := NativeTreeHash(ps.TreeHash, ps.Curve)
+	if err != nil {
+		return nil, err
+	}
+	if err = params.verifyRoots(hash, ps.TreeDepth); err != nil {
+		return nil, err
+	}
+
+	batchSize := int(ps.BatchSize)
+	count := (size + batchSize - 1) / batchSize
+	padding := count*batchSize - size
+	if padding != 0 && ps.Indexed {
+		return nil, &BatchSizeError{Field: "indices", Expected: count * batchSize, Actual: size}
+	}
+	if padding != 0 && ps.NonZeroIdComms && params.EmptyLeaf.Sign() == 0 {
+		return nil, &BatchSizeError{Field: "identity commitments", Expected: count * batchSize, Actual: size}
+	}
+	if padding != 0 && uint64(params.StartIndex)+uint64(count*batchSize) > treeCapacity(ps.TreeDepth) {
+		start := params.StartIndex + uint32((count-1)*batchSize)
+		return nil, &StartIndexError{StartIndex: start, BatchSize: batchSize, TreeDepth: ps.TreeDepth}
+	}
+	idComms, merkleProofs := params.IdComms, params.MerkleProofs
+	if padding != 0 {
+		idComms = append(idComms[:size:size], make([]big.Int, padding)...)
+		for i := size; i < len(idComms); i++ {
+			idComms[i].Set(&params.EmptyLeaf)
+		}
+		padded, err := paddingProofs(hash, params, padding)
+		if err != nil {
+			return nil, err
+		}
+		merkleProofs = append(merkleProofs[:size:size], padded...)
+	}
+
+	batches := make([]*SubBatch, count)
+	preRoot := &params.PreRoot
+	for i := range batches {
+		from, to := i*batchSize, (i+1)*batchSize
+		sub := &Parameters{
+			StartIndex:   params.StartIndex + uint32(from),
+			IdComms:      idComms[from:to],
+			MerkleProofs: merkleProofs[from:to],
+			TreeDepth:    params.TreeDepth,
+		}
+		if ps.Indexed {
+			sub.Indices = params.Indices[from:to]
+		}
+		sub.PreRoot.Set(preRoot)
+		sub.EmptyLeaf.Set(&params.EmptyLeaf)
+		if to >= size {
+			sub.PostRoot.Set(&params.PostRoot)
+		} else {
+			root, err := nativeRoot(hash, &idComms[to-1], params.index(to-1), merkleProofs[to-1])
+			if err != nil {
+				return nil, err
+			}
+			sub.PostRoot.Set(root)
+		}
+		if err = sub.ComputeInputHashWith(ps.Commitment); err != nil {
+			return nil, err
+		}
+		preRoot = &sub.PostRoot
+		batches[i] = &SubBatch{Parameters: sub}
+	}
+	batches[count-1].Padding = padding
+	batches[count-1].Parameters.padding = padding
+	return batches, nil
+}
+
+// paddingProofs derives the Merkle proofs of the empty slots following the
+// last insertion of params, once it is inserted.
+func paddingProofs(hash func(left, right *big.Int) (*big.Int, error), params *Parameters, padding int) ([][]big.Int, error) {
+	empty, err := emptyNodes(hash, &params.EmptyLeaf, len(params.MerkleProofs[0]))
+	if err != nil {
+		return nil, err
+	}
+	last := len(params.IdComms) - 1
+	leaf, index, siblings := &params.IdComms[last], params.index(last), params.MerkleProofs[last]
+	proofs := make([][]big.Int, padding)
+	for i := range proofs {
+		if proofs[i], err = nextProof(hash, empty, leaf, index, siblings); err != nil {
+			return nil, err
+		}
+		// The padding leaves the tree unchanged, so the next empty slot is
+		// derived from this one.
+		leaf, index, siblings = &params.EmptyLeaf, index+1, proofs[i]
+	}
+	return proofs, nil
+}
+
+// ProveSplit splits params with SplitBatch and proves the sub-batches in
+// order, stopping at the first that fails or once ctx is done.
+func (ps *ProvingSystem) ProveSplit(ctx context.Context, params *Parameters) ([]*SubBatch, error) {
+	batches, err := ps.SplitBatch(params)
+	if err != nil {
+		return nil, err
+	}
+	for _, batch := range batches {
+		if batch.Proof, err = ps.Prove(ctx, batch.Parameters); err != nil {
+			return nil, err
+		}
+	}
+	return batches, nil
+}
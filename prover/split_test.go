@@ -0,0 +1,97 @@
+package prover
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+)
+
+// sequentialParameters returns the insertion of identity commitments 1 to n
+// at the start of an empty tree of depth testTreeDepth.
+func sequentialParameters(n int) *Parameters {
+	leaves := make([]big.Int, 1<<testTreeDepth)
+	// proof returns the Merkle proof of index and the root of the tree.
+	proof := func(index int) ([]big.Int, big.Int) {
+		level := leaves
+		siblings := make([]big.Int, testTreeDepth)
+		for depth := range siblings {
+			siblings[depth] = level[index^1]
+			next := make([]big.Int, len(level)/2)
+			for i := range next {
+				next[i] = poseidonHash(level[2*i], level[2*i+1])
+			}
+			level, index = next, index/2
+		}
+		return siblings, level[0]
+	}
+	params := &Parameters{}
+	_, params.PreRoot = proof(0)
+	for i := 0; i < n; i++ {
+		siblings, _ := proof(i)
+		params.IdComms = append(params.IdComms, *big.NewInt(int64(i + 1)))
+		params.MerkleProofs = append(params.MerkleProofs, siblings)
+		leaves[i].SetInt64(int64(i + 1))
+	}
+	_, params.PostRoot = proof(0)
+	return params
+}
+
+func TestSplitBatch(t *testing.T) {
+	const batchSize = 3
+	cs, err := BuildR1CS(context.Background(), testTreeDepth, batchSize, WithCommitment(CommitmentPoseidon))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps := &ProvingSystem{Curve: ecc.BN254, TreeDepth: testTreeDepth, BatchSize: batchSize, Commitment: CommitmentPoseidon, ConstraintSystem: cs}
+	for n := 1; n <= 1<<testTreeDepth-2; n++ {
+		params := sequentialParameters(n)
+		batches, err := ps.SplitBatch(params)
+		if err != nil {
+			t.Fatalf("%d insertions: %v", n, err)
+		}
+		if expected := (n + batchSize - 1) / batchSize; len(batches) != expected {
+			t.Fatalf("%d insertions: expected %d sub-batches, got %d", n, expected, len(batches))
+		}
+		if padding := batches[len(batches)-1].Padding; n+padding != len(batches)*batchSize {
+			t.Fatalf("%d insertions: unexpected padding %d", n, padding)
+		}
+		preRoot := params.PreRoot
+		for i, batch := range batches {
+			sub := batch.Parameters
+			if sub.PreRoot.Cmp(&preRoot) != 0 || sub.StartIndex != uint32(i*batchSize) {
+				t.Fatalf("%d insertions: sub-batch %d does not follow the previous one", n, i)
+			}
+			if err = ps.Check(sub); err != nil {
+				t.Fatalf("%d insertions: sub-batch %d: %v", n, i, err)
+			}
+			preRoot = sub.PostRoot
+		}
+		if preRoot.Cmp(&params.PostRoot) != 0 {
+			t.Fatalf("%d insertions: expected the last sub-batch to end at the post root", n)
+		}
+	}
+
+	// The padding would not fit in the tree.
+	var startIndex *StartIndexError
+	if _, err = ps.SplitBatch(sequentialParameters(1<<testTreeDepth - 1)); !errors.As(err, &startIndex) {
+		t.Fatalf("expected a start index error, got %v", err)
+	}
+
+	params := sequentialParameters(4)
+	params.MerkleProofs[2][1].SetUint64(42)
+	var mismatch *RootMismatchError
+	if _, err = ps.SplitBatch(params); !errors.As(err, &mismatch) || mismatch.Proof != 2 {
+		t.Fatalf("expected proof 2 to be reported, got %v", err)
+	}
+
+	indexed := &ProvingSystem{Curve: ecc.BN254, TreeDepth: testTreeDepth, BatchSize: batchSize, Indexed: true}
+	params = sequentialParameters(4)
+	params.Indices = []uint32{0, 1, 2, 3}
+	var batchSizeErr *BatchSizeError
+	if _, err = indexed.SplitBatch(params); !errors.As(err, &batchSizeErr) {
+		t.Fatalf("expected indexed batches to be a multiple of the batch size, got %v", err)
+	}
+}
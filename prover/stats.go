@@ -0,0 +1,68 @@
+package prover
+
+import (
+	"io"
+	"sync"
+)
+
+// Stats describes the size of a proving system, for capacity planning.
+type Stats struct {
+	Constraints int `json:"constraints"`
+	// PublicVariables and SecretVariables are the inputs of the circuit,
+	// the constant wire left out, InternalVariables the wires the solver
+	// computes from them.
+	PublicVariables   int `json:"publicVariables"`
+	SecretVariables   int `json:"secretVariables"`
+	InternalVariables int `json:"internalVariables"`
+	// ProvingKeySize and VerifyingKeySize are the sizes, in bytes, of the
+	// keys written with uncompressed points, zero for keys that are not
+	// loaded.
+	ProvingKeySize   int64 `json:"provingKeySize,omitempty"`
+	VerifyingKeySize int64 `json:"verifyingKeySize,omitempty"`
+	// WitnessSize is the size, in bytes, of the gnark binary encoding of
+	// the full witness of a proof.
+	WitnessSize int64 `json:"witnessSize"`
+	// ProofMemory is ProvingSystem.ProofMemory.
+	ProofMemory uint64 `json:"proofMemory"`
+}
+
+// keySizes caches the sizes of the keys of a proving system, which takes
+// writing them.
+type keySizes struct {
+	once         sync.Once
+	provingKey   int64
+	verifyingKey int64
+}
+
+// Stats returns the Stats of the proving system, nil if it holds no
+// constraint system. The sizes of the keys are computed on the first call.
+func (ps *ProvingSystem) Stats() *Stats {
+	cs := ps.ConstraintSystem
+	if cs == nil {
+		return nil
+	}
+	ps.keySizes.once.Do(func() {
+		if ps.ProvingKey != nil {
+			ps.keySizes.provingKey, _ = ps.ProvingKey.WriteRawTo(io.Discard)
+		}
+		if ps.VerifyingKey != nil {
+			ps.keySizes.verifyingKey, _ = ps.VerifyingKey.WriteRawTo(io.Discard)
+		}
+	})
+	internal, secret, public := cs.GetNbVariables()
+	element := int64(ps.Curve.ScalarField().BitLen()+7) / 8
+	return &Stats{
+		Constraints: cs.GetNbConstraints(),
+		// The constant wire is counted as a public variable, but is no
+		// input.
+		PublicVariables:   public - 1,
+		SecretVariables:   secret,
+		InternalVariables: internal,
+		ProvingKeySize:    ps.keySizes.provingKey,
+		VerifyingKeySize:  ps.keySizes.verifyingKey,
+		// The witness is prefixed with its number of public and secret
+		// values and the length of its vector.
+		WitnessSize: 12 + element*int64(public-1+secret),
+		ProofMemory: ps.ProofMemory(),
+	}
+}
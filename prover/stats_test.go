@@ -0,0 +1,44 @@
+package prover
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/consensys/gnark/frontend"
+)
+
+func TestStats(t *testing.T) {
+	ps := smallProvingSystem(t)
+	stats := ps.Stats()
+	if stats.Constraints != ps.ConstraintSystem.GetNbConstraints() || stats.PublicVariables != 1 || stats.SecretVariables != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	var pk, vk bytes.Buffer
+	if _, err := ps.ProvingKey.WriteRawTo(&pk); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ps.VerifyingKey.WriteRawTo(&vk); err != nil {
+		t.Fatal(err)
+	}
+	if stats.ProvingKeySize != int64(pk.Len()) || stats.VerifyingKeySize != int64(vk.Len()) {
+		t.Fatalf("expected keys of %d and %d bytes, got %d and %d", pk.Len(), vk.Len(), stats.ProvingKeySize, stats.VerifyingKeySize)
+	}
+	witness, err := frontend.NewWitness(&squareCircuit{X: 3, Y: 9}, ps.Curve.ScalarField())
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := witness.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.WitnessSize != int64(len(encoded)) {
+		t.Fatalf("expected a witness of %d bytes, got %d", len(encoded), stats.WitnessSize)
+	}
+	if stats.ProofMemory != ps.ProofMemory() {
+		t.Fatal("expected the memory of a proof")
+	}
+
+	if (&ProvingSystem{}).Stats() != nil {
+		t.Fatal("expected no stats without a constraint system")
+	}
+}
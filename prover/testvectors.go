@@ -0,0 +1,205 @@
+package prover
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+)
+
+// TestVectorsVersion is the version of the test-vector format. It changes
+// whenever a field is added, removed or changes meaning, and readers reject
+// other versions rather than misreading them.
+const TestVectorsVersion = 1
+
+// TestVectors are parameters frozen with the input hash and public inputs
+// they must yield, shared with the sequencers so that both sides validate
+// circuit changes against the same inputs.
+type TestVectors struct {
+	Version int `json:"version"`
+	// CircuitVersion is the CircuitSemver of the prover that exported the
+	// vectors, for reference only.
+	CircuitVersion string       `json:"circuitVersion"`
+	Vectors        []TestVector `json:"vectors"`
+}
+
+// TestVectorCircuit identifies the circuit a vector is for.
+type TestVectorCircuit struct {
+	Curve          string     `json:"curve"`
+	TreeDepth      uint32     `json:"treeDepth"`
+	BatchSize      uint32     `json:"batchSize"`
+	Commitment     Commitment `json:"commitment"`
+	TreeHash       TreeHash   `json:"treeHash"`
+	Indexed        bool       `json:"indexed"`
+	PublicPostRoot bool       `json:"publicPostRoot"`
+	// NonZeroIdComms is omitted when unset, as in vectors exported before
+	// the option was introduced.
+	NonZeroIdComms bool `json:"nonZeroIdComms,omitempty"`
+	// EmptyLeaf is 32-byte hex, like the field elements of the vector.
+	EmptyLeaf string `json:"emptyLeaf"`
+}
+
+// TestVector is a set of parameters with the input hash and public inputs
+// of the circuit it is for. Field elements are 32-byte hex.
+type TestVector struct {
+	Name       string            `json:"name"`
+	Circuit    TestVectorCircuit `json:"circuit"`
+	Parameters *Parameters       `json:"parameters"`
+	InputHash  string            `json:"inputHash"`
+	// PublicInputs are the public inputs of the proof, the input hash
+	// reduced modulo the scalar field then, for circuits exposing it, the
+	// post root.
+	PublicInputs []string `json:"publicInputs"`
+}
+
+// TestVectorMismatchError is returned when replaying a vector yields another
+// value than the frozen one.
+type TestVectorMismatchError struct {
+	Vector   string
+	Field    string
+	Expected string
+	Actual   string
+}
+
+func (e *TestVectorMismatchError) Error() string {
+	return fmt.Sprintf("test vector %s: expected %s %s, got %s", e.Vector, e.Field, e.Expected, e.Actual)
+}
+
+// ReadTestVectors strictly decodes test vectors of the current version.
+func ReadTestVectors(r io.Reader) (*TestVectors, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var version struct {
+		Version int `json:"version"`
+	}
+	if err = json.Unmarshal(data, &version); err != nil {
+		return nil, err
+	}
+	if version.Version != TestVectorsVersion {
+		return nil, fmt.Errorf("unsupported test vectors version %d, expected %d", version.Version, TestVectorsVersion)
+	}
+	var vectors TestVectors
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(&vectors); err != nil {
+		return nil, err
+	}
+	for i, vector := range vectors.Vectors {
+		if vector.Parameters == nil {
+			return nil, fmt.Errorf("test vector %d (%s) has no parameters", i, vector.Name)
+		}
+	}
+	return &vectors, nil
+}
+
+// WriteTo writes the vectors as indented JSON, so that changes to them diff
+// line by line.
+func (vectors *TestVectors) WriteTo(w io.Writer) (int64, error) {
+	data, err := json.MarshalIndent(vectors, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(append(data, '\n'))
+	return int64(n), err
+}
+
+// TestVectorCircuit returns the circuit of the proving system as recorded in
+// test vectors.
+func (ps *ProvingSystem) TestVectorCircuit() TestVectorCircuit {
+	commitment, treeHash := ps.Commitment, ps.TreeHash
+	if commitment == "" {
+		commitment = CommitmentKeccak
+	}
+	if treeHash == "" {
+		treeHash = TreeHashPoseidon
+	}
+	return TestVectorCircuit{
+		Curve:          ps.Curve.String(),
+		TreeDepth:      ps.TreeDepth,
+		BatchSize:      ps.BatchSize,
+		Commitment:     commitment,
+		TreeHash:       treeHash,
+		Indexed:        ps.Indexed,
+		NonZeroIdComms: ps.NonZeroIdComms,
+		PublicPostRoot: ps.PublicPostRoot,
+		EmptyLeaf:      toHex32(&ps.EmptyLeaf),
+	}
+}
+
+// PublicInputs returns the public inputs of the proof of params, whose input
+// hash must be computed.
+func (ps *ProvingSystem) PublicInputs(params *Parameters) []big.Int {
+	inputs := []big.Int{*new(big.Int).Mod(&params.InputHash, ps.Curve.ScalarField())}
+	if ps.PublicPostRoot {
+		inputs = append(inputs, *new(big.Int).Set(&params.PostRoot))
+	}
+	return inputs
+}
+
+// NewTestVector computes the input hash and public inputs of params, which
+// must solve the circuit, and freezes them in a vector.
+func (ps *ProvingSystem) NewTestVector(name string, params *Parameters) (*TestVector, error) {
+	params.InputHash.SetUint64(0)
+	if err := ps.Check(params); err != nil {
+		return nil, fmt.Errorf("test vector %s: %w", name, err)
+	}
+	vector := &TestVector{
+		Name:       name,
+		Circuit:    ps.TestVectorCircuit(),
+		Parameters: params,
+		InputHash:  toHex32(&params.InputHash),
+	}
+	for _, input := range ps.PublicInputs(params) {
+		vector.PublicInputs = append(vector.PublicInputs, toHex32(&input))
+	}
+	return vector, nil
+}
+
+// ReplayTestVector recomputes the input hash and public inputs of a vector
+// for the circuit of the proving system, and checks that they match the
+// frozen ones and that the parameters solve the circuit. With prove, the
+// parameters are also proven and the proof verified against the frozen
+// public inputs, which needs the keys. Vectors for another circuit fail
+// with an error; callers skip them by comparing TestVectorCircuit first. ctx
+// is that of the proof.
+func (ps *ProvingSystem) ReplayTestVector(ctx context.Context, vector *TestVector, prove bool) error {
+	if circuit := ps.TestVectorCircuit(); vector.Circuit != circuit {
+		return fmt.Errorf("test vector %s is for the circuit %+v, not %+v", vector.Name, vector.Circuit, circuit)
+	}
+	params := *vector.Parameters
+	params.InputHash = big.Int{}
+	if err := ps.Check(&params); err != nil {
+		return fmt.Errorf("test vector %s: %w", vector.Name, err)
+	}
+	if inputHash := toHex32(&params.InputHash); inputHash != vector.InputHash {
+		return &TestVectorMismatchError{Vector: vector.Name, Field: "inputHash", Expected: vector.InputHash, Actual: inputHash}
+	}
+	inputs := ps.PublicInputs(&params)
+	actual := make([]string, len(inputs))
+	for i := range inputs {
+		actual[i] = toHex32(&inputs[i])
+	}
+	if fmt.Sprint(actual) != fmt.Sprint(vector.PublicInputs) {
+		return &TestVectorMismatchError{Vector: vector.Name, Field: "publicInputs", Expected: fmt.Sprint(vector.PublicInputs), Actual: fmt.Sprint(actual)}
+	}
+	if !prove {
+		return nil
+	}
+	proof, err := ps.Prove(ctx, &params)
+	if err != nil {
+		return fmt.Errorf("test vector %s: %w", vector.Name, err)
+	}
+	if ps.PublicPostRoot {
+		err = ps.VerifyWithPostRoot(params.InputHash, params.PostRoot, proof)
+	} else {
+		err = ps.Verify(params.InputHash, proof)
+	}
+	if err != nil {
+		return fmt.Errorf("test vector %s: the proof does not verify: %w", vector.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package prover
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+)
+
+func TestTestVectors(t *testing.T) {
+	cs, err := BuildR1CS(context.Background(), testTreeDepth, testBatchSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps := &ProvingSystem{Curve: ecc.BN254, TreeDepth: testTreeDepth, BatchSize: testBatchSize, ConstraintSystem: cs}
+	vector, err := ps.NewTestVector("insertion", testParameters())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vector.InputHash != toHex32(&testParameters().InputHash) || len(vector.PublicInputs) != 1 {
+		t.Fatalf("unexpected vector %+v", vector)
+	}
+
+	var buf bytes.Buffer
+	if _, err = (&TestVectors{Version: TestVectorsVersion, CircuitVersion: CircuitSemver, Vectors: []TestVector{*vector}}).WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	vectors, err := ReadTestVectors(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ps.ReplayTestVector(context.Background(), &vectors.Vectors[0], false); err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := vectors.Vectors[0]
+	tampered.InputHash = toHex32(new(big.Int).SetUint64(1))
+	var mismatch *TestVectorMismatchError
+	if err = ps.ReplayTestVector(context.Background(), &tampered, false); !errors.As(err, &mismatch) || mismatch.Field != "inputHash" {
+		t.Fatalf("expected the input hash to mismatch, got %v", err)
+	}
+	tampered = vectors.Vectors[0]
+	tampered.Circuit.Commitment = CommitmentPoseidon
+	if err = ps.ReplayTestVector(context.Background(), &tampered, false); err == nil {
+		t.Fatal("expected a vector of another circuit to be rejected")
+	}
+
+	if _, err = ReadTestVectors(strings.NewReader(strings.Replace(buf.String(), `"version": 1`, `"version": 2`, 1))); err == nil {
+		t.Fatal("expected another version to be rejected")
+	}
+}
@@ -0,0 +1,63 @@
+package prover
+
+import (
+	"fmt"
+	"math/big"
+
+	"worldcoin/gnark-mbu/merkletree"
+)
+
+// NewTree returns an empty tree of the depth, empty leaf and tree hash of the
+// circuit, for building parameters with ParametersFromTree.
+func (ps *ProvingSystem) NewTree() (*merkletree.Tree, error) {
+	hash, err := NativeTreeHash(ps.TreeHash, ps.Curve)
+	if err != nil {
+		return nil, err
+	}
+	return merkletree.New(int(ps.TreeDepth), &ps.EmptyLeaf, hash)
+}
+
+// ParametersFromTree inserts idComms into tree at consecutive indices from
+// startIndex and returns the parameters proving the insertion: the roots of
+// the tree before and after it, and the Merkle proof of every slot in the
+// tree left by the insertions before it. The slots must be empty. The input
+// hash is left for the prover to compute. If hashing fails, the tree is left
+// partially updated.
+func ParametersFromTree(tree *merkletree.Tree, startIndex uint32, idComms []big.Int) (*Parameters, error) {
+	params := &Parameters{
+		StartIndex: startIndex,
+		IdComms:    make([]big.Int, len(idComms)),
+	}
+	params.EmptyLeaf.Set(tree.EmptyLeaf())
+	if err := params.ValidateCapacity(uint32(tree.Depth())); err != nil {
+		return nil, err
+	}
+	for i := range idComms {
+		params.IdComms[i].Set(&idComms[i])
+		leaf, err := tree.Leaf(params.index(i))
+		if err != nil {
+			return nil, err
+		}
+		if leaf.Cmp(&params.EmptyLeaf) != 0 {
+			return nil, &ParameterError{
+				Field:  "startIndex",
+				Value:  fmt.Sprint(startIndex),
+				Reason: fmt.Sprintf("slot %d of the tree is not empty", params.index(i)),
+			}
+		}
+	}
+	params.PreRoot.Set(tree.Root())
+	params.MerkleProofs = make([][]big.Int, len(idComms))
+	for i := range idComms {
+		proof, err := tree.Proof(params.index(i))
+		if err != nil {
+			return nil, err
+		}
+		params.MerkleProofs[i] = proof
+		if err = tree.Set(params.index(i), &params.IdComms[i]); err != nil {
+			return nil, err
+		}
+	}
+	params.PostRoot.Set(tree.Root())
+	return params, nil
+}
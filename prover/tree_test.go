@@ -0,0 +1,53 @@
+package prover
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+)
+
+func TestParametersFromTree(t *testing.T) {
+	ps := &ProvingSystem{Curve: ecc.BN254, TreeDepth: testTreeDepth}
+	tree, err := ps.NewTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The tree is filled in two batches, the second starting where the first
+	// ends.
+	for _, batch := range []struct{ start, n int }{{0, 3}, {3, 2}} {
+		expected := offsetParameters(batch.start, batch.n)
+		params, err := ParametersFromTree(tree, uint32(batch.start), expected.IdComms)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if params.PreRoot.Cmp(&expected.PreRoot) != 0 || params.PostRoot.Cmp(&expected.PostRoot) != 0 {
+			t.Fatalf("batch at %d: roots differ", batch.start)
+		}
+		for i := range expected.MerkleProofs {
+			for level := range expected.MerkleProofs[i] {
+				if params.MerkleProofs[i][level].Cmp(&expected.MerkleProofs[i][level]) != 0 {
+					t.Fatalf("batch at %d: proof %d differs at level %d", batch.start, i, level)
+				}
+			}
+		}
+		if err = params.Verify(testTreeDepth, uint32(batch.n)); err != nil {
+			t.Fatalf("batch at %d: %v", batch.start, err)
+		}
+	}
+
+	// Filled slots cannot be inserted into again.
+	root := tree.Root()
+	var parameterError *ParameterError
+	if _, err = ParametersFromTree(tree, 2, []big.Int{*big.NewInt(9), *big.NewInt(10)}); !errors.As(err, &parameterError) {
+		t.Fatalf("expected a ParameterError, got %v", err)
+	}
+	var startIndexError *StartIndexError
+	if _, err = ParametersFromTree(tree, 1<<testTreeDepth-1, []big.Int{*big.NewInt(9), *big.NewInt(10)}); !errors.As(err, &startIndexError) {
+		t.Fatalf("expected a StartIndexError, got %v", err)
+	}
+	if tree.Root().Cmp(root) != 0 {
+		t.Fatal("expected rejected insertions to leave the tree unchanged")
+	}
+}
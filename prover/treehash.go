@@ -0,0 +1,196 @@
+package prover
+
+import (
+	"fmt"
+	"math/big"
+	"sync"
+	"worldcoin/gnark-mbu/prover/poseidon"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	gnarkHash "github.com/consensys/gnark-crypto/hash"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/std/hash/mimc"
+	iden3poseidon "github.com/iden3/go-iden3-crypto/poseidon"
+)
+
+// TreeHash is the two-to-one hash of the nodes of the Merkle tree. It is
+// chosen at setup and recorded in the key file, so that the prover can serve
+// Semaphore forks hashing their trees differently.
+type TreeHash string
+
+const (
+	// TreeHashPoseidon is Poseidon with the parameters of circomlib, 8 full
+	// and 57 partial rounds, used by Semaphore. It is the default.
+	TreeHashPoseidon TreeHash = "poseidon"
+	// TreeHashMiMC is the MiMC of gnark in Miyaguchi-Preneel mode, hashing
+	// the left node and then the right one. Its parameters are those of the
+	// curve.
+	TreeHashMiMC TreeHash = "mimc"
+)
+
+// The rounds of circomlib, and the bound of the rounds of PoseidonTreeHash,
+// which the parameters are derived from as 10-bit numbers.
+const (
+	circomlibFullRounds    = 8
+	circomlibPartialRounds = 57
+	maxPoseidonRounds      = 1<<10 - 1
+)
+
+// PoseidonTreeHash is Poseidon with the given numbers of rounds, whose
+// constants and MDS matrix are derived for the field of the curve like the
+// reference implementation of Poseidon derives them. It is named
+// poseidon-<full rounds>-<partial rounds>; the rounds of circomlib give
+// TreeHashPoseidon.
+func PoseidonTreeHash(fullRounds, partialRounds int) TreeHash {
+	if fullRounds == circomlibFullRounds && partialRounds == circomlibPartialRounds {
+		return TreeHashPoseidon
+	}
+	return TreeHash(fmt.Sprintf("poseidon-%d-%d", fullRounds, partialRounds))
+}
+
+// poseidonRounds returns the rounds of a Poseidon tree hash.
+func (h TreeHash) poseidonRounds() (fullRounds, partialRounds int, ok bool) {
+	if h == TreeHashPoseidon || h == "" {
+		return circomlibFullRounds, circomlibPartialRounds, true
+	}
+	var trailing string
+	n, _ := fmt.Sscanf(string(h), "poseidon-%d-%d%s", &fullRounds, &partialRounds, &trailing)
+	return fullRounds, partialRounds, n == 2
+}
+
+// ParseTreeHash parses the name of a tree hash, the empty name selecting
+// TreeHashPoseidon.
+func ParseTreeHash(name string) (TreeHash, error) {
+	if h := TreeHash(name); h == TreeHashMiMC {
+		return h, nil
+	}
+	fullRounds, partialRounds, ok := TreeHash(name).poseidonRounds()
+	if !ok {
+		return "", fmt.Errorf("unknown tree hash: %s", name)
+	}
+	if fullRounds < 2 || fullRounds%2 != 0 || fullRounds > maxPoseidonRounds {
+		return "", fmt.Errorf("invalid tree hash %s: the full rounds must be even, from 2 to %d", name, maxPoseidonRounds)
+	}
+	if partialRounds < 1 || partialRounds > maxPoseidonRounds {
+		return "", fmt.Errorf("invalid tree hash %s: the partial rounds must be from 1 to %d", name, maxPoseidonRounds)
+	}
+	return PoseidonTreeHash(fullRounds, partialRounds), nil
+}
+
+// WithTreeHash selects the hash of the nodes of the Merkle tree. It defaults
+// to TreeHashPoseidon.
+func WithTreeHash(treeHash TreeHash) CircuitOption {
+	return func(o *circuitOptions) {
+		o.treeHash = treeHash
+	}
+}
+
+// poseidonParameters caches the parameters of Poseidon tree hashes by hash
+// and field, which take milliseconds to derive.
+var poseidonParameters sync.Map
+
+type poseidonParametersKey struct {
+	treeHash TreeHash
+	field    string
+}
+
+// poseidonParameters returns the parameters of a Poseidon tree hash over
+// field. Those of circomlib are reduced modulo the field rather than derived
+// for it, like NewPoseidon2 does.
+func (h TreeHash) poseidonParameters(field *big.Int) *poseidon.Parameters {
+	if h == TreeHashPoseidon || h == "" {
+		return poseidon.Parameters2
+	}
+	key := poseidonParametersKey{treeHash: h, field: field.String()}
+	if params, ok := poseidonParameters.Load(key); ok {
+		return params.(*poseidon.Parameters)
+	}
+	fullRounds, partialRounds, _ := h.poseidonRounds()
+	params, _ := poseidonParameters.LoadOrStore(key, poseidon.GenerateParameters(field, 3, fullRounds, partialRounds))
+	return params.(*poseidon.Parameters)
+}
+
+// TreeHasher hashes two nodes of the Merkle tree into their parent in a
+// circuit.
+type TreeHasher interface {
+	Hash(left, right frontend.Variable) frontend.Variable
+}
+
+type poseidonTreeHasher struct {
+	h poseidon.Poseidon
+}
+
+func (p *poseidonTreeHasher) Hash(left, right frontend.Variable) frontend.Variable {
+	return nodeSum(p.h, left, right)
+}
+
+type mimcTreeHasher struct {
+	h mimc.MiMC
+}
+
+func (m *mimcTreeHasher) Hash(left, right frontend.Variable) frontend.Variable {
+	m.h.Reset()
+	m.h.Write(left, right)
+	return m.h.Sum()
+}
+
+// treeHasher returns the gadget of the tree hash in a circuit.
+func (h TreeHash) treeHasher(api frontend.API) (TreeHasher, error) {
+	if h == TreeHashMiMC {
+		m, err := mimc.NewMiMC(api)
+		if err != nil {
+			return nil, err
+		}
+		return &mimcTreeHasher{h: m}, nil
+	}
+	return &poseidonTreeHasher{h: poseidon.NewPoseidonWithParameters(api, h.poseidonParameters(api.Compiler().Field()))}, nil
+}
+
+// mimcHashes are the native MiMC of the supported curves.
+var mimcHashes = map[ecc.ID]gnarkHash.Hash{
+	ecc.BN254:     gnarkHash.MIMC_BN254,
+	ecc.BLS12_377: gnarkHash.MIMC_BLS12_377,
+	ecc.BLS12_381: gnarkHash.MIMC_BLS12_381,
+	ecc.BLS24_315: gnarkHash.MIMC_BLS24_315,
+	ecc.BLS24_317: gnarkHash.MIMC_BLS24_317,
+	ecc.BW6_761:   gnarkHash.MIMC_BW6_761,
+	ecc.BW6_633:   gnarkHash.MIMC_BW6_633,
+}
+
+// NativeTreeHash returns the tree hash on curve computed outside of the
+// circuit, using the faster iden3 implementation of TreeHashPoseidon on
+// BN254. The nodes must be elements of the scalar field of the curve.
+func NativeTreeHash(treeHash TreeHash, curve ecc.ID) (func(left, right *big.Int) (*big.Int, error), error) {
+	treeHash, err := ParseTreeHash(string(treeHash))
+	if err != nil {
+		return nil, err
+	}
+	field := curve.ScalarField()
+	switch {
+	case treeHash == TreeHashMiMC:
+		mimcHash, ok := mimcHashes[curve]
+		if !ok {
+			return nil, fmt.Errorf("unsupported curve: %s", curve)
+		}
+		size := (field.BitLen() + 7) / 8
+		return func(left, right *big.Int) (*big.Int, error) {
+			h := mimcHash.New()
+			if _, err := h.Write(left.FillBytes(make([]byte, size))); err != nil {
+				return nil, err
+			}
+			if _, err := h.Write(right.FillBytes(make([]byte, size))); err != nil {
+				return nil, err
+			}
+			return new(big.Int).SetBytes(h.Sum(nil)), nil
+		}, nil
+	case treeHash == TreeHashPoseidon && curve == ecc.BN254:
+		return func(left, right *big.Int) (*big.Int, error) {
+			return iden3poseidon.Hash([]*big.Int{left, right})
+		}, nil
+	default:
+		params := treeHash.poseidonParameters(field)
+		return func(left, right *big.Int) (*big.Int, error) {
+			return params.Hash(field, left, right), nil
+		}, nil
+	}
+}
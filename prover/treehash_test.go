@@ -0,0 +1,107 @@
+package prover
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/test"
+)
+
+func TestParseTreeHash(t *testing.T) {
+	for name, expected := range map[string]TreeHash{
+		"":              TreeHashPoseidon,
+		"poseidon":      TreeHashPoseidon,
+		"poseidon-8-57": TreeHashPoseidon,
+		"poseidon-8-60": "poseidon-8-60",
+		"mimc":          TreeHashMiMC,
+	} {
+		if treeHash, err := ParseTreeHash(name); err != nil || treeHash != expected {
+			t.Errorf("%q: expected %s, got %s, %v", name, expected, treeHash, err)
+		}
+	}
+	for _, name := range []string{"poseidon2", "poseidon-7-57", "poseidon-8-0", "poseidon-8-60x", "poseidon-8-2000"} {
+		if _, err := ParseTreeHash(name); err == nil {
+			t.Errorf("%q: expected an error", name)
+		}
+	}
+}
+
+// treeHashParameters returns the insertion of insertionParameters into a
+// tree hashed with treeHash.
+func treeHashParameters(t *testing.T, treeHash TreeHash) *Parameters {
+	hash, err := NativeTreeHash(treeHash, ecc.BN254)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := func(left, right big.Int) big.Int {
+		parent, err := hash(&left, &right)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return *parent
+	}
+	empty := make([]big.Int, testTreeDepth+1)
+	for i := 1; i <= testTreeDepth; i++ {
+		empty[i] = h(empty[i-1], empty[i-1])
+	}
+	first, second := *big.NewInt(1), *big.NewInt(2)
+	params := Parameters{
+		PreRoot:  empty[testTreeDepth],
+		PostRoot: h(h(h(first, second), empty[1]), empty[2]),
+		IdComms:  []big.Int{first, second},
+		MerkleProofs: [][]big.Int{
+			{empty[0], empty[1], empty[2]},
+			{first, empty[1], empty[2]},
+		},
+	}
+	params.ComputeInputHash()
+	return &params
+}
+
+func TestCircuitWithTreeHash(t *testing.T) {
+	for _, treeHash := range []TreeHash{TreeHashPoseidon, PoseidonTreeHash(8, 60), TreeHashMiMC} {
+		params := treeHashParameters(t, treeHash)
+		ps := &ProvingSystem{Curve: ecc.BN254, TreeDepth: testTreeDepth, BatchSize: testBatchSize, TreeHash: treeHash}
+		if err := ps.checkRoots(params); err != nil {
+			t.Fatalf("%s: %v", treeHash, err)
+		}
+		options := newCircuitOptions([]CircuitOption{WithTreeHash(treeHash)})
+		circuit, assignment := testAssignment(params), testAssignment(params)
+		err := test.IsSolved(options.wrap(circuit, nil), options.wrap(assignment, nil), ecc.BN254.ScalarField())
+		if err != nil {
+			t.Fatalf("%s: %v", treeHash, err)
+		}
+	}
+
+	// A tree hashed with Poseidon does not open under MiMC.
+	params := testParameters()
+	ps := &ProvingSystem{Curve: ecc.BN254, TreeDepth: testTreeDepth, BatchSize: testBatchSize, TreeHash: TreeHashMiMC}
+	if _, ok := ps.checkRoots(params).(*RootMismatchError); !ok {
+		t.Fatal("expected a root mismatch under another tree hash")
+	}
+	options := newCircuitOptions([]CircuitOption{WithTreeHash(TreeHashMiMC)})
+	circuit, assignment := testAssignment(params), testAssignment(params)
+	if err := test.IsSolved(options.wrap(circuit, nil), options.wrap(assignment, nil), ecc.BN254.ScalarField()); err == nil {
+		t.Fatal("expected the circuit to reject a tree hashed with poseidon")
+	}
+}
+
+func TestTreeHashHeader(t *testing.T) {
+	poseidon := &ProvingSystem{Curve: ecc.BN254, TreeDepth: 20, BatchSize: 10}
+	mimc := &ProvingSystem{Curve: ecc.BN254, TreeDepth: 20, BatchSize: 10, TreeHash: TreeHashMiMC}
+	if poseidon.Fingerprint() != (&ProvingSystem{Curve: ecc.BN254, TreeDepth: 20, BatchSize: 10, TreeHash: TreeHashPoseidon}).Fingerprint() {
+		t.Fatal("expected the default tree hash to keep the fingerprint")
+	}
+	if poseidon.Fingerprint() == mimc.Fingerprint() {
+		t.Fatal("expected the tree hash to change the fingerprint")
+	}
+	header := mimc.keysFileHeader()
+	var read ProvingSystem
+	if err := read.setCircuit(&header); err != nil {
+		t.Fatal(err)
+	}
+	if read.TreeHash != TreeHashMiMC {
+		t.Fatalf("expected the tree hash to be read back, got %s", read.TreeHash)
+	}
+}
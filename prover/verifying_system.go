@@ -0,0 +1,198 @@
+package prover
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/frontend"
+)
+
+// verifyingKeyFileMagic prefixes verifying key files, which hold the header
+// of the proving system they were exported from, the verifying key and a
+// SHA-256 checksum.
+var verifyingKeyFileMagic = [4]byte{'M', 'B', 'U', 'V'}
+
+// VerifyingSystem is the part of a proving system needed to verify proofs.
+// Its file is a few hundred bytes, so verifiers do not need to download the
+// proving key.
+type VerifyingSystem struct {
+	Curve          ecc.ID
+	TreeDepth      uint32
+	BatchSize      uint32
+	PublicPostRoot bool
+	EmptyLeaf      big.Int
+	Commitment     Commitment
+	Indexed        bool
+	TreeHash       TreeHash
+	LookupKeccak   bool
+	VerifyingKey   groth16.VerifyingKey
+}
+
+// VerifyingSystem returns the verifying part of the proving system.
+func (ps *ProvingSystem) VerifyingSystem() *VerifyingSystem {
+	vs := &VerifyingSystem{
+		Curve:          ps.Curve,
+		TreeDepth:      ps.TreeDepth,
+		BatchSize:      ps.BatchSize,
+		PublicPostRoot: ps.PublicPostRoot,
+		Commitment:     ps.Commitment,
+		Indexed:        ps.Indexed,
+		TreeHash:       ps.TreeHash,
+		LookupKeccak:   ps.LookupKeccak,
+		VerifyingKey:   ps.VerifyingKey,
+	}
+	vs.EmptyLeaf.Set(&ps.EmptyLeaf)
+	return vs
+}
+
+// circuit returns a proving system without keys describing the same circuit.
+func (vs *VerifyingSystem) circuit() *ProvingSystem {
+	ps := &ProvingSystem{
+		Curve:          vs.Curve,
+		TreeDepth:      vs.TreeDepth,
+		BatchSize:      vs.BatchSize,
+		PublicPostRoot: vs.PublicPostRoot,
+		Commitment:     vs.Commitment,
+		Indexed:        vs.Indexed,
+		TreeHash:       vs.TreeHash,
+		LookupKeccak:   vs.LookupKeccak,
+	}
+	ps.EmptyLeaf.Set(&vs.EmptyLeaf)
+	return ps
+}
+
+// ProvingSystem returns a proving system holding the verifying key only. It
+// verifies proofs like the verifying system, but cannot prove.
+func (vs *VerifyingSystem) ProvingSystem() *ProvingSystem {
+	ps := vs.circuit()
+	ps.VerifyingKey = vs.VerifyingKey
+	return ps
+}
+
+// Fingerprint identifies the circuit like the fingerprint of the proving
+// system the verifying key was exported from.
+func (vs *VerifyingSystem) Fingerprint() string {
+	return vs.circuit().Fingerprint()
+}
+
+func (vs *VerifyingSystem) Verify(inputHash big.Int, proof *Proof) error {
+	if vs.PublicPostRoot {
+		return fmt.Errorf("the circuit exposes the post root as a public input, use VerifyWithPostRoot")
+	}
+	return vs.verify(inputHash, nil, proof)
+}
+
+// VerifyWithPostRoot verifies a proof generated by a proving system set up
+// with WithPublicPostRoot.
+func (vs *VerifyingSystem) VerifyWithPostRoot(inputHash big.Int, postRoot big.Int, proof *Proof) error {
+	if !vs.PublicPostRoot {
+		return fmt.Errorf("the circuit does not expose the post root as a public input, use Verify")
+	}
+	return vs.verify(inputHash, postRoot, proof)
+}
+
+func (vs *VerifyingSystem) verify(inputHash big.Int, postRoot frontend.Variable, proof *Proof) error {
+	if proof.Proof.CurveID() != vs.Curve {
+		return fmt.Errorf("proof is on curve %s, the proving system uses %s", proof.Proof.CurveID(), vs.Curve)
+	}
+	publicAssignment := MbuCircuit{
+		InputHash: inputHash,
+		IdComms:   make([]frontend.Variable, vs.BatchSize),
+	}
+	witness, err := frontend.NewWitness(vs.circuit().circuitOptions().wrap(publicAssignment, postRoot), vs.Curve.ScalarField(), frontend.PublicOnly())
+	if err != nil {
+		return err
+	}
+	return groth16.Verify(proof.Proof, vs.VerifyingKey, witness)
+}
+
+// WriteTo writes the verifying key file.
+func (vs *VerifyingSystem) WriteTo(w io.Writer) (int64, error) {
+	kw := newKeysFileWriter(w, false)
+	header := vs.circuit().keysFileHeader()
+	if err := kw.write(func(w io.Writer) (int64, error) { return writeFileHeader(w, verifyingKeyFileMagic, &header) }); err != nil {
+		return kw.written, err
+	}
+	if err := kw.key(vs.VerifyingKey); err != nil {
+		return kw.written, err
+	}
+	err := kw.finish()
+	return kw.written, err
+}
+
+// ReadFrom reads a verifying key file, checking its fingerprint and checksum.
+func (vs *VerifyingSystem) ReadFrom(r io.Reader) (int64, error) {
+	digest := sha256.New()
+	hashed := io.TeeReader(r, digest)
+	var magic [4]byte
+	read, err := io.ReadFull(hashed, magic[:])
+	totalRead := int64(read)
+	if err != nil {
+		return totalRead, err
+	}
+	if magic != verifyingKeyFileMagic {
+		return totalRead, fmt.Errorf("not a verifying key file")
+	}
+	header, headerRead, err := readFileHeaderBody(hashed)
+	totalRead += headerRead
+	if err != nil {
+		return totalRead, err
+	}
+	if err = header.verify(); err != nil {
+		return totalRead, err
+	}
+	var circuit ProvingSystem
+	if err = circuit.setCircuit(header); err != nil {
+		return totalRead, err
+	}
+	*vs = *circuit.VerifyingSystem()
+
+	vs.VerifyingKey = groth16.NewVerifyingKey(vs.Curve)
+	keyRead, err := vs.VerifyingKey.ReadFrom(hashed)
+	totalRead += keyRead
+	if err != nil {
+		return totalRead, keysReadError(header, err)
+	}
+	read64, err := readKeysFileChecksum(r, header, digest)
+	return totalRead + read64, err
+}
+
+// WriteVerifyingKeyToFile exports the verifying key of the proving system to
+// path.
+func (ps *ProvingSystem) WriteVerifyingKeyToFile(path string) (written int64, err error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		closeErr := file.Close()
+		if closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}()
+	return ps.VerifyingSystem().WriteTo(file)
+}
+
+func ReadVerifyingSystemFromFile(path string) (vs *VerifyingSystem, err error) {
+	vs = new(VerifyingSystem)
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		closeErr := file.Close()
+		if closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}()
+
+	_, err = vs.ReadFrom(bufio.NewReader(file))
+	return
+}
@@ -0,0 +1,56 @@
+package prover
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestVerifyingKeyFile(t *testing.T) {
+	ps := smallProvingSystem(t)
+	ps.PublicPostRoot = true
+	ps.EmptyLeaf.SetUint64(7)
+	ps.Commitment = CommitmentPoseidon
+	var buf bytes.Buffer
+	written, err := ps.VerifyingSystem().WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := buf.Bytes()
+
+	var vs VerifyingSystem
+	read, err := vs.ReadFrom(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read != written {
+		t.Fatalf("read %d bytes, written %d", read, written)
+	}
+	if vs.Fingerprint() != ps.Fingerprint() {
+		t.Fatal("expected the verifying system to have the fingerprint of the proving system")
+	}
+	if !vs.PublicPostRoot || vs.EmptyLeaf.Uint64() != 7 || vs.Commitment != CommitmentPoseidon || vs.TreeDepth != ps.TreeDepth {
+		t.Fatalf("unexpected verifying system %+v", vs)
+	}
+	var expected, actual bytes.Buffer
+	ps.VerifyingKey.WriteTo(&expected)
+	vs.VerifyingKey.WriteTo(&actual)
+	if !bytes.Equal(expected.Bytes(), actual.Bytes()) {
+		t.Fatal("expected the verifying key to survive a round trip")
+	}
+
+	flipped := bytes.Clone(file)
+	flipped[len(flipped)-1] ^= 1
+	var corrupt *CorruptKeysError
+	if _, err = new(VerifyingSystem).ReadFrom(bytes.NewReader(flipped)); !errors.As(err, &corrupt) {
+		t.Fatalf("expected a checksum mismatch to be rejected, got %v", err)
+	}
+
+	var keysFile bytes.Buffer
+	if _, err = ps.WriteTo(&keysFile); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = new(VerifyingSystem).ReadFrom(&keysFile); err == nil {
+		t.Fatal("expected a proving system file to be rejected")
+	}
+}
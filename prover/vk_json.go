@@ -0,0 +1,148 @@
+package prover
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	bls12377 "github.com/consensys/gnark-crypto/ecc/bls12-377"
+	bls12377fp "github.com/consensys/gnark-crypto/ecc/bls12-377/fp"
+	bls12381 "github.com/consensys/gnark-crypto/ecc/bls12-381"
+	bls12381fp "github.com/consensys/gnark-crypto/ecc/bls12-381/fp"
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	bn254fp "github.com/consensys/gnark-crypto/ecc/bn254/fp"
+)
+
+// VerifyingKeyJSON is the verifying key in the layout of ark-groth16's
+// VerifyingKey, for verifiers that do not read gnark's binary encoding.
+// Base field elements are 0x-prefixed big-endian hex padded to the size of
+// the field. G1 points are [x, y] and G2 points [x, y] with each coordinate
+// [c0, c1], the real part first as in arkworks; the Solidity verifier expects
+// the opposite order. GammaABC holds one point per public input, preceded by
+// the one for the constant wire.
+type VerifyingKeyJSON struct {
+	Protocol string `json:"protocol"`
+	Curve    string `json:"curve"`
+	// Fingerprint identifies the circuit, as reported by /info.
+	Fingerprint string `json:"fingerprint"`
+	// PublicInputs names the public inputs in order.
+	PublicInputs []string     `json:"publicInputs"`
+	Alpha        [2]string    `json:"alpha_g1"`
+	Beta         [2][2]string `json:"beta_g2"`
+	Gamma        [2][2]string `json:"gamma_g2"`
+	Delta        [2][2]string `json:"delta_g2"`
+	GammaABC     [][2]string  `json:"gamma_abc_g1"`
+}
+
+// fieldElement is implemented by the base field elements of every curve.
+type fieldElement interface {
+	BigInt(res *big.Int) *big.Int
+}
+
+func fieldHex(e fieldElement, size int) string {
+	return fmt.Sprintf("0x%0*x", 2*size, e.BigInt(new(big.Int)))
+}
+
+// verifyingKeyPoints decodes the raw encoding of a verifying key, which is
+// [α]1, [β]1, [β]2, [γ]2, [δ]1, [δ]2, uint32(len(K)), [K]1 for all curves.
+func verifyingKeyPoints(decode func(v interface{}) error, alpha, betaG1, beta, gamma, deltaG1, delta, k interface{}) error {
+	for _, v := range []interface{}{alpha, betaG1, beta, gamma, deltaG1, delta, k} {
+		if err := decode(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExportVerifyingKeyJSON writes the verifying key as VerifyingKeyJSON. It is
+// supported on BN254, BLS12-381 and BLS12-377.
+func (vs *VerifyingSystem) ExportVerifyingKeyJSON(w io.Writer) error {
+	var raw bytes.Buffer
+	if _, err := vs.VerifyingKey.WriteRawTo(&raw); err != nil {
+		return err
+	}
+	vk := VerifyingKeyJSON{
+		Protocol:     "groth16",
+		Curve:        vs.Curve.String(),
+		Fingerprint:  vs.Fingerprint(),
+		PublicInputs: []string{"inputHash"},
+	}
+	if vs.PublicPostRoot {
+		vk.PublicInputs = append(vk.PublicInputs, "postRoot")
+	}
+	switch vs.Curve {
+	case ecc.BN254:
+		var alpha, betaG1, deltaG1 bn254.G1Affine
+		var beta, gamma, delta bn254.G2Affine
+		var k []bn254.G1Affine
+		if err := verifyingKeyPoints(bn254.NewDecoder(&raw).Decode, &alpha, &betaG1, &beta, &gamma, &deltaG1, &delta, &k); err != nil {
+			return err
+		}
+		g1 := func(p *bn254.G1Affine) [2]string {
+			return [2]string{fieldHex(&p.X, bn254fp.Bytes), fieldHex(&p.Y, bn254fp.Bytes)}
+		}
+		g2 := func(p *bn254.G2Affine) [2][2]string {
+			return [2][2]string{
+				{fieldHex(&p.X.A0, bn254fp.Bytes), fieldHex(&p.X.A1, bn254fp.Bytes)},
+				{fieldHex(&p.Y.A0, bn254fp.Bytes), fieldHex(&p.Y.A1, bn254fp.Bytes)},
+			}
+		}
+		vk.Alpha, vk.Beta, vk.Gamma, vk.Delta = g1(&alpha), g2(&beta), g2(&gamma), g2(&delta)
+		for i := range k {
+			vk.GammaABC = append(vk.GammaABC, g1(&k[i]))
+		}
+	case ecc.BLS12_381:
+		var alpha, betaG1, deltaG1 bls12381.G1Affine
+		var beta, gamma, delta bls12381.G2Affine
+		var k []bls12381.G1Affine
+		if err := verifyingKeyPoints(bls12381.NewDecoder(&raw).Decode, &alpha, &betaG1, &beta, &gamma, &deltaG1, &delta, &k); err != nil {
+			return err
+		}
+		g1 := func(p *bls12381.G1Affine) [2]string {
+			return [2]string{fieldHex(&p.X, bls12381fp.Bytes), fieldHex(&p.Y, bls12381fp.Bytes)}
+		}
+		g2 := func(p *bls12381.G2Affine) [2][2]string {
+			return [2][2]string{
+				{fieldHex(&p.X.A0, bls12381fp.Bytes), fieldHex(&p.X.A1, bls12381fp.Bytes)},
+				{fieldHex(&p.Y.A0, bls12381fp.Bytes), fieldHex(&p.Y.A1, bls12381fp.Bytes)},
+			}
+		}
+		vk.Alpha, vk.Beta, vk.Gamma, vk.Delta = g1(&alpha), g2(&beta), g2(&gamma), g2(&delta)
+		for i := range k {
+			vk.GammaABC = append(vk.GammaABC, g1(&k[i]))
+		}
+	case ecc.BLS12_377:
+		var alpha, betaG1, deltaG1 bls12377.G1Affine
+		var beta, gamma, delta bls12377.G2Affine
+		var k []bls12377.G1Affine
+		if err := verifyingKeyPoints(bls12377.NewDecoder(&raw).Decode, &alpha, &betaG1, &beta, &gamma, &deltaG1, &delta, &k); err != nil {
+			return err
+		}
+		g1 := func(p *bls12377.G1Affine) [2]string {
+			return [2]string{fieldHex(&p.X, bls12377fp.Bytes), fieldHex(&p.Y, bls12377fp.Bytes)}
+		}
+		g2 := func(p *bls12377.G2Affine) [2][2]string {
+			return [2][2]string{
+				{fieldHex(&p.X.A0, bls12377fp.Bytes), fieldHex(&p.X.A1, bls12377fp.Bytes)},
+				{fieldHex(&p.Y.A0, bls12377fp.Bytes), fieldHex(&p.Y.A1, bls12377fp.Bytes)},
+			}
+		}
+		vk.Alpha, vk.Beta, vk.Gamma, vk.Delta = g1(&alpha), g2(&beta), g2(&gamma), g2(&delta)
+		for i := range k {
+			vk.GammaABC = append(vk.GammaABC, g1(&k[i]))
+		}
+	default:
+		return fmt.Errorf("verifying key JSON is not supported on %s", vs.Curve)
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(&vk)
+}
+
+// ExportVerifyingKeyJSON writes the verifying key as VerifyingKeyJSON.
+func (ps *ProvingSystem) ExportVerifyingKeyJSON(w io.Writer) error {
+	return ps.VerifyingSystem().ExportVerifyingKeyJSON(w)
+}
@@ -0,0 +1,58 @@
+package prover
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fp"
+)
+
+func TestExportVerifyingKeyJSON(t *testing.T) {
+	ps := smallProvingSystem(t)
+	var buf bytes.Buffer
+	if err := ps.ExportVerifyingKeyJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var vk VerifyingKeyJSON
+	if err := json.Unmarshal(buf.Bytes(), &vk); err != nil {
+		t.Fatal(err)
+	}
+	if vk.Protocol != "groth16" || vk.Curve != "bn254" || vk.Fingerprint != ps.Fingerprint() {
+		t.Fatalf("unexpected verifying key %+v", vk)
+	}
+	// The square circuit has one public input besides the constant wire.
+	if len(vk.GammaABC) != 2 {
+		t.Fatalf("expected 2 gamma_abc_g1 points, got %d", len(vk.GammaABC))
+	}
+
+	coordinate := func(s string) big.Int {
+		if len(s) != 66 || !strings.HasPrefix(s, "0x") {
+			t.Fatalf("expected a 32-byte hex coordinate, got %s", s)
+		}
+		var i big.Int
+		i.SetString(s[2:], 16)
+		return i
+	}
+	set := func(e *fp.Element, s string) {
+		i := coordinate(s)
+		e.SetBigInt(&i)
+	}
+	var alpha bn254.G1Affine
+	set(&alpha.X, vk.Alpha[0])
+	set(&alpha.Y, vk.Alpha[1])
+	if !alpha.IsOnCurve() {
+		t.Fatal("expected alpha to be on the curve")
+	}
+	var delta bn254.G2Affine
+	set(&delta.X.A0, vk.Delta[0][0])
+	set(&delta.X.A1, vk.Delta[0][1])
+	set(&delta.Y.A0, vk.Delta[1][0])
+	set(&delta.Y.A1, vk.Delta[1][1])
+	if !delta.IsOnCurve() {
+		t.Fatal("expected delta to be on the curve, with the real part first")
+	}
+}
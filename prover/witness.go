@@ -0,0 +1,146 @@
+package prover
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/backend/witness"
+)
+
+// Witness is the assignment of a batch to the circuit of a proving system,
+// ready to be proven by groth16.Prove with the same keys.
+type Witness struct {
+	Curve ecc.ID
+	// Full is the witness passed to groth16.Prove, Public the part of it
+	// passed to groth16.Verify.
+	Full   witness.Witness
+	Public witness.Witness
+}
+
+// WitnessJSON is the JSON encoding of a Witness, holding the gnark binary
+// encoding of both witnesses.
+type WitnessJSON struct {
+	Curve         string `json:"curve"`
+	Witness       []byte `json:"witness"`
+	PublicWitness []byte `json:"publicWitness"`
+}
+
+func (w *Witness) MarshalJSON() ([]byte, error) {
+	full, err := w.Full.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	public, err := w.Public.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(WitnessJSON{Curve: w.Curve.String(), Witness: full, PublicWitness: public})
+}
+
+func (w *Witness) UnmarshalJSON(data []byte) error {
+	var witnessJson WitnessJSON
+	if err := json.Unmarshal(data, &witnessJson); err != nil {
+		return err
+	}
+	curve, err := ParseCurve(witnessJson.Curve)
+	if err != nil {
+		return err
+	}
+	full, err := witness.New(curve.ScalarField())
+	if err != nil {
+		return err
+	}
+	if err = full.UnmarshalBinary(witnessJson.Witness); err != nil {
+		return fmt.Errorf("invalid witness: %w", err)
+	}
+	public, err := witness.New(curve.ScalarField())
+	if err != nil {
+		return err
+	}
+	if err = public.UnmarshalBinary(witnessJson.PublicWitness); err != nil {
+		return fmt.Errorf("invalid public witness: %w", err)
+	}
+	w.Curve, w.Full, w.Public = curve, full, public
+	return nil
+}
+
+// BuildWitness validates params and assigns them to the circuit without
+// proving, so that the proof can be generated by another process holding the
+// same keys. Unlike Prove, which only does so when proving fails, it checks
+// that the Merkle proofs chain from the pre root to the post root upfront, as
+// the witness is not solved here.
+func (ps *ProvingSystem) BuildWitness(params *Parameters) (*Witness, error) {
+	full, err := ps.buildWitness(params)
+	if err != nil {
+		return nil, err
+	}
+	if err = ps.checkRoots(params); err != nil {
+		return nil, err
+	}
+	public, err := full.Public()
+	if err != nil {
+		return nil, &WitnessError{Err: err}
+	}
+	return &Witness{Curve: ps.Curve, Full: full, Public: public}, nil
+}
+
+func (ps *ProvingSystem) buildWitness(params *Parameters) (witness.Witness, error) {
+	return ps.buildWitnessInto(params, new(witnessBuffers))
+}
+
+// buildWitnessInto validates params and assigns them to a witness reusing
+// buffers, which hold on to params until they are reset.
+func (ps *ProvingSystem) buildWitnessInto(params *Parameters, buffers *witnessBuffers) (witness.Witness, error) {
+	workers := ps.witnessWorkers()
+	if err := ps.validateParameters(params, workers); err != nil {
+		return nil, err
+	}
+	if err := ps.checkInputHash(params); err != nil {
+		return nil, err
+	}
+	assignment := buffers.assign(ps, params)
+	witness, err := buffers.fill(ps.circuitOptions().wrap(assignment, &params.PostRoot), ps.Curve.ScalarField(), workers)
+	if err != nil {
+		return nil, &WitnessError{Err: err}
+	}
+	return witness, nil
+}
+
+// validateParameters checks that params fit the circuit of the proving
+// system, expanding their frontier first.
+func (ps *ProvingSystem) validateParameters(params *Parameters, workers int) error {
+	if err := ps.ExpandFrontier(params); err != nil {
+		return err
+	}
+	if err := params.ValidateShape(ps.TreeDepth, ps.BatchSize); err != nil {
+		return err
+	}
+	if (len(params.Indices) != 0) != ps.Indexed {
+		return &IndicesError{Indexed: ps.Indexed}
+	}
+	if err := params.ValidateCapacity(ps.TreeDepth); err != nil {
+		return err
+	}
+	if err := params.validateFieldElements(ps.Curve.ScalarField(), workers); err != nil {
+		return err
+	}
+	if params.EmptyLeaf.Cmp(&ps.EmptyLeaf) != 0 {
+		return &EmptyLeafError{Expected: ps.EmptyLeaf, Actual: params.EmptyLeaf}
+	}
+	return nil
+}
+
+// ProveWitness proves a witness built by BuildWitness, possibly by another
+// process holding the same keys.
+func (ps *ProvingSystem) ProveWitness(w *Witness) (*Proof, error) {
+	if w.Curve != ps.Curve {
+		return nil, fmt.Errorf("witness is on curve %s, the proving system uses %s", w.Curve, ps.Curve)
+	}
+	proof, err := groth16.Prove(ps.ConstraintSystem, ps.ProvingKey, w.Full, ps.ProverOptions...)
+	if err != nil {
+		return nil, &WitnessError{Err: err}
+	}
+	return &Proof{proof}, nil
+}
@@ -0,0 +1,197 @@
+package prover
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+
+	fr_bls12377 "github.com/consensys/gnark-crypto/ecc/bls12-377/fr"
+	fr_bls12381 "github.com/consensys/gnark-crypto/ecc/bls12-381/fr"
+	fr_bls24315 "github.com/consensys/gnark-crypto/ecc/bls24-315/fr"
+	fr_bls24317 "github.com/consensys/gnark-crypto/ecc/bls24-317/fr"
+	fr_bn254 "github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	fr_bw6633 "github.com/consensys/gnark-crypto/ecc/bw6-633/fr"
+	fr_bw6761 "github.com/consensys/gnark-crypto/ecc/bw6-761/fr"
+	"github.com/consensys/gnark/backend/witness"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/schema"
+)
+
+var variableType = reflect.TypeOf((*frontend.Variable)(nil)).Elem()
+
+// witnessBuffers are the assignment and witness of a proof, which Prove
+// reuses across proofs so that sustained batches do not allocate them for
+// every proof. The assignment points into the parameters instead of copying
+// them.
+type witnessBuffers struct {
+	idComms []frontend.Variable
+	indices []frontend.Variable
+	proofs  [][]frontend.Variable
+	full    witness.Witness
+	// values are the leaves of the assignment, public ones first.
+	values []any
+	// nbPublic and nbSecret are the shape full was last filled with.
+	nbPublic, nbSecret int
+}
+
+// witnessBuffers takes buffers from the pool of the proving system.
+func (ps *ProvingSystem) witnessBuffers() *witnessBuffers {
+	if buffers, ok := ps.buffers.Get().(*witnessBuffers); ok {
+		return buffers
+	}
+	return new(witnessBuffers)
+}
+
+// releaseWitnessBuffers returns buffers to the pool once their witness is no
+// longer used.
+func (ps *ProvingSystem) releaseWitnessBuffers(buffers *witnessBuffers) {
+	buffers.reset()
+	ps.buffers.Put(buffers)
+}
+
+// assign returns the assignment of params, reusing the slices of buffers if
+// they have the right shape.
+func (b *witnessBuffers) assign(ps *ProvingSystem, params *Parameters) MbuCircuit {
+	if len(b.idComms) != int(ps.BatchSize) {
+		b.idComms = make([]frontend.Variable, ps.BatchSize)
+	}
+	if len(b.proofs) != int(ps.BatchSize) || (len(b.proofs) > 0 && len(b.proofs[0]) != int(ps.TreeDepth)) {
+		b.proofs = make([][]frontend.Variable, ps.BatchSize)
+		for i := range b.proofs {
+			b.proofs[i] = make([]frontend.Variable, ps.TreeDepth)
+		}
+	}
+	for i := range b.idComms {
+		b.idComms[i] = &params.IdComms[i]
+	}
+	for i := range b.proofs {
+		for j := range b.proofs[i] {
+			b.proofs[i][j] = &params.MerkleProofs[i][j]
+		}
+	}
+	var indices []frontend.Variable
+	if ps.Indexed {
+		if len(b.indices) != int(ps.BatchSize) {
+			b.indices = make([]frontend.Variable, ps.BatchSize)
+		}
+		for i := range b.indices {
+			b.indices[i] = params.Indices[i]
+		}
+		indices = b.indices
+	}
+	return MbuCircuit{
+		InputHash:    &params.InputHash,
+		StartIndex:   params.StartIndex,
+		PreRoot:      &params.PreRoot,
+		PostRoot:     &params.PostRoot,
+		IdComms:      b.idComms,
+		Indices:      indices,
+		MerkleProofs: b.proofs,
+	}
+}
+
+// fill assigns the witness like frontend.NewWitness, reusing the witness
+// vector of earlier proofs. The values are converted to field elements by up
+// to workers goroutines.
+func (b *witnessBuffers) fill(assignment frontend.Circuit, field *big.Int, workers int) (witness.Witness, error) {
+	s, err := schema.Walk(assignment, variableType, nil)
+	if err != nil {
+		return nil, err
+	}
+	if b.full == nil {
+		if b.full, err = witness.New(field); err != nil {
+			return nil, err
+		}
+	}
+	b.values = b.values[:0]
+	for _, visibility := range []schema.Visibility{schema.Public, schema.Secret} {
+		schema.Walk(assignment, variableType, func(leaf schema.LeafInfo, value reflect.Value) error {
+			if leaf.Visibility == visibility {
+				b.values = append(b.values, value.Interface())
+			}
+			return nil
+		})
+	}
+	if b.nbPublic != s.Public || b.nbSecret != s.Secret {
+		// Only Fill sizes the vector and records the number of public
+		// inputs, so the vector is first filled with zeros.
+		b.nbPublic, b.nbSecret = 0, 0
+		zeros := make(chan any)
+		go func() {
+			defer close(zeros)
+			for range b.values {
+				zeros <- 0
+			}
+		}()
+		if err = b.full.Fill(s.Public, s.Secret, zeros); err != nil {
+			return nil, err
+		}
+		b.nbPublic, b.nbSecret = s.Public, s.Secret
+	}
+	if err = setElements(b.full.Vector(), b.values, workers); err != nil {
+		return nil, err
+	}
+	return b.full, nil
+}
+
+// setElements sets the elements of a witness vector to values.
+func setElements(vector any, values []any, workers int) error {
+	switch v := vector.(type) {
+	case fr_bn254.Vector:
+		return setElementsParallel(v, values, workers)
+	case fr_bls12377.Vector:
+		return setElementsParallel(v, values, workers)
+	case fr_bls12381.Vector:
+		return setElementsParallel(v, values, workers)
+	case fr_bls24315.Vector:
+		return setElementsParallel(v, values, workers)
+	case fr_bls24317.Vector:
+		return setElementsParallel(v, values, workers)
+	case fr_bw6761.Vector:
+		return setElementsParallel(v, values, workers)
+	case fr_bw6633.Vector:
+		return setElementsParallel(v, values, workers)
+	default:
+		return fmt.Errorf("unsupported witness vector %T", vector)
+	}
+}
+
+// setElementsParallel sets vector to values with up to workers goroutines,
+// returning the error of the first invalid value.
+func setElementsParallel[E any, P interface {
+	*E
+	SetInterface(any) (*E, error)
+}](vector []E, values []any, workers int) error {
+	if len(vector) != len(values) {
+		return fmt.Errorf("expected %d values, got %d", len(vector), len(values))
+	}
+	errs := make([]error, len(values))
+	parallelize(len(values), workers, minParallelWork, func(start, end int) {
+		for i := start; i < end; i++ {
+			if _, errs[i] = P(&vector[i]).SetInterface(values[i]); errs[i] != nil {
+				return
+			}
+		}
+	})
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reset drops the references to the parameters of the last proof.
+func (b *witnessBuffers) reset() {
+	for i := range b.idComms {
+		b.idComms[i] = nil
+	}
+	for i := range b.proofs {
+		for j := range b.proofs[i] {
+			b.proofs[i][j] = nil
+		}
+	}
+	for i := range b.values {
+		b.values[i] = nil
+	}
+}
@@ -0,0 +1,100 @@
+package prover
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"worldcoin/gnark-mbu/logging"
+
+	"github.com/consensys/gnark/backend/witness"
+)
+
+// WitnessDump configures the files the witnesses of failed proofs are
+// written to, so that circuit engineers can reproduce the failures offline.
+type WitnessDump struct {
+	// Dir is the directory of the files, the default directory for
+	// temporary files if empty.
+	Dir string
+	// Redact leaves the identity commitments and the private witness out of
+	// the files, keeping the other parameters and the public witness. The
+	// failures are then reproduced with the commitments of another source.
+	Redact bool
+}
+
+// WitnessDumpFile is the JSON content of a witness dump.
+type WitnessDumpFile struct {
+	Circuit        TestVectorCircuit `json:"circuit"`
+	CircuitVersion string            `json:"circuitVersion"`
+	// Error is the error the proof failed with.
+	Error string `json:"error"`
+	// Redacted is set when the identity commitments are zeroed and the
+	// private witness is left out.
+	Redacted   bool        `json:"redacted,omitempty"`
+	Parameters *Parameters `json:"parameters"`
+	// Witness is the gnark binary encoding of the full witness, empty if
+	// redacted.
+	Witness       []byte `json:"witness,omitempty"`
+	PublicWitness []byte `json:"publicWitness"`
+}
+
+// dumpWitness writes the witness of params, whose proof failed with cause,
+// to a new file of the WitnessDump of the proving system, if any, and logs
+// its path. Failing to do so is logged, as the proof failed anyway.
+func (ps *ProvingSystem) dumpWitness(params *Parameters, full witness.Witness, cause error) {
+	if ps.WitnessDump == nil {
+		return
+	}
+	path, err := ps.WitnessDump.write(ps, params, full, cause)
+	if err != nil {
+		logging.Logger().Error().Err(err).Msg("failed to dump the witness of the failed proof")
+		return
+	}
+	logging.Logger().Warn().Str("path", path).Bool("redacted", ps.WitnessDump.Redact).Msg("dumped the witness of the failed proof")
+}
+
+func (dump *WitnessDump) write(ps *ProvingSystem, params *Parameters, full witness.Witness, cause error) (path string, err error) {
+	public, err := full.Public()
+	if err != nil {
+		return "", err
+	}
+	contents := WitnessDumpFile{
+		Circuit:        ps.TestVectorCircuit(),
+		CircuitVersion: CircuitSemver,
+		Error:          cause.Error(),
+		Redacted:       dump.Redact,
+		Parameters:     params,
+	}
+	if contents.PublicWitness, err = public.MarshalBinary(); err != nil {
+		return "", err
+	}
+	if dump.Redact {
+		redacted := *params
+		redacted.IdComms = make([]big.Int, len(params.IdComms))
+		contents.Parameters = &redacted
+	} else if contents.Witness, err = full.MarshalBinary(); err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(&contents, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	file, err := os.CreateTemp(dump.Dir, "witness-*.json")
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if closeErr := file.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(file.Name())
+		}
+	}()
+	// CreateTemp makes the file readable by the owner only, as the
+	// parameters of the batches of a sequencer are not meant to be shared.
+	if _, err = file.Write(data); err != nil {
+		return "", fmt.Errorf("writing %s: %w", file.Name(), err)
+	}
+	return file.Name(), nil
+}
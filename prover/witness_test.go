@@ -0,0 +1,179 @@
+package prover
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark/frontend"
+)
+
+func TestBuildWitness(t *testing.T) {
+	ps := &ProvingSystem{Curve: ecc.BN254, TreeDepth: testTreeDepth, BatchSize: testBatchSize}
+	witness, err := ps.BuildWitness(testParameters())
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := json.Marshal(witness)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Witness
+	if err = json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	expected, _ := witness.Full.MarshalBinary()
+	actual, _ := decoded.Full.MarshalBinary()
+	expectedPublic, _ := witness.Public.MarshalBinary()
+	actualPublic, _ := decoded.Public.MarshalBinary()
+	if decoded.Curve != ecc.BN254 || !bytes.Equal(expected, actual) || !bytes.Equal(expectedPublic, actualPublic) {
+		t.Fatal("expected the witness to survive a round trip")
+	}
+
+	params := testParameters()
+	params.PostRoot.SetInt64(1)
+	if err = params.ComputeInputHash(); err != nil {
+		t.Fatal(err)
+	}
+	var rootMismatch *RootMismatchError
+	if _, err = ps.BuildWitness(params); !errors.As(err, &rootMismatch) {
+		t.Fatalf("expected a root mismatch, got %v", err)
+	}
+}
+
+func TestBuildWitnessIndexed(t *testing.T) {
+	ps := &ProvingSystem{Curve: ecc.BN254, TreeDepth: testTreeDepth, BatchSize: testBatchSize, Indexed: true}
+	params := indexedParameters()
+	actual, err := ps.BuildWitness(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := frontend.NewWitness(ps.circuitOptions().wrap(testAssignment(params), nil), ecc.BN254.ScalarField())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedBytes, _ := expected.MarshalBinary()
+	actualBytes, _ := actual.Full.MarshalBinary()
+	if !bytes.Equal(expectedBytes, actualBytes) {
+		t.Fatal("expected the witness to assign the indices")
+	}
+
+	var indices *IndicesError
+	if _, err = ps.BuildWitness(testParameters()); !errors.As(err, &indices) {
+		t.Fatalf("expected missing indices to be rejected, got %v", err)
+	}
+	ps.Indexed = false
+	if _, err = ps.BuildWitness(indexedParameters()); !errors.As(err, &indices) {
+		t.Fatalf("expected unexpected indices to be rejected, got %v", err)
+	}
+}
+
+func TestWitnessBuffersReuse(t *testing.T) {
+	ps := &ProvingSystem{Curve: ecc.BN254, TreeDepth: testTreeDepth, BatchSize: testBatchSize}
+	other := testParameters()
+	other.IdComms[1].SetUint64(3)
+	if err := other.ComputeInputHash(); err != nil {
+		t.Fatal(err)
+	}
+	buffers := ps.witnessBuffers()
+	for _, params := range []*Parameters{testParameters(), other} {
+		expected, err := frontend.NewWitness(ps.circuitOptions().wrap(testAssignment(params), params.PostRoot), ecc.BN254.ScalarField())
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := ps.buildWitnessInto(params, buffers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectedBytes, _ := expected.MarshalBinary()
+		actualBytes, _ := actual.MarshalBinary()
+		if !bytes.Equal(expectedBytes, actualBytes) {
+			t.Fatal("expected the pooled witness to match frontend.NewWitness")
+		}
+		buffers.reset()
+		if buffers.idComms[0] != nil {
+			t.Fatal("expected reset buffers not to reference the parameters")
+		}
+	}
+}
+
+func TestSetElementsParallel(t *testing.T) {
+	values := make([]any, 3*minParallelWork+1)
+	for i := range values {
+		values[i] = new(big.Int).SetUint64(uint64(i))
+	}
+	expected := make(fr.Vector, len(values))
+	if err := setElements(expected, values, 1); err != nil {
+		t.Fatal(err)
+	}
+	actual := make(fr.Vector, len(values))
+	if err := setElements(actual, values, 4); err != nil {
+		t.Fatal(err)
+	}
+	for i := range expected {
+		if !expected[i].Equal(&actual[i]) {
+			t.Fatalf("element %d: expected %s, got %s", i, expected[i].String(), actual[i].String())
+		}
+	}
+	values[2*minParallelWork] = "invalid"
+	values[3*minParallelWork] = "invalid"
+	if err := setElements(actual, values, 4); err == nil {
+		t.Fatal("expected an invalid value to fail")
+	}
+}
+
+func TestWitnessDump(t *testing.T) {
+	ps := &ProvingSystem{Curve: ecc.BN254, TreeDepth: testTreeDepth, BatchSize: testBatchSize, WitnessDump: &WitnessDump{Dir: t.TempDir()}}
+	params := testParameters()
+	params.PostRoot.SetInt64(1)
+	params.InputHash.SetInt64(0)
+	full, err := ps.buildWitness(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := func(path string) *WitnessDumpFile {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var dump WitnessDumpFile
+		if err = json.Unmarshal(data, &dump); err != nil {
+			t.Fatal(err)
+		}
+		return &dump
+	}
+
+	path, err := ps.WitnessDump.write(ps, params, full, errors.New("constraint not satisfied"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 || filepath.Dir(path) != ps.WitnessDump.Dir {
+		t.Fatalf("expected a private file in the dump directory, got %s: %v", path, err)
+	}
+	dump := read(path)
+	expected, _ := full.MarshalBinary()
+	if dump.Error != "constraint not satisfied" || dump.Redacted || !bytes.Equal(dump.Witness, expected) || dump.Circuit != ps.TestVectorCircuit() {
+		t.Fatalf("unexpected dump %+v", dump)
+	}
+	if dump.Parameters.PostRoot.Int64() != 1 || dump.Parameters.IdComms[0].Cmp(&params.IdComms[0]) != 0 {
+		t.Fatal("expected the parameters to be dumped")
+	}
+
+	ps.WitnessDump.Redact = true
+	if path, err = ps.WitnessDump.write(ps, params, full, errors.New("constraint not satisfied")); err != nil {
+		t.Fatal(err)
+	}
+	dump = read(path)
+	if !dump.Redacted || len(dump.Witness) != 0 || len(dump.PublicWitness) == 0 || dump.Parameters.IdComms[0].Sign() != 0 {
+		t.Fatalf("expected the identity commitments and private witness to be redacted, got %+v", dump)
+	}
+	if params.IdComms[0].Sign() == 0 {
+		t.Fatal("expected the parameters not to be modified")
+	}
+}
@@ -0,0 +1,111 @@
+// Package replay records the proof requests a server receives to an NDJSON
+// file and replays them against a server at a chosen concurrency and rate,
+// reporting the latencies of its responses, so that load tests use the
+// traffic of production.
+package replay
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// recordedHeaders are the only headers kept in recorded requests. The
+// others, such as the API key, signature and idempotency key of requests,
+// are dropped: credentials must not end up in replay files, and replayed
+// requests would hit the proof cache of the server with the idempotency key
+// of the recorded ones.
+var recordedHeaders = []string{"Content-Type", "Content-Encoding", "Accept", "X-Priority"}
+
+// droppedQueryParameters are the query parameters dropped from recorded
+// requests, so that replays do not call the callbacks of the recorded ones.
+var droppedQueryParameters = []string{"callback_url"}
+
+// Request is a recorded request, a line of a replay file.
+type Request struct {
+	// Time is the time the request was received.
+	Time   time.Time `json:"time"`
+	Method string    `json:"method"`
+	// Path is the path of the request, with its query.
+	Path   string            `json:"path"`
+	Header map[string]string `json:"header,omitempty"`
+	// Body is the body as sent, still compressed if it was.
+	Body []byte `json:"body,omitempty"`
+}
+
+// NewRequest returns the sanitized record of r, whose body is body.
+func NewRequest(r *http.Request, body []byte, received time.Time) *Request {
+	query := r.URL.Query()
+	for _, name := range droppedQueryParameters {
+		query.Del(name)
+	}
+	path := (&url.URL{Path: r.URL.Path, RawQuery: query.Encode()}).RequestURI()
+	header := make(map[string]string)
+	for _, name := range recordedHeaders {
+		if value := r.Header.Get(name); value != "" {
+			header[name] = value
+		}
+	}
+	return &Request{Time: received.UTC(), Method: r.Method, Path: path, Header: header, Body: body}
+}
+
+// Recorder appends requests to a replay file. It is safe for concurrent
+// use.
+type Recorder struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewRecorder opens the replay file at path, creating it if needed, to
+// append requests to it.
+func NewRecorder(path string) (*Recorder, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{file: file}, nil
+}
+
+// Record appends request to the file.
+func (recorder *Recorder) Record(request *Request) error {
+	line, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	_, err = recorder.file.Write(append(line, '\n'))
+	return err
+}
+
+// Close closes the file.
+func (recorder *Recorder) Close() error {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	return recorder.file.Close()
+}
+
+// ReadRequests reads the requests of a replay file, skipping blank lines.
+func ReadRequests(r io.Reader) ([]Request, error) {
+	var requests []Request
+	scanner := bufio.NewScanner(r)
+	// Lines hold whole batches of Merkle proofs.
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<30)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var request Request
+		if err := json.Unmarshal(scanner.Bytes(), &request); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		requests = append(requests, request)
+	}
+	return requests, scanner.Err()
+}
@@ -0,0 +1,205 @@
+package replay
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Options configure a replay.
+type Options struct {
+	// Target is the base URL of the server the requests are sent to, such
+	// as http://localhost:3001.
+	Target string
+	// Concurrency is the number of requests in flight at once, one if zero.
+	Concurrency int
+	// QPS bounds the rate requests are sent at. Zero sends them as fast as
+	// Concurrency allows.
+	QPS float64
+	// Count is the number of requests sent, cycling through the recorded
+	// ones. Zero sends each of them once.
+	Count int
+	// Header is added to every request, such as the API key the recorded
+	// requests were stripped of.
+	Header http.Header
+	// Client sends the requests, http.DefaultClient if nil.
+	Client *http.Client
+}
+
+// Report summarizes a replay. Latencies are those of the requests answered
+// with a 2xx status, from the time they are sent to the end of their
+// response.
+type Report struct {
+	Requests int
+	// Failures is the number of requests failing to be sent or answered
+	// with another status.
+	Failures int
+	// Statuses counts the responses by status, 0 counting the requests
+	// that failed to be sent.
+	Statuses map[int]int
+	Elapsed  time.Duration
+	// Throughput is the number of requests answered per second.
+	Throughput float64
+	Latencies  Latencies
+}
+
+// Latencies are the percentiles of a set of latencies.
+type Latencies struct {
+	Mean, P50, P90, P95, P99, Max time.Duration
+}
+
+// newLatencies computes the percentiles of latencies, which it sorts.
+func newLatencies(latencies []time.Duration) Latencies {
+	if len(latencies) == 0 {
+		return Latencies{}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	var total time.Duration
+	for _, latency := range latencies {
+		total += latency
+	}
+	return Latencies{
+		Mean: total / time.Duration(len(latencies)),
+		P50:  Percentile(latencies, 50),
+		P90:  Percentile(latencies, 90),
+		P95:  Percentile(latencies, 95),
+		P99:  Percentile(latencies, 99),
+		Max:  latencies[len(latencies)-1],
+	}
+}
+
+// Percentile returns the p-th percentile of the sorted latencies, by the
+// nearest-rank method.
+func Percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(sorted) {
+		rank = len(sorted)
+	}
+	return sorted[rank-1]
+}
+
+// result is the outcome of a replayed request.
+type result struct {
+	status  int
+	latency time.Duration
+}
+
+// Run replays requests against opts.Target until they are all sent or ctx
+// is done. Requests are sent in order, but answered in any order when
+// several are in flight. Once Concurrency requests are in flight the next
+// one waits, so that the rate achieved may stay below QPS.
+func Run(ctx context.Context, requests []Request, opts Options) (*Report, error) {
+	if len(requests) == 0 {
+		return nil, fmt.Errorf("no requests to replay")
+	}
+	target := strings.TrimSuffix(opts.Target, "/")
+	client := opts.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	concurrency := opts.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	count := opts.Count
+	if count <= 0 {
+		count = len(requests)
+	}
+
+	pending := make(chan *Request)
+	results := make(chan result, concurrency)
+	var workers sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for request := range pending {
+				results <- send(ctx, client, target, request, opts.Header)
+			}
+		}()
+	}
+	start := time.Now()
+	go func() {
+		defer close(pending)
+		var tick <-chan time.Time
+		if opts.QPS > 0 {
+			ticker := time.NewTicker(time.Duration(float64(time.Second) / opts.QPS))
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+		for i := 0; i < count; i++ {
+			if tick != nil && i > 0 {
+				select {
+				case <-tick:
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case pending <- &requests[i%len(requests)]:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		workers.Wait()
+		close(results)
+	}()
+
+	report := &Report{Statuses: make(map[int]int)}
+	var latencies []time.Duration
+	for res := range results {
+		report.Requests++
+		report.Statuses[res.status]++
+		if res.status < 200 || res.status > 299 {
+			report.Failures++
+			continue
+		}
+		latencies = append(latencies, res.latency)
+	}
+	report.Elapsed = time.Since(start)
+	if report.Elapsed > 0 {
+		report.Throughput = float64(len(latencies)) / report.Elapsed.Seconds()
+	}
+	report.Latencies = newLatencies(latencies)
+	return report, ctx.Err()
+}
+
+// send sends request to target and reads its response, returning a zero
+// status if it fails.
+func send(ctx context.Context, client *http.Client, target string, request *Request, header http.Header) result {
+	r, err := http.NewRequestWithContext(ctx, request.Method, target+request.Path, bytes.NewReader(request.Body))
+	if err != nil {
+		return result{}
+	}
+	for name, value := range request.Header {
+		r.Header.Set(name, value)
+	}
+	for name, values := range header {
+		r.Header[name] = values
+	}
+	start := time.Now()
+	response, err := client.Do(r)
+	if err != nil {
+		return result{}
+	}
+	defer response.Body.Close()
+	if _, err = io.Copy(io.Discard, response.Body); err != nil {
+		return result{}
+	}
+	return result{status: response.StatusCode, latency: time.Since(start)}
+}
@@ -0,0 +1,112 @@
+package replay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	var latencies []time.Duration
+	for i := 1; i <= 100; i++ {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+	for _, test := range []struct {
+		p        float64
+		expected time.Duration
+	}{{50, 50 * time.Millisecond}, {99, 99 * time.Millisecond}, {99.5, 100 * time.Millisecond}, {0, time.Millisecond}} {
+		if percentile := Percentile(latencies, test.p); percentile != test.expected {
+			t.Errorf("expected the p%v of 1..100ms to be %s, got %s", test.p, test.expected, percentile)
+		}
+	}
+	if percentile := Percentile(nil, 50); percentile != 0 {
+		t.Errorf("expected no latencies to have a zero percentile, got %s", percentile)
+	}
+}
+
+func TestReadRequests(t *testing.T) {
+	var buf bytes.Buffer
+	for _, path := range []string{"/prove", "/prove_batch"} {
+		line, err := json.Marshal(&Request{Method: http.MethodPost, Path: path, Body: []byte("{}")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf.Write(line)
+		buf.WriteString("\n\n")
+	}
+	requests, err := ReadRequests(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 2 || requests[1].Path != "/prove_batch" || string(requests[1].Body) != "{}" {
+		t.Fatalf("unexpected requests %+v", requests)
+	}
+	if _, err = ReadRequests(bytes.NewBufferString("{\"method\": \"POST\"}\nnot json\n")); err == nil {
+		t.Fatal("expected a malformed line to fail")
+	}
+}
+
+func TestRun(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		bodies   []string
+		inFlight atomic.Int32
+		peak     atomic.Int32
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n := inFlight.Add(1); n > peak.Load() {
+			peak.Store(n)
+		}
+		defer inFlight.Add(-1)
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(body))
+		mu.Unlock()
+		if r.Header.Get("X-API-Key") != "sequencer-key" || r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	requests := []Request{
+		{Method: http.MethodPost, Path: "/prove", Header: map[string]string{"Content-Type": "application/json"}, Body: []byte("a")},
+		{Method: http.MethodPost, Path: "/fail", Header: map[string]string{"Content-Type": "application/json"}, Body: []byte("b")},
+	}
+	report, err := Run(context.Background(), requests, Options{
+		Target:      server.URL + "/",
+		Concurrency: 2,
+		Count:       6,
+		Header:      http.Header{"X-Api-Key": {"sequencer-key"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Requests != 6 || report.Failures != 3 || report.Statuses[http.StatusOK] != 3 || report.Statuses[http.StatusServiceUnavailable] != 3 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if report.Latencies.P50 < 10*time.Millisecond || report.Latencies.Max < report.Latencies.P50 || report.Throughput <= 0 {
+		t.Fatalf("unexpected latencies %+v", report.Latencies)
+	}
+	if len(bodies) != 6 || peak.Load() > 2 {
+		t.Fatalf("expected 6 requests with at most 2 in flight, got %d with %d", len(bodies), peak.Load())
+	}
+
+	start := time.Now()
+	if _, err = Run(context.Background(), requests[:1], Options{Target: server.URL, QPS: 20, Count: 3, Header: http.Header{"X-Api-Key": {"sequencer-key"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Fatalf("expected 3 requests at 20 QPS to take at least 100ms, took %s", elapsed)
+	}
+}
@@ -0,0 +1,88 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"worldcoin/gnark-mbu/aws"
+)
+
+// SecretsManager reads secrets from AWS Secrets Manager. References are the
+// name or ARN of a secret, followed by a # and a field for secrets holding a
+// JSON object, as the console stores key/value secrets.
+type SecretsManager struct {
+	client *aws.JSONClient
+}
+
+// NewSecretsManager returns a Secrets Manager client with the credentials
+// and region of the environment.
+func NewSecretsManager() (*SecretsManager, error) {
+	client, err := aws.NewJSONClient("secretsmanager", "secretsmanager", "")
+	if err != nil {
+		return nil, err
+	}
+	return &SecretsManager{client: client}, nil
+}
+
+func (sm *SecretsManager) Secret(ctx context.Context, ref string) ([]byte, error) {
+	id, field := splitField(ref)
+	var response struct {
+		SecretString *string
+		SecretBinary []byte
+	}
+	if err := sm.client.Call(ctx, "GetSecretValue", map[string]string{"SecretId": id}, &response); err != nil {
+		return nil, err
+	}
+	if response.SecretString == nil {
+		if field != "" {
+			return nil, fmt.Errorf("the secret is binary, it has no field %s", field)
+		}
+		return response.SecretBinary, nil
+	}
+	if field == "" {
+		return []byte(*response.SecretString), nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(*response.SecretString), &fields); err != nil {
+		return nil, fmt.Errorf("the secret is not a JSON object, it has no field %s", field)
+	}
+	return secretField(fields, field)
+}
+
+// KMSFiles decrypts local files of base64 ciphertext, as written by aws kms
+// encrypt, with AWS KMS, so that the plaintext is never stored. References
+// are the paths of the files.
+type KMSFiles struct {
+	client *aws.JSONClient
+}
+
+// NewKMSFiles returns a KMS client with the credentials and region of the
+// environment.
+func NewKMSFiles() (*KMSFiles, error) {
+	client, err := aws.NewJSONClient("kms", "TrentService", "")
+	if err != nil {
+		return nil, err
+	}
+	return &KMSFiles{client: client}, nil
+}
+
+func (k *KMSFiles) Secret(ctx context.Context, path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data)))
+	if err != nil {
+		return nil, fmt.Errorf("%s is not base64 ciphertext: %w", path, err)
+	}
+	var response struct {
+		Plaintext []byte
+	}
+	if err = k.client.Call(ctx, "Decrypt", map[string][]byte{"CiphertextBlob": ciphertext}, &response); err != nil {
+		return nil, err
+	}
+	return response.Plaintext, nil
+}
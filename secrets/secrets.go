@@ -0,0 +1,127 @@
+// Package secrets reads the secrets of the prover, such as API keys, tokens
+// and signing keys, from a secrets manager rather than from files on disk.
+// Secrets are named by references whose scheme selects the provider:
+//
+//	vault://<path>#<field>           a field of a HashiCorp Vault secret
+//	awssm://<secret id>[#<field>]    an AWS Secrets Manager secret, or a field of its JSON
+//	awskms+file://<path>             a local file of base64 ciphertext decrypted by AWS KMS
+//	<path>                           a local file
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Provider reads the secrets of a secrets manager.
+type Provider interface {
+	// Secret returns the secret ref names. The providers of a Resolver
+	// are given the references without their scheme.
+	Secret(ctx context.Context, ref string) ([]byte, error)
+}
+
+// ProviderFunc adapts a function to a Provider.
+type ProviderFunc func(ctx context.Context, ref string) ([]byte, error)
+
+func (f ProviderFunc) Secret(ctx context.Context, ref string) ([]byte, error) {
+	return f(ctx, ref)
+}
+
+// Resolver reads secret references with the provider of their scheme, and
+// references without a scheme as local files. It is safe for concurrent
+// use.
+type Resolver struct {
+	mu        sync.Mutex
+	providers map[string]func() (Provider, error)
+	opened    map[string]Provider
+}
+
+// NewResolver returns a resolver of the vault, awssm and awskms+file
+// schemes, whose providers are configured from the environment when first
+// used: VAULT_ADDR, VAULT_TOKEN and VAULT_NAMESPACE for Vault, the standard
+// AWS variables for AWS.
+func NewResolver() *Resolver {
+	r := &Resolver{providers: make(map[string]func() (Provider, error)), opened: make(map[string]Provider)}
+	r.providers["vault"] = func() (Provider, error) { return NewVault() }
+	r.providers["awssm"] = func() (Provider, error) { return NewSecretsManager() }
+	r.providers["awskms+file"] = func() (Provider, error) { return NewKMSFiles() }
+	return r
+}
+
+// Register serves the references of scheme with provider.
+func (r *Resolver) Register(scheme string, provider Provider) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.providers[scheme] = func() (Provider, error) { return provider, nil }
+	delete(r.opened, scheme)
+}
+
+// split returns the scheme of a reference and the rest of it, or an empty
+// scheme for the references of local files.
+func split(location string) (string, string) {
+	scheme, ref, ok := strings.Cut(location, "://")
+	if !ok || strings.ContainsAny(scheme, "/\\") {
+		return "", location
+	}
+	return scheme, ref
+}
+
+// IsReference reports whether location names a secret of a secrets manager
+// rather than a local file.
+func IsReference(location string) bool {
+	scheme, _ := split(location)
+	return scheme != ""
+}
+
+// provider returns the provider of scheme, opening it on first use.
+func (r *Resolver) provider(scheme string) (Provider, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if provider, ok := r.opened[scheme]; ok {
+		return provider, nil
+	}
+	open, ok := r.providers[scheme]
+	if !ok {
+		return nil, fmt.Errorf("unknown secrets provider %s://", scheme)
+	}
+	provider, err := open()
+	if err != nil {
+		return nil, err
+	}
+	r.opened[scheme] = provider
+	return provider, nil
+}
+
+// Secret returns the secret of location.
+func (r *Resolver) Secret(ctx context.Context, location string) ([]byte, error) {
+	scheme, ref := split(location)
+	if scheme == "" || scheme == "file" {
+		return os.ReadFile(ref)
+	}
+	provider, err := r.provider(scheme)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := provider.Secret(ctx, ref)
+	if err != nil {
+		return nil, fmt.Errorf("reading secret %s: %w", location, err)
+	}
+	return secret, nil
+}
+
+// defaultResolver serves Read.
+var defaultResolver = NewResolver()
+
+// Read returns the secret of location with the providers of NewResolver.
+func Read(ctx context.Context, location string) ([]byte, error) {
+	return defaultResolver.Secret(ctx, location)
+}
+
+// splitField splits ref at its #field, if any.
+func splitField(ref string) (string, string) {
+	path, field, _ := strings.Cut(ref, "#")
+	return path, field
+}
@@ -0,0 +1,141 @@
+package secrets
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolverFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("s3cret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	r := NewResolver()
+	for _, location := range []string{path, "file://" + path} {
+		secret, err := r.Secret(context.Background(), location)
+		if err != nil || string(secret) != "s3cret\n" {
+			t.Fatalf("%s: unexpected secret %q, %v", location, secret, err)
+		}
+	}
+	if _, err := r.Secret(context.Background(), "gcpsm://prover"); err == nil || !strings.Contains(err.Error(), "unknown secrets provider") {
+		t.Fatalf("expected an unknown provider error, got %v", err)
+	}
+	r.Register("test", ProviderFunc(func(ctx context.Context, ref string) ([]byte, error) {
+		return []byte("value of " + ref), nil
+	}))
+	if secret, err := r.Secret(context.Background(), "test://a#b"); err != nil || string(secret) != "value of a#b" {
+		t.Fatalf("unexpected secret %q, %v", secret, err)
+	}
+	if IsReference(path) || !IsReference("vault://secret/data/prover") {
+		t.Fatal("references not told from paths")
+	}
+}
+
+func TestVault(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "root" || r.Header.Get("X-Vault-Namespace") != "team" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors": ["permission denied"]}`))
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/prover":
+			w.Write([]byte(`{"data": {"data": {"adminToken": "admin", "callbackSecret": "hmac"}, "metadata": {"version": 3}}}`))
+		case "/v1/kv/prover":
+			w.Write([]byte(`{"data": {"token": "v1"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors": []}`))
+		}
+	}))
+	defer server.Close()
+	t.Setenv("VAULT_ADDR", server.URL)
+	t.Setenv("VAULT_TOKEN", "root")
+	t.Setenv("VAULT_NAMESPACE", "team")
+	r := NewResolver()
+	for location, expected := range map[string]string{
+		"vault://secret/data/prover#adminToken":     "admin",
+		"vault://secret/data/prover#callbackSecret": "hmac",
+		"vault://kv/prover":                         "v1",
+	} {
+		secret, err := r.Secret(context.Background(), location)
+		if err != nil || string(secret) != expected {
+			t.Fatalf("%s: expected %s, got %q, %v", location, expected, secret, err)
+		}
+	}
+	for _, location := range []string{"vault://secret/data/prover", "vault://secret/data/prover#missing", "vault://secret/data/other"} {
+		if _, err := r.Secret(context.Background(), location); err == nil {
+			t.Fatalf("%s: expected an error", location)
+		}
+	}
+}
+
+// awsServer answers the actions of Secrets Manager and KMS.
+func awsServer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request map[string]string
+		json.NewDecoder(r.Body).Decode(&request)
+		switch r.Header.Get("X-Amz-Target") {
+		case "secretsmanager.GetSecretValue":
+			switch request["SecretId"] {
+			case "prover/token":
+				w.Write([]byte(`{"SecretString": "token"}`))
+			case "prover/config":
+				w.Write([]byte(`{"SecretString": "{\"apiKey\": \"key\"}"}`))
+			case "prover/binary":
+				w.Write([]byte(`{"SecretBinary": "` + base64.StdEncoding.EncodeToString([]byte{1, 2}) + `"}`))
+			default:
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"__type": "ResourceNotFoundException", "Message": "not found"}`))
+			}
+		case "TrentService.Decrypt":
+			ciphertext, _ := base64.StdEncoding.DecodeString(request["CiphertextBlob"])
+			w.Write([]byte(`{"Plaintext": "` + base64.StdEncoding.EncodeToString([]byte(strings.ToUpper(string(ciphertext)))) + `"}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv("AWS_ENDPOINT_URL", server.URL)
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+}
+
+func TestSecretsManager(t *testing.T) {
+	awsServer(t)
+	r := NewResolver()
+	for location, expected := range map[string]string{
+		"awssm://prover/token":         "token",
+		"awssm://prover/config#apiKey": "key",
+		"awssm://prover/binary":        "\x01\x02",
+	} {
+		secret, err := r.Secret(context.Background(), location)
+		if err != nil || string(secret) != expected {
+			t.Fatalf("%s: expected %q, got %q, %v", location, expected, secret, err)
+		}
+	}
+	_, err := r.Secret(context.Background(), "awssm://prover/missing")
+	if err == nil || !strings.Contains(err.Error(), "ResourceNotFoundException") {
+		t.Fatalf("expected the error of Secrets Manager, got %v", err)
+	}
+	if _, err = r.Secret(context.Background(), "awssm://prover/token#apiKey"); err == nil {
+		t.Fatal("expected an error for a field of a plain secret")
+	}
+}
+
+func TestKMSFiles(t *testing.T) {
+	awsServer(t)
+	path := filepath.Join(t.TempDir(), "token.enc")
+	if err := os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString([]byte("sealed"))+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	secret, err := NewResolver().Secret(context.Background(), "awskms+file://"+path)
+	if err != nil || string(secret) != "SEALED" {
+		t.Fatalf("unexpected plaintext %q, %v", secret, err)
+	}
+}
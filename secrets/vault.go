@@ -0,0 +1,115 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Vault reads secrets from the HashiCorp Vault HTTP API. References are the
+// API path of a secret, such as secret/data/prover for the prover secret of
+// a KV version 2 engine mounted at secret, and the field of the secret after
+// a #, which may be left out for secrets of a single field.
+type Vault struct {
+	Address   string
+	Token     string
+	Namespace string
+	Client    *http.Client
+}
+
+// NewVault returns a Vault client of VAULT_ADDR, authenticated with
+// VAULT_TOKEN in VAULT_NAMESPACE.
+func NewVault() (*Vault, error) {
+	address := os.Getenv("VAULT_ADDR")
+	if address == "" {
+		return nil, fmt.Errorf("vault secrets need VAULT_ADDR")
+	}
+	token := os.Getenv("VAULT_TOKEN")
+	if token == "" {
+		return nil, fmt.Errorf("vault secrets need VAULT_TOKEN")
+	}
+	return &Vault{
+		Address:   strings.TrimSuffix(address, "/"),
+		Token:     token,
+		Namespace: os.Getenv("VAULT_NAMESPACE"),
+		Client:    &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+func (v *Vault) Secret(ctx context.Context, ref string) ([]byte, error) {
+	path, field := splitField(ref)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.Address+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", v.Token)
+	if v.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.Namespace)
+	}
+	resp, err := v.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var failure struct {
+			Errors []string `json:"errors"`
+		}
+		json.Unmarshal(body, &failure)
+		return nil, fmt.Errorf("vault answered %d: %s", resp.StatusCode, strings.Join(failure.Errors, ", "))
+	}
+	var secret struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err = json.Unmarshal(body, &secret); err != nil {
+		return nil, err
+	}
+	data := secret.Data
+	// KV version 2 nests the fields under data, next to the metadata.
+	if nested, ok := data["data"]; ok {
+		if _, versioned := data["metadata"]; versioned {
+			data = nil
+			if err = json.Unmarshal(nested, &data); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return secretField(data, field)
+}
+
+// secretField returns field of a secret of several fields as a string, or
+// its only field if field is empty.
+func secretField(fields map[string]json.RawMessage, field string) ([]byte, error) {
+	if field == "" {
+		if len(fields) != 1 {
+			names := make([]string, 0, len(fields))
+			for name := range fields {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("the secret has the fields %s, name one after #", strings.Join(names, ", "))
+		}
+		for name := range fields {
+			field = name
+		}
+	}
+	raw, ok := fields[field]
+	if !ok {
+		return nil, fmt.Errorf("the secret has no field %s", field)
+	}
+	var value string
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return nil, fmt.Errorf("field %s of the secret is not a string", field)
+	}
+	return []byte(value), nil
+}
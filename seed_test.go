@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+)
+
+// seedMu serializes the calls to withSeed, which replace a global.
+var seedMu sync.Mutex
+
+// seedStream is the SHA-256 of the seed and a counter, in counter mode.
+type seedStream struct {
+	seed    []byte
+	counter uint64
+	block   []byte
+}
+
+func (s *seedStream) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(s.block) == 0 {
+			h := sha256.New()
+			h.Write(s.seed)
+			binary.Write(h, binary.BigEndian, s.counter)
+			s.counter++
+			s.block = h.Sum(nil)
+		}
+		copied := copy(p[n:], s.block)
+		s.block = s.block[copied:]
+		n += copied
+	}
+	return n, nil
+}
+
+// withSeed runs fn with crypto/rand.Reader reading a stream derived from
+// seed, and returns its error. gnark samples the toxic waste of setups and the
+// blinding of proofs from crypto/rand.Reader, so keys set up and proofs
+// generated inside fn are identical across runs. Other goroutines reading
+// crypto/rand meanwhile get bytes of the stream too, so fn should be the only
+// source of randomness of the test. Keys set up from a known seed are
+// insecure, which is why this only exists in test files.
+func withSeed(seed string, fn func() error) error {
+	seedMu.Lock()
+	defer seedMu.Unlock()
+	reader := rand.Reader
+	rand.Reader = &seedStream{seed: []byte(seed)}
+	defer func() { rand.Reader = reader }()
+	return fn()
+}
+
+type squareCircuit struct {
+	X frontend.Variable
+	Y frontend.Variable `gnark:",public"`
+}
+
+func (circuit *squareCircuit) Define(api frontend.API) error {
+	api.AssertIsEqual(api.Mul(circuit.X, circuit.X), circuit.Y)
+	return nil
+}
+
+// setupAndProve sets up the square circuit from seed and proves 3^2 = 9,
+// returning the raw verifying key and proof.
+func setupAndProve(t *testing.T, seed string) ([]byte, []byte) {
+	cs, err := frontend.Compile(ecc.BN254.ScalarField(), r1cs.NewBuilder, &squareCircuit{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	witness, err := frontend.NewWitness(&squareCircuit{X: 3, Y: 9}, ecc.BN254.ScalarField())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vk, proof bytes.Buffer
+	err = withSeed(seed, func() error {
+		pk, verifyingKey, err := groth16.Setup(cs)
+		if err != nil {
+			return err
+		}
+		p, err := groth16.Prove(cs, pk, witness)
+		if err != nil {
+			return err
+		}
+		if _, err = verifyingKey.WriteRawTo(&vk); err != nil {
+			return err
+		}
+		_, err = p.WriteRawTo(&proof)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return vk.Bytes(), proof.Bytes()
+}
+
+func TestWithSeed(t *testing.T) {
+	vk, proof := setupAndProve(t, "fixture")
+	sameVK, sameProof := setupAndProve(t, "fixture")
+	if !bytes.Equal(vk, sameVK) || !bytes.Equal(proof, sameProof) {
+		t.Fatal("expected the same seed to set up the same keys and proofs")
+	}
+	otherVK, _ := setupAndProve(t, "other fixture")
+	if bytes.Equal(vk, otherVK) {
+		t.Fatal("expected another seed to set up other keys")
+	}
+}
+
+// goldenProof is the SHA-256 of the raw proof set up and generated from the
+// "fixture" seed. It changes with the setup or prover of gnark.
+const goldenProof = "349e5f9e698891d019914ada810a559fee8a78c71420798531b16ff548af15ad"
+
+func TestGolden(t *testing.T) {
+	_, proof := setupAndProve(t, "fixture")
+	if digest := fmt.Sprintf("%x", sha256.Sum256(proof)); digest != goldenProof {
+		t.Fatalf("expected the golden proof %s, got %s", goldenProof, digest)
+	}
+}
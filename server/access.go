@@ -0,0 +1,215 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+	"worldcoin/gnark-mbu/logging"
+
+	"github.com/rs/zerolog"
+)
+
+// RequestIDHeader carries the id of a request, which the server takes from
+// the request or assigns and returns in the response, so that the logs of
+// clients can be correlated with the access log.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds the length of request ids honored from clients.
+const maxRequestIDLength = 128
+
+// accessEntry collects what handlers report about a request for its access
+// log entry.
+type accessEntry struct {
+	id            string
+	method, path  string
+	start         time.Time
+	batchSize     atomic.Int64
+	proofs        atomic.Int64
+	proofDuration atomic.Int64
+	// tenant is the name of the tenant whose API key the request carries,
+	// if any. It is read by the admin API while the request is served.
+	tenant atomic.Pointer[string]
+	// phases are the times the request spent in each phase, reported by
+	// slowRequests.
+	phases phaseTimings
+}
+
+func (entry *accessEntry) tenantName() string {
+	if tenant := entry.tenant.Load(); tenant != nil {
+		return *tenant
+	}
+	return ""
+}
+
+type accessEntryKey struct{}
+
+// statusRecorder records the status of a response, and the error it carries
+// if it was sent with Error.send.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	err    *Error
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush lets /prove_batch stream its results through the recorder.
+func (w *statusRecorder) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack lets /prove/ws take over the connection through the recorder.
+func (w *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("the response writer cannot be hijacked")
+	}
+	if w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return hijacker.Hijack()
+}
+
+// recordError records err in the statusRecorder under w, if any.
+func recordError(w http.ResponseWriter, err *Error) {
+	if recorder := findStatusRecorder(w); recorder != nil {
+		recorder.err = err
+	}
+}
+
+// findStatusRecorder returns the statusRecorder under w, nil if there is
+// none.
+func findStatusRecorder(w http.ResponseWriter) *statusRecorder {
+	for {
+		if recorder, ok := w.(*statusRecorder); ok {
+			return recorder
+		}
+		wrapper, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return nil
+		}
+		w = wrapper.Unwrap()
+	}
+}
+
+// accessLog assigns every request an id, returned in RequestIDHeader, and
+// logs it with its outcome once it is served. Health probes are logged at
+// debug level so that they do not drown the other requests. Requests are
+// tracked by requests, if not nil, while in flight and once they fail.
+func accessLog(next http.Handler, requests *requestTracker) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		entry := &accessEntry{id: r.Header.Get(RequestIDHeader), method: r.Method, path: r.URL.Path, start: start}
+		if !validRequestID(entry.id) {
+			entry.id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, entry.id)
+		recorder := &statusRecorder{ResponseWriter: w}
+		requests.begin(entry)
+		next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), accessEntryKey{}, entry)))
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		requests.end(entry, recorder.status, recorder.err)
+
+		level := zerolog.InfoLevel
+		if r.URL.Path == "/health" || r.URL.Path == "/ready" {
+			level = zerolog.DebugLevel
+		}
+		event := logging.Logger().WithLevel(level).
+			Str("requestId", entry.id).
+			Str("method", r.Method).
+			Str("path", r.URL.Path).
+			Str("remoteAddr", r.RemoteAddr).
+			Int("status", recorder.status).
+			Dur("latency", time.Since(start))
+		if tenant := entry.tenantName(); tenant != "" {
+			event = event.Str("tenant", tenant)
+		}
+		if recorder.err != nil {
+			event = event.Str("code", recorder.err.Code)
+		}
+		if batchSize := entry.batchSize.Load(); batchSize > 0 {
+			event = event.Int64("batchSize", batchSize)
+		}
+		if proofs := entry.proofs.Load(); proofs > 0 {
+			event = event.Int64("proofs", proofs).Dur("proofDuration", time.Duration(entry.proofDuration.Load()))
+		}
+		event.Msg("request served")
+	})
+}
+
+// validRequestID reports whether id can be honored as a request id: it must
+// be short and printable ASCII, so that it cannot forge log entries.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(id[:])
+}
+
+// requestID returns the id accessLog assigned to r, or an empty string for
+// requests served without it.
+func requestID(r *http.Request) string {
+	if entry, ok := r.Context().Value(accessEntryKey{}).(*accessEntry); ok {
+		return entry.id
+	}
+	return ""
+}
+
+// logTenant records the tenant of a request in its access log entry.
+func logTenant(r *http.Request, tenant string) {
+	if entry, ok := r.Context().Value(accessEntryKey{}).(*accessEntry); ok {
+		entry.tenant.Store(&tenant)
+	}
+}
+
+// logBatchSize records the number of identity commitments of a request in
+// its access log entry.
+func logBatchSize(r *http.Request, batchSize int) {
+	if entry, ok := r.Context().Value(accessEntryKey{}).(*accessEntry); ok {
+		entry.batchSize.Store(int64(batchSize))
+	}
+}
+
+// logProof adds a proof of a request and the time it took to its access log
+// entry.
+func logProof(r *http.Request, elapsed time.Duration) {
+	if entry, ok := r.Context().Value(accessEntryKey{}).(*accessEntry); ok {
+		entry.proofs.Add(1)
+		entry.proofDuration.Add(int64(elapsed))
+	}
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"worldcoin/gnark-mbu/logging"
+
+	"github.com/rs/zerolog"
+)
+
+func TestAccessLog(t *testing.T) {
+	var logs bytes.Buffer
+	previous := *logging.Logger()
+	*logging.Logger() = zerolog.New(&logs)
+	defer func() { *logging.Logger() = previous }()
+
+	var seen string
+	handler := accessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = requestID(r)
+		logBatchSize(r, 4)
+		logProof(r, time.Second)
+		logProof(r, 2*time.Second)
+		w.WriteHeader(http.StatusTeapot)
+	}), nil)
+
+	r := httptest.NewRequest(http.MethodPost, "/prove", nil)
+	r.Header.Set(RequestIDHeader, "sequencer-42")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if seen != "sequencer-42" || w.Header().Get(RequestIDHeader) != "sequencer-42" {
+		t.Fatalf("expected the request id to be honored, got %q and %q", seen, w.Header().Get(RequestIDHeader))
+	}
+	var entry struct {
+		RequestID     string  `json:"requestId"`
+		Method        string  `json:"method"`
+		Path          string  `json:"path"`
+		Status        int     `json:"status"`
+		BatchSize     int     `json:"batchSize"`
+		Proofs        int     `json:"proofs"`
+		ProofDuration float64 `json:"proofDuration"`
+	}
+	if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.RequestID != "sequencer-42" || entry.Method != http.MethodPost || entry.Path != "/prove" || entry.Status != http.StatusTeapot ||
+		entry.BatchSize != 4 || entry.Proofs != 2 || entry.ProofDuration != 3000 {
+		t.Fatalf("unexpected access log entry %s", logs.String())
+	}
+
+	// Missing and unprintable ids are replaced.
+	for _, id := range []string{"", "forged\nentry"} {
+		r = httptest.NewRequest(http.MethodGet, "/info", nil)
+		r.Header.Set(RequestIDHeader, id)
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if assigned := w.Header().Get(RequestIDHeader); len(assigned) != 32 || assigned != seen {
+			t.Fatalf("expected an id to be assigned instead of %q, got %q", id, assigned)
+		}
+	}
+}
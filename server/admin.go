@@ -0,0 +1,382 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+	"worldcoin/gnark-mbu/buildinfo"
+	"worldcoin/gnark-mbu/jobstore"
+	"worldcoin/gnark-mbu/prover"
+)
+
+// maxRecentErrors bounds the number of failed requests the admin API
+// reports.
+const maxRecentErrors = 100
+
+// inFlightRequest is a request being served, as reported by /requests of the
+// admin API.
+type inFlightRequest struct {
+	ID        string    `json:"id"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Tenant    string    `json:"tenant,omitempty"`
+	BatchSize int64     `json:"batchSize,omitempty"`
+	Proofs    int64     `json:"proofs,omitempty"`
+	Started   time.Time `json:"started"`
+	// Elapsed is the time the request has been served for, in seconds.
+	Elapsed float64 `json:"elapsed"`
+}
+
+// recentError is a failed request, as reported by /errors of the admin API.
+type recentError struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"requestId"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Status    int       `json:"status"`
+	Code      string    `json:"code,omitempty"`
+	Message   string    `json:"message,omitempty"`
+}
+
+// requestTracker keeps the requests in flight and the last failed ones for
+// the admin API. A nil tracker tracks nothing.
+type requestTracker struct {
+	mu       sync.Mutex
+	inFlight map[*accessEntry]struct{}
+	// errors is a ring of the last maxRecentErrors failed requests, next
+	// being the index of the oldest once it is full.
+	errors []recentError
+	next   int
+}
+
+func newRequestTracker() *requestTracker {
+	return &requestTracker{inFlight: make(map[*accessEntry]struct{})}
+}
+
+func (tracker *requestTracker) begin(entry *accessEntry) {
+	if tracker == nil {
+		return
+	}
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	tracker.inFlight[entry] = struct{}{}
+}
+
+// end stops tracking the request of entry, recording it if it failed with a
+// server error or with an Error.
+func (tracker *requestTracker) end(entry *accessEntry, status int, err *Error) {
+	if tracker == nil {
+		return
+	}
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	delete(tracker.inFlight, entry)
+	if err == nil && status < http.StatusInternalServerError {
+		return
+	}
+	failure := recentError{Time: time.Now(), RequestID: entry.id, Method: entry.method, Path: entry.path, Status: status}
+	if err != nil {
+		failure.Code, failure.Message = err.Code, err.Message
+	}
+	if len(tracker.errors) < maxRecentErrors {
+		tracker.errors = append(tracker.errors, failure)
+		return
+	}
+	tracker.errors[tracker.next] = failure
+	tracker.next = (tracker.next + 1) % maxRecentErrors
+}
+
+// requests returns the requests in flight, oldest first.
+func (tracker *requestTracker) requests(now time.Time) []inFlightRequest {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	requests := make([]inFlightRequest, 0, len(tracker.inFlight))
+	for entry := range tracker.inFlight {
+		requests = append(requests, inFlightRequest{
+			ID:        entry.id,
+			Method:    entry.method,
+			Path:      entry.path,
+			Tenant:    entry.tenantName(),
+			BatchSize: entry.batchSize.Load(),
+			Proofs:    entry.proofs.Load(),
+			Started:   entry.start,
+			Elapsed:   now.Sub(entry.start).Seconds(),
+		})
+	}
+	sort.Slice(requests, func(i, j int) bool { return requests[i].Started.Before(requests[j].Started) })
+	return requests
+}
+
+// recentErrors returns the last failed requests, most recent first.
+func (tracker *requestTracker) recentErrors() []recentError {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	errors := make([]recentError, 0, len(tracker.errors))
+	for i := len(tracker.errors) - 1; i >= 0; i-- {
+		errors = append(errors, tracker.errors[(tracker.next+i)%len(tracker.errors)])
+	}
+	return errors
+}
+
+// adminConfig is the configuration reported by /config of the admin API. It
+// leaves out secrets, such as the admin token and the callback secret.
+type adminConfig struct {
+	ProverAddress          string               `json:"proverAddress"`
+	MetricsAddress         string               `json:"metricsAddress"`
+	AdminAddress           string               `json:"adminAddress"`
+	TLS                    bool                 `json:"tls"`
+	Mode                   Mode                 `json:"mode"`
+	Dev                    bool                 `json:"dev"`
+	LegacyJSON             bool                 `json:"legacyJson"`
+	MaxConcurrentProofs    int                  `json:"maxConcurrentProofs"`
+	AutoscaleTargetLatency string               `json:"autoscaleTargetLatency"`
+	PriorityAging          string               `json:"priorityAging"`
+	ClientKeys             []string             `json:"clientKeys"`
+	RequireSignatures      bool                 `json:"requireSignatures"`
+	ProveTimeout           string               `json:"proveTimeout"`
+	ReadTimeout            string               `json:"readTimeout"`
+	WriteTimeout           string               `json:"writeTimeout"`
+	IdleTimeout            string               `json:"idleTimeout"`
+	SlowRequestThreshold   string               `json:"slowRequestThreshold"`
+	BatchWorkers           int                  `json:"batchWorkers"`
+	Aggregation            bool                 `json:"aggregation"`
+	ProofEncoding          prover.ProofEncoding `json:"proofEncoding"`
+	NumberFormat           prover.NumberFormat  `json:"numberFormat"`
+	RequestLimits          RequestLimits        `json:"requestLimits"`
+	RateLimits             *RateLimits          `json:"rateLimits"`
+	DrainGracePeriod       string               `json:"drainGracePeriod"`
+	Jobs                   bool                 `json:"jobs"`
+	JobRetention           string               `json:"jobRetention"`
+	Callbacks              bool                 `json:"callbacks"`
+	ProofCache             bool                 `json:"proofCache"`
+	Ledger                 bool                 `json:"ledger"`
+	ProofRetries           *ProofRetries        `json:"proofRetries"`
+	SelfVerify             bool                 `json:"selfVerify"`
+	ResultSigning          bool                 `json:"resultSigning"`
+	Pprof                  bool                 `json:"pprof"`
+	MetricsExporter        bool                 `json:"metricsExporter"`
+	MetricsPushInterval    string               `json:"metricsPushInterval"`
+	ReloadableKeys         bool                 `json:"reloadableKeys"`
+	LazyKeys               bool                 `json:"lazyKeys"`
+	MemoryBudget           *MemoryBudget        `json:"memoryBudget"`
+	Tenants                []string             `json:"tenants"`
+	TenantsFile            string               `json:"tenantsFile,omitempty"`
+	StartIndexAlignment    uint32               `json:"startIndexAlignment"`
+	Recording              bool                 `json:"recording"`
+	CORS                   *CORS                `json:"cors"`
+}
+
+func newAdminConfig(config *Config, mode Mode) *adminConfig {
+	admin := &adminConfig{
+		ProverAddress:          config.ProverAddress,
+		MetricsAddress:         config.MetricsAddress,
+		AdminAddress:           config.AdminAddress,
+		TLS:                    config.TLSCertificate != nil,
+		Mode:                   mode,
+		Dev:                    config.Dev,
+		LegacyJSON:             config.LegacyJSON,
+		MaxConcurrentProofs:    config.MaxConcurrentProofs,
+		AutoscaleTargetLatency: config.AutoscaleTargetLatency.String(),
+		PriorityAging:          config.PriorityAging.String(),
+		ClientKeys:             []string{},
+		RequireSignatures:      config.RequireSignatures,
+		ProveTimeout:           config.ProveTimeout.String(),
+		ReadTimeout:            config.ReadTimeout.String(),
+		WriteTimeout:           config.WriteTimeout.String(),
+		IdleTimeout:            config.IdleTimeout.String(),
+		SlowRequestThreshold:   config.SlowRequestThreshold.String(),
+		BatchWorkers:           config.BatchWorkers,
+		Aggregation:            config.Aggregation != nil,
+		ProofEncoding:          config.ProofEncoding,
+		NumberFormat:           config.NumberFormat,
+		RequestLimits:          config.RequestLimits,
+		RateLimits:             config.RateLimits,
+		DrainGracePeriod:       config.DrainGracePeriod.String(),
+		Jobs:                   config.Jobs != nil,
+		JobRetention:           config.JobRetention.String(),
+		Callbacks:              config.Callbacks != nil,
+		ProofCache:             config.ProofCache != nil,
+		Ledger:                 config.Ledger != nil,
+		ProofRetries:           config.ProofRetries,
+		SelfVerify:             config.SelfVerify,
+		ResultSigning:          config.ResultSigner != nil,
+		Pprof:                  config.Pprof,
+		MetricsExporter:        config.MetricsExporter != nil,
+		MetricsPushInterval:    config.MetricsPushInterval.String(),
+		ReloadableKeys:         config.LoadKeys != nil,
+		LazyKeys:               config.LazyKeys,
+		MemoryBudget:           config.MemoryBudget,
+		Tenants:                []string{},
+		TenantsFile:            config.TenantsFile,
+		StartIndexAlignment:    config.StartIndexAlignment,
+		Recording:              config.Recorder != nil,
+		CORS:                   config.CORS,
+	}
+	for id := range config.ClientKeys {
+		admin.ClientKeys = append(admin.ClientKeys, id)
+	}
+	sort.Strings(admin.ClientKeys)
+	for _, tenant := range config.Tenants {
+		admin.Tenants = append(admin.Tenants, tenant.Name)
+	}
+	return admin
+}
+
+// adminKeys is the response of /keys of the admin API.
+type adminKeys struct {
+	// Current describes the default keys, nil if they are not loaded.
+	Current *circuitInfo `json:"current"`
+	// KeysError is the error the keys failed to load with at startup.
+	KeysError string `json:"keysError,omitempty"`
+	// Files are the key files of the keys directory, if any.
+	Files []keyFileInfo `json:"files"`
+}
+
+// adminJobs is the response of /jobs of the admin API.
+type adminJobs struct {
+	Queue autoscaleSignal `json:"queue"`
+	// Draining is set once the server started shutting down.
+	Draining bool `json:"draining"`
+	// Jobs are the unfinished jobs of the async mode, if enabled.
+	Jobs []adminJob `json:"jobs"`
+}
+
+type adminJob struct {
+	ID       string          `json:"id"`
+	ClientID string          `json:"clientId,omitempty"`
+	Status   jobstore.Status `json:"status"`
+	Stage    string          `json:"stage,omitempty"`
+	Created  time.Time       `json:"created"`
+	Updated  time.Time       `json:"updated"`
+}
+
+// adminBuild is the response of /build of the admin API.
+type adminBuild struct {
+	Version        string    `json:"version"`
+	GitCommit      string    `json:"gitCommit"`
+	GoVersion      string    `json:"goVersion"`
+	Backend        string    `json:"backend"`
+	GnarkVersion   string    `json:"gnarkVersion,omitempty"`
+	CircuitVersion string    `json:"circuitVersion"`
+	Started        time.Time `json:"started"`
+	Goroutines     int       `json:"goroutines"`
+}
+
+// gnarkVersion returns the version of gnark the binary was built with.
+func gnarkVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	for _, dependency := range info.Deps {
+		if dependency.Path == "github.com/consensys/gnark" {
+			return dependency.Version
+		}
+	}
+	return ""
+}
+
+// admin serves the read-only admin API, for on-call engineers inspecting a
+// stuck prover.
+type admin struct {
+	config   *adminConfig
+	keysErr  error
+	system   *activeSystem
+	keys     *keySet
+	queue    *proofQueue
+	drain    *drain
+	jobs     jobstore.Store
+	requests *requestTracker
+	started  time.Time
+}
+
+// mux returns the handler of the admin API, which requires token.
+func (admin *admin) mux(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/config", func(w http.ResponseWriter, r *http.Request) {
+		sendAdminJSON(w, admin.config)
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		response := adminKeys{Files: admin.keys.files()}
+		if provingSystem := admin.system.current.Load().provingSystem; provingSystem != nil {
+			info := newCircuitInfo(provingSystem)
+			response.Current = &info
+		}
+		if admin.keysErr != nil {
+			response.KeysError = admin.keysErr.Error()
+		}
+		sendAdminJSON(w, &response)
+	})
+	mux.HandleFunc("/requests", func(w http.ResponseWriter, r *http.Request) {
+		sendAdminJSON(w, admin.requests.requests(time.Now()))
+	})
+	mux.HandleFunc("/jobs", func(w http.ResponseWriter, r *http.Request) {
+		response := adminJobs{Queue: admin.queue.autoscale(time.Now()), Draining: admin.drain.isDraining(), Jobs: []adminJob{}}
+		if admin.jobs != nil {
+			ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+			defer cancel()
+			jobs, err := admin.jobs.Unfinished(ctx)
+			if err != nil {
+				unexpectedError(err).send(w)
+				return
+			}
+			for _, job := range jobs {
+				response.Jobs = append(response.Jobs, adminJob{ID: job.ID, ClientID: job.ClientID, Status: job.Status, Stage: job.Stage, Created: job.CreatedAt, Updated: job.UpdatedAt})
+			}
+		}
+		sendAdminJSON(w, &response)
+	})
+	mux.HandleFunc("/errors", func(w http.ResponseWriter, r *http.Request) {
+		sendAdminJSON(w, admin.requests.recentErrors())
+	})
+	mux.HandleFunc("/build", func(w http.ResponseWriter, r *http.Request) {
+		sendAdminJSON(w, &adminBuild{
+			Version:        buildinfo.Version,
+			GitCommit:      buildinfo.GitCommit,
+			GoVersion:      runtime.Version(),
+			Backend:        backend,
+			GnarkVersion:   gnarkVersion(),
+			CircuitVersion: prover.CircuitSemver,
+			Started:        admin.started,
+			Goroutines:     runtime.NumGoroutine(),
+		})
+	})
+	return adminAuth(token, mux)
+}
+
+// adminAuth serves the GET requests carrying token as a bearer token or in
+// APIKeyHeader, the admin API being read-only.
+func adminAuth(token string, next http.Handler) http.Handler {
+	expected := sha256.Sum256([]byte(token))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := apiKey(r)
+		actual := sha256.Sum256([]byte(key))
+		if key == "" || subtle.ConstantTimeCompare(actual[:], expected[:]) != 1 {
+			unauthorizedError("invalid_admin_token", "the admin API requires the admin token").send(w)
+			return
+		}
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func sendAdminJSON(w http.ResponseWriter, response interface{}) {
+	responseBytes, err := json.Marshal(response)
+	if err != nil {
+		unexpectedError(err).send(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(responseBytes)
+}
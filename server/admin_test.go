@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"worldcoin/gnark-mbu/jobstore"
+)
+
+func TestAdmin(t *testing.T) {
+	requests := newRequestTracker()
+	proving := make(chan struct{})
+	done := make(chan struct{})
+	handler := accessLog(compressResponses(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/prove" {
+			logBatchSize(r, 4)
+			proving <- struct{}{}
+			<-proving
+		}
+		proverUnavailableError().send(w)
+	})), requests)
+	go func() {
+		defer close(done)
+		r := httptest.NewRequest(http.MethodPost, "/prove", nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+	}()
+	<-proving
+
+	jobs := jobstore.NewMemory()
+	if err := jobs.Create(context.Background(), &jobstore.Job{ID: "job-1", Status: jobstore.StatusRunning}); err != nil {
+		t.Fatal(err)
+	}
+	config := &Config{AdminAddress: "localhost:9997", AdminToken: "on-call", Callbacks: &Callbacks{Secret: []byte("callback secret")}}
+	admin := &admin{
+		config:   newAdminConfig(config, ModeBoth),
+		system:   newActiveSystem(nil),
+		queue:    newProofQueue(1, time.Minute, 0),
+		drain:    newDrain(),
+		jobs:     jobs,
+		requests: requests,
+		started:  time.Now(),
+	}
+	mux := admin.mux(config.AdminToken)
+	get := func(path string, token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, r)
+		return recorder
+	}
+
+	for _, token := range []string{"", "sequencer-key"} {
+		if recorder := get("/config", token); recorder.Code != http.StatusUnauthorized {
+			t.Fatalf("expected a request with token %q to be rejected, got %d", token, recorder.Code)
+		}
+	}
+	if recorder := get("/config", "on-call"); recorder.Code != http.StatusOK || strings.Contains(recorder.Body.String(), "secret") || strings.Contains(recorder.Body.String(), "on-call") {
+		t.Fatalf("expected the configuration without secrets, got %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	var inFlight []inFlightRequest
+	if err := json.Unmarshal(get("/requests", "on-call").Body.Bytes(), &inFlight); err != nil {
+		t.Fatal(err)
+	}
+	if len(inFlight) != 1 || inFlight[0].Path != "/prove" || inFlight[0].BatchSize != 4 {
+		t.Fatalf("expected the proof request to be in flight, got %+v", inFlight)
+	}
+	var response adminJobs
+	if err := json.Unmarshal(get("/jobs", "on-call").Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Jobs) != 1 || response.Jobs[0].ID != "job-1" {
+		t.Fatalf("expected the running job, got %+v", response)
+	}
+
+	proving <- struct{}{}
+	<-done
+	var recent []recentError
+	if err := json.Unmarshal(get("/errors", "on-call").Body.Bytes(), &recent); err != nil {
+		t.Fatal(err)
+	}
+	if len(recent) != 1 || recent[0].Code != "prover_unavailable" || recent[0].Status != http.StatusServiceUnavailable {
+		t.Fatalf("expected the failed proof request, got %+v", recent)
+	}
+	if inFlight = requests.requests(time.Now()); len(inFlight) != 0 {
+		t.Fatalf("expected no request in flight, got %+v", inFlight)
+	}
+
+	for i := 0; i < maxRecentErrors+1; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/info", nil))
+	}
+	if recent = requests.recentErrors(); len(recent) != maxRecentErrors || recent[0].Path != "/info" || recent[len(recent)-1].Path != "/info" {
+		t.Fatalf("expected the oldest errors to be dropped, got %d errors", len(recent))
+	}
+
+	if recorder := get("/build", "on-call"); recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), `"circuitVersion"`) {
+		t.Fatalf("expected the build info, got %d %s", recorder.Code, recorder.Body.String())
+	}
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+	"worldcoin/gnark-mbu/logging"
+	"worldcoin/gnark-mbu/prover"
+)
+
+// aggregateHandler serves /aggregate. Aggregated proofs are on BW6-761, so
+// they can only be verified off-chain.
+type aggregateHandler struct {
+	aggregation *prover.AggregationSystem
+	queue       *proofQueue
+	drain       *drain
+	encoding    prover.ProofEncoding
+	numbers     prover.NumberFormat
+	limits      RequestLimits
+}
+
+type aggregateProofJSON struct {
+	Proof     prover.Proof `json:"proof"`
+	InputHash string       `json:"inputHash"`
+	PostRoot  string       `json:"postRoot,omitempty"`
+}
+
+type aggregateRequest struct {
+	Proofs []aggregateProofJSON `json:"proofs"`
+}
+
+func (handler aggregateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	logging.Logger().Info().Msg("received aggregate request")
+	encoding, encodingErr := proofEncoding(r, handler.encoding)
+	if encodingErr != nil {
+		encodingErr.send(w)
+		return
+	}
+	sched, scheduleErr := requestSchedule(r)
+	if scheduleErr != nil {
+		scheduleErr.send(w)
+		return
+	}
+	buf, readErr := handler.limits.readBody(w, r)
+	if readErr != nil {
+		readErr.send(w)
+		return
+	}
+	var request aggregateRequest
+	err := json.Unmarshal(buf, &request)
+	if err != nil {
+		malformedBodyError(err).send(w)
+		return
+	}
+	inputs := make([]prover.AggregationInput, len(request.Proofs))
+	for i := range request.Proofs {
+		inputs[i].Proof = &request.Proofs[i].Proof
+		if inputs[i].InputHash, err = parseNumber("inputHash", request.Proofs[i].InputHash); err != nil {
+			malformedBodyError(err).send(w)
+			return
+		}
+		if handler.aggregation.PublicPostRoot {
+			if inputs[i].PostRoot, err = parseNumber("postRoot", request.Proofs[i].PostRoot); err != nil {
+				malformedBodyError(err).send(w)
+				return
+			}
+		}
+	}
+	var proof *prover.Proof
+	done := make(chan error, 1)
+	go func() {
+		// Queued aggregations are dropped when the server cancels its
+		// proofs.
+		ctx, cancel := handler.drain.context(context.Background())
+		defer cancel()
+		var err error
+		if queueErr := handler.queue.run(ctx, sched, func() {
+			if ctx.Err() != nil {
+				err = errShuttingDown
+				return
+			}
+			start := time.Now()
+			proof, err = handler.aggregation.Aggregate(inputs)
+			aggregation := handler.aggregation
+			proofDurationHistogram.WithLabelValues(strconv.FormatUint(uint64(aggregation.TreeDepth), 10), strconv.FormatUint(uint64(aggregation.BatchSize), 10), "aggregation", proofOutcome(err)).Observe(time.Since(start).Seconds())
+		}); queueErr != nil {
+			err = errShuttingDown
+		}
+		done <- err
+	}()
+	select {
+	case err = <-done:
+	case <-handler.drain.cancelled():
+		err = errShuttingDown
+	}
+	if errors.Is(err, errShuttingDown) {
+		proofError(err).send(w)
+		return
+	}
+	if err != nil {
+		proverError(err).send(w)
+		return
+	}
+	responseBytes, err := json.Marshal(proof.Encoded(encoding, handler.numbers))
+	if err != nil {
+		unexpectedError(err).send(w)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(responseBytes)
+}
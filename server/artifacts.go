@@ -0,0 +1,58 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"worldcoin/gnark-mbu/prover"
+)
+
+// artifactsHandler serves the artifacts derived from the verifying key under
+// /artifacts/, so that integrators can deploy and check the contracts of the
+// circuit the server proves. They only need the verifying key, so they are
+// served while the proving keys are not loaded, from the verifier.
+type artifactsHandler struct {
+	system *activeSystem
+}
+
+// artifacts are the files served under /artifacts/, by name.
+var artifacts = map[string]struct {
+	contentType string
+	write       func(vs *prover.VerifyingSystem, w io.Writer) error
+}{
+	"verifier.sol":       {"text/plain; charset=utf-8", (*prover.VerifyingSystem).ExportSolidity},
+	"verifying_key.json": {"application/json", (*prover.VerifyingSystem).ExportVerifyingKeyJSON},
+	"verifying_key": {"application/octet-stream", func(vs *prover.VerifyingSystem, w io.Writer) error {
+		_, err := vs.WriteTo(w)
+		return err
+	}},
+}
+
+func (handler artifactsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	artifact, ok := artifacts[strings.TrimPrefix(r.URL.Path, "/artifacts/")]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	g := handler.system.acquire()
+	defer g.release()
+	provingSystem := handler.system.verifying(g)
+	if provingSystem == nil {
+		verifierUnavailableError().send(w)
+		return
+	}
+	var buf bytes.Buffer
+	if err := artifact.write(provingSystem.VerifyingSystem(), &buf); err != nil {
+		unexpectedError(fmt.Errorf("failed to export %s: %w", r.URL.Path, err)).send(w)
+		return
+	}
+	w.Header().Set("Content-Type", artifact.contentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
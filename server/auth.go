@@ -0,0 +1,108 @@
+package server
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"worldcoin/gnark-mbu/logging"
+)
+
+const (
+	// ClientIdHeader names the registered key a request is signed with.
+	ClientIdHeader = "X-Client-Id"
+	// SignatureHeader carries the base64 encoded signature over
+	// prover.Parameters.Digest. Ed25519 signatures are raw, ECDSA signatures
+	// are ASN.1 encoded.
+	SignatureHeader = "X-Signature"
+)
+
+// ClientKeys maps client identifiers to the public keys their requests are
+// signed with. Supported keys are ed25519.PublicKey and *ecdsa.PublicKey.
+type ClientKeys map[string]crypto.PublicKey
+
+// LoadClientKeys reads every <client id>.pem file in dir, each holding a PKIX
+// encoded Ed25519 or ECDSA public key.
+func LoadClientKeys(dir string) (ClientKeys, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	keys := make(ClientKeys)
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("%s: no PEM block found", path)
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		switch key.(type) {
+		case ed25519.PublicKey, *ecdsa.PublicKey:
+		default:
+			return nil, fmt.Errorf("%s: unsupported key type %T", path, key)
+		}
+		keys[strings.TrimSuffix(filepath.Base(path), ".pem")] = key
+	}
+	return keys, nil
+}
+
+func verifySignature(key crypto.PublicKey, digest []byte, signature []byte) bool {
+	switch key := key.(type) {
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, digest, signature)
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(key, digest, signature)
+	default:
+		return false
+	}
+}
+
+func unauthorizedError(code string, message string) *Error {
+	return &Error{StatusCode: http.StatusUnauthorized, Code: code, Message: message}
+}
+
+// authenticate checks the signature of a request over the digest of its
+// parameters, returning the identifier of the signing client or an empty
+// string for unsigned requests, which are only accepted if signatures are not
+// required.
+func authenticate(r *http.Request, digest [32]byte, keys ClientKeys, required bool) (string, *Error) {
+	return authenticateSignature(r, r.Header.Get(ClientIdHeader), r.Header.Get(SignatureHeader), digest, keys, required)
+}
+
+// authenticateSignature checks a signature over digest sent by clientId
+// other than in the headers of r, like authenticate.
+func authenticateSignature(r *http.Request, clientId string, encodedSignature string, digest [32]byte, keys ClientKeys, required bool) (string, *Error) {
+	if clientId == "" && encodedSignature == "" {
+		if required {
+			return "", unauthorizedError("missing_signature", "requests must be signed")
+		}
+		return "", nil
+	}
+	key, ok := keys[clientId]
+	if !ok {
+		return "", unauthorizedError("unknown_client", fmt.Sprintf("unknown client: %s", clientId))
+	}
+	signature, err := base64.StdEncoding.DecodeString(encodedSignature)
+	if err != nil {
+		return "", unauthorizedError("invalid_signature", "signature is not valid base64")
+	}
+	if !verifySignature(key, digest[:], signature) {
+		logging.Audit().Warn().Str("clientId", clientId).Str("digest", hex.EncodeToString(digest[:])).Str("remoteAddr", r.RemoteAddr).Msg("rejected request with invalid signature")
+		return "", unauthorizedError("invalid_signature", "signature does not match the parameters")
+	}
+	return clientId, nil
+}
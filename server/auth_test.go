@@ -0,0 +1,74 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"worldcoin/gnark-mbu/prover"
+)
+
+func writePublicKey(t *testing.T, dir string, clientId string, key interface{}) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	if err = os.WriteFile(filepath.Join(dir, clientId+".pem"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	dir := t.TempDir()
+	edPublic, edPrivate, _ := ed25519.GenerateKey(rand.Reader)
+	ecPrivate, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	writePublicKey(t, dir, "sequencer-ed", edPublic)
+	writePublicKey(t, dir, "sequencer-ec", &ecPrivate.PublicKey)
+	keys, err := LoadClientKeys(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params := &prover.Parameters{StartIndex: 7, IdComms: []big.Int{*big.NewInt(1)}}
+	digest := params.Digest()
+	ecSignature, _ := ecdsa.SignASN1(rand.Reader, ecPrivate, digest[:])
+	signatures := map[string][]byte{
+		"sequencer-ed": ed25519.Sign(edPrivate, digest[:]),
+		"sequencer-ec": ecSignature,
+	}
+
+	for clientId, signature := range signatures {
+		r := httptest.NewRequest("POST", "/prove", nil)
+		r.Header.Set(ClientIdHeader, clientId)
+		r.Header.Set(SignatureHeader, base64.StdEncoding.EncodeToString(signature))
+		authenticated, authErr := authenticate(r, params.Digest(), keys, true)
+		if authErr != nil {
+			t.Fatalf("%s: %s", clientId, authErr.Message)
+		}
+		if authenticated != clientId {
+			t.Fatalf("expected %s, got %s", clientId, authenticated)
+		}
+
+		tampered := &prover.Parameters{StartIndex: 8, IdComms: params.IdComms}
+		if _, authErr = authenticate(r, tampered.Digest(), keys, true); authErr == nil || authErr.Code != "invalid_signature" {
+			t.Fatalf("%s: expected tampered parameters to be rejected", clientId)
+		}
+	}
+
+	r := httptest.NewRequest("POST", "/prove", nil)
+	if _, authErr := authenticate(r, params.Digest(), keys, true); authErr == nil || authErr.Code != "missing_signature" {
+		t.Fatal("expected unsigned requests to be rejected when signatures are required")
+	}
+	if clientId, authErr := authenticate(r, params.Digest(), keys, false); authErr != nil || clientId != "" {
+		t.Fatal("expected unsigned requests to be accepted when signatures are optional")
+	}
+}
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// DeadlineHeader carries the RFC 3339 time by which the caller needs the
+// proof. It is only used to compute the autoscaling signal.
+const DeadlineHeader = "X-Deadline"
+
+func requestDeadline(r *http.Request) time.Time {
+	deadline, err := time.Parse(time.RFC3339, r.Header.Get(DeadlineHeader))
+	if err != nil {
+		return time.Time{}
+	}
+	return deadline
+}
+
+type autoscaleHandler struct {
+	queue *proofQueue
+}
+
+func (handler autoscaleHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	responseBytes, err := json.Marshal(handler.queue.autoscale(time.Now()))
+	if err != nil {
+		unexpectedError(err).send(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(responseBytes)
+}
@@ -0,0 +1,216 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"worldcoin/gnark-mbu/logging"
+	"worldcoin/gnark-mbu/prover"
+)
+
+// proveBatchHandler proves an array of parameter sets, streaming each result
+// back as a line of NDJSON as soon as it is available.
+type proveBatchHandler struct {
+	proveHandler
+	workers int
+}
+
+// batchResult is a line of the response of the batch endpoint. Results are
+// sent in completion order, Index refers to the position of the parameters in
+// the request.
+type batchResult struct {
+	Index int            `json:"index"`
+	Proof json.Marshaler `json:"proof,omitempty"`
+	// Signature is sent as the ProofSignatureHeader of /prove.
+	Signature string         `json:"signature,omitempty"`
+	Metadata  *proofMetadata `json:"metadata,omitempty"`
+	Error     *Error         `json:"error,omitempty"`
+}
+
+// decodeBatch decodes a JSON array of parameters, each in the dialect
+// selected as for decodeParameters.
+func decodeBatch(r *http.Request, body []byte, legacyJSON bool) ([]*prover.Parameters, error) {
+	var items []json.RawMessage
+	if err := json.Unmarshal(body, &items); err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, fmt.Errorf("the batch is empty")
+	}
+	legacy := useLegacyJSON(r, legacyJSON)
+	batch := make([]*prover.Parameters, len(items))
+	for i, item := range items {
+		params, err := unmarshalParameters(item, legacy)
+		if err != nil {
+			return nil, fmt.Errorf("parameters %d: %w", i, err)
+		}
+		batch[i] = params
+	}
+	return batch, nil
+}
+
+// batchDigest is the digest a batch request is signed over: the SHA-256 of
+// the concatenated digests of its parameters.
+func batchDigest(batch []*prover.Parameters) [32]byte {
+	hash := sha256.New()
+	for _, params := range batch {
+		digest := params.Digest()
+		hash.Write(digest[:])
+	}
+	var digest [32]byte
+	copy(digest[:], hash.Sum(nil))
+	return digest
+}
+
+func (handler proveBatchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if limitErr := handler.limiter.allowIP(r); limitErr != nil {
+		limitErr.send(w)
+		return
+	}
+	logging.Logger().Info().Msg("received prove batch request")
+	w.Header().Set(CircuitVersionHeader, prover.CircuitSemver)
+	if handler.system.current.Load().provingSystem == nil {
+		proverUnavailableError().send(w)
+		return
+	}
+	buf, readErr := handler.limits.readBody(w, r)
+	if readErr != nil {
+		readErr.send(w)
+		return
+	}
+	batch, err := decodeBatch(r, buf, handler.legacyJSON)
+	if err != nil {
+		malformedBodyError(err).send(w)
+		return
+	}
+	batchSize := 0
+	for _, params := range batch {
+		batchSize += len(params.IdComms)
+	}
+	logBatchSize(r, batchSize)
+	digest := batchDigest(batch)
+	clientId, authErr := authenticate(r, digest, handler.clientKeys, handler.requireSignatures)
+	if authErr != nil {
+		authErr.send(w)
+		return
+	}
+	flightKey, keyErr := idempotencyKey(r, clientId)
+	if keyErr != nil {
+		keyErr.send(w)
+		return
+	}
+	if limitErr := handler.limiter.allowClient(clientId); limitErr != nil {
+		limitErr.send(w)
+		return
+	}
+	for _, params := range batch {
+		if circuitErr := allowCircuit(r, paramsShape(params)); circuitErr != nil {
+			circuitErr.send(w)
+			return
+		}
+	}
+	audit := logging.Audit().With().Str("requestId", requestID(r)).Str("clientId", clientId).Str("digest", hex.EncodeToString(digest[:])).Str("remoteAddr", r.RemoteAddr).Logger()
+	audit.Info().Bool("authenticated", clientId != "").Int("batch", len(batch)).Msg("proof batch requested")
+	includeMetadata, _ := strconv.ParseBool(r.URL.Query().Get("include_metadata"))
+	encoding, encodingErr := proofEncoding(r, handler.encoding)
+	if encodingErr != nil {
+		encodingErr.send(w)
+		return
+	}
+	sched, scheduleErr := requestSchedule(r)
+	if scheduleErr != nil {
+		scheduleErr.send(w)
+		return
+	}
+
+	// All parameters of a batch are proven with the same keys, those of the
+	// first parameters.
+	g := handler.systemFor(batch[0]).acquire()
+	defer g.release()
+	if g.provingSystem == nil {
+		proverUnavailableError().send(w)
+		return
+	}
+	workers := handler.workers
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(batch) {
+		workers = len(batch)
+	}
+	indices := make(chan int)
+	results := make(chan batchResult)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indices {
+				params := batch[index]
+				key := flightKey
+				if key != "" {
+					key += ":" + strconv.Itoa(index)
+				}
+				res := handler.proveCancellable(sched, g, params, key, nil)
+				result := batchResult{Index: index}
+				if res.err != nil {
+					audit.Info().Int("index", index).Err(res.err).Msg("proof failed")
+					result.Error = proofError(res.err)
+				} else {
+					if !res.cached {
+						logProof(r, res.elapsed)
+					}
+					audit.Info().Int("index", index).Str("inputHash", params.InputHash.Text(16)).Bool("cached", res.cached).Bool("coalesced", res.coalesced).Msg("proof generated")
+					signature, err := signResult(handler.signer, res.proof, &params.InputHash)
+					if err != nil {
+						logging.Logger().Error().Err(err).Msg("error signing proof")
+						result.Error = signingFailedError(err)
+						results <- result
+						continue
+					}
+					result.Proof = res.proof.Encoded(encoding, handler.numbers)
+					result.Signature = signature
+					if includeMetadata {
+						result.Metadata = newProofMetadata(g.provingSystem, params, res.elapsed, handler.numbers)
+					}
+				}
+				results <- result
+			}
+		}()
+	}
+	go func() {
+		for index := range batch {
+			indices <- index
+		}
+		close(indices)
+		wg.Wait()
+		close(results)
+	}()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+	for result := range results {
+		// Keep draining the results if the client went away, so that the
+		// workers finish and release the keys.
+		if err != nil {
+			continue
+		}
+		if err = encoder.Encode(&result); err != nil {
+			logging.Logger().Error().Err(err).Msg("error writing batch result")
+			continue
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"crypto/sha256"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDecodeBatch(t *testing.T) {
+	body := `[
+		{"startIndex":0,"preRoot":"0x1","postRoot":"0x2","identityCommitments":["0x3"],"merkleProofs":[["0x0"]]},
+		{"startIndex":1,"preRoot":"0x2","postRoot":"0x4","identityCommitments":["0x5"],"merkleProofs":[["0x3"]]}
+	]`
+	r := httptest.NewRequest(http.MethodPost, "/prove_batch", nil)
+	r.Header.Set("Content-Type", "application/json")
+	batch, err := decodeBatch(r, []byte(body), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batch) != 2 || batch[1].StartIndex != 1 {
+		t.Fatalf("unexpected batch %+v", batch)
+	}
+
+	first, second := batch[0].Digest(), batch[1].Digest()
+	expected := sha256.Sum256(append(first[:], second[:]...))
+	if batchDigest(batch) != expected {
+		t.Fatal("expected the batch digest to hash the digests of the parameters")
+	}
+
+	if _, err = decodeBatch(r, []byte(`[]`), false); err == nil {
+		t.Fatal("expected an empty batch to be rejected")
+	}
+	if _, err = decodeBatch(r, []byte(`[{"startIndex":"x"}]`), false); err == nil || !strings.Contains(err.Error(), "parameters 0") {
+		t.Fatalf("expected the malformed parameters to be reported, got %v", err)
+	}
+}
@@ -0,0 +1,230 @@
+package server
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"worldcoin/gnark-mbu/logging"
+	"worldcoin/gnark-mbu/prover"
+	"worldcoin/gnark-mbu/redis"
+)
+
+// ProofCache stores generated proofs, so that a request retried after a
+// timeout is answered without proving the same batch again.
+type ProofCache interface {
+	// Get returns the value stored under key, if it has not expired.
+	Get(key string) ([]byte, bool, error)
+	// Set stores value under key.
+	Set(key string, value []byte) error
+}
+
+type memoryCacheEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// memoryProofCache is a ProofCache evicting the least recently used entries.
+type memoryProofCache struct {
+	size    int
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+	now     func() time.Time
+}
+
+// NewMemoryProofCache returns a ProofCache holding up to size proofs in
+// memory for ttl each, or without expiry if ttl is zero.
+func NewMemoryProofCache(size int, ttl time.Duration) ProofCache {
+	return &memoryProofCache{size: size, ttl: ttl, entries: make(map[string]*list.Element), order: list.New(), now: time.Now}
+}
+
+func (c *memoryProofCache) Get(key string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	entry := element.Value.(*memoryCacheEntry)
+	if c.ttl > 0 && !c.now().Before(entry.expires) {
+		c.order.Remove(element)
+		delete(c.entries, key)
+		return nil, false, nil
+	}
+	c.order.MoveToFront(element)
+	return entry.value, true, nil
+}
+
+func (c *memoryProofCache) Set(key string, value []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := &memoryCacheEntry{key: key, value: value, expires: c.now().Add(c.ttl)}
+	if element, ok := c.entries[key]; ok {
+		element.Value = entry
+		c.order.MoveToFront(element)
+		return nil
+	}
+	c.entries[key] = c.order.PushFront(entry)
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*memoryCacheEntry).key)
+	}
+	return nil
+}
+
+// redisProofCache is a ProofCache shared by the replicas using the same
+// Redis server. Keys expire on the server.
+type redisProofCache struct {
+	location *url.URL
+	prefix   string
+	ttl      time.Duration
+	mu       sync.Mutex
+	client   *redis.Client
+}
+
+// OpenRedisProofCache returns a ProofCache storing proofs on the Redis server
+// of a redis://[user:password@]host[:port][/<key prefix>] URL for ttl each,
+// or without expiry if ttl is zero.
+func OpenRedisProofCache(location string, ttl time.Duration) (ProofCache, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "redis" {
+		return nil, fmt.Errorf("invalid proof cache, expected redis://host/<key prefix>: %s", location)
+	}
+	prefix := strings.TrimPrefix(u.Path, "/")
+	if prefix == "" {
+		prefix = "gnark-mbu:proof:"
+	}
+	c := &redisProofCache{location: u, prefix: prefix, ttl: ttl}
+	if c.client, err = redis.Dial(u); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// do sends a command, reconnecting first if the previous one failed.
+func (c *redisProofCache) do(args ...string) (interface{}, error) {
+	c.mu.Lock()
+	client := c.client
+	if client == nil {
+		var err error
+		if client, err = redis.Dial(c.location); err != nil {
+			c.mu.Unlock()
+			return nil, err
+		}
+		c.client = client
+	}
+	c.mu.Unlock()
+	reply, err := client.Do(args...)
+	if _, ok := err.(redis.Error); err != nil && !ok {
+		c.mu.Lock()
+		if c.client == client {
+			c.client = nil
+			client.Close()
+		}
+		c.mu.Unlock()
+	}
+	return reply, err
+}
+
+func (c *redisProofCache) Get(key string) ([]byte, bool, error) {
+	reply, err := c.do("GET", c.prefix+key)
+	if err != nil {
+		return nil, false, err
+	}
+	value, ok := reply.(string)
+	return []byte(value), ok, nil
+}
+
+func (c *redisProofCache) Set(key string, value []byte) error {
+	args := []string{"SET", c.prefix + key, string(value)}
+	if c.ttl > 0 {
+		args = append(args, "PX", strconv.FormatInt(c.ttl.Milliseconds(), 10))
+	}
+	_, err := c.do(args...)
+	return err
+}
+
+// cachedProof is a proof as stored in a ProofCache.
+type cachedProof struct {
+	Proof *prover.Proof `json:"proof"`
+	// Elapsed is the time the proof took to generate, in nanoseconds.
+	Elapsed time.Duration `json:"elapsed"`
+}
+
+// proofKey identifies the proof of params by the keys it is proven with and
+// the input hash, which commits to all the parameters and must have been
+// checked.
+func proofKey(provingSystem *prover.ProvingSystem, params *prover.Parameters) (string, error) {
+	var vk bytes.Buffer
+	if _, err := provingSystem.VerifyingKey.WriteRawTo(&vk); err != nil {
+		return "", err
+	}
+	digest := sha256.Sum256(vk.Bytes())
+	return hex.EncodeToString(digest[:]) + ":" + params.InputHash.Text(16), nil
+}
+
+// proofCache looks up proofs in a ProofCache by their proofKey. Failures of
+// the cache are logged and treated as misses. A nil cache stores nothing.
+type proofCache struct {
+	store ProofCache
+}
+
+// get returns the cached proof of key, if any.
+func (c *proofCache) get(key string) (proofResult, bool) {
+	if c == nil {
+		return proofResult{}, false
+	}
+	value, ok, err := c.store.Get(key)
+	if err != nil {
+		return c.lookupFailed("get", err)
+	}
+	if !ok {
+		proofCacheLookupsCounter.WithLabelValues("miss").Inc()
+		return proofResult{}, false
+	}
+	var cached cachedProof
+	if err = json.Unmarshal(value, &cached); err != nil || cached.Proof == nil {
+		return c.lookupFailed("decode", err)
+	}
+	proofCacheLookupsCounter.WithLabelValues("hit").Inc()
+	return proofResult{proof: cached.Proof, elapsed: cached.Elapsed, cached: true}, true
+}
+
+// add stores the proof of key.
+func (c *proofCache) add(key string, res proofResult) {
+	if c == nil || res.err != nil || res.cached {
+		return
+	}
+	value, err := json.Marshal(&cachedProof{Proof: res.proof, Elapsed: res.elapsed})
+	if err != nil {
+		logProofCacheFailure("set", err)
+		return
+	}
+	if err = c.store.Set(key, value); err != nil {
+		logProofCacheFailure("set", err)
+	}
+}
+
+func (c *proofCache) lookupFailed(operation string, err error) (proofResult, bool) {
+	proofCacheLookupsCounter.WithLabelValues("error").Inc()
+	logProofCacheFailure(operation, err)
+	return proofResult{}, false
+}
+
+func logProofCacheFailure(operation string, err error) {
+	logging.Logger().Warn().Err(err).Str("operation", operation).Msg("proof cache failed")
+}
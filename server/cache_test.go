@@ -0,0 +1,129 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+	"worldcoin/gnark-mbu/prover"
+	"worldcoin/gnark-mbu/redis"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend/groth16"
+)
+
+func TestMemoryProofCache(t *testing.T) {
+	now := time.Unix(0, 0)
+	cache := NewMemoryProofCache(2, time.Minute).(*memoryProofCache)
+	cache.now = func() time.Time { return now }
+
+	cache.Set("a", []byte("1"))
+	cache.Set("b", []byte("2"))
+	if value, ok, _ := cache.Get("a"); !ok || string(value) != "1" {
+		t.Fatalf("expected a to be cached, got %q", value)
+	}
+	// b is now the least recently used entry.
+	cache.Set("c", []byte("3"))
+	if _, ok, _ := cache.Get("b"); ok {
+		t.Fatal("expected b to be evicted")
+	}
+	if _, ok, _ := cache.Get("a"); !ok {
+		t.Fatal("expected a to be kept")
+	}
+	now = now.Add(time.Minute)
+	if _, ok, _ := cache.Get("c"); ok {
+		t.Fatal("expected c to expire")
+	}
+	if len(cache.entries) != 1 || cache.order.Len() != 1 {
+		t.Fatalf("expected the expired entry to be dropped, got %d entries", len(cache.entries))
+	}
+}
+
+// fakeRedisCache serves GET and SET on conn, recording the arguments of SET.
+func fakeRedisCache(listener net.Listener, sets chan<- []interface{}) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	values := make(map[string]string)
+	for {
+		command, err := redis.ReadReply(reader)
+		if err != nil {
+			return
+		}
+		args := command.([]interface{})
+		switch args[0] {
+		case "GET":
+			value, ok := values[args[1].(string)]
+			if !ok {
+				io.WriteString(conn, "$-1\r\n")
+				continue
+			}
+			fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(value), value)
+		case "SET":
+			values[args[1].(string)] = args[2].(string)
+			sets <- args
+			io.WriteString(conn, "+OK\r\n")
+		default:
+			fmt.Fprintf(conn, "-ERR unknown command '%s'\r\n", args[0])
+		}
+	}
+}
+
+func TestProofCache(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	sets := make(chan []interface{}, 1)
+	go fakeRedisCache(listener, sets)
+	store, err := OpenRedisProofCache("redis://"+listener.Addr().String()+"/proofs:", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := &proofCache{store: store}
+
+	ps := &prover.ProvingSystem{Curve: ecc.BN254, VerifyingKey: groth16.NewVerifyingKey(ecc.BN254)}
+	params := &prover.Parameters{}
+	params.InputHash.SetInt64(42)
+	key, err := proofKey(ps, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.get(key); ok {
+		t.Fatal("expected a miss")
+	}
+	proof := &prover.Proof{Proof: groth16.NewProof(ecc.BN254)}
+	cache.add(key, proofResult{proof: proof, elapsed: time.Second})
+	set := <-sets
+	if key := set[1].(string); key[:len("proofs:")] != "proofs:" || key[len(key)-3:] != ":2a" {
+		t.Fatalf("expected the key to be prefixed and end with the input hash, got %s", key)
+	}
+	if len(set) != 5 || set[3] != "PX" || set[4] != "60000" {
+		t.Fatalf("expected the proof to expire in a minute, got %v", set[3:])
+	}
+	res, ok := cache.get(key)
+	if !ok || !res.cached || res.elapsed != time.Second || res.proof == nil {
+		t.Fatalf("expected a hit, got %+v", res)
+	}
+
+	// Proofs are not shared across input hashes.
+	params.InputHash.SetInt64(43)
+	if key, err = proofKey(ps, params); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.get(key); ok {
+		t.Fatal("expected a miss for another input hash")
+	}
+	// A nil cache stores nothing.
+	var disabled *proofCache
+	disabled.add(key, proofResult{proof: proof})
+	if _, ok := disabled.get(key); ok {
+		t.Fatal("expected a disabled cache to miss")
+	}
+}
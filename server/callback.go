@@ -0,0 +1,262 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"worldcoin/gnark-mbu/jobstore"
+	"worldcoin/gnark-mbu/logging"
+	"worldcoin/gnark-mbu/prover"
+)
+
+const (
+	// CallbackTimestampHeader is the Unix time a callback was sent at.
+	CallbackTimestampHeader = "X-Callback-Timestamp"
+	// CallbackSignatureHeader carries the hex encoded HMAC-SHA256 of the
+	// timestamp, a dot and the body of a callback, keyed with the callback
+	// secret.
+	CallbackSignatureHeader = "X-Callback-Signature"
+)
+
+func invalidCallbackURLError(message string) *Error {
+	return &Error{StatusCode: http.StatusBadRequest, Code: "invalid_callback_url", Message: message}
+}
+
+// Callbacks configures the delivery of proof results to the callback_url of
+// requests.
+type Callbacks struct {
+	// Secret keys the signatures of callbacks.
+	Secret []byte
+	// AllowedURLs are the callback URLs requests may ask for: a host, with
+	// an optional port, a *.domain wildcard, or a URL whose scheme, host and
+	// path callback URLs must start with. Callback URLs matching none of them
+	// are rejected.
+	AllowedURLs []string
+	// Attempts is the number of times a callback is tried before giving up.
+	Attempts int
+	// Backoff is the wait before the first retry, doubled for every next one
+	// up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Timeout bounds each attempt.
+	Timeout time.Duration
+}
+
+// callbackSender posts results to callback URLs in the background. A nil
+// sender rejects requests with a callback URL.
+type callbackSender struct {
+	config Callbacks
+	client *http.Client
+	// drain waits for the deliveries in flight when shutting down. It may be
+	// nil.
+	drain *drain
+	// allowAddress reports whether callbacks may connect to an address. It
+	// is checked when dialing, after the host name is resolved, so that a
+	// host resolving to an internal address is refused too.
+	allowAddress func(net.IP) bool
+	// sleep waits between attempts, returning false if cancelled first.
+	sleep func(time.Duration, <-chan struct{}) bool
+}
+
+func newCallbackSender(config Callbacks, drain *drain) *callbackSender {
+	s := &callbackSender{config: config, drain: drain, allowAddress: publicAddress, sleep: sleep}
+	dialer := &net.Dialer{Timeout: 30 * time.Second, Control: func(network, address string, _ syscall.RawConn) error {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		if ip := net.ParseIP(host); ip == nil || !s.allowAddress(ip) {
+			return fmt.Errorf("callbacks may not connect to %s", host)
+		}
+		return nil
+	}}
+	// Callbacks are not sent through a proxy, which would be dialed instead
+	// of their host, nor do they follow redirects, which could point them at
+	// any URL.
+	s.client = &http.Client{
+		Timeout:   config.Timeout,
+		Transport: &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: 10 * time.Second},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return s
+}
+
+// publicAddress refuses the loopback, private, link-local and unspecified
+// addresses, so that callbacks cannot reach the internal services and
+// metadata endpoints the server can.
+func publicAddress(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() && !ip.IsUnspecified()
+}
+
+// callbackURL returns the callback_url query parameter of r, if any.
+func (s *callbackSender) callbackURL(r *http.Request) (string, *Error) {
+	location := r.URL.Query().Get("callback_url")
+	if location == "" {
+		return "", nil
+	}
+	if s == nil {
+		return "", invalidCallbackURLError("callbacks are not enabled on this server")
+	}
+	u, err := url.Parse(location)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil {
+		return "", invalidCallbackURLError(fmt.Sprintf("expected an absolute http or https URL, got %q", location))
+	}
+	if !s.allowed(u) {
+		return "", invalidCallbackURLError(fmt.Sprintf("%q is not an allowed callback URL", location))
+	}
+	return location, nil
+}
+
+// allowed reports whether u matches one of the allowed callback URLs.
+func (s *callbackSender) allowed(u *url.URL) bool {
+	for _, entry := range s.config.AllowedURLs {
+		switch {
+		case strings.Contains(entry, "://"):
+			prefix, err := url.Parse(entry)
+			if err != nil || !strings.EqualFold(prefix.Scheme, u.Scheme) || !strings.EqualFold(prefix.Host, u.Host) {
+				continue
+			}
+			// Prefixes match whole path segments, so that /proofs does not
+			// allow /proofs-admin.
+			path := strings.TrimSuffix(prefix.Path, "/")
+			if u.Path == path || strings.HasPrefix(u.Path, path+"/") {
+				return true
+			}
+		case strings.HasPrefix(entry, "*."):
+			if strings.HasSuffix(strings.ToLower(u.Hostname()), strings.ToLower(entry[1:])) {
+				return true
+			}
+		case strings.Contains(entry, ":"):
+			if strings.EqualFold(u.Host, entry) {
+				return true
+			}
+		default:
+			if strings.EqualFold(u.Hostname(), entry) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// proofCallback is the body of the callbacks of /prove requests.
+type proofCallback struct {
+	RequestID string          `json:"requestId"`
+	Status    jobstore.Status `json:"status"`
+	Proof     json.Marshaler  `json:"proof,omitempty"`
+	Error     *resultError    `json:"error,omitempty"`
+}
+
+func newProofCallback(requestId string, res proofResult, encoding prover.ProofEncoding, numbers prover.NumberFormat) *proofCallback {
+	if res.err != nil {
+		proofErr := proofError(res.err)
+		return &proofCallback{RequestID: requestId, Status: jobstore.StatusFailed, Error: &resultError{Code: proofErr.Code, Message: proofErr.Message}}
+	}
+	return &proofCallback{RequestID: requestId, Status: jobstore.StatusSucceeded, Proof: res.proof.Encoded(encoding, numbers)}
+}
+
+// send posts payload as JSON to location in the background. Deliveries are
+// tracked by the drain, so that shutting down waits for them up to the grace
+// period and then gives up their retries.
+func (s *callbackSender) send(location string, payload interface{}) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		logging.Logger().Error().Err(err).Str("callbackUrl", location).Msg("failed to encode callback")
+		callbackDeliveriesCounter.WithLabelValues("failed").Inc()
+		return
+	}
+	if s.drain != nil && !s.drain.hold() {
+		logging.Logger().Error().Str("callbackUrl", location).Msg("callback dropped, the server has shut down")
+		callbackDeliveriesCounter.WithLabelValues("failed").Inc()
+		return
+	}
+	go func() {
+		if s.drain != nil {
+			defer s.drain.exit()
+		}
+		s.deliver(location, body)
+	}()
+}
+
+// deliver posts body to location until it is accepted, retrying failed
+// attempts with exponential backoff. Client errors other than 408 and 429
+// are not retried, nor are attempts once the drain cancels in-flight work.
+func (s *callbackSender) deliver(location string, body []byte) bool {
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	if s.drain != nil {
+		ctx, cancel = s.drain.context(context.Background())
+	}
+	defer cancel()
+	start := time.Now()
+	backoff := s.config.Backoff
+	for attempt := 1; ; attempt++ {
+		retry, err := s.attempt(ctx, location, body)
+		if err == nil {
+			callbackDeliveriesCounter.WithLabelValues("delivered").Inc()
+			callbackDeliveryDurationHistogram.Observe(time.Since(start).Seconds())
+			return true
+		}
+		logger := logging.Logger().With().Err(err).Str("callbackUrl", location).Int("attempt", attempt).Logger()
+		if !retry || attempt >= s.config.Attempts {
+			logger.Error().Msg("callback delivery failed")
+			callbackDeliveriesCounter.WithLabelValues("failed").Inc()
+			return false
+		}
+		logger.Warn().Dur("backoff", backoff).Msg("callback attempt failed, retrying")
+		if !s.sleep(backoff, ctx.Done()) {
+			logger.Error().Msg("callback delivery cancelled by the shutdown")
+			callbackDeliveriesCounter.WithLabelValues("failed").Inc()
+			return false
+		}
+		if backoff *= 2; s.config.MaxBackoff > 0 && backoff > s.config.MaxBackoff {
+			backoff = s.config.MaxBackoff
+		}
+	}
+}
+
+// attempt posts body once and reports whether a failure is worth retrying.
+func (s *callbackSender) attempt(ctx context.Context, location string, body []byte) (bool, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, location, bytes.NewReader(body))
+	if err != nil {
+		callbackAttemptsCounter.WithLabelValues("error").Inc()
+		return false, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(CallbackTimestampHeader, timestamp)
+	request.Header.Set(CallbackSignatureHeader, signCallback(s.config.Secret, timestamp, body))
+	response, err := s.client.Do(request)
+	if err != nil {
+		callbackAttemptsCounter.WithLabelValues("error").Inc()
+		return true, err
+	}
+	response.Body.Close()
+	callbackAttemptsCounter.WithLabelValues(strconv.Itoa(response.StatusCode/100) + "xx").Inc()
+	if response.StatusCode/100 == 2 {
+		return false, nil
+	}
+	retry := response.StatusCode >= 500 || response.StatusCode == http.StatusRequestTimeout || response.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("callback answered %s", response.Status)
+}
+
+// signCallback returns the CallbackSignatureHeader of a callback.
+func signCallback(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
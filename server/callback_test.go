@@ -0,0 +1,162 @@
+package server
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCallbackDelivery(t *testing.T) {
+	secret := []byte("secret")
+	statuses := []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK}
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get(CallbackSignatureHeader) != signCallback(secret, r.Header.Get(CallbackTimestampHeader), body) {
+			t.Errorf("unexpected signature %s", r.Header.Get(CallbackSignatureHeader))
+		}
+		if string(body) != `{"status":"succeeded"}` {
+			t.Errorf("unexpected body %s", body)
+		}
+		w.WriteHeader(statuses[attempts])
+		attempts++
+	}))
+	defer server.Close()
+
+	var backoffs []time.Duration
+	sender := newCallbackSender(Callbacks{Secret: secret, Attempts: 3, Backoff: time.Second, MaxBackoff: 1500 * time.Millisecond}, nil)
+	sender.allowAddress = func(net.IP) bool { return true }
+	sender.sleep = func(backoff time.Duration, _ <-chan struct{}) bool {
+		backoffs = append(backoffs, backoff)
+		return true
+	}
+	delivered := testutil.ToFloat64(callbackDeliveriesCounter.WithLabelValues("delivered"))
+
+	if !sender.deliver(server.URL, []byte(`{"status":"succeeded"}`)) {
+		t.Fatal("expected the callback to be delivered")
+	}
+	if attempts != 3 || len(backoffs) != 2 || backoffs[0] != time.Second || backoffs[1] != 1500*time.Millisecond {
+		t.Fatalf("unexpected attempts %d with backoffs %v", attempts, backoffs)
+	}
+	if testutil.ToFloat64(callbackDeliveriesCounter.WithLabelValues("delivered")) != delivered+1 {
+		t.Fatal("expected the delivery to be counted")
+	}
+
+	// Client errors are not retried, and attempts run out.
+	for _, status := range []int{http.StatusBadRequest, http.StatusInternalServerError} {
+		attempts, statuses = 0, []int{status, status, status}
+		if sender.deliver(server.URL, []byte(`{"status":"succeeded"}`)) {
+			t.Fatalf("expected the callback answered %d to fail", status)
+		}
+		if expected := map[int]int{http.StatusBadRequest: 1, http.StatusInternalServerError: 3}[status]; attempts != expected {
+			t.Fatalf("expected %d attempts for %d, got %d", expected, status, attempts)
+		}
+	}
+}
+
+func TestCallbackURL(t *testing.T) {
+	sender := newCallbackSender(Callbacks{Attempts: 1, AllowedURLs: []string{"sequencer", "10.0.0.1:8080", "*.example.com", "https://relay/proofs/"}}, nil)
+	for location, valid := range map[string]bool{
+		"":                                   true,
+		"https://sequencer/proofs?id=7":      true,
+		"http://10.0.0.1:8080/callback":      true,
+		"https://prover.example.com/results": true,
+		"https://relay/proofs/7":             true,
+		"https://relay/proofs":               true,
+		"http://10.0.0.1/callback":           false,
+		"https://example.com/results":        false,
+		"https://relay/proofs-admin":         false,
+		"http://relay/proofs/7":              false,
+		"https://sequencer.evil/proofs":      false,
+		"https://user@sequencer/proofs":      false,
+		"ftp://sequencer/proofs":             false,
+		"/proofs":                            false,
+		"https://":                           false,
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/prove", nil)
+		r.URL.RawQuery = "callback_url=" + location
+		callbackURL, err := sender.callbackURL(r)
+		if (err == nil) != valid || (valid && callbackURL != location) {
+			t.Errorf("%q: unexpected result %q, %v", location, callbackURL, err)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/prove?callback_url=https://sequencer", nil)
+	if _, err := (*callbackSender)(nil).callbackURL(r); err == nil || err.Code != "invalid_callback_url" {
+		t.Fatalf("expected callbacks to be rejected when disabled, got %v", err)
+	}
+}
+
+func TestCallbackConnections(t *testing.T) {
+	for ip, public := range map[string]bool{
+		"8.8.8.8":         true,
+		"2001:4860::8888": true,
+		"127.0.0.1":       false,
+		"10.1.2.3":        false,
+		"192.168.0.1":     false,
+		"169.254.169.254": false,
+		"::1":             false,
+		"fe80::1":         false,
+		"fd00::1":         false,
+		"0.0.0.0":         false,
+	} {
+		if publicAddress(net.ParseIP(ip)) != public {
+			t.Errorf("%s: expected public to be %v", ip, public)
+		}
+	}
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.Redirect(w, r, "/elsewhere", http.StatusTemporaryRedirect)
+	}))
+	defer server.Close()
+
+	// Loopback addresses are refused when dialing.
+	sender := newCallbackSender(Callbacks{Attempts: 1}, nil)
+	if sender.deliver(server.URL, []byte(`{}`)) || requests != 0 {
+		t.Fatalf("expected the loopback callback to be refused, got %d requests", requests)
+	}
+
+	// Redirects are not followed.
+	sender.allowAddress = func(net.IP) bool { return true }
+	if sender.deliver(server.URL, []byte(`{}`)) || requests != 1 {
+		t.Fatalf("expected the redirect to fail the callback, got %d requests", requests)
+	}
+}
+
+func TestCallbackDrain(t *testing.T) {
+	received := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- struct{}{}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	d := newDrain()
+	sender := newCallbackSender(Callbacks{Attempts: 3, Backoff: time.Hour}, d)
+	sender.allowAddress = func(net.IP) bool { return true }
+	sender.send(server.URL, struct{}{})
+	<-received
+
+	// The drain waits for the delivery, and cancels its retries once the
+	// grace period runs out.
+	drained := make(chan struct{})
+	go func() {
+		d.run(10 * time.Millisecond)
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the drain to cancel the callback retries")
+	}
+	if d.hold() {
+		t.Fatal("expected no delivery to start once drained")
+	}
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"worldcoin/gnark-mbu/prover"
+)
+
+// verifyChainHandler verifies a sequence of proofs of consecutive batches,
+// e.g. the history of a sequencer.
+type verifyChainHandler struct {
+	system *activeSystem
+	limits RequestLimits
+}
+
+type chainLinkJSON struct {
+	InputHash string       `json:"inputHash"`
+	PreRoot   string       `json:"preRoot"`
+	PostRoot  string       `json:"postRoot"`
+	Proof     prover.Proof `json:"proof"`
+}
+
+type verifyChainRequest struct {
+	Proofs []chainLinkJSON `json:"proofs"`
+}
+
+// Codes of the failures of /verify_chain.
+const (
+	chainInvalidProof      = "invalid_proof"
+	chainRootDiscontinuity = "root_discontinuity"
+)
+
+// chainFailure is a proof of a chain that does not verify, or whose pre root
+// is not the post root of the previous one.
+type chainFailure struct {
+	Index   int    `json:"index"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type verifyChainResponse struct {
+	Valid bool `json:"valid"`
+	// Failures are ordered by index, the proof of a link being checked
+	// before its pre root.
+	Failures []chainFailure `json:"failures,omitempty"`
+}
+
+// chainLink is a parsed link of a chain.
+type chainLink struct {
+	inputHash, preRoot, postRoot big.Int
+	proof                        *prover.Proof
+}
+
+func parseChainLinks(request *verifyChainRequest) ([]chainLink, error) {
+	if len(request.Proofs) == 0 {
+		return nil, errors.New("the chain has no proofs")
+	}
+	links := make([]chainLink, len(request.Proofs))
+	for i := range request.Proofs {
+		link, parsed := &request.Proofs[i], &links[i]
+		var err error
+		if parsed.inputHash, err = parseNumber("inputHash", link.InputHash); err == nil {
+			if parsed.preRoot, err = parseNumber("preRoot", link.PreRoot); err == nil {
+				parsed.postRoot, err = parseNumber("postRoot", link.PostRoot)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("proof %d: %w", i, err)
+		}
+		parsed.proof = &link.Proof
+	}
+	return links, nil
+}
+
+// verifyChain verifies every link of a chain, and checks that every pre root
+// is the post root of the previous link.
+func verifyChain(provingSystem *prover.ProvingSystem, links []chainLink) []chainFailure {
+	var failures []chainFailure
+	for i := range links {
+		link := &links[i]
+		var err error
+		if provingSystem.PublicPostRoot {
+			err = provingSystem.VerifyWithPostRoot(link.inputHash, link.postRoot, link.proof)
+		} else {
+			err = provingSystem.Verify(link.inputHash, link.proof)
+		}
+		if err != nil {
+			failures = append(failures, chainFailure{Index: i, Code: chainInvalidProof, Message: err.Error()})
+		}
+		if i > 0 && link.preRoot.Cmp(&links[i-1].postRoot) != 0 {
+			failures = append(failures, chainFailure{
+				Index:   i,
+				Code:    chainRootDiscontinuity,
+				Message: fmt.Sprintf("pre root %#x is not the post root %#x of proof %d", &link.preRoot, &links[i-1].postRoot, i-1),
+			})
+		}
+	}
+	return failures
+}
+
+func (handler verifyChainHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	g := handler.system.acquire()
+	defer g.release()
+	provingSystem := handler.system.verifying(g)
+	if provingSystem == nil {
+		verifierUnavailableError().send(w)
+		return
+	}
+	buf, readErr := handler.limits.readBody(w, r)
+	if readErr != nil {
+		readErr.send(w)
+		return
+	}
+	var request verifyChainRequest
+	if err := json.Unmarshal(buf, &request); err != nil {
+		malformedBodyError(err).send(w)
+		return
+	}
+	links, err := parseChainLinks(&request)
+	if err != nil {
+		malformedBodyError(err).send(w)
+		return
+	}
+	failures := verifyChain(provingSystem, links)
+	responseBytes, err := json.Marshal(&verifyChainResponse{Valid: len(failures) == 0, Failures: failures})
+	if err != nil {
+		unexpectedError(err).send(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(responseBytes)
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"worldcoin/gnark-mbu/prover"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend/groth16"
+)
+
+func TestVerifyChain(t *testing.T) {
+	// The keys verify no proof, so that every link fails to verify on top
+	// of the discontinuities.
+	ps := &prover.ProvingSystem{Curve: ecc.BN254, VerifyingKey: groth16.NewVerifyingKey(ecc.BN254)}
+	handler := verifyChainHandler{system: newActiveSystem(ps)}
+	proof, err := json.Marshal(&prover.Proof{Proof: groth16.NewProof(ecc.BN254)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	link := func(preRoot, postRoot string) string {
+		return `{"inputHash": "0x2a", "preRoot": "` + preRoot + `", "postRoot": "` + postRoot + `", "proof": ` + string(proof) + `}`
+	}
+	verify := func(body string) (*httptest.ResponseRecorder, verifyChainResponse) {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/verify_chain", strings.NewReader(body)))
+		var response verifyChainResponse
+		if recorder.Code == http.StatusOK {
+			if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return recorder, response
+	}
+
+	recorder, response := verify(`{"proofs": [` + link("0x1", "0x2") + `, ` + link("0x2", "0x3") + `, ` + link("0x4", "0x5") + `]}`)
+	if recorder.Code != http.StatusOK || response.Valid {
+		t.Fatalf("expected an invalid chain, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	var discontinuities []int
+	for _, failure := range response.Failures {
+		if failure.Code == chainRootDiscontinuity {
+			discontinuities = append(discontinuities, failure.Index)
+		}
+	}
+	if len(discontinuities) != 1 || discontinuities[0] != 2 {
+		t.Fatalf("expected proof 2 not to follow proof 1, got %+v", response.Failures)
+	}
+	if len(response.Failures) != 4 {
+		t.Fatalf("expected every proof to fail to verify, got %+v", response.Failures)
+	}
+
+	for _, body := range []string{`{"proofs": []}`, `{"proofs": [` + link("0x1", "root") + `]}`, `[]`} {
+		if recorder, _ = verify(body); recorder.Code != http.StatusBadRequest || !strings.Contains(recorder.Body.String(), "malformed_body") {
+			t.Fatalf("expected %s to be rejected, got %d %s", body, recorder.Code, recorder.Body.String())
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"worldcoin/gnark-mbu/logging"
+	"worldcoin/gnark-mbu/prover"
+)
+
+// checkHandler solves the circuit for a batch without proving it, so that
+// sequencer developers learn whether a batch is valid, and which constraint
+// it fails, in a fraction of the proving time. Requests are decoded and
+// authenticated like /prove, but do not wait in the proof queue.
+type checkHandler struct {
+	proveHandler
+}
+
+type checkResponse struct {
+	Satisfied bool   `json:"satisfied"`
+	Code      string `json:"code,omitempty"`
+	Message   string `json:"message,omitempty"`
+}
+
+func (handler checkHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set(CircuitVersionHeader, prover.CircuitSemver)
+	if limitErr := handler.limiter.allowIP(r); limitErr != nil {
+		limitErr.send(w)
+		return
+	}
+	if handler.system.current.Load().provingSystem == nil {
+		proverUnavailableError().send(w)
+		return
+	}
+	buf, readErr := handler.limits.readBody(w, r)
+	if readErr != nil {
+		readErr.send(w)
+		return
+	}
+	params, decodeErr := handler.limits.decodeParameters(r, buf, handler.legacyJSON)
+	if decodeErr != nil {
+		decodeErr.send(w)
+		return
+	}
+	logBatchSize(r, len(params.IdComms))
+	digest := params.Digest()
+	clientId, authErr := authenticate(r, digest, handler.clientKeys, handler.requireSignatures)
+	if authErr != nil {
+		authErr.send(w)
+		return
+	}
+	if limitErr := handler.limiter.allowClient(clientId); limitErr != nil {
+		limitErr.send(w)
+		return
+	}
+	if circuitErr := allowCircuit(r, paramsShape(params)); circuitErr != nil {
+		circuitErr.send(w)
+		return
+	}
+	audit := logging.Audit().With().Str("requestId", requestID(r)).Str("clientId", clientId).Str("digest", hex.EncodeToString(digest[:])).Str("remoteAddr", r.RemoteAddr).Logger()
+	audit.Info().Bool("authenticated", clientId != "").Msg("check requested")
+	g := handler.systemFor(params).acquire()
+	defer g.release()
+	provingSystem := g.provingSystem
+	if provingSystem == nil {
+		proverUnavailableError().send(w)
+		return
+	}
+	response := checkResponse{Satisfied: true}
+	if err := provingSystem.Check(params); err != nil {
+		audit.Info().Err(err).Msg("check failed")
+		checkErr := proverError(err)
+		response = checkResponse{Code: checkErr.Code, Message: checkErr.Message}
+	}
+	responseBytes, err := json.Marshal(&response)
+	if err != nil {
+		unexpectedError(err).send(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(responseBytes)
+}
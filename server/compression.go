@@ -0,0 +1,193 @@
+package server
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Content encodings of request and response bodies. Batches of Merkle proofs
+// compress about tenfold, which matters for clients behind slow links.
+const (
+	encodingGzip = "gzip"
+	encodingZstd = "zstd"
+)
+
+func unsupportedEncodingError(encoding string) *Error {
+	return &Error{
+		StatusCode: http.StatusUnsupportedMediaType,
+		Code:       "unsupported_content_encoding",
+		Message:    fmt.Sprintf("unsupported content encoding %q, expected gzip, zstd or identity", encoding),
+	}
+}
+
+var zstdDecoders = sync.Pool{New: func() interface{} {
+	decoder, _ := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true))
+	return decoder
+}}
+
+// decodedBody returns the reader of body decoded as the Content-Encoding of
+// r, and a function releasing it.
+func decodedBody(r *http.Request, body io.Reader) (io.Reader, func(), *Error) {
+	switch encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))); encoding {
+	case "", "identity":
+		return body, func() {}, nil
+	case encodingGzip:
+		reader, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, nil, malformedBodyError(err)
+		}
+		return reader, func() { reader.Close() }, nil
+	case encodingZstd:
+		decoder := zstdDecoders.Get().(*zstd.Decoder)
+		if err := decoder.Reset(body); err != nil {
+			zstdDecoders.Put(decoder)
+			return nil, nil, malformedBodyError(err)
+		}
+		return decoder, func() {
+			decoder.Reset(nil)
+			zstdDecoders.Put(decoder)
+		}, nil
+	default:
+		return nil, nil, unsupportedEncodingError(encoding)
+	}
+}
+
+// responseEncoding picks the encoding of a response from the Accept-Encoding
+// of r, preferring zstd over gzip when both are acceptable, or returns an
+// empty string to leave it uncompressed.
+func responseEncoding(r *http.Request) string {
+	var chosen string
+	best := 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "*" {
+			name = encodingZstd
+		}
+		if name != encodingGzip && name != encodingZstd {
+			continue
+		}
+		quality := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			quality = parsed
+		}
+		if quality > best || (quality == best && quality > 0 && name == encodingZstd) {
+			chosen, best = name, quality
+		}
+	}
+	return chosen
+}
+
+// encoder is a compressing writer that can flush what it compressed so far.
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+}
+
+var (
+	gzipEncoders = sync.Pool{New: func() interface{} { return gzip.NewWriter(nil) }}
+	zstdEncoders = sync.Pool{New: func() interface{} {
+		encoder, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1), zstd.WithEncoderLevel(zstd.SpeedFastest))
+		return encoder
+	}}
+)
+
+// compressWriter compresses a response in the chosen encoding, unless it has
+// no body.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding    string
+	encoder     encoder
+	wroteHeader bool
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	header := w.Header()
+	if status != http.StatusNoContent && status != http.StatusNotModified && header.Get("Content-Encoding") == "" {
+		header.Del("Content-Length")
+		header.Set("Content-Encoding", w.encoding)
+		if w.encoding == encodingGzip {
+			encoder := gzipEncoders.Get().(*gzip.Writer)
+			encoder.Reset(w.ResponseWriter)
+			w.encoder = encoder
+		} else {
+			encoder := zstdEncoders.Get().(*zstd.Encoder)
+			encoder.Reset(w.ResponseWriter)
+			w.encoder = encoder
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.encoder == nil {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.encoder.Write(b)
+}
+
+// Flush lets /prove_batch and /prove_split stream their results compressed.
+func (w *compressWriter) Flush() {
+	if w.encoder != nil {
+		w.encoder.Flush()
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the writer of the uncompressed response.
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// close completes the compressed body and returns the encoder to its pool.
+func (w *compressWriter) close() {
+	if w.encoder == nil {
+		return
+	}
+	w.encoder.Close()
+	switch encoder := w.encoder.(type) {
+	case *gzip.Writer:
+		gzipEncoders.Put(encoder)
+	case *zstd.Encoder:
+		encoder.Reset(nil)
+		zstdEncoders.Put(encoder)
+	}
+}
+
+// compressResponses compresses the responses of the requests accepting gzip
+// or zstd. Responses are compressed as they are written, so that streamed
+// ones are not buffered.
+func compressResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := responseEncoding(r)
+		// Upgraded connections, such as those of /prove/ws, have no body.
+		if encoding == "" || r.Method == http.MethodHead || r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		writer := &compressWriter{ResponseWriter: w, encoding: encoding}
+		defer writer.close()
+		next.ServeHTTP(writer, r)
+	})
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func compress(t *testing.T, encoding string, data []byte) []byte {
+	var buf bytes.Buffer
+	var encoder io.WriteCloser
+	if encoding == encodingGzip {
+		encoder = gzip.NewWriter(&buf)
+	} else {
+		var err error
+		if encoder, err = zstd.NewWriter(&buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	encoder.Write(data)
+	encoder.Close()
+	return buf.Bytes()
+}
+
+func decompress(t *testing.T, encoding string, data []byte) []byte {
+	var reader io.Reader
+	var err error
+	if encoding == encodingGzip {
+		reader, err = gzip.NewReader(bytes.NewReader(data))
+	} else {
+		reader, err = zstd.NewReader(bytes.NewReader(data))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return decoded
+}
+
+func TestCompressedRequests(t *testing.T) {
+	body := []byte(`{"idComms": ["0x1", "0x2"]}` + strings.Repeat(" ", 100))
+	limits := RequestLimits{MaxBodyBytes: 200}
+	for _, encoding := range []string{encodingGzip, encodingZstd} {
+		r := httptest.NewRequest(http.MethodPost, "/prove", bytes.NewReader(compress(t, encoding, body)))
+		r.Header.Set("Content-Encoding", encoding)
+		buf, err := limits.readBody(httptest.NewRecorder(), r)
+		if err != nil || !bytes.Equal(buf, body) {
+			t.Fatalf("expected the %s body to be decoded, got %s, %v", encoding, buf, err)
+		}
+
+		// The limit applies to the decoded body.
+		r = httptest.NewRequest(http.MethodPost, "/prove", bytes.NewReader(compress(t, encoding, bytes.Repeat(body, 3))))
+		r.Header.Set("Content-Encoding", encoding)
+		if _, err = limits.readBody(httptest.NewRecorder(), r); err == nil || err.Code != "request_too_large" {
+			t.Fatalf("expected the decoded %s body to be too large, got %v", encoding, err)
+		}
+
+		r = httptest.NewRequest(http.MethodPost, "/prove", strings.NewReader("not compressed"))
+		r.Header.Set("Content-Encoding", encoding)
+		if _, err = limits.readBody(httptest.NewRecorder(), r); err == nil || err.Code != "malformed_body" {
+			t.Fatalf("expected the invalid %s body to be rejected, got %v", encoding, err)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/prove", bytes.NewReader(body))
+	r.Header.Set("Content-Encoding", "br")
+	if _, err := limits.readBody(httptest.NewRecorder(), r); err == nil || err.StatusCode != http.StatusUnsupportedMediaType {
+		t.Fatalf("expected the encoding to be unsupported, got %v", err)
+	}
+}
+
+func TestResponseEncoding(t *testing.T) {
+	for acceptEncoding, expected := range map[string]string{
+		"":                        "",
+		"br":                      "",
+		"gzip":                    encodingGzip,
+		"gzip, deflate, br, zstd": encodingZstd,
+		"zstd;q=0.5, gzip":        encodingGzip,
+		"zstd;q=0, gzip;q=0":      "",
+		"*":                       encodingZstd,
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/info", nil)
+		r.Header.Set("Accept-Encoding", acceptEncoding)
+		if encoding := responseEncoding(r); encoding != expected {
+			t.Fatalf("expected %q for %q, got %q", expected, acceptEncoding, encoding)
+		}
+	}
+}
+
+func TestCompressedResponses(t *testing.T) {
+	lines := []string{`{"index":0}`, `{"index":1}`}
+	handler := compressResponses(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/empty" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		for _, line := range lines {
+			w.Write([]byte(line + "\n"))
+			w.(http.Flusher).Flush()
+		}
+	}))
+	for _, encoding := range []string{encodingGzip, encodingZstd} {
+		r := httptest.NewRequest(http.MethodPost, "/prove_batch", nil)
+		r.Header.Set("Accept-Encoding", encoding)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Header().Get("Content-Encoding") != encoding || w.Header().Get("Vary") != "Accept-Encoding" {
+			t.Fatalf("unexpected headers %v", w.Header())
+		}
+		if decoded := string(decompress(t, encoding, w.Body.Bytes())); decoded != strings.Join(lines, "\n")+"\n" {
+			t.Fatalf("unexpected %s response %q", encoding, decoded)
+		}
+
+		r = httptest.NewRequest(http.MethodPost, "/empty", nil)
+		r.Header.Set("Accept-Encoding", encoding)
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != http.StatusNoContent || w.Header().Get("Content-Encoding") != "" || w.Body.Len() != 0 {
+			t.Fatalf("expected empty responses to be left uncompressed, got %d %v", w.Code, w.Header())
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/prove_batch", nil))
+	if w.Header().Get("Content-Encoding") != "" || w.Body.String() != strings.Join(lines, "\n")+"\n" {
+		t.Fatalf("expected the response to be left uncompressed, got %v", w.Header())
+	}
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"worldcoin/gnark-mbu/prover"
+)
+
+// requestFormat returns the binary format of the body of r, or an empty
+// format for JSON.
+func requestFormat(r *http.Request) prover.BinaryFormat {
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	format, _ := prover.ParseBinaryContentType(contentType)
+	return format
+}
+
+// acceptedType is a media type of an Accept header with its quality.
+type acceptedType struct {
+	mediaType string
+	quality   float64
+}
+
+// acceptedTypes parses an Accept header, skipping malformed and unacceptable
+// media types.
+func acceptedTypes(accept string) []acceptedType {
+	var types []acceptedType
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if value, ok := params["q"]; ok {
+			if quality, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+		if quality > 0 {
+			types = append(types, acceptedType{mediaType, quality})
+		}
+	}
+	return types
+}
+
+// responseFormat picks the format of a proof from the Accept header of r,
+// preferring JSON on ties. Requests accepting any type, or none they can be
+// served in, get a proof in the format of their body.
+func responseFormat(r *http.Request) prover.BinaryFormat {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return requestFormat(r)
+	}
+	var chosen prover.BinaryFormat
+	best, found := 0.0, false
+	for _, accepted := range acceptedTypes(accept) {
+		mediaType, quality := accepted.mediaType, accepted.quality
+		var format prover.BinaryFormat
+		switch mediaType {
+		case "application/json":
+		case "*/*", "application/*":
+			format = requestFormat(r)
+		default:
+			var ok bool
+			if format, ok = prover.ParseBinaryContentType(mediaType); !ok {
+				continue
+			}
+		}
+		if !found || quality > best || (quality == best && format == "") {
+			chosen, best, found = format, quality, true
+		}
+	}
+	if !found {
+		return requestFormat(r)
+	}
+	return chosen
+}
+
+// proofFormat returns the wire format of the proof requested by the format
+// query parameter of r or, failing that, preferred by its Accept header over
+// JSON and the binary formats. It returns an empty format for the others.
+func proofFormat(r *http.Request) (prover.ProofFormat, *Error) {
+	if name := r.URL.Query().Get("format"); name != "" {
+		format, err := prover.ParseProofFormat(name)
+		if err != nil {
+			return "", invalidEncodingError(err)
+		}
+		return format, nil
+	}
+	var chosen prover.ProofFormat
+	best, other := 0.0, 0.0
+	for _, accepted := range acceptedTypes(r.Header.Get("Accept")) {
+		if format, ok := prover.ParseProofContentType(accepted.mediaType); ok {
+			if accepted.quality > best {
+				chosen, best = format, accepted.quality
+			}
+		} else if accepted.quality > other {
+			other = accepted.quality
+		}
+	}
+	if best <= other {
+		return "", nil
+	}
+	return chosen, nil
+}
+
+// encodeProof encodes a proof, with its metadata unless it is nil, in format
+// and returns it with its content type.
+func encodeProof(format prover.BinaryFormat, proof *prover.Proof, encoding prover.ProofEncoding, numbers prover.NumberFormat, metadata *proofMetadata) ([]byte, string, error) {
+	if format == "" {
+		var response interface{} = proof.Encoded(encoding, numbers)
+		if metadata != nil {
+			response = &proofWithMetadata{Proof: response, Metadata: metadata}
+		}
+		responseBytes, err := json.Marshal(response)
+		return responseBytes, "application/json", err
+	}
+	binaryProof, err := proof.Binary(encoding)
+	if err != nil {
+		return nil, "", err
+	}
+	var response interface{} = binaryProof
+	if metadata != nil {
+		response = &proofWithMetadata{Proof: binaryProof, Metadata: metadata}
+	}
+	responseBytes, err := format.Marshal(response)
+	return responseBytes, format.ContentType(), err
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"bytes"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"worldcoin/gnark-mbu/prover"
+
+	"github.com/consensys/gnark-crypto/ecc"
+)
+
+func TestResponseFormat(t *testing.T) {
+	for _, test := range []struct {
+		contentType, accept string
+		expected            prover.BinaryFormat
+	}{
+		{"application/json", "", ""},
+		{"application/cbor", "", prover.BinaryFormatCBOR},
+		{"application/json", "application/msgpack", prover.BinaryFormatMsgpack},
+		{"application/json", "application/x-msgpack;q=0.5, application/cbor", prover.BinaryFormatCBOR},
+		{"application/json", "application/cbor, application/json", ""},
+		{"application/msgpack", "*/*", prover.BinaryFormatMsgpack},
+		{"application/msgpack", "application/cbor;q=0, text/html", prover.BinaryFormatMsgpack},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/prove", nil)
+		r.Header.Set("Content-Type", test.contentType)
+		r.Header.Set("Accept", test.accept)
+		if format := responseFormat(r); format != test.expected {
+			t.Errorf("expected %q for %q accepting %q, got %q", test.expected, test.contentType, test.accept, format)
+		}
+	}
+}
+
+func TestProofFormat(t *testing.T) {
+	for _, test := range []struct {
+		query, accept string
+		expected      prover.ProofFormat
+	}{
+		{"", "", ""},
+		{"", "application/json", ""},
+		{"?format=snarkjs", "application/json", prover.ProofFormatSnarkJS},
+		{"", "application/octet-stream", prover.ProofFormatRaw},
+		{"", "application/vnd.gnark-mbu.evm+json, application/json", ""},
+		{"", "application/json;q=0.5, application/vnd.snarkjs+json", prover.ProofFormatSnarkJS},
+		{"", "text/plain, */*;q=0.1", prover.ProofFormatBase64},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/prove"+test.query, nil)
+		r.Header.Set("Accept", test.accept)
+		if format, err := proofFormat(r); err != nil || format != test.expected {
+			t.Errorf("expected %q for %q accepting %q, got %q (%v)", test.expected, test.query, test.accept, format, err)
+		}
+	}
+	r := httptest.NewRequest(http.MethodPost, "/prove?format=pem", nil)
+	if _, err := proofFormat(r); err == nil || err.Code != "invalid_encoding" {
+		t.Fatalf("expected an unknown format to be rejected, got %v", err)
+	}
+}
+
+func TestDecodeBinaryParameters(t *testing.T) {
+	params := &prover.Parameters{StartIndex: 7, PreRoot: *big.NewInt(1), PostRoot: *big.NewInt(2), IdComms: []big.Int{*big.NewInt(3)}}
+	for _, format := range []prover.BinaryFormat{prover.BinaryFormatCBOR, prover.BinaryFormatMsgpack} {
+		body, err := format.Marshal(params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest(http.MethodPost, "/prove", bytes.NewReader(body))
+		r.Header.Set("Content-Type", format.ContentType())
+		// Binary bodies are decoded even when JSON defaults to the legacy
+		// format.
+		decoded, decodeErr := RequestLimits{}.decodeParameters(r, body, true)
+		if decodeErr != nil {
+			t.Fatal(decodeErr.Message)
+		}
+		if decoded.Digest() != params.Digest() {
+			t.Fatalf("expected the %s parameters to be decoded, got %+v", format, decoded)
+		}
+	}
+}
+
+func TestProveRejectsOversizedMsgpackBatch(t *testing.T) {
+	ps := &prover.ProvingSystem{Curve: ecc.BN254, TreeDepth: 2, BatchSize: 2}
+	handler := proveHandler{system: newActiveSystem(ps), drain: newDrain(), limits: RequestLimits{MaxBatchSize: 2}}
+	params := &prover.Parameters{IdComms: make([]big.Int, 3), MerkleProofs: make([][]big.Int, 3)}
+	body, err := prover.BinaryFormatMsgpack.Marshal(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/prove", bytes.NewReader(body))
+	r.Header.Set("Content-Type", prover.BinaryFormatMsgpack.ContentType())
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, r)
+	if recorder.Code != http.StatusRequestEntityTooLarge || !strings.Contains(recorder.Body.String(), "request_too_large") {
+		t.Fatalf("expected the msgpack batch to be rejected, got %d: %s", recorder.Code, recorder.Body)
+	}
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORS lets browsers call the prover API from other origins, such as
+// internal dashboards.
+type CORS struct {
+	// AllowedOrigins are the origins allowed to call the API, "*" allowing
+	// any.
+	AllowedOrigins []string
+	// AllowedMethods are the methods allowed to be used from them, GET and
+	// POST if empty.
+	AllowedMethods []string
+	// MaxAge is the time browsers may cache the result of a preflight
+	// request. Zero leaves it to the browser.
+	MaxAge time.Duration
+}
+
+// corsAllowedHeaders are the request headers of the API browsers may send.
+var corsAllowedHeaders = []string{"Content-Type", "Content-Encoding", "Authorization", APIKeyHeader, SignatureHeader, IdempotencyKeyHeader}
+
+// corsExposedHeaders are the response headers of the API browsers may read.
+var corsExposedHeaders = []string{CircuitVersionHeader, "Retry-After", "Location"}
+
+// allowedOrigin returns the value of the Access-Control-Allow-Origin header
+// of a request from origin, empty if the origin is not allowed.
+func (cors *CORS) allowedOrigin(origin string) string {
+	for _, allowed := range cors.AllowedOrigins {
+		if allowed == "*" {
+			return "*"
+		}
+		if strings.EqualFold(allowed, origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+func (cors *CORS) allowedMethods() []string {
+	if len(cors.AllowedMethods) == 0 {
+		return []string{http.MethodGet, http.MethodPost}
+	}
+	return cors.AllowedMethods
+}
+
+// handle adds the CORS headers to the responses of next to allowed origins,
+// and answers their preflight requests. Requests from other origins are
+// served without them, so that browsers block their responses.
+func (cors *CORS) handle(next http.Handler) http.Handler {
+	if cors == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		header := w.Header()
+		header.Add("Vary", "Origin")
+		allowed := cors.allowedOrigin(origin)
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if allowed == "" {
+			if preflight {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		header.Set("Access-Control-Allow-Origin", allowed)
+		if !preflight {
+			header.Set("Access-Control-Expose-Headers", strings.Join(corsExposedHeaders, ", "))
+			next.ServeHTTP(w, r)
+			return
+		}
+		header.Set("Access-Control-Allow-Methods", strings.Join(cors.allowedMethods(), ", "))
+		header.Set("Access-Control-Allow-Headers", strings.Join(corsAllowedHeaders, ", "))
+		if cors.MaxAge > 0 {
+			header.Set("Access-Control-Max-Age", strconv.Itoa(int(cors.MaxAge.Seconds())))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// securityHeaders adds to the responses of next the headers keeping browsers
+// from sniffing their content type, framing them, leaking the URL of the
+// API in referrers or running anything they embed. The API only serves
+// JSON and binary bodies, so none of them restrict legitimate clients.
+func securityHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := w.Header()
+		header.Set("X-Content-Type-Options", "nosniff")
+		header.Set("X-Frame-Options", "DENY")
+		header.Set("Referrer-Policy", "no-referrer")
+		header.Set("Content-Security-Policy", "default-src 'none'; frame-ancestors 'none'")
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCORS(t *testing.T) {
+	cors := &CORS{AllowedOrigins: []string{"https://dashboard.internal"}, MaxAge: time.Minute}
+	served := 0
+	handler := securityHeaders(cors.handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served++
+		w.WriteHeader(http.StatusOK)
+	})))
+	request := func(method string, origin string, preflight bool) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/prove", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		if preflight {
+			r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, r)
+		return recorder
+	}
+
+	recorder := request(http.MethodOptions, "https://dashboard.internal", true)
+	header := recorder.Header()
+	if recorder.Code != http.StatusNoContent || served != 0 {
+		t.Fatalf("expected the preflight request to be answered, got %d", recorder.Code)
+	}
+	if header.Get("Access-Control-Allow-Origin") != "https://dashboard.internal" || header.Get("Access-Control-Allow-Methods") != "GET, POST" || header.Get("Access-Control-Max-Age") != "60" {
+		t.Fatalf("unexpected preflight headers %v", header)
+	}
+
+	recorder = request(http.MethodPost, "https://dashboard.internal", false)
+	if recorder.Code != http.StatusOK || recorder.Header().Get("Access-Control-Allow-Origin") != "https://dashboard.internal" || recorder.Header().Get("Access-Control-Expose-Headers") == "" {
+		t.Fatalf("expected the request to be served with CORS headers, got %d %v", recorder.Code, recorder.Header())
+	}
+
+	recorder = request(http.MethodPost, "https://elsewhere.example", false)
+	if recorder.Code != http.StatusOK || recorder.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatalf("expected the request to be served without CORS headers, got %d %v", recorder.Code, recorder.Header())
+	}
+	if recorder.Header().Get("X-Content-Type-Options") != "nosniff" || recorder.Header().Get("X-Frame-Options") != "DENY" {
+		t.Fatalf("expected the security headers, got %v", recorder.Header())
+	}
+
+	if header := (&CORS{AllowedOrigins: []string{"*"}}).allowedOrigin("https://any.example"); header != "*" {
+		t.Fatalf("expected any origin to be allowed, got %q", header)
+	}
+	var disabled *CORS
+	served = 0
+	recorder = httptest.NewRecorder()
+	disabled.handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { served++ })).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/info", nil))
+	if served != 1 || recorder.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatal("expected a nil configuration to serve no CORS headers")
+	}
+}
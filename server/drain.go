@@ -0,0 +1,175 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+	"worldcoin/gnark-mbu/logging"
+)
+
+const (
+	statusReady    = "ready"
+	statusDraining = "draining"
+)
+
+// errShuttingDown is the error of proofs cancelled at the end of the drain
+// grace period, or not started because of it.
+var errShuttingDown = errors.New("the server is shutting down")
+
+func shuttingDownError(message string) *Error {
+	return &Error{StatusCode: http.StatusServiceUnavailable, Code: "shutting_down", Message: message}
+}
+
+// drain tracks the proof requests in flight, so that shutting down stops
+// accepting new ones and gives the running ones a grace period to complete.
+type drain struct {
+	mu       sync.Mutex
+	draining bool
+	inFlight int
+	// idle is closed once draining with no request in flight.
+	idle chan struct{}
+	// cancel is closed when the grace period runs out.
+	cancel chan struct{}
+}
+
+func newDrain() *drain {
+	return &drain{idle: make(chan struct{}), cancel: make(chan struct{})}
+}
+
+// enter registers a request, unless the server is draining.
+func (d *drain) enter() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.draining {
+		return false
+	}
+	d.inFlight++
+	return true
+}
+
+// hold registers background work that outlives the request it started from,
+// even while draining. It fails once the drain is over.
+func (d *drain) hold() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.draining && d.inFlight == 0 {
+		return false
+	}
+	d.inFlight++
+	return true
+}
+
+func (d *drain) exit() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.inFlight--
+	if d.draining && d.inFlight == 0 {
+		close(d.idle)
+	}
+}
+
+func (d *drain) isDraining() bool {
+	if d == nil {
+		return false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.draining
+}
+
+// cancelled returns a channel closed when in-flight proofs are cancelled. It
+// is never closed for a nil drain.
+func (d *drain) cancelled() <-chan struct{} {
+	if d == nil {
+		return nil
+	}
+	return d.cancel
+}
+
+// context returns a context derived from parent and cancelled with the
+// in-flight proofs, so that the prover stops at its next cancellation check
+// when either the proof is no longer wanted or the server shuts down.
+func (d *drain) context(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	go func() {
+		select {
+		case <-d.cancelled():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// run stops accepting requests, waits up to grace for those in flight, and
+// then cancels the remaining ones and waits for them to return.
+func (d *drain) run(grace time.Duration) {
+	d.mu.Lock()
+	d.draining = true
+	if d.inFlight == 0 {
+		close(d.idle)
+	}
+	inFlight := d.inFlight
+	d.mu.Unlock()
+	drainingGauge.Set(1)
+
+	logging.Logger().Info().Int("inFlight", inFlight).Dur("gracePeriod", grace).Msg("draining proof requests")
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+	select {
+	case <-d.idle:
+		logging.Logger().Info().Msg("proof requests drained")
+		return
+	case <-timer.C:
+	}
+	logging.Logger().Warn().Msg("drain grace period ran out, cancelling proof requests")
+	close(d.cancel)
+	<-d.idle
+}
+
+// track rejects requests to next while draining and keeps track of the
+// others.
+func (d *drain) track(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !d.enter() {
+			shuttingDownError("the server is draining, retry on another instance").send(w)
+			return
+		}
+		defer d.exit()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// readyHandler reports whether the server accepts proof requests, so that
+// load balancers stop routing to it while it drains or has no keys.
+type readyHandler struct {
+	drain  *drain
+	system *activeSystem
+}
+
+func (handler readyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	response := healthResponse{Status: statusReady}
+	statusCode := http.StatusOK
+	if handler.drain.isDraining() {
+		response.Status = statusDraining
+		statusCode = http.StatusServiceUnavailable
+	} else if handler.system.current.Load().provingSystem == nil {
+		response.Status, response.Reason = statusDegraded, "no proving keys are loaded"
+		statusCode = http.StatusServiceUnavailable
+	}
+	responseBytes, err := json.Marshal(&response)
+	if err != nil {
+		unexpectedError(err).send(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(responseBytes)
+}
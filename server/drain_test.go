@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"worldcoin/gnark-mbu/prover"
+)
+
+func TestDrain(t *testing.T) {
+	d := newDrain()
+	system := newActiveSystem(&prover.ProvingSystem{})
+	release := make(chan struct{})
+	started := make(chan struct{})
+	handler := d.track(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}))
+	ready := func() int {
+		recorder := httptest.NewRecorder()
+		readyHandler{drain: d, system: system}.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/ready", nil))
+		return recorder.Code
+	}
+	if code := ready(); code != http.StatusOK {
+		t.Fatalf("expected the server to be ready, got %d", code)
+	}
+
+	inFlight := httptest.NewRecorder()
+	served := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(inFlight, httptest.NewRequest(http.MethodPost, "/prove", nil))
+		close(served)
+	}()
+	<-started
+	drained := make(chan struct{})
+	go func() {
+		d.run(time.Minute)
+		close(drained)
+	}()
+	for !d.isDraining() {
+		time.Sleep(time.Millisecond)
+	}
+
+	if code := ready(); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected a draining server not to be ready, got %d", code)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/prove", nil))
+	if recorder.Code != http.StatusServiceUnavailable || !strings.Contains(recorder.Body.String(), "shutting_down") {
+		t.Fatalf("expected new requests to be rejected, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	select {
+	case <-drained:
+		t.Fatal("expected the drain to wait for the request in flight")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	close(release)
+	<-served
+	<-drained
+	if inFlight.Code != http.StatusOK {
+		t.Fatalf("expected the request in flight to complete, got %d", inFlight.Code)
+	}
+	select {
+	case <-d.cancelled():
+		t.Fatal("expected completed requests not to be cancelled")
+	default:
+	}
+}
+
+func TestDrainCancels(t *testing.T) {
+	d := newDrain()
+	started := make(chan struct{})
+	handler := d.track(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := d.context(context.Background())
+		defer cancel()
+		close(started)
+		<-ctx.Done()
+		proofError(errShuttingDown).send(w)
+	}))
+	recorder := httptest.NewRecorder()
+	go handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/prove", nil))
+	<-started
+	d.run(10 * time.Millisecond)
+	if recorder.Code != http.StatusServiceUnavailable || !strings.Contains(recorder.Body.String(), "shutting_down") {
+		t.Fatalf("expected the request to be cancelled, got %d %s", recorder.Code, recorder.Body.String())
+	}
+}
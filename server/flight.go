@@ -0,0 +1,120 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+)
+
+// IdempotencyKeyHeader names a proof request, so that requests carrying the
+// same key share one proof while it is generated instead of being matched by
+// their parameters. Keys are scoped to the authenticated client, so anonymous
+// requests may not carry one: they would share a single scope, in which a
+// caller could take the proof of another.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+var errIdempotencyKeyReused = errors.New("the idempotency key is in use by a request with other parameters")
+
+func idempotencyKeyReusedError() *Error {
+	return &Error{StatusCode: http.StatusUnprocessableEntity, Code: "idempotency_key_reused", Message: errIdempotencyKeyReused.Error()}
+}
+
+func anonymousIdempotencyKeyError() *Error {
+	return &Error{StatusCode: http.StatusBadRequest, Code: "anonymous_idempotency_key", Message: "idempotency keys are scoped to the client, so requests carrying one must be signed"}
+}
+
+// idempotencyKey returns the key of the flight of a request of clientId, or
+// an empty string if it does not carry an idempotency key. Anonymous requests
+// carrying one are refused.
+func idempotencyKey(r *http.Request, clientId string) (string, *Error) {
+	key := r.Header.Get(IdempotencyKeyHeader)
+	if key == "" {
+		return "", nil
+	}
+	if clientId == "" {
+		return "", anonymousIdempotencyKeyError()
+	}
+	return "idempotency:" + clientId + ":" + key, nil
+}
+
+// errProofAbandoned is the error of proofs cancelled because every request
+// waiting for them gave up.
+var errProofAbandoned = errors.New("no request waits for the proof anymore")
+
+type flight struct {
+	// proofKey identifies the parameters being proven.
+	proofKey string
+	done     chan struct{}
+	res      proofResult
+	// waiters counts the requests waiting for the proof; cancel cancels it
+	// once none is left.
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// flightGroup coalesces concurrent requests for the same proof, so that it is
+// generated once and returned to every caller.
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{flights: make(map[string]*flight)}
+}
+
+// do calls prove unless a call for the same key is in flight, in which case
+// it waits for that call and returns its result. Calls for key must prove the
+// parameters identified by proofKey, or fail with errIdempotencyKeyReused.
+// A caller stops waiting when its ctx is done, and the context prove is
+// given is cancelled once every caller has, so that a proof nobody waits for
+// gives its queue slot up; later calls for key start a new proof.
+func (group *flightGroup) do(ctx context.Context, key string, proofKey string, prove func(ctx context.Context) proofResult) proofResult {
+	group.mu.Lock()
+	f, coalesced := group.flights[key]
+	if coalesced {
+		if f.proofKey != proofKey {
+			group.mu.Unlock()
+			return proofResult{err: errIdempotencyKeyReused}
+		}
+		coalescedRequestsCounter.Inc()
+	} else {
+		flightCtx, cancel := context.WithCancel(context.Background())
+		f = &flight{proofKey: proofKey, done: make(chan struct{}), cancel: cancel}
+		group.flights[key] = f
+		go func() {
+			res := prove(flightCtx)
+			group.mu.Lock()
+			group.landLocked(key, f)
+			group.mu.Unlock()
+			f.res = res
+			close(f.done)
+		}()
+	}
+	f.waiters++
+	group.mu.Unlock()
+
+	select {
+	case <-f.done:
+		res := f.res
+		res.coalesced = coalesced
+		return res
+	case <-ctx.Done():
+		group.mu.Lock()
+		if f.waiters--; f.waiters == 0 {
+			group.landLocked(key, f)
+		}
+		group.mu.Unlock()
+		return proofResult{err: ctx.Err()}
+	}
+}
+
+// landLocked removes f from the flights, unless a new flight for key
+// replaced it, and cancels its context.
+func (group *flightGroup) landLocked(key string, f *flight) {
+	if group.flights[key] == f {
+		delete(group.flights, key)
+	}
+	f.cancel()
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"worldcoin/gnark-mbu/prover"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestFlightGroup(t *testing.T) {
+	group := newFlightGroup()
+	coalesced := testutil.ToFloat64(coalescedRequestsCounter)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	calls := 0
+	prove := func(context.Context) proofResult {
+		calls++
+		close(started)
+		<-release
+		return proofResult{elapsed: time.Second}
+	}
+
+	var wg sync.WaitGroup
+	results := make([]proofResult, 3)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		results[0] = group.do(context.Background(), "key", "proof", prove)
+	}()
+	<-started
+	for i := 1; i < len(results); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = group.do(context.Background(), "key", "proof", prove)
+		}(i)
+	}
+	// Reusing the key for other parameters fails right away.
+	if res := group.do(context.Background(), "key", "other proof", prove); !errors.Is(res.err, errIdempotencyKeyReused) {
+		t.Fatalf("expected the reused key to be rejected, got %+v", res)
+	}
+	// Wait for the followers to join the flight before landing it.
+	for testutil.ToFloat64(coalescedRequestsCounter) < coalesced+2 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Fatalf("expected a single proof, got %d", calls)
+	}
+	for i, res := range results {
+		if res.elapsed != time.Second || res.coalesced != (i > 0) {
+			t.Errorf("request %d: unexpected result %+v", i, res)
+		}
+	}
+	if len(group.flights) != 0 {
+		t.Fatal("expected the flight to be removed once landed")
+	}
+}
+
+func TestFlightGroupCancelsAbandonedProofs(t *testing.T) {
+	group := newFlightGroup()
+	started, cancelled := make(chan struct{}), make(chan struct{})
+	prove := func(ctx context.Context) proofResult {
+		close(started)
+		<-ctx.Done()
+		close(cancelled)
+		return proofResult{err: ctx.Err()}
+	}
+	first, cancelFirst := context.WithCancel(context.Background())
+	second, cancelSecond := context.WithCancel(context.Background())
+	results := make(chan proofResult, 2)
+	go func() { results <- group.do(first, "key", "proof", prove) }()
+	<-started
+	coalesced := testutil.ToFloat64(coalescedRequestsCounter)
+	go func() { results <- group.do(second, "key", "proof", prove) }()
+	for testutil.ToFloat64(coalescedRequestsCounter) < coalesced+1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The proof goes on while a request still waits for it.
+	cancelFirst()
+	if res := <-results; !errors.Is(res.err, context.Canceled) {
+		t.Fatalf("expected the first request to stop waiting, got %+v", res)
+	}
+	select {
+	case <-cancelled:
+		t.Fatal("expected the proof to go on for the second request")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	cancelSecond()
+	<-results
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the abandoned proof to be cancelled")
+	}
+
+	// A later request starts a new proof.
+	res := group.do(context.Background(), "key", "proof", func(context.Context) proofResult {
+		return proofResult{elapsed: time.Second}
+	})
+	if res.err != nil || res.coalesced {
+		t.Fatalf("expected a new proof, got %+v", res)
+	}
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/prove", nil)
+	if key, keyErr := idempotencyKey(r, "sequencer"); key != "" || keyErr != nil {
+		t.Fatalf("expected no key, got %q", key)
+	}
+	r.Header.Set(IdempotencyKeyHeader, "batch-7")
+	sequencer, _ := idempotencyKey(r, "sequencer")
+	other, _ := idempotencyKey(r, "other")
+	if sequencer == other {
+		t.Fatal("expected keys to be scoped to the client")
+	}
+}
+
+func TestAnonymousIdempotencyKey(t *testing.T) {
+	ps := &prover.ProvingSystem{Curve: ecc.BN254, TreeDepth: 2, BatchSize: 1}
+	handler := proveHandler{system: newActiveSystem(ps), drain: newDrain()}
+	// Two anonymous callers sending the same key would share its scope, the
+	// second being answered with the proof of the first.
+	for _, body := range []string{
+		`{"inputHash":"0x1","startIndex":0,"preRoot":"0x1","postRoot":"0x2","identityCommitments":["0x3"],"merkleProofs":[["0x0","0x0"]]}`,
+		`{"inputHash":"0x2","startIndex":0,"preRoot":"0x1","postRoot":"0x4","identityCommitments":["0x5"],"merkleProofs":[["0x0","0x0"]]}`,
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/prove", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set(IdempotencyKeyHeader, "batch-7")
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, r)
+		if recorder.Code != http.StatusBadRequest || !strings.Contains(recorder.Body.String(), "anonymous_idempotency_key") {
+			t.Fatalf("expected the anonymous idempotency key to be refused, got %d: %s", recorder.Code, recorder.Body)
+		}
+	}
+}
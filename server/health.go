@@ -0,0 +1,71 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+const (
+	statusOk       = "ok"
+	statusDegraded = "degraded"
+)
+
+// health tracks whether the server is able to generate proofs. While it is
+// degraded the server keeps serving the endpoints that do not need the prover.
+type health struct {
+	mu     sync.Mutex
+	reason string
+}
+
+func (h *health) degrade(reason string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.reason = reason
+	proverHealthyGauge.Set(0)
+}
+
+func (h *health) restore() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.reason = ""
+	proverHealthyGauge.Set(1)
+}
+
+// status returns the current status and, when degraded, the reason.
+func (h *health) status() (string, string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.reason != "" {
+		return statusDegraded, h.reason
+	}
+	return statusOk, ""
+}
+
+type healthHandler struct {
+	health *health
+}
+
+type healthResponse struct {
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// ServeHTTP reports the health of the server. A degraded server still
+// responds with 200 so that it keeps receiving the requests it can serve.
+func (handler healthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var response healthResponse
+	response.Status, response.Reason = handler.health.status()
+	responseBytes, err := json.Marshal(&response)
+	if err != nil {
+		unexpectedError(err).send(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(responseBytes)
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHealthHandler(t *testing.T) {
+	h := &health{}
+	h.restore()
+	check := func(status string, reason string) {
+		t.Helper()
+		recorder := httptest.NewRecorder()
+		healthHandler{health: h}.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/health", nil))
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, recorder.Code)
+		}
+		var response healthResponse
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		if response.Status != status || response.Reason != reason {
+			t.Fatalf("expected %s (%q), got %s (%q)", status, reason, response.Status, response.Reason)
+		}
+	}
+	check(statusOk, "")
+	h.degrade("failed to load keys")
+	check(statusDegraded, "failed to load keys")
+	h.restore()
+	check(statusOk, "")
+}
+
+func TestDegradedHandlers(t *testing.T) {
+	h := &health{}
+	h.degrade("failed to load keys")
+
+	recorder := httptest.NewRecorder()
+	proveHandler{system: newActiveSystem(nil), health: h}.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/prove", strings.NewReader("{}")))
+	if recorder.Code != http.StatusServiceUnavailable || !strings.Contains(recorder.Body.String(), "prover_unavailable") {
+		t.Fatalf("expected prove to be unavailable, got %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	recorder = httptest.NewRecorder()
+	checkHandler{proveHandler{system: newActiveSystem(nil), health: h}}.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/check", strings.NewReader("{}")))
+	if recorder.Code != http.StatusServiceUnavailable || !strings.Contains(recorder.Body.String(), "prover_unavailable") {
+		t.Fatalf("expected check to be unavailable, got %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	recorder = httptest.NewRecorder()
+	verifyHandler{system: newActiveSystem(nil)}.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/verify", strings.NewReader("{}")))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected verify to be unavailable, got %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	infoHandler{system: newActiveSystem(nil), health: h}.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/info", nil))
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), statusDegraded) {
+		t.Fatalf("expected info to report the degraded status, got %d %s", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestUnsupportedCircuit(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	unsupportedCircuitHandler{circuit: "deletion"}.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/prove/deletion", strings.NewReader("{}")))
+	if recorder.Code != http.StatusNotImplemented || !strings.Contains(recorder.Body.String(), "unsupported_circuit") {
+		t.Fatalf("expected unsupported_circuit, got %d: %s", recorder.Code, recorder.Body)
+	}
+}
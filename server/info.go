@@ -0,0 +1,133 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"worldcoin/gnark-mbu/buildinfo"
+	"worldcoin/gnark-mbu/hardware"
+	"worldcoin/gnark-mbu/prover"
+)
+
+type infoHandler struct {
+	system   *activeSystem
+	hardware *hardware.Selection
+	health   *health
+	// keys are the key files of the keys directory, if any.
+	keys *keySet
+	// dev is Config.Dev.
+	dev bool
+	// mode is Config.Mode.
+	mode Mode
+	// signingKey is the key results are signed with, nil if they are not.
+	signingKey *signingKeyInfo
+}
+
+// backend is the proving backend of the prover.
+const backend = "groth16"
+
+// contentTypes are the content types request bodies are accepted in.
+var contentTypes = []string{"application/json", LegacyContentType, prover.BinaryFormatCBOR.ContentType(), prover.BinaryFormatMsgpack.ContentType()}
+
+// proofEncodings are the encodings proofs can be requested in.
+var proofEncodings = []prover.ProofEncoding{prover.ProofEncodingDefault, prover.ProofEncodingCompressed}
+
+// infoResponse omits the circuit if the keys could not be loaded.
+type infoResponse struct {
+	Status    string `json:"status"`
+	Curve     string `json:"curve,omitempty"`
+	TreeDepth uint32 `json:"treeDepth,omitempty"`
+	BatchSize uint32 `json:"batchSize,omitempty"`
+	// Commitment is the hash computing the input hash.
+	Commitment prover.Commitment `json:"commitment,omitempty"`
+	// Indexed is set when requests must give the index of every insertion.
+	Indexed bool `json:"indexed,omitempty"`
+	// TreeHash is the hash of the nodes of the tree.
+	TreeHash prover.TreeHash `json:"treeHash,omitempty"`
+	// CircuitVersion is prover.CircuitSemver.
+	CircuitVersion string `json:"circuitVersion"`
+	// Fingerprint identifies the circuit, see prover.ProvingSystem.Fingerprint.
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// Gas estimates the cost of verifying a batch on the EVM.
+	Gas *prover.GasReport `json:"gas,omitempty"`
+	// Stats are the sizes of the circuit, keys and witness, for capacity
+	// planning.
+	Stats *prover.Stats `json:"stats,omitempty"`
+	// Backend is the proving backend, groth16.
+	Backend string `json:"backend"`
+	// ProverVersion and GitCommit identify the prover binary, see
+	// buildinfo.
+	ProverVersion string `json:"proverVersion"`
+	GitCommit     string `json:"gitCommit,omitempty"`
+	// BatchSizes are the batch sizes of all circuits served, in increasing
+	// order, for callers choosing how to cut their batches.
+	BatchSizes []uint32 `json:"batchSizes,omitempty"`
+	// Circuits are the circuits served: those of the keys directory, or
+	// the one of the keys file.
+	Circuits []keyFileInfo `json:"circuits,omitempty"`
+	// ContentTypes are the content types request bodies are accepted in.
+	ContentTypes []string `json:"contentTypes"`
+	// ProofEncodings are the encodings proofs can be requested in.
+	ProofEncodings []prover.ProofEncoding `json:"proofEncodings"`
+	Hardware       *hardware.Selection    `json:"hardware,omitempty"`
+	// Dev is set when the keys are throwaway development keys, whose
+	// proofs verify against no deployed verifier.
+	Dev bool `json:"dev,omitempty"`
+	// Mode is the server mode: both, prover or verifier.
+	Mode Mode `json:"mode"`
+	// ResultSigningKey is the public key proofs are signed with, see
+	// ProofSignatureHeader.
+	ResultSigningKey *signingKeyInfo `json:"resultSigningKey,omitempty"`
+}
+
+func (handler infoHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	response := infoResponse{
+		CircuitVersion:   prover.CircuitSemver,
+		Backend:          backend,
+		ProverVersion:    buildinfo.Version,
+		GitCommit:        buildinfo.GitCommit,
+		ContentTypes:     contentTypes,
+		ProofEncodings:   proofEncodings,
+		Hardware:         handler.hardware,
+		Dev:              handler.dev,
+		Mode:             handler.mode,
+		ResultSigningKey: handler.signingKey,
+	}
+	response.Status, _ = handler.health.status()
+	if provingSystem := handler.system.current.Load().provingSystem; provingSystem != nil {
+		response.Curve = provingSystem.Curve.String()
+		response.TreeDepth = provingSystem.TreeDepth
+		response.BatchSize = provingSystem.BatchSize
+		response.Commitment, _ = prover.ParseCommitment(string(provingSystem.Commitment))
+		response.Indexed = provingSystem.Indexed
+		response.TreeHash, _ = prover.ParseTreeHash(string(provingSystem.TreeHash))
+		response.Fingerprint = provingSystem.Fingerprint()
+		response.Gas = provingSystem.GasReport()
+		response.Stats = provingSystem.Stats()
+	}
+	if handler.keys != nil {
+		response.Circuits = handler.keys.files()
+	} else if provingSystem := handler.system.current.Load().provingSystem; provingSystem != nil {
+		response.Circuits = []keyFileInfo{{circuitInfo: newCircuitInfo(provingSystem), Default: true, Loaded: true}}
+	}
+	seen := make(map[uint32]bool)
+	for _, circuit := range response.Circuits {
+		if !seen[circuit.BatchSize] {
+			seen[circuit.BatchSize] = true
+			response.BatchSizes = append(response.BatchSizes, circuit.BatchSize)
+		}
+	}
+	sort.Slice(response.BatchSizes, func(i, j int) bool { return response.BatchSizes[i] < response.BatchSizes[j] })
+	responseBytes, err := json.Marshal(&response)
+	if err != nil {
+		unexpectedError(err).send(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(responseBytes)
+}
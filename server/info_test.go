@@ -0,0 +1,54 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"worldcoin/gnark-mbu/prover"
+
+	"github.com/consensys/gnark-crypto/ecc"
+)
+
+func TestInfo(t *testing.T) {
+	circuit := &prover.ProvingSystem{Curve: ecc.BN254, TreeDepth: 2, BatchSize: 4}
+	serve := func(handler infoHandler) infoResponse {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/info", nil))
+		var response infoResponse
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+
+	system := newActiveSystem(circuit)
+	response := serve(infoHandler{system: system, health: &health{}})
+	if response.Backend != "groth16" || response.ProverVersion == "" || len(response.ContentTypes) != 4 || len(response.ProofEncodings) != 2 {
+		t.Fatalf("unexpected info %+v", response)
+	}
+	if len(response.Circuits) != 1 || response.Circuits[0].Fingerprint != circuit.Fingerprint() || response.Circuits[0].Path != "" {
+		t.Fatalf("expected the keys file to be described, got %+v", response.Circuits)
+	}
+	if response.Dev {
+		t.Fatal("expected the keys not to be reported as development keys")
+	}
+	if response.Stats != nil {
+		t.Fatal("expected no stats for a circuit without a constraint system")
+	}
+	if response = serve(infoHandler{system: system, health: &health{}, dev: true}); !response.Dev {
+		t.Fatal("expected the keys to be reported as development keys")
+	}
+
+	files := []KeyFile{
+		{Path: "large", Circuit: circuit},
+		{Path: "small", Circuit: &prover.ProvingSystem{Curve: ecc.BN254, TreeDepth: 2, BatchSize: 1}},
+		{Path: "indexed", Circuit: &prover.ProvingSystem{Curve: ecc.BN254, TreeDepth: 3, BatchSize: 1, Indexed: true}},
+	}
+	keys := newKeySet(files, prover.ReadSystemFromFile, true, system)
+	response = serve(infoHandler{system: system, health: &health{}, keys: keys})
+	if !reflect.DeepEqual(response.BatchSizes, []uint32{1, 4}) || len(response.Circuits) != 3 {
+		t.Fatalf("expected the key files to be described, got %v %+v", response.BatchSizes, response.Circuits)
+	}
+}
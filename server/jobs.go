@@ -0,0 +1,316 @@
+package server
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"worldcoin/gnark-mbu/jobstore"
+	"worldcoin/gnark-mbu/logging"
+	"worldcoin/gnark-mbu/prover"
+)
+
+// jobRetentionInterval is how often finished jobs past their retention are
+// deleted.
+const jobRetentionInterval = time.Minute
+
+func jobNotFoundError(id string) *Error {
+	return &Error{StatusCode: http.StatusNotFound, Code: "job_not_found", Message: "no job " + id + ", it may have expired"}
+}
+
+// resultError is the error of a failed job or callback.
+type resultError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type jobResponse struct {
+	ID     string          `json:"id"`
+	Status jobstore.Status `json:"status"`
+	// Stage is the stage of the proof of a running job, or the stage a
+	// failed job failed in, see prover.ProofStage.
+	Stage     string         `json:"stage,omitempty"`
+	Proof     json.Marshaler `json:"proof,omitempty"`
+	Error     *resultError   `json:"error,omitempty"`
+	CreatedAt time.Time      `json:"createdAt"`
+	UpdatedAt time.Time      `json:"updatedAt"`
+}
+
+func newJobResponse(job *jobstore.Job, encoding prover.ProofEncoding, numbers prover.NumberFormat) (*jobResponse, error) {
+	response := &jobResponse{ID: job.ID, Status: job.Status, Stage: job.Stage, CreatedAt: job.CreatedAt.UTC(), UpdatedAt: job.UpdatedAt.UTC()}
+	if job.Proof != nil {
+		var proof prover.Proof
+		if err := json.Unmarshal(job.Proof, &proof); err != nil {
+			return nil, err
+		}
+		response.Proof = proof.Encoded(encoding, numbers)
+	}
+	if job.ErrorCode != "" {
+		response.Error = &resultError{Code: job.ErrorCode, Message: job.ErrorMessage}
+	}
+	return response, nil
+}
+
+// jobRunner proves the jobs of the async mode in the background, through the
+// proof queue like /prove. Jobs interrupted by a shutdown stay queued in the
+// store and are resumed when the server starts again.
+type jobRunner struct {
+	prove proveHandler
+	store jobstore.Store
+}
+
+// submit proves job in the background.
+func (runner *jobRunner) submit(job *jobstore.Job, params *prover.Parameters) {
+	go runner.run(job, params)
+}
+
+func (runner *jobRunner) run(job *jobstore.Job, params *prover.Parameters) {
+	if !runner.prove.drain.enter() {
+		return
+	}
+	defer runner.prove.drain.exit()
+	g := runner.prove.systemFor(params).acquire()
+	if g.provingSystem == nil {
+		g.release()
+		runner.finish(job, proofResult{}, proverUnavailableError())
+		return
+	}
+	job.Stage = ""
+	runner.update(job, jobstore.StatusRunning)
+	// The proof outlives proveCancellable when the server shuts down, and
+	// must not record its stages once the job is queued again.
+	var mu sync.Mutex
+	returned := false
+	res := runner.prove.proveCancellable(schedule{priority: PriorityNormal}, g, params, "", func(stage prover.ProofStage) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !returned {
+			job.Stage = string(stage)
+			runner.update(job, jobstore.StatusRunning)
+		}
+	})
+	mu.Lock()
+	returned = true
+	mu.Unlock()
+	if errors.Is(res.err, errShuttingDown) {
+		job.Stage = ""
+		runner.update(job, jobstore.StatusQueued)
+		return
+	}
+	if res.err != nil {
+		runner.finish(job, res, proofError(res.err))
+		return
+	}
+	runner.finish(job, res, nil)
+}
+
+// finish records the result of job.
+func (runner *jobRunner) finish(job *jobstore.Job, res proofResult, jobErr *Error) {
+	audit := logging.Audit().With().Str("jobId", job.ID).Str("clientId", job.ClientID).Logger()
+	if jobErr != nil {
+		audit.Info().Str("code", jobErr.Code).Msg("proof job failed")
+		job.ErrorCode, job.ErrorMessage = jobErr.Code, jobErr.Message
+		runner.update(job, jobstore.StatusFailed)
+		runner.callBack(job)
+		return
+	}
+	proof, err := json.Marshal(res.proof)
+	if err != nil {
+		runner.finish(job, proofResult{}, unexpectedError(err))
+		return
+	}
+	audit.Info().Bool("cached", res.cached).Bool("coalesced", res.coalesced).Msg("proof job succeeded")
+	job.Proof = proof
+	runner.update(job, jobstore.StatusSucceeded)
+	runner.callBack(job)
+}
+
+// callBack posts the finished job to its callback URL, if any, as GET
+// /jobs/<id> would report it with the default encoding.
+func (runner *jobRunner) callBack(job *jobstore.Job) {
+	if job.CallbackURL == "" {
+		return
+	}
+	response, err := newJobResponse(job, runner.prove.encoding, runner.prove.numbers)
+	if err != nil {
+		logging.Logger().Error().Err(err).Str("jobId", job.ID).Msg("failed to encode callback")
+		return
+	}
+	runner.prove.callbacks.send(job.CallbackURL, response)
+}
+
+func (runner *jobRunner) update(job *jobstore.Job, status jobstore.Status) {
+	job.Status, job.UpdatedAt = status, time.Now()
+	if err := runner.store.Update(context.Background(), job); err != nil {
+		logging.Logger().Error().Err(err).Str("jobId", job.ID).Str("status", string(status)).Msg("failed to store proof job")
+	}
+}
+
+// resume submits the jobs left unfinished by a previous run of the server.
+func (runner *jobRunner) resume() {
+	jobs, err := runner.store.Unfinished(context.Background())
+	if err != nil {
+		logging.Logger().Error().Err(err).Msg("failed to read unfinished proof jobs")
+		return
+	}
+	for _, job := range jobs {
+		var params prover.Parameters
+		if err = json.Unmarshal(job.Parameters, &params); err != nil {
+			runner.finish(job, proofResult{}, malformedBodyError(err))
+			continue
+		}
+		runner.submit(job, &params)
+	}
+	if len(jobs) > 0 {
+		logging.Logger().Info().Int("jobs", len(jobs)).Msg("resumed unfinished proof jobs")
+	}
+}
+
+// spawnJobRetentionJob deletes the jobs finished more than retention ago.
+func spawnJobRetentionJob(store jobstore.Store, retention time.Duration) RunningJob {
+	stopped := make(chan struct{})
+	start := func() {
+		ticker := time.NewTicker(jobRetentionInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				deleted, err := store.DeleteFinished(context.Background(), time.Now().Add(-retention))
+				if err != nil {
+					logging.Logger().Error().Err(err).Msg("failed to delete expired proof jobs")
+				} else if deleted > 0 {
+					logging.Logger().Info().Int64("jobs", deleted).Msg("deleted expired proof jobs")
+				}
+			case <-stopped:
+				return
+			}
+		}
+	}
+	return SpawnJob(start, func() { close(stopped) })
+}
+
+// jobsHandler serves the async mode: POST /jobs takes the body of /prove and
+// answers 202 with a job id right away, and GET /jobs/<id> reports the job
+// and its proof once done.
+type jobsHandler struct {
+	proveHandler
+	runner *jobRunner
+}
+
+func (handler jobsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/jobs":
+		handler.submit(w, r)
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/jobs/"):
+		handler.get(w, r, strings.TrimPrefix(r.URL.Path, "/jobs/"))
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (handler jobsHandler) submit(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(CircuitVersionHeader, prover.CircuitSemver)
+	if limitErr := handler.limiter.allowIP(r); limitErr != nil {
+		limitErr.send(w)
+		return
+	}
+	callbackURL, callbackErr := handler.callbacks.callbackURL(r)
+	if callbackErr != nil {
+		callbackErr.send(w)
+		return
+	}
+	buf, readErr := handler.limits.readBody(w, r)
+	if readErr != nil {
+		readErr.send(w)
+		return
+	}
+	params, decodeErr := handler.limits.decodeParameters(r, buf, handler.legacyJSON)
+	if decodeErr != nil {
+		decodeErr.send(w)
+		return
+	}
+	logBatchSize(r, len(params.IdComms))
+	digest := params.Digest()
+	clientId, authErr := authenticate(r, digest, handler.clientKeys, handler.requireSignatures)
+	if authErr != nil {
+		authErr.send(w)
+		return
+	}
+	if limitErr := handler.limiter.allowClient(clientId); limitErr != nil {
+		limitErr.send(w)
+		return
+	}
+	if circuitErr := allowCircuit(r, paramsShape(params)); circuitErr != nil {
+		circuitErr.send(w)
+		return
+	}
+	// Bad batches are rejected upfront rather than stored as failed jobs.
+	g := handler.systemFor(params).acquire()
+	provingSystem := g.provingSystem
+	if provingSystem == nil {
+		g.release()
+		proverUnavailableError().send(w)
+		return
+	}
+	err := handler.verifyParameters(provingSystem, params)
+	g.release()
+	if err != nil {
+		proverError(err).send(w)
+		return
+	}
+	parameters, err := json.Marshal(params)
+	if err != nil {
+		unexpectedError(err).send(w)
+		return
+	}
+	now := time.Now()
+	job := &jobstore.Job{ID: newRequestID(), ClientID: clientId, Status: jobstore.StatusQueued, Parameters: parameters, CallbackURL: callbackURL, CreatedAt: now, UpdatedAt: now}
+	if err = handler.runner.store.Create(r.Context(), job); err != nil {
+		unexpectedError(err).send(w)
+		return
+	}
+	audit := logging.Audit().With().Str("requestId", requestID(r)).Str("clientId", clientId).Str("digest", hex.EncodeToString(digest[:])).Str("remoteAddr", r.RemoteAddr).Logger()
+	audit.Info().Bool("authenticated", clientId != "").Str("jobId", job.ID).Msg("proof job submitted")
+	handler.runner.submit(job, params)
+
+	w.Header().Set("Location", "/jobs/"+job.ID)
+	handler.respond(w, r, job, http.StatusAccepted)
+}
+
+func (handler jobsHandler) get(w http.ResponseWriter, r *http.Request, id string) {
+	job, err := handler.runner.store.Get(r.Context(), id)
+	if errors.Is(err, jobstore.ErrNotFound) {
+		jobNotFoundError(id).send(w)
+		return
+	}
+	if err != nil {
+		unexpectedError(err).send(w)
+		return
+	}
+	handler.respond(w, r, job, http.StatusOK)
+}
+
+func (handler jobsHandler) respond(w http.ResponseWriter, r *http.Request, job *jobstore.Job, statusCode int) {
+	encoding, encodingErr := proofEncoding(r, handler.encoding)
+	if encodingErr != nil {
+		encodingErr.send(w)
+		return
+	}
+	response, err := newJobResponse(job, encoding, handler.numbers)
+	if err != nil {
+		unexpectedError(err).send(w)
+		return
+	}
+	responseBytes, err := json.Marshal(response)
+	if err != nil {
+		unexpectedError(err).send(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(responseBytes)
+}
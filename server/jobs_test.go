@@ -0,0 +1,74 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"worldcoin/gnark-mbu/jobstore"
+)
+
+func TestJobs(t *testing.T) {
+	store := jobstore.NewMemory()
+	prove := proveHandler{system: newActiveSystem(nil), drain: newDrain()}
+	runner := &jobRunner{prove: prove, store: store}
+	handler := jobsHandler{proveHandler: prove, runner: runner}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/jobs/unknown", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown job, got %d", recorder.Code)
+	}
+
+	// Unfinished jobs are resumed, and fail if their parameters cannot be read.
+	now := time.Now()
+	job := &jobstore.Job{ID: "job", Status: jobstore.StatusRunning, Parameters: []byte("not json"), CreatedAt: now, UpdatedAt: now}
+	if err := store.Create(context.Background(), job); err != nil {
+		t.Fatal(err)
+	}
+	runner.resume()
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/jobs/job", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", recorder.Code, recorder.Body)
+	}
+	var response struct {
+		ID     string          `json:"id"`
+		Status jobstore.Status `json:"status"`
+		Proof  json.RawMessage `json:"proof"`
+		Error  *resultError    `json:"error"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.ID != "job" || response.Status != jobstore.StatusFailed || response.Proof != nil || response.Error == nil || response.Error.Code != "malformed_body" {
+		t.Fatalf("unexpected job %s", recorder.Body)
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodDelete, "/jobs/job", nil))
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405, got %d", recorder.Code)
+	}
+}
+
+func TestJobsWithoutKeys(t *testing.T) {
+	store := jobstore.NewMemory()
+	runner := &jobRunner{prove: proveHandler{system: newActiveSystem(nil), drain: newDrain()}, store: store}
+	now := time.Now()
+	job := &jobstore.Job{ID: "job", Status: jobstore.StatusQueued, CreatedAt: now, UpdatedAt: now}
+	if err := store.Create(context.Background(), job); err != nil {
+		t.Fatal(err)
+	}
+	runner.run(job, nil)
+	stored, err := store.Get(context.Background(), "job")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != jobstore.StatusFailed || stored.ErrorCode != "prover_unavailable" {
+		t.Fatalf("expected the job to fail without proving keys, got %+v", stored)
+	}
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+	"worldcoin/gnark-mbu/logging"
+)
+
+const (
+	// defaultKeepaliveInterval is the interval of the keepalives of event
+	// streams requested without one.
+	defaultKeepaliveInterval = 15 * time.Second
+	// minKeepaliveInterval bounds how often clients can make the server
+	// write.
+	minKeepaliveInterval = time.Second
+	// StatusTrailer is the trailer of streamed responses holding the status
+	// code of their final payload, as the status line is sent before it is
+	// known.
+	StatusTrailer = "X-Status-Code"
+)
+
+func invalidKeepaliveError(message string) *Error {
+	return &Error{StatusCode: http.StatusBadRequest, Code: "invalid_keepalive", Message: message}
+}
+
+// keepaliveStream is the response of a proof streamed while it is computed,
+// so that proxies do not close the connection as idle. The status line is
+// sent at once, then a keepalive every interval and finally the proof or the
+// error. In the plain form, keepalives are newlines before the JSON payload,
+// which JSON parsers skip as whitespace. Event streams send them as comments
+// and the payload as a proof or error event.
+type keepaliveStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	events  bool
+	ticker  *time.Ticker
+	// err is the first write error, after which the client is gone.
+	err error
+}
+
+// wantsEventStream reports whether the request accepts server-sent events.
+func wantsEventStream(r *http.Request) bool {
+	for _, accepted := range acceptedTypes(r.Header.Get("Accept")) {
+		if accepted.mediaType == "text/event-stream" {
+			return true
+		}
+	}
+	return false
+}
+
+// keepaliveInterval returns the keepalive interval requested with the
+// keepalive query parameter, zero if the response is not streamed.
+func keepaliveInterval(r *http.Request) (time.Duration, *Error) {
+	value := r.URL.Query().Get("keepalive")
+	if value == "" {
+		if wantsEventStream(r) {
+			return defaultKeepaliveInterval, nil
+		}
+		return 0, nil
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, invalidKeepaliveError(err.Error())
+	}
+	if interval < minKeepaliveInterval {
+		return 0, invalidKeepaliveError(fmt.Sprintf("the keepalive interval must be at least %s", minKeepaliveInterval))
+	}
+	return interval, nil
+}
+
+// startKeepalive sends the status line and headers of a streamed response.
+func startKeepalive(w http.ResponseWriter, r *http.Request, interval time.Duration) *keepaliveStream {
+	stream := &keepaliveStream{w: w, events: wantsEventStream(r), ticker: time.NewTicker(interval)}
+	stream.flusher, _ = w.(http.Flusher)
+	if stream.events {
+		w.Header().Set("Content-Type", "text/event-stream")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Add("Trailer", StatusTrailer)
+	w.WriteHeader(http.StatusOK)
+	stream.flush()
+	return stream
+}
+
+// ticks returns the channel of the keepalive ticks, nil for a nil stream.
+func (s *keepaliveStream) ticks() <-chan time.Time {
+	if s == nil {
+		return nil
+	}
+	return s.ticker.C
+}
+
+func (s *keepaliveStream) write(data string) {
+	if s.err != nil {
+		return
+	}
+	if _, s.err = s.w.Write([]byte(data)); s.err != nil {
+		logging.Logger().Error().Err(s.err).Msg("error writing streamed response")
+		return
+	}
+	s.flush()
+}
+
+func (s *keepaliveStream) flush() {
+	if s.flusher != nil {
+		s.flusher.Flush()
+	}
+}
+
+// keepalive sends a keepalive.
+func (s *keepaliveStream) keepalive() {
+	if s.events {
+		s.write(": keepalive\n\n")
+	} else {
+		s.write("\n")
+	}
+}
+
+// finish sends the final JSON payload of the response with the status code
+// it would have been sent with, and stops the keepalives.
+func (s *keepaliveStream) finish(statusCode int, payload []byte) {
+	s.ticker.Stop()
+	s.w.Header().Set(StatusTrailer, strconv.Itoa(statusCode))
+	switch {
+	case !s.events:
+		s.write(string(payload))
+	case statusCode == http.StatusOK:
+		s.write("event: proof\ndata: " + string(payload) + "\n\n")
+	default:
+		s.write("event: error\ndata: " + string(payload) + "\n\n")
+	}
+}
+
+// fail sends error as the final payload of the response.
+func (s *keepaliveStream) fail(error *Error) {
+	recordError(s.w, error)
+	jsonBytes, err := error.MarshalJSON()
+	if err != nil {
+		jsonBytes = []byte(`{"code": "unexpected_error", "message": "failed to marshal error"}`)
+	}
+	s.finish(error.StatusCode, jsonBytes)
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestKeepaliveInterval(t *testing.T) {
+	for _, test := range []struct {
+		query, accept string
+		interval      time.Duration
+		code          string
+	}{
+		{"", "", 0, ""},
+		{"", "text/event-stream", defaultKeepaliveInterval, ""},
+		{"keepalive=5s", "", 5 * time.Second, ""},
+		{"keepalive=10ms", "", 0, "invalid_keepalive"},
+		{"keepalive=often", "", 0, "invalid_keepalive"},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/prove?"+test.query, nil)
+		r.Header.Set("Accept", test.accept)
+		interval, err := keepaliveInterval(r)
+		if (err == nil && test.code != "") || (err != nil && err.Code != test.code) || interval != test.interval {
+			t.Fatalf("%q: expected %s %q, got %s %v", test.query, test.interval, test.code, interval, err)
+		}
+	}
+}
+
+func TestKeepaliveStream(t *testing.T) {
+	// Keepalives are whitespace before the JSON payload.
+	r := httptest.NewRequest(http.MethodPost, "/prove?keepalive=1s", nil)
+	w := httptest.NewRecorder()
+	stream := startKeepalive(w, r, time.Second)
+	stream.keepalive()
+	stream.keepalive()
+	stream.fail(unexpectedError(errors.New("test")))
+	if w.Code != http.StatusOK || w.Body.String() != "\n\n"+`{"code":"unexpected_error","message":"test"}` {
+		t.Fatalf("unexpected response %d %q", w.Code, w.Body.String())
+	}
+	var payload map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &payload); err != nil || payload["code"] != "unexpected_error" {
+		t.Fatalf("expected the payload to parse as JSON, got %v", err)
+	}
+	if status := w.Result().Trailer.Get(StatusTrailer); status != "500" {
+		t.Fatalf("expected the status trailer to be 500, got %q", status)
+	}
+
+	// Event streams send keepalives as comments and the payload as an event.
+	r = httptest.NewRequest(http.MethodPost, "/prove", nil)
+	r.Header.Set("Accept", "text/event-stream")
+	w = httptest.NewRecorder()
+	stream = startKeepalive(w, r, time.Second)
+	stream.keepalive()
+	stream.finish(http.StatusOK, []byte(`{"ar":[]}`))
+	if w.Header().Get("Content-Type") != "text/event-stream" || w.Body.String() != ": keepalive\n\nevent: proof\ndata: {\"ar\":[]}\n\n" {
+		t.Fatalf("unexpected event stream %q", w.Body.String())
+	}
+}
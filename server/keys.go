@@ -0,0 +1,295 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"worldcoin/gnark-mbu/logging"
+	"worldcoin/gnark-mbu/prover"
+)
+
+// KeyFile is a key file found in a keys directory by ScanKeysDir.
+type KeyFile struct {
+	Path string
+	// Circuit describes the circuit of the file, read from its header. It
+	// holds no keys.
+	Circuit *prover.ProvingSystem
+}
+
+// KeyFileMode names the kind of insertions the circuit of a key file proves.
+func KeyFileMode(circuit *prover.ProvingSystem) string {
+	if circuit.Indexed {
+		return "indexed"
+	}
+	return "insertion"
+}
+
+// ScanKeysDir reads the headers of the key files of dir, in the order of
+// their names. Files that are not key files, or whose header does not verify,
+// are logged and skipped, as are the partial files of setups in progress.
+// Of several files for the same tree depth, batch size and mode, the first
+// is kept.
+func ScanKeysDir(dir string) ([]KeyFile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []KeyFile
+	seen := make(map[keyShape]string)
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".partial") {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		circuit, err := prover.ReadSystemHeaderFromFile(path)
+		if err != nil {
+			logging.Logger().Warn().Str("path", path).Err(err).Msg("skipping file of the keys directory")
+			continue
+		}
+		shape := shapeOf(circuit)
+		if previous, ok := seen[shape]; ok {
+			logging.Logger().Warn().Str("path", path).Str("kept", previous).Msg("skipping key file of a circuit already served")
+			continue
+		}
+		seen[shape] = path
+		files = append(files, KeyFile{Path: path, Circuit: circuit})
+	}
+	return files, nil
+}
+
+// keyShape is what requests are routed to key files by.
+type keyShape struct {
+	treeDepth uint32
+	batchSize uint32
+	indexed   bool
+}
+
+// mode returns the mode of the circuit, insertion or indexed, as tenants
+// and metrics name it.
+func (shape keyShape) mode() string {
+	if shape.indexed {
+		return "indexed"
+	}
+	return "insertion"
+}
+
+func shapeOf(circuit *prover.ProvingSystem) keyShape {
+	if circuit == nil {
+		return keyShape{}
+	}
+	return keyShape{treeDepth: circuit.TreeDepth, batchSize: circuit.BatchSize, indexed: circuit.Indexed}
+}
+
+// paramsShape returns the shape of the circuit proving params, of the tree
+// depth they select if they do.
+func paramsShape(params *prover.Parameters) keyShape {
+	shape := keyShape{batchSize: uint32(len(params.IdComms)), indexed: len(params.Indices) != 0}
+	if params.TreeDepth != 0 {
+		shape.treeDepth = params.TreeDepth
+	} else if len(params.MerkleProofs) != 0 {
+		shape.treeDepth = uint32(len(params.MerkleProofs[0]))
+	} else if params.Frontier != nil {
+		shape.treeDepth = uint32(len(params.Frontier))
+	}
+	return shape
+}
+
+// keyEntry is a key file served by a keySet, whose keys are loaded at
+// startup or on first use.
+type keyEntry struct {
+	file   KeyFile
+	system *activeSystem
+	// mu serializes loading.
+	mu sync.Mutex
+	// failure is the error the last attempt to load the keys failed with,
+	// empty if it succeeded.
+	failure atomic.Value
+}
+
+// loaded returns the system of the entry, loading its keys if they are not
+// loaded yet. A failed load is retried by the next request.
+func (entry *keyEntry) loaded(load func(path string) (*prover.ProvingSystem, error)) *activeSystem {
+	if entry.system.current.Load().provingSystem != nil {
+		return entry.system
+	}
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	if entry.system.current.Load().provingSystem == nil {
+		logging.Logger().Info().Str("path", entry.file.Path).Msg("loading proving keys")
+		provingSystem, err := load(entry.file.Path)
+		if err != nil {
+			entry.failure.Store(err.Error())
+			logging.Logger().Error().Str("path", entry.file.Path).Err(err).Msg("failed to load proving keys")
+		} else {
+			entry.failure.Store("")
+			entry.system.swap(provingSystem)
+			logging.Logger().Info().Str("path", entry.file.Path).Msg("proving keys loaded")
+		}
+	}
+	return entry.system
+}
+
+// keySet routes requests to the key files of a keys directory by the shape
+// of their parameters, falling back to the default keys.
+type keySet struct {
+	entries []*keyEntry
+	byShape map[keyShape]*keyEntry
+	load    func(path string) (*prover.ProvingSystem, error)
+	// fallback serves the requests matching no key file.
+	fallback *activeSystem
+}
+
+// newKeySet serves files, sharing the system of the default keys with the
+// file of the same circuit. Unless lazy is set, the keys of the other files
+// are loaded right away.
+func newKeySet(files []KeyFile, load func(path string) (*prover.ProvingSystem, error), lazy bool, fallback *activeSystem) *keySet {
+	set := &keySet{byShape: make(map[keyShape]*keyEntry), load: load, fallback: fallback}
+	var fingerprint string
+	if provingSystem := fallback.current.Load().provingSystem; provingSystem != nil {
+		fingerprint = provingSystem.Fingerprint()
+	}
+	for _, file := range files {
+		entry := &keyEntry{file: file, system: newActiveSystem(nil)}
+		if fingerprint != "" && file.Circuit.Fingerprint() == fingerprint {
+			entry.system = fallback
+		}
+		set.entries = append(set.entries, entry)
+		set.byShape[shapeOf(file.Circuit)] = entry
+	}
+	for _, entry := range set.entries {
+		if !lazy {
+			entry.loaded(load)
+		}
+		circuit := entry.file.Circuit
+		logging.Logger().Info().
+			Str("path", entry.file.Path).
+			Uint32("treeDepth", circuit.TreeDepth).
+			Uint32("batchSize", circuit.BatchSize).
+			Str("mode", KeyFileMode(circuit)).
+			Stringer("curve", circuit.Curve).
+			Bool("loaded", entry.system.current.Load().provingSystem != nil).
+			Msg("available keys")
+	}
+	return set
+}
+
+// systemFor returns the system proving params: that of the key file of
+// their shape, loaded if need be, or the default one.
+func (set *keySet) systemFor(params *prover.Parameters) *activeSystem {
+	if set == nil {
+		return nil
+	}
+	if entry, ok := set.byShape[paramsShape(params)]; ok {
+		return entry.loaded(set.load)
+	}
+	return set.fallback
+}
+
+// splitSystemFor returns the system splitting params into batches: that of
+// the key file of their tree depth and mode with the largest batch size, or
+// the default one.
+func (set *keySet) splitSystemFor(params *prover.Parameters) *activeSystem {
+	if set == nil {
+		return nil
+	}
+	shape := paramsShape(params)
+	var best *keyEntry
+	for _, entry := range set.entries {
+		candidate := shapeOf(entry.file.Circuit)
+		if candidate.treeDepth == shape.treeDepth && candidate.indexed == shape.indexed &&
+			(best == nil || candidate.batchSize > best.file.Circuit.BatchSize) {
+			best = entry
+		}
+	}
+	if best == nil {
+		return set.fallback
+	}
+	return best.loaded(set.load)
+}
+
+// circuitInfo describes a circuit served by the prover.
+type circuitInfo struct {
+	Curve       string            `json:"curve"`
+	TreeDepth   uint32            `json:"treeDepth"`
+	BatchSize   uint32            `json:"batchSize"`
+	Mode        string            `json:"mode"`
+	Commitment  prover.Commitment `json:"commitment"`
+	TreeHash    prover.TreeHash   `json:"treeHash"`
+	Fingerprint string            `json:"fingerprint"`
+}
+
+func newCircuitInfo(circuit *prover.ProvingSystem) circuitInfo {
+	info := circuitInfo{
+		Curve:       circuit.Curve.String(),
+		TreeDepth:   circuit.TreeDepth,
+		BatchSize:   circuit.BatchSize,
+		Mode:        KeyFileMode(circuit),
+		Fingerprint: circuit.Fingerprint(),
+	}
+	info.Commitment, _ = prover.ParseCommitment(string(circuit.Commitment))
+	info.TreeHash, _ = prover.ParseTreeHash(string(circuit.TreeHash))
+	return info
+}
+
+// keyFileInfo describes a key file in the response of /keys.
+type keyFileInfo struct {
+	// Path is omitted for the keys file when no keys directory is served.
+	Path string `json:"path,omitempty"`
+	circuitInfo
+	// Default is set for the file of the default keys.
+	Default bool   `json:"default,omitempty"`
+	Loaded  bool   `json:"loaded"`
+	Error   string `json:"error,omitempty"`
+}
+
+// files describes the key files of the set by tree depth and batch size.
+func (set *keySet) files() []keyFileInfo {
+	files := []keyFileInfo{}
+	if set == nil {
+		return files
+	}
+	for _, entry := range set.entries {
+		info := keyFileInfo{
+			Path:        entry.file.Path,
+			circuitInfo: newCircuitInfo(entry.file.Circuit),
+			Default:     entry.system == set.fallback,
+			Loaded:      entry.system.current.Load().provingSystem != nil,
+		}
+		info.Error, _ = entry.failure.Load().(string)
+		files = append(files, info)
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		if files[i].TreeDepth != files[j].TreeDepth {
+			return files[i].TreeDepth < files[j].TreeDepth
+		}
+		return files[i].BatchSize < files[j].BatchSize
+	})
+	return files
+}
+
+// keysHandler lists the key files of the keys directory.
+type keysHandler struct {
+	keys *keySet
+}
+
+func (handler keysHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	files := handler.keys.files()
+	responseBytes, err := json.Marshal(files)
+	if err != nil {
+		unexpectedError(err).send(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(responseBytes)
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"worldcoin/gnark-mbu/prover"
+
+	"github.com/consensys/gnark-crypto/ecc"
+)
+
+func TestKeySet(t *testing.T) {
+	circuit := func(treeDepth, batchSize uint32, indexed bool) *prover.ProvingSystem {
+		return &prover.ProvingSystem{Curve: ecc.BN254, TreeDepth: treeDepth, BatchSize: batchSize, Indexed: indexed}
+	}
+	files := []KeyFile{
+		{Path: "small", Circuit: circuit(2, 1, false)},
+		{Path: "large", Circuit: circuit(2, 4, false)},
+		{Path: "indexed", Circuit: circuit(2, 1, true)},
+		{Path: "broken", Circuit: circuit(3, 1, false)},
+	}
+	var loads []string
+	load := func(path string) (*prover.ProvingSystem, error) {
+		loads = append(loads, path)
+		if path == "broken" {
+			return nil, errors.New("broken keys")
+		}
+		for _, file := range files {
+			if file.Path == path {
+				return file.Circuit, nil
+			}
+		}
+		return nil, os.ErrNotExist
+	}
+	fallback := newActiveSystem(files[1].Circuit)
+	set := newKeySet(files, load, true, fallback)
+	if len(loads) != 0 {
+		t.Fatalf("expected lazy keys not to be loaded, loaded %v", loads)
+	}
+
+	params := func(batchSize, treeDepth int, indices ...uint32) *prover.Parameters {
+		p := &prover.Parameters{IdComms: make([]big.Int, batchSize), MerkleProofs: make([][]big.Int, batchSize), Indices: indices}
+		for i := range p.MerkleProofs {
+			p.MerkleProofs[i] = make([]big.Int, treeDepth)
+		}
+		return p
+	}
+	if system := set.systemFor(params(1, 2)); system.current.Load().provingSystem != files[0].Circuit {
+		t.Fatal("expected the small keys to prove batches of one")
+	}
+	if system := set.systemFor(params(1, 2, 7)); system.current.Load().provingSystem != files[2].Circuit {
+		t.Fatal("expected the indexed keys to prove batches with indices")
+	}
+	if system := set.systemFor(params(4, 2)); system != fallback {
+		t.Fatal("expected the default keys to be shared with their file")
+	}
+	if system := set.systemFor(params(2, 2)); system != fallback {
+		t.Fatal("expected unmatched batches to fall back to the default keys")
+	}
+	explicit := params(1, 3)
+	explicit.TreeDepth = 2
+	if system := set.systemFor(explicit); system.current.Load().provingSystem != files[0].Circuit {
+		t.Fatal("expected batches to be routed by the tree depth they select")
+	}
+	if system := set.splitSystemFor(params(9, 2)); system != fallback {
+		t.Fatal("expected batches to be split with the largest batch size")
+	}
+	if system := set.systemFor(params(1, 3)); system.current.Load().provingSystem != nil {
+		t.Fatal("expected keys failing to load to be unavailable")
+	}
+	if len(loads) != 3 {
+		t.Fatalf("expected each file to be loaded once, loaded %v", loads)
+	}
+
+	recorder := httptest.NewRecorder()
+	keysHandler{keys: set}.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/keys", nil))
+	var listed []keyFileInfo
+	if err := json.Unmarshal(recorder.Body.Bytes(), &listed); err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 4 || listed[0].Path != "small" || listed[2].Path != "large" || !listed[2].Default || listed[3].Error != "broken keys" || listed[1].Mode != "indexed" {
+		t.Fatalf("unexpected key files %s", recorder.Body)
+	}
+}
+
+func TestScanKeysDir(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not a key file"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	files, err := ScanKeysDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Fatalf("expected files that are not key files to be skipped, got %v", files)
+	}
+	if _, err = ScanKeysDir(filepath.Join(dir, "missing")); err == nil {
+		t.Fatal("expected a missing directory to fail")
+	}
+}
@@ -0,0 +1,301 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"worldcoin/gnark-mbu/logging"
+	"worldcoin/gnark-mbu/prover"
+	"worldcoin/gnark-mbu/redis"
+)
+
+// LedgerBatch is a batch of insertions as recorded in a Ledger.
+type LedgerBatch struct {
+	// StartIndex is the index of the first insertion, unless Indexed.
+	StartIndex uint32
+	PreRoot    big.Int
+	PostRoot   big.Int
+	// Indexed is set for batches inserting at arbitrary indices, whose
+	// StartIndex is not recorded.
+	Indexed bool
+}
+
+func newLedgerBatch(provingSystem *prover.ProvingSystem, params *prover.Parameters) *LedgerBatch {
+	batch := &LedgerBatch{StartIndex: params.StartIndex, Indexed: provingSystem.Indexed}
+	batch.PreRoot.Set(&params.PreRoot)
+	batch.PostRoot.Set(&params.PostRoot)
+	return batch
+}
+
+// keys returns the keys batch is recorded under: its pre root and, unless it
+// is indexed, its start index. A batch conflicts with the recorded batches
+// sharing one of them.
+func (batch *LedgerBatch) keys() []string {
+	keys := []string{"pre:" + batch.PreRoot.Text(16)}
+	if !batch.Indexed {
+		keys = append(keys, "index:"+strconv.FormatUint(uint64(batch.StartIndex), 10))
+	}
+	return keys
+}
+
+// String encodes the batch as recorded in a Ledger.
+func (batch *LedgerBatch) String() string {
+	startIndex := "-"
+	if !batch.Indexed {
+		startIndex = strconv.FormatUint(uint64(batch.StartIndex), 10)
+	}
+	return startIndex + ":" + batch.PreRoot.Text(16) + ":" + batch.PostRoot.Text(16)
+}
+
+func parseLedgerBatch(s string) (*LedgerBatch, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("invalid ledger batch %q", s)
+	}
+	batch := &LedgerBatch{Indexed: parts[0] == "-"}
+	if !batch.Indexed {
+		startIndex, err := strconv.ParseUint(parts[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ledger batch %q: %w", s, err)
+		}
+		batch.StartIndex = uint32(startIndex)
+	}
+	_, okPre := batch.PreRoot.SetString(parts[1], 16)
+	_, okPost := batch.PostRoot.SetString(parts[2], 16)
+	if !okPre || !okPost {
+		return nil, fmt.Errorf("invalid ledger batch %q", s)
+	}
+	return batch, nil
+}
+
+// LedgerConflictError is returned by Ledger.Reserve for a batch that was
+// already reserved, or that conflicts with a reserved batch.
+type LedgerConflictError struct {
+	// Recorded is the reserved batch.
+	Recorded *LedgerBatch
+	// Duplicate is set if Recorded is the same batch.
+	Duplicate bool
+}
+
+func (err *LedgerConflictError) Error() string {
+	if err.Duplicate {
+		return fmt.Sprintf("the batch from pre root %#x to post root %#x was already proven", &err.Recorded.PreRoot, &err.Recorded.PostRoot)
+	}
+	if err.Recorded.Indexed {
+		return fmt.Sprintf("the batch conflicts with the batch from pre root %#x to post root %#x", &err.Recorded.PreRoot, &err.Recorded.PostRoot)
+	}
+	return fmt.Sprintf("the batch conflicts with the batch at start index %d from pre root %#x to post root %#x", err.Recorded.StartIndex, &err.Recorded.PreRoot, &err.Recorded.PostRoot)
+}
+
+func newLedgerConflictError(batch *LedgerBatch, recorded string) error {
+	if recorded == batch.String() {
+		return &LedgerConflictError{Recorded: batch, Duplicate: true}
+	}
+	parsed, err := parseLedgerBatch(recorded)
+	if err != nil {
+		return err
+	}
+	return &LedgerConflictError{Recorded: parsed}
+}
+
+// errLedgerUnavailable wraps the failures of the Ledger, which reject the
+// proofs rather than risk proving contradictory batches.
+var errLedgerUnavailable = errors.New("the batch ledger is unavailable")
+
+func ledgerUnavailableError(err error) *Error {
+	return &Error{StatusCode: http.StatusServiceUnavailable, Code: "ledger_unavailable", Message: err.Error()}
+}
+
+func ledgerConflictError(err *LedgerConflictError) *Error {
+	code := "conflicting_batch"
+	if err.Duplicate {
+		code = "duplicate_batch"
+	}
+	return &Error{StatusCode: http.StatusConflict, Code: code, Message: err.Error()}
+}
+
+// Ledger records the batches the server proves, so that the sequencer
+// instances sharing it cannot have duplicate or contradictory batches
+// proven: batches with the same pre root or start index as a recorded one.
+// It is safe for concurrent use.
+type Ledger interface {
+	// Reserve records batch before it is proven, or returns a
+	// *LedgerConflictError if it conflicts with a recorded batch.
+	Reserve(batch *LedgerBatch) error
+	// Release forgets batch, whose proof failed, so that it can be
+	// requested again.
+	Release(batch *LedgerBatch) error
+}
+
+// memoryLedger is a Ledger of a single server.
+type memoryLedger struct {
+	mu      sync.Mutex
+	batches map[string]string
+}
+
+// NewMemoryLedger returns a Ledger holding the batches in memory, which is
+// lost on restart.
+func NewMemoryLedger() Ledger {
+	return &memoryLedger{batches: make(map[string]string)}
+}
+
+func (ledger *memoryLedger) Reserve(batch *LedgerBatch) error {
+	ledger.mu.Lock()
+	defer ledger.mu.Unlock()
+	keys := batch.keys()
+	for _, key := range keys {
+		if recorded, ok := ledger.batches[key]; ok {
+			return newLedgerConflictError(batch, recorded)
+		}
+	}
+	for _, key := range keys {
+		ledger.batches[key] = batch.String()
+	}
+	return nil
+}
+
+func (ledger *memoryLedger) Release(batch *LedgerBatch) error {
+	ledger.mu.Lock()
+	defer ledger.mu.Unlock()
+	for _, key := range batch.keys() {
+		if ledger.batches[key] == batch.String() {
+			delete(ledger.batches, key)
+		}
+	}
+	return nil
+}
+
+// redisReserveScript records ARGV[1] under KEYS, unless one of them holds a
+// batch already, which it returns.
+const redisReserveScript = `
+for _, key in ipairs(KEYS) do
+	local recorded = redis.call('GET', key)
+	if recorded then
+		return recorded
+	end
+end
+for _, key in ipairs(KEYS) do
+	redis.call('SET', key, ARGV[1])
+end
+return false`
+
+// redisReleaseScript deletes the KEYS holding ARGV[1].
+const redisReleaseScript = `
+for _, key in ipairs(KEYS) do
+	if redis.call('GET', key) == ARGV[1] then
+		redis.call('DEL', key)
+	end
+end
+return 0`
+
+// redisLedger is a Ledger shared by the servers using the same Redis server.
+// Batches are checked and recorded by scripts, which Redis runs atomically.
+type redisLedger struct {
+	location *url.URL
+	prefix   string
+	mu       sync.Mutex
+	client   *redis.Client
+}
+
+// OpenRedisLedger returns a Ledger recording the batches on the Redis server
+// of a redis://[user:password@]host[:port][/<key prefix>] URL.
+func OpenRedisLedger(location string) (Ledger, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "redis" {
+		return nil, fmt.Errorf("invalid ledger, expected redis://host/<key prefix>: %s", location)
+	}
+	prefix := strings.TrimPrefix(u.Path, "/")
+	if prefix == "" {
+		prefix = "gnark-mbu:ledger:"
+	}
+	ledger := &redisLedger{location: u, prefix: prefix}
+	if ledger.client, err = redis.Dial(u); err != nil {
+		return nil, err
+	}
+	return ledger, nil
+}
+
+// eval runs script on the keys of batch, reconnecting first if the previous
+// command failed.
+func (ledger *redisLedger) eval(script string, batch *LedgerBatch) (interface{}, error) {
+	ledger.mu.Lock()
+	client := ledger.client
+	if client == nil {
+		var err error
+		if client, err = redis.Dial(ledger.location); err != nil {
+			ledger.mu.Unlock()
+			return nil, err
+		}
+		ledger.client = client
+	}
+	ledger.mu.Unlock()
+	keys := batch.keys()
+	args := []string{"EVAL", script, strconv.Itoa(len(keys))}
+	for _, key := range keys {
+		args = append(args, ledger.prefix+key)
+	}
+	reply, err := client.Do(append(args, batch.String())...)
+	if _, ok := err.(redis.Error); err != nil && !ok {
+		ledger.mu.Lock()
+		if ledger.client == client {
+			ledger.client = nil
+			client.Close()
+		}
+		ledger.mu.Unlock()
+	}
+	return reply, err
+}
+
+func (ledger *redisLedger) Reserve(batch *LedgerBatch) error {
+	reply, err := ledger.eval(redisReserveScript, batch)
+	if err != nil {
+		return err
+	}
+	if recorded, ok := reply.(string); ok {
+		return newLedgerConflictError(batch, recorded)
+	}
+	return nil
+}
+
+func (ledger *redisLedger) Release(batch *LedgerBatch) error {
+	_, err := ledger.eval(redisReleaseScript, batch)
+	return err
+}
+
+// batchLedger reserves the batches of proofs in a Ledger. A nil ledger
+// reserves nothing.
+type batchLedger struct {
+	store Ledger
+}
+
+// reserve reserves the batch of params, returning a function releasing it
+// if its proof fails.
+func (ledger *batchLedger) reserve(provingSystem *prover.ProvingSystem, params *prover.Parameters) (func(error), error) {
+	if ledger == nil {
+		return func(error) {}, nil
+	}
+	batch := newLedgerBatch(provingSystem, params)
+	if err := ledger.store.Reserve(batch); err != nil {
+		var conflict *LedgerConflictError
+		if errors.As(err, &conflict) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %s", errLedgerUnavailable, err)
+	}
+	return func(proofErr error) {
+		if proofErr == nil {
+			return
+		}
+		if err := ledger.store.Release(batch); err != nil {
+			logging.Logger().Warn().Err(err).Msg("failed to release the batch of a failed proof from the ledger")
+		}
+	}, nil
+}
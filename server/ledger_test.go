@@ -0,0 +1,146 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"worldcoin/gnark-mbu/prover"
+	"worldcoin/gnark-mbu/redis"
+)
+
+// fakeRedisLedger runs the ledger scripts on conn like Redis would.
+func fakeRedisLedger(listener net.Listener) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	values := make(map[string]string)
+	for {
+		command, err := redis.ReadReply(reader)
+		if err != nil {
+			return
+		}
+		args := command.([]interface{})
+		if args[0] != "EVAL" {
+			fmt.Fprintf(conn, "-ERR unknown command '%s'\r\n", args[0])
+			continue
+		}
+		n, _ := strconv.Atoi(args[2].(string))
+		keys, batch := args[3:3+n], args[3+n].(string)
+		switch args[1] {
+		case redisReserveScript:
+			recorded := ""
+			for _, key := range keys {
+				if value, ok := values[key.(string)]; ok && recorded == "" {
+					recorded = value
+				}
+			}
+			if recorded != "" {
+				fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(recorded), recorded)
+				continue
+			}
+			for _, key := range keys {
+				values[key.(string)] = batch
+			}
+			io.WriteString(conn, "$-1\r\n")
+		case redisReleaseScript:
+			for _, key := range keys {
+				if values[key.(string)] == batch {
+					delete(values, key.(string))
+				}
+			}
+			io.WriteString(conn, ":0\r\n")
+		}
+	}
+}
+
+// failingLedger is a Ledger that cannot be reached.
+type failingLedger struct{}
+
+func (failingLedger) Reserve(batch *LedgerBatch) error {
+	return errors.New("connection refused")
+}
+
+func (failingLedger) Release(batch *LedgerBatch) error {
+	return errors.New("connection refused")
+}
+
+func TestLedger(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go fakeRedisLedger(listener)
+	redisLedger, err := OpenRedisLedger("redis://" + listener.Addr().String() + "/ledger:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ps := &prover.ProvingSystem{}
+	batch := func(startIndex uint32, preRoot, postRoot int64) *prover.Parameters {
+		params := &prover.Parameters{StartIndex: startIndex}
+		params.PreRoot.SetInt64(preRoot)
+		params.PostRoot.SetInt64(postRoot)
+		return params
+	}
+	for name, store := range map[string]Ledger{"memory": NewMemoryLedger(), "redis": redisLedger} {
+		ledger := &batchLedger{store: store}
+		release, err := ledger.reserve(ps, batch(0, 1, 2))
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		release(nil)
+		for _, test := range []struct {
+			params    *prover.Parameters
+			code      string
+			duplicate bool
+		}{
+			{batch(0, 1, 2), "duplicate_batch", true},
+			{batch(0, 1, 3), "conflicting_batch", false},
+			{batch(0, 5, 6), "conflicting_batch", false},
+		} {
+			_, err = ledger.reserve(ps, test.params)
+			var conflict *LedgerConflictError
+			if !errors.As(err, &conflict) || conflict.Duplicate != test.duplicate || proofError(err).Code != test.code {
+				t.Fatalf("%s: expected %s, got %v", name, test.code, err)
+			}
+		}
+
+		// Batches whose proof failed can be requested again.
+		release, err = ledger.reserve(ps, batch(2, 2, 3))
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		release(errors.New("proof failed"))
+		if _, err = ledger.reserve(ps, batch(2, 2, 4)); err != nil {
+			t.Fatalf("%s: expected the failed batch to be released, got %s", name, err)
+		}
+	}
+
+	// Indexed batches are only recorded by their pre root.
+	ledger := &batchLedger{store: NewMemoryLedger()}
+	indexed := &prover.ProvingSystem{Indexed: true}
+	if _, err = ledger.reserve(indexed, batch(0, 1, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ledger.reserve(indexed, batch(0, 2, 3)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Proofs are rejected while the ledger cannot be reached.
+	_, err = (&batchLedger{store: failingLedger{}}).reserve(ps, batch(0, 1, 2))
+	if proofError(err).Code != "ledger_unavailable" {
+		t.Fatalf("expected the ledger to be unavailable, got %v", err)
+	}
+	var disabled *batchLedger
+	if _, err = disabled.reserve(ps, batch(0, 1, 2)); err != nil {
+		t.Fatal(err)
+	}
+}
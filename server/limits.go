@@ -0,0 +1,166 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"worldcoin/gnark-mbu/prover"
+)
+
+// RequestLimits bound the requests the server reads, so that a single
+// oversized request cannot exhaust memory before its shape is validated.
+// Zero fields do not limit requests.
+type RequestLimits struct {
+	// MaxBodyBytes bounds the size of request bodies, both as sent and
+	// once decoded if they are compressed.
+	MaxBodyBytes int64
+	// MaxBatchSize bounds the number of elements of every array of a
+	// request, JSON or binary, which covers the identity commitments and
+	// Merkle proofs of a batch as well as the parameter sets of
+	// /prove_batch.
+	MaxBatchSize int
+	// MaxJSONDepth bounds the nesting of objects and arrays, or maps and
+	// arrays in binary requests.
+	MaxJSONDepth int
+}
+
+func requestTooLargeError(message string) *Error {
+	return &Error{StatusCode: http.StatusRequestEntityTooLarge, Code: "request_too_large", Message: message}
+}
+
+// readBody reads the body of r within the limits, decoding it if it is
+// compressed. Bodies which are not valid JSON, or valid in the binary format
+// of their content type, are returned as they are, for the decoder to report.
+func (limits RequestLimits) readBody(w http.ResponseWriter, r *http.Request) ([]byte, *Error) {
+	body := r.Body
+	if limits.MaxBodyBytes > 0 {
+		body = http.MaxBytesReader(w, r.Body, limits.MaxBodyBytes)
+	}
+	decoded, release, encodingErr := decodedBody(r, body)
+	if encodingErr != nil {
+		return nil, encodingErr
+	}
+	defer release()
+	if limits.MaxBodyBytes > 0 && decoded != body {
+		decoded = io.LimitReader(decoded, limits.MaxBodyBytes+1)
+	}
+	buf, err := io.ReadAll(decoded)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, requestTooLargeError(fmt.Sprintf("request body exceeds %d bytes", tooLarge.Limit))
+		}
+		return nil, malformedBodyError(err)
+	}
+	if limits.MaxBodyBytes > 0 && int64(len(buf)) > limits.MaxBodyBytes {
+		return nil, requestTooLargeError(fmt.Sprintf("decoded request body exceeds %d bytes", limits.MaxBodyBytes))
+	}
+	if format := requestFormat(r); format != "" {
+		if message := limits.checkBinary(format, buf); message != "" {
+			return nil, requestTooLargeError(message)
+		}
+	} else if message := limits.checkJSON(buf); message != "" {
+		return nil, requestTooLargeError(message)
+	}
+	return buf, nil
+}
+
+// checkBinary describes the first limit buf, encoded in format, exceeds, if
+// any. Maps count as objects.
+func (limits RequestLimits) checkBinary(format prover.BinaryFormat, buf []byte) string {
+	if limits.MaxBatchSize <= 0 && limits.MaxJSONDepth <= 0 {
+		return ""
+	}
+	value, err := format.Decode(buf)
+	if err != nil {
+		return ""
+	}
+	return limits.checkValue(value, 0)
+}
+
+// checkValue describes the first limit a decoded value nested in depth
+// containers exceeds.
+func (limits RequestLimits) checkValue(value interface{}, depth int) string {
+	var children []interface{}
+	switch value := value.(type) {
+	case []interface{}:
+		if limits.MaxBatchSize > 0 && len(value) > limits.MaxBatchSize {
+			return fmt.Sprintf("array exceeds the maximum batch size of %d elements", limits.MaxBatchSize)
+		}
+		children = value
+	case map[string]interface{}:
+		for _, child := range value {
+			children = append(children, child)
+		}
+	default:
+		return ""
+	}
+	if limits.MaxJSONDepth > 0 && depth >= limits.MaxJSONDepth {
+		return fmt.Sprintf("nesting exceeds a depth of %d", limits.MaxJSONDepth)
+	}
+	for _, child := range children {
+		if message := limits.checkValue(child, depth+1); message != "" {
+			return message
+		}
+	}
+	return ""
+}
+
+// checkParameters describes the first limit the arrays of decoded parameters
+// exceed, if any, whatever the content type they were decoded from.
+func (limits RequestLimits) checkParameters(params *prover.Parameters) string {
+	if limits.MaxBatchSize <= 0 {
+		return ""
+	}
+	lengths := []int{len(params.IdComms), len(params.MerkleProofs), len(params.Indices), len(params.Frontier)}
+	for _, proof := range params.MerkleProofs {
+		lengths = append(lengths, len(proof))
+	}
+	for _, length := range lengths {
+		if length > limits.MaxBatchSize {
+			return fmt.Sprintf("array exceeds the maximum batch size of %d elements", limits.MaxBatchSize)
+		}
+	}
+	return ""
+}
+
+// checkJSON scans buf without decoding it and describes the first limit it
+// exceeds, if any.
+func (limits RequestLimits) checkJSON(buf []byte) string {
+	if limits.MaxBatchSize <= 0 && limits.MaxJSONDepth <= 0 {
+		return ""
+	}
+	decoder := json.NewDecoder(bytes.NewReader(buf))
+	// lengths holds the number of elements of the enclosing arrays and -1
+	// for the enclosing objects.
+	var lengths []int
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return ""
+		}
+		closing := token == json.Delim(']') || token == json.Delim('}')
+		if !closing && len(lengths) > 0 && lengths[len(lengths)-1] >= 0 {
+			lengths[len(lengths)-1]++
+			if limits.MaxBatchSize > 0 && lengths[len(lengths)-1] > limits.MaxBatchSize {
+				return fmt.Sprintf("array exceeds the maximum batch size of %d elements", limits.MaxBatchSize)
+			}
+		}
+		switch token {
+		case json.Delim('['), json.Delim('{'):
+			if limits.MaxJSONDepth > 0 && len(lengths) >= limits.MaxJSONDepth {
+				return fmt.Sprintf("JSON nesting exceeds a depth of %d", limits.MaxJSONDepth)
+			}
+			length := 0
+			if token == json.Delim('{') {
+				length = -1
+			}
+			lengths = append(lengths, length)
+		case json.Delim(']'), json.Delim('}'):
+			lengths = lengths[:len(lengths)-1]
+		}
+	}
+}
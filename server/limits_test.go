@@ -0,0 +1,92 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"worldcoin/gnark-mbu/prover"
+)
+
+func TestRequestLimits(t *testing.T) {
+	limits := RequestLimits{MaxBodyBytes: 80, MaxBatchSize: 3, MaxJSONDepth: 3}
+	for _, test := range []struct {
+		body string
+		code string
+	}{
+		{body: `{"idComms": ["1", "2", "3"], "merkleProofs": [["1"], ["2"], ["3"]]}`},
+		{body: `{"idComms": ["1", "2", "3", "4"]}`, code: "request_too_large"},
+		{body: `[{"a": 1}, {"b": 2}, [], []]`, code: "request_too_large"},
+		{body: `{"a": {"b": [[1]]}}`, code: "request_too_large"},
+		{body: fmt.Sprintf(`{"inputHash": "%s"}`, strings.Repeat("0", 80)), code: "request_too_large"},
+		// Invalid JSON is left to the decoder.
+		{body: `{"idComms": [`},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/prove", strings.NewReader(test.body))
+		buf, err := limits.readBody(httptest.NewRecorder(), r)
+		if test.code == "" {
+			if err != nil || string(buf) != test.body {
+				t.Errorf("%s: expected the body to be read, got %v", test.body, err)
+			}
+			continue
+		}
+		if err == nil || err.Code != test.code || err.StatusCode != http.StatusRequestEntityTooLarge {
+			t.Errorf("%s: expected %s, got %+v", test.body, test.code, err)
+		}
+	}
+
+	// Zero limits read any body.
+	body := fmt.Sprintf(`[[[[%s1]]]]`, strings.Repeat("1, ", 100))
+	if _, err := (RequestLimits{}).readBody(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/prove", strings.NewReader(body))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRequestLimitsBinary(t *testing.T) {
+	limits := RequestLimits{MaxBatchSize: 3, MaxJSONDepth: 3}
+	for _, test := range []struct {
+		value interface{}
+		code  string
+	}{
+		{value: map[string]interface{}{"idComms": []interface{}{1, 2, 3}, "merkleProofs": []interface{}{[]interface{}{1}}}},
+		{value: map[string]interface{}{"idComms": []interface{}{1, 2, 3, 4}}, code: "request_too_large"},
+		{value: map[string]interface{}{"a": map[string]interface{}{"b": []interface{}{[]interface{}{1}}}}, code: "request_too_large"},
+	} {
+		body, err := prover.BinaryFormatCBOR.Marshal(test.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest(http.MethodPost, "/prove", bytes.NewReader(body))
+		r.Header.Set("Content-Type", prover.BinaryFormatCBOR.ContentType())
+		_, readErr := limits.readBody(httptest.NewRecorder(), r)
+		if test.code == "" {
+			if readErr != nil {
+				t.Errorf("%v: expected the body to be read, got %+v", test.value, readErr)
+			}
+			continue
+		}
+		if readErr == nil || readErr.Code != test.code || readErr.StatusCode != http.StatusRequestEntityTooLarge {
+			t.Errorf("%v: expected %s, got %+v", test.value, test.code, readErr)
+		}
+	}
+}
+
+func TestDecodeParametersLimits(t *testing.T) {
+	// The batch size is checked once decoded, whatever the content type.
+	params := &prover.Parameters{IdComms: make([]big.Int, 4)}
+	body, err := prover.BinaryFormatCBOR.Marshal(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/prove", bytes.NewReader(body))
+	r.Header.Set("Content-Type", prover.BinaryFormatCBOR.ContentType())
+	if _, decodeErr := (RequestLimits{MaxBatchSize: 3}).decodeParameters(r, body, false); decodeErr == nil || decodeErr.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected the batch to be too large, got %+v", decodeErr)
+	}
+	if _, decodeErr := (RequestLimits{MaxBatchSize: 4}).decodeParameters(r, body, false); decodeErr != nil {
+		t.Fatal(decodeErr.Message)
+	}
+}
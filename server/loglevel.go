@@ -0,0 +1,61 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"worldcoin/gnark-mbu/logging"
+
+	"github.com/rs/zerolog"
+)
+
+type logLevelJSON struct {
+	Level string `json:"level"`
+}
+
+// logLevelHandler reports the log level on GET and changes it on PUT, so
+// that debug logging can be turned on during incidents without a restart.
+// It is served on the metrics address, which is not meant to be public.
+type logLevelHandler struct{}
+
+func invalidLogLevelError(err error) *Error {
+	return &Error{StatusCode: http.StatusBadRequest, Code: "invalid_log_level", Message: err.Error()}
+}
+
+func (handler logLevelHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var request logLevelJSON
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			malformedBodyError(err).send(w)
+			return
+		}
+		level, err := zerolog.ParseLevel(request.Level)
+		if err == nil && request.Level == "" {
+			err = errors.New("missing level")
+		}
+		if err != nil {
+			invalidLogLevelError(err).send(w)
+			return
+		}
+		// The change is logged under the more verbose of both levels.
+		previous := logging.Level()
+		if level < previous {
+			logging.SetLevel(level)
+		}
+		logging.Logger().Info().Str("previous", previous.String()).Str("level", level.String()).Msg("log level changed")
+		logging.SetLevel(level)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	responseBytes, err := json.Marshal(logLevelJSON{Level: logging.Level().String()})
+	if err != nil {
+		unexpectedError(err).send(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(responseBytes)
+}
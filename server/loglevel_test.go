@@ -0,0 +1,39 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"worldcoin/gnark-mbu/logging"
+
+	"github.com/rs/zerolog"
+)
+
+func TestLogLevelHandler(t *testing.T) {
+	defer logging.SetLevel(logging.Level())
+	logging.SetLevel(zerolog.InfoLevel)
+
+	for _, test := range []struct {
+		method, body string
+		status       int
+		response     string
+		level        zerolog.Level
+	}{
+		{method: http.MethodGet, status: http.StatusOK, response: `{"level":"info"}`, level: zerolog.InfoLevel},
+		{method: http.MethodPut, body: `{"level": "debug"}`, status: http.StatusOK, response: `{"level":"debug"}`, level: zerolog.DebugLevel},
+		{method: http.MethodPut, body: `{"level": "loud"}`, status: http.StatusBadRequest, level: zerolog.DebugLevel},
+		{method: http.MethodPut, body: `{}`, status: http.StatusBadRequest, level: zerolog.DebugLevel},
+		{method: http.MethodPost, body: `{"level": "warn"}`, status: http.StatusMethodNotAllowed, level: zerolog.DebugLevel},
+		{method: http.MethodPut, body: `{"level": "warn"}`, status: http.StatusOK, response: `{"level":"warn"}`, level: zerolog.WarnLevel},
+	} {
+		w := httptest.NewRecorder()
+		logLevelHandler{}.ServeHTTP(w, httptest.NewRequest(test.method, "/log_level", strings.NewReader(test.body)))
+		if w.Code != test.status || (test.response != "" && w.Body.String() != test.response) {
+			t.Errorf("%s %s: expected %d %s, got %d %s", test.method, test.body, test.status, test.response, w.Code, w.Body.String())
+		}
+		if logging.Level() != test.level {
+			t.Errorf("%s %s: expected the level to be %s, got %s", test.method, test.body, test.level, logging.Level())
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"worldcoin/gnark-mbu/prover"
+)
+
+// MemoryBudget bounds the memory taken by the proofs generated at once, so
+// that a burst of large proofs is turned away instead of getting the prover
+// killed by the kernel.
+type MemoryBudget struct {
+	// Bytes is the memory the proofs may take together, on top of the keys.
+	Bytes uint64
+	// ProofBytes overrides the memory a proof is assumed to take, estimated
+	// by prover.ProvingSystem.ProofMemory if zero.
+	ProofBytes uint64
+	// Queue makes proofs that do not fit wait for running ones to finish
+	// instead of failing.
+	Queue bool
+}
+
+// memoryBudgetExceededError is the error of proofs that do not fit in the
+// memory budget.
+type memoryBudgetExceededError struct {
+	needed, used, budget uint64
+}
+
+func (e *memoryBudgetExceededError) Error() string {
+	if e.needed > e.budget {
+		return fmt.Sprintf("the proof needs %d bytes of memory, more than the whole budget of %d bytes", e.needed, e.budget)
+	}
+	return fmt.Sprintf("the proof needs %d bytes of memory, %d of the budget of %d bytes are in use", e.needed, e.used, e.budget)
+}
+
+func memoryBudgetError(err *memoryBudgetExceededError) *Error {
+	return &Error{StatusCode: http.StatusServiceUnavailable, Code: "memory_budget_exceeded", Message: err.Error()}
+}
+
+// memoryBudget reserves the memory of proofs. A nil budget is unlimited.
+type memoryBudget struct {
+	config MemoryBudget
+
+	mu   sync.Mutex
+	used uint64
+	// released is closed and replaced whenever memory is released.
+	released chan struct{}
+}
+
+func newMemoryBudget(config MemoryBudget) *memoryBudget {
+	return &memoryBudget{config: config, released: make(chan struct{})}
+}
+
+// proofBytes returns the memory a proof of provingSystem is assumed to take.
+func (budget *memoryBudget) proofBytes(provingSystem *prover.ProvingSystem) uint64 {
+	if budget.config.ProofBytes != 0 {
+		return budget.config.ProofBytes
+	}
+	return provingSystem.ProofMemory()
+}
+
+// reserve reserves the memory of a proof of provingSystem, to be released
+// with the returned function. A proof that does not fit fails with a
+// *memoryBudgetExceededError, or waits for memory to be released if the
+// budget queues them, until cancel is closed. A proof needing more than the
+// whole budget always fails.
+func (budget *memoryBudget) reserve(provingSystem *prover.ProvingSystem, cancel <-chan struct{}) (release func(), err error) {
+	if budget == nil {
+		return func() {}, nil
+	}
+	needed := budget.proofBytes(provingSystem)
+	for {
+		budget.mu.Lock()
+		if budget.used+needed <= budget.config.Bytes {
+			budget.used += needed
+			memoryReservedGauge.Set(float64(budget.used))
+			budget.mu.Unlock()
+			return func() { budget.release(needed) }, nil
+		}
+		if needed > budget.config.Bytes || !budget.config.Queue {
+			err := &memoryBudgetExceededError{needed: needed, used: budget.used, budget: budget.config.Bytes}
+			budget.mu.Unlock()
+			memoryBudgetRejectionsCounter.Inc()
+			return nil, err
+		}
+		released := budget.released
+		budget.mu.Unlock()
+		select {
+		case <-released:
+		case <-cancel:
+			return nil, errShuttingDown
+		}
+	}
+}
+
+func (budget *memoryBudget) release(bytes uint64) {
+	budget.mu.Lock()
+	defer budget.mu.Unlock()
+	budget.used -= bytes
+	memoryReservedGauge.Set(float64(budget.used))
+	close(budget.released)
+	budget.released = make(chan struct{})
+}
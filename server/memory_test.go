@@ -0,0 +1,76 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestMemoryBudget(t *testing.T) {
+	var unbounded *memoryBudget
+	release, err := unbounded.reserve(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+
+	budget := newMemoryBudget(MemoryBudget{Bytes: 3, ProofBytes: 2})
+	release, err = budget.reserve(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = budget.reserve(nil, nil)
+	var budgetErr *memoryBudgetExceededError
+	if !errors.As(err, &budgetErr) || budgetErr.used != 2 {
+		t.Fatalf("expected the second proof to exceed the budget, got %v", err)
+	}
+	if proofError(err).StatusCode != http.StatusServiceUnavailable || proofError(err).Code != "memory_budget_exceeded" {
+		t.Fatalf("unexpected error %+v", proofError(err))
+	}
+	release()
+	if release, err = budget.reserve(nil, nil); err != nil {
+		t.Fatalf("expected the released memory to be reusable, got %v", err)
+	}
+	release()
+
+	// Proofs larger than the budget are rejected even when queueing.
+	budget = newMemoryBudget(MemoryBudget{Bytes: 1, ProofBytes: 2, Queue: true})
+	if _, err = budget.reserve(nil, nil); !errors.As(err, &budgetErr) {
+		t.Fatalf("expected the proof to exceed the budget, got %v", err)
+	}
+}
+
+func TestMemoryBudgetQueue(t *testing.T) {
+	budget := newMemoryBudget(MemoryBudget{Bytes: 2, ProofBytes: 2, Queue: true})
+	release, err := budget.reserve(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reserved := make(chan error, 1)
+	go func() {
+		release, err := budget.reserve(nil, nil)
+		if err == nil {
+			release()
+		}
+		reserved <- err
+	}()
+	select {
+	case err = <-reserved:
+		t.Fatalf("expected the second proof to wait, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	release()
+	if err = <-reserved; err != nil {
+		t.Fatal(err)
+	}
+
+	// Waiting proofs are cancelled with the server's proofs.
+	release, _ = budget.reserve(nil, nil)
+	defer release()
+	cancel := make(chan struct{})
+	close(cancel)
+	if _, err = budget.reserve(nil, cancel); !errors.Is(err, errShuttingDown) {
+		t.Fatalf("expected the waiting proof to be cancelled, got %v", err)
+	}
+}
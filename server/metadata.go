@@ -0,0 +1,53 @@
+package server
+
+import (
+	"time"
+	"worldcoin/gnark-mbu/buildinfo"
+	"worldcoin/gnark-mbu/prover"
+)
+
+// proofWithMetadata holds the encoded proof, a json.Marshaler or a
+// prover.ProofBinary, with its metadata.
+type proofWithMetadata struct {
+	Proof    interface{}    `json:"proof"`
+	Metadata *proofMetadata `json:"metadata"`
+}
+
+type circuitIdentifier struct {
+	Curve     string `json:"curve"`
+	TreeDepth uint32 `json:"treeDepth"`
+	BatchSize uint32 `json:"batchSize"`
+	// Version is prover.CircuitSemver.
+	Version string `json:"version"`
+}
+
+type proofTiming struct {
+	ProvingMillis int64 `json:"provingMillis"`
+}
+
+// proofMetadata lets callers sanity-check a proof without recomputing the
+// public inputs.
+type proofMetadata struct {
+	InputHash     string            `json:"inputHash"`
+	PreRoot       string            `json:"preRoot"`
+	PostRoot      string            `json:"postRoot"`
+	ProverVersion string            `json:"proverVersion"`
+	Circuit       circuitIdentifier `json:"circuit"`
+	Timing        proofTiming       `json:"timing"`
+}
+
+func newProofMetadata(ps *prover.ProvingSystem, params *prover.Parameters, took time.Duration, numbers prover.NumberFormat) *proofMetadata {
+	return &proofMetadata{
+		InputHash:     numbers.Format(&params.InputHash),
+		PreRoot:       numbers.Format(&params.PreRoot),
+		PostRoot:      numbers.Format(&params.PostRoot),
+		ProverVersion: buildinfo.Version,
+		Circuit: circuitIdentifier{
+			Curve:     ps.Curve.String(),
+			TreeDepth: ps.TreeDepth,
+			BatchSize: ps.BatchSize,
+			Version:   prover.CircuitSemver,
+		},
+		Timing: proofTiming{ProvingMillis: took.Milliseconds()},
+	}
+}
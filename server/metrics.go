@@ -0,0 +1,223 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"runtime"
+	"strconv"
+	"time"
+	"worldcoin/gnark-mbu/logging"
+	"worldcoin/gnark-mbu/metrics"
+	"worldcoin/gnark-mbu/prover"
+)
+
+// proofLabels are the labels of the proof metrics: the tree depth, batch
+// size and mode of the circuit, and the outcome of the proof.
+var proofLabels = []string{"tree_depth", "batch_size", "circuit_mode", "outcome"}
+
+// Outcomes of proofs, as labelled in the proof metrics.
+const (
+	outcomeOK           = "ok"
+	outcomeInvalidInput = "invalid_input"
+	outcomeUnsatisfied  = "unsatisfied"
+	outcomeTimeout      = "timeout"
+	outcomeCancelled    = "cancelled"
+	outcomeError        = "error"
+)
+
+var (
+	queueDepthGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "prover_queue_depth",
+		Help: "Number of proof requests waiting for a proving slot.",
+	})
+	queueDroppedCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "prover_queue_dropped_total",
+		Help: "Number of queued proofs dropped before taking a slot, because no request waited for them anymore or the server shut down.",
+	})
+	runningProofsGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "prover_running_proofs",
+		Help: "Number of proofs currently being generated.",
+	})
+	// proofsInStageGauge follows the stages of prover.ProveWithProgress, the
+	// FFT and multi-exponentiation ones being estimated.
+	proofsInStageGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "prover_proofs_in_stage",
+		Help: "Number of proofs currently being generated, by stage (witness, solving, fft, msm_g1 or msm_g2).",
+	}, []string{"stage"})
+	memoryReservedGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "prover_memory_reserved_bytes",
+		Help: "Memory reserved by the proofs being generated, out of the memory budget.",
+	})
+	memoryBudgetRejectionsCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "prover_memory_budget_rejections_total",
+		Help: "Number of proofs rejected because they did not fit in the memory budget.",
+	})
+	proofDurationHistogram = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "prover_proof_duration_seconds",
+		Help:    "Time taken to generate a proof, excluding queueing, by circuit and outcome (ok, unsatisfied, cancelled or error).",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+	}, proofLabels)
+	proofsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "prover_proofs_total",
+		Help: "Number of proofs requested, by circuit and outcome (ok, invalid_input, unsatisfied, timeout, cancelled or error).",
+	}, proofLabels)
+	provingThreadsGauge = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "prover_proving_threads",
+		Help: "Number of threads proofs run on (GOMAXPROCS).",
+	}, func() float64 {
+		return float64(runtime.GOMAXPROCS(0))
+	})
+	recommendedReplicasGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "prover_autoscale_recommended_replicas",
+		Help: "Number of replicas needed to serve this replica's queue within its deadlines. Sum across replicas to get the desired replica count.",
+	})
+	proverHealthyGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "prover_healthy",
+		Help: "Whether the server is able to generate proofs (1) or is degraded (0).",
+	})
+	drainingGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "prover_draining",
+		Help: "Whether the server is draining proof requests before shutting down (1) or not (0).",
+	})
+	rateLimitedCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "prover_rate_limited_requests_total",
+		Help: "Number of requests rejected by the rate limits, by the limit exceeded (ip, client or tenant).",
+	}, []string{"scope"})
+	tenantRequestsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "prover_tenant_requests_total",
+		Help: "Number of requests served for each tenant, by HTTP status.",
+	}, []string{"tenant", "status"})
+	tenantRequestsInFlightGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "prover_tenant_requests_in_flight",
+		Help: "Number of requests of each tenant being served.",
+	}, []string{"tenant"})
+	proofCacheLookupsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "prover_proof_cache_lookups_total",
+		Help: "Number of proofs looked up in the proof cache, by result (hit, miss or error).",
+	}, []string{"result"})
+	coalescedRequestsCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "prover_coalesced_requests_total",
+		Help: "Number of proofs requested while the same proof was being generated for another request, which they shared.",
+	})
+	selfVerificationFailuresCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "prover_self_verification_failures_total",
+		Help: "Number of generated proofs that did not verify against the verifying key with self-verify.",
+	})
+	resultSigningFailuresCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "prover_result_signing_failures_total",
+		Help: "Number of proofs the operator key failed to sign with result-signing-key.",
+	})
+	proofRetriesCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "prover_proof_retries_total",
+		Help: "Number of proofs tried again after failing with a transient error, by error code.",
+	}, []string{"code"})
+	proofRetriesExhaustedCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "prover_proof_retries_exhausted_total",
+		Help: "Number of proofs failing with a transient error after all their retries, by error code.",
+	}, []string{"code"})
+	slowRequestsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "prover_slow_requests_total",
+		Help: "Number of proof requests taking longer than slow-request-threshold to serve, by path.",
+	}, []string{"path"})
+	callbackAttemptsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "prover_callback_attempts_total",
+		Help: "Number of callbacks posted, by the status class of the response (2xx, 4xx, 5xx) or error if none was received.",
+	}, []string{"result"})
+	callbackDeliveriesCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "prover_callback_deliveries_total",
+		Help: "Number of callbacks delivered or given up on after their attempts, by result (delivered or failed).",
+	}, []string{"result"})
+	callbackDeliveryDurationHistogram = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "prover_callback_delivery_duration_seconds",
+		Help:    "Time taken to deliver a callback, including retries.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 4, 10),
+	})
+)
+
+// proofOutcome returns the outcome of a proof that failed with err, or
+// succeeded if err is nil. Timeouts are reported by the handlers.
+func proofOutcome(err error) string {
+	var (
+		panicErr            *proverPanicError
+		budgetErr           *memoryBudgetExceededError
+		witnessErr          *prover.WitnessError
+		selfVerificationErr *selfVerificationError
+	)
+	switch {
+	case err == nil:
+		return outcomeOK
+	case errors.Is(err, errShuttingDown):
+		return outcomeCancelled
+	case errors.Is(err, errProofAbandoned):
+		return outcomeTimeout
+	case errors.As(err, &witnessErr):
+		return outcomeUnsatisfied
+	case errors.As(err, &panicErr), errors.As(err, &budgetErr), errors.As(err, &selfVerificationErr), errors.Is(err, errIdempotencyKeyReused):
+		return outcomeError
+	case prover.ErrorCode(err) != "proving_error":
+		return outcomeInvalidInput
+	}
+	return outcomeError
+}
+
+func proofLabelValues(shape keyShape, outcome string) []string {
+	return []string{strconv.FormatUint(uint64(shape.treeDepth), 10), strconv.FormatUint(uint64(shape.batchSize), 10), shape.mode(), outcome}
+}
+
+// countProof counts a proof requested for the circuit of shape.
+func countProof(shape keyShape, outcome string) {
+	proofsCounter.WithLabelValues(proofLabelValues(shape, outcome)...).Inc()
+}
+
+// observeProof records the time taken to generate a proof for the circuit of
+// shape.
+func observeProof(shape keyShape, outcome string, took time.Duration) {
+	proofDurationHistogram.WithLabelValues(proofLabelValues(shape, outcome)...).Observe(took.Seconds())
+}
+
+// spawnMetricsPushJob pushes the metrics to exporter every interval, and a
+// last time when stopped.
+var (
+	workerBusySecondsDesc = prometheus.NewDesc("prover_worker_busy_seconds_total",
+		"Time spent generating proofs, summed over the proofs running at once. Its rate divided by the proving slots is their utilization.", nil, nil)
+	workerUtilizationDesc = prometheus.NewDesc("prover_worker_utilization",
+		"Share of the proving slots (max-concurrent-proofs) generating a proof, omitted if they are unlimited.", nil, nil)
+)
+
+// queueCollector exports the worker metrics of the proof queue of a server.
+// It is registered with the registry of the server rather than globally, so
+// that every server reports its own queue.
+type queueCollector struct {
+	queue *proofQueue
+}
+
+func (c queueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- workerBusySecondsDesc
+	ch <- workerUtilizationDesc
+}
+
+func (c queueCollector) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(workerBusySecondsDesc, prometheus.CounterValue, c.queue.busySeconds(time.Now()))
+	if utilization, ok := c.queue.utilization(); ok {
+		ch <- prometheus.MustNewConstMetric(workerUtilizationDesc, prometheus.GaugeValue, utilization)
+	}
+}
+
+func spawnMetricsPushJob(gatherer prometheus.Gatherer, exporter metrics.Exporter, interval time.Duration) RunningJob {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	start := func() {
+		defer close(done)
+		metrics.Push(ctx, gatherer, exporter, interval)
+	}
+	shutdown := func() {
+		cancel()
+		<-done
+		if err := exporter.Close(); err != nil {
+			logging.Logger().Error().Err(err).Msg("error closing the metrics exporter")
+		}
+	}
+	return SpawnJob(start, shutdown)
+}
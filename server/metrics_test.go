@@ -0,0 +1,67 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"worldcoin/gnark-mbu/prover"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestProofOutcome(t *testing.T) {
+	for _, test := range []struct {
+		err     error
+		outcome string
+	}{
+		{nil, outcomeOK},
+		{errShuttingDown, outcomeCancelled},
+		{&prover.WitnessError{Err: errors.New("constraint not satisfied")}, outcomeUnsatisfied},
+		{fmt.Errorf("proof 1: %w", &prover.RootMismatchError{Proof: 1}), outcomeInvalidInput},
+		{&prover.BatchSizeError{}, outcomeInvalidInput},
+		{&proverPanicError{value: "boom"}, outcomeError},
+		{errors.New("unexpected"), outcomeError},
+	} {
+		if outcome := proofOutcome(test.err); outcome != test.outcome {
+			t.Errorf("expected %v to be %s, got %s", test.err, test.outcome, outcome)
+		}
+	}
+}
+
+func TestCountProof(t *testing.T) {
+	shape := keyShape{treeDepth: 20, batchSize: 4, indexed: true}
+	counter := proofsCounter.WithLabelValues("20", "4", "indexed", outcomeTimeout)
+	before := testutil.ToFloat64(counter)
+	countProof(shape, outcomeTimeout)
+	if count := testutil.ToFloat64(counter); count != before+1 {
+		t.Fatalf("expected the timeout to be counted, got %v proofs", count-before)
+	}
+}
+
+func TestQueueCollector(t *testing.T) {
+	// Every server reports the queue it holds.
+	busy := newProofQueue(2, 0, 0)
+	busy.running = 2
+	idle := newProofQueue(2, 0, 0)
+	for _, test := range []struct {
+		queue       *proofQueue
+		utilization string
+	}{
+		{busy, "prover_worker_utilization 1\n"},
+		{idle, "prover_worker_utilization 0\n"},
+		// Unlimited slots are not reported as over-utilized.
+		{newProofQueue(0, 0, 0), ""},
+	} {
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(queueCollector{queue: test.queue})
+		expected := ""
+		if test.utilization != "" {
+			expected = "# HELP prover_worker_utilization Share of the proving slots (max-concurrent-proofs) generating a proof, omitted if they are unlimited.\n# TYPE prover_worker_utilization gauge\n" + test.utilization
+		}
+		if err := testutil.GatherAndCompare(registry, strings.NewReader(expected), "prover_worker_utilization"); err != nil {
+			t.Error(err)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package server
+
+import "fmt"
+
+// Mode selects the endpoints a server serves, so that verifiers loading only
+// a verifying key and provers on large machines run the same binary.
+type Mode string
+
+const (
+	// ModeBoth serves the proof and the verification endpoints.
+	ModeBoth Mode = "both"
+	// ModeProver serves the proof endpoints only.
+	ModeProver Mode = "prover"
+	// ModeVerifier serves the verification endpoints only, which need no
+	// proving key.
+	ModeVerifier Mode = "verifier"
+)
+
+// ParseMode parses a server mode, accepting "" as ModeBoth.
+func ParseMode(s string) (Mode, error) {
+	switch Mode(s) {
+	case "", ModeBoth:
+		return ModeBoth, nil
+	case ModeProver, ModeVerifier:
+		return Mode(s), nil
+	}
+	return "", fmt.Errorf("unknown mode %q, expected both, prover or verifier", s)
+}
+
+// proves reports whether the proof endpoints are served in the mode.
+func (mode Mode) proves() bool {
+	return mode != ModeVerifier
+}
+
+// verifies reports whether the verification endpoints are served in the
+// mode.
+func (mode Mode) verifies() bool {
+	return mode != ModeProver
+}
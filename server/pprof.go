@@ -0,0 +1,22 @@
+package server
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+)
+
+// pprofMutexProfileFraction samples one in this many mutex contention events
+// for the mutex profile, which is empty unless sampling is turned on.
+const pprofMutexProfileFraction = 100
+
+// registerPprof serves the CPU, heap, goroutine, mutex and other profiles of
+// net/http/pprof under /debug/pprof/ on mux.
+func registerPprof(mux *http.ServeMux) {
+	runtime.SetMutexProfileFraction(pprofMutexProfileFraction)
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+}
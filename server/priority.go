@@ -0,0 +1,69 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// PriorityHeader carries the priority class of a proof request: critical,
+// normal (the default) or background. Waiting proofs of a higher class get
+// the proving slots first.
+const PriorityHeader = "X-Priority"
+
+// Priority is the priority class of a proof in the queue.
+type Priority int
+
+const (
+	// PriorityBackground is for traffic that can wait, such as re-proving
+	// or benchmarking.
+	PriorityBackground Priority = iota
+	PriorityNormal
+	// PriorityCritical is for the time-critical batches of the sequencer.
+	PriorityCritical
+)
+
+var priorityNames = map[Priority]string{
+	PriorityBackground: "background",
+	PriorityNormal:     "normal",
+	PriorityCritical:   "critical",
+}
+
+func (p Priority) String() string {
+	return priorityNames[p]
+}
+
+// ParsePriority parses the name of a priority class, the empty name
+// selecting the normal one.
+func ParsePriority(name string) (Priority, error) {
+	if name == "" {
+		return PriorityNormal, nil
+	}
+	for priority, priorityName := range priorityNames {
+		if name == priorityName {
+			return priority, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown priority %q, expected critical, normal or background", name)
+}
+
+func invalidPriorityError(err error) *Error {
+	return &Error{StatusCode: http.StatusBadRequest, Code: "invalid_priority", Message: err.Error()}
+}
+
+// schedule is how a proof is queued: by the deadline informing the
+// autoscaling signal, which may be zero, and by priority.
+type schedule struct {
+	deadline time.Time
+	priority Priority
+}
+
+// requestSchedule returns the schedule of the proofs of r, from its deadline
+// and priority headers.
+func requestSchedule(r *http.Request) (schedule, *Error) {
+	priority, err := ParsePriority(r.Header.Get(PriorityHeader))
+	if err != nil {
+		return schedule{}, invalidPriorityError(err)
+	}
+	return schedule{deadline: requestDeadline(r), priority: priority}, nil
+}
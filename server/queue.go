@@ -0,0 +1,277 @@
+package server
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// proofQueue bounds the number of concurrent proofs and keeps the statistics
+// the autoscaling signal is computed from. Freed slots go to the waiting
+// proof of the highest priority, the earliest on ties. A waiting proof is
+// promoted one class every aging, so that proofs of lower classes are not
+// starved by a steady stream of higher ones.
+type proofQueue struct {
+	// maxConcurrent bounds the running proofs, unbounded if zero.
+	maxConcurrent int
+	aging         time.Duration
+
+	mu sync.Mutex
+	// busy is the number of slots taken, waiters the proofs waiting for
+	// one.
+	busy    int
+	waiters []*queueWaiter
+	nextID  uint64
+	pending map[uint64]time.Time // deadline of every queued or running job
+	queued  int
+	running int
+	// busyTime is the time integral of running up to busySince, the last
+	// time running changed.
+	busyTime  time.Duration
+	busySince time.Time
+	// averageDuration is an exponentially weighted moving average of proof
+	// durations, zero until the first proof completes.
+	averageDuration time.Duration
+	// targetLatency is the deadline assumed for jobs without one.
+	targetLatency time.Duration
+}
+
+// durationSmoothing is the weight of the latest sample in averageDuration.
+const durationSmoothing = 0.2
+
+// defaultTargetLatency is used when no target latency is configured.
+const defaultTargetLatency = 5 * time.Minute
+
+// defaultPriorityAging is used when no priority aging is configured.
+const defaultPriorityAging = 5 * time.Minute
+
+// queueWaiter is a proof waiting for a slot, which is handed over by closing
+// ready.
+type queueWaiter struct {
+	id       uint64
+	priority Priority
+	enqueued time.Time
+	ready    chan struct{}
+}
+
+// effectivePriority is the priority of the waiter promoted for the time it
+// waited.
+func (w *queueWaiter) effectivePriority(now time.Time, aging time.Duration) Priority {
+	priority := w.priority + Priority(now.Sub(w.enqueued)/aging)
+	if priority > PriorityCritical {
+		return PriorityCritical
+	}
+	return priority
+}
+
+func newProofQueue(maxConcurrent int, targetLatency time.Duration, aging time.Duration) *proofQueue {
+	if targetLatency <= 0 {
+		targetLatency = defaultTargetLatency
+	}
+	if aging <= 0 {
+		aging = defaultPriorityAging
+	}
+	return &proofQueue{maxConcurrent: maxConcurrent, aging: aging, pending: make(map[uint64]time.Time), targetLatency: targetLatency}
+}
+
+// run waits for a proving slot and runs f in it. If ctx is done first, the
+// job leaves the queue without running and run returns the error of ctx, so
+// that requests which timed out do not take a slot. The deadline of the
+// schedule, which may be zero, only informs the autoscaling signal.
+func (q *proofQueue) run(ctx context.Context, s schedule, f func()) error {
+	q.mu.Lock()
+	id := q.nextID
+	q.nextID++
+	q.pending[id] = s.deadline
+	q.queued++
+	q.updateGauges()
+	if q.maxConcurrent > 0 && (q.busy >= q.maxConcurrent || len(q.waiters) > 0) {
+		waiter := &queueWaiter{id: id, priority: s.priority, enqueued: time.Now(), ready: make(chan struct{})}
+		q.waiters = append(q.waiters, waiter)
+		q.mu.Unlock()
+		select {
+		case <-waiter.ready:
+			q.mu.Lock()
+		case <-ctx.Done():
+			q.mu.Lock()
+			if q.leaveLocked(waiter) {
+				q.mu.Unlock()
+				queueDroppedCounter.Inc()
+				return ctx.Err()
+			}
+			// The slot was handed over meanwhile, so the job runs.
+		}
+	} else {
+		q.busy++
+	}
+	q.queued--
+	q.accountBusyLocked(time.Now())
+	q.running++
+	q.updateGauges()
+	q.mu.Unlock()
+
+	start := time.Now()
+	defer func() {
+		took := time.Since(start)
+
+		q.mu.Lock()
+		q.releaseLocked(time.Now())
+		delete(q.pending, id)
+		q.accountBusyLocked(time.Now())
+		q.running--
+		if q.averageDuration == 0 {
+			q.averageDuration = took
+		} else {
+			q.averageDuration = time.Duration(durationSmoothing*float64(took) + (1-durationSmoothing)*float64(q.averageDuration))
+		}
+		q.updateGauges()
+		q.mu.Unlock()
+	}()
+	f()
+	return nil
+}
+
+// leaveLocked removes a waiter whose context is done from the queue, and
+// reports false if it was handed a slot first.
+func (q *proofQueue) leaveLocked(waiter *queueWaiter) bool {
+	for i, w := range q.waiters {
+		if w == waiter {
+			q.waiters = append(q.waiters[:i], q.waiters[i+1:]...)
+			delete(q.pending, waiter.id)
+			q.queued--
+			q.updateGauges()
+			return true
+		}
+	}
+	return false
+}
+
+// releaseLocked hands the slot of a finished proof over to the waiter of the
+// highest effective priority, the earliest on ties, or frees it.
+func (q *proofQueue) releaseLocked(now time.Time) {
+	if len(q.waiters) == 0 {
+		q.busy--
+		return
+	}
+	next := 0
+	for i, waiter := range q.waiters[1:] {
+		// Waiters are in arrival order, so later ones only win with a
+		// strictly higher priority.
+		if waiter.effectivePriority(now, q.aging) > q.waiters[next].effectivePriority(now, q.aging) {
+			next = i + 1
+		}
+	}
+	close(q.waiters[next].ready)
+	q.waiters = append(q.waiters[:next], q.waiters[next+1:]...)
+}
+
+// accountBusyLocked adds the time the running proofs ran since the last
+// change of their number to busyTime.
+func (q *proofQueue) accountBusyLocked(now time.Time) {
+	if !q.busySince.IsZero() {
+		q.busyTime += time.Duration(q.running) * now.Sub(q.busySince)
+	}
+	q.busySince = now
+}
+
+// busySeconds returns the time spent generating proofs, summed over the
+// proofs running at once, including those still running.
+func (q *proofQueue) busySeconds(now time.Time) float64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.accountBusyLocked(now)
+	return q.busyTime.Seconds()
+}
+
+// utilization returns the share of the proving slots taken, or false if the
+// slots are unlimited.
+func (q *proofQueue) utilization() (float64, bool) {
+	if q.maxConcurrent <= 0 {
+		return 0, false
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return float64(q.running) / float64(q.maxConcurrent), true
+}
+
+func (q *proofQueue) capacity() int {
+	if q.maxConcurrent <= 0 {
+		return 1
+	}
+	return q.maxConcurrent
+}
+
+func (q *proofQueue) updateGauges() {
+	queueDepthGauge.Set(float64(q.queued))
+	runningProofsGauge.Set(float64(q.running))
+	recommendedReplicasGauge.Set(float64(q.signalLocked(time.Now()).RecommendedReplicas))
+}
+
+type autoscaleSignal struct {
+	QueueDepth             int     `json:"queueDepth"`
+	RunningProofs          int     `json:"runningProofs"`
+	Capacity               int     `json:"capacity"`
+	AverageProofSeconds    float64 `json:"averageProofSeconds"`
+	RecommendedReplicas    int     `json:"recommendedReplicas"`
+	EarliestDeadlineMillis *int64  `json:"earliestDeadlineMillis,omitempty"`
+}
+
+func (q *proofQueue) autoscale(now time.Time) autoscaleSignal {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.signalLocked(now)
+}
+
+// signalLocked computes the number of replicas needed to complete every
+// pending job before its deadline, assuming each replica serves capacity
+// proofs at a time. Jobs without a deadline are given targetLatency.
+//
+// Jobs are ordered by deadline; the k-th job can only complete in time if k
+// average proof durations of work fit into its remaining time, which needs
+// ceil(k * duration / remaining) proving slots. The recommendation is the
+// largest such requirement over all jobs.
+func (q *proofQueue) signalLocked(now time.Time) autoscaleSignal {
+	signal := autoscaleSignal{
+		QueueDepth:          q.queued,
+		RunningProofs:       q.running,
+		Capacity:            q.capacity(),
+		AverageProofSeconds: q.averageDuration.Seconds(),
+	}
+
+	deadlines := make([]time.Time, 0, len(q.pending))
+	for _, deadline := range q.pending {
+		if deadline.IsZero() {
+			deadline = now.Add(q.targetLatency)
+		}
+		deadlines = append(deadlines, deadline)
+	}
+	sort.Slice(deadlines, func(i, j int) bool { return deadlines[i].Before(deadlines[j]) })
+	if len(deadlines) > 0 {
+		earliest := deadlines[0].Sub(now).Milliseconds()
+		signal.EarliestDeadlineMillis = &earliest
+	}
+
+	duration := q.averageDuration
+	if duration == 0 {
+		// Without any sample, assume a proof takes the whole target latency.
+		duration = q.targetLatency
+	}
+	slots := 0
+	for k, deadline := range deadlines {
+		remaining := deadline.Sub(now)
+		if remaining < duration {
+			// Already late or about to be, the job needs a slot of its own.
+			remaining = duration
+		}
+		needed := int(math.Ceil(float64(k+1) * float64(duration) / float64(remaining)))
+		if needed > slots {
+			slots = needed
+		}
+	}
+	if slots > 0 {
+		signal.RecommendedReplicas = (slots + signal.Capacity - 1) / signal.Capacity
+	}
+	return signal
+}
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestAutoscaleSignal(t *testing.T) {
+	now := time.Now()
+	queue := newProofQueue(2, time.Hour, 0)
+	queue.averageDuration = time.Minute
+
+	if signal := queue.autoscale(now); signal.RecommendedReplicas != 0 {
+		t.Fatalf("expected no replicas for an empty queue, got %d", signal.RecommendedReplicas)
+	}
+
+	// Four jobs due within a minute need four proving slots, i.e. two
+	// replicas of capacity two.
+	for i := uint64(0); i < 4; i++ {
+		queue.pending[i] = now.Add(time.Minute)
+	}
+	if signal := queue.autoscale(now); signal.RecommendedReplicas != 2 {
+		t.Fatalf("expected 2 replicas, got %d", signal.RecommendedReplicas)
+	}
+
+	// Without deadlines, the same jobs fit into the target latency of one hour.
+	for i := uint64(0); i < 4; i++ {
+		queue.pending[i] = time.Time{}
+	}
+	if signal := queue.autoscale(now); signal.RecommendedReplicas != 1 {
+		t.Fatalf("expected 1 replica, got %d", signal.RecommendedReplicas)
+	}
+}
+
+func TestProofQueueBoundsConcurrency(t *testing.T) {
+	queue := newProofQueue(1, 0, 0)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go queue.run(context.Background(), schedule{}, func() {
+		close(started)
+		<-release
+	})
+	<-started
+
+	done := make(chan struct{})
+	go queue.run(context.Background(), schedule{}, func() { close(done) })
+	select {
+	case <-done:
+		t.Fatal("second job ran while the only slot was taken")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if signal := queue.autoscale(time.Now()); signal.QueueDepth != 1 || signal.RunningProofs != 1 {
+		t.Fatalf("unexpected queue state %+v", signal)
+	}
+	close(release)
+	<-done
+}
+
+func TestProofQueueDropsCancelledJobs(t *testing.T) {
+	queue := newProofQueue(1, 0, 0)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go queue.run(context.Background(), schedule{}, func() {
+		close(started)
+		<-release
+	})
+	<-started
+
+	// A job whose request timed out leaves the queue without running.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	dropped := testutil.ToFloat64(queueDroppedCounter)
+	if err := queue.run(ctx, schedule{}, func() { t.Error("expected the timed out job not to run") }); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the job to be dropped, got %v", err)
+	}
+	if testutil.ToFloat64(queueDroppedCounter) != dropped+1 {
+		t.Fatal("expected the dropped job to be counted")
+	}
+	if signal := queue.autoscale(time.Now()); signal.QueueDepth != 0 || signal.RunningProofs != 1 {
+		t.Fatalf("unexpected queue state %+v", signal)
+	}
+
+	// The slot goes to the next job once released.
+	done := make(chan struct{})
+	go queue.run(context.Background(), schedule{}, func() { close(done) })
+	close(release)
+	<-done
+}
+
+func TestProofQueuePriority(t *testing.T) {
+	queue := newProofQueue(1, 0, time.Hour)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go queue.run(context.Background(), schedule{}, func() {
+		close(started)
+		<-release
+	})
+	<-started
+
+	// Proofs queued in increasing priority run in decreasing priority.
+	order := make(chan Priority, 3)
+	for i, priority := range []Priority{PriorityBackground, PriorityNormal, PriorityCritical} {
+		priority := priority
+		go queue.run(context.Background(), schedule{priority: priority}, func() { order <- priority })
+		for {
+			queue.mu.Lock()
+			waiting := len(queue.waiters)
+			queue.mu.Unlock()
+			if waiting == i+1 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	close(release)
+	for _, expected := range []Priority{PriorityCritical, PriorityNormal, PriorityBackground} {
+		if priority := <-order; priority != expected {
+			t.Fatalf("expected the %s proof to run next, got the %s one", expected, priority)
+		}
+	}
+}
+
+func TestRequestSchedule(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/prove", nil)
+	if s, err := requestSchedule(r); err != nil || s.priority != PriorityNormal {
+		t.Fatalf("expected the normal priority by default, got %v (%v)", s.priority, err)
+	}
+	r.Header.Set(PriorityHeader, "background")
+	if s, err := requestSchedule(r); err != nil || s.priority != PriorityBackground {
+		t.Fatalf("expected the background priority, got %v (%v)", s.priority, err)
+	}
+	r.Header.Set(PriorityHeader, "urgent")
+	if _, err := requestSchedule(r); err == nil || err.Code != "invalid_priority" {
+		t.Fatalf("expected an unknown priority to be rejected, got %v", err)
+	}
+}
+
+func TestProofQueueAging(t *testing.T) {
+	now := time.Now()
+	queue := newProofQueue(1, 0, time.Minute)
+	queue.busy = 1
+	background := &queueWaiter{id: 0, priority: PriorityBackground, enqueued: now.Add(-2 * time.Minute), ready: make(chan struct{})}
+	critical := &queueWaiter{id: 1, priority: PriorityCritical, enqueued: now, ready: make(chan struct{})}
+	queue.waiters = []*queueWaiter{background, critical}
+	if priority := background.effectivePriority(now, queue.aging); priority != PriorityCritical {
+		t.Fatalf("expected the background proof to be promoted to critical, got %s", priority)
+	}
+
+	// The promoted proof waited longer, so it wins the tie.
+	queue.releaseLocked(now)
+	select {
+	case <-background.ready:
+	default:
+		t.Fatal("expected the starved background proof to get the slot")
+	}
+	if len(queue.waiters) != 1 || queue.waiters[0] != critical || queue.busy != 1 {
+		t.Fatalf("expected the critical proof to wait for the handed over slot, got %d waiters and %d busy slots", len(queue.waiters), queue.busy)
+	}
+}
+
+func TestProofQueueBusyTime(t *testing.T) {
+	queue := newProofQueue(2, 0, 0)
+	now := time.Now()
+	queue.accountBusyLocked(now)
+	queue.running = 2
+	// Running proofs are accounted for before they complete.
+	if busy := queue.busySeconds(now.Add(3 * time.Second)); busy != 6 {
+		t.Fatalf("expected 6 busy seconds, got %f", busy)
+	}
+	if utilization, ok := queue.utilization(); !ok || utilization != 1 {
+		t.Fatalf("expected full utilization, got %f", utilization)
+	}
+	queue.running = 1
+	if busy := queue.busySeconds(now.Add(5 * time.Second)); busy != 8 {
+		t.Fatalf("expected 8 busy seconds, got %f", busy)
+	}
+	if utilization, ok := queue.utilization(); !ok || utilization != 0.5 {
+		t.Fatalf("expected half utilization, got %f", utilization)
+	}
+	// Unlimited slots have no utilization, however many proofs run.
+	unlimited := newProofQueue(0, 0, 0)
+	unlimited.running = 3
+	if _, ok := unlimited.utilization(); ok {
+		t.Fatal("expected no utilization without a limit of proving slots")
+	}
+}
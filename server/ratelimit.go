@@ -0,0 +1,152 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimit is a token bucket holding up to Burst requests and refilled at
+// Rate requests per second. A zero Rate does not limit requests.
+type RateLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// RateLimits configure how often proofs may be requested, so that a
+// misbehaving client cannot starve the others.
+type RateLimits struct {
+	// IP limits requests per remote address. It is checked before the
+	// request is read.
+	IP RateLimit
+	// Client limits requests per client id. It is checked once the request
+	// is authenticated, so that clients cannot exhaust each other's buckets.
+	Client RateLimit
+	// Clients overrides Client for individual client ids.
+	Clients map[string]RateLimit
+}
+
+// maxBuckets bounds the number of buckets kept before full ones, which behave
+// like new ones, are dropped.
+const maxBuckets = 10000
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type rateLimiter struct {
+	limits  RateLimits
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	now     func() time.Time
+}
+
+func newRateLimiter(limits RateLimits) *rateLimiter {
+	return &rateLimiter{limits: limits, buckets: make(map[string]*tokenBucket), now: time.Now}
+}
+
+func rateLimitedError(scope string, retryAfter time.Duration) *Error {
+	return &Error{
+		StatusCode: http.StatusTooManyRequests,
+		Code:       "rate_limited",
+		Message:    fmt.Sprintf("too many requests per %s, retry in %s", scope, retryAfter),
+		RetryAfter: retryAfter,
+	}
+}
+
+// burst returns the size of the bucket, at least one request and by default
+// a second's worth of requests.
+func (limit RateLimit) burst() float64 {
+	if limit.Burst > 0 {
+		return float64(limit.Burst)
+	}
+	return math.Max(1, math.Ceil(limit.Rate))
+}
+
+// take takes a token from the bucket of key, returning how long to wait for
+// one if it is empty.
+func (l *rateLimiter) take(key string, limit RateLimit) (time.Duration, bool) {
+	if limit.Rate <= 0 {
+		return 0, true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	bucket, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxBuckets {
+			l.prune(now)
+		}
+		bucket = &tokenBucket{tokens: limit.burst(), last: now}
+		l.buckets[key] = bucket
+	}
+	return bucket.take(now, limit)
+}
+
+// take refills the bucket up to now and takes a token from it, returning how
+// long to wait for one if it is empty.
+func (bucket *tokenBucket) take(now time.Time, limit RateLimit) (time.Duration, bool) {
+	bucket.tokens = math.Min(limit.burst(), bucket.tokens+now.Sub(bucket.last).Seconds()*limit.Rate)
+	bucket.last = now
+	if bucket.tokens < 1 {
+		wait := time.Duration((1 - bucket.tokens) / limit.Rate * float64(time.Second))
+		return wait, false
+	}
+	bucket.tokens -= 1
+	return 0, true
+}
+
+// prune drops the buckets that have refilled since their last request.
+func (l *rateLimiter) prune(now time.Time) {
+	for key, bucket := range l.buckets {
+		limit := l.limits.IP
+		if clientId, ok := strings.CutPrefix(key, "client:"); ok {
+			limit = l.clientLimit(clientId)
+		}
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*limit.Rate >= limit.burst() {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func (l *rateLimiter) clientLimit(clientId string) RateLimit {
+	if limit, ok := l.limits.Clients[clientId]; ok {
+		return limit
+	}
+	return l.limits.Client
+}
+
+// allowIP checks the limit of the remote address of a request. A nil limiter
+// allows all requests.
+func (l *rateLimiter) allowIP(r *http.Request) *Error {
+	if l == nil {
+		return nil
+	}
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if wait, ok := l.take("ip:"+ip, l.limits.IP); !ok {
+		rateLimitedCounter.WithLabelValues("ip").Inc()
+		return rateLimitedError("IP address", wait)
+	}
+	return nil
+}
+
+// allowClient checks the limit of an authenticated client. Unsigned requests
+// are only limited per address.
+func (l *rateLimiter) allowClient(clientId string) *Error {
+	if l == nil || clientId == "" {
+		return nil
+	}
+	if wait, ok := l.take("client:"+clientId, l.clientLimit(clientId)); !ok {
+		rateLimitedCounter.WithLabelValues("client").Inc()
+		return rateLimitedError("client", wait)
+	}
+	return nil
+}
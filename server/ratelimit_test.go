@@ -0,0 +1,64 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Unix(0, 0)
+	limiter := newRateLimiter(RateLimits{
+		IP:      RateLimit{Rate: 1, Burst: 2},
+		Client:  RateLimit{Rate: 0.5},
+		Clients: map[string]RateLimit{"sequencer": {Rate: 100}},
+	})
+	limiter.now = func() time.Time { return now }
+
+	r := httptest.NewRequest(http.MethodPost, "/prove", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	for i := 0; i < 2; i++ {
+		if err := limiter.allowIP(r); err != nil {
+			t.Fatalf("request %d: expected the burst to be allowed, got %v", i, err)
+		}
+	}
+	err := limiter.allowIP(r)
+	if err == nil || err.StatusCode != http.StatusTooManyRequests || err.RetryAfter != time.Second {
+		t.Fatalf("expected the third request to wait a second, got %+v", err)
+	}
+	w := httptest.NewRecorder()
+	err.send(w)
+	if w.Header().Get("Retry-After") != "1" {
+		t.Fatalf("expected a Retry-After header, got %q", w.Header().Get("Retry-After"))
+	}
+
+	// Other addresses have their own buckets, which refill over time.
+	r.RemoteAddr = "10.0.0.2:1234"
+	if err = limiter.allowIP(r); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(time.Second)
+	r.RemoteAddr = "10.0.0.1:4321"
+	if err = limiter.allowIP(r); err != nil {
+		t.Fatalf("expected the bucket to refill, got %v", err)
+	}
+
+	if err = limiter.allowClient("prover-client"); err != nil {
+		t.Fatal(err)
+	}
+	if err = limiter.allowClient("prover-client"); err == nil || err.RetryAfter != 2*time.Second {
+		t.Fatalf("expected the client to wait two seconds, got %+v", err)
+	}
+	for i := 0; i < 100; i++ {
+		if err = limiter.allowClient("sequencer"); err != nil {
+			t.Fatalf("expected the override to apply, got %v", err)
+		}
+	}
+	if err = limiter.allowClient(""); err != nil {
+		t.Fatal("expected unsigned requests not to be limited per client")
+	}
+	if err = (*rateLimiter)(nil).allowIP(r); err != nil {
+		t.Fatal("expected a nil limiter to allow requests")
+	}
+}
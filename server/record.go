@@ -0,0 +1,43 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"time"
+	"worldcoin/gnark-mbu/logging"
+	"worldcoin/gnark-mbu/replay"
+)
+
+// record appends the POST requests served by next to recorder, sanitized by
+// replay.NewRequest, before serving them. Bodies larger than maxBodyBytes,
+// if positive, are not recorded. A nil recorder records nothing.
+func record(next http.Handler, recorder *replay.Recorder, maxBodyBytes int64) http.Handler {
+	if recorder == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			next.ServeHTTP(w, r)
+			return
+		}
+		received := time.Now()
+		body := io.Reader(r.Body)
+		if maxBodyBytes > 0 {
+			body = io.LimitReader(r.Body, maxBodyBytes+1)
+		}
+		buf, err := io.ReadAll(body)
+		// The handler reads the body again, including what was not read if
+		// it is too large.
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(buf), r.Body), r.Body}
+		if err == nil && (maxBodyBytes <= 0 || int64(len(buf)) <= maxBodyBytes) {
+			if err = recorder.Record(replay.NewRequest(r, buf, received)); err != nil {
+				logging.Logger().Error().Err(err).Msg("error recording request")
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
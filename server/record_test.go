@@ -0,0 +1,62 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"worldcoin/gnark-mbu/replay"
+)
+
+func TestRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "requests.ndjson")
+	recorder, err := replay.NewRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var served []string
+	handler := record(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		served = append(served, string(body))
+	}), recorder, 20)
+	send := func(method string, target string, body string) {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set(APIKeyHeader, "sequencer-key")
+		r.Header.Set(SignatureHeader, "signature")
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	send(http.MethodPost, "/prove?encoding=binary&callback_url=https://sequencer/proofs", `{"preRoot":"0x1"}`)
+	send(http.MethodPost, "/prove", `{"preRoot":"0x1","postRoot":"0x2"}`)
+	send(http.MethodGet, "/prove", "")
+	if err = recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(served) != 3 || served[0] != `{"preRoot":"0x1"}` || served[1] != `{"preRoot":"0x1","postRoot":"0x2"}` {
+		t.Fatalf("expected the handler to read the bodies as sent, got %q", served)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	requests, err := replay.ReadRequests(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The second body is too large and the GET has none to replay.
+	if len(requests) != 1 {
+		t.Fatalf("expected one request to be recorded, got %d", len(requests))
+	}
+	request := requests[0]
+	if request.Method != http.MethodPost || request.Path != "/prove?encoding=binary" || string(request.Body) != `{"preRoot":"0x1"}` {
+		t.Fatalf("unexpected recorded request %+v", request)
+	}
+	if len(request.Header) != 1 || request.Header["Content-Type"] != "application/json" {
+		t.Fatalf("expected the credentials not to be recorded, got %v", request.Header)
+	}
+}
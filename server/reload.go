@@ -0,0 +1,163 @@
+package server
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"worldcoin/gnark-mbu/logging"
+	"worldcoin/gnark-mbu/prover"
+)
+
+// generation is a proving system together with the requests using it, so
+// that a replaced system can be drained before it is released. Once it is
+// draining it can no longer be acquired, which the count of its requests and
+// the flag are guarded together for, so that no request acquires it while it
+// is found drained.
+type generation struct {
+	provingSystem *prover.ProvingSystem
+	mu            sync.Mutex
+	inFlight      int
+	draining      bool
+	// drained is closed once the generation is draining and no request uses
+	// it.
+	drained chan struct{}
+}
+
+func newGeneration(provingSystem *prover.ProvingSystem) *generation {
+	return &generation{provingSystem: provingSystem, drained: make(chan struct{})}
+}
+
+// tryAcquire counts a request using the generation, unless it is draining.
+func (g *generation) tryAcquire() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.draining {
+		return false
+	}
+	g.inFlight++
+	return true
+}
+
+// retain counts another use of a generation the caller holds, which may be
+// draining but cannot be drained yet.
+func (g *generation) retain() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.inFlight++
+}
+
+func (g *generation) release() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.inFlight--
+	if g.draining && g.inFlight == 0 {
+		close(g.drained)
+	}
+}
+
+// drain stops the generation from being acquired and returns a channel
+// closed once the requests using it have completed.
+func (g *generation) drain() <-chan struct{} {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.draining {
+		g.draining = true
+		if g.inFlight == 0 {
+			close(g.drained)
+		}
+	}
+	return g.drained
+}
+
+// activeSystem holds the proving system the handlers serve. It is swapped
+// atomically when the keys are reloaded; requests already holding the
+// previous generation keep using it until they complete.
+type activeSystem struct {
+	current atomic.Pointer[generation]
+	// verifier holds the verifying key loaded apart from the proving keys,
+	// nil if none was, which proofs are verified with while no proving
+	// system is loaded.
+	verifier *prover.ProvingSystem
+	// mu serializes reloads.
+	mu sync.Mutex
+}
+
+func newActiveSystem(provingSystem *prover.ProvingSystem) *activeSystem {
+	system := &activeSystem{}
+	system.current.Store(newGeneration(provingSystem))
+	return system
+}
+
+// acquire returns the current generation, which must be released once the
+// request is done with it. Its proving system is nil if no keys are loaded.
+func (system *activeSystem) acquire() *generation {
+	for {
+		// A reload may have swapped the generation and started draining it
+		// since it was loaded, in which case the new one is.
+		if g := system.current.Load(); g.tryAcquire() {
+			return g
+		}
+	}
+}
+
+// verifying returns the system the proofs of requests holding g are
+// verified with: the proving system of g if it is loaded, else the verifier.
+// It is nil if neither is.
+func (system *activeSystem) verifying(g *generation) *prover.ProvingSystem {
+	if g.provingSystem != nil {
+		return g.provingSystem
+	}
+	return system.verifier
+}
+
+// swap replaces the proving system and returns a channel closed once the
+// requests using the previous one have completed.
+func (system *activeSystem) swap(provingSystem *prover.ProvingSystem) <-chan struct{} {
+	return system.current.Swap(newGeneration(provingSystem)).drain()
+}
+
+// reload loads new keys and swaps them in, keeping the current keys if
+// loading fails.
+func (system *activeSystem) reload(loadKeys func() (*prover.ProvingSystem, error), health *health) {
+	system.mu.Lock()
+	defer system.mu.Unlock()
+	logging.Logger().Info().Msg("reloading proving keys")
+	provingSystem, err := loadKeys()
+	if err != nil {
+		logging.Logger().Error().Err(err).Msg("failed to reload proving keys, keeping the current ones")
+		return
+	}
+	drained := system.swap(provingSystem)
+	health.restore()
+	logging.Logger().Info().
+		Stringer("curve", provingSystem.Curve).
+		Uint32("treeDepth", provingSystem.TreeDepth).
+		Uint32("batchSize", provingSystem.BatchSize).
+		Msg("proving keys reloaded")
+	<-drained
+	logging.Logger().Info().Msg("requests using the previous proving keys drained")
+}
+
+// spawnReloadJob reloads the keys whenever the process receives SIGHUP.
+func spawnReloadJob(system *activeSystem, loadKeys func() (*prover.ProvingSystem, error), health *health) RunningJob {
+	sighup := make(chan os.Signal, 1)
+	stopped := make(chan struct{})
+	start := func() {
+		for {
+			select {
+			case <-sighup:
+				system.reload(loadKeys, health)
+			case <-stopped:
+				return
+			}
+		}
+	}
+	shutdown := func() {
+		signal.Stop(sighup)
+		close(stopped)
+	}
+	signal.Notify(sighup, syscall.SIGHUP)
+	return SpawnJob(start, shutdown)
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+	"worldcoin/gnark-mbu/prover"
+
+	"github.com/consensys/gnark-crypto/ecc"
+)
+
+func TestSwapDrainsPreviousGeneration(t *testing.T) {
+	old := &prover.ProvingSystem{Curve: ecc.BN254, BatchSize: 1}
+	system := newActiveSystem(old)
+	g := system.acquire()
+	if g.provingSystem != old {
+		t.Fatal("expected the initial proving system")
+	}
+
+	next := &prover.ProvingSystem{Curve: ecc.BN254, BatchSize: 2}
+	drained := system.swap(next)
+	if current := system.acquire(); current.provingSystem != next {
+		t.Fatal("expected new requests to use the new proving system")
+	} else {
+		current.release()
+	}
+	select {
+	case <-drained:
+		t.Fatal("expected the previous generation to be in use")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	g.release()
+	select {
+	case <-drained:
+	case <-time.After(time.Second):
+		t.Fatal("expected the previous generation to drain")
+	}
+}
+
+func TestReloadKeepsKeysOnFailure(t *testing.T) {
+	old := &prover.ProvingSystem{Curve: ecc.BN254, BatchSize: 1}
+	system := newActiveSystem(old)
+	h := &health{}
+	h.degrade("prover panicked")
+
+	system.reload(func() (*prover.ProvingSystem, error) { return nil, errors.New("no such file") }, h)
+	if system.current.Load().provingSystem != old {
+		t.Fatal("expected the current proving system to be kept")
+	}
+	if status, _ := h.status(); status != statusDegraded {
+		t.Fatalf("expected the status to be unchanged, got %s", status)
+	}
+
+	next := &prover.ProvingSystem{Curve: ecc.BN254, BatchSize: 2}
+	system.reload(func() (*prover.ProvingSystem, error) { return next, nil }, h)
+	if system.current.Load().provingSystem != next {
+		t.Fatal("expected the proving system to be replaced")
+	}
+	if status, _ := h.status(); status != statusOk {
+		t.Fatalf("expected a reload to restore the status, got %s", status)
+	}
+}
+
+// TestReloadWhileAcquiring reloads repeatedly while requests acquire and
+// release the system, for the race detector to catch a generation acquired
+// while it is found drained.
+func TestReloadWhileAcquiring(t *testing.T) {
+	system := newActiveSystem(&prover.ProvingSystem{Curve: ecc.BN254, BatchSize: 1})
+	h := &health{}
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				g := system.acquire()
+				if g.provingSystem == nil {
+					t.Error("expected a proving system")
+				}
+				g.release()
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		batchSize := uint32(i)
+		system.reload(func() (*prover.ProvingSystem, error) {
+			return &prover.ProvingSystem{Curve: ecc.BN254, BatchSize: batchSize}, nil
+		}, h)
+	}
+	close(stop)
+	wg.Wait()
+
+	g := system.acquire()
+	if g.provingSystem.BatchSize != 99 {
+		t.Fatalf("expected the last proving system, got batch size %d", g.provingSystem.BatchSize)
+	}
+	g.release()
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+	"worldcoin/gnark-mbu/logging"
+)
+
+// ProofRetries configures the retries of proofs failing with transient
+// errors, which may succeed when tried again: proofs that did not fit in the
+// memory budget while other proofs ran, proofs whose batch could not be
+// reserved in the ledger, and errors reporting themselves as temporary.
+// Proofs cancelled by shutdown are not retried.
+type ProofRetries struct {
+	// Retries is the number of times a proof is tried again.
+	Retries int
+	// Backoff is the wait before the first retry, doubled for every next one
+	// up to MaxBackoff. Every wait is jittered down by up to half its length,
+	// so that the proofs failing together are not retried together.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// transientError reports whether a proof failing with err may succeed when
+// tried again.
+func transientError(err error) bool {
+	var budgetErr *memoryBudgetExceededError
+	if errors.As(err, &budgetErr) {
+		// Proofs larger than the whole budget never fit.
+		return budgetErr.needed <= budgetErr.budget
+	}
+	if errors.Is(err, errLedgerUnavailable) {
+		return true
+	}
+	var temporary interface{ Temporary() bool }
+	return errors.As(err, &temporary) && temporary.Temporary()
+}
+
+// proofRetrier retries the proofs failing with transient errors. A nil
+// retrier tries proofs once.
+type proofRetrier struct {
+	config ProofRetries
+	// sleep waits for d, unless cancel is closed first, and reports whether
+	// it waited.
+	sleep func(d time.Duration, cancel <-chan struct{}) bool
+	// jitter returns a random duration in [0, n).
+	jitter func(n int64) int64
+}
+
+func newProofRetrier(config ProofRetries) *proofRetrier {
+	return &proofRetrier{config: config, sleep: sleep, jitter: rand.Int63n}
+}
+
+func sleep(d time.Duration, cancel <-chan struct{}) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-cancel:
+		return false
+	}
+}
+
+// do runs attempt until it succeeds, fails with a non-transient error or
+// runs out of retries, and returns its last result. Retries stop when cancel
+// is closed.
+func (retrier *proofRetrier) do(cancel <-chan struct{}, attempt func() proofResult) proofResult {
+	res := attempt()
+	if retrier == nil {
+		return res
+	}
+	backoff := retrier.config.Backoff
+	for retry := 1; res.err != nil && transientError(res.err); retry++ {
+		code := proofError(res.err).Code
+		if retry > retrier.config.Retries {
+			proofRetriesExhaustedCounter.WithLabelValues(code).Inc()
+			break
+		}
+		wait := backoff
+		if half := int64(wait / 2); half > 0 {
+			wait -= time.Duration(retrier.jitter(half))
+		}
+		logging.Logger().Warn().Err(res.err).Int("retry", retry).Dur("backoff", wait).Msg("proof failed with a transient error, retrying")
+		proofRetriesCounter.WithLabelValues(code).Inc()
+		if !retrier.sleep(wait, cancel) {
+			break
+		}
+		if backoff *= 2; retrier.config.MaxBackoff > 0 && backoff > retrier.config.MaxBackoff {
+			backoff = retrier.config.MaxBackoff
+		}
+		res = attempt()
+	}
+	return res
+}
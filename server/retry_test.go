@@ -0,0 +1,94 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// temporaryError is an error reporting itself as temporary.
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "connection reset" }
+func (temporaryError) Temporary() bool { return true }
+
+func TestTransientError(t *testing.T) {
+	for _, test := range []struct {
+		err       error
+		transient bool
+	}{
+		{&memoryBudgetExceededError{needed: 2, used: 3, budget: 4}, true},
+		{&memoryBudgetExceededError{needed: 5, budget: 4}, false},
+		{fmt.Errorf("%w: connection refused", errLedgerUnavailable), true},
+		{fmt.Errorf("reading: %w", temporaryError{}), true},
+		{errShuttingDown, false},
+		{&proverPanicError{value: "index out of range"}, false},
+		{errors.New("constraint is not satisfied"), false},
+	} {
+		if transient := transientError(test.err); transient != test.transient {
+			t.Errorf("expected %q to be transient: %t, got %t", test.err, test.transient, transient)
+		}
+	}
+}
+
+func TestProofRetrier(t *testing.T) {
+	var waits []time.Duration
+	retrier := newProofRetrier(ProofRetries{Retries: 3, Backoff: time.Second, MaxBackoff: 3 * time.Second})
+	retrier.sleep = func(d time.Duration, cancel <-chan struct{}) bool {
+		waits = append(waits, d)
+		select {
+		case <-cancel:
+			return false
+		default:
+			return true
+		}
+	}
+	retrier.jitter = func(n int64) int64 { return n / 2 }
+	busy := &memoryBudgetExceededError{needed: 1, used: 1, budget: 1}
+	attempts := func(errs ...error) func() proofResult {
+		return func() proofResult {
+			err := errs[0]
+			if len(errs) > 1 {
+				errs = errs[1:]
+			}
+			return proofResult{err: err}
+		}
+	}
+
+	// Transient errors are retried until the proof succeeds.
+	if res := retrier.do(nil, attempts(busy, busy, nil)); res.err != nil {
+		t.Fatalf("expected the retried proof to succeed, got %v", res.err)
+	}
+	if expected := []time.Duration{750 * time.Millisecond, 1500 * time.Millisecond}; !reflect.DeepEqual(waits, expected) {
+		t.Fatalf("expected jittered doubling waits %v, got %v", expected, waits)
+	}
+
+	// The waits are capped, and the last error is returned once the
+	// retries run out.
+	waits = nil
+	if res := retrier.do(nil, attempts(busy)); res.err != busy {
+		t.Fatalf("expected the last transient error, got %v", res.err)
+	}
+	if expected := []time.Duration{750 * time.Millisecond, 1500 * time.Millisecond, 2250 * time.Millisecond}; !reflect.DeepEqual(waits, expected) {
+		t.Fatalf("expected capped waits %v, got %v", expected, waits)
+	}
+
+	// Other errors are not retried, nor are proofs once cancelled.
+	waits = nil
+	unsatisfied := errors.New("constraint is not satisfied")
+	if res := retrier.do(nil, attempts(unsatisfied, nil)); res.err != unsatisfied || len(waits) != 0 {
+		t.Fatalf("expected the error not to be retried, got %v after %d retries", res.err, len(waits))
+	}
+	cancel := make(chan struct{})
+	close(cancel)
+	if res := retrier.do(cancel, attempts(busy, nil)); res.err != busy || len(waits) != 1 {
+		t.Fatalf("expected the cancelled proof not to be retried, got %v after %d waits", res.err, len(waits))
+	}
+
+	var disabled *proofRetrier
+	if res := disabled.do(nil, attempts(busy, nil)); res.err != busy {
+		t.Fatalf("expected a nil retrier to try once, got %v", res.err)
+	}
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"worldcoin/gnark-mbu/prover"
+)
+
+// selfVerificationError is the error of proofs that do not verify against the
+// verifying key of the keys they were generated with, which points to keys
+// that do not match the circuit rather than to the request.
+type selfVerificationError struct {
+	err error
+}
+
+func (e *selfVerificationError) Error() string {
+	return fmt.Sprintf("the generated proof does not verify: %s", e.err)
+}
+
+func (e *selfVerificationError) Unwrap() error {
+	return e.err
+}
+
+func selfVerificationFailedError(err *selfVerificationError) *Error {
+	return &Error{StatusCode: http.StatusInternalServerError, Code: "self_verification_failed", Message: err.Error()}
+}
+
+// verifyOwnProof verifies proof, generated by provingSystem for params,
+// before it is returned.
+func verifyOwnProof(provingSystem *prover.ProvingSystem, params *prover.Parameters, proof *prover.Proof) error {
+	var err error
+	if provingSystem.PublicPostRoot {
+		err = provingSystem.VerifyWithPostRoot(params.InputHash, params.PostRoot, proof)
+	} else {
+		err = provingSystem.Verify(params.InputHash, proof)
+	}
+	if err != nil {
+		selfVerificationFailuresCounter.Inc()
+		return &selfVerificationError{err: err}
+	}
+	return nil
+}
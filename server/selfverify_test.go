@@ -0,0 +1,21 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestSelfVerificationError(t *testing.T) {
+	err := error(&selfVerificationError{err: errors.New("pairing check failed")})
+	if e := proofError(err); e.Code != "self_verification_failed" || e.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("expected a self_verification_failed error, got %+v", e)
+	}
+	if outcome := proofOutcome(err); outcome != outcomeError {
+		t.Fatalf("expected the error outcome, got %s", outcome)
+	}
+	// A mismatched key fails every proof, retrying cannot help.
+	if transientError(err) {
+		t.Fatal("expected self-verification failures not to be retried")
+	}
+}
@@ -2,12 +2,25 @@ package server
 
 import (
 	"context"
+	"crypto"
+	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
+	"math"
+	"mime"
 	"net/http"
+	"strconv"
+	"time"
+	"worldcoin/gnark-mbu/hardware"
+	"worldcoin/gnark-mbu/jobstore"
 	"worldcoin/gnark-mbu/logging"
+	"worldcoin/gnark-mbu/metrics"
+	"worldcoin/gnark-mbu/replay"
+	"worldcoin/gnark-mbu/secrets"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"worldcoin/gnark-mbu/prover"
 )
@@ -16,6 +29,8 @@ type Error struct {
 	StatusCode int
 	Code       string
 	Message    string
+	// RetryAfter is sent in the Retry-After header if it is set.
+	RetryAfter time.Duration
 }
 
 func malformedBodyError(err error) *Error {
@@ -26,6 +41,68 @@ func provingError(err error) *Error {
 	return &Error{StatusCode: http.StatusBadRequest, Code: "proving_error", Message: err.Error()}
 }
 
+func inputHashMismatchError(err *prover.InputHashMismatchError) *Error {
+	return &Error{StatusCode: http.StatusBadRequest, Code: "input_hash_mismatch", Message: err.Error()}
+}
+
+func proverUnavailableError() *Error {
+	return &Error{StatusCode: http.StatusServiceUnavailable, Code: "prover_unavailable", Message: "the proving keys are not loaded"}
+}
+
+func verifierUnavailableError() *Error {
+	return &Error{StatusCode: http.StatusServiceUnavailable, Code: "prover_unavailable", Message: "neither the proving keys nor a verifying key are loaded"}
+}
+
+func invalidEncodingError(err error) *Error {
+	return &Error{StatusCode: http.StatusBadRequest, Code: "invalid_encoding", Message: err.Error()}
+}
+
+// proofEncoding returns the proof encoding requested with the encoding query
+// parameter, falling back to the configured one.
+func proofEncoding(r *http.Request, fallback prover.ProofEncoding) (prover.ProofEncoding, *Error) {
+	name := r.URL.Query().Get("encoding")
+	if name == "" {
+		return fallback, nil
+	}
+	encoding, err := prover.ParseProofEncoding(name)
+	if err != nil {
+		return "", invalidEncodingError(err)
+	}
+	return encoding, nil
+}
+
+func unsupportedCircuitError(circuit string) *Error {
+	return &Error{StatusCode: http.StatusNotImplemented, Code: "unsupported_circuit", Message: fmt.Sprintf("%s proofs are not supported by this prover", circuit)}
+}
+
+// unsupportedCircuitHandler serves the route of a circuit the prover has no
+// keys for.
+type unsupportedCircuitHandler struct {
+	circuit string
+}
+
+func (handler unsupportedCircuitHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	unsupportedCircuitError(handler.circuit).send(w)
+}
+
+func timeoutError(timeout time.Duration) *Error {
+	return &Error{StatusCode: http.StatusGatewayTimeout, Code: "timeout", Message: fmt.Sprintf("proof not generated within %s", timeout)}
+}
+
+// proverError maps the typed errors returned by the prover to error codes,
+// see prover.ErrorCode.
+func proverError(err error) *Error {
+	var inputHash *prover.InputHashMismatchError
+	if errors.As(err, &inputHash) {
+		return inputHashMismatchError(inputHash)
+	}
+	return &Error{StatusCode: http.StatusBadRequest, Code: prover.ErrorCode(err), Message: err.Error()}
+}
+
 func unexpectedError(err error) *Error {
 	return &Error{StatusCode: http.StatusInternalServerError, Code: "unexpected_error", Message: err.Error()}
 }
@@ -38,6 +115,10 @@ func (error *Error) MarshalJSON() ([]byte, error) {
 }
 
 func (error *Error) send(w http.ResponseWriter) {
+	recordError(w, error)
+	if error.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(error.RetryAfter.Seconds()))))
+	}
 	w.WriteHeader(error.StatusCode)
 	jsonBytes, err := error.MarshalJSON()
 	if err != nil {
@@ -52,16 +133,185 @@ func (error *Error) send(w http.ResponseWriter) {
 type Config struct {
 	ProverAddress  string
 	MetricsAddress string
+	// Hardware is the proving configuration selected for the host, reported
+	// by the info endpoint.
+	Hardware *hardware.Selection
+	// LegacyJSON decodes request bodies in the legacy sequencer dialect
+	// unless they are sent with the canonical JSON content type.
+	LegacyJSON bool
+	// MaxConcurrentProofs bounds the number of proofs generated at once,
+	// queueing further requests. Zero means unbounded.
+	MaxConcurrentProofs int
+	// AutoscaleTargetLatency is the deadline assumed by the autoscaling
+	// signal for requests that do not carry one.
+	AutoscaleTargetLatency time.Duration
+	// PriorityAging is the time after which a queued proof is promoted one
+	// priority class, so that proofs of lower classes are not starved.
+	// Defaults to 5 minutes.
+	PriorityAging time.Duration
+	// ClientKeys are the keys signed requests are verified against.
+	ClientKeys ClientKeys
+	// RequireSignatures rejects requests that are not signed by one of the
+	// ClientKeys.
+	RequireSignatures bool
+	// ProveTimeout bounds the time a request waits for its proof, including
+	// time spent queued. Zero means no timeout.
+	ProveTimeout time.Duration
+	// ReadTimeout, WriteTimeout and IdleTimeout are those of the prover
+	// address: the time to read a request, body included, the time to serve
+	// it once its headers are read, and the time a keep-alive connection
+	// waits for its next request. Zero means no timeout, idle connections
+	// then waiting for ReadTimeout. WriteTimeout cuts off the proofs that
+	// outlast it, so it should exceed ProveTimeout.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+	// SlowRequestThreshold logs a warning, with the batch size and the time
+	// spent in each phase, for the proof requests taking longer to serve.
+	// Zero logs none.
+	SlowRequestThreshold time.Duration
+	// BatchWorkers is the number of parameter sets of a batch request proven
+	// at once, on top of the limit set by MaxConcurrentProofs. Zero or one
+	// proves them sequentially.
+	BatchWorkers int
+	// Aggregation serves /aggregate if set.
+	Aggregation *prover.AggregationSystem
+	// ProofEncoding is the encoding of proofs in responses unless requests
+	// select another one with the encoding query parameter.
+	ProofEncoding prover.ProofEncoding
+	// NumberFormat is the format of field elements in responses, 0x-prefixed
+	// hex padded to 32 bytes unless it is decimal.
+	NumberFormat prover.NumberFormat
+	// RequestLimits bound the size of request bodies, checked before they
+	// are decoded.
+	RequestLimits RequestLimits
+	// RateLimits limit how often proofs may be requested per address and
+	// client. Nil does not limit requests.
+	RateLimits *RateLimits
+	// DrainGracePeriod is the time in-flight proofs are given to complete
+	// when the server stops, after which they are cancelled. Zero cancels
+	// them right away.
+	DrainGracePeriod time.Duration
+	// KeysError is the error the proving keys failed to load with. The server
+	// then runs degraded, without a proving system.
+	KeysError error
+	// VerifyingSystem is a verifying key loaded apart from the proving keys,
+	// which /verify, /verify_chain and /artifacts fall back to while the
+	// proving keys are not loaded. Nil leaves them unavailable then.
+	VerifyingSystem *prover.VerifyingSystem
+	// Jobs stores the proof jobs of the async mode, served under /jobs if it
+	// is set.
+	Jobs jobstore.Store
+	// JobRetention is the time finished jobs are kept. Zero keeps them.
+	JobRetention time.Duration
+	// Callbacks enables the callback_url of /prove and /jobs if set.
+	Callbacks *Callbacks
+	// ProofCache answers retried proof requests with the proof generated
+	// for them before. Nil proves every request.
+	ProofCache ProofCache
+	// Ledger is consulted before proving a batch, rejecting batches that
+	// were already proven or that contradict a proven one. Nil proves any
+	// batch.
+	Ledger Ledger
+	// ProofRetries retries the proofs failing with transient errors. Nil
+	// tries proofs once.
+	ProofRetries *ProofRetries
+	// SelfVerify verifies every proof against the verifying key before
+	// returning it, failing the request with self_verification_failed if it
+	// does not pass, so that keys not matching the circuit are caught before
+	// their proofs go on-chain.
+	SelfVerify bool
+	// ResultSigner, an Ed25519 or ECDSA key, signs the ResultDigest of
+	// every proof returned, so that consumers can authenticate the prover
+	// that generated it with the public key /info reports. Nil signs
+	// nothing.
+	ResultSigner crypto.Signer
+	// Pprof serves the net/http/pprof profiles under /debug/pprof/ on the
+	// metrics address.
+	Pprof bool
+	// MetricsExporter pushes the metrics every MetricsPushInterval to a
+	// backend that cannot scrape the metrics address, which still serves
+	// them. Nil pushes them nowhere.
+	MetricsExporter     metrics.Exporter
+	MetricsPushInterval time.Duration
+	// LoadKeys loads the proving system when the process receives SIGHUP,
+	// replacing the current one without downtime. Nil disables reloading.
+	LoadKeys func() (*prover.ProvingSystem, error)
+	// KeyFiles are the key files of a keys directory, found by ScanKeysDir.
+	// Requests are proven with the file matching the tree depth, batch size
+	// and mode of their parameters, or with the default keys if none does.
+	KeyFiles []KeyFile
+	// LoadKeyFile loads the keys of one of the KeyFiles.
+	LoadKeyFile func(path string) (*prover.ProvingSystem, error)
+	// LazyKeys loads the KeyFiles on first use instead of at startup.
+	LazyKeys bool
+	// MemoryBudget bounds the memory of the proofs generated at once. Nil
+	// does not bound it.
+	MemoryBudget *MemoryBudget
+	// Tenants, if any, are the only users of the proof endpoints, which
+	// then require the API key of one of them.
+	Tenants []Tenant
+	// TenantsFile is reloaded into Tenants whenever it changes, if set.
+	TenantsFile string
+	// Secrets reads the secrets of the server held by a secrets manager,
+	// such as the APIKeySecrets of the tenants when TenantsFile is
+	// reloaded. Nil reads none.
+	Secrets secrets.Provider
+	// StartIndexAlignment rejects batches whose start index is not a
+	// multiple of it, for sequencers filling their trees a whole batch at a
+	// time. Zero or one accepts any start index.
+	StartIndexAlignment uint32
+	// Recorder appends the proof requests served, stripped of their
+	// credentials, to a replay file for load tests. Nil records none.
+	Recorder *replay.Recorder
+	// Dev is set when the proving system was set up at startup with
+	// prover.SetupDev, which /info reports.
+	Dev bool
+	// CORS lets browsers call the API from the allowed origins. Nil serves
+	// no CORS headers.
+	CORS *CORS
+	// Mode selects the endpoints served. The zero value serves them all, as
+	// ModeBoth does. In ModeVerifier the proving system may hold a verifying
+	// key only.
+	Mode Mode
+	// AdminAddress serves the read-only admin API, reporting the
+	// configuration, keys, requests in flight, recent errors and build of the
+	// server, if set. Its requests must carry AdminToken.
+	AdminAddress string
+	AdminToken   string
+	// TLSCertificate serves the prover and admin APIs over HTTPS with the
+	// certificate, read by LoadTLSCertificate, if not nil. The metrics
+	// server stays plain HTTP.
+	TLSCertificate *tls.Certificate
 }
 
-func spawnServerJob(server *http.Server, label string) RunningJob {
+// CircuitVersionHeader carries prover.CircuitSemver in the responses of the
+// endpoints generating proofs and witnesses.
+const CircuitVersionHeader = "X-Circuit-Version"
+
+// LegacyContentType selects the legacy sequencer JSON dialect for a request
+// regardless of the LegacyJSON setting.
+const LegacyContentType = "application/vnd.sequencer-legacy+json"
+
+// spawnServerJob serves server until the job is stopped, over HTTPS if it
+// has a TLS configuration. If drain is not nil it is called before the server
+// shuts down, while it still serves requests.
+func spawnServerJob(server *http.Server, label string, drain func()) RunningJob {
 	start := func() {
-		err := server.ListenAndServe()
+		var err error
+		if server.TLSConfig != nil {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
 		if err != nil && err != http.ErrServerClosed {
 			panic(fmt.Sprintf("%s failed: %s", label, err))
 		}
 	}
 	shutdown := func() {
+		if drain != nil {
+			drain()
+		}
 		logging.Logger().Info().Msgf("shutting down %s", label)
 		err := server.Shutdown(context.Background())
 		if err != nil {
@@ -73,23 +323,465 @@ func spawnServerJob(server *http.Server, label string) RunningJob {
 }
 
 func Run(config *Config, provingSystem *prover.ProvingSystem) RunningJob {
+	// The metrics of this server, such as those of its proof queue, are
+	// registered with its own registry, served along the global metrics.
+	registry := prometheus.NewRegistry()
+	gatherer := prometheus.Gatherers{prometheus.DefaultGatherer, registry}
 	metricsMux := http.NewServeMux()
-	metricsMux.Handle("/metrics", promhttp.Handler())
+	metricsMux.Handle("/metrics", promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{})))
+	metricsMux.Handle("/log_level", logLevelHandler{})
+	if config.Pprof {
+		registerPprof(metricsMux)
+	}
 	metricsServer := &http.Server{Addr: config.MetricsAddress, Handler: metricsMux}
-	metricsJob := spawnServerJob(metricsServer, "metrics server")
+	metricsJob := spawnServerJob(metricsServer, "metrics server", nil)
 	logging.Logger().Info().Str("addr", config.MetricsAddress).Msg("metrics server started")
 
+	health := &health{}
+	health.restore()
+	if config.KeysError != nil {
+		health.degrade(fmt.Sprintf("failed to load keys: %s", config.KeysError))
+		logging.Logger().Error().Err(config.KeysError).Msg("running degraded without a proving system")
+	}
+
+	system := newActiveSystem(provingSystem)
+	if config.VerifyingSystem != nil {
+		system.verifier = config.VerifyingSystem.ProvingSystem()
+	}
+	background := []RunningJob{metricsJob}
+	if config.MetricsExporter != nil {
+		background = append(background, spawnMetricsPushJob(gatherer, config.MetricsExporter, config.MetricsPushInterval))
+	}
+	if config.LoadKeys != nil {
+		background = append(background, spawnReloadJob(system, config.LoadKeys, health))
+	}
+
 	proverMux := http.NewServeMux()
-	proverMux.Handle("/prove", proveHandler{provingSystem: provingSystem})
-	proverServer := &http.Server{Addr: config.ProverAddress, Handler: proverMux}
-	proverJob := spawnServerJob(proverServer, "prover server")
-	logging.Logger().Info().Str("addr", config.ProverAddress).Msg("app server started")
+	queue := newProofQueue(config.MaxConcurrentProofs, config.AutoscaleTargetLatency, config.PriorityAging)
+	registry.MustRegister(queueCollector{queue: queue})
+	drain := newDrain()
+	prove := proveHandler{
+		drain:               drain,
+		system:              system,
+		health:              health,
+		legacyJSON:          config.LegacyJSON,
+		queue:               queue,
+		clientKeys:          config.ClientKeys,
+		requireSignatures:   config.RequireSignatures,
+		timeout:             config.ProveTimeout,
+		encoding:            config.ProofEncoding,
+		numbers:             config.NumberFormat,
+		limits:              config.RequestLimits,
+		startIndexAlignment: config.StartIndexAlignment,
+		selfVerify:          config.SelfVerify,
+		signer:              config.ResultSigner,
+		flights:             newFlightGroup(),
+	}
+	if config.RateLimits != nil {
+		prove.limiter = newRateLimiter(*config.RateLimits)
+	}
+	if config.ProofCache != nil {
+		prove.cache = &proofCache{store: config.ProofCache}
+	}
+	if config.Ledger != nil {
+		prove.ledger = &batchLedger{store: config.Ledger}
+	}
+	if config.ProofRetries != nil {
+		prove.retrier = newProofRetrier(*config.ProofRetries)
+	}
+	if config.Callbacks != nil {
+		prove.callbacks = newCallbackSender(*config.Callbacks, drain)
+	}
+	if config.MemoryBudget != nil {
+		prove.memory = newMemoryBudget(*config.MemoryBudget)
+	}
+	if len(config.KeyFiles) != 0 {
+		prove.keys = newKeySet(config.KeyFiles, config.LoadKeyFile, config.LazyKeys, system)
+	}
+	var tenants *tenantSet
+	if len(config.Tenants) != 0 || config.TenantsFile != "" {
+		tenants = newTenantSet(config.Tenants)
+		if config.TenantsFile != "" {
+			background = append(background, spawnTenantsReloadJob(tenants, config.TenantsFile, config.Secrets))
+		}
+	}
+	mode := config.Mode
+	if mode == "" {
+		mode = ModeBoth
+	}
+	if mode.proves() {
+		// Proof requests are recorded once authenticated, to be replayed by
+		// load tests.
+		recorded := func(handler http.Handler) http.Handler {
+			return record(handler, config.Recorder, config.RequestLimits.MaxBodyBytes)
+		}
+		proverMux.Handle("/prove", tenants.track(drain.track(recorded(prove))))
+		// Routes are named by the circuit they prove. There is no deletion
+		// circuit yet, so only insertions are served.
+		proverMux.Handle("/prove/insertion", tenants.track(drain.track(recorded(prove))))
+		proverMux.Handle("/prove/deletion", unsupportedCircuitHandler{circuit: "deletion"})
+		// WebSocket requests are tracked by the drain one proof at a time.
+		proverMux.Handle("/prove/ws", tenants.track(proveWebSocketHandler{proveHandler: prove, cors: config.CORS}))
+		proverMux.Handle("/prove_batch", tenants.track(drain.track(recorded(proveBatchHandler{proveHandler: prove, workers: config.BatchWorkers}))))
+		proverMux.Handle("/prove_split", tenants.track(drain.track(recorded(proveSplitHandler{proveHandler: prove}))))
+		proverMux.Handle("/witness", tenants.track(drain.track(recorded(witnessHandler{proveHandler: prove}))))
+		proverMux.Handle("/check", tenants.track(drain.track(recorded(checkHandler{proveHandler: prove}))))
+		if config.Jobs != nil {
+			runner := &jobRunner{prove: prove, store: config.Jobs}
+			jobs := jobsHandler{proveHandler: prove, runner: runner}
+			// Jobs can be looked up while draining.
+			proverMux.Handle("/jobs", tenants.track(drain.track(recorded(jobs))))
+			proverMux.Handle("/jobs/", tenants.track(jobs))
+			runner.resume()
+			if config.JobRetention > 0 {
+				background = append(background, spawnJobRetentionJob(config.Jobs, config.JobRetention))
+			}
+		}
+		if config.Aggregation != nil {
+			proverMux.Handle("/aggregate", tenants.track(drain.track(aggregateHandler{aggregation: config.Aggregation, queue: queue, drain: drain, encoding: config.ProofEncoding, numbers: config.NumberFormat, limits: config.RequestLimits})))
+		}
+		proverMux.Handle("/autoscale", autoscaleHandler{queue: queue})
+		proverMux.Handle("/keys", keysHandler{keys: prove.keys})
+	}
+	signingKey, err := newSigningKeyInfo(config.ResultSigner)
+	if err != nil {
+		logging.Logger().Error().Err(err).Msg("the result signing key cannot be reported by /info")
+	}
+	proverMux.Handle("/info", infoHandler{system: system, hardware: config.Hardware, health: health, keys: prove.keys, dev: config.Dev, mode: mode, signingKey: signingKey})
+	if mode.verifies() {
+		proverMux.Handle("/verify", verifyHandler{system: system, limits: config.RequestLimits})
+		proverMux.Handle("/verify_chain", verifyChainHandler{system: system, limits: config.RequestLimits})
+	}
+	proverMux.Handle("/artifacts/", artifactsHandler{system: system})
+	proverMux.Handle("/health", healthHandler{health: health})
+	proverMux.Handle("/ready", readyHandler{drain: drain, system: system})
+	var requests *requestTracker
+	if config.AdminAddress != "" {
+		requests = newRequestTracker()
+		admin := &admin{
+			config:   newAdminConfig(config, mode),
+			keysErr:  config.KeysError,
+			system:   system,
+			keys:     prove.keys,
+			queue:    queue,
+			drain:    drain,
+			jobs:     config.Jobs,
+			requests: requests,
+			started:  time.Now(),
+		}
+		adminServer := &http.Server{Addr: config.AdminAddress, Handler: admin.mux(config.AdminToken), TLSConfig: tlsConfig(config.TLSCertificate)}
+		background = append(background, spawnServerJob(adminServer, "admin server", nil))
+		logging.Logger().Info().Str("addr", config.AdminAddress).Msg("admin server started")
+	}
+	proverServer := &http.Server{
+		Addr:         config.ProverAddress,
+		Handler:      accessLog(slowRequests(securityHeaders(config.CORS.handle(compressResponses(proverMux))), config.SlowRequestThreshold), requests),
+		ReadTimeout:  config.ReadTimeout,
+		WriteTimeout: config.WriteTimeout,
+		IdleTimeout:  config.IdleTimeout,
+		TLSConfig:    tlsConfig(config.TLSCertificate),
+	}
+	proverJob := spawnServerJob(proverServer, "prover server", func() { drain.run(config.DrainGracePeriod) })
+	logging.Logger().Info().Str("addr", config.ProverAddress).Bool("tls", config.TLSCertificate != nil).Msg("app server started")
 
-	return CombineJobs(metricsJob, proverJob)
+	return CombineJobs(append(background, proverJob)...)
 }
 
 type proveHandler struct {
-	provingSystem *prover.ProvingSystem
+	system            *activeSystem
+	drain             *drain
+	health            *health
+	legacyJSON        bool
+	queue             *proofQueue
+	clientKeys        ClientKeys
+	requireSignatures bool
+	timeout           time.Duration
+	encoding          prover.ProofEncoding
+	numbers           prover.NumberFormat
+	// limiter is shared by /prove, /prove_batch and /witness.
+	limiter *rateLimiter
+	limits  RequestLimits
+	// startIndexAlignment is Config.StartIndexAlignment.
+	startIndexAlignment uint32
+	// cache and flights are shared by /prove and /prove_batch.
+	cache     *proofCache
+	flights   *flightGroup
+	callbacks *callbackSender
+	// keys routes requests to the key files of the keys directory, if any.
+	keys *keySet
+	// memory reserves the memory of proofs, nil if unbounded.
+	memory *memoryBudget
+	// ledger reserves the batches of proofs, nil if there is no Ledger.
+	ledger *batchLedger
+	// selfVerify verifies every proof before returning it.
+	selfVerify bool
+	// signer signs the proofs returned, nil if they are not signed.
+	signer crypto.Signer
+	// retrier retries the proofs failing with transient errors, nil if they
+	// are tried once.
+	retrier *proofRetrier
+}
+
+// systemFor returns the system proving params: that of the key file of the
+// keys directory matching their shape, else the default one.
+func (handler proveHandler) systemFor(params *prover.Parameters) *activeSystem {
+	if system := handler.keys.systemFor(params); system != nil {
+		return system
+	}
+	return handler.system
+}
+
+// proverPanicError is returned by prove when the prover panics.
+type proverPanicError struct {
+	value interface{}
+}
+
+func (e *proverPanicError) Error() string {
+	return fmt.Sprintf("prover panicked: %v", e.value)
+}
+
+// prove generates a proof, recovering from panics in the prover so that a
+// faulty backend degrades the server instead of crashing it. The server is
+// healthy again once a proof succeeds. The stages of the proof are counted in
+// proofsInStageGauge and reported to progress, if not nil. Proofs cancelled
+// through ctx fail with errShuttingDown.
+func (handler proveHandler) prove(ctx context.Context, provingSystem *prover.ProvingSystem, params *prover.Parameters, progress prover.Progress) (proof *prover.Proof, err error) {
+	var current prover.ProofStage
+	leave := func() {
+		if current != "" {
+			proofsInStageGauge.WithLabelValues(string(current)).Dec()
+			current = ""
+		}
+	}
+	defer leave()
+	defer func() {
+		if value := recover(); value != nil {
+			logging.Logger().Error().Interface("panic", value).Msg("prover panicked")
+			err = &proverPanicError{value: value}
+			handler.health.degrade(err.Error())
+		}
+	}()
+	proof, err = provingSystem.ProveWithProgress(ctx, params, func(stage prover.ProofStage) {
+		leave()
+		if stage != prover.StageDone {
+			current = stage
+			proofsInStageGauge.WithLabelValues(string(stage)).Inc()
+		}
+		if progress != nil {
+			progress(stage)
+		}
+	})
+	if err == nil && handler.selfVerify {
+		if err = verifyOwnProof(provingSystem, params, proof); err != nil {
+			logging.Logger().Error().Err(err).Msg("generated proof failed self-verification")
+			handler.health.degrade(err.Error())
+			proof = nil
+		}
+	}
+	if err == nil {
+		handler.health.restore()
+	}
+	if errors.Is(err, context.Canceled) {
+		err = handler.cancellation()
+	}
+	return proof, err
+}
+
+// cancellation returns the error of a proof whose context was cancelled:
+// errShuttingDown if the drain cancelled it, and errProofAbandoned if every
+// request waiting for it gave up.
+func (handler proveHandler) cancellation() error {
+	select {
+	case <-handler.drain.cancelled():
+		return errShuttingDown
+	default:
+		return errProofAbandoned
+	}
+}
+
+type proofResult struct {
+	proof   *prover.Proof
+	elapsed time.Duration
+	// cached is set for proofs taken from the proof cache, whose elapsed is
+	// the time they took to generate originally.
+	cached bool
+	// coalesced is set for proofs generated for a concurrent request.
+	coalesced bool
+	err       error
+}
+
+// verifyParameters checks params natively for provingSystem and the start
+// index alignment of the server.
+func (handler proveHandler) verifyParameters(provingSystem *prover.ProvingSystem, params *prover.Parameters) error {
+	if err := params.ValidateAlignment(handler.startIndexAlignment); err != nil {
+		return err
+	}
+	return provingSystem.VerifyParameters(params)
+}
+
+// proveQueued waits for a slot in the queue and proves params with the
+// proving system of g, unless the server cancelled its proofs in the
+// meantime. Concurrent requests for the same proof, or with the same
+// idempotency key, share one proof, whose progress is reported to the
+// progress of the request that started it. The request stops waiting when
+// ctx is done, and the proof is cancelled once no request waits for it.
+func (handler proveHandler) proveQueued(ctx context.Context, sched schedule, g *generation, params *prover.Parameters, idempotencyKey string, progress prover.Progress) proofResult {
+	provingSystem := g.provingSystem
+	// Bad batches are rejected before they take a queue slot.
+	if err := handler.verifyParameters(provingSystem, params); err != nil {
+		return proofResult{err: err}
+	}
+	key, err := proofKey(provingSystem, params)
+	if err != nil {
+		return proofResult{err: err}
+	}
+	if res, ok := handler.cache.get(key); ok {
+		return res
+	}
+	flightKey := idempotencyKey
+	if flightKey == "" {
+		flightKey = key
+	}
+	// The flight holds g until the proof returns, which may be after the
+	// requests waiting for it gave up.
+	g.retain()
+	return handler.flights.do(ctx, flightKey, key, func(ctx context.Context) proofResult {
+		defer g.release()
+		ctx, cancel := handler.drain.context(ctx)
+		defer cancel()
+		res := handler.retrier.do(ctx.Done(), func() proofResult {
+			return handler.proveReserved(ctx, sched, provingSystem, params, progress)
+		})
+		handler.cache.add(key, res)
+		return res
+	})
+}
+
+// proveReserved reserves the batch of params in the ledger and proves it in
+// a queue slot, releasing the batch if the proof fails or ctx is cancelled.
+func (handler proveHandler) proveReserved(ctx context.Context, sched schedule, provingSystem *prover.ProvingSystem, params *prover.Parameters, progress prover.Progress) proofResult {
+	// Batches are reserved once per flight, so that the requests coalesced
+	// into it are not duplicates of each other.
+	releaseBatch, err := handler.ledger.reserve(provingSystem, params)
+	if err != nil {
+		return proofResult{err: err}
+	}
+	var res proofResult
+	err = handler.queue.run(ctx, sched, func() {
+		if ctx.Err() != nil {
+			res.err = handler.cancellation()
+			return
+		}
+		release, err := handler.memory.reserve(provingSystem, ctx.Done())
+		if err != nil {
+			if ctx.Err() != nil {
+				err = handler.cancellation()
+			}
+			res.err = err
+			return
+		}
+		defer release()
+		start := time.Now()
+		res.proof, res.err = handler.prove(ctx, provingSystem, params, progress)
+		res.elapsed = time.Since(start)
+		observeProof(shapeOf(provingSystem), proofOutcome(res.err), res.elapsed)
+	})
+	if err != nil {
+		res.err = handler.cancellation()
+	}
+
+	releaseBatch(res.err)
+	return res
+}
+
+// proveCancellable proves params like proveQueued, but returns as soon as
+// the server cancels its proofs. The proof then completes in the background,
+// holding its own reference to g.
+func (handler proveHandler) proveCancellable(sched schedule, g *generation, params *prover.Parameters, idempotencyKey string, progress prover.Progress) proofResult {
+	g.retain()
+	done := make(chan proofResult, 1)
+	go func() {
+		defer g.release()
+		done <- handler.proveQueued(context.Background(), sched, g, params, idempotencyKey, progress)
+	}()
+	var res proofResult
+	select {
+	case res = <-done:
+	case <-handler.drain.cancelled():
+		res = proofResult{err: errShuttingDown}
+	}
+	countProof(shapeOf(g.provingSystem), proofOutcome(res.err))
+	return res
+}
+
+// proofError maps a failed proof to the error sent to the client.
+func proofError(err error) *Error {
+	var panicErr *proverPanicError
+	if errors.As(err, &panicErr) {
+		return unexpectedError(err)
+	}
+	var budgetErr *memoryBudgetExceededError
+	if errors.As(err, &budgetErr) {
+		return memoryBudgetError(budgetErr)
+	}
+	if errors.Is(err, errShuttingDown) {
+		return shuttingDownError("the proof was cancelled because the server is shutting down")
+	}
+	if errors.Is(err, errIdempotencyKeyReused) {
+		return idempotencyKeyReusedError()
+	}
+	var selfVerificationErr *selfVerificationError
+	if errors.As(err, &selfVerificationErr) {
+		return selfVerificationFailedError(selfVerificationErr)
+	}
+	var conflictErr *LedgerConflictError
+	if errors.As(err, &conflictErr) {
+		return ledgerConflictError(conflictErr)
+	}
+	if errors.Is(err, errLedgerUnavailable) {
+		return ledgerUnavailableError(err)
+	}
+	return proverError(err)
+}
+
+// decodeParameters decodes the request body into parameters, in the dialect
+// selected by the content type and configuration, and checks them against the
+// limits.
+func (limits RequestLimits) decodeParameters(r *http.Request, body []byte, legacyJSON bool) (*prover.Parameters, *Error) {
+	var params *prover.Parameters
+	var err error
+	if format := requestFormat(r); format != "" {
+		params = new(prover.Parameters)
+		err = format.Unmarshal(body, params)
+	} else {
+		params, err = unmarshalParameters(body, useLegacyJSON(r, legacyJSON))
+	}
+	if err != nil {
+		return nil, malformedBodyError(err)
+	}
+	if message := limits.checkParameters(params); message != "" {
+		return nil, requestTooLargeError(message)
+	}
+	return params, nil
+}
+
+func useLegacyJSON(r *http.Request, legacyJSON bool) bool {
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return contentType == LegacyContentType || (legacyJSON && contentType != "application/json")
+}
+
+func unmarshalParameters(body []byte, legacy bool) (*prover.Parameters, error) {
+	var params prover.Parameters
+	var err error
+	if legacy {
+		err = params.UnmarshalLegacyJSON(body)
+	} else {
+		err = json.Unmarshal(body, &params)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &params, nil
 }
 
 func (handler proveHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -97,28 +789,203 @@ func (handler proveHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
+	received := time.Now()
 	logging.Logger().Info().Msg("received prove request")
-	buf, err := io.ReadAll(r.Body)
-	if err != nil {
-		malformedBodyError(err).send(w)
+	w.Header().Set(CircuitVersionHeader, prover.CircuitSemver)
+	if limitErr := handler.limiter.allowIP(r); limitErr != nil {
+		limitErr.send(w)
 		return
 	}
-	var params prover.Parameters
-	err = json.Unmarshal(buf, &params)
-	if err != nil {
-		malformedBodyError(err).send(w)
+	// Reloads never unload the keys, so a loaded system stays available.
+	if handler.system.current.Load().provingSystem == nil {
+		proverUnavailableError().send(w)
+		return
+	}
+	buf, readErr := handler.limits.readBody(w, r)
+	if readErr != nil {
+		readErr.send(w)
+		return
+	}
+	params, decodeErr := handler.limits.decodeParameters(r, buf, handler.legacyJSON)
+	if decodeErr != nil {
+		decodeErr.send(w)
+		return
+	}
+	logBatchSize(r, len(params.IdComms))
+	logPhase(r, phaseDecode, time.Since(received))
+	digest := params.Digest()
+	clientId, authErr := authenticate(r, digest, handler.clientKeys, handler.requireSignatures)
+	if authErr != nil {
+		authErr.send(w)
+		return
+	}
+	flightKey, keyErr := idempotencyKey(r, clientId)
+	if keyErr != nil {
+		keyErr.send(w)
+		return
+	}
+	if limitErr := handler.limiter.allowClient(clientId); limitErr != nil {
+		limitErr.send(w)
+		return
+	}
+	if circuitErr := allowCircuit(r, paramsShape(params)); circuitErr != nil {
+		circuitErr.send(w)
+		return
+	}
+	audit := logging.Audit().With().Str("requestId", requestID(r)).Str("clientId", clientId).Str("digest", hex.EncodeToString(digest[:])).Str("remoteAddr", r.RemoteAddr).Logger()
+	audit.Info().Bool("authenticated", clientId != "").Msg("proof requested")
+	includeMetadata, _ := strconv.ParseBool(r.URL.Query().Get("include_metadata"))
+	encoding, encodingErr := proofEncoding(r, handler.encoding)
+	if encodingErr != nil {
+		encodingErr.send(w)
+		return
+	}
+	wireFormat, formatErr := proofFormat(r)
+	if formatErr != nil {
+		formatErr.send(w)
+		return
+	}
+	interval, keepaliveErr := keepaliveInterval(r)
+	if keepaliveErr != nil {
+		keepaliveErr.send(w)
+		return
+	}
+	// Keepalives precede a JSON payload; event streams always carry JSON.
+	if interval > 0 && (wireFormat != "" || (!wantsEventStream(r) && responseFormat(r) != "")) {
+		invalidKeepaliveError("keepalives are only sent with JSON responses").send(w)
+		return
+	}
+	sched, scheduleErr := requestSchedule(r)
+	if scheduleErr != nil {
+		scheduleErr.send(w)
 		return
 	}
-	proof, err := handler.provingSystem.Prove(&params)
+	callbackURL, callbackErr := handler.callbacks.callbackURL(r)
+	if callbackErr != nil {
+		callbackErr.send(w)
+		return
+	}
+	requestId := requestID(r)
+	// The generation is held until the proof completes, which may be after
+	// the request timed out, so that reloads drain it.
+	g := handler.systemFor(params).acquire()
+	if err := wireFormat.CheckCurve(g.provingSystem.Curve); err != nil {
+		g.release()
+		invalidEncodingError(err).send(w)
+		return
+	}
+	done := make(chan proofResult, 1)
+	progress := phaseProgress(r, time.Now())
+	// The request stops waiting for the proof at its timeout, unless the
+	// result is posted to a callback URL.
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	if handler.timeout > 0 && callbackURL == "" {
+		ctx, cancel = context.WithTimeout(ctx, handler.timeout)
+	}
+	go func() {
+		defer g.release()
+		defer cancel()
+		res := handler.proveQueued(ctx, sched, g, params, flightKey, progress)
+		done <- res
+		// The callback is posted even if the request timed out meanwhile.
+		if callbackURL != "" {
+			handler.callbacks.send(callbackURL, newProofCallback(requestId, res, encoding, handler.numbers))
+		}
+	}()
+	var timeout <-chan time.Time
+	if handler.timeout > 0 {
+		timer := time.NewTimer(handler.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	var stream *keepaliveStream
+	if interval > 0 {
+		if handler.signer != nil {
+			w.Header().Add("Trailer", ProofSignatureHeader)
+		}
+		stream = startKeepalive(w, r, interval)
+	}
+	// fail sends an error, as the final payload of a streamed response once
+	// its status line is sent.
+	fail := func(error *Error) {
+		if stream != nil {
+			stream.fail(error)
+		} else {
+			error.send(w)
+		}
+	}
+	shape := shapeOf(g.provingSystem)
+	var res proofResult
+wait:
+	for {
+		select {
+		case res = <-done:
+			countProof(shape, proofOutcome(res.err))
+			if res.err == nil && !res.cached {
+				logProof(r, res.elapsed)
+			}
+			break wait
+		case <-stream.ticks():
+			stream.keepalive()
+		case <-timeout:
+			// The proof is cancelled with the request, unless another
+			// request coalesced into it still waits: it leaves the queue,
+			// or stops at the next cancellation check of the prover.
+			countProof(shape, outcomeTimeout)
+			audit.Info().Dur("timeout", handler.timeout).Msg("proof timed out")
+			fail(timeoutError(handler.timeout))
+			return
+		case <-handler.drain.cancelled():
+			countProof(shape, outcomeCancelled)
+			audit.Info().Msg("proof cancelled by shutdown")
+			fail(proofError(errShuttingDown))
+			return
+		}
+	}
+	if res.err != nil {
+		audit.Info().Err(res.err).Msg("proof failed")
+		fail(proofError(res.err))
+		return
+	}
+	proof := res.proof
+	audit.Info().Str("inputHash", params.InputHash.Text(16)).Bool("cached", res.cached).Bool("coalesced", res.coalesced).Msg("proof generated")
+	signature, err := signResult(handler.signer, proof, &params.InputHash)
 	if err != nil {
-		provingError(err).send(w)
+		logging.Logger().Error().Err(err).Msg("error signing proof")
+		fail(signingFailedError(err))
 		return
 	}
-	responseBytes, err := json.Marshal(&proof)
+	if signature != "" {
+		w.Header().Set(ProofSignatureHeader, signature)
+	}
+	var metadata *proofMetadata
+	if includeMetadata {
+		metadata = newProofMetadata(g.provingSystem, params, res.elapsed, handler.numbers)
+	}
+	var responseBytes []byte
+	var contentType string
+	if stream != nil {
+		responseBytes, _, err = encodeProof("", proof, encoding, handler.numbers, metadata)
+		if err != nil {
+			fail(unexpectedError(err))
+			return
+		}
+		stream.finish(http.StatusOK, responseBytes)
+		return
+	}
+	if wireFormat != "" {
+		// Wire formats hold the proof alone, without its metadata.
+		responseBytes, err = proof.MarshalFormat(wireFormat, handler.numbers)
+		contentType = wireFormat.ContentType()
+	} else {
+		responseBytes, contentType, err = encodeProof(responseFormat(r), proof, encoding, handler.numbers, metadata)
+	}
 	if err != nil {
 		unexpectedError(err).send(w)
 		return
 	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Add("Vary", "Accept")
 	w.WriteHeader(http.StatusOK)
 	_, err = w.Write(responseBytes)
 }
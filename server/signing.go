@@ -0,0 +1,110 @@
+package server
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"worldcoin/gnark-mbu/prover"
+)
+
+// ProofSignatureHeader carries the base64 encoded signature of the operator
+// key over ResultDigest, when results are signed. Ed25519 signatures are raw,
+// ECDSA signatures are ASN.1 encoded. Streamed responses send it as a
+// trailer.
+const ProofSignatureHeader = "X-Proof-Signature"
+
+// ResultDigest is the digest the results of the prover are signed over: the
+// SHA-256 of the raw encoding of the proof followed by the input hash, as 32
+// big-endian bytes.
+func ResultDigest(proof *prover.Proof, inputHash *big.Int) ([]byte, error) {
+	raw, err := proof.MarshalFormat(prover.ProofFormatRaw, prover.NumberFormatHex)
+	if err != nil {
+		return nil, err
+	}
+	if inputHash.Sign() < 0 || inputHash.BitLen() > 256 {
+		return nil, fmt.Errorf("the input hash does not fit in 32 bytes")
+	}
+	h := sha256.New()
+	h.Write(raw)
+	h.Write(inputHash.FillBytes(make([]byte, 32)))
+	return h.Sum(nil), nil
+}
+
+// VerifyResult checks the signature of a result, as sent in
+// ProofSignatureHeader, with the public key /info reports.
+func VerifyResult(key crypto.PublicKey, proof *prover.Proof, inputHash *big.Int, signature []byte) error {
+	digest, err := ResultDigest(proof, inputHash)
+	if err != nil {
+		return err
+	}
+	if !verifySignature(key, digest, signature) {
+		return fmt.Errorf("invalid result signature")
+	}
+	return nil
+}
+
+func signingFailedError(err error) *Error {
+	return &Error{StatusCode: http.StatusInternalServerError, Code: "signing_failed", Message: fmt.Sprintf("the proof could not be signed: %s", err)}
+}
+
+// signResult returns the base64 encoded signature of signer over the
+// ResultDigest of proof, or an empty string if signer is nil.
+func signResult(signer crypto.Signer, proof *prover.Proof, inputHash *big.Int) (string, error) {
+	if signer == nil {
+		return "", nil
+	}
+	digest, err := ResultDigest(proof, inputHash)
+	if err != nil {
+		return "", err
+	}
+	// Ed25519 signs the digest itself, like the signatures of clients.
+	var opts crypto.SignerOpts = crypto.SHA256
+	if _, ok := signer.Public().(ed25519.PublicKey); ok {
+		opts = crypto.Hash(0)
+	}
+	signature, err := signer.Sign(rand.Reader, digest, opts)
+	if err != nil {
+		resultSigningFailuresCounter.Inc()
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(signature), nil
+}
+
+// signingKeyInfo is the public key results are signed with, as /info
+// reports it.
+type signingKeyInfo struct {
+	// Algorithm is ed25519 or ecdsa.
+	Algorithm string `json:"algorithm"`
+	// Curve is the curve of ECDSA keys, such as P-256.
+	Curve string `json:"curve,omitempty"`
+	// PublicKey is the PEM encoded PKIX public key.
+	PublicKey string `json:"publicKey"`
+}
+
+func newSigningKeyInfo(signer crypto.Signer) (*signingKeyInfo, error) {
+	if signer == nil {
+		return nil, nil
+	}
+	der, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	info := &signingKeyInfo{PublicKey: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))}
+	switch key := signer.Public().(type) {
+	case ed25519.PublicKey:
+		info.Algorithm = "ed25519"
+	case *ecdsa.PublicKey:
+		info.Algorithm, info.Curve = "ecdsa", key.Curve.Params().Name
+	default:
+		return nil, fmt.Errorf("unsupported signing key type %T", key)
+	}
+	return info, nil
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"math/big"
+	"strings"
+	"testing"
+	"worldcoin/gnark-mbu/prover"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend/groth16"
+)
+
+func TestSignResult(t *testing.T) {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	proof := &prover.Proof{Proof: groth16.NewProof(ecc.BN254)}
+	inputHash := big.NewInt(42)
+	for _, signer := range []crypto.Signer{edKey, ecKey} {
+		encoded, err := signResult(signer, proof, inputHash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = VerifyResult(signer.Public(), proof, inputHash, signature); err != nil {
+			t.Fatalf("%T: %v", signer, err)
+		}
+		// The signature is bound to the input hash.
+		if err = VerifyResult(signer.Public(), proof, big.NewInt(43), signature); err == nil {
+			t.Fatalf("%T: signature verified with another input hash", signer)
+		}
+	}
+	if signature, err := signResult(nil, proof, inputHash); signature != "" || err != nil {
+		t.Fatalf("expected no signature without a signer, got %q, %v", signature, err)
+	}
+}
+
+func TestSigningKeyInfo(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	info, err := newSigningKeyInfo(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Algorithm != "ecdsa" || info.Curve != "P-256" || !strings.HasPrefix(info.PublicKey, "-----BEGIN PUBLIC KEY-----") {
+		t.Fatalf("unexpected key info: %+v", info)
+	}
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	if info, err = newSigningKeyInfo(edKey); err != nil || info.Algorithm != "ed25519" || info.Curve != "" {
+		t.Fatalf("unexpected key info: %+v, %v", info, err)
+	}
+	if info, err = newSigningKeyInfo(nil); info != nil || err != nil {
+		t.Fatalf("expected no key info without a signer, got %+v, %v", info, err)
+	}
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"net/http"
+	"sync"
+	"time"
+	"worldcoin/gnark-mbu/logging"
+	"worldcoin/gnark-mbu/prover"
+
+	"github.com/rs/zerolog"
+)
+
+// Phases of a request timed for the slow request log, next to the stages of
+// its proof.
+const (
+	phaseDecode = "decode"
+	phaseQueue  = "queue"
+)
+
+// phaseTimings sums the time a request spent in each phase, in the order the
+// phases were first entered.
+type phaseTimings struct {
+	mu        sync.Mutex
+	names     []string
+	durations map[string]time.Duration
+}
+
+func (timings *phaseTimings) add(name string, elapsed time.Duration) {
+	timings.mu.Lock()
+	defer timings.mu.Unlock()
+	if timings.durations == nil {
+		timings.durations = make(map[string]time.Duration)
+	}
+	if _, ok := timings.durations[name]; !ok {
+		timings.names = append(timings.names, name)
+	}
+	timings.durations[name] += elapsed
+}
+
+// dict returns the timings as a log field, nil if there are none.
+func (timings *phaseTimings) dict() *zerolog.Event {
+	timings.mu.Lock()
+	defer timings.mu.Unlock()
+	if len(timings.names) == 0 {
+		return nil
+	}
+	dict := zerolog.Dict()
+	for _, name := range timings.names {
+		dict = dict.Dur(name, timings.durations[name])
+	}
+	return dict
+}
+
+// logPhase adds the time a request spent in a phase to its access log entry.
+func logPhase(r *http.Request, name string, elapsed time.Duration) {
+	if entry, ok := r.Context().Value(accessEntryKey{}).(*accessEntry); ok {
+		entry.phases.add(name, elapsed)
+	}
+}
+
+// phaseProgress returns a progress timing the stages of the proof of r from
+// submitted on, the time until its first stage, and between retries, being
+// spent in the queue.
+func phaseProgress(r *http.Request, submitted time.Time) prover.Progress {
+	var mu sync.Mutex
+	phase, since := phaseQueue, submitted
+	return func(stage prover.ProofStage) {
+		mu.Lock()
+		defer mu.Unlock()
+		now := time.Now()
+		logPhase(r, phase, now.Sub(since))
+		phase, since = string(stage), now
+		if stage == prover.StageDone {
+			phase = phaseQueue
+		}
+	}
+}
+
+// slowRequests logs a warning for the proof requests taking longer than
+// threshold to serve, with their batch size and the time they spent in each
+// phase, and counts them in slowRequestsCounter. It runs inside accessLog,
+// whose entry it reads. A zero threshold logs none.
+func slowRequests(next http.Handler, threshold time.Duration) http.Handler {
+	if threshold <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r)
+		entry, ok := r.Context().Value(accessEntryKey{}).(*accessEntry)
+		if !ok || entry.batchSize.Load() == 0 {
+			return
+		}
+		latency := time.Since(entry.start)
+		if latency <= threshold {
+			return
+		}
+		slowRequestsCounter.WithLabelValues(r.URL.Path).Inc()
+		event := logging.Logger().Warn().
+			Str("requestId", entry.id).
+			Str("path", r.URL.Path).
+			Int64("batchSize", entry.batchSize.Load()).
+			Dur("latency", latency).
+			Dur("threshold", threshold)
+		if recorder := findStatusRecorder(w); recorder != nil {
+			status := recorder.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			event = event.Int("status", status)
+		}
+		if tenant := entry.tenantName(); tenant != "" {
+			event = event.Str("tenant", tenant)
+		}
+		if phases := entry.phases.dict(); phases != nil {
+			event = event.Dict("phases", phases)
+		}
+		event.Msg("slow request")
+	})
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"worldcoin/gnark-mbu/logging"
+	"worldcoin/gnark-mbu/prover"
+
+	"github.com/rs/zerolog"
+)
+
+func TestSlowRequests(t *testing.T) {
+	var logs bytes.Buffer
+	previous := *logging.Logger()
+	*logging.Logger() = zerolog.New(&logs).Level(zerolog.WarnLevel)
+	defer func() { *logging.Logger() = previous }()
+
+	handler := accessLog(slowRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/info" {
+			time.Sleep(20 * time.Millisecond)
+			return
+		}
+		logBatchSize(r, 4)
+		logPhase(r, phaseDecode, time.Millisecond)
+		progress := phaseProgress(r, time.Now())
+		time.Sleep(10 * time.Millisecond)
+		for _, stage := range prover.ProofStages {
+			progress(stage)
+		}
+		if r.URL.Path == "/prove" {
+			time.Sleep(10 * time.Millisecond)
+		}
+		w.WriteHeader(http.StatusGatewayTimeout)
+	}), 15*time.Millisecond), nil)
+
+	// Requests without a batch, or served within the threshold, are not
+	// logged: /prove_fast proves as long as /prove but returns right after
+	// its proof.
+	for _, path := range []string{"/info", "/prove_fast"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, path, nil))
+		if logs.Len() != 0 {
+			t.Fatalf("expected %s not to be logged as slow, got %s", path, logs.String())
+		}
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/prove", nil))
+	var entry struct {
+		Level     string             `json:"level"`
+		Message   string             `json:"message"`
+		Path      string             `json:"path"`
+		Status    int                `json:"status"`
+		BatchSize int                `json:"batchSize"`
+		Latency   float64            `json:"latency"`
+		Phases    map[string]float64 `json:"phases"`
+	}
+	if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Level != "warn" || entry.Message != "slow request" || entry.Path != "/prove" || entry.Status != http.StatusGatewayTimeout ||
+		entry.BatchSize != 4 || entry.Latency < 20 {
+		t.Fatalf("unexpected slow request entry %s", logs.String())
+	}
+	if entry.Phases[phaseDecode] != 1 || entry.Phases[phaseQueue] < 10 || len(entry.Phases) != len(prover.ProofStages)+1 {
+		t.Fatalf("expected the decode, queue and proof stage timings, got %v", entry.Phases)
+	}
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"worldcoin/gnark-mbu/logging"
+	"worldcoin/gnark-mbu/prover"
+)
+
+// proveSplitHandler proves a batch of any size as consecutive batches of the
+// batch size of the circuit, streaming the proof of each sub-batch back as a
+// line of NDJSON in order.
+type proveSplitHandler struct {
+	proveHandler
+}
+
+// splitResult is a line of the response of the split endpoint. Metadata is
+// always included, as the roots and input hash of the sub-batches are not
+// known to the caller.
+type splitResult struct {
+	batchResult
+	Padding int `json:"padding,omitempty"`
+}
+
+func (handler proveSplitHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if limitErr := handler.limiter.allowIP(r); limitErr != nil {
+		limitErr.send(w)
+		return
+	}
+	logging.Logger().Info().Msg("received prove split request")
+	w.Header().Set(CircuitVersionHeader, prover.CircuitSemver)
+	if handler.system.current.Load().provingSystem == nil {
+		proverUnavailableError().send(w)
+		return
+	}
+	buf, readErr := handler.limits.readBody(w, r)
+	if readErr != nil {
+		readErr.send(w)
+		return
+	}
+	params, decodeErr := handler.limits.decodeParameters(r, buf, handler.legacyJSON)
+	if decodeErr != nil {
+		decodeErr.send(w)
+		return
+	}
+	logBatchSize(r, len(params.IdComms))
+	digest := params.Digest()
+	clientId, authErr := authenticate(r, digest, handler.clientKeys, handler.requireSignatures)
+	if authErr != nil {
+		authErr.send(w)
+		return
+	}
+	flightKey, keyErr := idempotencyKey(r, clientId)
+	if keyErr != nil {
+		keyErr.send(w)
+		return
+	}
+	if limitErr := handler.limiter.allowClient(clientId); limitErr != nil {
+		limitErr.send(w)
+		return
+	}
+	audit := logging.Audit().With().Str("requestId", requestID(r)).Str("clientId", clientId).Str("digest", hex.EncodeToString(digest[:])).Str("remoteAddr", r.RemoteAddr).Logger()
+	audit.Info().Bool("authenticated", clientId != "").Int("size", len(params.IdComms)).Msg("split proof requested")
+	encoding, encodingErr := proofEncoding(r, handler.encoding)
+	if encodingErr != nil {
+		encodingErr.send(w)
+		return
+	}
+	sched, scheduleErr := requestSchedule(r)
+	if scheduleErr != nil {
+		scheduleErr.send(w)
+		return
+	}
+
+	// All sub-batches are proven with the same keys, those of the largest
+	// batch size for the tree depth of the batch.
+	system := handler.keys.splitSystemFor(params)
+	if system == nil {
+		system = handler.system
+	}
+	g := system.acquire()
+	defer g.release()
+	if g.provingSystem == nil {
+		proverUnavailableError().send(w)
+		return
+	}
+	if circuitErr := allowCircuit(r, shapeOf(g.provingSystem)); circuitErr != nil {
+		circuitErr.send(w)
+		return
+	}
+	batches, err := g.provingSystem.SplitBatch(params)
+	if err != nil {
+		audit.Info().Err(err).Msg("split failed")
+		proofError(err).send(w)
+		return
+	}
+	audit.Info().Int("batches", len(batches)).Msg("batch split")
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+	// Each sub-batch starts from the post root of the previous one, so
+	// they are proven in order and the first failure ends the response.
+	for index, batch := range batches {
+		key := flightKey
+		if key != "" {
+			key += ":" + strconv.Itoa(index)
+		}
+		sub := batch.Parameters
+		res := handler.proveCancellable(sched, g, sub, key, nil)
+		result := splitResult{batchResult: batchResult{Index: index}, Padding: batch.Padding}
+		if res.err != nil {
+			audit.Info().Int("index", index).Err(res.err).Msg("proof failed")
+			result.Error = proofError(res.err)
+		} else {
+			if !res.cached {
+				logProof(r, res.elapsed)
+			}
+			audit.Info().Int("index", index).Str("inputHash", sub.InputHash.Text(16)).Bool("cached", res.cached).Bool("coalesced", res.coalesced).Msg("proof generated")
+			result.Proof = res.proof.Encoded(encoding, handler.numbers)
+			result.Metadata = newProofMetadata(g.provingSystem, sub, res.elapsed, handler.numbers)
+		}
+		if err = encoder.Encode(&result); err != nil {
+			logging.Logger().Error().Err(err).Msg("error writing split result")
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if res.err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"worldcoin/gnark-mbu/prover"
+
+	"github.com/consensys/gnark-crypto/ecc"
+)
+
+func TestProveSplitRejectsBadBatch(t *testing.T) {
+	ps := &prover.ProvingSystem{Curve: ecc.BN254, TreeDepth: 2, BatchSize: 2}
+	handler := proveSplitHandler{proveHandler: proveHandler{system: newActiveSystem(ps), drain: newDrain()}}
+
+	// Three insertions split into two batches, but the roots do not chain.
+	body := `{"startIndex":0,"preRoot":"0x1","postRoot":"0x2","identityCommitments":["0x3","0x4","0x5"],"merkleProofs":[["0x0","0x0"],["0x3","0x0"],["0x0","0x0"]]}`
+	r := httptest.NewRequest(http.MethodPost, "/prove_split", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, r)
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d: %s", recorder.Code, recorder.Body)
+	}
+	var response struct {
+		Code string `json:"code"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Code != "root_mismatch" {
+		t.Fatalf("expected the batch to be rejected before proving, got %s", recorder.Body)
+	}
+}
@@ -0,0 +1,319 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"worldcoin/gnark-mbu/logging"
+	"worldcoin/gnark-mbu/secrets"
+
+	"gopkg.in/yaml.v3"
+)
+
+// APIKeyHeader carries the API key of a tenant, which may also be sent as an
+// Authorization bearer token.
+const APIKeyHeader = "X-API-Key"
+
+// tenantsReloadInterval is how often the tenants file is checked for
+// changes.
+const tenantsReloadInterval = 5 * time.Second
+
+// TenantCircuit selects the circuits of a tree depth, batch size and mode
+// (insertion or indexed), its zero fields matching any.
+type TenantCircuit struct {
+	TreeDepth uint32 `yaml:"treeDepth"`
+	BatchSize uint32 `yaml:"batchSize"`
+	Mode      string `yaml:"mode"`
+}
+
+func (circuit TenantCircuit) matches(shape keyShape) bool {
+	return (circuit.TreeDepth == 0 || circuit.TreeDepth == shape.treeDepth) &&
+		(circuit.BatchSize == 0 || circuit.BatchSize == shape.batchSize) &&
+		(circuit.Mode == "" || circuit.Mode == shape.mode())
+}
+
+// Tenant is a user of a prover shared by several, identified by its API keys.
+type Tenant struct {
+	Name    string   `yaml:"name"`
+	APIKeys []string `yaml:"apiKeys"`
+	// APIKeySecrets are references to API keys held by a secrets manager,
+	// such as vault://secret/data/prover#tenant, which are added to
+	// APIKeys when the tenants are loaded.
+	APIKeySecrets []string `yaml:"apiKeySecrets"`
+	// Circuits are the circuits the tenant may use, all of them if empty.
+	Circuits []TenantCircuit `yaml:"circuits"`
+	// RateLimit limits how often the tenant may send requests. A zero rate
+	// does not limit them.
+	RateLimit RateLimit `yaml:"rateLimit"`
+	// MaxConcurrentRequests bounds the requests of the tenant served at
+	// once. Zero means unbounded.
+	MaxConcurrentRequests int `yaml:"maxConcurrentRequests"`
+}
+
+// LoadTenants reads the tenants of a YAML file holding a list of Tenant
+// under the tenants key, reading their APIKeySecrets with provider, which
+// may be nil if there are none. Tenants must have a unique name and at least
+// one API key, which no other tenant has.
+func LoadTenants(ctx context.Context, path string, provider secrets.Provider) ([]Tenant, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Tenants []Tenant `yaml:"tenants"`
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err = decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("invalid tenants file %s: %w", path, err)
+	}
+	for i := range file.Tenants {
+		tenant := &file.Tenants[i]
+		for _, ref := range tenant.APIKeySecrets {
+			if provider == nil {
+				return nil, fmt.Errorf("invalid tenants file %s: tenant %s has API key secrets but no secrets provider is configured", path, tenant.Name)
+			}
+			key, err := provider.Secret(ctx, ref)
+			if err != nil {
+				return nil, fmt.Errorf("invalid tenants file %s: tenant %s: %w", path, tenant.Name, err)
+			}
+			tenant.APIKeys = append(tenant.APIKeys, string(bytes.TrimSpace(key)))
+		}
+	}
+	names := make(map[string]bool)
+	keys := make(map[string]string)
+	for _, tenant := range file.Tenants {
+		if tenant.Name == "" || names[tenant.Name] {
+			return nil, fmt.Errorf("invalid tenants file %s: tenant names must be set and unique, got %q", path, tenant.Name)
+		}
+		names[tenant.Name] = true
+		if len(tenant.APIKeys) == 0 {
+			return nil, fmt.Errorf("invalid tenants file %s: tenant %s has no API key", path, tenant.Name)
+		}
+		for _, key := range tenant.APIKeys {
+			if key == "" {
+				return nil, fmt.Errorf("invalid tenants file %s: tenant %s has an empty API key", path, tenant.Name)
+			}
+			if other, ok := keys[key]; ok {
+				return nil, fmt.Errorf("invalid tenants file %s: tenants %s and %s share an API key", path, other, tenant.Name)
+			}
+			keys[key] = tenant.Name
+		}
+		for _, circuit := range tenant.Circuits {
+			if circuit.Mode != "" && circuit.Mode != "insertion" && circuit.Mode != "indexed" {
+				return nil, fmt.Errorf("invalid tenants file %s: tenant %s has a circuit of unknown mode %q", path, tenant.Name, circuit.Mode)
+			}
+		}
+		if tenant.RateLimit.Rate < 0 || tenant.MaxConcurrentRequests < 0 {
+			return nil, fmt.Errorf("invalid tenants file %s: tenant %s has a negative quota", path, tenant.Name)
+		}
+	}
+	return file.Tenants, nil
+}
+
+// tenantState is the configuration of a tenant with its usage, which is kept
+// when the tenants are reloaded.
+type tenantState struct {
+	mu       sync.Mutex
+	tenant   Tenant
+	bucket   *tokenBucket
+	inFlight int
+}
+
+// enter admits a request of the tenant, unless it exceeds its quotas.
+func (state *tenantState) enter(now time.Time) *Error {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if state.tenant.RateLimit.Rate > 0 {
+		if state.bucket == nil {
+			state.bucket = &tokenBucket{tokens: state.tenant.RateLimit.burst(), last: now}
+		}
+		if wait, ok := state.bucket.take(now, state.tenant.RateLimit); !ok {
+			rateLimitedCounter.WithLabelValues("tenant").Inc()
+			return rateLimitedError("tenant", wait)
+		}
+	}
+	if max := state.tenant.MaxConcurrentRequests; max > 0 && state.inFlight >= max {
+		return &Error{
+			StatusCode: http.StatusTooManyRequests,
+			Code:       "tenant_concurrency_exceeded",
+			Message:    fmt.Sprintf("tenant %s already has %d requests in flight", state.tenant.Name, max),
+		}
+	}
+	state.inFlight++
+	tenantRequestsInFlightGauge.WithLabelValues(state.tenant.Name).Inc()
+	return nil
+}
+
+func (state *tenantState) exit() {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	state.inFlight--
+	tenantRequestsInFlightGauge.WithLabelValues(state.tenant.Name).Dec()
+}
+
+// tenantSet authenticates the requests of the tenants and enforces their
+// quotas. A nil set serves requests without tenants.
+type tenantSet struct {
+	mu sync.RWMutex
+	// byKey maps the SHA-256 of API keys to their tenant, so that looking
+	// keys up does not leak them through timing.
+	byKey  map[[32]byte]*tenantState
+	byName map[string]*tenantState
+	now    func() time.Time
+}
+
+func newTenantSet(tenants []Tenant) *tenantSet {
+	set := &tenantSet{byName: make(map[string]*tenantState), now: time.Now}
+	set.update(tenants)
+	return set
+}
+
+// update replaces the tenants of the set. Tenants keep their rate limit
+// bucket and requests in flight across updates.
+func (set *tenantSet) update(tenants []Tenant) {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	byKey := make(map[[32]byte]*tenantState)
+	byName := make(map[string]*tenantState)
+	for _, tenant := range tenants {
+		state, ok := set.byName[tenant.Name]
+		if !ok {
+			state = &tenantState{}
+		}
+		state.mu.Lock()
+		state.tenant = tenant
+		state.mu.Unlock()
+		byName[tenant.Name] = state
+		for _, key := range tenant.APIKeys {
+			byKey[sha256.Sum256([]byte(key))] = state
+		}
+	}
+	set.byKey, set.byName = byKey, byName
+}
+
+// apiKey returns the API key of r, from APIKeyHeader or a bearer token.
+func apiKey(r *http.Request) string {
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+		return key
+	}
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
+
+func (set *tenantSet) authenticate(r *http.Request) (*tenantState, *Error) {
+	key := apiKey(r)
+	if key == "" {
+		return nil, unauthorizedError("missing_api_key", "requests must carry the API key of a tenant")
+	}
+	set.mu.RLock()
+	defer set.mu.RUnlock()
+	state, ok := set.byKey[sha256.Sum256([]byte(key))]
+	if !ok {
+		logging.Audit().Warn().Str("remoteAddr", r.RemoteAddr).Msg("rejected request with unknown API key")
+		return nil, unauthorizedError("unknown_api_key", "the API key is not one of a tenant")
+	}
+	return state, nil
+}
+
+type tenantKey struct{}
+
+// track serves the requests of the tenants within their quotas, counting
+// them by tenant and status.
+func (set *tenantSet) track(next http.Handler) http.Handler {
+	if set == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state, authErr := set.authenticate(r)
+		if authErr != nil {
+			authErr.send(w)
+			return
+		}
+		state.mu.Lock()
+		name := state.tenant.Name
+		state.mu.Unlock()
+		logTenant(r, name)
+		recorder := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			if recorder.status == 0 {
+				recorder.status = http.StatusOK
+			}
+			tenantRequestsCounter.WithLabelValues(name, strconv.Itoa(recorder.status)).Inc()
+		}()
+		if quotaErr := state.enter(set.now()); quotaErr != nil {
+			quotaErr.send(recorder)
+			return
+		}
+		defer state.exit()
+		next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), tenantKey{}, state)))
+	})
+}
+
+// allowCircuit checks that the tenant of r may use the circuit of shape.
+// Requests served without tenants may use any circuit.
+func allowCircuit(r *http.Request, shape keyShape) *Error {
+	state, ok := r.Context().Value(tenantKey{}).(*tenantState)
+	if !ok {
+		return nil
+	}
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if len(state.tenant.Circuits) == 0 {
+		return nil
+	}
+	for _, circuit := range state.tenant.Circuits {
+		if circuit.matches(shape) {
+			return nil
+		}
+	}
+	return &Error{
+		StatusCode: http.StatusForbidden,
+		Code:       "circuit_not_allowed",
+		Message:    fmt.Sprintf("tenant %s may not use the circuit of tree depth %d and batch size %d", state.tenant.Name, shape.treeDepth, shape.batchSize),
+	}
+}
+
+// spawnTenantsReloadJob reloads the tenants of set from path whenever the
+// file changes. A file that fails to load is logged and the tenants are kept.
+func spawnTenantsReloadJob(set *tenantSet, path string, provider secrets.Provider) RunningJob {
+	stopped := make(chan struct{})
+	start := func() {
+		var modified time.Time
+		var size int64
+		if stat, err := os.Stat(path); err == nil {
+			modified, size = stat.ModTime(), stat.Size()
+		}
+		ticker := time.NewTicker(tenantsReloadInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-stopped:
+				return
+			}
+			stat, err := os.Stat(path)
+			if err != nil || (stat.ModTime().Equal(modified) && stat.Size() == size) {
+				continue
+			}
+			modified, size = stat.ModTime(), stat.Size()
+			tenants, err := LoadTenants(context.Background(), path, provider)
+			if err != nil {
+				logging.Logger().Error().Err(err).Msg("failed to reload tenants, keeping the current ones")
+				continue
+			}
+			set.update(tenants)
+			logging.Logger().Info().Int("tenants", len(tenants)).Msg("tenants reloaded")
+		}
+	}
+	return SpawnJob(start, func() { close(stopped) })
+}
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"worldcoin/gnark-mbu/secrets"
+)
+
+const tenantsYAML = `tenants:
+  - name: sequencer
+    apiKeys: [sequencer-key]
+    circuits:
+      - treeDepth: 16
+        mode: insertion
+    maxConcurrentRequests: 1
+  - name: indexer
+    apiKeys: [indexer-key, indexer-key-2]
+    rateLimit:
+      rate: 1
+      burst: 2
+`
+
+func writeTenants(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "tenants.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadTenants(t *testing.T) {
+	tenants, err := LoadTenants(context.Background(), writeTenants(t, tenantsYAML), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tenants) != 2 || tenants[0].Circuits[0].TreeDepth != 16 || tenants[0].MaxConcurrentRequests != 1 || tenants[1].RateLimit.Burst != 2 || len(tenants[1].APIKeys) != 2 {
+		t.Fatalf("unexpected tenants %+v", tenants)
+	}
+
+	for _, invalid := range []string{
+		"tenants:\n  - name: a\n",
+		"tenants:\n  - name: a\n    apiKeys: [k]\n  - name: b\n    apiKeys: [k]\n",
+		"tenants:\n  - name: a\n    apiKeys: [k]\n  - name: a\n    apiKeys: [l]\n",
+		"tenants:\n  - name: a\n    apiKeys: [k]\n    circuits: [{mode: deletion}]\n",
+		"tenants:\n  - name: a\n    apiKey: k\n",
+	} {
+		if _, err = LoadTenants(context.Background(), writeTenants(t, invalid), nil); err == nil {
+			t.Fatalf("expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestLoadTenantsSecrets(t *testing.T) {
+	path := writeTenants(t, "tenants:\n  - name: a\n    apiKeySecrets: [vault://secret/data/prover#a]\n")
+	provider := secrets.ProviderFunc(func(ctx context.Context, ref string) ([]byte, error) {
+		if ref != "vault://secret/data/prover#a" {
+			return nil, fmt.Errorf("no secret %s", ref)
+		}
+		return []byte("key-a\n"), nil
+	})
+	tenants, err := LoadTenants(context.Background(), path, provider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tenants[0].APIKeys) != 1 || tenants[0].APIKeys[0] != "key-a" {
+		t.Fatalf("unexpected API keys %v", tenants[0].APIKeys)
+	}
+	if _, err = LoadTenants(context.Background(), path, nil); err == nil {
+		t.Fatal("expected API key secrets to need a provider")
+	}
+}
+
+func TestTenants(t *testing.T) {
+	tenants, err := LoadTenants(context.Background(), writeTenants(t, tenantsYAML), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := newTenantSet(tenants)
+	now := time.Unix(1000, 0)
+	set.now = func() time.Time { return now }
+	release := make(chan struct{})
+	var shape keyShape
+	handler := set.track(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("block") != "" {
+			<-release
+		}
+		if circuitErr := allowCircuit(r, shape); circuitErr != nil {
+			circuitErr.send(w)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	serve := func(header, key, query string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/prove"+query, nil)
+		if key != "" {
+			r.Header.Set(header, key)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := serve(APIKeyHeader, "", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected requests without API key to be rejected, got %d", w.Code)
+	}
+	if w := serve(APIKeyHeader, "other", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected unknown API keys to be rejected, got %d", w.Code)
+	}
+
+	// Tenants may only use their circuits.
+	shape = keyShape{treeDepth: 16, batchSize: 100}
+	if w := serve(APIKeyHeader, "sequencer-key", ""); w.Code != http.StatusOK {
+		t.Fatalf("expected the request to be served, got %d: %s", w.Code, w.Body)
+	}
+	shape.indexed = true
+	if w := serve("Authorization", "Bearer sequencer-key", ""); w.Code != http.StatusForbidden {
+		t.Fatalf("expected the indexed circuit to be forbidden, got %d", w.Code)
+	}
+	if w := serve(APIKeyHeader, "indexer-key", ""); w.Code != http.StatusOK {
+		t.Fatalf("expected tenants without circuits to use any, got %d", w.Code)
+	}
+
+	// The indexer may send two requests at once, then one per second.
+	if w := serve(APIKeyHeader, "indexer-key-2", ""); w.Code != http.StatusOK {
+		t.Fatalf("expected the burst to be served, got %d", w.Code)
+	}
+	if w := serve(APIKeyHeader, "indexer-key", ""); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected the rate limit to be exceeded, got %d", w.Code)
+	}
+	now = now.Add(time.Second)
+	if w := serve(APIKeyHeader, "indexer-key", ""); w.Code != http.StatusOK {
+		t.Fatalf("expected the bucket to refill, got %d", w.Code)
+	}
+
+	// The sequencer may send one request at once, which it keeps when the
+	// tenants are reloaded.
+	shape.indexed = false
+	done := make(chan struct{})
+	go func() {
+		serve(APIKeyHeader, "sequencer-key", "?block=1")
+		close(done)
+	}()
+	for {
+		set.byName["sequencer"].mu.Lock()
+		inFlight := set.byName["sequencer"].inFlight
+		set.byName["sequencer"].mu.Unlock()
+		if inFlight == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	set.update(tenants)
+	if w := serve(APIKeyHeader, "sequencer-key", ""); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected the concurrency quota to be exceeded, got %d", w.Code)
+	}
+	close(release)
+	<-done
+	if w := serve(APIKeyHeader, "sequencer-key", ""); w.Code != http.StatusOK {
+		t.Fatalf("expected the request to be served once the other finished, got %d", w.Code)
+	}
+
+	// Removed tenants are rejected.
+	set.update(tenants[1:])
+	if w := serve(APIKeyHeader, "sequencer-key", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected the removed tenant to be rejected, got %d", w.Code)
+	}
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"worldcoin/gnark-mbu/secrets"
+)
+
+// LoadTLSCertificate reads the PEM certificate chain and private key the
+// server is served over HTTPS with. Both are files or secret references, so
+// that the private key can be held by a secrets manager such as Vault rather
+// than on disk.
+func LoadTLSCertificate(ctx context.Context, provider secrets.Provider, certLocation string, keyLocation string) (*tls.Certificate, error) {
+	certPEM, err := provider.Secret(ctx, certLocation)
+	if err != nil {
+		return nil, fmt.Errorf("reading the TLS certificate: %w", err)
+	}
+	keyPEM, err := provider.Secret(ctx, keyLocation)
+	if err != nil {
+		return nil, fmt.Errorf("reading the TLS key: %w", err)
+	}
+	certificate, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TLS certificate or key: %w", err)
+	}
+	return &certificate, nil
+}
+
+// tlsConfig returns the TLS configuration of servers presenting certificate,
+// nil to serve plain HTTP if certificate is nil.
+func tlsConfig(certificate *tls.Certificate) *tls.Config {
+	if certificate == nil {
+		return nil
+	}
+	return &tls.Config{Certificates: []tls.Certificate{*certificate}, MinVersion: tls.VersionTLS12}
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"worldcoin/gnark-mbu/secrets"
+)
+
+// freeAddress returns a local address nothing listens on.
+func freeAddress(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+func TestRunWithTLSKeyFromSecrets(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "prover"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(t.TempDir(), "cert.pem")
+	if err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The private key is only held by the secrets manager.
+	resolver := secrets.NewResolver()
+	resolver.Register("vault", secrets.ProviderFunc(func(ctx context.Context, ref string) ([]byte, error) {
+		if ref != "secret/data/prover#tlsKey" {
+			return nil, fmt.Errorf("unknown secret %s", ref)
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), nil
+	}))
+	certificate, err := LoadTLSCertificate(context.Background(), resolver, certFile, "vault://secret/data/prover#tlsKey")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	address := freeAddress(t)
+	instance := Run(&Config{ProverAddress: address, MetricsAddress: freeAddress(t), TLSCertificate: certificate}, nil)
+	defer func() {
+		instance.RequestStop()
+		instance.AwaitStop()
+	}()
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(leaf)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}, Timeout: time.Second}
+	for i := 0; ; i++ {
+		resp, err := client.Get("https://" + address + "/health")
+		if err == nil {
+			resp.Body.Close()
+			if resp.TLS == nil || !resp.TLS.HandshakeComplete {
+				t.Fatal("expected the server to be served over TLS")
+			}
+			break
+		}
+		if i == 50 {
+			t.Fatalf("expected the server to serve HTTPS with the key of the secrets manager: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	// Plain HTTP is not served.
+	resp, err := http.Get("http://" + address + "/health")
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("expected plain HTTP to be refused, got %d", resp.StatusCode)
+		}
+	}
+}
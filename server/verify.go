@@ -0,0 +1,86 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"worldcoin/gnark-mbu/prover"
+)
+
+type verifyHandler struct {
+	system *activeSystem
+	limits RequestLimits
+}
+
+type verifyRequest struct {
+	InputHash string       `json:"inputHash"`
+	PostRoot  string       `json:"postRoot,omitempty"`
+	Proof     prover.Proof `json:"proof"`
+}
+
+type verifyResponse struct {
+	Valid   bool   `json:"valid"`
+	Message string `json:"message,omitempty"`
+}
+
+func parseNumber(name string, s string) (big.Int, error) {
+	var n big.Int
+	if _, ok := n.SetString(s, 0); !ok {
+		return n, fmt.Errorf("invalid %s: %q", name, s)
+	}
+	return n, nil
+}
+
+func (handler verifyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	g := handler.system.acquire()
+	defer g.release()
+	provingSystem := handler.system.verifying(g)
+	if provingSystem == nil {
+		verifierUnavailableError().send(w)
+		return
+	}
+	buf, readErr := handler.limits.readBody(w, r)
+	if readErr != nil {
+		readErr.send(w)
+		return
+	}
+	var request verifyRequest
+	err := json.Unmarshal(buf, &request)
+	if err != nil {
+		malformedBodyError(err).send(w)
+		return
+	}
+	inputHash, err := parseNumber("inputHash", request.InputHash)
+	if err != nil {
+		malformedBodyError(err).send(w)
+		return
+	}
+	if provingSystem.PublicPostRoot {
+		var postRoot big.Int
+		postRoot, err = parseNumber("postRoot", request.PostRoot)
+		if err != nil {
+			malformedBodyError(err).send(w)
+			return
+		}
+		err = provingSystem.VerifyWithPostRoot(inputHash, postRoot, &request.Proof)
+	} else {
+		err = provingSystem.Verify(inputHash, &request.Proof)
+	}
+	response := verifyResponse{Valid: err == nil}
+	if err != nil {
+		response.Message = err.Error()
+	}
+	responseBytes, err := json.Marshal(&response)
+	if err != nil {
+		unexpectedError(err).send(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(responseBytes)
+}
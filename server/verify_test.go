@@ -0,0 +1,24 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"worldcoin/gnark-mbu/prover"
+
+	"github.com/consensys/gnark-crypto/ecc"
+)
+
+func TestVerifyRejectsOutOfRangeProof(t *testing.T) {
+	handler := verifyHandler{system: newActiveSystem(&prover.ProvingSystem{Curve: ecc.BN254, TreeDepth: 2, BatchSize: 1})}
+	for _, coordinate := range []string{"0x1" + strings.Repeat("0", 64), "-0x1"} {
+		body := fmt.Sprintf(`{"inputHash":"0x1","proof":{"ar":["%s","0x1"],"bs":[["0x1","0x1"],["0x1","0x1"]],"krs":["0x1","0x1"]}}`, coordinate)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/verify", strings.NewReader(body)))
+		if recorder.Code != http.StatusBadRequest {
+			t.Fatalf("expected the coordinate %s to be rejected with 400, got %d %s", coordinate, recorder.Code, recorder.Body.String())
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package server
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"worldcoin/gnark-mbu/logging"
+	"worldcoin/gnark-mbu/prover"
+
+	"github.com/gorilla/websocket"
+)
+
+// Events of /prove/ws, sent in this order for every proof. A proof served
+// from the cache or coalesced into another skips the progress events.
+const (
+	wsEventAccepted     = "accepted"
+	wsEventWitnessReady = "witness_ready"
+	wsEventProving      = "proving"
+	wsEventDone         = "done"
+	wsEventError        = "error"
+)
+
+// proveWebSocketHandler proves parameters sent over a WebSocket, pushing the
+// progress of every proof as events before its result. A connection proves
+// its requests one at a time, in the order they are sent. Each of them is
+// tracked by the drain, not the connection, so that idle connections do not
+// hold up shutdowns.
+type proveWebSocketHandler struct {
+	proveHandler
+	cors *CORS
+}
+
+// wsRequest is a message of the client. Signatures are sent in the message,
+// as browsers cannot set the headers of WebSocket handshakes.
+type wsRequest struct {
+	Parameters      json.RawMessage `json:"parameters"`
+	ClientId        string          `json:"clientId,omitempty"`
+	Signature       string          `json:"signature,omitempty"`
+	IncludeMetadata bool            `json:"includeMetadata,omitempty"`
+}
+
+// wsEvent is a message of the server. Stage is the prover.ProofStage of
+// proving events.
+type wsEvent struct {
+	Event string         `json:"event"`
+	Stage string         `json:"stage,omitempty"`
+	Proof json.Marshaler `json:"proof,omitempty"`
+	// Signature is sent as the ProofSignatureHeader of /prove.
+	Signature string         `json:"signature,omitempty"`
+	Metadata  *proofMetadata `json:"metadata,omitempty"`
+	Error     *Error         `json:"error,omitempty"`
+}
+
+// checkOrigin accepts handshakes from non-browser clients, from the origin of
+// the server and from the origins allowed by CORS.
+func (handler proveWebSocketHandler) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if handler.cors != nil && handler.cors.allowedOrigin(origin) != "" {
+		return true
+	}
+	parsed, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(parsed.Host, r.Host)
+}
+
+func (handler proveWebSocketHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if limitErr := handler.limiter.allowIP(r); limitErr != nil {
+		limitErr.send(w)
+		return
+	}
+	logging.Logger().Info().Msg("received prove websocket request")
+	w.Header().Set(CircuitVersionHeader, prover.CircuitSemver)
+	encoding, encodingErr := proofEncoding(r, handler.encoding)
+	if encodingErr != nil {
+		encodingErr.send(w)
+		return
+	}
+	sched, scheduleErr := requestSchedule(r)
+	if scheduleErr != nil {
+		scheduleErr.send(w)
+		return
+	}
+	upgrader := websocket.Upgrader{CheckOrigin: handler.checkOrigin}
+	conn, err := upgrader.Upgrade(w, r, http.Header{CircuitVersionHeader: {prover.CircuitSemver}})
+	if err != nil {
+		// The upgrader answered the handshake with the error.
+		return
+	}
+	defer conn.Close()
+	// The read and write timeouts of the server bound the handshake, not the
+	// proofs requested over the connection.
+	conn.UnderlyingConn().SetDeadline(time.Time{})
+	if handler.limits.MaxBodyBytes > 0 {
+		conn.SetReadLimit(handler.limits.MaxBodyBytes)
+	}
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if !handler.drain.enter() {
+			conn.WriteJSON(&wsEvent{Event: wsEventError, Error: shuttingDownError("the server is draining, retry on another instance")})
+			return
+		}
+		event := handler.proveMessage(r, conn, message, sched, encoding)
+		handler.drain.exit()
+		if err = conn.WriteJSON(event); err != nil {
+			logging.Logger().Error().Err(err).Msg("error writing websocket event")
+			return
+		}
+	}
+}
+
+// proveMessage proves the parameters of a message, sending its progress
+// events on conn, and returns its final event.
+func (handler proveWebSocketHandler) proveMessage(r *http.Request, conn *websocket.Conn, message []byte, sched schedule, encoding prover.ProofEncoding) *wsEvent {
+	fail := func(error *Error) *wsEvent {
+		return &wsEvent{Event: wsEventError, Error: error}
+	}
+	if limitMessage := handler.limits.checkJSON(message); limitMessage != "" {
+		return fail(requestTooLargeError(limitMessage))
+	}
+	var request wsRequest
+	if err := json.Unmarshal(message, &request); err != nil {
+		return fail(malformedBodyError(err))
+	}
+	params, err := unmarshalParameters(request.Parameters, false)
+	if err != nil {
+		return fail(malformedBodyError(err))
+	}
+	logBatchSize(r, len(params.IdComms))
+	digest := params.Digest()
+	clientId, authErr := authenticateSignature(r, request.ClientId, request.Signature, digest, handler.clientKeys, handler.requireSignatures)
+	if authErr != nil {
+		return fail(authErr)
+	}
+	if limitErr := handler.limiter.allowClient(clientId); limitErr != nil {
+		return fail(limitErr)
+	}
+	if circuitErr := allowCircuit(r, paramsShape(params)); circuitErr != nil {
+		return fail(circuitErr)
+	}
+	audit := logging.Audit().With().Str("requestId", requestID(r)).Str("clientId", clientId).Str("digest", hex.EncodeToString(digest[:])).Str("remoteAddr", r.RemoteAddr).Logger()
+	audit.Info().Bool("authenticated", clientId != "").Msg("websocket proof requested")
+	g := handler.systemFor(params).acquire()
+	defer g.release()
+	if g.provingSystem == nil {
+		return fail(proverUnavailableError())
+	}
+	if err = conn.WriteJSON(&wsEvent{Event: wsEventAccepted}); err != nil {
+		return fail(unexpectedError(err))
+	}
+
+	// Stages are reported by the proving goroutine; sends never block it,
+	// the buffer holding every stage of a proof.
+	events := make(chan wsEvent, len(prover.ProofStages))
+	progress := func(stage prover.ProofStage) {
+		event := wsEvent{Event: wsEventProving, Stage: string(stage)}
+		switch stage {
+		case prover.StageWitness, prover.StageDone:
+			return
+		case prover.StageSolving:
+			// The witness is built once the constraints are being solved.
+			select {
+			case events <- wsEvent{Event: wsEventWitnessReady}:
+			default:
+			}
+		}
+		select {
+		case events <- event:
+		default:
+		}
+	}
+	done := make(chan proofResult, 1)
+	go func() {
+		done <- handler.proveCancellable(sched, g, params, "", progress)
+	}()
+	var res proofResult
+wait:
+	for {
+		select {
+		case event := <-events:
+			if err = conn.WriteJSON(&event); err != nil {
+				logging.Logger().Error().Err(err).Msg("error writing websocket event")
+			}
+		case res = <-done:
+			break wait
+		}
+	}
+	// Stages are reported before the proof returns.
+	for len(events) > 0 {
+		event := <-events
+		conn.WriteJSON(&event)
+	}
+	if res.err != nil {
+		audit.Info().Err(res.err).Msg("proof failed")
+		return fail(proofError(res.err))
+	}
+	if !res.cached {
+		logProof(r, res.elapsed)
+	}
+	audit.Info().Str("inputHash", params.InputHash.Text(16)).Bool("cached", res.cached).Bool("coalesced", res.coalesced).Msg("proof generated")
+	signature, err := signResult(handler.signer, res.proof, &params.InputHash)
+	if err != nil {
+		logging.Logger().Error().Err(err).Msg("error signing proof")
+		return fail(signingFailedError(err))
+	}
+	event := &wsEvent{Event: wsEventDone, Proof: res.proof.Encoded(encoding, handler.numbers), Signature: signature}
+	if request.IncludeMetadata {
+		event.Metadata = newProofMetadata(g.provingSystem, params, res.elapsed, handler.numbers)
+	}
+	return event
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestProveWebSocket(t *testing.T) {
+	d := newDrain()
+	handler := proveWebSocketHandler{proveHandler: proveHandler{system: newActiveSystem(nil), drain: d}}
+	// The access log wraps the handler, which must still be able to upgrade.
+	server := httptest.NewServer(accessLog(handler, nil))
+	defer server.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var event struct {
+		Event string `json:"event"`
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	for _, test := range []struct {
+		message, code string
+	}{
+		{`not json`, "malformed_body"},
+		{`{"parameters": {"startIndex": "x"}}`, "malformed_body"},
+		{`{"parameters": {"startIndex": 0, "preRoot": "0x1", "postRoot": "0x2", "identityCommitments": ["0x3"], "merkleProofs": [["0x0"]]}}`, "prover_unavailable"},
+	} {
+		if err = conn.WriteMessage(websocket.TextMessage, []byte(test.message)); err != nil {
+			t.Fatal(err)
+		}
+		if err = conn.ReadJSON(&event); err != nil {
+			t.Fatal(err)
+		}
+		if event.Event != wsEventError || event.Error.Code != test.code {
+			t.Fatalf("%s: expected a %s error, got %+v", test.message, test.code, event)
+		}
+	}
+
+	// Requests sent while draining are refused and the connection closed.
+	// Idle connections do not hold up the drain.
+	d.run(0)
+	conn.WriteMessage(websocket.TextMessage, []byte(`{}`))
+	if err = conn.ReadJSON(&event); err != nil || event.Error.Code != "shutting_down" {
+		t.Fatalf("expected the request to be refused while draining, got %+v %v", event, err)
+	}
+	if _, _, err = conn.ReadMessage(); err == nil {
+		t.Fatal("expected the connection to be closed")
+	}
+}
+
+func TestWebSocketOrigin(t *testing.T) {
+	handler := proveWebSocketHandler{cors: &CORS{AllowedOrigins: []string{"https://dashboard.example"}}}
+	for origin, allowed := range map[string]bool{
+		"":                          true,
+		"https://prover.example":    true,
+		"https://dashboard.example": true,
+		"https://evil.example":      false,
+	} {
+		r := httptest.NewRequest(http.MethodGet, "https://prover.example/prove/ws", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		if handler.checkOrigin(r) != allowed {
+			t.Fatalf("origin %q: expected allowed to be %t", origin, allowed)
+		}
+	}
+}
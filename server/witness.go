@@ -0,0 +1,80 @@
+package server
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"worldcoin/gnark-mbu/logging"
+	"worldcoin/gnark-mbu/prover"
+)
+
+// witnessHandler builds the witness of a batch without proving it, for
+// provers running elsewhere with the same keys. Requests are decoded and
+// authenticated like /prove, but do not wait in the proof queue.
+type witnessHandler struct {
+	proveHandler
+}
+
+func (handler witnessHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set(CircuitVersionHeader, prover.CircuitSemver)
+	if limitErr := handler.limiter.allowIP(r); limitErr != nil {
+		limitErr.send(w)
+		return
+	}
+	if handler.system.current.Load().provingSystem == nil {
+		proverUnavailableError().send(w)
+		return
+	}
+	buf, readErr := handler.limits.readBody(w, r)
+	if readErr != nil {
+		readErr.send(w)
+		return
+	}
+	params, decodeErr := handler.limits.decodeParameters(r, buf, handler.legacyJSON)
+	if decodeErr != nil {
+		decodeErr.send(w)
+		return
+	}
+	logBatchSize(r, len(params.IdComms))
+	digest := params.Digest()
+	clientId, authErr := authenticate(r, digest, handler.clientKeys, handler.requireSignatures)
+	if authErr != nil {
+		authErr.send(w)
+		return
+	}
+	if limitErr := handler.limiter.allowClient(clientId); limitErr != nil {
+		limitErr.send(w)
+		return
+	}
+	if circuitErr := allowCircuit(r, paramsShape(params)); circuitErr != nil {
+		circuitErr.send(w)
+		return
+	}
+	audit := logging.Audit().With().Str("requestId", requestID(r)).Str("clientId", clientId).Str("digest", hex.EncodeToString(digest[:])).Str("remoteAddr", r.RemoteAddr).Logger()
+	audit.Info().Bool("authenticated", clientId != "").Msg("witness requested")
+	g := handler.systemFor(params).acquire()
+	defer g.release()
+	provingSystem := g.provingSystem
+	if provingSystem == nil {
+		proverUnavailableError().send(w)
+		return
+	}
+	witness, err := provingSystem.BuildWitness(params)
+	if err != nil {
+		audit.Info().Err(err).Msg("witness failed")
+		proverError(err).send(w)
+		return
+	}
+	responseBytes, err := json.Marshal(witness)
+	if err != nil {
+		unexpectedError(err).send(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(responseBytes)
+}
@@ -5,6 +5,13 @@ import (
 	"math/big"
 )
 
+// nodeHash hashes two nodes of a tree into their parent.
+type nodeHash func(left, right *big.Int) (*big.Int, error)
+
+func poseidonHash(left, right *big.Int) (*big.Int, error) {
+	return poseidon.Hash([]*big.Int{left, right})
+}
+
 type PoseidonNode interface {
 	depth() int
 	value() big.Int
@@ -37,6 +44,7 @@ func (node *PoseidonFullNode) withValue(index int, val big.Int) PoseidonNode {
 		dep:   node.depth(),
 		left:  node.left,
 		right: node.right,
+		hash:  node.hash,
 	}
 	if node.depth() == 0 {
 		result.val = val
@@ -53,12 +61,13 @@ func (node *PoseidonFullNode) withValue(index int, val big.Int) PoseidonNode {
 
 func (node *PoseidonEmptyNode) withValue(index int, val big.Int) PoseidonNode {
 	result := PoseidonFullNode{
-		dep: node.depth(),
+		dep:  node.depth(),
+		hash: node.hash,
 	}
 	if node.depth() == 0 {
 		result.val = val
 	} else {
-		emptyChild := PoseidonEmptyNode{dep: node.depth() - 1, emptyTreeValues: node.emptyTreeValues}
+		emptyChild := PoseidonEmptyNode{dep: node.depth() - 1, emptyTreeValues: node.emptyTreeValues, hash: node.hash}
 		initializedChild := emptyChild.withValue(index, val)
 		if indexIsLeft(index, node.depth()) {
 			result.left = initializedChild
@@ -96,18 +105,20 @@ type PoseidonFullNode struct {
 	val   big.Int
 	left  PoseidonNode
 	right PoseidonNode
+	hash  nodeHash
 }
 
 func (node *PoseidonFullNode) initHash() {
 	leftVal := node.left.value()
 	rightVal := node.right.value()
-	newVal, _ := poseidon.Hash([]*big.Int{&leftVal, &rightVal})
+	newVal, _ := node.hash(&leftVal, &rightVal)
 	node.val = *newVal
 }
 
 type PoseidonEmptyNode struct {
 	dep             int
 	emptyTreeValues []big.Int
+	hash            nodeHash
 }
 
 type PoseidonTree struct {
@@ -126,10 +137,21 @@ func (tree *PoseidonTree) Update(index int, value big.Int) []big.Int {
 }
 
 func NewTree(depth int) PoseidonTree {
+	return NewTreeWithEmptyLeaf(depth, *big.NewInt(0))
+}
+
+func NewTreeWithEmptyLeaf(depth int, emptyLeaf big.Int) PoseidonTree {
+	return NewTreeWithHash(depth, emptyLeaf, poseidonHash)
+}
+
+// NewTreeWithHash returns an empty tree whose nodes are hashed with hash,
+// for circuits set up with another tree hash than Poseidon.
+func NewTreeWithHash(depth int, emptyLeaf big.Int, hash nodeHash) PoseidonTree {
 	initHashes := make([]big.Int, depth+1)
+	initHashes[0] = emptyLeaf
 	for i := 1; i <= depth; i++ {
-		val, _ := poseidon.Hash([]*big.Int{&initHashes[i-1], &initHashes[i-1]})
+		val, _ := hash(&initHashes[i-1], &initHashes[i-1])
 		initHashes[i] = *val
 	}
-	return PoseidonTree{root: &PoseidonEmptyNode{dep: depth, emptyTreeValues: initHashes}}
+	return PoseidonTree{root: &PoseidonEmptyNode{dep: depth, emptyTreeValues: initHashes, hash: hash}}
 }
@@ -0,0 +1,103 @@
+package watch
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"worldcoin/gnark-mbu/prover"
+
+	"github.com/iden3/go-iden3-crypto/keccak256"
+)
+
+// RegisterIdentitiesSignature is the function of the World ID identity
+// manager inserting a batch of identity commitments with its proof.
+const RegisterIdentitiesSignature = "registerIdentities(uint256[8],uint256,uint32,uint256[],uint256)"
+
+// registerIdentitiesSelector is the first 4 bytes of the calldata of
+// registerIdentities transactions.
+var registerIdentitiesSelector = keccak256.Hash([]byte(RegisterIdentitiesSignature))[:4]
+
+// wordSize is the size of the words of ABI encoded arguments.
+const wordSize = 32
+
+// Batch is a batch inserted by a registerIdentities transaction.
+type Batch struct {
+	Proof      [8]*big.Int
+	PreRoot    big.Int
+	StartIndex uint32
+	IdComms    []big.Int
+	PostRoot   big.Int
+}
+
+// Parameters returns the parameters of the batch for the circuit of system,
+// without Merkle proofs, with the input hash the contract computes.
+func (b *Batch) Parameters(system *prover.VerifyingSystem) (*prover.Parameters, error) {
+	params := &prover.Parameters{StartIndex: b.StartIndex, IdComms: b.IdComms}
+	params.PreRoot.Set(&b.PreRoot)
+	params.PostRoot.Set(&b.PostRoot)
+	params.EmptyLeaf.Set(&system.EmptyLeaf)
+	if err := params.ComputeInputHashWith(system.Commitment); err != nil {
+		return nil, err
+	}
+	return params, nil
+}
+
+var errNotRegisterIdentities = errors.New("the calldata does not call registerIdentities")
+
+// isRegisterIdentities reports whether input calls registerIdentities.
+func isRegisterIdentities(input []byte) bool {
+	return len(input) >= len(registerIdentitiesSelector) && string(input[:len(registerIdentitiesSelector)]) == string(registerIdentitiesSelector)
+}
+
+// DecodeRegisterIdentities decodes the ABI encoded calldata of a
+// registerIdentities transaction: the 8 words of the proof, the pre root,
+// the start index, the offset of the identity commitments and the post root,
+// followed by the length-prefixed identity commitments.
+func DecodeRegisterIdentities(input []byte) (*Batch, error) {
+	if !isRegisterIdentities(input) {
+		return nil, errNotRegisterIdentities
+	}
+	args := input[len(registerIdentitiesSelector):]
+	word := func(offset uint64) (*big.Int, error) {
+		if offset+wordSize < offset || offset+wordSize > uint64(len(args)) {
+			return nil, fmt.Errorf("the calldata ends before the word at %d", offset)
+		}
+		return new(big.Int).SetBytes(args[offset : offset+wordSize]), nil
+	}
+	head := make([]*big.Int, 12)
+	for i := range head {
+		var err error
+		if head[i], err = word(uint64(i * wordSize)); err != nil {
+			return nil, err
+		}
+	}
+	batch := &Batch{}
+	copy(batch.Proof[:], head[:8])
+	batch.PreRoot.Set(head[8])
+	if !head[9].IsUint64() || head[9].Uint64() > 1<<32-1 {
+		return nil, fmt.Errorf("the start index %s does not fit in 32 bits", head[9])
+	}
+	batch.StartIndex = uint32(head[9].Uint64())
+	batch.PostRoot.Set(head[11])
+
+	if !head[10].IsUint64() {
+		return nil, fmt.Errorf("invalid offset of the identity commitments: %s", head[10])
+	}
+	offset := head[10].Uint64()
+	length, err := word(offset)
+	if err != nil {
+		return nil, err
+	}
+	if !length.IsUint64() || length.Uint64() > uint64(len(args))/wordSize {
+		return nil, fmt.Errorf("invalid number of identity commitments: %s", length)
+	}
+	batch.IdComms = make([]big.Int, length.Uint64())
+	for i := range batch.IdComms {
+		idComm, err := word(offset + uint64(i+1)*wordSize)
+		if err != nil {
+			return nil, err
+		}
+		batch.IdComms[i].Set(idComm)
+	}
+	return batch, nil
+}
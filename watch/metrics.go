@@ -0,0 +1,30 @@
+package watch
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Outcomes of the registerIdentities transactions checked by a Watcher.
+const (
+	outcomeVerified    = "verified"
+	outcomeMismatch    = "mismatch"
+	outcomeReverted    = "reverted"
+	outcomeSkipped     = "skipped"
+	outcomeUndecodable = "undecodable"
+)
+
+var (
+	batchesCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "prover_watch_batches_total",
+		Help: "Number of registerIdentities transactions checked by watch, by outcome: verified, mismatch, reverted, skipped (another batch size) or undecodable.",
+	}, []string{"outcome"})
+	blockGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "prover_watch_block",
+		Help: "Number of the last block checked by watch.",
+	})
+	rpcErrorsCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "prover_watch_rpc_errors_total",
+		Help: "Number of failed calls to the RPC endpoint watched, which are retried.",
+	})
+)
@@ -0,0 +1,145 @@
+package watch
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// rpcClient calls the JSON-RPC API of an Ethereum node over HTTP.
+type rpcClient struct {
+	url    string
+	client *http.Client
+	id     int
+}
+
+type rpcRequest struct {
+	JSONRPC string        `json:"jsonrpc"`
+	ID      int           `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type rpcResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// call calls method with params and decodes its result into result.
+func (c *rpcClient) call(ctx context.Context, result interface{}, method string, params ...interface{}) error {
+	c.id++
+	body, err := json.Marshal(rpcRequest{JSONRPC: "2.0", ID: c.id, Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := c.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: the node answered %s", method, response.Status)
+	}
+	var decoded rpcResponse
+	if err = json.NewDecoder(response.Body).Decode(&decoded); err != nil {
+		return fmt.Errorf("%s: %w", method, err)
+	}
+	if decoded.Error != nil {
+		return fmt.Errorf("%s: %s (%d)", method, decoded.Error.Message, decoded.Error.Code)
+	}
+	return json.Unmarshal(decoded.Result, result)
+}
+
+// quantity is a number encoded as 0x-prefixed hex.
+type quantity uint64
+
+func (q *quantity) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	n, err := strconv.ParseUint(strings.TrimPrefix(s, "0x"), 16, 64)
+	if err != nil {
+		return fmt.Errorf("invalid quantity %q", s)
+	}
+	*q = quantity(n)
+	return nil
+}
+
+func (q quantity) String() string {
+	return "0x" + strconv.FormatUint(uint64(q), 16)
+}
+
+// data is a byte string encoded as 0x-prefixed hex.
+type data []byte
+
+func (d *data) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	decoded, err := hex.DecodeString(strings.TrimPrefix(s, "0x"))
+	if err != nil {
+		return fmt.Errorf("invalid data: %w", err)
+	}
+	*d = decoded
+	return nil
+}
+
+type rpcTransaction struct {
+	Hash  string `json:"hash"`
+	To    string `json:"to"`
+	Input data   `json:"input"`
+}
+
+type rpcBlock struct {
+	Number       quantity         `json:"number"`
+	Transactions []rpcTransaction `json:"transactions"`
+}
+
+type rpcReceipt struct {
+	Status quantity `json:"status"`
+}
+
+func (c *rpcClient) blockNumber(ctx context.Context) (uint64, error) {
+	var number quantity
+	err := c.call(ctx, &number, "eth_blockNumber")
+	return uint64(number), err
+}
+
+// block returns the block numbered number with its transactions.
+func (c *rpcClient) block(ctx context.Context, number uint64) (*rpcBlock, error) {
+	var block *rpcBlock
+	if err := c.call(ctx, &block, "eth_getBlockByNumber", quantity(number).String(), true); err != nil {
+		return nil, err
+	}
+	if block == nil {
+		return nil, fmt.Errorf("block %d not found", number)
+	}
+	return block, nil
+}
+
+// succeeded reports whether the transaction hash did not revert.
+func (c *rpcClient) succeeded(ctx context.Context, hash string) (bool, error) {
+	var receipt *rpcReceipt
+	if err := c.call(ctx, &receipt, "eth_getTransactionReceipt", hash); err != nil {
+		return false, err
+	}
+	if receipt == nil {
+		return false, fmt.Errorf("receipt of %s not found", hash)
+	}
+	return receipt.Status == 1, nil
+}
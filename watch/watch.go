@@ -0,0 +1,235 @@
+// Package watch audits the proofs a bridge accepts: it tails an Ethereum
+// RPC endpoint for registerIdentities transactions to the identity manager,
+// reconstructs the input hash of every batch from its calldata and verifies
+// its proof with the local verifying key, alerting on any mismatch.
+package watch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+	"worldcoin/gnark-mbu/logging"
+	"worldcoin/gnark-mbu/prover"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Config configures a Watcher.
+type Config struct {
+	// RPC is the URL of the JSON-RPC endpoint of an Ethereum node.
+	RPC string
+	// Contract is the address of the identity manager; transactions to other
+	// addresses are ignored.
+	Contract string
+	// FromBlock is the first block checked, the first confirmed block at
+	// startup if zero.
+	FromBlock uint64
+	// Confirmations is the number of blocks a block is checked behind the
+	// head of the chain, so that reorganized blocks are not reported.
+	Confirmations uint64
+	// PollInterval is the time between polls of the head of the chain once
+	// the watcher caught up with it.
+	PollInterval time.Duration
+	// Webhook, if set, is posted every mismatch as JSON.
+	Webhook string
+	// MetricsAddress, if set, is the address /metrics is served on while
+	// the watcher runs.
+	MetricsAddress string
+}
+
+// Mismatch is a successful registerIdentities transaction whose proof does
+// not verify against the input hash reconstructed from its calldata.
+type Mismatch struct {
+	Transaction string `json:"transaction"`
+	Block       uint64 `json:"block"`
+	StartIndex  uint32 `json:"startIndex"`
+	PreRoot     string `json:"preRoot"`
+	PostRoot    string `json:"postRoot"`
+	InputHash   string `json:"inputHash"`
+	Error       string `json:"error"`
+}
+
+// Watcher verifies the proofs of the registerIdentities transactions of
+// every block, in order. It is not safe for concurrent use.
+type Watcher struct {
+	config   Config
+	system   *prover.VerifyingSystem
+	rpc      *rpcClient
+	client   *http.Client
+	contract string
+	// alert is called with every mismatch.
+	alert func(*Mismatch)
+}
+
+// New returns a Watcher verifying proofs with system, which must be set up
+// for the insertions at consecutive indices registerIdentities takes.
+func New(config Config, system *prover.VerifyingSystem) (*Watcher, error) {
+	if system.Curve != ecc.BN254 {
+		return nil, fmt.Errorf("on-chain proofs are on bn254, the keys are on %s", system.Curve)
+	}
+	if system.Indexed {
+		return nil, fmt.Errorf("registerIdentities inserts at consecutive indices, the keys are indexed")
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = 12 * time.Second
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	w := &Watcher{
+		config:   config,
+		system:   system,
+		rpc:      &rpcClient{url: config.RPC, client: client},
+		client:   client,
+		contract: strings.ToLower(config.Contract),
+	}
+	w.alert = w.report
+	return w, nil
+}
+
+// Run checks the blocks from FromBlock on, then every new confirmed block,
+// until ctx is done. Failed calls to the node are logged and retried.
+func (w *Watcher) Run(ctx context.Context) error {
+	log := logging.Logger().With().Str("contract", w.contract).Logger()
+	if w.config.MetricsAddress != "" {
+		listener, err := net.Listen("tcp", w.config.MetricsAddress)
+		if err != nil {
+			return err
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.Handler())
+		metricsServer := &http.Server{Handler: mux}
+		go metricsServer.Serve(listener)
+		defer metricsServer.Close()
+		log.Info().Str("addr", w.config.MetricsAddress).Msg("metrics server started")
+	}
+	next := w.config.FromBlock
+	for ctx.Err() == nil {
+		head, err := w.rpc.blockNumber(ctx)
+		if err == nil && head >= w.config.Confirmations {
+			confirmed := head - w.config.Confirmations
+			if next == 0 {
+				next = confirmed
+				log.Info().Uint64("block", next).Msg("watching from the last confirmed block")
+			}
+			for ; next <= confirmed && ctx.Err() == nil; next++ {
+				if err = w.checkBlock(ctx, next); err != nil {
+					break
+				}
+				blockGauge.Set(float64(next))
+			}
+		}
+		if err != nil && ctx.Err() == nil {
+			rpcErrorsCounter.Inc()
+			log.Warn().Err(err).Uint64("block", next).Dur("retryIn", w.config.PollInterval).Msg("failed to read the chain")
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(w.config.PollInterval):
+		}
+	}
+	return nil
+}
+
+// checkBlock checks the registerIdentities transactions to the contract in
+// the block numbered number.
+func (w *Watcher) checkBlock(ctx context.Context, number uint64) error {
+	block, err := w.rpc.block(ctx, number)
+	if err != nil {
+		return err
+	}
+	for _, tx := range block.Transactions {
+		if strings.ToLower(tx.To) != w.contract || !isRegisterIdentities(tx.Input) {
+			continue
+		}
+		succeeded, err := w.rpc.succeeded(ctx, tx.Hash)
+		if err != nil {
+			return err
+		}
+		log := logging.Logger().With().Str("transaction", tx.Hash).Uint64("block", number).Logger()
+		if !succeeded {
+			// The contract rejected the batch, whether its proof was
+			// valid or not.
+			batchesCounter.WithLabelValues(outcomeReverted).Inc()
+			log.Info().Msg("skipped reverted registerIdentities transaction")
+			continue
+		}
+		outcome, mismatch := w.check(tx.Input)
+		batchesCounter.WithLabelValues(outcome).Inc()
+		switch outcome {
+		case outcomeMismatch:
+			mismatch.Transaction, mismatch.Block = tx.Hash, number
+			w.alert(mismatch)
+		case outcomeVerified:
+			log.Info().Msg("on-chain proof verified")
+		default:
+			log.Warn().Str("outcome", outcome).Msg("on-chain proof not verified")
+		}
+	}
+	return nil
+}
+
+// check verifies the proof of the registerIdentities calldata input.
+func (w *Watcher) check(input []byte) (string, *Mismatch) {
+	batch, err := DecodeRegisterIdentities(input)
+	if err != nil {
+		return outcomeUndecodable, nil
+	}
+	if len(batch.IdComms) != int(w.system.BatchSize) {
+		// Batches of other sizes are verified by other keys.
+		return outcomeSkipped, nil
+	}
+	mismatch := &Mismatch{
+		StartIndex: batch.StartIndex,
+		PreRoot:    "0x" + batch.PreRoot.Text(16),
+		PostRoot:   "0x" + batch.PostRoot.Text(16),
+	}
+	params, err := batch.Parameters(w.system)
+	if err != nil {
+		mismatch.Error = err.Error()
+		return outcomeMismatch, mismatch
+	}
+	mismatch.InputHash = "0x" + params.InputHash.Text(16)
+	proof, err := prover.ProofFromEVM(batch.Proof)
+	if err == nil {
+		if w.system.PublicPostRoot {
+			err = w.system.VerifyWithPostRoot(params.InputHash, params.PostRoot, proof)
+		} else {
+			err = w.system.Verify(params.InputHash, proof)
+		}
+	}
+	if err != nil {
+		mismatch.Error = err.Error()
+		return outcomeMismatch, mismatch
+	}
+	return outcomeVerified, nil
+}
+
+// report logs a mismatch and posts it to the webhook.
+func (w *Watcher) report(mismatch *Mismatch) {
+	logging.Audit().Error().Str("transaction", mismatch.Transaction).Uint64("block", mismatch.Block).
+		Uint32("startIndex", mismatch.StartIndex).Str("inputHash", mismatch.InputHash).Str("error", mismatch.Error).
+		Msg("on-chain proof does not verify")
+	if w.config.Webhook == "" {
+		return
+	}
+	body, err := json.Marshal(mismatch)
+	if err != nil {
+		logging.Logger().Error().Err(err).Msg("failed to encode the mismatch")
+		return
+	}
+	response, err := w.client.Post(w.config.Webhook, "application/json", bytes.NewReader(body))
+	if err == nil {
+		response.Body.Close()
+		if response.StatusCode/100 != 2 {
+			err = fmt.Errorf("the webhook answered %s", response.Status)
+		}
+	}
+	if err != nil {
+		logging.Logger().Error().Err(err).Str("transaction", mismatch.Transaction).Msg("failed to post the mismatch to the webhook")
+	}
+}
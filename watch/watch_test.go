@@ -0,0 +1,171 @@
+package watch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"worldcoin/gnark-mbu/prover"
+
+	"github.com/consensys/gnark-crypto/ecc"
+)
+
+const testContract = "0x00000000000000000000000000000000000000aa"
+
+// provenBatch sets up a circuit of depth 2 and batch size 2 and proves the
+// insertion of identity commitments 1 and 2 at the start of an empty tree.
+func provenBatch(t *testing.T) (*prover.VerifyingSystem, *Batch) {
+	ps, err := prover.Setup(context.Background(), 2, 2, prover.WithCommitment(prover.CommitmentPoseidon))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := prover.NativeTreeHash(ps.TreeHash, ps.Curve)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := func(left, right *big.Int) *big.Int {
+		n, err := hash(left, right)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	empty, one, two := big.NewInt(0), big.NewInt(1), big.NewInt(2)
+	emptyNode := node(empty, empty)
+	params := &prover.Parameters{IdComms: []big.Int{*one, *two}, Frontier: []big.Int{*empty, *emptyNode}}
+	params.PreRoot.Set(node(emptyNode, emptyNode))
+	params.PostRoot.Set(node(node(one, two), emptyNode))
+	proof, err := ps.Prove(context.Background(), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := proof.MarshalFormat(prover.ProofFormatRaw, prover.NumberFormatHex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch := &Batch{StartIndex: 0, IdComms: params.IdComms}
+	batch.PreRoot.Set(&params.PreRoot)
+	batch.PostRoot.Set(&params.PostRoot)
+	for i := range batch.Proof {
+		batch.Proof[i] = new(big.Int).SetBytes(raw[i*wordSize : (i+1)*wordSize])
+	}
+	return ps.VerifyingSystem(), batch
+}
+
+// encodeRegisterIdentities ABI encodes a registerIdentities call.
+func encodeRegisterIdentities(batch *Batch) []byte {
+	input := append([]byte{}, registerIdentitiesSelector...)
+	word := func(n *big.Int) {
+		input = append(input, n.FillBytes(make([]byte, wordSize))...)
+	}
+	for _, w := range batch.Proof {
+		word(w)
+	}
+	word(&batch.PreRoot)
+	word(big.NewInt(int64(batch.StartIndex)))
+	word(big.NewInt(12 * wordSize))
+	word(&batch.PostRoot)
+	word(big.NewInt(int64(len(batch.IdComms))))
+	for i := range batch.IdComms {
+		word(&batch.IdComms[i])
+	}
+	return input
+}
+
+func TestDecodeRegisterIdentities(t *testing.T) {
+	batch := &Batch{StartIndex: 7, IdComms: []big.Int{*big.NewInt(3), *big.NewInt(4)}}
+	for i := range batch.Proof {
+		batch.Proof[i] = big.NewInt(int64(i + 1))
+	}
+	batch.PreRoot.SetInt64(10)
+	batch.PostRoot.SetInt64(11)
+	input := encodeRegisterIdentities(batch)
+	decoded, err := DecodeRegisterIdentities(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.StartIndex != 7 || decoded.PreRoot.Int64() != 10 || decoded.PostRoot.Int64() != 11 ||
+		len(decoded.IdComms) != 2 || decoded.IdComms[1].Int64() != 4 || decoded.Proof[7].Int64() != 8 {
+		t.Fatalf("unexpected batch %+v", decoded)
+	}
+	if _, err = DecodeRegisterIdentities(input[:len(input)-1]); err == nil {
+		t.Fatal("expected truncated calldata to be rejected")
+	}
+	if _, err = DecodeRegisterIdentities([]byte{1, 2, 3, 4}); err != errNotRegisterIdentities {
+		t.Fatalf("expected other calls to be rejected, got %v", err)
+	}
+}
+
+// fakeNode serves a chain whose block 5 holds txs, all of which succeeded.
+func fakeNode(t *testing.T, txs []rpcTransaction) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request rpcRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Error(err)
+			return
+		}
+		var result interface{}
+		switch request.Method {
+		case "eth_blockNumber":
+			result = "0x5"
+		case "eth_getBlockByNumber":
+			encoded := make([]map[string]string, len(txs))
+			for i, tx := range txs {
+				encoded[i] = map[string]string{"hash": tx.Hash, "to": tx.To, "input": fmt.Sprintf("0x%x", []byte(tx.Input))}
+			}
+			result = map[string]interface{}{"number": request.Params[0], "transactions": encoded}
+		case "eth_getTransactionReceipt":
+			result = map[string]string{"status": "0x1"}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": request.ID, "result": result})
+	}))
+}
+
+func TestWatcher(t *testing.T) {
+	system, batch := provenBatch(t)
+	valid := encodeRegisterIdentities(batch)
+	// The proof does not verify against another post root.
+	batch.PostRoot.Add(&batch.PostRoot, big.NewInt(1))
+	forged := encodeRegisterIdentities(batch)
+	node := fakeNode(t, []rpcTransaction{
+		{Hash: "0x01", To: testContract, Input: valid},
+		{Hash: "0x02", To: strings.ToUpper(testContract), Input: forged},
+		{Hash: "0x03", To: "0x00000000000000000000000000000000000000bb", Input: forged},
+	})
+	defer node.Close()
+	posted := make(chan Mismatch, 3)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var mismatch Mismatch
+		if err := json.Unmarshal(body, &mismatch); err != nil {
+			t.Error(err)
+		}
+		posted <- mismatch
+	}))
+	defer webhook.Close()
+
+	watcher, err := New(Config{RPC: node.URL, Contract: testContract, Webhook: webhook.URL}, system)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = watcher.checkBlock(context.Background(), 5); err != nil {
+		t.Fatal(err)
+	}
+	close(posted)
+	var mismatches []Mismatch
+	for mismatch := range posted {
+		mismatches = append(mismatches, mismatch)
+	}
+	if len(mismatches) != 1 || mismatches[0].Transaction != "0x02" || mismatches[0].Block != 5 || mismatches[0].Error == "" {
+		t.Fatalf("expected the forged transaction to be reported, got %+v", mismatches)
+	}
+
+	if _, err = New(Config{}, &prover.VerifyingSystem{Curve: ecc.BLS12_381}); err == nil {
+		t.Fatal("expected keys on another curve to be rejected")
+	}
+}
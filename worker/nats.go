@@ -0,0 +1,193 @@
+package worker
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// natsQueue takes jobs from a NATS subject as a member of a queue group, so
+// that each job is delivered to one worker, and publishes results to the
+// reply subject of requests or the results subject. It speaks the core NATS
+// text protocol without TLS.
+type natsQueue struct {
+	mu      sync.Mutex
+	conn    net.Conn
+	subject string
+	group   string
+	results string
+	sid     int
+	msgs    chan *Delivery
+	// err is set before msgs is closed, when the connection fails.
+	err error
+}
+
+func openNATS(u *url.URL) (*natsQueue, error) {
+	subject := strings.TrimPrefix(u.Path, "/")
+	if subject == "" {
+		return nil, fmt.Errorf("invalid NATS queue, expected nats://host/<subject>: %s", u)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	conn, err := net.DialTimeout("tcp", host, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	q := &natsQueue{
+		conn:    conn,
+		subject: subject,
+		group:   u.Query().Get("group"),
+		results: u.Query().Get("results"),
+		msgs:    make(chan *Delivery, 1),
+	}
+	if q.group == "" {
+		q.group = "gnark-mbu"
+	}
+	if err = q.connect(u.User); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return q, nil
+}
+
+// connect completes the handshake and starts reading messages.
+func (q *natsQueue) connect(user *url.Userinfo) error {
+	reader := bufio.NewReader(q.conn)
+	q.conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	info, err := reader.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(info, "INFO ") {
+		return fmt.Errorf("nats: unexpected greeting %q", strings.TrimSpace(info))
+	}
+	options := map[string]interface{}{"verbose": false, "pedantic": false, "name": "gnark-mbu-worker", "lang": "go"}
+	if user != nil {
+		options["user"] = user.Username()
+		if password, ok := user.Password(); ok {
+			options["pass"] = password
+		}
+	}
+	connect, err := json.Marshal(options)
+	if err != nil {
+		return err
+	}
+	if err = q.write("CONNECT " + string(connect) + "\r\nPING\r\n"); err != nil {
+		return err
+	}
+	// The server answers the PING once it accepted the connection.
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSpace(line)
+		if line == "PONG" {
+			break
+		}
+		if strings.HasPrefix(line, "-ERR") {
+			return fmt.Errorf("nats: %s", line)
+		}
+	}
+	q.conn.SetReadDeadline(time.Time{})
+	go q.read(reader)
+	return nil
+}
+
+func (q *natsQueue) write(s string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err := io.WriteString(q.conn, s)
+	return err
+}
+
+// read delivers messages to msgs and answers pings until the connection
+// fails.
+func (q *natsQueue) read(reader *bufio.Reader) {
+	defer close(q.msgs)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			q.err = err
+			return
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "PING":
+			if err = q.write("PONG\r\n"); err != nil {
+				q.err = err
+				return
+			}
+		case strings.HasPrefix(line, "MSG "):
+			// MSG <subject> <sid> [reply-to] <size>
+			fields := strings.Fields(line)
+			if len(fields) != 4 && len(fields) != 5 {
+				q.err = fmt.Errorf("nats: malformed message %q", line)
+				return
+			}
+			size, err := strconv.Atoi(fields[len(fields)-1])
+			if err != nil {
+				q.err = fmt.Errorf("nats: malformed message %q", line)
+				return
+			}
+			payload := make([]byte, size+2)
+			if _, err = io.ReadFull(reader, payload); err != nil {
+				q.err = err
+				return
+			}
+			delivery := &Delivery{Body: payload[:size]}
+			if len(fields) == 5 {
+				delivery.ReplyTo = fields[3]
+			}
+			q.msgs <- delivery
+		case strings.HasPrefix(line, "-ERR"):
+			q.err = fmt.Errorf("nats: %s", line)
+			return
+		}
+	}
+}
+
+// Pop subscribes for a single message, so that jobs are not delivered to
+// this worker while it is proving.
+func (q *natsQueue) Pop(ctx context.Context) (*Delivery, error) {
+	q.sid++
+	sid := strconv.Itoa(q.sid)
+	if err := q.write(fmt.Sprintf("SUB %s %s %s\r\nUNSUB %s 1\r\n", q.subject, q.group, sid, sid)); err != nil {
+		return nil, err
+	}
+	select {
+	case delivery, ok := <-q.msgs:
+		if !ok {
+			return nil, q.err
+		}
+		return delivery, nil
+	case <-ctx.Done():
+		q.write(fmt.Sprintf("UNSUB %s\r\n", sid))
+		return nil, ctx.Err()
+	}
+}
+
+func (q *natsQueue) Push(_ context.Context, replyTo string, body []byte) error {
+	if replyTo == "" {
+		replyTo = q.results
+	}
+	if replyTo == "" {
+		return errNoResultsDestination
+	}
+	return q.write(fmt.Sprintf("PUB %s %d\r\n%s\r\n", replyTo, len(body), body))
+}
+
+func (q *natsQueue) Close() error {
+	return q.conn.Close()
+}
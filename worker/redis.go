@@ -0,0 +1,67 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"worldcoin/gnark-mbu/redis"
+)
+
+// redisPollInterval bounds how long a BLPOP blocks, and hence how long Pop
+// takes to notice that its context is done.
+const redisPollInterval = time.Second
+
+// redisQueue takes jobs from a Redis list with BLPOP and RPUSHes results to
+// another one.
+type redisQueue struct {
+	client  *redis.Client
+	jobs    string
+	results string
+}
+
+func openRedis(u *url.URL) (*redisQueue, error) {
+	jobs := strings.TrimPrefix(u.Path, "/")
+	if jobs == "" {
+		return nil, fmt.Errorf("invalid Redis queue, expected redis://host/<jobs list>: %s", u)
+	}
+	client, err := redis.Dial(u)
+	if err != nil {
+		return nil, err
+	}
+	client.Timeout = redisPollInterval + 10*time.Second
+	return &redisQueue{client: client, jobs: jobs, results: u.Query().Get("results")}, nil
+}
+
+func (q *redisQueue) Pop(ctx context.Context) (*Delivery, error) {
+	timeout := strconv.Itoa(int(redisPollInterval / time.Second))
+	for ctx.Err() == nil {
+		reply, err := q.client.Do("BLPOP", q.jobs, timeout)
+		if err != nil {
+			return nil, err
+		}
+		// BLPOP replies with the list and the element, or nil on timeout.
+		if popped, ok := reply.([]interface{}); ok && len(popped) == 2 {
+			body, _ := popped[1].(string)
+			return &Delivery{Body: []byte(body)}, nil
+		}
+	}
+	return nil, ctx.Err()
+}
+
+func (q *redisQueue) Push(_ context.Context, replyTo string, body []byte) error {
+	if replyTo == "" {
+		replyTo = q.results
+	}
+	if replyTo == "" {
+		return errNoResultsDestination
+	}
+	_, err := q.client.Do("RPUSH", replyTo, string(body))
+	return err
+}
+
+func (q *redisQueue) Close() error {
+	return q.client.Close()
+}
@@ -0,0 +1,123 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"worldcoin/gnark-mbu/aws"
+)
+
+// sqsWaitSeconds is how long a ReceiveMessage long-polls for a job, the
+// maximum SQS allows.
+const sqsWaitSeconds = 20
+
+// sqsQueue takes jobs from an SQS queue one message at a time and sends
+// results to another queue, signing the requests with the aws package. A job
+// is deleted once its result is sent, so that the job of a worker that dies
+// while proving is delivered again when its visibility timeout expires.
+type sqsQueue struct {
+	client *aws.JSONClient
+	jobs   string
+	// results is the URL of the results queue, empty if there is none.
+	results string
+	// visibilityTimeout overrides the visibility timeout of the queue, in
+	// seconds, if not zero. It must exceed the time a proof takes.
+	visibilityTimeout int
+}
+
+// openSQS opens sqs://<queue URL without scheme>, such as
+// sqs://sqs.us-east-1.amazonaws.com/123456789012/jobs. The region is the one
+// of the host, or of the environment for other hosts. The results queue and
+// the replyTo of jobs are queue URLs, or names of queues of the same account.
+func openSQS(u *url.URL) (*sqsQueue, error) {
+	if u.Host == "" || strings.Count(strings.Trim(u.Path, "/"), "/") != 1 {
+		return nil, fmt.Errorf("invalid SQS queue, expected sqs://<host>/<account>/<queue>: %s", u)
+	}
+	client, err := aws.NewJSONClient("sqs", "AmazonSQS", sqsRegion(u.Hostname()))
+	if err != nil {
+		return nil, err
+	}
+	client.JSONVersion = "1.0"
+	q := &sqsQueue{client: client, jobs: "https://" + u.Host + "/" + strings.Trim(u.Path, "/")}
+	if results := u.Query().Get("results"); results != "" {
+		q.results = q.queueURL(results)
+	}
+	if visibility := u.Query().Get("visibilityTimeout"); visibility != "" {
+		if q.visibilityTimeout, err = strconv.Atoi(visibility); err != nil || q.visibilityTimeout <= 0 {
+			return nil, fmt.Errorf("invalid SQS visibility timeout: %s", visibility)
+		}
+	}
+	return q, nil
+}
+
+// sqsRegion returns the region of an sqs.<region>.amazonaws.com host, empty
+// for other hosts.
+func sqsRegion(host string) string {
+	region, ok := strings.CutPrefix(host, "sqs.")
+	if !ok {
+		return ""
+	}
+	region, ok = strings.CutSuffix(region, ".amazonaws.com")
+	if !ok {
+		return ""
+	}
+	return region
+}
+
+// queueURL returns the URL of a queue given by URL or by name.
+func (q *sqsQueue) queueURL(queue string) string {
+	if strings.Contains(queue, "://") {
+		return queue
+	}
+	return q.jobs[:strings.LastIndex(q.jobs, "/")+1] + queue
+}
+
+func (q *sqsQueue) Pop(ctx context.Context) (*Delivery, error) {
+	request := map[string]interface{}{
+		"QueueUrl":            q.jobs,
+		"MaxNumberOfMessages": 1,
+		"WaitTimeSeconds":     sqsWaitSeconds,
+	}
+	if q.visibilityTimeout > 0 {
+		request["VisibilityTimeout"] = q.visibilityTimeout
+	}
+	for ctx.Err() == nil {
+		var response struct {
+			Messages []struct {
+				Body          string
+				ReceiptHandle string
+			}
+		}
+		if err := q.client.Call(ctx, "ReceiveMessage", request, &response); err != nil {
+			return nil, err
+		}
+		if len(response.Messages) == 0 {
+			continue
+		}
+		message := response.Messages[0]
+		return &Delivery{
+			Body: []byte(message.Body),
+			Ack: func(ctx context.Context) error {
+				return q.client.Call(ctx, "DeleteMessage", map[string]string{"QueueUrl": q.jobs, "ReceiptHandle": message.ReceiptHandle}, &struct{}{})
+			},
+		}, nil
+	}
+	return nil, ctx.Err()
+}
+
+func (q *sqsQueue) Push(ctx context.Context, replyTo string, body []byte) error {
+	destination := q.results
+	if replyTo != "" {
+		destination = q.queueURL(replyTo)
+	}
+	if destination == "" {
+		return errNoResultsDestination
+	}
+	return q.client.Call(ctx, "SendMessage", map[string]string{"QueueUrl": destination, "MessageBody": string(body)}, &struct{}{})
+}
+
+func (q *sqsQueue) Close() error {
+	return nil
+}
@@ -0,0 +1,180 @@
+// Package worker proves witnesses built by the /witness endpoint, taking
+// them as jobs from a message queue and sending the proofs back, so that
+// proving scales separately from the HTTP front end.
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"runtime"
+	"time"
+	"worldcoin/gnark-mbu/hardware"
+	"worldcoin/gnark-mbu/logging"
+	"worldcoin/gnark-mbu/prover"
+)
+
+// Job is a witness to prove. ReplyTo optionally names the list or subject
+// its result is sent to instead of the queue's results destination.
+type Job struct {
+	ID      string          `json:"id"`
+	Witness *prover.Witness `json:"witness"`
+	ReplyTo string          `json:"replyTo,omitempty"`
+}
+
+// Result is the outcome of a job, either a proof or an error message.
+type Result struct {
+	ID    string         `json:"id"`
+	Proof json.Marshaler `json:"proof,omitempty"`
+	Error string         `json:"error,omitempty"`
+	// Elapsed is the proving time in milliseconds.
+	Elapsed int64 `json:"elapsed,omitempty"`
+}
+
+// errNoResultsDestination is returned by Push if neither the job nor the
+// queue name a destination for its result.
+var errNoResultsDestination = errors.New("the job has no replyTo and the queue no results destination")
+
+// Delivery is a message taken from a queue. ReplyTo is set by transports
+// that carry a reply destination themselves, such as NATS requests.
+type Delivery struct {
+	Body    []byte
+	ReplyTo string
+	// Ack, if not nil, acknowledges the message once its result is sent,
+	// for transports that deliver unacknowledged messages again, such as
+	// SQS.
+	Ack func(ctx context.Context) error
+}
+
+// Queue is the transport jobs are taken from and results sent to.
+type Queue interface {
+	// Pop blocks until a job is available or ctx is done, taking one job
+	// at a time so that idle workers get the remaining ones.
+	Pop(ctx context.Context) (*Delivery, error)
+	// Push sends a result to replyTo, or to the queue's results
+	// destination if it is empty.
+	Push(ctx context.Context, replyTo string, body []byte) error
+	Close() error
+}
+
+// Open connects to the queue at location, either
+// redis://[user:password@]host[:port]/<jobs list>?results=<list>,
+// nats://[user:password@]host[:port]/<subject>?group=<queue group>&results=<subject> or
+// sqs://<host>/<account>/<queue>?results=<queue>&visibilityTimeout=<seconds>.
+func Open(location string) (Queue, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "redis":
+		return openRedis(u)
+	case "nats":
+		return openNATS(u)
+	case "sqs":
+		return openSQS(u)
+	default:
+		return nil, fmt.Errorf("unsupported queue scheme: %s", u.Scheme)
+	}
+}
+
+// pinThread pins the calling thread to CPUs, replaced in tests.
+var pinThread = hardware.PinThread
+
+// Worker proves the jobs of a queue one at a time.
+type Worker struct {
+	ProvingSystem *prover.ProvingSystem
+	Queue         Queue
+	Encoding      prover.ProofEncoding
+	Numbers       prover.NumberFormat
+	// CPUs, if not nil, are the partition of the worker: the goroutine
+	// proving a job is locked to its thread, pinned to them for the proof,
+	// so that the workers of a process prove on disjoint CPUs.
+	CPUs []int
+}
+
+// Run proves jobs until ctx is done, returning nil, or the queue fails.
+func (w *Worker) Run(ctx context.Context) error {
+	for {
+		delivery, err := w.Queue.Pop(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		job, result := w.process(delivery.Body)
+		replyTo := delivery.ReplyTo
+		if replyTo == "" && job != nil {
+			replyTo = job.ReplyTo
+		}
+		body, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		err = w.Queue.Push(ctx, replyTo, body)
+		if errors.Is(err, errNoResultsDestination) {
+			logging.Logger().Warn().Str("id", result.ID).Msg("dropping result without destination")
+			err = nil
+		}
+		if err == nil && delivery.Ack != nil {
+			err = delivery.Ack(ctx)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// process proves the job in body. Malformed jobs are answered with an error
+// result, which is only delivered if the transport knows where to.
+func (w *Worker) process(body []byte) (*Job, *Result) {
+	var job Job
+	if err := json.Unmarshal(body, &job); err != nil {
+		logging.Logger().Warn().Err(err).Msg("malformed job")
+		return nil, &Result{Error: fmt.Sprintf("malformed job: %v", err)}
+	}
+	if job.Witness == nil {
+		return &job, &Result{ID: job.ID, Error: "malformed job: missing witness"}
+	}
+	logging.Logger().Info().Str("id", job.ID).Msg("proving job")
+	start := time.Now()
+	proof, err := w.prove(job.Witness)
+	elapsed := time.Since(start)
+	if err != nil {
+		logging.Logger().Warn().Str("id", job.ID).Err(err).Msg("job failed")
+		return &job, &Result{ID: job.ID, Error: err.Error()}
+	}
+	logging.Logger().Info().Str("id", job.ID).Dur("took", elapsed).Msg("job proven")
+	return &job, &Result{ID: job.ID, Proof: proof.Encoded(w.Encoding, w.Numbers), Elapsed: elapsed.Milliseconds()}
+}
+
+// prove proves witness, on the CPUs of the worker if it has a partition.
+// The helper goroutines of the prover run on threads Go starts for them,
+// which do not inherit the affinity of the pinned thread.
+func (w *Worker) prove(witness *prover.Witness) (*prover.Proof, error) {
+	if w.CPUs == nil {
+		return w.ProvingSystem.ProveWitness(witness)
+	}
+	runtime.LockOSThread()
+	restore, err := pinThread(w.CPUs)
+	if err != nil {
+		runtime.UnlockOSThread()
+		return nil, err
+	}
+	defer func() {
+		// A thread whose affinity is not restored stays locked, so that it
+		// is not reused for the goroutines of other workers.
+		if err := restore(); err != nil {
+			logging.Logger().Warn().Err(err).Msg("restoring the CPU affinity of the worker thread")
+			return
+		}
+		runtime.UnlockOSThread()
+	}()
+	return w.ProvingSystem.ProveWitness(witness)
+}
@@ -0,0 +1,377 @@
+package worker
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"worldcoin/gnark-mbu/prover"
+	"worldcoin/gnark-mbu/redis"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+)
+
+type squareCircuit struct {
+	X frontend.Variable
+	Y frontend.Variable `gnark:",public"`
+}
+
+func (circuit *squareCircuit) Define(api frontend.API) error {
+	api.AssertIsEqual(api.Mul(circuit.X, circuit.X), circuit.Y)
+	return nil
+}
+
+// squareJob sets up keys for a tiny circuit and returns them with a job
+// proving that x squared is y.
+func squareJob(t *testing.T, x, y int) (*prover.ProvingSystem, []byte) {
+	cs, err := frontend.Compile(ecc.BN254.ScalarField(), r1cs.NewBuilder, &squareCircuit{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, vk, err := groth16.Setup(cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	full, err := frontend.NewWitness(&squareCircuit{X: x, Y: y}, ecc.BN254.ScalarField())
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := full.Public()
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, err := json.Marshal(&Job{ID: "job-1", Witness: &prover.Witness{Curve: ecc.BN254, Full: full, Public: public}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &prover.ProvingSystem{Curve: ecc.BN254, ProvingKey: pk, VerifyingKey: vk, ConstraintSystem: cs}, job
+}
+
+// checkResult decodes a result and verifies its proof.
+func checkResult(t *testing.T, ps *prover.ProvingSystem, body []byte, y int) {
+	var result struct {
+		ID    string       `json:"id"`
+		Proof prover.Proof `json:"proof"`
+		Error string       `json:"error"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.ID != "job-1" || result.Error != "" {
+		t.Fatalf("unexpected result %s", body)
+	}
+	public, err := frontend.NewWitness(&squareCircuit{Y: y}, ecc.BN254.ScalarField(), frontend.PublicOnly())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = groth16.Verify(result.Proof.Proof, ps.VerifyingKey, public); err != nil {
+		t.Fatalf("expected the proof to verify, got %v", err)
+	}
+}
+
+func listen(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	return listener
+}
+
+// fakeRedis serves BLPOP on a list holding job and sends RPUSHed values to
+// pushed.
+func fakeRedis(listener net.Listener, job []byte, pushed chan<- []string) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	jobs := [][]byte{job}
+	for {
+		command, err := redis.ReadReply(reader)
+		if err != nil {
+			return
+		}
+		args := command.([]interface{})
+		switch args[0] {
+		case "BLPOP":
+			if len(jobs) == 0 {
+				io.WriteString(conn, "*-1\r\n")
+				continue
+			}
+			fmt.Fprintf(conn, "*2\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(args[1].(string)), args[1], len(jobs[0]), jobs[0])
+			jobs = jobs[1:]
+		case "RPUSH":
+			pushed <- []string{args[1].(string), args[2].(string)}
+			io.WriteString(conn, ":1\r\n")
+		default:
+			fmt.Fprintf(conn, "-ERR unknown command '%s'\r\n", args[0])
+		}
+	}
+}
+
+func TestRedisWorker(t *testing.T) {
+	ps, job := squareJob(t, 3, 9)
+	listener := listen(t)
+	pushed := make(chan []string, 1)
+	go fakeRedis(listener, job, pushed)
+
+	queue, err := Open("redis://" + listener.Addr().String() + "/jobs?results=results")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer queue.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- (&Worker{ProvingSystem: ps, Queue: queue}).Run(ctx)
+	}()
+
+	select {
+	case push := <-pushed:
+		if push[0] != "results" {
+			t.Fatalf("expected the result in the results list, got %s", push[0])
+		}
+		checkResult(t, ps, []byte(push[1]), 9)
+	case <-time.After(30 * time.Second):
+		t.Fatal("no result pushed")
+	}
+	cancel()
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNATSWorker(t *testing.T) {
+	ps, job := squareJob(t, 3, 9)
+	listener := listen(t)
+	published := make(chan []string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.WriteString(conn, "INFO {\"max_payload\":1048576}\r\n")
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			fields := strings.Fields(line)
+			switch fields[0] {
+			case "PING":
+				io.WriteString(conn, "PONG\r\n")
+			case "SUB":
+				if fields[1] != "jobs" || fields[2] != "provers" {
+					t.Errorf("unexpected subscription %q", line)
+				}
+				if fields[3] == "1" {
+					fmt.Fprintf(conn, "MSG jobs %s _INBOX.1 %d\r\n%s\r\n", fields[3], len(job), job)
+				}
+			case "PUB":
+				size, _ := strconv.Atoi(fields[2])
+				payload := make([]byte, size+2)
+				io.ReadFull(reader, payload)
+				published <- []string{fields[1], string(payload[:size])}
+			}
+		}
+	}()
+
+	queue, err := Open("nats://" + listener.Addr().String() + "/jobs?group=provers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer queue.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- (&Worker{ProvingSystem: ps, Queue: queue, Encoding: prover.ProofEncodingCompressed}).Run(ctx)
+	}()
+
+	select {
+	case publish := <-published:
+		if publish[0] != "_INBOX.1" {
+			t.Fatalf("expected the result on the reply subject, got %s", publish[0])
+		}
+		checkResult(t, ps, []byte(publish[1]), 9)
+	case <-time.After(30 * time.Second):
+		t.Fatal("no result published")
+	}
+	cancel()
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSQSWorker(t *testing.T) {
+	ps, job := squareJob(t, 3, 9)
+	sent := make(chan map[string]string, 1)
+	deleted := make(chan string, 1)
+	var received atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/x-amz-json-1.0" || !strings.Contains(r.Header.Get("Authorization"), "/us-east-1/sqs/") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var request map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&request)
+		switch r.Header.Get("X-Amz-Target") {
+		case "AmazonSQS.ReceiveMessage":
+			if request["QueueUrl"] != "https://sqs.us-east-1.amazonaws.com/123456789012/jobs" || received.Swap(true) {
+				time.Sleep(10 * time.Millisecond)
+				w.Write([]byte(`{}`))
+				return
+			}
+			response, _ := json.Marshal(map[string]interface{}{"Messages": []map[string]string{{"Body": string(job), "ReceiptHandle": "receipt-1"}}})
+			w.Write(response)
+		case "AmazonSQS.SendMessage":
+			sent <- map[string]string{"queue": request["QueueUrl"].(string), "body": request["MessageBody"].(string)}
+			w.Write([]byte(`{"MessageId": "result-1"}`))
+		case "AmazonSQS.DeleteMessage":
+			deleted <- request["ReceiptHandle"].(string)
+		}
+	}))
+	defer server.Close()
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_ENDPOINT_URL_SQS", server.URL)
+
+	queue, err := Open("sqs://sqs.us-east-1.amazonaws.com/123456789012/jobs?results=results")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer queue.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- (&Worker{ProvingSystem: ps, Queue: queue}).Run(ctx)
+	}()
+
+	select {
+	case message := <-sent:
+		if message["queue"] != "https://sqs.us-east-1.amazonaws.com/123456789012/results" {
+			t.Fatalf("expected the result in the results queue, got %s", message["queue"])
+		}
+		checkResult(t, ps, []byte(message["body"]), 9)
+	case <-time.After(30 * time.Second):
+		t.Fatal("no result sent")
+	}
+	select {
+	case receipt := <-deleted:
+		if receipt != "receipt-1" {
+			t.Fatalf("expected the job to be deleted, got %s", receipt)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the job was not deleted once its result was sent")
+	}
+	cancel()
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// channelQueue is a queue of jobs held in memory.
+type channelQueue struct {
+	jobs    chan []byte
+	results chan []byte
+}
+
+func (q *channelQueue) Pop(ctx context.Context) (*Delivery, error) {
+	select {
+	case job := <-q.jobs:
+		return &Delivery{Body: job}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (q *channelQueue) Push(ctx context.Context, replyTo string, body []byte) error {
+	q.results <- body
+	return nil
+}
+
+func (q *channelQueue) Close() error {
+	return nil
+}
+
+func TestConcurrentWorkersPinDisjointCPUs(t *testing.T) {
+	ps, job := squareJob(t, 3, 9)
+	partitions := [][]int{{0, 1}, {2, 3}}
+	// Both proofs are pinned before either runs, and no CPU is pinned twice
+	// at a time.
+	var mutex sync.Mutex
+	active := map[int]bool{}
+	var pinned [][]int
+	both := make(chan struct{})
+	defer func(pin func([]int) (func() error, error)) { pinThread = pin }(pinThread)
+	pinThread = func(cpus []int) (func() error, error) {
+		mutex.Lock()
+		for _, cpu := range cpus {
+			if active[cpu] {
+				mutex.Unlock()
+				return nil, fmt.Errorf("CPU %d is pinned by two proofs", cpu)
+			}
+			active[cpu] = true
+		}
+		pinned = append(pinned, cpus)
+		if len(pinned) == len(partitions) {
+			close(both)
+		}
+		mutex.Unlock()
+		select {
+		case <-both:
+		case <-time.After(10 * time.Second):
+			return nil, fmt.Errorf("the proofs did not run concurrently")
+		}
+		return func() error {
+			mutex.Lock()
+			defer mutex.Unlock()
+			for _, cpu := range cpus {
+				delete(active, cpu)
+			}
+			return nil
+		}, nil
+	}
+
+	queue := &channelQueue{jobs: make(chan []byte, 2), results: make(chan []byte, 2)}
+	queue.jobs <- job
+	queue.jobs <- job
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for _, cpus := range partitions {
+		w := &Worker{ProvingSystem: ps, Queue: queue, Encoding: prover.ProofEncodingDefault, CPUs: cpus}
+		go w.Run(ctx)
+	}
+	for range partitions {
+		select {
+		case body := <-queue.results:
+			checkResult(t, ps, body, 9)
+		case <-time.After(20 * time.Second):
+			t.Fatal("expected both jobs to be proven")
+		}
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(active) != 0 {
+		t.Errorf("expected the affinity of the threads to be restored, CPUs %v still pinned", active)
+	}
+	if fmt.Sprint(pinned) != "[[0 1] [2 3]]" && fmt.Sprint(pinned) != "[[2 3] [0 1]]" {
+		t.Errorf("expected the proofs to be pinned to the partitions of their workers, got %v", pinned)
+	}
+}